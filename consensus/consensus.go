@@ -17,6 +17,9 @@ limitations under the License.
 package consensus
 
 import (
+	"io"
+
+	"github.com/hyperledger/fabric/core/peer/statetransfer"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -28,6 +31,188 @@ type Consenter interface {
 	StateUpdating(tag uint64, id []byte)                    // Called when SkipTo causes state transfer to start serial with StateUpdated
 }
 
+// Closer is implemented by consenters which hold threads or timers, which
+// must be released when the consenter is replaced by another one
+type Closer interface {
+	Close() // Stops the consenter, it must not receive messages afterwards
+}
+
+// StateTransferObserver is implemented by consenters which follow the
+// progress of a state transfer started by SkipTo, between StateUpdating and
+// StateUpdated. It is called from the state transfer threads and must not block
+type StateTransferObserver interface {
+	StateUpdateProgress(tag uint64, status statetransfer.Status)
+}
+
+// Querier is implemented by consenters which answer read-only queries from
+// matching results of the validators, without ordering the queries
+type Querier interface {
+	Query(tx *pb.Transaction) ([]byte, error) // Blocks until enough validators returned the same result
+}
+
+// Throttler is implemented by consenters which signal backpressure, new
+// client transactions should be rejected or delayed while they are overloaded
+type Throttler interface {
+	Overloaded() bool // Whether the consenter has more outstanding requests than it accepts
+}
+
+// Auditor is implemented by consenters which keep an audit trail of the
+// sequence numbers they executed
+type Auditor interface {
+	AuditTrail(epoch, from, to uint64) ([][]byte, error) // Serialized, signed records of the executed sequence numbers from..to of epoch
+}
+
+// Rekeyer is implemented by consenters which authenticate messages with
+// session keys, which an operator may want to replace ahead of schedule
+type Rekeyer interface {
+	RotateSessionKeys() error // Starts a new session key epoch, keys in use are still accepted for messages in flight
+}
+
+// Profiler is implemented by consenters which can be profiled while they
+// run, so that a stalled validator can be diagnosed without restarting it
+type Profiler interface {
+	StartCPUProfile(w io.Writer) error   // Profiles the CPU of the process to w until StopCPUProfile, an error if a profile is running
+	StopCPUProfile()                     // Stops the CPU profile once its samples are written
+	SetBlockProfileRate(rate int)        // Samples one blocking event per rate nanoseconds spent blocked, 0 disables block profiling
+	WriteBlockProfile(w io.Writer) error // Writes the blocking events sampled so far
+	DumpStacks(w io.Writer) error        // Writes the stacks of the goroutines delivering events to the consenter and executing its transactions
+}
+
+// Inspector is implemented by consenters which expose a snapshot of their
+// internal state, e.g. to the admin services of the peer
+type Inspector interface {
+	Inspect() (*ReplicaState, error) // Snapshot of the state of the replica, taken between two events
+}
+
+// ViewChanger is implemented by consenters whose replicas can be asked to
+// abandon the current view, e.g. to take its primary down for maintenance
+type ViewChanger interface {
+	RequestViewChange() error // Votes to move to the next view, which is installed once f+1 replicas voted for it, an error if a view change is in progress
+}
+
+// EpochResetter is implemented by consenters which assign sequence numbers in
+// epochs, whose operators may want to restart the numbering
+type EpochResetter interface {
+	ResetEpoch() error // Orders a request ending the epoch at the next checkpoint, the numbering restarts at 0 once that checkpoint is stable
+}
+
+// StateDumper is implemented by consenters which can write out their soft
+// state, for the analysis of a wedged replica after the fact
+type StateDumper interface {
+	DumpState(w io.Writer) error // Writes the soft state of the replica as JSON, taken between two events
+}
+
+// ReplicaState is a snapshot of the state of a replica of a BFT consenter
+type ReplicaState struct {
+	ReplicaID           uint64
+	View                uint64
+	Epoch               uint64 // epoch of the sequence numbers below, they restart at 0 in every epoch
+	ActiveView          bool   // false while a view change is in progress
+	Primary             uint64 // primary of View
+	SeqNo               uint64 // last sequence number the replica assigned as primary, or found assigned in the new view
+	LastExec            uint64 // last executed sequence number
+	LowWatermark        uint64 // h, the sequence number of the last stable checkpoint
+	LogSize             uint64 // L, sequence numbers above the low watermark which may be assigned
+	CheckpointPeriod    uint64 // K, sequence numbers between two checkpoints
+	OutstandingRequests int    // requests received but not yet committed
+	StateTransfer       bool   // whether the replica is transferring state to catch up
+
+	ViewChanges []ViewChangeVote          // view changes received for views the replica has not installed
+	Checkpoints map[uint64]string         // checkpoints taken by the replica, by sequence number
+	Votes       map[uint64][]CheckpointID // checkpoints received from the replicas, by sequence number
+}
+
+// ViewChangeVote is a view change a replica sent
+type ViewChangeVote struct {
+	View      uint64
+	ReplicaID uint64
+}
+
+// CheckpointID is a checkpoint a replica sent
+type CheckpointID struct {
+	ReplicaID uint64
+	ID        string // state the replica checkpointed
+}
+
+// StatusQuerier is implemented by consenters which report how far the
+// transactions submitted to them progressed, so that clients can wait for
+// their execution without subscribing to events
+type StatusQuerier interface {
+	RequestStatus(uuid string) (*RequestStatus, error) // Status of the transaction with the uuid, taken between two events
+}
+
+// RequestStatus is the progress of a transaction through a replica
+type RequestStatus struct {
+	Phase RequestPhase
+	SeqNo uint64 // sequence number the transaction was assigned, once it was pre-prepared
+}
+
+// RequestPhase is a phase a transaction passes through on a replica
+type RequestPhase int
+
+// The phases of a transaction, in the order it passes through them
+const (
+	RequestUnknown     RequestPhase = iota // never received, or forgotten since it executed, the ledger knows whether it did
+	RequestQueued                          // received, waiting for a sequence number
+	RequestPrePrepared                     // assigned a sequence number by the primary
+	RequestPrepared                        // a quorum agreed on its sequence number in the current view
+	RequestCommitted                       // a quorum committed to its sequence number, it executes once its predecessors did
+	RequestExecuted                        // executed by the replica
+)
+
+var requestPhaseNames = []string{"unknown", "queued", "pre-prepared", "prepared", "committed", "executed"}
+
+func (p RequestPhase) String() string {
+	if p < 0 || int(p) >= len(requestPhaseNames) {
+		return "invalid"
+	}
+	return requestPhaseNames[p]
+}
+
+// ExecutionReceiver is implemented by stacks which want proof that the
+// transactions they submitted were ordered and executed. It is called from
+// the consensus thread and must not block
+type ExecutionReceiver interface {
+	Executed(tx []byte, certificate []byte) // certificate is the serialized proof that tx was executed
+}
+
+// Admitter is implemented by stacks which restrict which clients may submit
+// transactions. It is called before a transaction consumes ordering
+// resources, from the consensus thread, and must not block
+type Admitter interface {
+	Admit(tx *pb.Transaction) (identity string, err error) // identity the transaction is accounted to, err if it must be rejected
+}
+
+// PayloadOpener is implemented by stacks which accept transactions sealed to
+// the validators, whose contents stay encrypted while they are relayed and
+// ordered. It is called from the execution thread
+type PayloadOpener interface {
+	OpenPayload(sealed []byte) ([]byte, error) // the serialized transaction sealed in the payload of a CHAIN_SEALED transaction
+}
+
+// EvidenceReporter is implemented by stacks which want to be notified when
+// the consenter detects a provable fault of another validator, for instance
+// to alert operators. It is called from the consensus thread and must not block
+type EvidenceReporter interface {
+	ReportEvidence(accused *pb.PeerID, fault string, evidence []byte) // evidence is the serialized, signed evidence bundle
+}
+
+// ThrottleObserver is implemented by stacks which want to be notified when
+// the consenter starts or stops rejecting the requests of a client for
+// exceeding its submission rate. It is called from the consensus thread and
+// must not block
+type ThrottleObserver interface {
+	Throttled(identity string, throttled bool) // identity as accounted by the Admitter, throttled is false once throttling ends
+}
+
+// ExpiryObserver is implemented by stacks which want to be notified when the
+// consenter drops a transaction whose expiry passed before it was ordered, so
+// that its client can stop waiting for it. It is called from the consensus
+// thread and must not block
+type ExpiryObserver interface {
+	Expired(tx *pb.Transaction)
+}
+
 // Inquirer is used to retrieve info about the validating network
 type Inquirer interface {
 	GetNetworkInfo() (self *pb.PeerEndpoint, network []*pb.PeerEndpoint, err error)
@@ -69,6 +254,11 @@ type Executor interface {
 	PreviewCommitTxBatch(id interface{}, metadata []byte) ([]byte, error)
 }
 
+// ReadOnlyExecutor is used to execute queries against the committed state, without modifying it
+type ReadOnlyExecutor interface {
+	QueryTx(tx *pb.Transaction) ([]byte, error)
+}
+
 // LedgerManager is used to manipulate the state of the ledger
 type LedgerManager interface {
 	SkipTo(tag uint64, id []byte, peers []*pb.PeerID) // SkipTo tells state transfer to bring the ledger to a particular state, it should generally be preceeded/proceeded by Invalidate/Validate
@@ -84,11 +274,19 @@ type StatePersistor interface {
 	DelState(key string)
 }
 
+// StateBatchPersistor is implemented by stacks which can store and delete
+// several keys in a single atomic write, so that a crash cannot leave only
+// some of them persisted, and the cost of syncing the write is paid once
+type StateBatchPersistor interface {
+	StoreStateBatch(store map[string][]byte, del []string) error
+}
+
 // Stack is the set of stack-facing methods available to the consensus plugin
 type Stack interface {
 	NetworkStack
 	SecurityUtils
 	Executor
+	ReadOnlyExecutor
 	LedgerManager
 	ReadOnlyLedger
 	StatePersistor
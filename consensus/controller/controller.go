@@ -17,14 +17,18 @@ limitations under the License.
 package controller
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
 
 	"github.com/hyperledger/fabric/consensus"
-	"github.com/hyperledger/fabric/consensus/noops"
-	"github.com/hyperledger/fabric/consensus/obcpbft"
+	// the plugins register themselves with the consensus package
+	_ "github.com/hyperledger/fabric/consensus/noops"
+	_ "github.com/hyperledger/fabric/consensus/obcpbft"
+	_ "github.com/hyperledger/fabric/consensus/orderer"
+	_ "github.com/hyperledger/fabric/consensus/poa"
 )
 
 var logger *logging.Logger // package-level logger
@@ -34,15 +38,77 @@ func init() {
 	logger = logging.MustGetLogger("consensus/controller")
 }
 
-// NewConsenter constructs a Consenter object if not already present
+// NewConsenter constructs a Consenter object if not already present. The
+// consenter can replace its plugin at runtime, see Swappable. It panics if
+// the plugin lacks a capability the deployment requires
 func NewConsenter(stack consensus.Stack) consensus.Consenter {
 
-	plugin := strings.ToLower(viper.GetString("peer.validator.consensus.plugin"))
-	if plugin == "pbft" {
-		logger.Info("Creating consensus plugin %s", plugin)
-		return obcpbft.GetPlugin(stack)
+	name := strings.ToLower(viper.GetString("peer.validator.consensus.plugin"))
+	plugin, ok := consensus.LookupPlugin(name)
+	if !ok {
+		name = "noops"
+		plugin, _ = consensus.LookupPlugin(name)
 	}
-	logger.Info("Creating default consensus plugin (noops)")
-	return noops.GetNoops(stack)
+	if err := validatePlugin(plugin); err != nil {
+		panic(err)
+	}
+	s := newSwappable(stack, name, func(stack consensus.Stack) consensus.Consenter {
+		logger.Info("Creating consensus plugin %s", name)
+		return plugin.Shared(stack)
+	}, createPlugin)
+
+	if height := uint64(viper.GetInt("peer.validator.consensus.swap.height")); height > 0 {
+		next := strings.ToLower(viper.GetString("peer.validator.consensus.swap.plugin"))
+		err := fmt.Errorf("Unknown consensus plugin %s, known plugins are %v", next, consensus.PluginNames())
+		if plugin, ok := consensus.LookupPlugin(next); ok {
+			if err = validatePlugin(plugin); err == nil {
+				err = s.Schedule(next, height)
+			}
+		}
+		if err != nil {
+			logger.Error("Cannot schedule consensus plugin swap: %s", err)
+		}
+	}
+	return s
+
+}
+
+// createPlugin creates a fresh instance of the named plugin, the plugins are
+// not shared with the singletons, as a replaced plugin is closed
+func createPlugin(name string, stack consensus.Stack) consensus.Consenter {
+	plugin, ok := consensus.LookupPlugin(name)
+	if !ok {
+		logger.Warning("Unknown consensus plugin %s, swapping to noops", name)
+		plugin, _ = consensus.LookupPlugin("noops")
+	}
+	return plugin.New(stack)
+}
+
+// validatePlugin checks the deployment configuration against the
+// capabilities of the plugin: the plugin must support the capabilities
+// listed in peer.validator.consensus.capabilities, and the peer must
+// provide what the plugin requires
+func validatePlugin(plugin *consensus.Plugin) error {
+	var caps consensus.Capability
+	if plugin.Capabilities != nil {
+		caps = plugin.Capabilities()
+	}
+	logger.Info("Consensus plugin %s has capabilities %v", plugin.Name, caps)
 
+	var required consensus.Capability
+	for _, name := range viper.GetStringSlice("peer.validator.consensus.capabilities") {
+		c, err := consensus.ParseCapability(name)
+		if err != nil {
+			return err
+		}
+		required |= c
+	}
+	if !caps.Has(required) {
+		return fmt.Errorf("Consensus plugin %s does not support %v, the deployment requires %v", plugin.Name, required&^caps, required)
+	}
+
+	if caps.Has(consensus.RequiresPersistence) && viper.GetString("peer.fileSystemPath") == "" {
+		return fmt.Errorf("Consensus plugin %s persists its state, but peer.fileSystemPath is not set", plugin.Name)
+	}
+	return nil
 }
@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/consensus"
+)
+
+func TestValidatePlugin(t *testing.T) {
+	defer viper.Set("peer.validator.consensus.capabilities", viper.Get("peer.validator.consensus.capabilities"))
+	defer viper.Set("peer.fileSystemPath", viper.Get("peer.fileSystemPath"))
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test")
+
+	bft := &consensus.Plugin{Name: "bft", Capabilities: func() consensus.Capability {
+		return consensus.SupportsBFT | consensus.RequiresPersistence
+	}}
+	plain := &consensus.Plugin{Name: "plain"}
+
+	viper.Set("peer.validator.consensus.capabilities", "")
+	if err := validatePlugin(plain); err != nil {
+		t.Errorf("Expected a plugin without capabilities to satisfy a deployment without requirements: %s", err)
+	}
+
+	viper.Set("peer.validator.consensus.capabilities", "bft")
+	if err := validatePlugin(bft); err != nil {
+		t.Errorf("Expected plugin supporting bft to satisfy the deployment: %s", err)
+	}
+	if err := validatePlugin(plain); err == nil || !strings.Contains(err.Error(), "bft") {
+		t.Errorf("Expected plugin without bft support to be refused, got %v", err)
+	}
+
+	viper.Set("peer.validator.consensus.capabilities", "bft nondeterminism")
+	if err := validatePlugin(bft); err == nil || !strings.Contains(err.Error(), "[nondeterminism]") {
+		t.Errorf("Expected plugin without nondeterminism support to be refused, got %v", err)
+	}
+
+	viper.Set("peer.validator.consensus.capabilities", "bft fast")
+	if err := validatePlugin(bft); err == nil {
+		t.Errorf("Expected an unknown capability to be refused")
+	}
+
+	viper.Set("peer.validator.consensus.capabilities", "")
+	viper.Set("peer.fileSystemPath", "")
+	if err := validatePlugin(bft); err == nil {
+		t.Errorf("Expected plugin requiring persistence to be refused without a file system path")
+	}
+}
+
+func TestRegisteredPlugins(t *testing.T) {
+	for _, name := range []string{"external", "noops", "pbft", "poa"} {
+		plugin, ok := consensus.LookupPlugin(name)
+		if !ok {
+			t.Errorf("Expected plugin %s to be registered, registered are %v", name, consensus.PluginNames())
+			continue
+		}
+		if plugin.Shared == nil || plugin.New == nil {
+			t.Errorf("Expected plugin %s to register its constructors", name)
+		}
+	}
+	pbft, _ := consensus.LookupPlugin("pbft")
+	if caps := pbft.Capabilities(); !caps.Has(consensus.SupportsBFT | consensus.RequiresPersistence) {
+		t.Errorf("Expected pbft to support bft and require persistence, it has capabilities %v", caps)
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/core/peer/statetransfer"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Swappable is a consensus.Consenter which forwards to the active consensus
+// plugin, and replaces the plugin with another one once the ledger reaches a
+// block height all validators agreed on, without restarting the peer.
+//
+// Once the ledger reaches the swap height, the active plugin may no longer
+// execute transactions, it is closed once in-flight messages were handled,
+// and the new plugin is created on the same stack, so that it resumes from
+// the ledger state left behind. Messages received meanwhile wait for the new
+// plugin, which handles all messages from then on, so the message handlers of
+// the peer keep delivering to the Swappable
+type Swappable struct {
+	lock   sync.RWMutex
+	stack  *swapStack
+	plugin string              // name of the active plugin
+	active consensus.Consenter // the active plugin
+
+	create func(plugin string, stack consensus.Stack) consensus.Consenter // creates a fresh instance of a plugin
+	reach  chan struct{}                                                  // signals that the ledger reached the swap height
+}
+
+// swap is a scheduled replacement of the active plugin
+type swap struct {
+	plugin string
+	height uint64 // block height after which the plugin is replaced
+}
+
+// swapStack is the stack handed to the plugins of a Swappable, it refuses to
+// execute transactions for a plugin which is about to be replaced
+type swapStack struct {
+	consensus.Stack
+	owner *Swappable
+
+	lock    sync.Mutex
+	pending *swap // nil if no swap is scheduled
+}
+
+// newSwappable creates a Swappable whose first plugin is created by initial
+func newSwappable(stack consensus.Stack, plugin string, initial func(consensus.Stack) consensus.Consenter,
+	create func(plugin string, stack consensus.Stack) consensus.Consenter) *Swappable {
+	s := &Swappable{
+		plugin: plugin,
+		create: create,
+		reach:  make(chan struct{}, 1),
+	}
+	s.stack = &swapStack{Stack: stack, owner: s}
+	s.active = initial(s.stack)
+	go s.swapLoop()
+	return s
+}
+
+// Plugin returns the name of the active plugin
+func (s *Swappable) Plugin() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.plugin
+}
+
+// Schedule replaces the active plugin with plugin once the ledger reached
+// height blocks. Every validator must schedule the same swap, so that they
+// all hand over at the same block
+func (s *Swappable) Schedule(plugin string, height uint64) error {
+	if size := s.stack.GetBlockchainSize(); height < size {
+		return fmt.Errorf("Cannot swap consensus plugin at block height %d, the ledger already holds %d blocks", height, size)
+	}
+
+	current := s.Plugin()
+	ss := s.stack
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if ss.pending != nil {
+		return fmt.Errorf("Consensus plugin swap to %s at block height %d already scheduled", ss.pending.plugin, ss.pending.height)
+	}
+	ss.pending = &swap{plugin: plugin, height: height}
+	logger.Info("Scheduled swap of consensus plugin %s to %s at block height %d", current, plugin, height)
+
+	if s.stack.GetBlockchainSize() >= height {
+		s.reached()
+	}
+	return nil
+}
+
+// reached signals the swap loop, it never blocks
+func (s *Swappable) reached() {
+	select {
+	case s.reach <- struct{}{}:
+	default:
+	}
+}
+
+// swapLoop replaces the active plugin each time the ledger reaches the scheduled height
+func (s *Swappable) swapLoop() {
+	for range s.reach {
+		s.stack.lock.Lock()
+		pending := s.stack.pending
+		s.stack.lock.Unlock()
+		if pending == nil || s.stack.GetBlockchainSize() < pending.height {
+			continue
+		}
+		s.replace(pending)
+	}
+}
+
+// replace closes the active plugin and hands over to the scheduled one
+func (s *Swappable) replace(sw *swap) {
+	s.lock.Lock() // waits for in-flight messages, and holds back new ones until the new plugin is in place
+	defer s.lock.Unlock()
+
+	logger.Info("Ledger reached block height %d, replacing consensus plugin %s with %s", sw.height, s.plugin, sw.plugin)
+	if closer, ok := s.active.(consensus.Closer); ok {
+		closer.Close()
+	}
+
+	s.stack.lock.Lock()
+	s.stack.pending = nil
+	s.stack.lock.Unlock()
+
+	s.active = s.create(sw.plugin, s.stack)
+	s.plugin = sw.plugin
+	logger.Info("Consensus plugin %s active", sw.plugin)
+}
+
+// RecvMsg is necessary to implement consensus.Consenter
+func (s *Swappable) RecvMsg(msg *pb.Message, senderHandle *pb.PeerID) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.active.RecvMsg(msg, senderHandle)
+}
+
+// StateUpdated is necessary to implement consensus.Consenter, state
+// transfer may carry the ledger past the swap height
+func (s *Swappable) StateUpdated(tag uint64, id []byte) {
+	s.lock.RLock()
+	s.active.StateUpdated(tag, id)
+	s.lock.RUnlock()
+	s.stack.checkHeight()
+}
+
+// StateUpdating is necessary to implement consensus.Consenter
+func (s *Swappable) StateUpdating(tag uint64, id []byte) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.active.StateUpdating(tag, id)
+}
+
+// StateUpdateProgress is necessary to implement consensus.StateTransferObserver
+func (s *Swappable) StateUpdateProgress(tag uint64, status statetransfer.Status) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if observer, ok := s.active.(consensus.StateTransferObserver); ok {
+		observer.StateUpdateProgress(tag, status)
+	}
+}
+
+// Overloaded is necessary to implement consensus.Throttler
+func (s *Swappable) Overloaded() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if throttler, ok := s.active.(consensus.Throttler); ok {
+		return throttler.Overloaded()
+	}
+	return false
+}
+
+// RotateSessionKeys is necessary to implement consensus.Rekeyer
+func (s *Swappable) RotateSessionKeys() error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if rekeyer, ok := s.active.(consensus.Rekeyer); ok {
+		return rekeyer.RotateSessionKeys()
+	}
+	return fmt.Errorf("Consensus plugin does not use session keys")
+}
+
+// Inspect is necessary to implement consensus.Inspector
+func (s *Swappable) Inspect() (*consensus.ReplicaState, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if inspector, ok := s.active.(consensus.Inspector); ok {
+		return inspector.Inspect()
+	}
+	return nil, fmt.Errorf("Consensus plugin does not expose its state")
+}
+
+// RequestViewChange is necessary to implement consensus.ViewChanger
+func (s *Swappable) RequestViewChange() error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if vc, ok := s.active.(consensus.ViewChanger); ok {
+		return vc.RequestViewChange()
+	}
+	return fmt.Errorf("Consensus plugin does not change views")
+}
+
+// ResetEpoch is necessary to implement consensus.EpochResetter
+func (s *Swappable) ResetEpoch() error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if er, ok := s.active.(consensus.EpochResetter); ok {
+		return er.ResetEpoch()
+	}
+	return fmt.Errorf("Consensus plugin does not assign sequence numbers in epochs")
+}
+
+// DumpState is necessary to implement consensus.StateDumper
+func (s *Swappable) DumpState(w io.Writer) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if dumper, ok := s.active.(consensus.StateDumper); ok {
+		return dumper.DumpState(w)
+	}
+	return fmt.Errorf("Consensus plugin does not dump its state")
+}
+
+// retiring returns an error once the ledger reached the height of a scheduled swap
+func (ss *swapStack) retiring() error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if ss.pending != nil && ss.Stack.GetBlockchainSize() >= ss.pending.height {
+		return fmt.Errorf("Consensus plugin is being replaced at block height %d", ss.pending.height)
+	}
+	return nil
+}
+
+// checkHeight signals the swap loop if the ledger reached the height of a scheduled swap
+func (ss *swapStack) checkHeight() {
+	if ss.retiring() != nil {
+		ss.owner.reached()
+	}
+}
+
+// BeginTxBatch refuses to start a batch once the ledger reached the swap height
+func (ss *swapStack) BeginTxBatch(id interface{}) error {
+	if err := ss.retiring(); err != nil {
+		return err
+	}
+	return ss.Stack.BeginTxBatch(id)
+}
+
+// ExecTxs refuses to execute transactions once the ledger reached the swap height
+func (ss *swapStack) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error) {
+	if err := ss.retiring(); err != nil {
+		return nil, err
+	}
+	return ss.Stack.ExecTxs(id, txs)
+}
+
+// CommitTxBatch refuses to commit a batch once the ledger reached the swap
+// height, and signals the swap once the committed block reaches it
+func (ss *swapStack) CommitTxBatch(id interface{}, metadata []byte) (*pb.Block, error) {
+	if err := ss.retiring(); err != nil {
+		ss.Stack.RollbackTxBatch(id)
+		return nil, err
+	}
+	block, err := ss.Stack.CommitTxBatch(id, metadata)
+	ss.checkHeight()
+	return block, err
+}
+
+// PreviewCommitTxBatch refuses to preview a batch once the ledger reached the swap height
+func (ss *swapStack) PreviewCommitTxBatch(id interface{}, metadata []byte) ([]byte, error) {
+	if err := ss.retiring(); err != nil {
+		return nil, err
+	}
+	return ss.Stack.PreviewCommitTxBatch(id, metadata)
+}
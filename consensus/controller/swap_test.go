@@ -0,0 +1,147 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type mockLedgerStack struct {
+	consensus.Stack
+	lock   sync.Mutex
+	height uint64
+}
+
+func (ms *mockLedgerStack) GetBlockchainSize() uint64 {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return ms.height
+}
+
+func (ms *mockLedgerStack) BeginTxBatch(id interface{}) error { return nil }
+
+func (ms *mockLedgerStack) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error) {
+	return nil, nil
+}
+
+func (ms *mockLedgerStack) CommitTxBatch(id interface{}, metadata []byte) (*pb.Block, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ms.height++
+	return &pb.Block{}, nil
+}
+
+func (ms *mockLedgerStack) RollbackTxBatch(id interface{}) error { return nil }
+
+// mockPlugin commits a block for every message it receives
+type mockPlugin struct {
+	name   string
+	stack  consensus.Stack
+	lock   sync.Mutex
+	blocks int
+	closed bool
+}
+
+func (mp *mockPlugin) RecvMsg(msg *pb.Message, senderHandle *pb.PeerID) error {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	if mp.closed {
+		panic("closed plugin received a message")
+	}
+	if err := mp.stack.BeginTxBatch(msg); err != nil {
+		return err
+	}
+	if _, err := mp.stack.ExecTxs(msg, nil); err != nil {
+		return err
+	}
+	if _, err := mp.stack.CommitTxBatch(msg, nil); err != nil {
+		return err
+	}
+	mp.blocks++
+	return nil
+}
+
+func (mp *mockPlugin) StateUpdated(tag uint64, id []byte)  {}
+func (mp *mockPlugin) StateUpdating(tag uint64, id []byte) {}
+
+func (mp *mockPlugin) Close() {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	mp.closed = true
+}
+
+func TestSwapPluginAtHeight(t *testing.T) {
+	stack := &mockLedgerStack{height: 1}
+	plugins := make(map[string]*mockPlugin)
+	create := func(plugin string, stack consensus.Stack) consensus.Consenter {
+		mp := &mockPlugin{name: plugin, stack: stack}
+		plugins[plugin] = mp
+		return mp
+	}
+	s := newSwappable(stack, "old", func(stack consensus.Stack) consensus.Consenter {
+		return create("old", stack)
+	}, create)
+
+	if err := s.Schedule("new", 0); err == nil {
+		t.Fatalf("Expected a swap below the current block height to be rejected")
+	}
+	if err := s.Schedule("new", 3); err != nil {
+		t.Fatalf("Could not schedule swap: %s", err)
+	}
+	if err := s.Schedule("other", 4); err == nil {
+		t.Fatalf("Expected a second swap to be rejected while one is scheduled")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.RecvMsg(&pb.Message{}, nil); err != nil {
+			t.Fatalf("Old plugin could not commit block %d: %s", i, err)
+		}
+	}
+	// Until the swap completes, the old plugin must not commit past the swap height
+	s.RecvMsg(&pb.Message{}, nil)
+	if stack.GetBlockchainSize() != 3 {
+		t.Fatalf("Expected the old plugin to stop at block height 3, got %d", stack.GetBlockchainSize())
+	}
+
+	for i := 0; s.Plugin() != "new"; i++ {
+		if i == 1000 {
+			t.Fatalf("Timed out waiting for the plugin to be swapped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !plugins["old"].closed {
+		t.Errorf("Expected the old plugin to be closed")
+	}
+	if plugins["old"].blocks != 2 {
+		t.Errorf("Expected the old plugin to commit 2 blocks, committed %d", plugins["old"].blocks)
+	}
+
+	if err := s.RecvMsg(&pb.Message{}, nil); err != nil {
+		t.Fatalf("New plugin could not commit: %s", err)
+	}
+	if stack.GetBlockchainSize() != 4 || plugins["new"].blocks != 1 {
+		t.Errorf("Expected the new plugin to continue from the ledger at height 3, height is %d", stack.GetBlockchainSize())
+	}
+	if err := s.Schedule("other", 10); err != nil {
+		t.Errorf("Expected another swap to be schedulable once the first completed: %s", err)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	google_protobuf "google/protobuf"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/core/peer"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// ServerConsensusAdmin implementation of the ConsensusAdmin service of a
+// validator, it forwards to the consenter of the engine
+type ServerConsensusAdmin struct {
+	engine peer.Engine
+}
+
+// NewConsensusAdminServer creates and returns a ConsensusAdmin service
+// instance for the consenter of engine
+func NewConsensusAdminServer(engine peer.Engine) *ServerConsensusAdmin {
+	return &ServerConsensusAdmin{engine: engine}
+}
+
+// GetConsensusStatus reports the view, the primary, the watermarks and the
+// progress of the consenter
+func (s *ServerConsensusAdmin) GetConsensusStatus(context.Context, *google_protobuf.Empty) (*pb.ConsensusStatus, error) {
+	inspector, ok := s.engine.(consensus.Inspector)
+	if !ok {
+		return nil, fmt.Errorf("Consensus plugin does not expose its state")
+	}
+	state, err := inspector.Inspect()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ConsensusStatus{
+		ReplicaID:           state.ReplicaID,
+		View:                state.View,
+		Epoch:               state.Epoch,
+		ActiveView:          state.ActiveView,
+		Primary:             state.Primary,
+		LastExecuted:        state.LastExec,
+		LowWatermark:        state.LowWatermark,
+		HighWatermark:       state.LowWatermark + state.LogSize,
+		CheckpointPeriod:    state.CheckpointPeriod,
+		OutstandingRequests: uint64(state.OutstandingRequests),
+		ViewChanges:         uint64(len(state.ViewChanges)),
+		Synced:              !state.StateTransfer,
+	}, nil
+}
+
+// RequestViewChange has the consenter vote to abandon its current view
+func (s *ServerConsensusAdmin) RequestViewChange(context.Context, *google_protobuf.Empty) (*google_protobuf.Empty, error) {
+	vc, ok := s.engine.(consensus.ViewChanger)
+	if !ok {
+		return nil, fmt.Errorf("Consensus plugin does not change views")
+	}
+	if err := vc.RequestViewChange(); err != nil {
+		return nil, err
+	}
+	logger.Info("Requested a view change on behalf of an operator")
+	return &google_protobuf.Empty{}, nil
+}
+
+// ResetEpoch has the consenter end its sequence number epoch at the next
+// checkpoint
+func (s *ServerConsensusAdmin) ResetEpoch(context.Context, *google_protobuf.Empty) (*google_protobuf.Empty, error) {
+	er, ok := s.engine.(consensus.EpochResetter)
+	if !ok {
+		return nil, fmt.Errorf("Consensus plugin does not assign sequence numbers in epochs")
+	}
+	if err := er.ResetEpoch(); err != nil {
+		return nil, err
+	}
+	logger.Info("Requested the end of the sequence number epoch on behalf of an operator")
+	return &google_protobuf.Empty{}, nil
+}
+
+// DumpState writes the soft state of the consenter to a file on the validator
+func (s *ServerConsensusAdmin) DumpState(context.Context, *google_protobuf.Empty) (*pb.ConsensusDump, error) {
+	path, err := DumpConsensusState(s.engine)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ConsensusDump{Path: path}, nil
+}
+
+// DumpConsensusState writes the soft state of the consenter of engine to a
+// new file in peer.validator.consensus.dumps, consensus/dumps below
+// peer.fileSystemPath by default, and returns the path of the file
+func DumpConsensusState(engine peer.Engine) (string, error) {
+	dumper, ok := engine.(consensus.StateDumper)
+	if !ok {
+		return "", fmt.Errorf("Consensus plugin does not dump its state")
+	}
+
+	dir := viper.GetString("peer.validator.consensus.dumps")
+	if dir == "" {
+		dir = filepath.Join(viper.GetString("peer.fileSystemPath"), "consensus", "dumps")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("Cannot create directory of the consensus state dumps: %s", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("state-%s.json", time.Now().UTC().Format("20060102T150405.000000000")))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", fmt.Errorf("Cannot create consensus state dump: %s", err)
+	}
+	if err := dumper.DumpState(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", fmt.Errorf("Cannot dump consensus state: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("Cannot write consensus state dump: %s", err)
+	}
+	logger.Info("Dumped the consensus state to %s", path)
+	return path, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	google_protobuf "google/protobuf"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type viewChangingConsenter struct {
+	inspectedConsenter
+	requested int
+}
+
+func (vc *viewChangingConsenter) RequestViewChange() error {
+	vc.requested++
+	return nil
+}
+
+type epochResettingConsenter struct {
+	throttledConsenter
+	resets int
+}
+
+func (er *epochResettingConsenter) ResetEpoch() error {
+	er.resets++
+	return nil
+}
+
+type dumpingConsenter struct {
+	throttledConsenter
+}
+
+func (dc *dumpingConsenter) DumpState(w io.Writer) error {
+	_, err := fmt.Fprint(w, `{"view":3}`)
+	return err
+}
+
+func TestConsensusAdminStatus(t *testing.T) {
+	state := &consensus.ReplicaState{
+		ReplicaID:           2,
+		View:                3,
+		ActiveView:          true,
+		Primary:             3,
+		LastExec:            12,
+		LowWatermark:        10,
+		LogSize:             20,
+		CheckpointPeriod:    10,
+		OutstandingRequests: 1,
+		ViewChanges:         []consensus.ViewChangeVote{{View: 4, ReplicaID: 1}},
+		StateTransfer:       true,
+	}
+	admin := NewConsensusAdminServer((&EngineImpl{}).setConsenter(&inspectedConsenter{state: state}))
+	status, err := admin.GetConsensusStatus(context.Background(), &google_protobuf.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get the consensus status: %s", err)
+	}
+	expected := &pb.ConsensusStatus{
+		ReplicaID:           2,
+		View:                3,
+		ActiveView:          true,
+		Primary:             3,
+		LastExecuted:        12,
+		LowWatermark:        10,
+		HighWatermark:       30,
+		CheckpointPeriod:    10,
+		OutstandingRequests: 1,
+		ViewChanges:         1,
+		Synced:              false,
+	}
+	if *status != *expected {
+		t.Errorf("Expected status %v, got %v", expected, status)
+	}
+}
+
+func TestConsensusAdminViewChange(t *testing.T) {
+	vc := &viewChangingConsenter{}
+	admin := NewConsensusAdminServer((&EngineImpl{}).setConsenter(vc))
+	if _, err := admin.RequestViewChange(context.Background(), &google_protobuf.Empty{}); err != nil || vc.requested != 1 {
+		t.Errorf("Expected the view change to be requested from the consenter, got %v", err)
+	}
+
+	admin = NewConsensusAdminServer((&EngineImpl{}).setConsenter(&throttledConsenter{}))
+	if _, err := admin.RequestViewChange(context.Background(), &google_protobuf.Empty{}); err == nil {
+		t.Error("Expected an error for a consenter which does not change views")
+	}
+}
+
+func TestConsensusAdminResetEpoch(t *testing.T) {
+	er := &epochResettingConsenter{}
+	admin := NewConsensusAdminServer((&EngineImpl{}).setConsenter(er))
+	if _, err := admin.ResetEpoch(context.Background(), &google_protobuf.Empty{}); err != nil || er.resets != 1 {
+		t.Errorf("Expected the end of the epoch to be requested from the consenter, got %v", err)
+	}
+
+	admin = NewConsensusAdminServer((&EngineImpl{}).setConsenter(&throttledConsenter{}))
+	if _, err := admin.ResetEpoch(context.Background(), &google_protobuf.Empty{}); err == nil {
+		t.Error("Expected an error for a consenter which does not assign sequence numbers in epochs")
+	}
+}
+
+func TestConsensusAdminDumpState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consensus-dumps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("peer.validator.consensus.dumps", dir)
+	defer viper.Set("peer.validator.consensus.dumps", "")
+
+	admin := NewConsensusAdminServer((&EngineImpl{}).setConsenter(&dumpingConsenter{}))
+	dump, err := admin.DumpState(context.Background(), &google_protobuf.Empty{})
+	if err != nil {
+		t.Fatalf("Could not dump the consensus state: %s", err)
+	}
+	if contents, err := ioutil.ReadFile(dump.Path); err != nil || string(contents) != `{"view":3}` {
+		t.Errorf("Expected the dump of the consenter in %s, got %q, %v", dump.Path, contents, err)
+	}
+
+	admin = NewConsensusAdminServer((&EngineImpl{}).setConsenter(&throttledConsenter{}))
+	if _, err := admin.DumpState(context.Background(), &google_protobuf.Empty{}); err == nil {
+		t.Error("Expected an error for a consenter which does not dump its state")
+	}
+}
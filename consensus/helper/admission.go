@@ -0,0 +1,76 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/events/producer"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// throttleEventType is the type of the generic events announcing that
+// throttling of a client engaged or ended
+const throttleEventType = "consensus.throttle"
+
+// throttleEvent is the JSON payload of a throttle event
+type throttleEvent struct {
+	Client    string `json:"client"`
+	Throttled bool   `json:"throttled"`
+}
+
+// Admit is necessary to implement consensus.Admitter. Clients are
+// identified by the SHA-256 fingerprint of the certificate of their
+// transactions, and admitted if it is listed in
+// peer.validator.consensus.admission.clients, or if the list is empty
+func (h *Helper) Admit(tx *pb.Transaction) (string, error) {
+	identity := "anonymous"
+	if len(tx.Cert) > 0 {
+		fingerprint := sha256.Sum256(tx.Cert)
+		identity = hex.EncodeToString(fingerprint[:])
+	}
+	allowed := viper.GetStringSlice("peer.validator.consensus.admission.clients")
+	if len(allowed) == 0 {
+		return identity, nil
+	}
+	for _, client := range allowed {
+		if strings.EqualFold(client, identity) {
+			return identity, nil
+		}
+	}
+	return "", fmt.Errorf("client %s is not allowed to submit transactions", identity)
+}
+
+// Throttled is necessary to implement consensus.ThrottleObserver. It
+// publishes a generic event, so that event consumers learn which clients
+// exceed their submission rate
+func (h *Helper) Throttled(identity string, throttled bool) {
+	payload, err := json.Marshal(&throttleEvent{Client: identity, Throttled: throttled})
+	if err != nil {
+		logger.Error("Cannot marshal throttle event: %s", err)
+		return
+	}
+	if err := producer.Send(producer.CreateGenericEvent(throttleEventType, payload)); err != nil {
+		logger.Error("Cannot send throttle event for client %s: %s", identity, err)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestAdmit(t *testing.T) {
+	defer viper.Set("peer.validator.consensus.admission.clients", nil)
+	h := &Helper{}
+	tx := &pb.Transaction{Cert: []byte("client certificate")}
+
+	identity, err := h.Admit(tx)
+	if err != nil || len(identity) != 64 {
+		t.Fatalf("Expected every client to be admitted by default, got %q, %v", identity, err)
+	}
+
+	viper.Set("peer.validator.consensus.admission.clients", []string{identity})
+	if _, err := h.Admit(tx); err != nil {
+		t.Errorf("Expected a listed client to be admitted: %s", err)
+	}
+	if _, err := h.Admit(&pb.Transaction{}); err == nil {
+		t.Errorf("Expected an anonymous client to be rejected when it is not listed")
+	}
+}
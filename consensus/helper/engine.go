@@ -26,6 +26,7 @@ import (
 	"github.com/hyperledger/fabric/core/chaincode"
 	pb "github.com/hyperledger/fabric/protos"
 	"golang.org/x/net/context"
+	"io"
 	"sync"
 )
 
@@ -76,6 +77,10 @@ func (eng *EngineImpl) ProcessTransactionMsg(msg *pb.Message, tx *pb.Transaction
 		if eng.consenter == nil {
 			return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte("Engine not initialized")}
 		}
+		if throttler, ok := eng.consenter.(consensus.Throttler); ok && throttler.Overloaded() {
+			logger.Warning("Rejecting transaction %s because consensus is overloaded", tx.Uuid)
+			return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte("Error: consensus is overloaded, retry later")}
+		}
 		// TODO, do we want to put these requests into a queue? This will block until
 		// the consenter gets around to handling the message, but it also provides some
 		// natural feedback to the REST API to determine how long it takes to queue messages
@@ -87,6 +92,46 @@ func (eng *EngineImpl) ProcessTransactionMsg(msg *pb.Message, tx *pb.Transaction
 	return response
 }
 
+// Inspect is necessary to implement consensus.Inspector
+func (eng *EngineImpl) Inspect() (*consensus.ReplicaState, error) {
+	if inspector, ok := eng.consenter.(consensus.Inspector); ok {
+		return inspector.Inspect()
+	}
+	return nil, fmt.Errorf("Consensus plugin does not expose its state")
+}
+
+// RequestStatus is necessary to implement consensus.StatusQuerier
+func (eng *EngineImpl) RequestStatus(uuid string) (*consensus.RequestStatus, error) {
+	if querier, ok := eng.consenter.(consensus.StatusQuerier); ok {
+		return querier.RequestStatus(uuid)
+	}
+	return nil, fmt.Errorf("Consensus plugin does not report the status of requests")
+}
+
+// RequestViewChange is necessary to implement consensus.ViewChanger
+func (eng *EngineImpl) RequestViewChange() error {
+	if vc, ok := eng.consenter.(consensus.ViewChanger); ok {
+		return vc.RequestViewChange()
+	}
+	return fmt.Errorf("Consensus plugin does not change views")
+}
+
+// ResetEpoch is necessary to implement consensus.EpochResetter
+func (eng *EngineImpl) ResetEpoch() error {
+	if er, ok := eng.consenter.(consensus.EpochResetter); ok {
+		return er.ResetEpoch()
+	}
+	return fmt.Errorf("Consensus plugin does not assign sequence numbers in epochs")
+}
+
+// DumpState is necessary to implement consensus.StateDumper
+func (eng *EngineImpl) DumpState(w io.Writer) error {
+	if dumper, ok := eng.consenter.(consensus.StateDumper); ok {
+		return dumper.DumpState(w)
+	}
+	return fmt.Errorf("Consensus plugin does not dump its state")
+}
+
 func (eng *EngineImpl) setConsenter(consenter consensus.Consenter) *EngineImpl {
 	eng.consenter = consenter
 	return eng
@@ -111,6 +156,12 @@ func GetEngine(coord peer.MessageHandlerCoordinator) (peer.Engine, error) {
 	engineOnce.Do(func() {
 		engine = new(EngineImpl)
 		engine.helper = NewHelper(coord)
+		if err = engine.helper.enableSigner(); err != nil {
+			return
+		}
+		if engine.helper.opener, err = newConfiguredOpener(); err != nil {
+			return
+		}
 		engine.consenter = controller.NewConsenter(engine.helper)
 		engine.helper.setConsenter(engine.consenter)
 		engine.peerEndpoint, err = coord.GetPeerEndpoint()
@@ -16,8 +16,85 @@ limitations under the License.
 
 package helper
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+)
 
 func TestEngine(t *testing.T) {
 	t.Skip("Engine functions already tested in other consensus components")
 }
+
+type throttledConsenter struct {
+	overloaded bool
+	received   int
+}
+
+func (tc *throttledConsenter) RecvMsg(msg *pb.Message, senderHandle *pb.PeerID) error {
+	tc.received++
+	return nil
+}
+func (tc *throttledConsenter) StateUpdated(tag uint64, id []byte)  {}
+func (tc *throttledConsenter) StateUpdating(tag uint64, id []byte) {}
+func (tc *throttledConsenter) Overloaded() bool                    { return tc.overloaded }
+
+func TestEngineBackpressure(t *testing.T) {
+	tc := &throttledConsenter{overloaded: true}
+	eng := (&EngineImpl{}).setConsenter(tc).setPeerEndpoint(&pb.PeerEndpoint{ID: &pb.PeerID{Name: "vp0"}})
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, Uuid: "foo"}
+
+	if resp := eng.ProcessTransactionMsg(&pb.Message{}, tx); resp.Status != pb.Response_FAILURE || tc.received != 0 {
+		t.Errorf("Expected the transaction to be rejected while the consenter is overloaded, got %v", resp)
+	}
+
+	tc.overloaded = false
+	if resp := eng.ProcessTransactionMsg(&pb.Message{}, tx); resp.Status != pb.Response_SUCCESS || tc.received != 1 {
+		t.Errorf("Expected the transaction to be passed to the consenter, got %v", resp)
+	}
+}
+
+type inspectedConsenter struct {
+	throttledConsenter
+	state *consensus.ReplicaState
+}
+
+func (ic *inspectedConsenter) Inspect() (*consensus.ReplicaState, error) { return ic.state, nil }
+
+func TestEngineInspect(t *testing.T) {
+	state := &consensus.ReplicaState{View: 3, LastExec: 12}
+	eng := (&EngineImpl{}).setConsenter(&inspectedConsenter{state: state})
+	if got, err := eng.Inspect(); err != nil || got != state {
+		t.Errorf("Expected the state of the consenter, got %v, %v", got, err)
+	}
+
+	eng.setConsenter(&throttledConsenter{})
+	if _, err := eng.Inspect(); err == nil {
+		t.Error("Expected an error for a consenter which does not expose its state")
+	}
+}
+
+type statusConsenter struct {
+	throttledConsenter
+	uuid string
+}
+
+func (sc *statusConsenter) RequestStatus(uuid string) (*consensus.RequestStatus, error) {
+	if uuid != sc.uuid {
+		return &consensus.RequestStatus{Phase: consensus.RequestUnknown}, nil
+	}
+	return &consensus.RequestStatus{Phase: consensus.RequestExecuted, SeqNo: 7}, nil
+}
+
+func TestEngineRequestStatus(t *testing.T) {
+	eng := (&EngineImpl{}).setConsenter(&statusConsenter{uuid: "tx1"})
+	if status, err := eng.RequestStatus("tx1"); err != nil || status.Phase != consensus.RequestExecuted || status.SeqNo != 7 {
+		t.Errorf("Expected the transaction to be executed at seqNo 7, got %v, %v", status, err)
+	}
+
+	eng.setConsenter(&throttledConsenter{})
+	if _, err := eng.RequestStatus("tx1"); err == nil {
+		t.Error("Expected an error for a consenter which does not report the status of requests")
+	}
+}
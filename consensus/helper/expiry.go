@@ -0,0 +1,48 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/events/producer"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// expiryEventType is the type of the generic events announcing that a
+// transaction was dropped because its expiry passed before it was ordered
+const expiryEventType = "consensus.expired"
+
+// expiryEvent is the JSON payload of an expiry event
+type expiryEvent struct {
+	UUID          string `json:"uuid"`
+	CorrelationID string `json:"correlationID,omitempty"`
+}
+
+// Expired is necessary to implement consensus.ExpiryObserver. It publishes a
+// generic event, so that the client of the transaction learns it will not be
+// executed
+func (h *Helper) Expired(tx *pb.Transaction) {
+	payload, err := json.Marshal(&expiryEvent{UUID: tx.Uuid, CorrelationID: tx.CorrelationID})
+	if err != nil {
+		logger.Error("Cannot marshal expiry event: %s", err)
+		return
+	}
+	if err := producer.Send(producer.CreateGenericEvent(expiryEventType, payload)); err != nil {
+		logger.Error("Cannot send expiry event for transaction %s: %s", tx.Uuid, err)
+	}
+}
@@ -40,6 +40,8 @@ type Helper struct {
 	secOn        bool
 	valid        bool // Whether we believe the state is up to date
 	secHelper    crypto.Peer
+	signer       signer                  // signs consensus messages through a signing device, nil if secHelper signs them
+	opener       *payloadOpener          // opens sealed transactions, nil if no sealing key is configured
 	curBatch     []*pb.Transaction       // TODO, remove after issue 579
 	curBatchErrs []*pb.TransactionResult // TODO, remove after issue 579
 	persist.Helper
@@ -47,6 +49,12 @@ type Helper struct {
 	sts *statetransfer.StateTransferState
 }
 
+// persistentCoordinator adds persistence to the MessageHandlerCoordinator, so that state transfer can record its progress
+type persistentCoordinator struct {
+	peer.MessageHandlerCoordinator
+	persist.Helper
+}
+
 // NewHelper constructs the consensus helper object
 func NewHelper(mhc peer.MessageHandlerCoordinator) *Helper {
 	h := &Helper{
@@ -55,7 +63,7 @@ func NewHelper(mhc peer.MessageHandlerCoordinator) *Helper {
 		secHelper:   mhc.GetSecHelper(),
 		valid:       true, // Assume our state is consistent until we are told otherwise, TODO: revisit
 	}
-	h.sts = statetransfer.NewStateTransferState(mhc)
+	h.sts = statetransfer.NewStateTransferState(&persistentCoordinator{MessageHandlerCoordinator: mhc})
 	h.sts.RegisterListener(h)
 	return h
 }
@@ -121,6 +129,9 @@ func (h *Helper) Unicast(msg *pb.Message, receiverHandle *pb.PeerID) error {
 // Sign a message with this validator's signing key
 func (h *Helper) Sign(msg []byte) ([]byte, error) {
 	if h.secOn {
+		if h.signer != nil {
+			return h.signer.Sign(msg)
+		}
 		return h.secHelper.Sign(msg)
 	}
 	logger.Debug("Security is disabled")
@@ -189,9 +200,9 @@ func (h *Helper) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error)
 	for i, e := range txerrs {
 		//NOTE- it'll be nice if we can have error values. For now success == 0, error == 1
 		if txerrs[i] != nil {
-			txresults[i] = &pb.TransactionResult{Uuid: txs[i].Uuid, Error: e.Error(), ErrorCode: 1}
+			txresults[i] = &pb.TransactionResult{Uuid: txs[i].Uuid, CorrelationID: txs[i].CorrelationID, Error: e.Error(), ErrorCode: 1}
 		} else {
-			txresults[i] = &pb.TransactionResult{Uuid: txs[i].Uuid}
+			txresults[i] = &pb.TransactionResult{Uuid: txs[i].Uuid, CorrelationID: txs[i].CorrelationID}
 		}
 	}
 	h.curBatchErrs = append(h.curBatchErrs, txresults...) // TODO, remove after issue 579
@@ -199,6 +210,18 @@ func (h *Helper) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error)
 	return res, err
 }
 
+// QueryTx executes a query transaction against the committed state,
+// it fails while the state is being transferred
+func (h *Helper) QueryTx(tx *pb.Transaction) ([]byte, error) {
+	if !h.valid {
+		return nil, fmt.Errorf("State is not up to date, cannot answer query %s", tx.Uuid)
+	}
+	if tx.Type != pb.Transaction_CHAINCODE_QUERY {
+		return nil, fmt.Errorf("Transaction %s is not a query", tx.Uuid)
+	}
+	return chaincode.Execute(context.Background(), chaincode.GetChain(chaincode.DefaultChain), tx)
+}
+
 // CommitTxBatch gets invoked when the current transaction-batch needs
 // to be committed. This function returns successfully iff the
 // transactions details and state changes (that may have happened
@@ -326,6 +349,12 @@ func (h *Helper) ValidateState() {
 	h.valid = true
 }
 
+// ReportEvidence is necessary to implement consensus.EvidenceReporter, it alerts
+// the operator of a provable fault of another validator
+func (h *Helper) ReportEvidence(accused *pb.PeerID, fault string, evidence []byte) {
+	logger.Error("Validator %v committed a provable fault (%s), %d bytes of signed evidence were recorded", accused, fault, len(evidence))
+}
+
 // Initiated is called when state transfer is kicked off, this occurs if SkipTo is invoked while statetransfer is not currently running
 func (h *Helper) Initiated(bn uint64, bh []byte, pids []*pb.PeerID, m interface{}) {
 	h.consenter.StateUpdating(m.(uint64), bh)
@@ -336,6 +365,22 @@ func (h *Helper) Completed(bn uint64, bh []byte, pids []*pb.PeerID, m interface{
 	h.consenter.StateUpdated(m.(uint64), bh)
 }
 
+// Progress is called as state transfer makes progress, it is passed on to consenters which observe it
+func (h *Helper) Progress(status statetransfer.Status) {
+	observer, ok := h.consenter.(consensus.StateTransferObserver)
+	if !ok {
+		return
+	}
+	if seqNo, ok := status.TargetMetadata.(uint64); ok {
+		observer.StateUpdateProgress(seqNo, status)
+	}
+}
+
+// StateTransferStatus returns the progress of the current state transfer, or of the last one if none is running
+func (h *Helper) StateTransferStatus() statetransfer.Status {
+	return h.sts.Status()
+}
+
 // Errored is called when state transfer encounters an error, this is not necessarily fatal
 func (h *Helper) Errored(bn uint64, bh []byte, pids []*pb.PeerID, m interface{}, e error) {
 	if seqNo, ok := m.(uint64); !ok {
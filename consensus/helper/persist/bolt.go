@@ -0,0 +1,127 @@
+// +build bolt
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package persist
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func init() {
+	RegisterDriver("bolt", openBolt)
+}
+
+var boltBucket = []byte("consensus")
+
+// boltDriver keeps the consensus state in a BoltDB file of its own
+type boltDriver struct {
+	db *bolt.DB
+}
+
+func openBolt(path string) (Driver, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(path, "consensus.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open BoltDB at %s: %s", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltDriver{db: db}, nil
+}
+
+// StoreState stores a key,value pair
+func (bd *boltDriver) StoreState(key string, value []byte) error {
+	return bd.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), value)
+	})
+}
+
+// DelState removes a key,value pair
+func (bd *boltDriver) DelState(key string) {
+	err := bd.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		logger.Warning("Cannot delete consensus state %s: %s", key, err)
+	}
+}
+
+// StoreStateBatch stores and deletes several keys in a single transaction
+func (bd *boltDriver) StoreStateBatch(store map[string][]byte, del []string) error {
+	return bd.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for key, value := range store {
+			if err := b.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		for _, key := range del {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReadState retrieves a value to a key
+func (bd *boltDriver) ReadState(key string) (value []byte, err error) {
+	err = bd.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("Cannot find key %s", key)
+		}
+		// values are only valid during the transaction
+		value = append([]byte(nil), v...)
+		return nil
+	})
+	return
+}
+
+// ReadStateSet retrieves all key,value pairs where the key starts with prefix
+func (bd *boltDriver) ReadStateSet(prefix string) (map[string][]byte, error) {
+	ret := make(map[string][]byte)
+	prefixRaw := []byte(prefix)
+	err := bd.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, v := c.Seek(prefixRaw); k != nil && bytes.HasPrefix(k, prefixRaw); k, v = c.Next() {
+			ret[string(k)] = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// Close closes the BoltDB file
+func (bd *boltDriver) Close() error {
+	return bd.db.Close()
+}
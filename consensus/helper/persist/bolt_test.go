@@ -0,0 +1,53 @@
+// +build bolt
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package persist
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBoltDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Open("bolt", dir)
+	if err != nil {
+		t.Fatalf("Failed to open bolt driver: %s", err)
+	}
+	testDriver(t, d)
+
+	// the state must survive reopening
+	d.StoreState("durable", []byte("yes"))
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err = Open("bolt", dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen bolt driver: %s", err)
+	}
+	defer d.Close()
+	if val, err := d.ReadState("durable"); err != nil || string(val) != "yes" {
+		t.Errorf("Expected the state to survive reopening, got %q, %v", val, err)
+	}
+}
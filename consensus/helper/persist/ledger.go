@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package persist
+
+import (
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/tecbot/gorocksdb"
+)
+
+func init() {
+	RegisterDriver("ledger", func(path string) (Driver, error) {
+		return ledgerDriver{}, nil
+	})
+}
+
+// ledgerDriver keeps the consensus state in the Persist column family of
+// the ledger database
+type ledgerDriver struct{}
+
+// StoreState stores a key,value pair
+func (ledgerDriver) StoreState(key string, value []byte) error {
+	db := db.GetDBHandle()
+	return db.Put(db.PersistCF, []byte("consensus."+key), value)
+}
+
+// DelState removes a key,value pair
+func (ledgerDriver) DelState(key string) {
+	db := db.GetDBHandle()
+	db.Delete(db.PersistCF, []byte("consensus."+key))
+}
+
+// StoreStateBatch stores and deletes several keys in a single write batch
+func (ledgerDriver) StoreStateBatch(store map[string][]byte, del []string) error {
+	db := db.GetDBHandle()
+	writeBatch := gorocksdb.NewWriteBatch()
+	defer writeBatch.Destroy()
+	for key, value := range store {
+		writeBatch.PutCF(db.PersistCF, []byte("consensus."+key), value)
+	}
+	for _, key := range del {
+		writeBatch.DeleteCF(db.PersistCF, []byte("consensus."+key))
+	}
+	opt := gorocksdb.NewDefaultWriteOptions()
+	defer opt.Destroy()
+	return db.DB.Write(opt, writeBatch)
+}
+
+// ReadState retrieves a value to a key
+func (ledgerDriver) ReadState(key string) ([]byte, error) {
+	db := db.GetDBHandle()
+	return db.Get(db.PersistCF, []byte("consensus."+key))
+}
+
+// ReadStateSet retrieves all key,value pairs where the key starts with prefix
+func (ledgerDriver) ReadStateSet(prefix string) (map[string][]byte, error) {
+	db := db.GetDBHandle()
+	prefixRaw := []byte("consensus." + prefix)
+
+	ret := make(map[string][]byte)
+	it := db.GetIterator(db.PersistCF)
+	defer it.Close()
+	for it.Seek(prefixRaw); it.ValidForPrefix(prefixRaw); it.Next() {
+		key := string(it.Key().Data())
+		key = key[len("consensus."):]
+		// copy data from the slice!
+		ret[key] = append([]byte(nil), it.Value().Data()...)
+	}
+	return ret, nil
+}
+
+// Close leaves the ledger database open, it is shared with the peer
+func (ledgerDriver) Close() error {
+	return nil
+}
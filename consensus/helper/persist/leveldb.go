@@ -0,0 +1,97 @@
+// +build leveldb
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package persist
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func init() {
+	RegisterDriver("leveldb", openLevelDB)
+}
+
+// levelDBDriver keeps the consensus state in a LevelDB database of its own
+type levelDBDriver struct {
+	db *leveldb.DB
+}
+
+func openLevelDB(path string) (Driver, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open LevelDB at %s: %s", path, err)
+	}
+	return &levelDBDriver{db: db}, nil
+}
+
+// StoreState stores a key,value pair
+func (ld *levelDBDriver) StoreState(key string, value []byte) error {
+	return ld.db.Put([]byte(key), value, nil)
+}
+
+// DelState removes a key,value pair
+func (ld *levelDBDriver) DelState(key string) {
+	if err := ld.db.Delete([]byte(key), nil); err != nil {
+		logger.Warning("Cannot delete consensus state %s: %s", key, err)
+	}
+}
+
+// StoreStateBatch stores and deletes several keys in a single write batch
+func (ld *levelDBDriver) StoreStateBatch(store map[string][]byte, del []string) error {
+	batch := new(leveldb.Batch)
+	for key, value := range store {
+		batch.Put([]byte(key), value)
+	}
+	for _, key := range del {
+		batch.Delete([]byte(key))
+	}
+	return ld.db.Write(batch, nil)
+}
+
+// ReadState retrieves a value to a key
+func (ld *levelDBDriver) ReadState(key string) ([]byte, error) {
+	value, err := ld.db.Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, fmt.Errorf("Cannot find key %s", key)
+	}
+	return value, err
+}
+
+// ReadStateSet retrieves all key,value pairs where the key starts with prefix
+func (ld *levelDBDriver) ReadStateSet(prefix string) (map[string][]byte, error) {
+	ret := make(map[string][]byte)
+	it := ld.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer it.Release()
+	for it.Next() {
+		// copy data from the slice!
+		ret[string(it.Key())] = append([]byte(nil), it.Value()...)
+	}
+	return ret, it.Error()
+}
+
+// Close closes the LevelDB database
+func (ld *levelDBDriver) Close() error {
+	return ld.db.Close()
+}
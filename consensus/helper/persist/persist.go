@@ -17,44 +17,126 @@ limitations under the License.
 package persist
 
 import (
-	"github.com/hyperledger/fabric/core/db"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
 )
 
-// Helper provides an abstraction to access the Persist column family
-// in the database.
+var logger *logging.Logger // package-level logger
+
+func init() {
+	logger = logging.MustGetLogger("consensus/persist")
+}
+
+// Driver stores the state of the consenter, a driver is selected by
+// peer.validator.consensus.persistence.driver
+type Driver interface {
+	StoreState(key string, value []byte) error
+	ReadState(key string) ([]byte, error)
+	ReadStateSet(prefix string) (map[string][]byte, error)
+	DelState(key string)
+	StoreStateBatch(store map[string][]byte, del []string) error // stores and deletes the keys atomically
+	Close() error
+}
+
+// Opener opens the driver storing its files at path, drivers sharing the
+// ledger database ignore the path
+type Opener func(path string) (Driver, error)
+
+var drivers = struct {
+	sync.Mutex
+	openers map[string]Opener
+	open    Driver // the driver of the Helper, once opened
+}{openers: make(map[string]Opener)}
+
+// RegisterDriver makes a driver available under name
+func RegisterDriver(name string, open Opener) {
+	drivers.Lock()
+	defer drivers.Unlock()
+	if _, ok := drivers.openers[name]; ok {
+		panic(fmt.Errorf("Persistence driver %s registered twice", name))
+	}
+	drivers.openers[name] = open
+}
+
+// Open opens the driver registered under name
+func Open(name string, path string) (Driver, error) {
+	drivers.Lock()
+	open, ok := drivers.openers[name]
+	var names []string
+	for n := range drivers.openers {
+		names = append(names, n)
+	}
+	drivers.Unlock()
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown persistence driver %s, this peer was built with %v, the others need the build tag of the same name", name, names)
+	}
+	return open(path)
+}
+
+// Helper provides the consenter with the configured persistence driver,
+// which it opens on first use
 type Helper struct{}
 
+func (h *Helper) driver() Driver {
+	drivers.Lock()
+	d := drivers.open
+	drivers.Unlock()
+	if d != nil {
+		return d
+	}
+
+	name := viper.GetString("peer.validator.consensus.persistence.driver")
+	if name == "" {
+		name = "ledger"
+	}
+	path := viper.GetString("peer.validator.consensus.persistence.path")
+	if path == "" {
+		path = filepath.Join(viper.GetString("peer.fileSystemPath"), "consensus", name)
+	}
+	d, err := Open(name, path)
+	if err != nil {
+		panic(fmt.Errorf("Cannot open consensus persistence: %s", err))
+	}
+
+	drivers.Lock()
+	defer drivers.Unlock()
+	if drivers.open != nil {
+		// opened concurrently
+		d.Close()
+		return drivers.open
+	}
+	logger.Info("Persisting consensus state with driver %s", name)
+	drivers.open = d
+	return d
+}
+
 // StoreState stores a key,value pair
 func (h *Helper) StoreState(key string, value []byte) error {
-	db := db.GetDBHandle()
-	return db.Put(db.PersistCF, []byte("consensus."+key), value)
+	return h.driver().StoreState(key, value)
 }
 
 // DelState removes a key,value pair
 func (h *Helper) DelState(key string) {
-	db := db.GetDBHandle()
-	db.Delete(db.PersistCF, []byte("consensus."+key))
+	h.driver().DelState(key)
+}
+
+// StoreStateBatch stores and deletes several keys in a single atomic write
+func (h *Helper) StoreStateBatch(store map[string][]byte, del []string) error {
+	return h.driver().StoreStateBatch(store, del)
 }
 
 // ReadState retrieves a value to a key
 func (h *Helper) ReadState(key string) ([]byte, error) {
-	db := db.GetDBHandle()
-	return db.Get(db.PersistCF, []byte("consensus."+key))
+	return h.driver().ReadState(key)
 }
 
 // ReadStateSet retrieves all key,value pairs where the key starts with prefix
 func (h *Helper) ReadStateSet(prefix string) (map[string][]byte, error) {
-	db := db.GetDBHandle()
-	prefixRaw := []byte("consensus." + prefix)
-
-	ret := make(map[string][]byte)
-	it := db.GetIterator(db.PersistCF)
-	defer it.Close()
-	for it.Seek(prefixRaw); it.ValidForPrefix(prefixRaw); it.Next() {
-		key := string(it.Key().Data())
-		key = key[len("consensus."):]
-		// copy data from the slice!
-		ret[key] = append([]byte(nil), it.Value().Data()...)
-	}
-	return ret, nil
+	return h.driver().ReadStateSet(prefix)
 }
@@ -16,8 +16,175 @@ limitations under the License.
 
 package persist
 
-import "testing"
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
 
-func TestPersist(t *testing.T) {
-	t.Skip("Persist functions already tested in other consensus components")
+	"github.com/spf13/viper"
+)
+
+type memDriver struct {
+	sync.Mutex
+	path   string
+	state  map[string][]byte
+	closed bool
+}
+
+func (d *memDriver) StoreState(key string, value []byte) error {
+	d.Lock()
+	defer d.Unlock()
+	d.state[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (d *memDriver) ReadState(key string) ([]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+	val, ok := d.state[key]
+	if !ok {
+		return nil, fmt.Errorf("No state for key %s", key)
+	}
+	return val, nil
+}
+
+func (d *memDriver) ReadStateSet(prefix string) (map[string][]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+	ret := make(map[string][]byte)
+	for k, v := range d.state {
+		if strings.HasPrefix(k, prefix) {
+			ret[k] = v
+		}
+	}
+	return ret, nil
+}
+
+func (d *memDriver) DelState(key string) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.state, key)
+}
+
+func (d *memDriver) StoreStateBatch(store map[string][]byte, del []string) error {
+	d.Lock()
+	defer d.Unlock()
+	for key, value := range store {
+		d.state[key] = append([]byte(nil), value...)
+	}
+	for _, key := range del {
+		delete(d.state, key)
+	}
+	return nil
+}
+
+func (d *memDriver) Close() error {
+	d.closed = true
+	return nil
+}
+
+var opened []*memDriver
+
+func init() {
+	RegisterDriver("mem", func(path string) (Driver, error) {
+		d := &memDriver{path: path, state: make(map[string][]byte)}
+		opened = append(opened, d)
+		return d, nil
+	})
+}
+
+// testDriver checks the behavior every driver must provide
+func testDriver(t *testing.T, d Driver) {
+	if err := d.StoreState("pset.1", []byte("one")); err != nil {
+		t.Fatalf("Failed to store state: %s", err)
+	}
+	d.StoreState("pset.2", []byte("two"))
+	d.StoreState("qset.1", []byte("other"))
+
+	if val, err := d.ReadState("pset.1"); err != nil || string(val) != "one" {
+		t.Errorf("Expected to read back the stored state, got %q, %v", val, err)
+	}
+	if _, err := d.ReadState("missing"); err == nil {
+		t.Error("Expected an error reading a missing key")
+	}
+
+	set, err := d.ReadStateSet("pset.")
+	if err != nil {
+		t.Fatalf("Failed to read state set: %s", err)
+	}
+	expected := map[string][]byte{"pset.1": []byte("one"), "pset.2": []byte("two")}
+	if !reflect.DeepEqual(set, expected) {
+		t.Errorf("Expected state set %v, got %v", expected, set)
+	}
+
+	err = d.StoreStateBatch(map[string][]byte{"pset.3": []byte("three"), "qset.1": []byte("replaced")}, []string{"pset.2"})
+	if err != nil {
+		t.Fatalf("Failed to store state batch: %s", err)
+	}
+	if val, _ := d.ReadState("qset.1"); string(val) != "replaced" {
+		t.Errorf("Expected the batch to replace the stored state, got %q", val)
+	}
+	if _, err := d.ReadState("pset.2"); err == nil {
+		t.Error("Expected the batch to delete the key")
+	}
+
+	d.DelState("pset.1")
+	if _, err := d.ReadState("pset.1"); err == nil {
+		t.Error("Expected the deleted key to be gone")
+	}
+	if set, _ := d.ReadStateSet("pset."); len(set) != 1 {
+		t.Errorf("Expected one key left in the state set, got %v", set)
+	}
+}
+
+func TestMemDriver(t *testing.T) {
+	d, err := Open("mem", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDriver(t, d)
+}
+
+func TestOpenUnknownDriver(t *testing.T) {
+	_, err := Open("nosuchdriver", "")
+	if err == nil {
+		t.Fatal("Expected opening an unknown driver to fail")
+	}
+	if !strings.Contains(err.Error(), "mem") {
+		t.Errorf("Expected the error to list the available drivers, got: %s", err)
+	}
+}
+
+func TestRegisterDriverTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a driver twice to panic")
+		}
+	}()
+	RegisterDriver("mem", nil)
+}
+
+func TestHelperUsesConfiguredDriver(t *testing.T) {
+	viper.Set("peer.validator.consensus.persistence.driver", "mem")
+	viper.Set("peer.validator.consensus.persistence.path", "")
+	viper.Set("peer.fileSystemPath", "/var/hyperledger")
+	opened = nil
+	defer func() {
+		viper.Set("peer.validator.consensus.persistence.driver", "")
+		drivers.open = nil
+	}()
+
+	h := &Helper{}
+	h.StoreState("key", []byte("value"))
+	if val, err := h.ReadState("key"); err != nil || string(val) != "value" {
+		t.Errorf("Expected to read back the stored state, got %q, %v", val, err)
+	}
+	if len(opened) != 1 {
+		t.Fatalf("Expected the driver to be opened once, opened %d times", len(opened))
+	}
+	if path := opened[0].path; path != "/var/hyperledger/consensus/mem" {
+		t.Errorf("Expected the default path below the file system path, got %s", path)
+	}
 }
@@ -0,0 +1,183 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/primitives/ecies"
+)
+
+// sealedPayload is a payload encrypted with a random content key, which is
+// in turn encrypted to the sealing key of each of the validators
+type sealedPayload struct {
+	Keys       []wrappedKey
+	Nonce      []byte
+	Ciphertext []byte // AES-256-GCM
+}
+
+type wrappedKey struct {
+	Recipient []byte // SHA-256 of the DER encoded public key of the validator
+	Key       []byte // the content key, ECIES encrypted to the validator
+}
+
+func keyID(pub *ecdsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	id := sha256.Sum256(der)
+	return id[:], nil
+}
+
+// SealPayload encrypts payload, usually a serialized transaction, so that
+// only the validators holding the private keys of recipients can open it.
+// The result is the payload of a CHAIN_SEALED transaction
+func SealPayload(payload []byte, recipients []*ecdsa.PublicKey) ([]byte, error) {
+	contentKey := make([]byte, 32)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	sealed := &sealedPayload{Nonce: make([]byte, gcm.NonceSize())}
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, err
+	}
+	sealed.Ciphertext = gcm.Seal(nil, sealed.Nonce, payload, nil)
+
+	spi := ecies.NewSPI()
+	for _, pub := range recipients {
+		id, err := keyID(pub)
+		if err != nil {
+			return nil, err
+		}
+		pk, err := spi.NewPublicKey(rand.Reader, pub)
+		if err != nil {
+			return nil, err
+		}
+		c, err := spi.NewAsymmetricCipherFromPublicKey(pk)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := c.Process(contentKey)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot seal payload to validator key %x: %s", id, err)
+		}
+		sealed.Keys = append(sealed.Keys, wrappedKey{Recipient: id, Key: wrapped})
+	}
+	return asn1.Marshal(*sealed)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// payloadOpener opens the payloads sealed to the sealing key of this validator
+type payloadOpener struct {
+	key *ecdsa.PrivateKey
+	id  []byte
+}
+
+// newConfiguredOpener returns nil if peer.validator.consensus.sealing.key.file is empty
+func newConfiguredOpener() (*payloadOpener, error) {
+	file := viper.GetString("peer.validator.consensus.sealing.key.file")
+	if file == "" {
+		return nil, nil
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read sealing key: %s", err)
+	}
+	key, err := primitives.PEMtoPrivateKey(raw, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse sealing key %s: %s", file, err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Sealing key %s is not an ECDSA key", file)
+	}
+	return newPayloadOpener(ecKey)
+}
+
+func newPayloadOpener(key *ecdsa.PrivateKey) (*payloadOpener, error) {
+	id, err := keyID(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &payloadOpener{key: key, id: id}, nil
+}
+
+func (po *payloadOpener) open(raw []byte) ([]byte, error) {
+	sealed := &sealedPayload{}
+	if rest, err := asn1.Unmarshal(raw, sealed); err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("Malformed sealed payload")
+	}
+	for _, wk := range sealed.Keys {
+		if !bytes.Equal(wk.Recipient, po.id) {
+			continue
+		}
+		spi := ecies.NewSPI()
+		sk, err := spi.NewPrivateKey(rand.Reader, po.key)
+		if err != nil {
+			return nil, err
+		}
+		c, err := spi.NewAsymmetricCipherFromPrivateKey(sk)
+		if err != nil {
+			return nil, err
+		}
+		contentKey, err := c.Process(wk.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot unwrap content key of sealed payload: %s", err)
+		}
+		gcm, err := newGCM(contentKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(sealed.Nonce) != gcm.NonceSize() {
+			return nil, fmt.Errorf("Malformed sealed payload")
+		}
+		return gcm.Open(nil, sealed.Nonce, sealed.Ciphertext, nil)
+	}
+	return nil, fmt.Errorf("Payload is not sealed to the key of this validator")
+}
+
+// OpenPayload is necessary to implement consensus.PayloadOpener
+func (h *Helper) OpenPayload(sealed []byte) ([]byte, error) {
+	if h.opener == nil {
+		return nil, fmt.Errorf("No sealing key configured, cannot open sealed payloads")
+	}
+	return h.opener.open(sealed)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+func TestSealPayload(t *testing.T) {
+	primitives.InitSecurityLevel("SHA3", 256)
+	var openers []*payloadOpener
+	var recipients []*ecdsa.PublicKey
+	for i := 0; i < 3; i++ {
+		key, err := primitives.NewECDSAKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		po, err := newPayloadOpener(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		openers = append(openers, po)
+		recipients = append(recipients, &key.PublicKey)
+	}
+
+	payload := []byte("a transaction only the validators may read")
+	sealed, err := SealPayload(payload, recipients[:2])
+	if err != nil {
+		t.Fatalf("Could not seal payload: %s", err)
+	}
+	if bytes.Contains(sealed, payload) {
+		t.Fatalf("Expected the sealed payload not to reveal the payload")
+	}
+	for i, po := range openers[:2] {
+		opened, err := po.open(sealed)
+		if err != nil || !bytes.Equal(opened, payload) {
+			t.Errorf("Expected validator %d to open the payload, got %q, %v", i, opened, err)
+		}
+	}
+	if _, err := openers[2].open(sealed); err == nil {
+		t.Errorf("Expected a validator the payload is not sealed to not to be able to open it")
+	}
+
+	tampered := &sealedPayload{}
+	asn1.Unmarshal(sealed, tampered)
+	tampered.Ciphertext[0] ^= 1
+	raw, _ := asn1.Marshal(*tampered)
+	if _, err := openers[0].open(raw); err == nil {
+		t.Errorf("Expected a tampered payload not to open")
+	}
+
+	h := &Helper{}
+	if _, err := h.OpenPayload(sealed); err == nil {
+		t.Errorf("Expected a validator without sealing key to refuse sealed payloads")
+	}
+}
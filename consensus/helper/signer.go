@@ -0,0 +1,177 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+// signer signs consensus messages with the enrollment key of this validator
+type signer interface {
+	Sign(msg []byte) ([]byte, error)
+}
+
+// signingDevice holds the enrollment key of this validator outside of the
+// process, e.g. in an HSM
+type signingDevice interface {
+	openSession() (signingSession, error) // a session is used by one worker at a time
+	close()
+}
+
+// signingSession computes raw ECDSA signatures over digests with the key of its device
+type signingSession interface {
+	sign(digest []byte) (r, s *big.Int, err error)
+}
+
+var signingDevices = make(map[string]func() (signingDevice, error))
+
+// registerSigningDevice makes a kind of signing device available under name,
+// for devices which depend on libraries the peer is not always built with
+func registerSigningDevice(name string, open func() (signingDevice, error)) {
+	if _, ok := signingDevices[name]; ok {
+		panic(fmt.Errorf("Signing device %s registered twice", name))
+	}
+	signingDevices[name] = open
+}
+
+type signRequest struct {
+	msg    []byte
+	result chan<- signResult
+}
+
+type signResult struct {
+	signature []byte
+	err       error
+}
+
+// signingPool hands the signing of consensus messages to a fixed number of
+// workers, each with its own session on the device, so that the latency of
+// the device is paid concurrently rather than by every caller in turn
+type signingPool struct {
+	device   signingDevice
+	requests chan signRequest
+}
+
+func newSigningPool(device signingDevice, workers int) (*signingPool, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("Signing pool needs at least one worker, got %d", workers)
+	}
+	sp := &signingPool{
+		device:   device,
+		requests: make(chan signRequest),
+	}
+	sessions := make([]signingSession, workers)
+	for i := range sessions {
+		session, err := device.openSession()
+		if err != nil {
+			return nil, fmt.Errorf("Cannot open signing session: %s", err)
+		}
+		sessions[i] = session
+	}
+	for _, session := range sessions {
+		go sp.work(session)
+	}
+	return sp, nil
+}
+
+func (sp *signingPool) work(session signingSession) {
+	for req := range sp.requests {
+		r, s, err := session.sign(primitives.Hash(req.msg))
+		if err != nil {
+			req.result <- signResult{err: fmt.Errorf("Signing device failed: %s", err)}
+			continue
+		}
+		// Encoded as the crypto package does, so that signatures verify against the enrollment certificate
+		signature, err := asn1.Marshal(primitives.ECDSASignature{R: r, S: s})
+		req.result <- signResult{signature: signature, err: err}
+	}
+}
+
+// Sign blocks until a worker signed msg
+func (sp *signingPool) Sign(msg []byte) ([]byte, error) {
+	result := make(chan signResult, 1)
+	sp.requests <- signRequest{msg: msg, result: result}
+	res := <-result
+	return res.signature, res.err
+}
+
+// close stops the workers and releases the device, it must not sign afterwards
+func (sp *signingPool) close() {
+	close(sp.requests)
+	sp.device.close()
+}
+
+// newConfiguredSigner returns the signer configured by
+// peer.validator.consensus.signer, or nil if consensus messages are signed
+// by the crypto package with the enrollment key on disk
+func newConfiguredSigner() (*signingPool, error) {
+	device := strings.ToLower(viper.GetString("peer.validator.consensus.signer.device"))
+	if device == "" || device == "software" {
+		return nil, nil
+	}
+	open, ok := signingDevices[device]
+	if !ok {
+		return nil, fmt.Errorf("Signing device %s is not supported by this peer, it must be built with -tags %s", device, device)
+	}
+	dev, err := open()
+	if err != nil {
+		return nil, err
+	}
+	sp, err := newSigningPool(dev, viper.GetInt("peer.validator.consensus.signer.workers"))
+	if err != nil {
+		dev.close()
+		return nil, err
+	}
+	logger.Info("Consensus messages signed by %s device with %d workers", device, viper.GetInt("peer.validator.consensus.signer.workers"))
+	return sp, nil
+}
+
+// enableSigner signs consensus messages through the configured signing
+// device, after checking that the key of the device is the enrollment key
+// the other validators verify the signatures of this validator with
+func (h *Helper) enableSigner() error {
+	if !h.secOn {
+		return nil
+	}
+	sp, err := newConfiguredSigner()
+	if err != nil || sp == nil {
+		return err
+	}
+	self, err := h.coordinator.GetPeerEndpoint()
+	if err != nil {
+		sp.close()
+		return fmt.Errorf("Couldn't retrieve own endpoint: %v", err)
+	}
+	probe := []byte("consensus signing device probe")
+	signature, err := sp.Sign(probe)
+	if err == nil {
+		err = h.secHelper.Verify(self.PkiID, signature, probe)
+	}
+	if err != nil {
+		sp.close()
+		return fmt.Errorf("Signing device does not hold the enrollment key of this validator: %s", err)
+	}
+	h.signer = sp
+	return nil
+}
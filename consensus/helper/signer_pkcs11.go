@@ -0,0 +1,152 @@
+// +build pkcs11
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	registerSigningDevice("pkcs11", openPKCS11Device)
+}
+
+// pkcs11Device is a token accessed through a PKCS#11 library, holding the
+// enrollment key of this validator as a private key object
+type pkcs11Device struct {
+	ctx   *pkcs11.Ctx
+	slot  uint
+	login pkcs11.SessionHandle // keeps the application logged in
+	key   pkcs11.ObjectHandle
+}
+
+type pkcs11Session struct {
+	device  *pkcs11Device
+	session pkcs11.SessionHandle
+}
+
+func openPKCS11Device() (signingDevice, error) {
+	library := viper.GetString("peer.validator.consensus.signer.pkcs11.library")
+	label := viper.GetString("peer.validator.consensus.signer.pkcs11.label")
+	keyLabel := viper.GetString("peer.validator.consensus.signer.pkcs11.keylabel")
+
+	ctx := pkcs11.New(library)
+	if ctx == nil {
+		return nil, fmt.Errorf("Cannot load PKCS#11 library %s", library)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("Cannot initialize PKCS#11 library %s: %s", library, err)
+	}
+	dev := &pkcs11Device{ctx: ctx}
+	if err := dev.open(label, keyLabel, viper.GetString("peer.validator.consensus.signer.pkcs11.pin")); err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+	return dev, nil
+}
+
+// open logs in to the token labelled label and finds the key labelled
+// keyLabel. On failure it ends the sessions it opened, but leaves the library
+// to the caller to finalize
+func (dev *pkcs11Device) open(label, keyLabel, pin string) error {
+	slots, err := dev.ctx.GetSlotList(true)
+	if err != nil {
+		return fmt.Errorf("Cannot list PKCS#11 slots: %s", err)
+	}
+	found := false
+	for _, slot := range slots {
+		info, err := dev.ctx.GetTokenInfo(slot)
+		if err == nil && info.Label == label {
+			dev.slot, found = slot, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("No PKCS#11 token labelled %s", label)
+	}
+
+	dev.login, err = dev.ctx.OpenSession(dev.slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("Cannot open PKCS#11 session: %s", err)
+	}
+	if err := dev.ctx.Login(dev.login, pkcs11.CKU_USER, pin); err != nil {
+		dev.ctx.CloseSession(dev.login)
+		return fmt.Errorf("Cannot log in to PKCS#11 token %s: %s", label, err)
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, keyLabel),
+	}
+	if err := dev.ctx.FindObjectsInit(dev.login, template); err != nil {
+		dev.logout()
+		return fmt.Errorf("Cannot search PKCS#11 token %s: %s", label, err)
+	}
+	keys, _, err := dev.ctx.FindObjects(dev.login, 1)
+	dev.ctx.FindObjectsFinal(dev.login)
+	if err != nil || len(keys) == 0 {
+		dev.logout()
+		return fmt.Errorf("No private key labelled %s on PKCS#11 token %s", keyLabel, label)
+	}
+	dev.key = keys[0]
+	return nil
+}
+
+func (dev *pkcs11Device) openSession() (signingSession, error) {
+	session, err := dev.ctx.OpenSession(dev.slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, err
+	}
+	return &pkcs11Session{device: dev, session: session}, nil
+}
+
+// logout ends all sessions of the application, logging it out
+func (dev *pkcs11Device) logout() {
+	dev.ctx.Logout(dev.login)
+	dev.ctx.CloseAllSessions(dev.slot)
+}
+
+// close logs out and releases the library
+func (dev *pkcs11Device) close() {
+	dev.logout()
+	dev.ctx.Finalize()
+	dev.ctx.Destroy()
+}
+
+func (ps *pkcs11Session) sign(digest []byte) (*big.Int, *big.Int, error) {
+	ctx := ps.device.ctx
+	if err := ctx.SignInit(ps.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, ps.device.key); err != nil {
+		return nil, nil, err
+	}
+	raw, err := ctx.Sign(ps.session, digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	// CKM_ECDSA returns r and s concatenated, each as long as the order of the curve
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, nil, fmt.Errorf("malformed ECDSA signature of %d bytes", len(raw))
+	}
+	half := len(raw) / 2
+	return new(big.Int).SetBytes(raw[:half]), new(big.Int).SetBytes(raw[half:]), nil
+}
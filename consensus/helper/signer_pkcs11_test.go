@@ -0,0 +1,240 @@
+// +build pkcs11
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+// The tests run against the token labelled PKCS11_LABEL, with the user PIN
+// PKCS11_PIN, of the PKCS#11 library PKCS11_LIB, e.g. a SoftHSM token. They
+// generate a key of their own on it and destroy it afterwards, and are skipped
+// if PKCS11_LIB is not set
+
+// oidP256 is the DER encoding of the OID of the NIST P-256 curve, the EC
+// parameters of the generated key
+var oidP256 = []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}
+
+func pkcs11Token(t *testing.T) (library, label, pin string) {
+	library = os.Getenv("PKCS11_LIB")
+	if library == "" {
+		t.Skip("PKCS11_LIB is not set")
+	}
+	return library, os.Getenv("PKCS11_LABEL"), os.Getenv("PKCS11_PIN")
+}
+
+// withTokenSession runs f in a logged in read-write session on the token, in
+// a library context of its own, which it releases before returning
+func withTokenSession(t *testing.T, f func(ctx *pkcs11.Ctx, session pkcs11.SessionHandle)) {
+	library, label, pin := pkcs11Token(t)
+	ctx := pkcs11.New(library)
+	if ctx == nil {
+		t.Fatalf("Cannot load PKCS#11 library %s", library)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		t.Fatalf("Cannot initialize PKCS#11 library %s: %s", library, err)
+	}
+	defer func() {
+		ctx.Finalize()
+		ctx.Destroy()
+	}()
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatalf("Cannot list PKCS#11 slots: %s", err)
+	}
+	for _, slot := range slots {
+		if info, err := ctx.GetTokenInfo(slot); err != nil || info.Label != label {
+			continue
+		}
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			t.Fatalf("Cannot open PKCS#11 session: %s", err)
+		}
+		defer ctx.CloseSession(session)
+		if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil {
+			t.Fatalf("Cannot log in to PKCS#11 token %s: %s", label, err)
+		}
+		defer ctx.Logout(session)
+		f(ctx, session)
+		return
+	}
+	t.Fatalf("No PKCS#11 token labelled %s", label)
+}
+
+// generateTokenKey generates a P-256 key pair labelled keyLabel on the token
+// and returns its public key
+func generateTokenKey(t *testing.T, keyLabel string) *ecdsa.PublicKey {
+	var pub *ecdsa.PublicKey
+	withTokenSession(t, func(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) {
+		public := []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, oidP256),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, keyLabel),
+		}
+		private := []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, keyLabel),
+		}
+		pubKey, _, err := ctx.GenerateKeyPair(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)}, public, private)
+		if err != nil {
+			t.Fatalf("Cannot generate key pair: %s", err)
+		}
+		attrs, err := ctx.GetAttributeValue(session, pubKey, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+		if err != nil || len(attrs) != 1 {
+			t.Fatalf("Cannot read the public key: %v", err)
+		}
+		// the point is an uncompressed point wrapped into a DER octet string
+		var point []byte
+		if _, err := asn1.Unmarshal(attrs[0].Value, &point); err != nil {
+			t.Fatalf("Malformed EC point: %s", err)
+		}
+		x, y := elliptic.Unmarshal(elliptic.P256(), point)
+		if x == nil {
+			t.Fatalf("Malformed EC point %x", point)
+		}
+		pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	})
+	return pub
+}
+
+// destroyTokenKey removes the objects labelled keyLabel from the token
+func destroyTokenKey(t *testing.T, keyLabel string) {
+	withTokenSession(t, func(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) {
+		if err := ctx.FindObjectsInit(session, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, keyLabel)}); err != nil {
+			t.Errorf("Cannot search the token: %s", err)
+			return
+		}
+		objects, _, err := ctx.FindObjects(session, 10)
+		ctx.FindObjectsFinal(session)
+		if err != nil {
+			t.Errorf("Cannot search the token: %s", err)
+		}
+		for _, object := range objects {
+			if err := ctx.DestroyObject(session, object); err != nil {
+				t.Errorf("Cannot destroy key %s: %s", keyLabel, err)
+			}
+		}
+	})
+}
+
+func configurePKCS11(library, label, keyLabel, pin string) {
+	viper.Set("peer.validator.consensus.signer.device", "pkcs11")
+	viper.Set("peer.validator.consensus.signer.workers", 2)
+	viper.Set("peer.validator.consensus.signer.pkcs11.library", library)
+	viper.Set("peer.validator.consensus.signer.pkcs11.label", label)
+	viper.Set("peer.validator.consensus.signer.pkcs11.keylabel", keyLabel)
+	viper.Set("peer.validator.consensus.signer.pkcs11.pin", pin)
+}
+
+// signAndVerify signs messages concurrently through sp, and checks their
+// signatures against pub
+func signAndVerify(t *testing.T, sp *signingPool, pub *ecdsa.PublicKey) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := []byte(fmt.Sprintf("message %d", i))
+			signature, err := sp.Sign(msg)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if ok, err := primitives.ECDSAVerify(pub, msg, signature); !ok || err != nil {
+				errs <- fmt.Errorf("signature of message %d does not verify: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestPKCS11Device(t *testing.T) {
+	library, label, pin := pkcs11Token(t)
+	primitives.InitSecurityLevel("SHA3", 256)
+	keyLabel := fmt.Sprintf("consensus-test-%d", time.Now().UnixNano())
+	pub := generateTokenKey(t, keyLabel)
+	defer destroyTokenKey(t, keyLabel)
+	defer viper.Set("peer.validator.consensus.signer.device", "")
+
+	configurePKCS11(library, label, keyLabel, pin)
+	sp, err := newConfiguredSigner()
+	if err != nil {
+		t.Fatalf("Cannot open the PKCS#11 device: %s", err)
+	}
+	signAndVerify(t, sp, pub)
+	sp.close()
+}
+
+func TestPKCS11DeviceFailures(t *testing.T) {
+	library, label, pin := pkcs11Token(t)
+	primitives.InitSecurityLevel("SHA3", 256)
+	keyLabel := fmt.Sprintf("consensus-test-%d", time.Now().UnixNano())
+	pub := generateTokenKey(t, keyLabel)
+	defer destroyTokenKey(t, keyLabel)
+	defer viper.Set("peer.validator.consensus.signer.device", "")
+
+	for _, c := range []struct {
+		library, label, keyLabel, pin string
+		expected                      string
+	}{
+		{library + ".missing", label, keyLabel, pin, "Cannot load"},
+		{library, label + " missing", keyLabel, pin, "No PKCS#11 token"},
+		{library, label, keyLabel, pin + "0", "Cannot log in"},
+		{library, label, keyLabel + "-missing", pin, "No private key"},
+	} {
+		configurePKCS11(c.library, c.label, c.keyLabel, c.pin)
+		if _, err := newConfiguredSigner(); err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("Expected opening the device with %+v to fail with %q, got %v", c, c.expected, err)
+		}
+	}
+
+	// every failure must have released the library, else it could not be
+	// initialized again
+	configurePKCS11(library, label, keyLabel, pin)
+	sp, err := newConfiguredSigner()
+	if err != nil {
+		t.Fatalf("Cannot open the PKCS#11 device after failing to: %s", err)
+	}
+	signAndVerify(t, sp, pub)
+	sp.close()
+}
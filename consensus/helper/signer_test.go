@@ -0,0 +1,137 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+// slowDevice signs with a key in memory, taking latency per signature
+type slowDevice struct {
+	key      *ecdsa.PrivateKey
+	latency  time.Duration
+	lock     sync.Mutex
+	sessions int
+	busy     int
+	maxBusy  int
+	closed   bool
+}
+
+type slowSession struct {
+	device *slowDevice
+}
+
+func (sd *slowDevice) openSession() (signingSession, error) {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+	sd.sessions++
+	return &slowSession{sd}, nil
+}
+
+func (sd *slowDevice) close() {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+	sd.closed = true
+}
+
+func (ss *slowSession) sign(digest []byte) (*big.Int, *big.Int, error) {
+	sd := ss.device
+	sd.lock.Lock()
+	sd.busy++
+	if sd.busy > sd.maxBusy {
+		sd.maxBusy = sd.busy
+	}
+	sd.lock.Unlock()
+	defer func() {
+		sd.lock.Lock()
+		sd.busy--
+		sd.lock.Unlock()
+	}()
+	time.Sleep(sd.latency)
+	return ecdsa.Sign(rand.Reader, sd.key, digest)
+}
+
+func TestSigningPool(t *testing.T) {
+	primitives.InitSecurityLevel("SHA3", 256)
+	key, err := primitives.NewECDSAKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := &slowDevice{key: key, latency: 20 * time.Millisecond}
+	sp, err := newSigningPool(device, 4)
+	if err != nil {
+		t.Fatalf("Could not create signing pool: %s", err)
+	}
+	if device.sessions != 4 {
+		t.Errorf("Expected a session per worker, opened %d", device.sessions)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := []byte(fmt.Sprintf("message %d", i))
+			signature, err := sp.Sign(msg)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if ok, err := primitives.ECDSAVerify(&key.PublicKey, msg, signature); !ok || err != nil {
+				errs <- fmt.Errorf("signature of message %d does not verify: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if device.maxBusy < 2 || device.maxBusy > 4 {
+		t.Errorf("Expected the workers to sign concurrently, at most 4 at once, got %d", device.maxBusy)
+	}
+
+	sp.close()
+	if !device.closed {
+		t.Errorf("Expected closing the pool to close the device")
+	}
+}
+
+func TestConfiguredSigner(t *testing.T) {
+	defer viper.Set("peer.validator.consensus.signer.device", "")
+
+	viper.Set("peer.validator.consensus.signer.device", "software")
+	if sp, err := newConfiguredSigner(); sp != nil || err != nil {
+		t.Errorf("Expected the crypto package to sign by default, got %v, %v", sp, err)
+	}
+
+	viper.Set("peer.validator.consensus.signer.device", "nosuchdevice")
+	if _, err := newConfiguredSigner(); err == nil {
+		t.Errorf("Expected a signing device the peer is not built with to be rejected")
+	}
+}
@@ -35,15 +35,19 @@ var logger *logging.Logger // package-level logger
 
 func init() {
 	logger = logging.MustGetLogger("consensus/noops")
+	consensus.RegisterPlugin(&consensus.Plugin{Name: "noops", Shared: GetNoops, New: New})
 }
 
 // Noops is a plugin object implementing the consensus.Consenter interface.
 type Noops struct {
-	stack    consensus.Stack
-	txQ      *txq
-	timer    *time.Timer
-	duration time.Duration
-	channel  chan *pb.Transaction
+	stack     consensus.Stack
+	txQ       *txq
+	timer     *time.Timer
+	duration  time.Duration
+	heartbeat *time.Timer   // nil if no empty blocks are committed
+	interval  time.Duration // of the heartbeat blocks
+	channel   chan *pb.Transaction
+	exit      chan struct{}
 }
 
 // Setting up a singleton NOOPS consenter
@@ -57,6 +61,12 @@ func GetNoops(c consensus.Stack) consensus.Consenter {
 	return iNoops
 }
 
+// New creates a NOOPS consenter which is not shared with the singleton, so
+// that it can be closed when it is replaced
+func New(c consensus.Stack) consensus.Consenter {
+	return newNoops(c)
+}
+
 // newNoops is a constructor returning a consensus.Consenter object.
 func newNoops(c consensus.Stack) consensus.Consenter {
 	var err error
@@ -67,24 +77,35 @@ func newNoops(c consensus.Stack) consensus.Consenter {
 	i.stack = c
 	config := loadConfig()
 	blockSize := config.GetInt("block.size")
-	blockTimeout := config.GetString("block.timeout")
-	if _, err = strconv.Atoi(blockTimeout); err == nil {
-		blockTimeout = blockTimeout + "s" //if string does not have unit of measure, default to seconds
+	blockMaxBytes := config.GetInt("block.maxbytes")
+	if blockMaxBytes < 0 {
+		panic(fmt.Errorf("Block byte size must not be negative, got %d", blockMaxBytes))
 	}
-	i.duration, err = time.ParseDuration(blockTimeout)
+	i.duration, err = parseDuration(config.GetString("block.timeout"))
 	if err != nil || i.duration == 0 {
 		panic(fmt.Errorf("Cannot parse block timeout: %s", err))
 	}
+	if heartbeat := config.GetString("block.heartbeat"); heartbeat != "" {
+		if i.interval, err = parseDuration(heartbeat); err != nil {
+			panic(fmt.Errorf("Cannot parse block heartbeat: %s", err))
+		}
+	}
 
 	logger.Info("NOOPS consensus type = %T", i)
 	logger.Info("NOOPS block size = %v", blockSize)
+	logger.Info("NOOPS block max bytes = %v", blockMaxBytes)
 	logger.Info("NOOPS block timeout = %v", i.duration)
+	logger.Info("NOOPS block heartbeat = %v", i.interval)
 
-	i.txQ = newTXQ(blockSize)
+	i.txQ = newTXQ(blockSize, blockMaxBytes)
 
 	i.channel = make(chan *pb.Transaction, 100)
+	i.exit = make(chan struct{})
 	i.timer = time.NewTimer(i.duration) // start timer now so we can just reset it
 	i.timer.Stop()
+	if i.interval > 0 {
+		i.heartbeat = time.NewTimer(i.interval)
+	}
 	go i.handleChannels()
 	return i
 }
@@ -112,6 +133,11 @@ func (i *Noops) RecvMsg(msg *pb.Message, senderHandle *pb.PeerID) error {
 	return nil
 }
 
+// Close stops the NOOPS consenter, transactions it has not executed yet are discarded
+func (i *Noops) Close() {
+	close(i.exit)
+}
+
 // StateUpdating is called once state transfer is initiated, currently unused
 func (i *Noops) StateUpdating(seqNo uint64, id []byte) {
 	// ignored as it is never initiated
@@ -163,11 +189,27 @@ func (i *Noops) canProcessBlock(tx *pb.Transaction) bool {
 }
 
 func (i *Noops) handleChannels() {
-	// Noops is a singleton object and only exits when peer exits, so we
-	// don't need a condition to exit this loop
+	var heartbeat <-chan time.Time
+	if i.heartbeat != nil {
+		heartbeat = i.heartbeat.C
+	}
 	for {
 		select {
+		case <-i.exit:
+			i.timer.Stop()
+			if i.heartbeat != nil {
+				i.heartbeat.Stop()
+			}
+			return
 		case tx := <-i.channel:
+			if i.txQ.overflows(tx) {
+				if logger.IsEnabledFor(logging.DEBUG) {
+					logger.Debug("Process block due to byte size")
+				}
+				if err := i.processBlock(); nil != err {
+					logger.Error(err.Error())
+				}
+			}
 			if i.canProcessBlock(tx) {
 				if logger.IsEnabledFor(logging.DEBUG) {
 					logger.Debug("Process block due to size")
@@ -183,10 +225,49 @@ func (i *Noops) handleChannels() {
 			if err := i.processBlock(); nil != err {
 				logger.Error(err.Error())
 			}
+		case <-heartbeat:
+			if logger.IsEnabledFor(logging.DEBUG) {
+				logger.Debug("Process empty block due to heartbeat")
+			}
+			if err := i.processHeartbeat(); nil != err {
+				logger.Error(err.Error())
+			}
 		}
 	}
 }
 
+// processHeartbeat commits an empty block, as no transaction arrived for
+// the heartbeat interval
+func (i *Noops) processHeartbeat() error {
+	if i.txQ.size() > 0 {
+		return i.processBlock()
+	}
+	defer i.resetHeartbeat()
+	if err := i.processTransactions(); nil != err {
+		return err
+	}
+	data, delta, err := i.getBlockData()
+	if nil != err {
+		return err
+	}
+	go i.notifyBlockAdded(data, delta)
+	return nil
+}
+
+// resetHeartbeat restarts the heartbeat interval, as a block was just committed
+func (i *Noops) resetHeartbeat() {
+	if i.heartbeat == nil {
+		return
+	}
+	if !i.heartbeat.Stop() {
+		select {
+		case <-i.heartbeat.C:
+		default:
+		}
+	}
+	i.heartbeat.Reset(i.interval)
+}
+
 func (i *Noops) processBlock() error {
 	i.timer.Stop()
 
@@ -200,6 +281,7 @@ func (i *Noops) processBlock() error {
 	var delta *statemgmt.StateDelta
 	var err error
 
+	defer i.resetHeartbeat()
 	if err = i.processTransactions(); nil != err {
 		return err
 	}
@@ -244,6 +326,14 @@ func (i *Noops) processTransactions() error {
 	return nil
 }
 
+// parseDuration parses a block duration, which is in seconds if it has no unit of measure
+func parseDuration(duration string) (time.Duration, error) {
+	if _, err := strconv.Atoi(duration); err == nil {
+		duration = duration + "s"
+	}
+	return time.ParseDuration(duration)
+}
+
 func (i *Noops) getTxFromMsg(msg *pb.Message) (*pb.Transaction, error) {
 	txs := &pb.TransactionBlock{}
 	if err := proto.Unmarshal(msg.Payload, txs); err != nil {
@@ -17,21 +17,26 @@ limitations under the License.
 package noops
 
 import (
+	"github.com/golang/protobuf/proto"
+
 	pb "github.com/hyperledger/fabric/protos"
 )
 
 type txq struct {
-	i int
-	q []*pb.Transaction
+	i        int
+	q        []*pb.Transaction
+	bytes    int // marshaled size of the queued transactions
+	maxBytes int // 0 if the queue is only bounded by count
 }
 
-func newTXQ(size int) *txq {
+func newTXQ(size int, maxBytes int) *txq {
 	o := &txq{}
 	o.i = 0
 	if size < 1 {
 		size = 1
 	}
 	o.q = make([]*pb.Transaction, size)
+	o.maxBytes = maxBytes
 	return o
 }
 
@@ -39,12 +44,14 @@ func (o *txq) append(tx *pb.Transaction) {
 	if cap(o.q) > o.i {
 		o.q[o.i] = tx
 		o.i++
+		o.bytes += proto.Size(tx)
 	}
 }
 
 func (o *txq) getTXs() []*pb.Transaction {
 	length := o.i
 	o.i = 0
+	o.bytes = 0
 	return o.q[:length]
 }
 
@@ -52,13 +59,23 @@ func (o *txq) isFull() bool {
 	if cap(o.q) == o.i {
 		return true
 	}
+	if o.maxBytes > 0 && o.bytes >= o.maxBytes {
+		return true
+	}
 	return false
 }
 
+// overflows returns whether appending tx would take the queue beyond its
+// byte limit, in which case the queued transactions are cut as a block first
+func (o *txq) overflows(tx *pb.Transaction) bool {
+	return o.maxBytes > 0 && o.i > 0 && o.bytes+proto.Size(tx) > o.maxBytes
+}
+
 func (o *txq) size() int {
 	return o.i
 }
 
 func (o *txq) reset() {
 	o.i = 0
+	o.bytes = 0
 }
@@ -0,0 +1,70 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package noops
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestTXQFullByCount(t *testing.T) {
+	q := newTXQ(2, 0)
+	q.append(&pb.Transaction{Uuid: "a"})
+	if q.isFull() {
+		t.Fatalf("Expected queue of one transaction not to be full")
+	}
+	q.append(&pb.Transaction{Uuid: "b"})
+	if !q.isFull() {
+		t.Fatalf("Expected queue of two transactions to be full")
+	}
+	if txs := q.getTXs(); len(txs) != 2 || q.size() != 0 {
+		t.Fatalf("Expected to get both transactions and an empty queue, got %d, %d left", len(txs), q.size())
+	}
+}
+
+func TestTXQFullByBytes(t *testing.T) {
+	small := &pb.Transaction{Uuid: "a", Payload: make([]byte, 10)}
+	large := &pb.Transaction{Uuid: "b", Payload: make([]byte, 100)}
+	q := newTXQ(10, proto.Size(small)+proto.Size(large)-1)
+
+	if q.overflows(large) {
+		t.Fatalf("Expected a transaction never to overflow an empty queue")
+	}
+	q.append(small)
+	if q.isFull() {
+		t.Fatalf("Expected queue below its byte limit not to be full")
+	}
+	if !q.overflows(large) {
+		t.Fatalf("Expected the large transaction to overflow the queue")
+	}
+	if q.overflows(small) {
+		t.Fatalf("Expected the small transaction to fit the queue")
+	}
+	q.getTXs()
+	q.append(large)
+	q.append(small)
+	if !q.isFull() {
+		t.Fatalf("Expected queue at its byte limit to be full")
+	}
+	q.getTXs()
+	if q.isFull() || q.overflows(large) {
+		t.Fatalf("Expected byte size to reset with the queue")
+	}
+}
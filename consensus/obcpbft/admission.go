@@ -0,0 +1,216 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
+	pb "github.com/hyperledger/fabric/protos"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// anonymousClient is the identity of the transactions without certificate
+const anonymousClient = "anonymous"
+
+// bucketPruneInterval is how often the token buckets of clients which have
+// been idle long enough to refill them are dropped
+const bucketPruneInterval = time.Minute
+
+// admissionControl checks the identity of the client which submitted a
+// request against the policy of the stack, and bounds the requests of each
+// identity which are outstanding, and the rate at which each identity
+// submits them, before the request consumes ordering resources. It is only
+// used from the event thread. All methods may be called on a nil
+// admissionControl, which admits every request
+type admissionControl struct {
+	admitter    consensus.Admitter // policy of the stack, nil if it has none
+	quota       int                // requests outstanding per identity, 0 if unlimited
+	outstanding map[string]int     // requests outstanding, by identity
+	identities  map[string]string  // identity of the outstanding requests, by request digest
+
+	observer  consensus.ThrottleObserver // notified when throttling of a client engages or ends, nil if the stack does not observe it
+	clock     pbft.Clock
+	rate      float64                      // requests per second per identity, 0 if unlimited
+	burst     float64                      // requests an identity may submit at once
+	buckets   map[string]*pbft.TokenBucket // rate of each identity which submitted requests recently
+	throttled map[string]bool              // identities whose last request exceeded the rate
+	lastPrune time.Time
+}
+
+// newAdmissionControl returns nil if the stack has no admission policy and
+// general.admission.quota and general.admission.rate are 0
+func newAdmissionControl(stack interface{}, config *viper.Viper, clk pbft.Clock) (*admissionControl, error) {
+	quota := config.GetInt("general.admission.quota")
+	if quota < 0 {
+		return nil, fmt.Errorf("Admission quota must not be negative, got %d", quota)
+	}
+	rate := config.GetFloat64("general.admission.rate")
+	if rate < 0 {
+		return nil, fmt.Errorf("Admission rate must not be negative, got %v", rate)
+	}
+	burst := config.GetInt("general.admission.burst")
+	if rate > 0 && burst < 1 {
+		return nil, fmt.Errorf("Admission burst must be at least 1, got %d", burst)
+	}
+	admitter, _ := stack.(consensus.Admitter)
+	if admitter == nil && quota == 0 && rate == 0 {
+		return nil, nil
+	}
+	observer, _ := stack.(consensus.ThrottleObserver)
+	return &admissionControl{
+		admitter:    admitter,
+		quota:       quota,
+		outstanding: make(map[string]int),
+		identities:  make(map[string]string),
+		observer:    observer,
+		clock:       clk,
+		rate:        rate,
+		burst:       float64(burst),
+		buckets:     make(map[string]*pbft.TokenBucket),
+		throttled:   make(map[string]bool),
+		lastPrune:   clk.Now(),
+	}, nil
+}
+
+// certIdentity identifies the client of tx by the fingerprint of its certificate
+func certIdentity(tx *pb.Transaction) string {
+	if len(tx.Cert) == 0 {
+		return anonymousClient
+	}
+	fingerprint := sha256.Sum256(tx.Cert)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// admit returns an error if the client which submitted req may not submit
+// it, has as many requests outstanding as its quota allows, or submits
+// requests faster than the rate allows. Otherwise the request counts against
+// the quota until it is released. A request which is outstanding already is
+// admitted again without being counted twice
+func (ac *admissionControl) admit(req *pbft.Request, digest string) error {
+	if ac == nil {
+		return nil
+	}
+	if _, ok := ac.identities[digest]; ok {
+		return nil
+	}
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(req.Payload, tx); err != nil {
+		return fmt.Errorf("cannot unmarshal transaction of request %s: %s", digest, err)
+	}
+
+	identity := certIdentity(tx)
+	if ac.admitter != nil {
+		var err error
+		if identity, err = ac.admitter.Admit(tx); err != nil {
+			return fmt.Errorf("transaction %s rejected by admission policy: %s", tx.Uuid, err)
+		}
+	}
+	if ac.quota > 0 && ac.outstanding[identity] >= ac.quota {
+		return fmt.Errorf("transaction %s rejected, client %s has %d requests outstanding", tx.Uuid, identity, ac.outstanding[identity])
+	}
+	if !ac.withinRate(identity) {
+		return fmt.Errorf("transaction %s rejected, client %s exceeds %v requests per second", tx.Uuid, identity, ac.rate)
+	}
+	ac.outstanding[identity]++
+	ac.identities[digest] = identity
+	return nil
+}
+
+// withinRate takes a token from the bucket of identity, and reports to the
+// observer when the client starts or stops exceeding the rate
+func (ac *admissionControl) withinRate(identity string) bool {
+	if ac.rate == 0 {
+		return true
+	}
+	now := ac.clock.Now()
+	ac.pruneBuckets(now)
+	b, ok := ac.buckets[identity]
+	if !ok {
+		b = &pbft.TokenBucket{Tokens: ac.burst, Last: now}
+		ac.buckets[identity] = b
+	}
+	within := b.Take(now, ac.rate, ac.burst)
+	if within == !ac.throttled[identity] {
+		return within
+	}
+	if within {
+		delete(ac.throttled, identity)
+		logger.Info("Client %s no longer throttled", identity)
+	} else {
+		ac.throttled[identity] = true
+		logger.Warning("Client %s throttled, it exceeds %v requests per second", identity, ac.rate)
+	}
+	if ac.observer != nil {
+		ac.observer.Throttled(identity, !within)
+	}
+	return within
+}
+
+// pruneBuckets drops the buckets of clients which did not submit requests
+// for long enough to refill them, as a fresh bucket is equivalent. With
+// transaction certificates every request may come from a new identity
+func (ac *admissionControl) pruneBuckets(now time.Time) {
+	if now.Sub(ac.lastPrune) < bucketPruneInterval {
+		return
+	}
+	ac.lastPrune = now
+	for identity, b := range ac.buckets {
+		if ac.throttled[identity] {
+			continue
+		}
+		if b.Tokens+now.Sub(b.Last).Seconds()*ac.rate >= ac.burst {
+			delete(ac.buckets, identity)
+		}
+	}
+}
+
+// resubmit moves the accounting of a request to the request which replaces
+// it, without charging the client for it again
+func (ac *admissionControl) resubmit(oldDigest, newDigest string) {
+	if ac == nil {
+		return
+	}
+	identity, ok := ac.identities[oldDigest]
+	if !ok {
+		return
+	}
+	delete(ac.identities, oldDigest)
+	ac.identities[newDigest] = identity
+}
+
+// release stops counting the request against the quota of its client, once
+// it executed or was abandoned
+func (ac *admissionControl) release(digest string) {
+	if ac == nil {
+		return
+	}
+	identity, ok := ac.identities[digest]
+	if !ok {
+		return
+	}
+	delete(ac.identities, digest)
+	if ac.outstanding[identity]--; ac.outstanding[identity] <= 0 {
+		delete(ac.outstanding, identity)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
+	pb "github.com/hyperledger/fabric/protos"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// chaincodeAdmitter accounts transactions to the chaincode they invoke, and
+// rejects those of the chaincode "banned"
+type chaincodeAdmitter struct{}
+
+func (chaincodeAdmitter) Admit(tx *pb.Transaction) (string, error) {
+	if string(tx.ChaincodeID) == "banned" {
+		return "", fmt.Errorf("chaincode banned")
+	}
+	return string(tx.ChaincodeID), nil
+}
+
+// throttleAdmitter is a chaincodeAdmitter recording throttle notifications
+type throttleAdmitter struct {
+	chaincodeAdmitter
+	events []string
+}
+
+func (ta *throttleAdmitter) Throttled(identity string, throttled bool) {
+	ta.events = append(ta.events, fmt.Sprintf("%s %v", identity, throttled))
+}
+
+// manualClock is a pbft.Clock which only moves forward when advanced, its
+// timers never fire
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	return nil
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func admissionReq(chaincode, uuid string) (*pbft.Request, string) {
+	raw, _ := proto.Marshal(&pb.Transaction{ChaincodeID: []byte(chaincode), Uuid: uuid})
+	return &pbft.Request{Payload: raw}, uuid
+}
+
+func TestAdmissionControl(t *testing.T) {
+	config := loadConfig()
+	if ac, err := newAdmissionControl(nil, config, &manualClock{}); ac != nil || err != nil {
+		t.Fatalf("Expected admission control to be disabled without policy and quota, got %v, %v", ac, err)
+	}
+	config.Set("general.admission.quota", 2)
+	ac, err := newAdmissionControl(chaincodeAdmitter{}, config, &manualClock{})
+	if err != nil || ac == nil {
+		t.Fatalf("Expected admission control to be enabled, got %v", err)
+	}
+
+	if err := ac.admit(admissionReq("banned", "b1")); err == nil {
+		t.Errorf("Expected a request the policy rejects not to be admitted")
+	}
+	for _, uuid := range []string{"a1", "a2"} {
+		if err := ac.admit(admissionReq("a", uuid)); err != nil {
+			t.Errorf("Expected request %s within the quota to be admitted: %s", uuid, err)
+		}
+	}
+	if err := ac.admit(admissionReq("a", "a1")); err != nil {
+		t.Errorf("Expected an outstanding request to be admitted again: %s", err)
+	}
+	if err := ac.admit(admissionReq("a", "a3")); err == nil {
+		t.Errorf("Expected a request beyond the quota of its client not to be admitted")
+	}
+	if err := ac.admit(admissionReq("b", "b2")); err != nil {
+		t.Errorf("Expected the request of another client to be admitted: %s", err)
+	}
+
+	ac.release("a1")
+	ac.release("a1")
+	if ac.outstanding["a"] != 1 {
+		t.Errorf("Expected a released request to stop counting once, %d outstanding", ac.outstanding["a"])
+	}
+	if err := ac.admit(admissionReq("a", "a3")); err != nil {
+		t.Errorf("Expected a request to be admitted once another one was released: %s", err)
+	}
+}
+
+func TestAdmissionRate(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.admission.rate", 1)
+	config.Set("general.admission.burst", 0)
+	if _, err := newAdmissionControl(nil, config, &manualClock{}); err == nil {
+		t.Errorf("Expected a rate without burst to be rejected")
+	}
+	config.Set("general.admission.burst", 2)
+	vc := &manualClock{time.Unix(0, 0)}
+	ta := &throttleAdmitter{}
+	ac, err := newAdmissionControl(ta, config, vc)
+	if err != nil || ac == nil {
+		t.Fatalf("Expected admission control to be enabled, got %v", err)
+	}
+
+	for _, uuid := range []string{"a1", "a2"} {
+		if err := ac.admit(admissionReq("a", uuid)); err != nil {
+			t.Errorf("Expected request %s within the burst to be admitted: %s", uuid, err)
+		}
+	}
+	for _, uuid := range []string{"a3", "a4"} {
+		if err := ac.admit(admissionReq("a", uuid)); err == nil {
+			t.Errorf("Expected request %s beyond the burst to be throttled", uuid)
+		}
+	}
+	if err := ac.admit(admissionReq("b", "b1")); err != nil {
+		t.Errorf("Expected the request of another client to be admitted: %s", err)
+	}
+	if err := ac.admit(admissionReq("a", "a1")); err != nil {
+		t.Errorf("Expected an outstanding request to be admitted again without charge: %s", err)
+	}
+
+	ac.resubmit("a1", "a5")
+	if ac.identities["a5"] != "a" || ac.outstanding["a"] != 2 {
+		t.Errorf("Expected a resubmitted request to be accounted to its client once")
+	}
+
+	vc.advance(time.Second)
+	if err := ac.admit(admissionReq("a", "a6")); err != nil {
+		t.Errorf("Expected a request to be admitted once the bucket refilled: %s", err)
+	}
+	if expected := []string{"a true", "a false"}; fmt.Sprint(ta.events) != fmt.Sprint(expected) {
+		t.Errorf("Expected throttling to be reported as %v, got %v", expected, ta.events)
+	}
+
+	vc.advance(2 * bucketPruneInterval)
+	ac.admit(admissionReq("b", "b2"))
+	if _, ok := ac.buckets["a"]; ok {
+		t.Errorf("Expected the bucket of an idle client to be pruned")
+	}
+}
@@ -23,12 +23,13 @@ import (
 	"time"
 
 	"github.com/hyperledger/fabric/consensus/obcpbft/custodian"
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
 )
 
 // CustodyPair is a tuple of enqueued id and data object
 type CustodyPair struct {
 	Hash    string
-	Request *Request
+	Request *pbft.Request
 }
 
 // complaintHandler represents a receiver of complaints
@@ -37,7 +38,7 @@ type complaintHandler interface {
 	// or complaint timeout was reached.  The second argument
 	// specifies whether the timeout was a Custody timeout (false)
 	// or Complaint timeout (true).
-	Complain(string, *Request, bool)
+	Complain(string, *pbft.Request, bool)
 }
 
 // complainer provideds lifecycle management to ensure that Requests
@@ -54,13 +55,14 @@ type complaintHandler interface {
 type complainer struct {
 	custody    *custodian.Custodian
 	complaints *custodian.Custodian
+	digest     pbft.DigestProvider
 
 	h complaintHandler
 }
 
 // newComplainer creates a new complainer.
-func newComplainer(h complaintHandler, custodyTimeout time.Duration, complaintTimeout time.Duration) *complainer {
-	c := &complainer{}
+func newComplainer(h complaintHandler, digest pbft.DigestProvider, custodyTimeout time.Duration, complaintTimeout time.Duration) *complainer {
+	c := &complainer{digest: digest}
 	c.custody = custodian.New(custodyTimeout, c.custodyTimeout)
 	c.complaints = custodian.New(complaintTimeout, c.complaintTimeout)
 	c.h = h
@@ -78,8 +80,8 @@ func (c *complainer) Stop() {
 // custody timeout expires, the complaintHandler will be invoked with
 // the bool argument set to false.  The Request stays in custody until
 // Success() is called.
-func (c *complainer) Custody(req *Request) string {
-	hash := hashReq(req)
+func (c *complainer) Custody(req *pbft.Request) string {
+	hash := pbft.HashReq(c.digest, req)
 	c.custody.Register(hash, req)
 	return hash
 }
@@ -87,7 +89,7 @@ func (c *complainer) Custody(req *Request) string {
 // custodyTimeout is the callback from the Custodian for requests that
 // are in custody.
 func (c *complainer) custodyTimeout(hash string, reqParam interface{}) {
-	req := reqParam.(*Request)
+	req := reqParam.(*pbft.Request)
 	c.custody.Register(hash, req)
 	c.h.Complain(hash, req, false)
 }
@@ -96,8 +98,8 @@ func (c *complainer) custodyTimeout(hash string, reqParam interface{}) {
 // When the complaint timeout expires, the complaintHandler will be
 // invoked with the bool argument set to true.  The Request is removed
 // from the complaint queue once the timeout expires.
-func (c *complainer) Complaint(req *Request) string {
-	hash := hashReq(req)
+func (c *complainer) Complaint(req *pbft.Request) string {
+	hash := pbft.HashReq(c.digest, req)
 	c.complaints.Register(hash, req)
 	return hash
 }
@@ -105,13 +107,13 @@ func (c *complainer) Complaint(req *Request) string {
 // complaintTimeout is the callback from the Custodian for requests
 // that are in the complaint queue.
 func (c *complainer) complaintTimeout(hash string, reqParam interface{}) {
-	req := reqParam.(*Request)
+	req := reqParam.(*pbft.Request)
 	c.h.Complain(hash, req, true)
 }
 
 // Success removes a Request from both custody and complaint queues.
-func (c *complainer) Success(req *Request) {
-	hash := hashReq(req)
+func (c *complainer) Success(req *pbft.Request) {
+	hash := pbft.HashReq(c.digest, req)
 	c.SuccessHash(hash)
 }
 
@@ -123,8 +125,8 @@ func (c *complainer) SuccessHash(hash string) {
 }
 
 // InCustody returns true if a request is currently in custody
-func (c *complainer) InCustody(req *Request) bool {
-	hash := hashReq(req)
+func (c *complainer) InCustody(req *pbft.Request) bool {
+	hash := pbft.HashReq(c.digest, req)
 	return c.custody.InCustody(hash)
 }
 
@@ -132,7 +134,7 @@ func (c *complainer) InCustody(req *Request) bool {
 func (c *complainer) CustodyElements() []CustodyPair {
 	var ret []CustodyPair
 	for _, pair := range c.custody.Elements() {
-		ret = append(ret, CustodyPair{pair.ID, pair.Data.(*Request)})
+		ret = append(ret, CustodyPair{pair.ID, pair.Data.(*pbft.Request)})
 	}
 	return ret
 }
@@ -149,7 +151,7 @@ func (c *complainer) Restart() []CustodyPair {
 	}
 
 	for _, pair := range c.complaints.RemoveAll() {
-		reqs = append(reqs, CustodyPair{pair.ID, pair.Data.(*Request)})
+		reqs = append(reqs, CustodyPair{pair.ID, pair.Data.(*pbft.Request)})
 	}
 
 	return reqs
@@ -18,6 +18,8 @@ package obcpbft
 
 import (
 	"time"
+
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
 )
 
 // deduplicator maintains the most recent Request timestamp for each
@@ -41,7 +43,7 @@ func newDeduplicator() *deduplicator {
 // replica.  If the request is older than any previously received or
 // executed request, Request() will return false, indicating a stale
 // request.
-func (d *deduplicator) Request(req *Request) bool {
+func (d *deduplicator) Request(req *pbft.Request) bool {
 	reqTime := time.Unix(req.Timestamp.Seconds, int64(req.Timestamp.Nanos))
 	if !reqTime.After(d.reqTimestamps[req.ReplicaId]) ||
 		!reqTime.After(d.execTimestamps[req.ReplicaId]) {
@@ -55,7 +57,7 @@ func (d *deduplicator) Request(req *Request) bool {
 // replica.  If the request is older than any previously executed
 // request from the same replica, Execute() will return false,
 // indicating a stale request.
-func (d *deduplicator) Execute(req *Request) bool {
+func (d *deduplicator) Execute(req *pbft.Request) bool {
 	reqTime := time.Unix(req.Timestamp.Seconds, int64(req.Timestamp.Nanos))
 	if !reqTime.After(d.execTimestamps[req.ReplicaId]) {
 		return false
@@ -66,7 +68,17 @@ func (d *deduplicator) Execute(req *Request) bool {
 
 // IsNew returns true if this Request is newer than any previously
 // executed request of the submitting replica.
-func (d *deduplicator) IsNew(req *Request) bool {
+func (d *deduplicator) IsNew(req *pbft.Request) bool {
 	reqTime := time.Unix(req.Timestamp.Seconds, int64(req.Timestamp.Nanos))
 	return reqTime.After(d.execTimestamps[req.ReplicaId])
 }
+
+// fork returns a copy of the deduplicator, which checks requests against
+// the executed requests without updating the original
+func (d *deduplicator) fork() *deduplicator {
+	f := newDeduplicator()
+	for replica, t := range d.execTimestamps {
+		f.execTimestamps[replica] = t
+	}
+	return f
+}
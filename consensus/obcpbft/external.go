@@ -17,6 +17,7 @@ limitations under the License.
 package obcpbft
 
 import (
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -28,30 +29,38 @@ import (
 // --------------------------------------------------------------
 
 type externalEventReceiver struct {
-	manager eventManager
+	manager        pbft.EventManager
+	validationPool *validationPool // validates messages before they are queued, if not nil
 }
 
 // RecvMsg is called by the stack when a new message is received
 func (eer *externalEventReceiver) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
-	eer.manager.queue() <- batchMessageEvent{
-		msg:    ocMsg,
-		sender: senderHandle,
+	if eer.validationPool != nil {
+		eer.validationPool.submit(pbft.BatchMessageEvent{
+			Msg:    ocMsg,
+			Sender: senderHandle,
+		})
+		return nil
+	}
+	eer.manager.Queue() <- pbft.BatchMessageEvent{
+		Msg:    ocMsg,
+		Sender: senderHandle,
 	}
 	return nil
 }
 
 // StateUpdated is a signal from the stack that it has fast-forwarded its state
 func (eer *externalEventReceiver) StateUpdated(seqNo uint64, id []byte) {
-	eer.manager.queue() <- stateUpdatedEvent{
-		seqNo: seqNo,
-		id:    id,
+	eer.manager.Queue() <- pbft.StateUpdatedEvent{
+		SeqNo: seqNo,
+		ID:    id,
 	}
 }
 
 // StateUpdating is a signal from the stack that state transfer has started
 func (eer *externalEventReceiver) StateUpdating(seqNo uint64, id []byte) {
-	eer.manager.queue() <- stateUpdatingEvent{
-		seqNo: seqNo,
-		id:    id,
+	eer.manager.Queue() <- pbft.StateUpdatingEvent{
+		SeqNo: seqNo,
+		ID:    id,
 	}
 }
@@ -16,79 +16,19 @@ limitations under the License.
 
 package obcpbft
 
+import (
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
+)
+
 // --------------------------------------------------------
 //
-// legacyPbftShim is a temporary measure to allow the non-batch
+// legacyGenericShim is a temporary measure to allow the non-batch
 // plugins to continue to function until they are completely
 // deprecated
 //
 // --------------------------------------------------------
 
-import (
-	"fmt"
-
-	"github.com/golang/protobuf/proto"
-)
-
 type legacyGenericShim struct {
 	obcGeneric
-	pbft legacyPbftShim
-}
-
-type legacyPbftShim struct {
-	*pbftCore
-}
-
-// stateUpdated is an event telling us that the application fast-forwarded its state
-func (instance legacyPbftShim) stateUpdated(seqNo uint64, id []byte) {
-	logger.Debug("Replica %d queueing message that it has caught up via state transfer", instance.id)
-	instance.manager.queue() <- stateUpdatedEvent{
-		seqNo: seqNo,
-		id:    id,
-	}
-}
-
-// stateUpdating is an event telling us that the application is fast-forwarding its state
-func (instance legacyPbftShim) stateUpdating(seqNo uint64, id []byte) {
-	logger.Debug("Replica %d queueing message that state transfer has been initiated", instance.id)
-	instance.manager.queue() <- stateUpdatingEvent{
-		seqNo: seqNo,
-		id:    id,
-	}
-}
-
-// handle new consensus requests
-func (instance legacyPbftShim) request(msgPayload []byte, senderID uint64) error {
-	msg := &Message{&Message_Request{&Request{Payload: msgPayload,
-		ReplicaId: senderID}}}
-	instance.manager.queue() <- pbftMessageEvent{
-		sender: senderID,
-		msg:    msg,
-	}
-	return nil
-}
-
-// handle internal consensus messages
-func (instance legacyPbftShim) receive(msgPayload []byte, senderID uint64) error {
-	msg := &Message{}
-	err := proto.Unmarshal(msgPayload, msg)
-	if err != nil {
-		return fmt.Errorf("Error unpacking payload from message: %s", err)
-	}
-
-	instance.manager.queue() <- pbftMessageEvent{
-		msg:    msg,
-		sender: senderID,
-	}
-
-	return nil
-}
-
-// TODO, this should not return an error
-func (instance legacyPbftShim) recvMsgSync(msg *Message, senderID uint64) (err error) {
-	instance.manager.queue() <- pbftMessageEvent{
-		msg:    msg,
-		sender: senderID,
-	}
-	return nil
+	pbft pbft.LegacyShim
 }
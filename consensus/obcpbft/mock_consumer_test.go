@@ -21,6 +21,8 @@ import (
 	"time"
 
 	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
+	"github.com/hyperledger/fabric/consensus/testkit"
 	pb "github.com/hyperledger/fabric/protos"
 
 	"github.com/golang/protobuf/proto"
@@ -28,32 +30,32 @@ import (
 )
 
 type consumerEndpoint struct {
-	*testEndpoint
+	*testkit.NetworkEndpoint
 	consumer     pbftConsumer
 	execTxResult func([]*pb.Transaction) ([]byte, error)
 }
 
-func (ce *consumerEndpoint) stop() {
+func (ce *consumerEndpoint) Stop() {
 	ce.consumer.Close()
 }
 
-func (ce *consumerEndpoint) isBusy() bool {
-	pbft := ce.consumer.getPBFTCore()
-	if pbft.timerActive || pbft.skipInProgress || pbft.currentExec != nil {
-		ce.net.debugMsg("Reporting busy because of timer (%v) or skipInProgress (%v) or currentExec (%v)\n", pbft.timerActive, pbft.skipInProgress, pbft.currentExec)
+func (ce *consumerEndpoint) IsBusy() bool {
+	core := ce.consumer.getPBFTCore()
+	if core.TimerActive || core.SkipInProgress || core.CurrentExec != nil {
+		ce.Net.DebugMsg("Reporting busy because of timer (%v) or skipInProgress (%v) or currentExec (%v)\n", core.TimerActive, core.SkipInProgress, core.CurrentExec)
 		return true
 	}
 
 	select {
 	case <-ce.consumer.idleChannel():
 	default:
-		ce.net.debugMsg("Reporting busy because consumer not idle\n")
+		ce.Net.DebugMsg("Reporting busy because consumer not idle\n")
 		return true
 	}
 
 	select {
-	case ce.consumer.getPBFTCore().manager.queue() <- nil:
-		ce.net.debugMsg("Reporting busy because pbft not idle\n")
+	case ce.consumer.getPBFTCore().Manager.Queue() <- nil:
+		ce.Net.DebugMsg("Reporting busy because pbft not idle\n")
 	default:
 		return true
 	}
@@ -62,7 +64,7 @@ func (ce *consumerEndpoint) isBusy() bool {
 	return false
 }
 
-func (ce *consumerEndpoint) deliver(msg []byte, senderHandle *pb.PeerID) {
+func (ce *consumerEndpoint) Deliver(msg []byte, senderHandle *pb.PeerID) {
 	ce.consumer.RecvMsg(&pb.Message{Type: pb.Message_CONSENSUS, Payload: msg}, senderHandle)
 }
 
@@ -87,27 +89,27 @@ func (cs *completeStack) SkipTo(tag uint64, id []byte, peers []*pb.PeerID) {
 			cs.consumer.StateUpdating(tag, id)
 			// State transfer takes time, not simulating this hides bugs
 			time.Sleep(time.Duration((MaxStateTransferTime/2)+rand.Intn(MaxStateTransferTime/2)) * time.Millisecond)
-			meta := &Metadata{tag}
+			meta := &pbft.Metadata{SeqNo: tag}
 			metaRaw, _ := proto.Marshal(meta)
 			cs.simulateStateTransfer(metaRaw, id, peers)
 			cs.consumer.StateUpdated(tag, id)
 			<-cs.skipTarget // Basically like releasing a mutex
 		}()
 	default:
-		cs.net.debugMsg("Ignoring skipTo because one is already in progress\n")
+		cs.Net.DebugMsg("Ignoring skipTo because one is already in progress\n")
 	}
 }
 
 type pbftConsumer interface {
-	innerStack
+	pbft.InnerStack
 	consensus.Consenter
-	getPBFTCore() *pbftCore
+	getPBFTCore() *pbft.PbftCore
 	Close()
 	idleChannel() <-chan struct{}
 }
 
 type consumerNetwork struct {
-	*testnet
+	*testkit.Network
 	mockLedgers []*MockLedger
 }
 
@@ -122,13 +124,14 @@ func (cnet *consumerNetwork) GetLedgerByPeerID(peerID *pb.PeerID) (consensus.Rea
 func makeConsumerNetwork(N int, makeConsumer func(id uint64, config *viper.Viper, stack consensus.Stack) pbftConsumer, initFNs ...func(*consumerEndpoint)) *consumerNetwork {
 	twl := consumerNetwork{mockLedgers: make([]*MockLedger, N)}
 
-	endpointFunc := func(id uint64, net *testnet) endpoint {
-		tep := makeTestEndpoint(id, net)
+	endpointFunc := func(id uint64, net *testkit.Network) testkit.Endpoint {
+		tep := testkit.NewNetworkEndpoint(id, net)
 		ce := &consumerEndpoint{
-			testEndpoint: tep,
+			NetworkEndpoint: tep,
 		}
 
 		ml := NewMockLedger(&twl)
+		ml.ce = ce
 		twl.mockLedgers[id] = ml
 
 		cs := &completeStack{
@@ -140,7 +143,7 @@ func makeConsumerNetwork(N int, makeConsumer func(id uint64, config *viper.Viper
 
 		ce.consumer = makeConsumer(id, loadConfig(), cs)
 		ce.consumer.getPBFTCore().N = N
-		ce.consumer.getPBFTCore().f = (N - 1) / 3
+		ce.consumer.getPBFTCore().F = (N - 1) / 3
 
 		for _, fn := range initFNs {
 			fn(ce)
@@ -149,6 +152,19 @@ func makeConsumerNetwork(N int, makeConsumer func(id uint64, config *viper.Viper
 		return ce
 	}
 
-	twl.testnet = makeTestnet(N, endpointFunc)
+	twl.Network = testkit.NewNetwork(N, endpointFunc)
+	twl.Classify = pbft.ClassifyMessage
+	twl.Attach(testkit.SafetyMonitors()...)
+	for _, ep := range twl.Endpoints {
+		id := int(ep.(*consumerEndpoint).ID)
+		ep.(*consumerEndpoint).consumer.getPBFTCore().ReportState(func(state *pbft.ReplicaState) {
+			twl.ReportState(id, testkit.ReplicaState{
+				View:         state.View,
+				LowWatermark: state.LowWatermark,
+				LastExec:     state.LastExec,
+				Epoch:        state.Epoch,
+			})
+		})
+	}
 	return &twl
 }
@@ -226,6 +226,15 @@ func (mock *MockLedger) GetBlockchainSize() uint64 {
 	return mock.blockHeight
 }
 
+// QueryTx answers a query with the queried payload at the current height
+func (mock *MockLedger) QueryTx(tx *protos.Transaction) ([]byte, error) {
+	mock.mutex.Lock()
+	defer func() {
+		mock.mutex.Unlock()
+	}()
+	return []byte(fmt.Sprintf("%d:%s", mock.blockHeight, tx.Payload)), nil
+}
+
 func (mock *MockLedger) GetBlock(id uint64) (*protos.Block, error) {
 	mock.mutex.Lock()
 	defer func() {
@@ -296,6 +305,7 @@ func (mock *MockLedger) simulateStateTransfer(meta []byte, id []byte, peers []*p
 			continue
 		}
 
+		block = proto.Clone(block).(*protos.Block) // do not modify the remote ledger
 		block.ConsensusMetadata = meta
 		mock.blocks[n] = block
 	}
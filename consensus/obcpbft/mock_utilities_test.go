@@ -18,14 +18,14 @@ package obcpbft
 
 import (
 	"fmt"
+	gp "google/protobuf"
 	"math/rand"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
-
 	pb "github.com/hyperledger/fabric/protos"
-	gp "google/protobuf"
+
+	"github.com/golang/protobuf/proto"
 )
 
 type noopSecurity struct{}
@@ -95,23 +95,16 @@ func createOcMsgWithChainTx(iter int64) (msg *pb.Message) {
 	return
 }
 
-// Create a message of type `Message_CHAIN_TRANSACTION`
-func createPbftRequestWithChainTx(iter int64, replica uint64) (msg *Request) {
-	txTime := &gp.Timestamp{Seconds: iter, Nanos: 0}
-	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY,
-		Timestamp: txTime,
+func createInvokeTx(iter int64) []byte {
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE,
+		Timestamp: &gp.Timestamp{Seconds: iter, Nanos: 0},
 		Payload:   []byte(fmt.Sprint(iter)),
 	}
 	txPacked, _ := proto.Marshal(tx)
-
-	msg = &Request{
-		Timestamp: txTime,
-		ReplicaId: replica,
-		Payload:   txPacked,
-	}
-	return
+	return txPacked
 }
 
+// Create a message of type `Message_CHAIN_TRANSACTION`
 func generateBroadcaster(validatorCount int) (requestBroadcaster int) {
 	seed := rand.NewSource(time.Now().UnixNano())
 	rndm := rand.New(seed)
@@ -153,25 +146,11 @@ type omniProto struct {
 	DelStateImpl               func(key string)
 	ValidateStateImpl          func()
 	InvalidateStateImpl        func()
-
-	// Inner Stack methods
-	broadcastImpl       func(msgPayload []byte)
-	unicastImpl         func(msgPayload []byte, receiverID uint64) (err error)
-	executeImpl         func(seqNo uint64, txRaw []byte)
-	getStateImpl        func() []byte
-	skipToImpl          func(seqNo uint64, snapshotID []byte, peers []uint64)
-	validateImpl        func(txRaw []byte) error
-	viewChangeImpl      func(curView uint64)
-	signImpl            func(msg []byte) ([]byte, error)
-	verifyImpl          func(senderID uint64, signature []byte, message []byte) error
-	getLastSeqNoImpl    func() (uint64, error)
-	validateStateImpl   func()
-	invalidateStateImpl func()
+	QueryTxImpl                func(tx *pb.Transaction) ([]byte, error)
 
 	// Closable Consenter methods
 	RecvMsgImpl func(ocMsg *pb.Message, senderHandle *pb.PeerID) error
 	CloseImpl   func()
-	deliverImpl func([]byte, *pb.PeerID)
 
 	// Orderer methods
 	ValidateImpl func(seqNo uint64, id []byte) (commit bool, correctedID []byte, peerIDs []*pb.PeerID)
@@ -361,67 +340,6 @@ func (op *omniProto) GetRemoteStateDeltas(replicaID *pb.PeerID, start, finish ui
 	panic("Unimplemented")
 }
 
-func (op *omniProto) broadcast(msgPayload []byte) {
-	if nil != op.broadcastImpl {
-		op.broadcastImpl(msgPayload)
-		return
-	}
-
-	panic("Unimplemented")
-}
-func (op *omniProto) unicast(msgPayload []byte, receiverID uint64) (err error) {
-	if nil != op.unicastImpl {
-		return op.unicastImpl(msgPayload, receiverID)
-	}
-
-	panic("Unimplemented")
-}
-func (op *omniProto) execute(seqNo uint64, txRaw []byte) {
-	if nil != op.executeImpl {
-		op.executeImpl(seqNo, txRaw)
-		return
-	}
-
-	panic("Unimplemented")
-}
-func (op *omniProto) skipTo(seqNo uint64, snapshotID []byte, peers []uint64) {
-	if nil != op.skipToImpl {
-		op.skipToImpl(seqNo, snapshotID, peers)
-		return
-	}
-
-	panic("Unimplemented")
-}
-func (op *omniProto) validate(txRaw []byte) error {
-	if nil != op.validateImpl {
-		return op.validateImpl(txRaw)
-	}
-
-	panic("Unimplemented")
-}
-func (op *omniProto) viewChange(curView uint64) {
-	if nil != op.viewChangeImpl {
-		op.viewChangeImpl(curView)
-		return
-	}
-
-	panic("Unimplemented")
-}
-func (op *omniProto) sign(msg []byte) ([]byte, error) {
-	if nil != op.signImpl {
-		return op.signImpl(msg)
-	}
-
-	panic("Unimplemented")
-}
-func (op *omniProto) verify(senderID uint64, signature []byte, message []byte) error {
-	if nil != op.verifyImpl {
-		return op.verifyImpl(senderID, signature, message)
-	}
-
-	panic("Unimplemented")
-}
-
 func (op *omniProto) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
 	if nil != op.RecvMsgImpl {
 		return op.RecvMsgImpl(ocMsg, senderHandle)
@@ -430,14 +348,6 @@ func (op *omniProto) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
 	panic("Unimplemented")
 }
 
-func (op *omniProto) getLastSeqNo() (uint64, error) {
-	if op.getLastSeqNoImpl != nil {
-		return op.getLastSeqNoImpl()
-	}
-
-	return 0, fmt.Errorf("getLastSeqNo is not implemented")
-}
-
 func (op *omniProto) Close() {
 	if nil != op.CloseImpl {
 		op.CloseImpl()
@@ -465,22 +375,6 @@ func (op *omniProto) SkipTo(seqNo uint64, meta []byte, id []*pb.PeerID) {
 	panic("Unimplemented")
 }
 
-func (op *omniProto) deliver(msg []byte, target *pb.PeerID) {
-	if nil != op.deliverImpl {
-		op.deliverImpl(msg, target)
-	}
-
-	panic("Unimplemented")
-}
-
-func (op *omniProto) getState() []byte {
-	if nil != op.getStateImpl {
-		return op.getStateImpl()
-	}
-
-	panic("Unimplemented")
-}
-
 func (op *omniProto) ReadState(key string) ([]byte, error) {
 	if nil != op.ReadStateImpl {
 		return op.ReadStateImpl(key)
@@ -524,18 +418,9 @@ func (op *omniProto) InvalidateState() {
 	panic("unimplemented")
 }
 
-func (op *omniProto) validateState() {
-	if nil != op.validateStateImpl {
-		op.validateStateImpl()
-		return
-	}
-	panic("unimplemented")
-}
-
-func (op *omniProto) invalidateState() {
-	if nil != op.invalidateStateImpl {
-		op.invalidateStateImpl()
-		return
+func (op *omniProto) QueryTx(tx *pb.Transaction) ([]byte, error) {
+	if nil != op.QueryTxImpl {
+		return op.QueryTxImpl(tx)
 	}
 	panic("unimplemented")
 }
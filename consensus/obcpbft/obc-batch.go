@@ -18,34 +18,45 @@ package obcpbft
 
 import (
 	"fmt"
+	google_protobuf "google/protobuf"
+	"io"
 	"time"
 
 	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
+	"github.com/hyperledger/fabric/consensus/orderer"
 	pb "github.com/hyperledger/fabric/protos"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/spf13/viper"
-	google_protobuf "google/protobuf"
 )
 
 type obcBatch struct {
 	obcGeneric
 	externalEventReceiver
-	pbft *pbftCore
+	pbft *pbft.PbftCore
 
 	batchSize        int
-	batchStore       []*Request
-	batchTimer       eventTimer
+	batchMaxBytes    int
+	batchStore       []*pbft.Request
+	batchBytes       int // marshaled size of the requests in batchStore
+	batchTimer       pbft.EventTimer
 	batchTimerActive bool
 	batchTimeout     time.Duration
 	inViewChange     bool
 
-	incomingChan chan *batchMessage // Queues messages for processing by main thread
-	idleChan     chan struct{}      // Idle channel, to be removed
+	incomingChan chan *pbft.BatchMessageEvent // Queues messages for processing by main thread
+	idleChan     chan struct{}                // Idle channel, to be removed
 
 	complainer   *complainer
 	deduplicator *deduplicator
 
+	speculative *speculativeBlock // block executed ahead of its commit certificate, its transaction batch is still open
+
+	ordering *orderer.Server // serves the ordered blocks to the peers executing them, in the standalone ordering role
+
+	admission *admissionControl // checks the clients submitting requests, nil if all are admitted
+
 	persistForward
 }
 
@@ -55,16 +66,28 @@ type custodyInfo struct {
 	complaint bool
 }
 
-type batchMessage struct {
-	msg    *pb.Message
-	sender *pb.PeerID
+// validatedBatchMessageEvent is sent when a consensus message has been
+// unmarshaled and verified by the validation pool
+type validatedBatchMessageEvent struct {
+	batchMsg *pbft.BatchMessage
+	sender   *pb.PeerID
+	pbftMsg  *pbft.Message   // the unmarshaled pbft message, if batchMsg carries one
+	senderID uint64          // the replica which sent pbftMsg
+	verified []pbft.Signable // the signed messages of pbftMsg which have been verified
 }
 
 type execInfo struct {
 	seqNo uint64
 	raw   []byte
+	txs   chan []*pb.Transaction // receives the transactions to execute, closed if the block is invalid
 }
 
+// complaintEvent is sent when custody has a complaint
+type complaintEvent custodyInfo
+
+// batchExecEvent is sent when a batch execution should take place
+type batchExecEvent execInfo
+
 func newObcBatch(id uint64, config *viper.Viper, stack consensus.Stack) *obcBatch {
 	var err error
 
@@ -76,73 +99,237 @@ func newObcBatch(id uint64, config *viper.Viper, stack consensus.Stack) *obcBatc
 
 	logger.Debug("Replica %d obtaining startup information", id)
 
-	op.pbft = newPbftCore(id, config, op)
-	op.pbft.manager = newEventManagerImpl(op) // TODO, this is hacky, eventually rip it out
-	etf := newEventTimerFactoryImpl(op.pbft.manager)
-	op.pbft.newViewTimer.halt()
-	op.pbft.newViewTimer = etf.createTimer()
-	op.pbft.manager.start()
-	op.externalEventReceiver.manager = op.pbft.manager
+	op.pbft = pbft.NewPbftCore(id, config, op, pbft.WithReceiver(op))
+	op.pbft.Manager.Start()
+	op.externalEventReceiver.manager = op.pbft.Manager
+	if workers := config.GetInt("general.validationworkers"); workers > 0 {
+		logger.Info("Batch replica %d validating messages with %d workers", id, workers)
+		op.validationPool = newValidationPool(workers, op.validateMessage, op.pbft.Manager)
+	}
+
+	if config.GetBool("general.ordering.standalone") {
+		if config.GetBool("general.speculative") {
+			panic(fmt.Errorf("Speculative execution cannot be combined with the standalone ordering role"))
+		}
+		op.ordering = orderer.NewServer(op.order, config.GetInt("general.ordering.retain"))
+		if err := op.ordering.Listen(config.GetString("general.ordering.address")); err != nil {
+			panic(err)
+		}
+		logger.Info("Batch replica %d running in the standalone ordering role, it does not execute requests", id)
+	}
 
 	op.batchSize = config.GetInt("general.batchSize")
+	op.batchMaxBytes = config.GetInt("general.batchmaxbytes")
+	if op.batchMaxBytes < 0 {
+		panic(fmt.Errorf("Maximum batch size in bytes must not be negative, got %d", op.batchMaxBytes))
+	}
+	if op.batchMaxBytes > 0 {
+		logger.Info("Batch replica %d cutting batches at %d bytes", id, op.batchMaxBytes)
+	}
 	op.batchStore = nil
 	op.batchTimeout, err = time.ParseDuration(config.GetString("general.timeout.batch"))
 	if err != nil {
 		panic(fmt.Errorf("Cannot parse batch timeout: %s", err))
 	}
 
-	op.incomingChan = make(chan *batchMessage)
+	op.incomingChan = make(chan *pbft.BatchMessageEvent)
 
-	op.complainer = newComplainer(op, op.pbft.requestTimeout, op.pbft.requestTimeout)
+	op.complainer = newComplainer(op, op.pbft.Digest, op.pbft.RequestTimeout, op.pbft.RequestTimeout)
 	op.deduplicator = newDeduplicator()
+	op.admission, err = newAdmissionControl(stack, config, op.pbft.Clock)
+	if err != nil {
+		panic(err)
+	}
 
-	op.batchTimer = etf.createTimer()
+	op.batchTimer = op.pbft.CreateTimer()
 
 	op.idleChan = make(chan struct{})
 	close(op.idleChan) // TODO remove eventually
 
+	op.pbft.Manager.Queue() <- pbft.RestoredEvent{}
+
 	return op
 }
 
 // Complain is necessary to implement complaintHandler
-func (op *obcBatch) Complain(hash string, req *Request, primaryFail bool) {
+func (op *obcBatch) Complain(hash string, req *pbft.Request, primaryFail bool) {
 	c := complaintEvent{hash, req, primaryFail}
-	op.pbft.manager.queue() <- c
+	op.pbft.Manager.Queue() <- c
 }
 
 // Close tells us to release resources we are holding
 func (op *obcBatch) Close() {
+	if op.validationPool != nil {
+		op.validationPool.halt()
+	}
 	op.complainer.Stop()
-	op.batchTimer.stop()
-	op.pbft.close()
+	op.batchTimer.Stop()
+	if op.ordering != nil {
+		op.ordering.Close()
+	}
+	op.pbft.Close()
+}
+
+// Query executes a read-only transaction on all replicas, without ordering
+// it, and returns the result once f+1 replicas agree on it
+func (op *obcBatch) Query(tx *pb.Transaction) ([]byte, error) {
+	txRaw, err := proto.Marshal(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal query transaction: %s", err)
+	}
+	return op.pbft.SubmitQuery(txRaw)
 }
 
-func (op *obcBatch) submitToLeader(req *Request) {
+// Overloaded is necessary to implement consensus.Throttler
+func (op *obcBatch) Overloaded() bool {
+	return op.pbft.Ingress.Overloaded()
+}
+
+// order submits a transaction which a peer of the ordering service received
+func (op *obcBatch) order(tx *pb.Transaction) error {
+	if op.Overloaded() {
+		return fmt.Errorf("Batch replica %d is overloaded", op.pbft.ID)
+	}
+	raw, err := proto.Marshal(tx)
+	if err != nil {
+		return err
+	}
+	return op.RecvMsg(&pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: raw}, nil)
+}
+
+// AuditTrail is necessary to implement consensus.Auditor
+func (op *obcBatch) AuditTrail(epoch, from, to uint64) ([][]byte, error) {
+	return op.pbft.GetAuditTrail(epoch, from, to)
+}
+
+// RotateSessionKeys is necessary to implement consensus.Rekeyer
+func (op *obcBatch) RotateSessionKeys() error {
+	return op.pbft.RequestSessionKeyRotation()
+}
+
+// Inspect is necessary to implement consensus.Inspector
+func (op *obcBatch) Inspect() (*consensus.ReplicaState, error) {
+	return replicaState(op.pbft.RequestInspection())
+}
+
+// RequestStatus is necessary to implement consensus.StatusQuerier. A
+// transaction is queued while it waits for the primary to cut a batch, or
+// while the replica holds it in custody until it is pre-prepared
+func (op *obcBatch) RequestStatus(uuid string) (*consensus.RequestStatus, error) {
+	match := matchTransaction(uuid)
+	done := make(chan *consensus.RequestStatus, 1)
+	op.pbft.Inject(func() {
+		status := op.pbft.RequestStatus(func(payload []byte) bool {
+			reqBatch := &pbft.RequestBlock{}
+			if err := proto.Unmarshal(payload, reqBatch); err != nil {
+				return false
+			}
+			for _, req := range reqBatch.Requests {
+				if match(req.Payload) {
+					return true
+				}
+			}
+			return false
+		})
+		if status.Phase == pbft.RequestUnknown && op.queued(match) {
+			status.Phase = pbft.RequestQueued
+		}
+		done <- requestStatus(status)
+	})
+	return <-done, nil
+}
+
+// queued returns whether a request matching waits in the pending batch or in
+// custody
+func (op *obcBatch) queued(match func(payload []byte) bool) bool {
+	for _, req := range op.batchStore {
+		if match(req.Payload) {
+			return true
+		}
+	}
+	for _, pair := range op.complainer.CustodyElements() {
+		if match(pair.Request.Payload) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResetEpoch is necessary to implement consensus.EpochResetter, the request
+// stays in custody until it executed, like the requests of clients, so that
+// it is submitted again if the view changes before the primary ordered it
+func (op *obcBatch) ResetEpoch() error {
+	req := op.pbft.EpochResetRequest()
+	op.pbft.Inject(func() {
+		hash := op.complainer.Custody(req)
+		logger.Info("Batch replica %d requesting the end of epoch %d: %s", op.pbft.ID, op.pbft.CurrentEpoch(), hash)
+		op.submitToLeader(req)
+	})
+	return nil
+}
+
+// EpochResetExecuted is necessary to implement epochResetObserver, the
+// request was ordered on its own rather than in a batch
+func (op *obcBatch) EpochResetExecuted(req *pbft.Request) {
+	op.complainer.Success(req)
+	op.deduplicator.Execute(req)
+}
+
+// RequestViewChange is necessary to implement consensus.ViewChanger
+func (op *obcBatch) RequestViewChange() error {
+	return op.pbft.RequestViewChange()
+}
+
+// DumpState is necessary to implement consensus.StateDumper
+func (op *obcBatch) DumpState(w io.Writer) error {
+	return op.pbft.RequestStateDump(w)
+}
+
+// StartCPUProfile is necessary to implement consensus.Profiler
+func (op *obcBatch) StartCPUProfile(w io.Writer) error {
+	return pbft.StartCPUProfile(w)
+}
+
+// StopCPUProfile is necessary to implement consensus.Profiler
+func (op *obcBatch) StopCPUProfile() {
+	pbft.StopCPUProfile()
+}
+
+// SetBlockProfileRate is necessary to implement consensus.Profiler
+func (op *obcBatch) SetBlockProfileRate(rate int) {
+	pbft.SetBlockProfileRate(rate)
+}
+
+// WriteBlockProfile is necessary to implement consensus.Profiler
+func (op *obcBatch) WriteBlockProfile(w io.Writer) error {
+	return pbft.WriteBlockProfile(w)
+}
+
+// DumpStacks is necessary to implement consensus.Profiler
+func (op *obcBatch) DumpStacks(w io.Writer) error {
+	return op.pbft.DumpStacks(w)
+}
+
+func (op *obcBatch) submitToLeader(req *pbft.Request) {
 	// submit to current leader
-	leader := op.pbft.primary(op.pbft.view)
-	if leader == op.pbft.id && op.pbft.activeView {
+	leader := op.pbft.Primary(op.pbft.View)
+	if leader == op.pbft.ID && op.pbft.ActiveView {
 		op.leaderProcReq(req)
 	} else {
-		op.unicastMsg(&BatchMessage{&BatchMessage_Request{req}}, leader)
+		op.unicastMsg(&pbft.BatchMessage{Payload: &pbft.BatchMessage_Request{Request: req}}, leader)
 	}
 }
 
-func (op *obcBatch) broadcastMsg(msg *BatchMessage) {
+func (op *obcBatch) broadcastMsg(msg *pbft.BatchMessage) {
 	msgPayload, _ := proto.Marshal(msg)
-	ocMsg := &pb.Message{
-		Type:    pb.Message_CONSENSUS,
-		Payload: msgPayload,
-	}
+	ocMsg := op.pbft.Wire.Envelope(msgPayload)
 	op.stack.Broadcast(ocMsg, pb.PeerEndpoint_UNDEFINED)
 }
 
 // send a message to a specific replica
-func (op *obcBatch) unicastMsg(msg *BatchMessage, receiverID uint64) {
+func (op *obcBatch) unicastMsg(msg *pbft.BatchMessage, receiverID uint64) {
 	msgPayload, _ := proto.Marshal(msg)
-	ocMsg := &pb.Message{
-		Type:    pb.Message_CONSENSUS,
-		Payload: msgPayload,
-	}
+	ocMsg := op.pbft.Wire.Envelope(msgPayload, receiverID)
 	receiverHandle, err := getValidatorHandle(receiverID)
 	if err != nil {
 		return
@@ -156,25 +343,25 @@ func (op *obcBatch) unicastMsg(msg *BatchMessage, receiverID uint64) {
 // =============================================================================
 
 // multicast a message to all replicas
-func (op *obcBatch) broadcast(msgPayload []byte) {
+func (op *obcBatch) Broadcast(msgPayload []byte) {
 	op.stack.Broadcast(op.wrapMessage(msgPayload), pb.PeerEndpoint_UNDEFINED)
 }
 
 // send a message to a specific replica
-func (op *obcBatch) unicast(msgPayload []byte, receiverID uint64) (err error) {
+func (op *obcBatch) Unicast(msgPayload []byte, receiverID uint64) (err error) {
 	receiverHandle, err := getValidatorHandle(receiverID)
 	if err != nil {
 		return
 	}
-	return op.stack.Unicast(op.wrapMessage(msgPayload), receiverHandle)
+	return op.stack.Unicast(op.wrapMessage(msgPayload, receiverID), receiverHandle)
 }
 
-func (op *obcBatch) sign(msg []byte) ([]byte, error) {
+func (op *obcBatch) Sign(msg []byte) ([]byte, error) {
 	return op.stack.Sign(msg)
 }
 
-// verify message signature
-func (op *obcBatch) verify(senderID uint64, signature []byte, message []byte) error {
+// Verify message signature
+func (op *obcBatch) Verify(senderID uint64, signature []byte, message []byte) error {
 	senderHandle, err := getValidatorHandle(senderID)
 	if err != nil {
 		return err
@@ -184,59 +371,155 @@ func (op *obcBatch) verify(senderID uint64, signature []byte, message []byte) er
 
 // validate checks whether the request is valid syntactically
 // not used in obc-batch at the moment
-func (op *obcBatch) validate(txRaw []byte) error {
+func (op *obcBatch) ValidateRequest(txRaw []byte) error {
 	return nil
 }
 
-// execute an opaque request which corresponds to an OBC Transaction
-func (op *obcBatch) execute(seqNo uint64, raw []byte) {
-	op.pbft.manager.queue() <- batchExecEvent{
+// Execute an opaque request which corresponds to an OBC Transaction, it is
+// invoked on the execution queue thread. The event thread accounts for the
+// requests of the block, while the transactions execute on this thread
+func (op *obcBatch) Execute(seqNo uint64, raw []byte, done pbft.ExecCallback) {
+	txs := make(chan []*pb.Transaction, 1)
+	op.pbft.Manager.Queue() <- batchExecEvent{
 		seqNo: seqNo,
 		raw:   raw,
+		txs:   txs,
+	}
+	batch, ok := <-txs
+	if !ok {
+		return
 	}
+
+	if op.ordering != nil {
+		op.ordering.Ordered(seqNo, batch)
+		done(op.GetState())
+		return
+	}
+
+	meta, _ := proto.Marshal(&pbft.Metadata{SeqNo: seqNo, Epoch: op.pbft.CurrentEpoch(), CommitCertificate: op.pbft.CommitCertificate(seqNo)})
+
+	id := []byte("foo")
+	op.stack.BeginTxBatch(id)
+	result, err := op.stack.ExecTxs(id, batch)
+	_ = err    // XXX what to do on error?
+	_ = result // XXX what to do with the result?
+	_, err = op.stack.CommitTxBatch(id, meta)
+
+	done(op.GetState())
 }
 
-func (op *obcBatch) executeImpl(seqNo uint64, raw []byte) {
-	reqs := &RequestBlock{}
-	if err := proto.Unmarshal(raw, reqs); err != nil {
-		logger.Warning("Batch replica %d could not unmarshal request block: %s", op.pbft.id, err)
+// executeImpl hands the transactions of the requests of a block which are
+// not stale to the execution queue thread, it runs on the event thread
+func (op *obcBatch) executeImpl(seqNo uint64, raw []byte, txs chan<- []*pb.Transaction) {
+	reqs, batch, err := op.unpackBlock(raw, op.deduplicator)
+	if err != nil {
+		logger.Warning("Batch replica %d could not unmarshal request block: %s", op.pbft.ID, err)
+		close(txs)
 		return
 	}
 
-	logger.Debug("Batch replica %d received exec for seqNo %d", op.pbft.id, seqNo)
+	logger.Debug("Batch replica %d received exec for seqNo %d", op.pbft.ID, seqNo)
+
+	for _, req := range reqs {
+		op.complainer.Success(req)
+		op.admission.release(pbft.HashReq(op.pbft.Digest, req))
+	}
+	txs <- batch
+}
+
+// unpackBlock returns the requests of a request block, and the transactions
+// of those the deduplicator accepts for execution
+func (op *obcBatch) unpackBlock(raw []byte, dedup *deduplicator) ([]*pbft.Request, []*pb.Transaction, error) {
+	reqs := &pbft.RequestBlock{}
+	if err := proto.Unmarshal(raw, reqs); err != nil {
+		return nil, nil, err
+	}
 
 	var txs []*pb.Transaction
 
 	for _, req := range reqs.Requests {
-		op.complainer.Success(req)
-
-		if !op.deduplicator.Execute(req) {
+		if !dedup.Execute(req) {
 			logger.Debug("Batch replica %d received exec of stale request from %d via %d",
-				op.pbft.id, req.ReplicaId, req.ReplicaId)
+				op.pbft.ID, req.ReplicaId, req.ReplicaId)
 			continue
 		}
 
 		tx := &pb.Transaction{}
 		if err := proto.Unmarshal(req.Payload, tx); err != nil {
-			logger.Warning("Batch replica %d could not unmarshal transaction: %s", op.pbft.id, err)
+			logger.Warning("Batch replica %d could not unmarshal transaction: %s", op.pbft.ID, err)
 			continue
 		}
+		tx, err := openSealedTx(op.stack, tx)
+		if err != nil {
+			logger.Warning("Batch replica %d skipping transaction: %s", op.pbft.ID, err)
+			continue
+		}
+		if tx.CorrelationID != "" {
+			logger.Debug("Batch replica %d executing transaction %s of correlation %s", op.pbft.ID, tx.Uuid, tx.CorrelationID)
+		}
 		txs = append(txs, tx)
 	}
+	return reqs.Requests, txs, nil
+}
 
-	meta, _ := proto.Marshal(&Metadata{seqNo})
+// speculativeBlock is a request block executed in a transaction batch which
+// is left open until the block commits
+type speculativeBlock struct {
+	seqNo uint64
+	reqs  []*pbft.Request
+}
+
+// Speculate executes a prepared request block without committing the
+// transaction batch, the requests only count as executed once confirmed
+func (op *obcBatch) Speculate(seqNo uint64, raw []byte) {
+	reqs, txs, err := op.unpackBlock(raw, op.deduplicator.fork())
+	if err != nil {
+		logger.Warning("Batch replica %d could not unmarshal request block: %s", op.pbft.ID, err)
+		return
+	}
+
+	logger.Debug("Batch replica %d speculatively executing seqNo %d", op.pbft.ID, seqNo)
+	op.speculative = &speculativeBlock{seqNo: seqNo, reqs: reqs}
 
 	id := []byte("foo")
 	op.stack.BeginTxBatch(id)
-	result, err := op.stack.ExecTxs(id, txs)
-	_ = err    // XXX what to do on error?
-	_ = result // XXX what to do with the result?
-	_, err = op.stack.CommitTxBatch(id, meta)
+	_, err = op.stack.ExecTxs(id, txs)
+	_ = err // XXX what to do on error?
+}
 
-	op.pbft.execDoneSync()
+// Confirm commits the transaction batch of the speculatively executed block
+func (op *obcBatch) Confirm(seqNo uint64) {
+	spec := op.speculative
+	op.speculative = nil
+	if spec == nil || spec.seqNo != seqNo {
+		logger.Warning("Batch replica %d has no speculative execution of seqNo %d to confirm", op.pbft.ID, seqNo)
+		return
+	}
+
+	logger.Debug("Batch replica %d confirming speculative execution of seqNo %d", op.pbft.ID, seqNo)
+	for _, req := range spec.reqs {
+		op.complainer.Success(req)
+		op.deduplicator.Execute(req)
+		op.admission.release(pbft.HashReq(op.pbft.Digest, req))
+	}
+
+	meta, _ := proto.Marshal(&pbft.Metadata{SeqNo: seqNo, Epoch: op.pbft.CurrentEpoch(), CommitCertificate: op.pbft.CommitCertificate(seqNo)})
+	op.stack.CommitTxBatch([]byte("foo"), meta)
+
+	op.pbft.ExecDoneSync(nil)
 }
 
-func (op *obcBatch) viewChange(curView uint64) {
+// Rollback discards the transaction batch of the speculatively executed block
+func (op *obcBatch) Rollback(seqNo uint64) {
+	if op.speculative == nil {
+		return
+	}
+	logger.Debug("Batch replica %d rolling back speculative execution of seqNo %d", op.pbft.ID, seqNo)
+	op.speculative = nil
+	op.stack.RollbackTxBatch([]byte("foo"))
+}
+
+func (op *obcBatch) ViewChange(curView uint64) {
 	// TODO, remove
 }
 
@@ -244,25 +527,53 @@ func (op *obcBatch) viewChange(curView uint64) {
 // functions specific to batch mode
 // =============================================================================
 
-func (op *obcBatch) leaderProcReq(req *Request) error {
+func (op *obcBatch) leaderProcReq(req *pbft.Request) error {
 	// XXX check req sig
 
+	if req.EpochReset {
+		// the request carries no transaction, it is ordered on its own
+		return op.pbft.RecvRequest(req)
+	}
+
+	hash := pbft.HashReq(op.pbft.Digest, req)
+	if err := op.admission.admit(req, hash); err != nil {
+		op.pbft.Metrics.RejectedReqs.Inc()
+		return fmt.Errorf("Batch primary %d rejecting request from %d: %s", op.pbft.ID, req.ReplicaId, err)
+	}
+
 	if !op.deduplicator.Request(req) {
 		logger.Debug("Batch replica %d received stale request from %d",
-			op.pbft.id, req.ReplicaId)
+			op.pbft.ID, req.ReplicaId)
+		op.admission.release(hash)
 		return nil
 	}
 
-	hash := hashReq(req)
+	if op.pbft.Expired(req) {
+		op.admission.release(hash)
+		op.pbft.DropExpired(req, hash)
+		return nil
+	}
 
-	logger.Debug("Batch primary %d queueing new request %s", op.pbft.id, hash)
+	// Cut the pending batch first if this request would push it over the
+	// byte limit, a single request larger than the limit is sent on its own
+	size := proto.Size(req)
+	if op.batchMaxBytes > 0 && len(op.batchStore) > 0 && op.batchBytes+size > op.batchMaxBytes {
+		logger.Debug("Batch primary %d cutting batch of %d bytes before request %s", op.pbft.ID, op.batchBytes, hash)
+		op.sendBatch()
+	}
+
+	logger.Debug("Batch primary %d queueing new request %s", op.pbft.ID, pbft.ReqLabel(hash, req))
 	op.batchStore = append(op.batchStore, req)
+	op.batchBytes += size
 
 	if !op.batchTimerActive {
 		op.startBatchTimer()
 	}
 
-	if len(op.batchStore) >= op.batchSize {
+	if len(op.batchStore) >= op.batchSize || (op.batchMaxBytes > 0 && op.batchBytes >= op.batchMaxBytes) {
+		op.sendBatch()
+	} else if req.Priority > pbft.PriorityBulk && op.pbft.Scheduler != nil {
+		logger.Debug("Batch primary %d cutting batch for request %s with priority %d", op.pbft.ID, hash, req.Priority)
 		op.sendBatch()
 	}
 
@@ -272,8 +583,31 @@ func (op *obcBatch) leaderProcReq(req *Request) error {
 func (op *obcBatch) sendBatch() error {
 	op.stopBatchTimer()
 
-	reqBlock := &RequestBlock{op.batchStore}
+	// Requests which expired while the batch filled up are not ordered
+	pending := op.batchStore[:0]
+	for _, req := range op.batchStore {
+		if !op.pbft.Expired(req) {
+			pending = append(pending, req)
+			continue
+		}
+		hash := pbft.HashReq(op.pbft.Digest, req)
+		op.admission.release(hash)
+		op.pbft.DropExpired(req, hash)
+	}
+	op.batchStore = pending
+	if len(op.batchStore) == 0 {
+		op.batchBytes = 0
+		return nil
+	}
+
+	// Requests of higher priority are executed first within the batch
+	if op.pbft.Scheduler != nil {
+		op.batchStore = pbft.Prioritize(op.batchStore)
+	}
+
+	reqBlock := &pbft.RequestBlock{Requests: op.batchStore}
 	op.batchStore = nil
+	op.batchBytes = 0
 
 	reqsPacked, err := proto.Marshal(reqBlock)
 	if err != nil {
@@ -283,32 +617,37 @@ func (op *obcBatch) sendBatch() error {
 	}
 
 	// process internally
-	logger.Info("Creating batch with %d requests", len(reqBlock.Requests))
-	op.pbft.requestSync(reqsPacked, op.pbft.id)
+	logger.Info("Creating batch with %d requests, %d bytes", len(reqBlock.Requests), len(reqsPacked))
+	op.pbft.RequestSync(reqsPacked, op.pbft.ID)
 
 	return nil
 }
 
-func (op *obcBatch) txToReq(tx []byte) *Request {
+func (op *obcBatch) txToReq(tx []byte) *pbft.Request {
 	now := time.Now()
-	req := &Request{
+	req := &pbft.Request{
 		Timestamp: &google_protobuf.Timestamp{
 			Seconds: now.Unix(),
 			Nanos:   int32(now.UnixNano() % 1000000000),
 		},
 		Payload:   tx,
-		ReplicaId: op.pbft.id,
+		ReplicaId: op.pbft.ID,
+		Priority:  op.pbft.Scheduler.Classify(tx),
 	}
 	// XXX sign req
-	return req
+	return op.annotate(req)
 }
 
 func (op *obcBatch) processMessage(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
 	if ocMsg.Type == pb.Message_CHAIN_TRANSACTION {
 		req := op.txToReq(ocMsg.Payload)
+		if err := op.admission.admit(req, pbft.HashReq(op.pbft.Digest, req)); err != nil {
+			op.pbft.Metrics.RejectedReqs.Inc()
+			return fmt.Errorf("Batch replica %d rejecting transaction: %s", op.pbft.ID, err)
+		}
 		hash := op.complainer.Custody(req)
 
-		logger.Info("Batch replica %d received new consensus request: %s", op.pbft.id, hash)
+		logger.Info("Batch replica %d received new consensus request: %s", op.pbft.ID, pbft.ReqLabel(hash, req))
 
 		op.submitToLeader(req)
 		return nil
@@ -318,14 +657,71 @@ func (op *obcBatch) processMessage(ocMsg *pb.Message, senderHandle *pb.PeerID) e
 		return fmt.Errorf("Unexpected message type: %s", ocMsg.Type)
 	}
 
-	batchMsg := &BatchMessage{}
-	err := proto.Unmarshal(ocMsg.Payload, batchMsg)
+	payload, err := pbft.OpenWireMessage(ocMsg)
 	if err != nil {
 		return err
 	}
+	batchMsg := &pbft.BatchMessage{}
+	err = proto.Unmarshal(payload, batchMsg)
+	if err != nil {
+		return err
+	}
+
+	return op.processBatchMessage(batchMsg, senderHandle)
+}
+
+// validateMessage unmarshals a consensus message and verifies the signatures
+// it carries, it is invoked concurrently by the validation pool and must not
+// access the state of the replica. Other messages are passed on unchanged
+func (op *obcBatch) validateMessage(msg pbft.BatchMessageEvent) interface{} {
+	if msg.Msg.Type != pb.Message_CONSENSUS {
+		return msg
+	}
+
+	payload, err := pbft.OpenWireMessage(msg.Msg)
+	if err != nil {
+		logger.Error("Error decoding batch message: %v", err)
+		return nil
+	}
+	batchMsg := &pbft.BatchMessage{}
+	if err := proto.Unmarshal(payload, batchMsg); err != nil {
+		logger.Error("Error unpacking batch message: %v", err)
+		return nil
+	}
+	validated := validatedBatchMessageEvent{
+		batchMsg: batchMsg,
+		sender:   msg.Sender,
+	}
+
+	if raw := batchMsg.GetPbftMessage(); raw != nil {
+		senderID, err := getValidatorID(msg.Sender)
+		if err != nil {
+			panic("Cannot map sender's PeerID to a valid replica ID")
+		}
+		pbftMsg := &pbft.Message{}
+		if err := proto.Unmarshal(raw, pbftMsg); err != nil {
+			logger.Error("Error unpacking payload from message: %v", err)
+			return nil
+		}
+		if !op.pbft.Admit(pbftMsg, senderID) {
+			return nil
+		}
+		verified, err := pbft.VerifyMessage(op.pbft.VerifyRaw, pbftMsg)
+		if err != nil {
+			logger.Warning("Batch replica %d dropping message from replica %d with incorrect signature: %s", op.pbft.ID, senderID, err)
+			return nil
+		}
+		validated.pbftMsg = pbftMsg
+		validated.senderID = senderID
+		validated.verified = verified
+	}
+	return validated
+}
 
+func (op *obcBatch) processBatchMessage(batchMsg *pbft.BatchMessage, senderHandle *pb.PeerID) error {
+	var err error
 	if req := batchMsg.GetRequest(); req != nil {
-		if (op.pbft.primary(op.pbft.view) == op.pbft.id) && op.pbft.activeView {
+		if (op.pbft.Primary(op.pbft.View) == op.pbft.ID) && op.pbft.ActiveView {
 			err := op.leaderProcReq(req)
 			if err != nil {
 				return err
@@ -336,21 +732,21 @@ func (op *obcBatch) processMessage(ocMsg *pb.Message, senderHandle *pb.PeerID) e
 		if err != nil {
 			panic("Cannot map sender's PeerID to a valid replica ID")
 		}
-		op.pbft.receiveSync(pbftMsg, senderID)
+		op.pbft.ReceiveSync(pbftMsg, senderID)
 	} else if complaint := batchMsg.GetComplaint(); complaint != nil {
-		if op.pbft.primary(op.pbft.view) == op.pbft.id && op.pbft.activeView {
+		if op.pbft.Primary(op.pbft.View) == op.pbft.ID && op.pbft.ActiveView {
 			return op.leaderProcReq(complaint)
 		}
 
 		// XXX check req sig
 		if !op.deduplicator.IsNew(complaint) {
 			logger.Debug("Batch replica %d received stale complaint from %d",
-				op.pbft.id, complaint.ReplicaId)
+				op.pbft.ID, complaint.ReplicaId)
 			return nil
 		}
 
 		hash := op.complainer.Complaint(complaint)
-		logger.Debug("Batch replica %d received complaint %s", op.pbft.id, hash)
+		logger.Debug("Batch replica %d received complaint %s", op.pbft.ID, hash)
 
 		op.submitToLeader(complaint)
 	} else {
@@ -367,46 +763,57 @@ func (op *obcBatch) processMessage(ocMsg *pb.Message, senderHandle *pb.PeerID) e
 // repackage this request's payload into a new request and resubmit
 // it.
 func (op *obcBatch) resubmitStaleRequest(c complaintEvent) {
-	oldReq := c.req.(*Request)
+	oldReq := c.req.(*pbft.Request)
 
 	if !op.complainer.InCustody(oldReq) {
 		logger.Debug("Batch replica %d custody expired for stale request: %s",
-			op.pbft.id, c.hash)
+			op.pbft.ID, c.hash)
 		return
 	}
 
 	newReq := op.txToReq(oldReq.Payload)
 
 	logger.Info("Batch replica %d custody expired for skipped request %s, resubmitting as %s",
-		op.pbft.id, hashReq(oldReq), hashReq(newReq))
+		op.pbft.ID, pbft.HashReq(op.pbft.Digest, oldReq), pbft.HashReq(op.pbft.Digest, newReq))
 	op.complainer.Success(oldReq)
+	op.admission.resubmit(pbft.HashReq(op.pbft.Digest, oldReq), pbft.HashReq(op.pbft.Digest, newReq))
 	op.complainer.Custody(newReq)
 	op.submitToLeader(newReq)
 }
 
 // allow the primary to send a batch when the timer expires
-func (op *obcBatch) processEvent(event interface{}) interface{} {
-	logger.Debug("Replica %d batch main thread looping", op.pbft.id)
+func (op *obcBatch) ProcessEvent(event interface{}) interface{} {
+	logger.Debug("Replica %d batch main thread looping", op.pbft.ID)
+	defer op.updateIngress()
 	switch et := event.(type) {
-	case batchMessageEvent:
+	case pbft.BatchMessageEvent:
 		ocMsg := et
-		if err := op.processMessage(ocMsg.msg, ocMsg.sender); nil != err {
+		if err := op.processMessage(ocMsg.Msg, ocMsg.Sender); nil != err {
+			logger.Error("Error processing message: %v", err)
+		}
+		return nil
+	case validatedBatchMessageEvent:
+		if et.pbftMsg != nil {
+			op.pbft.ReceiveVerifiedSync(et.pbftMsg, et.senderID, et.verified)
+		} else if err := op.processBatchMessage(et.batchMsg, et.sender); nil != err {
 			logger.Error("Error processing message: %v", err)
 		}
 		return nil
-	case batchTimerEvent:
-		logger.Info("Replica %d batch timer expired", op.pbft.id)
-		if op.pbft.activeView && (len(op.batchStore) > 0) {
+	case pbft.BatchTimerEvent:
+		logger.Info("Replica %d batch timer expired", op.pbft.ID)
+		if op.pbft.ActiveView && (len(op.batchStore) > 0) {
 			op.sendBatch()
 		}
-	case viewChangedEvent:
+	case pbft.ViewChangedEvent:
 		// Outstanding reqs doesn't make sense for batch, as all the requests in a batch may be processed
 		// in a different batch, but PBFT core can't see through the opaque structure to see this
 		// so, on view change, we rely on the fact that the complaint service will resubmit requests
 		// and instead zero the outstandingReqs map ourselves
-		op.pbft.outstandingReqs = make(map[string]*Request)
+		op.pbft.OutstandingReqs = make(map[string]*pbft.Request)
+		op.pbft.AdaptiveTimeout.Reset()
+		op.pbft.Phases.Reset()
 
-		logger.Debug("Replica %d batch thread recognizing new view", op.pbft.id)
+		logger.Debug("Replica %d batch thread recognizing new view", op.pbft.ID)
 		op.inViewChange = false
 		if op.batchTimerActive {
 			op.stopBatchTimer()
@@ -414,64 +821,77 @@ func (op *obcBatch) processEvent(event interface{}) interface{} {
 
 		op.complainer.Restart()
 		for _, pair := range op.complainer.CustodyElements() {
-			logger.Info("Replica %d resubmitting request under custody: %s", op.pbft.id, pair.Hash)
+			logger.Info("Replica %d resubmitting request under custody: %s", op.pbft.ID, pair.Hash)
 			op.submitToLeader(pair.Request)
 		}
 	case batchExecEvent:
 		execInfo := et
-		op.executeImpl(execInfo.seqNo, execInfo.raw)
+		op.executeImpl(execInfo.seqNo, execInfo.raw, execInfo.txs)
 	case complaintEvent:
 		c := et
-		logger.Debug("Replica %d processing complaint from custodian", op.pbft.id)
-		if !op.deduplicator.IsNew(c.req.(*Request)) {
+		logger.Debug("Replica %d processing complaint from custodian", op.pbft.ID)
+		if req := c.req.(*pbft.Request); op.pbft.Expired(req) {
+			// Nobody waits for the request anymore, there is nothing to complain about
+			op.complainer.SuccessHash(c.hash)
+			op.admission.release(c.hash)
+			op.pbft.DropExpired(req, c.hash)
+			break
+		}
+		if !op.deduplicator.IsNew(c.req.(*pbft.Request)) {
 			op.resubmitStaleRequest(c)
 			break
 		}
 
 		if !c.complaint {
-			logger.Warning("Batch replica %d custody expired, complaining: %s", op.pbft.id, c.hash)
-			op.broadcastMsg(&BatchMessage{&BatchMessage_Complaint{c.req.(*Request)}})
+			logger.Warning("Batch replica %d custody expired, complaining: %s", op.pbft.ID, c.hash)
+			op.broadcastMsg(&pbft.BatchMessage{Payload: &pbft.BatchMessage_Complaint{Complaint: c.req.(*pbft.Request)}})
 		} else {
-			if !op.inViewChange && op.pbft.activeView {
-				logger.Debug("Batch replica %d complaint timeout expired for %s", op.pbft.id, c.hash)
+			if !op.inViewChange && op.pbft.ActiveView {
+				logger.Debug("Batch replica %d complaint timeout expired for %s", op.pbft.ID, c.hash)
 				op.inViewChange = true
-				op.pbft.sendViewChange()
+				op.pbft.SendViewChange()
 			} else {
-				logger.Debug("Batch replica %d complaint timeout expired for %s while in view change", op.pbft.id, c.hash)
+				logger.Debug("Batch replica %d complaint timeout expired for %s while in view change", op.pbft.ID, c.hash)
 			}
 		}
 	default:
-		return op.pbft.processEvent(event)
+		return op.pbft.ProcessEvent(event)
 	}
 
 	return nil
 }
 
 func (op *obcBatch) startBatchTimer() {
-	op.batchTimer.reset(op.batchTimeout, batchTimerEvent{})
-	logger.Debug("Replica %d started the batch timer", op.pbft.id)
+	op.batchTimer.Reset(op.batchTimeout, pbft.BatchTimerEvent{})
+	logger.Debug("Replica %d started the batch timer", op.pbft.ID)
 	op.batchTimerActive = true
 }
 
 func (op *obcBatch) stopBatchTimer() {
-	op.batchTimer.stop()
-	logger.Debug("Replica %d stopped the batch timer", op.pbft.id)
+	op.batchTimer.Stop()
+	logger.Debug("Replica %d stopped the batch timer", op.pbft.ID)
 	op.batchTimerActive = false
 }
 
-// Wraps a payload into a batch message, packs it and wraps it into
-// a Fabric message. Called by broadcast before transmission.
-func (op *obcBatch) wrapMessage(msgPayload []byte) *pb.Message {
-	batchMsg := &BatchMessage{&BatchMessage_PbftMessage{msgPayload}}
+// updateIngress records the outstanding requests of the replica, which
+// include those waiting to be batched
+func (op *obcBatch) updateIngress() {
+	op.pbft.Ingress.Update(len(op.pbft.OutstandingReqs) + len(op.batchStore))
+}
+
+// Wraps a payload into a batch message, packs it and wraps it into a
+// Fabric message the receivers, all replicas if there are none, can decode.
+// Called by broadcast and unicast before transmission.
+func (op *obcBatch) wrapMessage(msgPayload []byte, receivers ...uint64) *pb.Message {
+	batchMsg := &pbft.BatchMessage{Payload: &pbft.BatchMessage_PbftMessage{PbftMessage: msgPayload}}
 	packedBatchMsg, _ := proto.Marshal(batchMsg)
-	ocMsg := &pb.Message{
-		Type:    pb.Message_CONSENSUS,
-		Payload: packedBatchMsg,
-	}
-	return ocMsg
+	return op.pbft.Wire.Envelope(packedBatchMsg, receivers...)
 }
 
 // Retrieve the idle channel, only used for testing
 func (op *obcBatch) idleChannel() <-chan struct{} {
+	if op.validationPool != nil && op.validationPool.busy() {
+		return make(chan struct{})
+	}
 	return op.idleChan
 }
@@ -17,18 +17,24 @@ limitations under the License.
 package obcpbft
 
 import (
+	"bytes"
+	gp "google/protobuf"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
+	"github.com/hyperledger/fabric/consensus/orderer"
 	pb "github.com/hyperledger/fabric/protos"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 )
 
-func (op *obcBatch) getPBFTCore() *pbftCore {
+func (op *obcBatch) getPBFTCore() *pbft.PbftCore {
 	return op.pbft
 }
 
@@ -43,44 +49,199 @@ func TestNetworkBatch(t *testing.T) {
 	net := makeConsumerNetwork(validatorCount, obcBatchHelper, func(ce *consumerEndpoint) {
 		ce.consumer.(*obcBatch).batchSize = batchSize
 	})
-	defer net.stop()
+	defer net.Stop()
 
-	broadcaster := net.endpoints[generateBroadcaster(validatorCount)].getHandle()
-	err := net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	err := net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
 	if err != nil {
 		t.Fatalf("External request was not processed by backup: %v", err)
 	}
 
-	net.process()
+	net.Process()
 
-	if l := len(net.endpoints[0].(*consumerEndpoint).consumer.(*obcBatch).batchStore); l != 1 {
+	if l := len(net.Endpoints[0].(*consumerEndpoint).consumer.(*obcBatch).batchStore); l != 1 {
 		t.Fatalf("%d message expected in primary's batchStore, found %d", 1, l)
 	}
 
-	err = net.endpoints[2].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(2), broadcaster)
-	net.process()
+	err = net.Endpoints[2].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(2), broadcaster)
+	net.Process()
 
-	if l := len(net.endpoints[0].(*consumerEndpoint).consumer.(*obcBatch).batchStore); l != 0 {
+	if l := len(net.Endpoints[0].(*consumerEndpoint).consumer.(*obcBatch).batchStore); l != 0 {
 		t.Fatalf("%d messages expected in primary's batchStore, found %d", 0, l)
 	}
 
-	for _, ep := range net.endpoints {
+	for _, ep := range net.Endpoints {
 		ce := ep.(*consumerEndpoint)
 		block, err := ce.consumer.(*obcBatch).stack.GetBlock(1)
 		if nil != err {
-			t.Fatalf("Replica %d executed requests, expected a new block on the chain, but could not retrieve it : %s", ce.id, err)
+			t.Fatalf("Replica %d executed requests, expected a new block on the chain, but could not retrieve it : %s", ce.ID, err)
 		}
 		numTrans := len(block.Transactions)
 		if numTrans != batchSize {
 			t.Fatalf("Replica %d executed %d requests, expected %d",
-				ce.id, numTrans, batchSize)
+				ce.ID, numTrans, batchSize)
 		}
 		if numTxResults := len(block.NonHashData.TransactionResults); numTxResults != 1 /*numTrans*/ {
-			t.Fatalf("Replica %d has %d txResults, expected %d", ce.id, numTxResults, numTrans)
+			t.Fatalf("Replica %d has %d txResults, expected %d", ce.ID, numTxResults, numTrans)
 		}
 	}
 }
 
+func TestBatchMaxBytes(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchHelper, func(ce *consumerEndpoint) {
+		ce.consumer.(*obcBatch).batchSize = 10
+	})
+	defer net.Stop()
+
+	primary := net.Endpoints[0].(*consumerEndpoint).consumer.(*obcBatch)
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	blockSizes := func() (sizes []int) {
+		for i := uint64(1); ; i++ {
+			block, err := primary.stack.GetBlock(i)
+			if err != nil {
+				return
+			}
+			sizes = append(sizes, len(block.Transactions))
+		}
+	}
+
+	primary.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.Process()
+	if l := len(primary.batchStore); l != 1 {
+		t.Fatalf("Expected 1 request in primary's batchStore, found %d", l)
+	}
+
+	// Room for one request, but not for two
+	primary.pbft.Manager.Queue() <- pbft.WorkEvent(func() {
+		primary.batchMaxBytes = primary.batchBytes * 3 / 2
+	})
+	primary.RecvMsg(createOcMsgWithChainTx(2), broadcaster)
+	net.Process()
+	if l := len(primary.batchStore); l != 1 {
+		t.Fatalf("Expected the second request to start a new batch, found %d requests in primary's batchStore", l)
+	}
+	if sizes := blockSizes(); !reflect.DeepEqual(sizes, []int{1}) {
+		t.Fatalf("Expected the first request to be cut into a block of its own, got blocks of %v transactions", sizes)
+	}
+
+	// Requests exceeding the limit are sent on their own
+	primary.pbft.Manager.Queue() <- pbft.WorkEvent(func() {
+		primary.batchMaxBytes = 1
+	})
+	primary.RecvMsg(createOcMsgWithChainTx(3), broadcaster)
+	net.Process()
+	if l := len(primary.batchStore); l != 0 {
+		t.Fatalf("Expected primary's batchStore to be empty, found %d requests", l)
+	}
+	if sizes := blockSizes(); !reflect.DeepEqual(sizes, []int{1, 1, 1}) {
+		t.Fatalf("Expected every request in a block of its own, got blocks of %v transactions", sizes)
+	}
+	if primary.batchBytes != 0 {
+		t.Errorf("Expected the byte count to be reset with the batch, got %d", primary.batchBytes)
+	}
+}
+
+func TestBatchExpiredRequest(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchHelper, func(ce *consumerEndpoint) {
+		ce.consumer.(*obcBatch).batchSize = 1
+	})
+	defer net.Stop()
+
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, Payload: []byte("stale"), Expiry: &gp.Timestamp{Seconds: 1}}
+	txPacked, _ := proto.Marshal(tx)
+	primary := net.Endpoints[0].(*consumerEndpoint).consumer.(*obcBatch)
+	if req := primary.txToReq(txPacked); req.Expiry == nil || req.Expiry.Seconds != 1 {
+		t.Fatalf("Expected the expiry of the transaction to be copied into the request, got %v", req.Expiry)
+	}
+
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	primary.RecvMsg(&pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: txPacked}, broadcaster)
+	net.Process()
+
+	if _, err := primary.stack.GetBlock(1); err == nil {
+		t.Error("Expected the expired request not to be ordered")
+	}
+	if v := primary.pbft.Metrics.ExpiredReqs.Value(); v != 1 {
+		t.Errorf("Expected the primary to count 1 expired request, got %d", v)
+	}
+}
+
+func TestBatchCorrelationID(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchHelper, func(ce *consumerEndpoint) {
+		ce.consumer.(*obcBatch).batchSize = 1
+	})
+	defer net.Stop()
+
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, Uuid: "traced", CorrelationID: "order-42"}
+	txPacked, _ := proto.Marshal(tx)
+	primary := net.Endpoints[0].(*consumerEndpoint).consumer.(*obcBatch)
+	req := primary.txToReq(txPacked)
+	if req.CorrelationId != "order-42" {
+		t.Fatalf("Expected the correlation ID of the transaction to be copied into the request, got %q", req.CorrelationId)
+	}
+	if label := pbft.ReqLabel("digest", req); label != "digest (correlation order-42)" {
+		t.Errorf("Expected the request to be logged with its correlation ID, got %q", label)
+	}
+
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	primary.RecvMsg(&pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: txPacked}, broadcaster)
+	net.Process()
+
+	for _, ep := range net.Endpoints {
+		op := ep.(*consumerEndpoint).consumer.(*obcBatch)
+		block, err := op.stack.GetBlock(1)
+		if err != nil {
+			t.Fatalf("Replica %d did not execute the request: %s", op.pbft.ID, err)
+		}
+		if len(block.Transactions) != 1 || block.Transactions[0].CorrelationID != "order-42" {
+			t.Errorf("Replica %d did not carry the correlation ID into the block: %v", op.pbft.ID, block.Transactions)
+		}
+	}
+}
+
+func TestBatchStandaloneOrdering(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, func(id uint64, config *viper.Viper, stack consensus.Stack) pbftConsumer {
+		config.Set("general.ordering.standalone", true)
+		config.Set("general.ordering.address", "127.0.0.1:0")
+		return newObcBatch(id, config, stack)
+	}, func(ce *consumerEndpoint) {
+		ce.consumer.(*obcBatch).batchSize = 1
+	})
+	defer net.Stop()
+
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.Process()
+
+	for _, ep := range net.Endpoints {
+		op := ep.(*consumerEndpoint).consumer.(*obcBatch)
+		if _, err := op.stack.GetBlock(1); err == nil {
+			t.Errorf("Replica %d in the ordering role executed the request", op.pbft.ID)
+		}
+
+		conn, err := grpc.Dial(op.ordering.Addr().String(), grpc.WithInsecure())
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := orderer.NewAtomicBroadcastClient(conn).Deliver(context.Background(), &orderer.DeliverRequest{Start: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Replica %d did not serve the ordered block: %s", op.pbft.ID, err)
+		}
+		if batch.SeqNo != 1 || len(batch.Transactions) != 1 {
+			t.Errorf("Replica %d served block %d of %d transactions, expected block 1 of the request", op.pbft.ID, batch.SeqNo, len(batch.Transactions))
+		}
+		conn.Close()
+	}
+}
+
 func TestBatchCustody(t *testing.T) {
 	t.Skip("test is racy")
 	validatorCount := 4
@@ -96,8 +257,8 @@ func TestBatchCustody(t *testing.T) {
 		config.Set("general.timeout.viewchange", "800ms")
 		return newObcBatch(id, config, stack)
 	})
-	defer net.stop()
-	net.filterFn = func(src int, dst int, payload []byte) []byte {
+	defer net.Stop()
+	net.FilterFn = func(src int, dst int, payload []byte) []byte {
 		logger.Info("msg from %d to %d", src, dst)
 		if src == 0 {
 			return nil
@@ -107,31 +268,31 @@ func TestBatchCustody(t *testing.T) {
 
 	// Submit two requests to replica 2, because vp0 is byzantine, they will not be processed until complaints triggers a view change
 	// Once the complaints work, we should end up in view 1, with 2 blocks
-	r2 := net.endpoints[2].(*consumerEndpoint).consumer
-	r2.RecvMsg(createOcMsgWithChainTx(1), net.endpoints[1].getHandle())
-	r2.RecvMsg(createOcMsgWithChainTx(2), net.endpoints[1].getHandle())
+	r2 := net.Endpoints[2].(*consumerEndpoint).consumer
+	r2.RecvMsg(createOcMsgWithChainTx(1), net.Endpoints[1].GetHandle())
+	r2.RecvMsg(createOcMsgWithChainTx(2), net.Endpoints[1].GetHandle())
 
-	//net.debug = true
-	net.debugMsg("Stage 1\n")
+	//net.Debug = true
+	net.DebugMsg("Stage 1\n")
 	// Get the requests into the custody store, will return once vp2 complaints
-	net.process()
-	net.debugMsg("Stage 2\n")
+	net.Process()
+	net.DebugMsg("Stage 2\n")
 
 	// Let the complaint timer expire for vp1/vp3
 	time.Sleep(500 * time.Millisecond)
 
 	// Process the new view and execute the requests
-	net.process()
-	net.debugMsg("Stage 3\n")
+	net.Process()
+	net.DebugMsg("Stage 3\n")
 
 	// Let the complaint timer expire for the other request
 	time.Sleep(500 * time.Millisecond)
 
 	// Process the complaint, this time without view change
-	net.process()
-	net.debugMsg("Stage 4\n")
+	net.Process()
+	net.DebugMsg("Stage 4\n")
 
-	for i, ep := range net.endpoints {
+	for i, ep := range net.Endpoints {
 
 		b := ep.(*consumerEndpoint).consumer.(*obcBatch)
 
@@ -139,8 +300,8 @@ func TestBatchCustody(t *testing.T) {
 			t.Errorf("Expected replica %d to have two blocks", i)
 		} else {
 			expectedView := uint64(1)
-			if b.pbft.view != expectedView {
-				t.Errorf("Expected replica %d to have two blocks and be in view %d", b.pbft.id, expectedView)
+			if b.pbft.View != expectedView {
+				t.Errorf("Expected replica %d to have two blocks and be in view %d", b.pbft.ID, expectedView)
 			}
 		}
 	}
@@ -154,10 +315,10 @@ func TestBatchStaleCustody(t *testing.T) {
 	config.Set("general.timeout.request", "250ms")
 	config.Set("general.timeout.viewchange", "800ms")
 
-	var reqs []*Request
+	var reqs []*pbft.Request
 	stack := &omniProto{
 		UnicastImpl: func(msg *pb.Message, p *pb.PeerID) error {
-			m := &Message{}
+			m := &pbft.Message{}
 			proto.Unmarshal(msg.Payload, m)
 			if r := m.GetRequest(); r != nil {
 				reqs = append(reqs, r)
@@ -179,6 +340,9 @@ func TestBatchStaleCustody(t *testing.T) {
 		CommitTxBatchImpl: func(id interface{}, meta []byte) (*pb.Block, error) {
 			return nil, nil
 		},
+		GetBlockchainInfoBlobImpl: func() []byte {
+			return nil
+		},
 	}
 	op := newObcBatch(1, config, stack)
 	defer op.Close()
@@ -186,14 +350,298 @@ func TestBatchStaleCustody(t *testing.T) {
 	req1 := createOcMsgWithChainTx(1)
 	op.RecvMsg(req1, &pb.PeerID{})
 	op.RecvMsg(createOcMsgWithChainTx(2), &pb.PeerID{})
-	op.pbft.manager.queue() <- nil
-	op.pbft.currentExec = new(uint64) // so that pbft.execDone doesn't get unhappy
-	*op.pbft.currentExec = 1
-	rblock2raw, _ := proto.Marshal(&RequestBlock{[]*Request{reqs[1]}})
-	op.executeImpl(1, rblock2raw)
+	op.pbft.Manager.Queue() <- nil
+	op.pbft.CurrentExec = new(uint64) // so that the completion of the execution is not ignored
+	*op.pbft.CurrentExec = 1
+	rblock2raw, _ := proto.Marshal(&pbft.RequestBlock{Requests: []*pbft.Request{reqs[1]}})
+	op.Execute(1, rblock2raw, op.pbft.ExecCompletion(1))
 	time.Sleep(500 * time.Millisecond)
-	op.pbft.manager.queue() <- nil
+	op.pbft.Manager.Queue() <- nil
 	if len(reqs) != 3 || !reflect.DeepEqual(reqs[2].Payload, req1.Payload) {
 		t.Error("expected resubmitted request")
 	}
 }
+
+// A request ending the epoch which the primary never ordered is submitted
+// again to the primary of the next view
+func TestBatchEpochResetResubmitted(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchHelper, func(ce *consumerEndpoint) {
+		ce.consumer.(*obcBatch).batchSize = 1
+		ce.consumer.(*obcBatch).pbft.K = 2
+	})
+	defer net.Stop()
+
+	// Replica 0, the primary of view 0, is cut off
+	net.FilterFn = func(src int, dst int, msg []byte) []byte {
+		if src == 0 || dst == 0 {
+			return nil
+		}
+		return msg
+	}
+
+	b1 := net.Endpoints[1].(*consumerEndpoint).consumer.(*obcBatch)
+	if err := b1.ResetEpoch(); err != nil {
+		t.Fatalf("Could not request the end of the epoch: %s", err)
+	}
+	net.Process()
+	if custody := b1.complainer.CustodyElements(); len(custody) != 1 || !custody[0].Request.EpochReset {
+		t.Fatalf("Expected replica 1 to hold the request ending the epoch in custody, holds %v", custody)
+	}
+
+	for i := 1; i < validatorCount; i++ {
+		if err := net.Endpoints[i].(*consumerEndpoint).consumer.(*obcBatch).RequestViewChange(); err != nil {
+			t.Fatalf("Replica %d could not request a view change: %s", i, err)
+		}
+	}
+	net.Process()
+
+	// The epoch ends at the checkpoint following the reset
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	b1.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.Process()
+
+	for i := 1; i < validatorCount; i++ {
+		b := net.Endpoints[i].(*consumerEndpoint).consumer.(*obcBatch)
+		if b.pbft.View != 1 || b.pbft.CurrentEpoch() != 1 {
+			t.Errorf("Replica %d should have entered epoch 1 in view 1, is in epoch %d of view %d", i, b.pbft.CurrentEpoch(), b.pbft.View)
+		}
+	}
+	if custody := b1.complainer.CustodyElements(); len(custody) != 0 {
+		t.Errorf("Expected replica 1 to release the executed request ending the epoch from custody, holds %v", custody)
+	}
+}
+
+// slowStack blocks executions until released
+type slowStack struct {
+	*completeStack
+	executing chan struct{}
+	release   chan struct{}
+}
+
+func (ss *slowStack) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error) {
+	ss.executing <- struct{}{}
+	<-ss.release
+	return ss.completeStack.ExecTxs(id, txs)
+}
+
+func TestBatchSlowExecution(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.batchsize", 1)
+	release := make(chan struct{})
+	stacks := make([]*slowStack, validatorCount)
+	net := makeConsumerNetwork(validatorCount, func(id uint64, _ *viper.Viper, stack consensus.Stack) pbftConsumer {
+		stacks[id] = &slowStack{stack.(*completeStack), make(chan struct{}, 2), release}
+		return newObcBatch(id, config, stacks[id])
+	})
+	defer net.Stop()
+
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(2), broadcaster)
+	processed := make(chan error)
+	go func() {
+		processed <- net.Process()
+	}()
+
+	for i, stack := range stacks {
+		select {
+		case <-stack.executing:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Replica %d did not start executing seqNo 1", i)
+		}
+	}
+
+	// While seqNo 1 executes, the event threads keep ordering seqNo 2
+	for i, ep := range net.Endpoints {
+		instance := ep.(*consumerEndpoint).consumer.getPBFTCore()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			committed := make(chan bool, 1)
+			select {
+			case instance.Manager.Queue() <- pbft.WorkEvent(func() {
+				// The batch of seqNo 2 embeds the transaction verbatim
+				status := instance.RequestStatus(func(payload []byte) bool {
+					return bytes.Contains(payload, createOcMsgWithChainTx(2).Payload)
+				})
+				committed <- status.Phase == pbft.RequestCommitted && status.SeqNo == 2 && instance.LastExec == 0
+			}):
+			case <-time.After(deadline.Sub(time.Now())):
+				t.Fatalf("Replica %d event thread blocked while executing seqNo 1", i)
+			}
+			if <-committed {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Replica %d did not commit seqNo 2 while executing seqNo 1", i)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	close(release)
+	if err := <-processed; err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	for i, ep := range net.Endpoints {
+		if lastExec := ep.(*consumerEndpoint).consumer.getPBFTCore().LastExec; lastExec != 2 {
+			t.Errorf("Replica %d expected to execute 2 requests, executed %d", i, lastExec)
+		}
+	}
+}
+
+func TestGenericPriority(t *testing.T) {
+	op := &obcGeneric{}
+	if p := op.Priority(createOcMsgWithChainTx(1).Payload); p != pbft.PriorityAdmin {
+		t.Errorf("Expected a deploy to be an administrative request, got priority %d", p)
+	}
+	if p := op.Priority(createInvokeTx(1)); p != pbft.PriorityBulk {
+		t.Errorf("Expected an invoke to be a bulk request, got priority %d", p)
+	}
+	if p := op.Priority([]byte("garbage")); p != pbft.PriorityBulk {
+		t.Errorf("Expected a payload which is no transaction to be a bulk request, got priority %d", p)
+	}
+}
+
+func TestBatchPriority(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, func(id uint64, config *viper.Viper, stack consensus.Stack) pbftConsumer {
+		config.Set("general.batchsize", 10)
+		config.Set("general.priority.enabled", true)
+		return newObcBatch(id, config, stack)
+	})
+	defer net.Stop()
+
+	primary := net.Endpoints[0].(*consumerEndpoint).consumer.(*obcBatch)
+	backup := net.Endpoints[1].(*consumerEndpoint).consumer.(*obcBatch)
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+
+	backup.RecvMsg(&pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: createInvokeTx(1)}, broadcaster)
+	net.Process()
+	if l := len(primary.batchStore); l != 1 {
+		t.Fatalf("Expected 1 request in primary's batchStore, found %d", l)
+	}
+
+	// A deploy cuts the batch at once, and is executed first
+	primary.RecvMsg(createOcMsgWithChainTx(2), broadcaster)
+	net.Process()
+	block, err := primary.stack.GetBlock(1)
+	if err != nil {
+		t.Fatalf("Expected the deploy to cut a batch: %s", err)
+	}
+	if len(block.Transactions) != 2 || block.Transactions[0].Type != pb.Transaction_CHAINCODE_DEPLOY {
+		t.Errorf("Expected the deploy to be executed ahead of the invoke, got %v", block.Transactions)
+	}
+}
+
+func TestBatchQuery(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, func(id uint64, config *viper.Viper, stack consensus.Stack) pbftConsumer {
+		return newObcBatch(id, config, stack)
+	})
+	defer net.Stop()
+
+	type queryResult struct {
+		result []byte
+		err    error
+	}
+	done := make(chan queryResult, 1)
+	go func() {
+		result, err := net.Endpoints[3].(*consumerEndpoint).consumer.(*obcBatch).Query(&pb.Transaction{Type: pb.Transaction_CHAINCODE_QUERY, Payload: []byte("foo")})
+		done <- queryResult{result, err}
+	}()
+
+	// The query is queued asynchronously, so keep processing until it completes
+	var r queryResult
+	deadline := time.After(5 * time.Second)
+	for completed := false; !completed; {
+		if err := net.Process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+		select {
+		case r = <-done:
+			completed = true
+		case <-deadline:
+			t.Fatalf("Query did not complete")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if r.err != nil {
+		t.Fatalf("Query failed: %s", r.err)
+	}
+	if string(r.result) != "1:foo" {
+		t.Errorf("Expected the query to be answered by the ledger, got %q", r.result)
+	}
+	for i, ml := range net.mockLedgers {
+		if size := ml.GetBlockchainSize(); size != 1 {
+			t.Errorf("Replica %d has blockchain size %d, expected the query not to be committed", i, size)
+		}
+	}
+}
+
+func TestBatchRequestStatus(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchHelper, func(ce *consumerEndpoint) {
+		ce.consumer.(*obcBatch).batchSize = 2
+	})
+	defer net.Stop()
+
+	status := func(id int, uuid string) *consensus.RequestStatus {
+		status, err := net.Endpoints[id].(*consumerEndpoint).consumer.(consensus.StatusQuerier).RequestStatus(uuid)
+		if err != nil {
+			t.Fatalf("Replica %d could not report the status of %s: %s", id, uuid, err)
+		}
+		return status
+	}
+
+	submitter := net.Endpoints[1].(*consumerEndpoint).consumer
+	submitter.RecvMsg(createOcMsgWithUUID("first"), net.Endpoints[1].GetHandle())
+	net.Process()
+	for _, id := range []int{0, 1} {
+		if s := status(id, "first"); s.Phase != consensus.RequestQueued {
+			t.Errorf("Expected the transaction to be queued on replica %d until the batch is cut, is %s", id, s.Phase)
+		}
+	}
+
+	submitter.RecvMsg(createOcMsgWithUUID("second"), net.Endpoints[1].GetHandle())
+	net.Process()
+	for id := range net.Endpoints {
+		for _, uuid := range []string{"first", "second"} {
+			if s := status(id, uuid); s.Phase != consensus.RequestExecuted || s.SeqNo != 1 {
+				t.Errorf("Expected %s to be executed at seqNo 1 on replica %d, is %s at seqNo %d", uuid, id, s.Phase, s.SeqNo)
+			}
+		}
+	}
+}
+
+func TestBatchSpeculativeExecution(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.speculative", true)
+	config.Set("general.batchsize", 1)
+	net := makeConsumerNetwork(validatorCount, func(id uint64, _ *viper.Viper, stack consensus.Stack) pbftConsumer {
+		return newObcBatch(id, config, stack)
+	})
+	defer net.Stop()
+
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	for i := int64(1); i <= 3; i++ {
+		net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(i), broadcaster)
+		net.Process()
+	}
+
+	for i, ep := range net.Endpoints {
+		batch := ep.(*consumerEndpoint).consumer.(*obcBatch)
+		if batch.pbft.LastExec != 3 {
+			t.Errorf("Replica %d expected to execute 3 requests, executed %d", i, batch.pbft.LastExec)
+		}
+		if spec := batch.pbft.Metrics.Speculations.Value(); spec == 0 {
+			t.Errorf("Replica %d expected to execute requests speculatively", i)
+		}
+		if size := net.mockLedgers[i].GetBlockchainSize(); size != 4 {
+			t.Errorf("Replica %d expected 3 blocks, found %d", i, size-1)
+		}
+	}
+}
@@ -18,8 +18,10 @@ package obcpbft
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
 	pb "github.com/hyperledger/fabric/protos"
 
 	"github.com/golang/protobuf/proto"
@@ -45,27 +47,35 @@ func newObcClassic(id uint64, config *viper.Viper, stack consensus.Stack) *obcCl
 
 	logger.Debug("Replica %d obtaining startup information", id)
 
-	op.pbft = legacyPbftShim{newPbftCore(id, config, op)}
-	op.pbft.manager.start()
+	op.pbft = pbft.LegacyShim{PbftCore: pbft.NewPbftCore(id, config, op)}
+	if err := op.pbft.EnableGossip(config); err != nil {
+		panic(err)
+	}
+	op.pbft.Manager.Start()
 
 	op.idleChan = make(chan struct{})
 	close(op.idleChan)
 
+	op.pbft.Manager.Queue() <- pbft.RestoredEvent{}
+
 	return op
 }
 
 // RecvMsg receives both CHAIN_TRANSACTION and CONSENSUS messages from
 // the stack. New transaction requests are broadcast to all replicas,
-// so that the current primary will receive the request.
+// or gossiped if enabled, so that the current primary will receive the request.
 func (op *obcClassic) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
 	if ocMsg.Type == pb.Message_CHAIN_TRANSACTION {
 		logger.Info("New consensus request received")
 
-		req := &Request{Payload: ocMsg.Payload, ReplicaId: op.pbft.id}
-		pbftMsg := &Message{&Message_Request{req}}
-		packedPbftMsg, _ := proto.Marshal(pbftMsg)
-		op.broadcast(packedPbftMsg)
-		op.pbft.request(ocMsg.Payload, op.pbft.id)
+		req := op.annotate(&pbft.Request{Payload: ocMsg.Payload, ReplicaId: op.pbft.ID, Priority: op.pbft.Scheduler.Classify(ocMsg.Payload)})
+		op.pbft.Tracing.Submit(req)
+		pbftMsg := &pbft.Message{Payload: &pbft.Message_Request{Request: req}}
+		if op.pbft.Gossip == nil {
+			packedPbftMsg, _ := proto.Marshal(pbftMsg)
+			op.Broadcast(packedPbftMsg)
+		}
+		op.pbft.RecvMsgSync(pbftMsg, op.pbft.ID)
 
 		return nil
 	}
@@ -79,14 +89,100 @@ func (op *obcClassic) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error
 		panic("Cannot map sender's PeerID to a valid replica ID")
 	}
 
-	op.pbft.receive(ocMsg.Payload, senderID)
+	payload, err := pbft.OpenWireMessage(ocMsg)
+	if err != nil {
+		return fmt.Errorf("Cannot decode message from replica %d: %s", senderID, err)
+	}
+	op.pbft.Receive(payload, senderID)
 
 	return nil
 }
 
 // Close tells us to release resources we are holding
 func (op *obcClassic) Close() {
-	op.pbft.close()
+	op.pbft.Close()
+}
+
+// Query executes a read-only transaction on all replicas, without ordering
+// it, and returns the result once f+1 replicas agree on it
+func (op *obcClassic) Query(tx *pb.Transaction) ([]byte, error) {
+	txRaw, err := proto.Marshal(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal query transaction: %s", err)
+	}
+	return op.pbft.SubmitQuery(txRaw)
+}
+
+// Overloaded is necessary to implement consensus.Throttler
+func (op *obcClassic) Overloaded() bool {
+	return op.pbft.Ingress.Overloaded()
+}
+
+// AuditTrail is necessary to implement consensus.Auditor
+func (op *obcClassic) AuditTrail(epoch, from, to uint64) ([][]byte, error) {
+	return op.pbft.GetAuditTrail(epoch, from, to)
+}
+
+// RotateSessionKeys is necessary to implement consensus.Rekeyer
+func (op *obcClassic) RotateSessionKeys() error {
+	return op.pbft.RequestSessionKeyRotation()
+}
+
+// Inspect is necessary to implement consensus.Inspector
+func (op *obcClassic) Inspect() (*consensus.ReplicaState, error) {
+	return replicaState(op.pbft.RequestInspection())
+}
+
+// RequestStatus is necessary to implement consensus.StatusQuerier
+func (op *obcClassic) RequestStatus(uuid string) (*consensus.RequestStatus, error) {
+	done := make(chan *consensus.RequestStatus, 1)
+	op.pbft.Inject(func() {
+		done <- requestStatus(op.pbft.RequestStatus(matchTransaction(uuid)))
+	})
+	return <-done, nil
+}
+
+// ResetEpoch is necessary to implement consensus.EpochResetter
+func (op *obcClassic) ResetEpoch() error {
+	pbftMsg := &pbft.Message{Payload: &pbft.Message_Request{Request: op.pbft.EpochResetRequest()}}
+	packedPbftMsg, _ := proto.Marshal(pbftMsg)
+	op.Broadcast(packedPbftMsg)
+	return op.pbft.RecvMsgSync(pbftMsg, op.pbft.ID)
+}
+
+// RequestViewChange is necessary to implement consensus.ViewChanger
+func (op *obcClassic) RequestViewChange() error {
+	return op.pbft.RequestViewChange()
+}
+
+// DumpState is necessary to implement consensus.StateDumper
+func (op *obcClassic) DumpState(w io.Writer) error {
+	return op.pbft.RequestStateDump(w)
+}
+
+// StartCPUProfile is necessary to implement consensus.Profiler
+func (op *obcClassic) StartCPUProfile(w io.Writer) error {
+	return pbft.StartCPUProfile(w)
+}
+
+// StopCPUProfile is necessary to implement consensus.Profiler
+func (op *obcClassic) StopCPUProfile() {
+	pbft.StopCPUProfile()
+}
+
+// SetBlockProfileRate is necessary to implement consensus.Profiler
+func (op *obcClassic) SetBlockProfileRate(rate int) {
+	pbft.SetBlockProfileRate(rate)
+}
+
+// WriteBlockProfile is necessary to implement consensus.Profiler
+func (op *obcClassic) WriteBlockProfile(w io.Writer) error {
+	return pbft.WriteBlockProfile(w)
+}
+
+// DumpStacks is necessary to implement consensus.Profiler
+func (op *obcClassic) DumpStacks(w io.Writer) error {
+	return op.pbft.DumpStacks(w)
 }
 
 // =============================================================================
@@ -94,20 +190,14 @@ func (op *obcClassic) Close() {
 // =============================================================================
 
 // multicast a message to all replicas
-func (op *obcClassic) broadcast(msgPayload []byte) {
-	ocMsg := &pb.Message{
-		Type:    pb.Message_CONSENSUS,
-		Payload: msgPayload,
-	}
+func (op *obcClassic) Broadcast(msgPayload []byte) {
+	ocMsg := op.pbft.Wire.Envelope(msgPayload)
 	op.stack.Broadcast(ocMsg, pb.PeerEndpoint_UNDEFINED)
 }
 
 // send a message to a specific replica
-func (op *obcClassic) unicast(msgPayload []byte, receiverID uint64) (err error) {
-	ocMsg := &pb.Message{
-		Type:    pb.Message_CONSENSUS,
-		Payload: msgPayload,
-	}
+func (op *obcClassic) Unicast(msgPayload []byte, receiverID uint64) (err error) {
+	ocMsg := op.pbft.Wire.Envelope(msgPayload, receiverID)
 	receiverHandle, err := getValidatorHandle(receiverID)
 	if err != nil {
 		return
@@ -115,11 +205,11 @@ func (op *obcClassic) unicast(msgPayload []byte, receiverID uint64) (err error)
 	return op.stack.Unicast(ocMsg, receiverHandle)
 }
 
-func (op *obcClassic) sign(msg []byte) ([]byte, error) {
+func (op *obcClassic) Sign(msg []byte) ([]byte, error) {
 	return op.stack.Sign(msg)
 }
 
-func (op *obcClassic) verify(senderID uint64, signature []byte, message []byte) error {
+func (op *obcClassic) Verify(senderID uint64, signature []byte, message []byte) error {
 	senderHandle, err := getValidatorHandle(senderID)
 	if err != nil {
 		return err
@@ -128,36 +218,42 @@ func (op *obcClassic) verify(senderID uint64, signature []byte, message []byte)
 }
 
 // validate checks whether the request is valid syntactically
-func (op *obcClassic) validate(txRaw []byte) error {
+func (op *obcClassic) ValidateRequest(txRaw []byte) error {
 	tx := &pb.Transaction{}
 	err := proto.Unmarshal(txRaw, tx)
 	return err
 }
 
-// execute an opaque request which corresponds to an OBC Transaction
-func (op *obcClassic) execute(seqNo uint64, txRaw []byte) {
+// Execute an opaque request which corresponds to an OBC Transaction
+func (op *obcClassic) Execute(seqNo uint64, txRaw []byte, done pbft.ExecCallback) {
 	tx := &pb.Transaction{}
 	err := proto.Unmarshal(txRaw, tx)
 	if err != nil {
 		logger.Error("Unable to unmarshal transaction: %v", err)
 		return
 	}
+	var txs []*pb.Transaction
+	if tx, err = openSealedTx(op.stack, tx); err != nil {
+		logger.Error("Unable to execute transaction, committing an empty block: %v", err)
+	} else {
+		txs = append(txs, tx)
+	}
 
-	meta, _ := proto.Marshal(&Metadata{seqNo})
+	meta, _ := proto.Marshal(&pbft.Metadata{SeqNo: seqNo, Epoch: op.pbft.CurrentEpoch(), CommitCertificate: op.pbft.CommitCertificate(seqNo)})
 
 	id := []byte("foo")
 	op.stack.BeginTxBatch(id)
-	result, err := op.stack.ExecTxs(id, []*pb.Transaction{tx})
+	result, err := op.stack.ExecTxs(id, txs)
 	_ = err    // XXX what to do on error?
 	_ = result // XXX what to do with the result?
 	_, err = op.stack.CommitTxBatch(id, meta)
 
-	op.pbft.execDone()
+	done(op.GetState())
 }
 
 // called when a view-change happened in the underlying PBFT
 // classic mode pbft does not use this information
-func (op *obcClassic) viewChange(curView uint64) {
+func (op *obcClassic) ViewChange(curView uint64) {
 }
 
 // Unnecessary
@@ -176,10 +272,10 @@ func (op *obcClassic) idleChannel() <-chan struct{} {
 
 // StateUpdated is a signal from the stack that it has fast-forwarded its state
 func (op *obcClassic) StateUpdated(seqNo uint64, id []byte) {
-	op.pbft.stateUpdated(seqNo, id)
+	op.pbft.StateUpdated(seqNo, id)
 }
 
 // StateUpdating is a signal from the stack that state transfer has started
 func (op *obcClassic) StateUpdating(seqNo uint64, id []byte) {
-	op.pbft.stateUpdating(seqNo, id)
+	op.pbft.StateUpdating(seqNo, id)
 }
@@ -17,16 +17,21 @@ limitations under the License.
 package obcpbft
 
 import (
+	"bytes"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
+	pb "github.com/hyperledger/fabric/protos"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/spf13/viper"
 )
 
-func (op *obcClassic) getPBFTCore() *pbftCore {
-	return op.legacyGenericShim.pbft.pbftCore
+func (op *obcClassic) getPBFTCore() *pbft.PbftCore {
+	return op.legacyGenericShim.pbft.PbftCore
 }
 
 func obcClassicHelper(id uint64, config *viper.Viper, stack consensus.Stack) pbftConsumer {
@@ -37,22 +42,22 @@ func obcClassicHelper(id uint64, config *viper.Viper, stack consensus.Stack) pbf
 func TestClassicNetwork(t *testing.T) {
 	validatorCount := 4
 	net := makeConsumerNetwork(validatorCount, obcClassicHelper)
-	defer net.stop()
+	defer net.Stop()
 
-	broadcaster := net.endpoints[generateBroadcaster(validatorCount)].getHandle()
-	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
 
-	net.process()
+	net.Process()
 
-	for _, ep := range net.endpoints {
+	for _, ep := range net.Endpoints {
 		ce := ep.(*consumerEndpoint)
 		block, err := ce.consumer.(*obcClassic).stack.GetBlock(1)
 		if nil != err {
-			t.Errorf("Replica %d executed requests, expected a new block on the chain, but could not retrieve it : %s", ce.id, err)
+			t.Errorf("Replica %d executed requests, expected a new block on the chain, but could not retrieve it : %s", ce.ID, err)
 		}
 		numTrans := len(block.Transactions)
 		if numTxResults := len(block.NonHashData.TransactionResults); numTxResults != numTrans {
-			t.Fatalf("Replica %d has %d txResults, expected %d", ce.id, numTxResults, numTrans)
+			t.Fatalf("Replica %d has %d txResults, expected %d", ce.ID, numTxResults, numTrans)
 		}
 	}
 }
@@ -63,11 +68,11 @@ func TestClassicStateTransfer(t *testing.T) {
 		ce.consumer.(*obcClassic).pbft.K = 2
 		ce.consumer.(*obcClassic).pbft.L = 4
 	})
-	defer net.stop()
-	// net.debug = true
+	defer net.Stop()
+	// net.Debug = true
 
 	filterMsg := true
-	net.filterFn = func(src int, dst int, msg []byte) []byte {
+	net.FilterFn = func(src int, dst int, msg []byte) []byte {
 		if filterMsg && dst == 3 { // 3 is byz
 			return nil
 		}
@@ -75,27 +80,27 @@ func TestClassicStateTransfer(t *testing.T) {
 	}
 
 	// Advance the network one seqNo past so that Replica 3 will have to do statetransfer
-	broadcaster := net.endpoints[generateBroadcaster(validatorCount)].getHandle()
-	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
-	net.process()
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.Process()
 
 	// Move the seqNo to 9, at seqNo 6, Replica 3 will realize it's behind, transfer to seqNo 8, then execute seqNo 9
 	filterMsg = false
 	for n := 2; n <= 9; n++ {
-		net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(int64(n)), broadcaster)
+		net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(int64(n)), broadcaster)
 	}
 
-	net.process()
+	net.Process()
 
-	for _, ep := range net.endpoints {
+	for _, ep := range net.Endpoints {
 		ce := ep.(*consumerEndpoint)
 		obc := ce.consumer.(*obcClassic)
 		_, err := obc.stack.GetBlock(9)
 		if nil != err {
-			t.Errorf("Replica %d executed requests, expected a new block on the chain, but could not retrieve it : %s", ce.id, err)
+			t.Errorf("Replica %d executed requests, expected a new block on the chain, but could not retrieve it : %s", ce.ID, err)
 		}
-		if !obc.pbft.activeView || obc.pbft.view != 0 {
-			t.Errorf("Replica %d not active in view 0, is %v %d", ce.id, obc.pbft.activeView, obc.pbft.view)
+		if !obc.pbft.ActiveView || obc.pbft.View != 0 {
+			t.Errorf("Replica %d not active in view 0, is %v %d", ce.ID, obc.pbft.ActiveView, obc.pbft.View)
 		}
 	}
 }
@@ -105,13 +110,14 @@ func TestClassicBackToBackStateTransfer(t *testing.T) {
 	net := makeConsumerNetwork(validatorCount, obcClassicHelper, func(ce *consumerEndpoint) {
 		ce.consumer.(*obcClassic).pbft.K = 2
 		ce.consumer.(*obcClassic).pbft.L = 4
-		ce.consumer.(*obcClassic).pbft.requestTimeout = time.Hour // We do not want any view changes
+		ce.consumer.(*obcClassic).pbft.RequestTimeout = time.Hour // We do not want any view changes
+		ce.consumer.(*obcClassic).pbft.CatchupGap = 0             // We want state transfer rather than catching up
 	})
-	defer net.stop()
-	// net.debug = true
+	defer net.Stop()
+	// net.Debug = true
 
 	filterMsg := true
-	net.filterFn = func(src int, dst int, msg []byte) []byte {
+	net.FilterFn = func(src int, dst int, msg []byte) []byte {
 		if filterMsg && dst == 3 { // 3 is byz
 			return nil
 		}
@@ -119,9 +125,9 @@ func TestClassicBackToBackStateTransfer(t *testing.T) {
 	}
 
 	// Get the group to advance past seqNo 1, leaving Replica 3 behind
-	broadcaster := net.endpoints[generateBroadcaster(validatorCount)].getHandle()
-	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
-	net.process()
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.Process()
 
 	// Now start including Replica 3, go to sequence number 10, Replica 3 will trigger state transfer
 	// after seeing seqNo 8, then pass another target for seqNo 10 and 12, but transfer to 8, but the network
@@ -129,32 +135,165 @@ func TestClassicBackToBackStateTransfer(t *testing.T) {
 	// Replica 3 will execute through seqNo 12
 	filterMsg = false
 	for n := 2; n <= 13; n++ {
-		net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(int64(n)), broadcaster)
+		net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(int64(n)), broadcaster)
 	}
 
-	net.process()
+	net.Process()
 
-	_, err := net.endpoints[3].(*consumerEndpoint).consumer.(*obcClassic).stack.GetBlock(13)
+	_, err := net.Endpoints[3].(*consumerEndpoint).consumer.(*obcClassic).stack.GetBlock(13)
 	if nil == err {
 		t.Errorf("Replica 3 should not be caught up yet")
 	}
 
 	// Replica 3 has a stable checkpoint at seqNo 12, it will detect it is behind at seqNo 18, then skip to seqNo 20, and execute request 21
 	for n := 14; n <= 21; n++ {
-		net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(int64(n)), broadcaster)
+		net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(int64(n)), broadcaster)
 	}
 
-	net.process()
+	net.Process()
 
-	for _, ep := range net.endpoints {
+	for _, ep := range net.Endpoints {
 		ce := ep.(*consumerEndpoint)
 		obc := ce.consumer.(*obcClassic)
 		_, err := obc.stack.GetBlock(21)
 		if nil != err {
-			t.Errorf("Replica %d executed requests, expected a new block on the chain, but could not retrieve it : %s", ce.id, err)
+			t.Errorf("Replica %d executed requests, expected a new block on the chain, but could not retrieve it : %s", ce.ID, err)
 		}
-		if !obc.pbft.activeView || obc.pbft.view != 0 {
-			t.Errorf("Replica %d not active in view 0, is %v %d", ce.id, obc.pbft.activeView, obc.pbft.view)
+		if !obc.pbft.ActiveView || obc.pbft.View != 0 {
+			t.Errorf("Replica %d not active in view 0, is %v %d", ce.ID, obc.pbft.ActiveView, obc.pbft.View)
+		}
+	}
+}
+
+func TestClassicGossip(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, func(id uint64, config *viper.Viper, stack consensus.Stack) pbftConsumer {
+		config.Set("general.gossip.fanout", 1)
+		config.Set("general.gossip.antientropy", "50ms")
+		return newObcClassic(id, config, stack)
+	})
+	defer net.Stop()
+
+	var broadcasts, pulls int32
+	net.FilterFn = func(src int, dst int, msg []byte) []byte {
+		pm := &pbft.Message{}
+		if err := proto.Unmarshal(msg, pm); err != nil {
+			t.Fatal(err)
+		}
+		if pm.GetRequest() != nil {
+			atomic.AddInt32(&broadcasts, 1)
+		}
+		// the primary only learns of the request through anti-entropy
+		if pm.GetGossipRequest() != nil && dst == 0 {
+			return nil
+		}
+		if pm.GetFetchRequest() != nil && src == 0 {
+			atomic.AddInt32(&pulls, 1)
+		}
+		return msg
+	}
+
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.Process()
+
+	if broadcasts != 0 {
+		t.Errorf("Expected the request to be gossiped rather than broadcast, saw %d requests", broadcasts)
+	}
+	if pulls == 0 {
+		t.Errorf("Expected the primary to pull the request")
+	}
+	for _, ep := range net.Endpoints {
+		ce := ep.(*consumerEndpoint)
+		if _, err := ce.consumer.(*obcClassic).stack.GetBlock(1); err != nil {
+			t.Errorf("Replica %d expected a new block on the chain, but could not retrieve it : %s", ce.ID, err)
+		}
+	}
+}
+
+func TestInspectConsenter(t *testing.T) {
+	net := makeConsumerNetwork(4, obcClassicHelper)
+	defer net.Stop()
+
+	broadcaster := net.Endpoints[generateBroadcaster(4)].GetHandle()
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.Process()
+
+	inspector, ok := net.Endpoints[1].(*consumerEndpoint).consumer.(consensus.Inspector)
+	if !ok {
+		t.Fatal("Expected the consenter to implement consensus.Inspector")
+	}
+	state, err := inspector.Inspect()
+	if err != nil {
+		t.Fatalf("Could not inspect the replica: %s", err)
+	}
+	if state.ReplicaID != 1 || !state.ActiveView || state.LastExec != 1 || state.OutstandingRequests != 0 {
+		t.Errorf("Expected replica 1 to have executed request 1 in an active view, got %+v", state)
+	}
+}
+
+// createOcMsgWithUUID creates a CHAIN_TRANSACTION message for a transaction
+// with the uuid
+func createOcMsgWithUUID(uuid string) *pb.Message {
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, Uuid: uuid, Payload: []byte(uuid)}
+	txPacked, _ := proto.Marshal(tx)
+	return &pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: txPacked}
+}
+
+func TestClassicRequestStatus(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcClassicHelper)
+	defer net.Stop()
+
+	tx := &pb.Transaction{Uuid: "status-tx"}
+	msg := createOcMsgWithUUID(tx.Uuid)
+
+	replica := net.Endpoints[1].(*consumerEndpoint).consumer.(consensus.StatusQuerier)
+	if status, err := replica.RequestStatus(tx.Uuid); err != nil || status.Phase != consensus.RequestUnknown {
+		t.Fatalf("Expected the transaction to be unknown before it was submitted, got %v, %v", status, err)
+	}
+
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(msg, net.Endpoints[1].GetHandle())
+	net.Process()
+
+	for _, ep := range net.Endpoints {
+		status, err := ep.(*consumerEndpoint).consumer.(consensus.StatusQuerier).RequestStatus(tx.Uuid)
+		if err != nil || status.Phase != consensus.RequestExecuted || status.SeqNo != 1 {
+			t.Errorf("Expected the transaction to be executed at seqNo 1, got %v, %v", status, err)
+		}
+	}
+}
+
+// compressedTag is how every marshaled compressed message starts, the key of
+// the compressed field of the payload oneof
+var compressedTag = proto.EncodeVarint(21<<3 | proto.WireBytes)
+
+func TestClassicNetworkCompressed(t *testing.T) {
+	validatorCount := 4
+	compressed := 0
+	net := makeConsumerNetwork(validatorCount, func(id uint64, config *viper.Viper, stack consensus.Stack) pbftConsumer {
+		config.Set("general.compression.threshold", 1)
+		return newObcClassic(id, config, stack)
+	})
+	defer net.Stop()
+	net.FilterFn = func(src int, dst int, msg []byte) []byte {
+		if bytes.HasPrefix(msg, compressedTag) {
+			compressed++
+		}
+		return msg
+	}
+
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.Process()
+
+	if compressed == 0 {
+		t.Errorf("Expected replicas to compress messages once they exchanged hellos")
+	}
+	for _, ep := range net.Endpoints {
+		ce := ep.(*consumerEndpoint)
+		if _, err := ce.consumer.(*obcClassic).stack.GetBlock(1); err != nil {
+			t.Errorf("Replica %d did not commit the compressed request: %s", ce.ID, err)
 		}
 	}
 }
@@ -24,19 +24,35 @@ import (
 	"strings"
 
 	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
+	_ "github.com/hyperledger/fabric/core" // Needed for logging format init
 	pb "github.com/hyperledger/fabric/protos"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
 	"github.com/spf13/viper"
 )
 
 const configPrefix = "CORE_PBFT"
 
+var logger *logging.Logger             // package-level logger
 var pluginInstance consensus.Consenter // singleton service
 var config *viper.Viper
 
 func init() {
+	logger = logging.MustGetLogger("consensus/obcpbft")
 	config = loadConfig()
+	consensus.RegisterPlugin(&consensus.Plugin{Name: "pbft", Capabilities: capabilities, Shared: GetPlugin, New: New})
+}
+
+// capabilities returns the capabilities of the configured mode, only sieve
+// detects non-deterministic transactions
+func capabilities() consensus.Capability {
+	caps := consensus.SupportsBFT | consensus.SupportsStateTransfer | consensus.RequiresPersistence
+	if strings.ToLower(config.GetString("general.mode")) == "sieve" {
+		caps |= consensus.SupportsNonDeterminism
+	}
+	return caps
 }
 
 // GetPlugin returns the handle to the Consenter singleton
@@ -51,8 +67,20 @@ func GetPlugin(c consensus.Stack) consensus.Consenter {
 // Internally, it uses an opaque pbft-core instance.
 func New(stack consensus.Stack) consensus.Consenter {
 	handle, _, _ := stack.GetNetworkHandles()
-	id, _ := getValidatorID(handle)
+	id, err := getValidatorID(handle)
+	if err != nil {
+		panic(err)
+	}
+	if self, network, err := stack.GetNetworkInfo(); err == nil {
+		if err = validateNetwork(id, config.GetInt("general.N"), self, network); err != nil {
+			panic(err)
+		}
+	}
+	return newConsenter(id, config, stack)
+}
 
+// newConsenter creates the Obc* instance of the configured mode
+func newConsenter(id uint64, config *viper.Viper, stack consensus.Stack) consensus.Consenter {
 	switch strings.ToLower(config.GetString("general.mode")) {
 	case "classic":
 		return newObcClassic(id, config, stack)
@@ -125,33 +153,197 @@ func getValidatorHandles(ids []uint64) (handles []*pb.PeerID) {
 	return
 }
 
+// validateNetwork checks the validators replica id is connected to against
+// general.N, it is called once the peer knows its network
+func validateNetwork(id uint64, N int, self *pb.PeerEndpoint, network []*pb.PeerEndpoint) error {
+	validators := make(map[string]bool)
+	for _, ep := range append([]*pb.PeerEndpoint{self}, network...) {
+		if ep == nil || ep.ID == nil || ep.Type != pb.PeerEndpoint_VALIDATOR {
+			continue
+		}
+		validators[ep.ID.Name] = true
+	}
+	for name := range validators {
+		if other, err := getValidatorID(&pb.PeerID{Name: name}); err != nil {
+			return fmt.Errorf("Validator %s is not named vp<ID>, which PBFT derives the replica IDs from", name)
+		} else if other >= uint64(N) {
+			return fmt.Errorf("Validator %s is out of range for general.N = %d, replica %d must be configured with the N of the network", name, N, id)
+		}
+	}
+	if len(validators) > N {
+		return fmt.Errorf("Replica %d knows %d validators, but general.N = %d; configure every replica with the N of the network", id, len(validators), N)
+	}
+	return nil
+}
+
 type obcGeneric struct {
 	stack consensus.Stack
-	pbft  *pbftCore
+	pbft  *pbft.PbftCore
 }
 
-func (op *obcGeneric) skipTo(seqNo uint64, id []byte, replicas []uint64) {
+func (op *obcGeneric) SkipTo(seqNo uint64, id []byte, replicas []uint64) {
 	op.stack.SkipTo(seqNo, id, getValidatorHandles(replicas))
 }
 
-func (op *obcGeneric) invalidateState() {
+func (op *obcGeneric) InvalidateState() {
 	op.stack.InvalidateState()
 }
 
-func (op *obcGeneric) validateState() {
+func (op *obcGeneric) ValidateState() {
 	op.stack.ValidateState()
 }
 
-func (op *obcGeneric) getState() []byte {
+func (op *obcGeneric) GetState() []byte {
 	return op.stack.GetBlockchainInfoBlob()
 }
 
-func (op *obcGeneric) getLastSeqNo() (uint64, error) {
-	raw, err := op.stack.GetBlockHeadMetadata()
+func (op *obcGeneric) GetLastSeqNo() (uint64, error) {
+	meta, err := op.lastMetadata()
 	if err != nil {
 		return 0, err
 	}
-	meta := &Metadata{}
-	proto.Unmarshal(raw, meta)
 	return meta.SeqNo, nil
 }
+
+func (op *obcGeneric) GetLastEpoch() (uint64, error) {
+	meta, err := op.lastMetadata()
+	if err != nil {
+		return 0, err
+	}
+	return meta.Epoch, nil
+}
+
+// lastMetadata returns the metadata of the head of the blockchain
+func (op *obcGeneric) lastMetadata() (*pbft.Metadata, error) {
+	raw, err := op.stack.GetBlockHeadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	meta := &pbft.Metadata{}
+	proto.Unmarshal(raw, meta)
+	return meta, nil
+}
+
+// Priority classifies chaincode deploys and terminations as administrative
+// transactions, which are ordered ahead of the others
+func (op *obcGeneric) Priority(txRaw []byte) uint32 {
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(txRaw, tx); err != nil {
+		return pbft.PriorityBulk
+	}
+	switch tx.Type {
+	case pb.Transaction_CHAINCODE_DEPLOY, pb.Transaction_CHAINCODE_TERMINATE:
+		return pbft.PriorityAdmin
+	default:
+		return pbft.PriorityBulk
+	}
+}
+
+// annotate copies the expiry and the correlation ID the client attached to
+// the transaction in the payload of req into req, and returns req
+func (op *obcGeneric) annotate(req *pbft.Request) *pbft.Request {
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(req.Payload, tx); err != nil {
+		return req
+	}
+	req.Expiry = tx.Expiry
+	req.CorrelationId = tx.CorrelationID
+	return req
+}
+
+func (op *obcGeneric) RequestExpired(txRaw []byte) {
+	observer, ok := op.stack.(consensus.ExpiryObserver)
+	if !ok {
+		return
+	}
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(txRaw, tx); err != nil {
+		logger.Error("Cannot unmarshal expired transaction: %s", err)
+		return
+	}
+	observer.Expired(tx)
+}
+
+// matchTransaction returns whether a payload is the transaction with the uuid
+func matchTransaction(uuid string) func(txRaw []byte) bool {
+	return func(txRaw []byte) bool {
+		tx := &pb.Transaction{}
+		return proto.Unmarshal(txRaw, tx) == nil && tx.Uuid == uuid
+	}
+}
+
+func (op *obcGeneric) ExecuteQuery(txRaw []byte) ([]byte, error) {
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(txRaw, tx); err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal query transaction: %s", err)
+	}
+	return op.stack.QueryTx(tx)
+}
+
+func (op *obcGeneric) ReportEvidence(ev *pbft.Evidence) {
+	reporter, ok := op.stack.(consensus.EvidenceReporter)
+	if !ok {
+		return
+	}
+	raw, err := proto.Marshal(ev)
+	if err != nil {
+		logger.Error("Cannot marshal evidence against replica %d: %s", ev.Accused, err)
+		return
+	}
+	handle, err := getValidatorHandle(ev.Accused)
+	if err != nil {
+		logger.Error("Cannot report evidence against replica %d: %s", ev.Accused, err)
+		return
+	}
+	reporter.ReportEvidence(handle, ev.Fault, raw)
+}
+
+func (op *obcGeneric) Executed(txRaw []byte, cert *pbft.ReplyCertificate) {
+	receiver, ok := op.stack.(consensus.ExecutionReceiver)
+	if !ok {
+		return
+	}
+	raw, err := proto.Marshal(cert)
+	if err != nil {
+		logger.Error("Cannot marshal execution certificate: %s", err)
+		return
+	}
+	receiver.Executed(txRaw, raw)
+}
+
+// replicaState reports the state of a replica as a consensus.ReplicaState
+func replicaState(state *pbft.ReplicaState, err error) (*consensus.ReplicaState, error) {
+	if err != nil {
+		return nil, err
+	}
+	rs := &consensus.ReplicaState{
+		ReplicaID:           state.ReplicaID,
+		View:                state.View,
+		Epoch:               state.Epoch,
+		ActiveView:          state.ActiveView,
+		Primary:             state.Primary,
+		SeqNo:               state.SeqNo,
+		LastExec:            state.LastExec,
+		LowWatermark:        state.LowWatermark,
+		LogSize:             state.LogSize,
+		CheckpointPeriod:    state.CheckpointPeriod,
+		OutstandingRequests: state.OutstandingRequests,
+		StateTransfer:       state.StateTransfer,
+		Checkpoints:         state.Checkpoints,
+		Votes:               make(map[uint64][]consensus.CheckpointID),
+	}
+	for _, vc := range state.ViewChanges {
+		rs.ViewChanges = append(rs.ViewChanges, consensus.ViewChangeVote{View: vc.View, ReplicaID: vc.ReplicaID})
+	}
+	for n, votes := range state.Votes {
+		for _, vote := range votes {
+			rs.Votes[n] = append(rs.Votes[n], consensus.CheckpointID{ReplicaID: vote.ReplicaID, ID: vote.ID})
+		}
+	}
+	return rs, nil
+}
+
+// requestStatus reports the progress of a request as a consensus.RequestStatus
+func requestStatus(status *pbft.RequestStatus) *consensus.RequestStatus {
+	return &consensus.RequestStatus{Phase: consensus.RequestPhase(status.Phase), SeqNo: status.SeqNo}
+}
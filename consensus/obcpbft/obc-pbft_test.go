@@ -0,0 +1,71 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"os"
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestEnvOverride(t *testing.T) {
+	config := loadConfig()
+
+	key := "general.mode"               // for a key that exists
+	envName := "CORE_PBFT_GENERAL_MODE" // env override name
+	overrideValue := "overide_test"     // value to override default value with
+
+	// test key
+	if ok := config.IsSet("general.mode"); !ok {
+		t.Fatalf("Cannot test env override because \"%s\" does not seem to be set", key)
+	}
+
+	os.Setenv(envName, overrideValue)
+	// The override config value will cause other calls to fail unless unset.
+	defer func() {
+		os.Unsetenv(envName)
+	}()
+
+	if ok := config.IsSet("general.mode"); !ok {
+		t.Fatalf("Env override in place, and key \"%s\" is not set", key)
+	}
+
+	// read key
+	configVal := config.GetString("general.mode")
+	if configVal != overrideValue {
+		t.Fatalf("Env override in place, expected key \"%s\" to be \"%s\" but instead got \"%s\"", key, overrideValue, configVal)
+	}
+
+}
+
+func TestValidateNetwork(t *testing.T) {
+	validator := func(name string) *pb.PeerEndpoint {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: name}, Type: pb.PeerEndpoint_VALIDATOR}
+	}
+	nvp := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "client"}, Type: pb.PeerEndpoint_NON_VALIDATOR}
+
+	if err := validateNetwork(0, 4, validator("vp0"), []*pb.PeerEndpoint{validator("vp1"), validator("vp3"), nvp}); err != nil {
+		t.Errorf("Expected the network to be valid, got %s", err)
+	}
+	if err := validateNetwork(0, 4, validator("vp0"), []*pb.PeerEndpoint{validator("vp4")}); err == nil {
+		t.Error("Expected an error for a validator beyond N")
+	}
+	if err := validateNetwork(0, 4, validator("vp0"), []*pb.PeerEndpoint{validator("peer1")}); err == nil {
+		t.Error("Expected an error for a validator which is not named vp<ID>")
+	}
+}
@@ -19,52 +19,59 @@ package obcpbft
 import (
 	"encoding/base64"
 	"fmt"
+	google_protobuf "google/protobuf"
+	"io"
 	"reflect"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
 	pb "github.com/hyperledger/fabric/protos"
-	google_protobuf "google/protobuf"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/spf13/viper"
 )
 
 type obcSieve struct {
 	legacyGenericShim
 
-	id             uint64
-	epoch          uint64
-	imminentEpoch  uint64
-	blockNumber    uint64
-	currentReqFull *Request
-	currentReq     string
-	currentResult  []byte
+	id            uint64
+	epoch         uint64
+	imminentEpoch uint64
+	blockNumber   uint64
+	currentBatch  []*pbft.Request
+	currentReq    string
+	currentResult []byte
+
+	batchSize     int
+	batchMaxBytes int
 
 	lastExecPbftSeqNo uint64
-	execOutstanding   bool
+	execOutstanding   pbft.ExecCallback // completes the execution which waits for state transfer, nil if none
 
-	verifyStore []*Verify
+	verifyStore []*pbft.Verify
 
-	queuedExec map[uint64]*Execute
-	queuedTx   []*Request
+	queuedExec map[uint64]*pbft.Execute
+	queuedTx   []*pbft.Request
+	isolated   []*pbft.Request // requests of a non-deterministic batch, executed one at a time
 
 	complainer   *complainer
 	deduplicator *deduplicator
 
 	persistForward
 
-	executeChan       chan *pbftExecute       // Written to by a go routine from PBFT execute method
-	incomingChan      chan *msgWithSender     // Written to by RecvMsg
-	custodyTimerChan  chan custodyInfo        // Written to by Complaint
-	stateUpdatedChan  chan *checkpointMessage // Written to by StateUpdate
-	stateUpdatingChan chan *checkpointMessage // Written to by StateUpdate
-	idleChan          chan struct{}           // Used for detecting thread idleness for testing
+	executeChan       chan *pbftExecute            // Written to by a go routine from PBFT execute method
+	incomingChan      chan *msgWithSender          // Written to by RecvMsg
+	custodyTimerChan  chan custodyInfo             // Written to by Complaint
+	stateUpdatedChan  chan *pbft.CheckpointMessage // Written to by StateUpdate
+	stateUpdatingChan chan *pbft.CheckpointMessage // Written to by StateUpdate
+	idleChan          chan struct{}                // Used for detecting thread idleness for testing
 }
 
 type pbftExecute struct {
 	seqNo uint64
 	txRaw []byte
+	done  pbft.ExecCallback
 }
 
 type msgWithSender struct {
@@ -79,21 +86,30 @@ func newObcSieve(id uint64, config *viper.Viper, stack consensus.Stack) *obcSiev
 		},
 		id: id,
 	}
-	op.queuedExec = make(map[uint64]*Execute)
+	op.queuedExec = make(map[uint64]*pbft.Execute)
 	op.persistForward.persistor = stack
 
+	op.batchSize = config.GetInt("general.batchsize")
+	if op.batchSize < 1 {
+		panic(fmt.Errorf("Batch size must be at least 1, got %d", op.batchSize))
+	}
+	op.batchMaxBytes = config.GetInt("general.batchmaxbytes")
+	if op.batchMaxBytes < 0 {
+		panic(fmt.Errorf("Maximum batch size in bytes must not be negative, got %d", op.batchMaxBytes))
+	}
+
 	op.restoreBlockNumber()
 
-	op.pbft = legacyPbftShim{newPbftCore(id, config, op)}
-	op.pbft.manager.start()
-	op.complainer = newComplainer(op, op.pbft.requestTimeout, op.pbft.requestTimeout)
+	op.pbft = pbft.LegacyShim{PbftCore: pbft.NewPbftCore(id, config, op)}
+	op.pbft.Manager.Start()
+	op.complainer = newComplainer(op, op.pbft.Digest, op.pbft.RequestTimeout, op.pbft.RequestTimeout)
 	op.deduplicator = newDeduplicator()
 
 	op.executeChan = make(chan *pbftExecute)
 	op.incomingChan = make(chan *msgWithSender)
 	op.custodyTimerChan = make(chan custodyInfo)
-	op.stateUpdatedChan = make(chan *checkpointMessage)
-	op.stateUpdatingChan = make(chan *checkpointMessage)
+	op.stateUpdatedChan = make(chan *pbft.CheckpointMessage)
+	op.stateUpdatingChan = make(chan *pbft.CheckpointMessage)
 
 	op.idleChan = make(chan struct{})
 
@@ -106,7 +122,7 @@ func newObcSieve(id uint64, config *viper.Viper, stack consensus.Stack) *obcSiev
 // have to agree to guarantee that more correct replicas than
 // byzantine replicas agree
 func (op *obcSieve) moreCorrectThanByzantineQuorum() int {
-	return 2*op.pbft.f + 1
+	return 2*op.pbft.F + 1
 }
 
 // recvMsg is the internal handler for messages which come in through RecvMsg
@@ -121,8 +137,12 @@ func (op *obcSieve) recvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
 			panic("Cannot map sender's PeerID to a valid replica ID")
 		}
 
-		svMsg := &SieveMessage{}
-		err = proto.Unmarshal(ocMsg.Payload, svMsg)
+		payload, err := pbft.OpenWireMessage(ocMsg)
+		if err != nil {
+			return fmt.Errorf("Cannot decode message from replica %d: %s", senderID, err)
+		}
+		svMsg := &pbft.SieveMessage{}
+		err = proto.Unmarshal(payload, svMsg)
 		if err != nil {
 			err = fmt.Errorf("Could not unmarshal sieve message: %v", ocMsg)
 			logger.Error(err.Error())
@@ -137,7 +157,7 @@ func (op *obcSieve) recvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
 
 func (op *obcSieve) request(tx []byte) error {
 	now := time.Now()
-	req := &Request{
+	req := &pbft.Request{
 		Timestamp: &google_protobuf.Timestamp{
 			Seconds: now.Unix(),
 			Nanos:   int32(now.UnixNano() % 1000000000),
@@ -146,7 +166,7 @@ func (op *obcSieve) request(tx []byte) error {
 		ReplicaId: op.id,
 	}
 	// XXX sign req
-	hash := hashReq(req)
+	hash := pbft.HashReq(op.pbft.Digest, req)
 
 	logger.Info("Sieve replica %d: New consensus request received: %s", op.id, hash)
 
@@ -156,21 +176,21 @@ func (op *obcSieve) request(tx []byte) error {
 	return nil
 }
 
-func (op *obcSieve) Complain(hash string, req *Request, primaryFail bool) {
+func (op *obcSieve) Complain(hash string, req *pbft.Request, primaryFail bool) {
 	op.custodyTimerChan <- custodyInfo{hash, req, primaryFail}
 }
 
-func (op *obcSieve) submitToLeader(req *Request) {
+func (op *obcSieve) submitToLeader(req *pbft.Request) {
 	// submit to current leader
-	leader := op.pbft.primary(op.pbft.view)
-	if leader == op.pbft.id && op.pbft.activeView {
+	leader := op.pbft.Primary(op.pbft.View)
+	if leader == op.pbft.ID && op.pbft.ActiveView {
 		op.recvRequest(req)
 	} else {
-		op.unicastMsg(&SieveMessage{&SieveMessage_Request{req}}, leader)
+		op.unicastMsg(&pbft.SieveMessage{Payload: &pbft.SieveMessage_Request{Request: req}}, leader)
 	}
 }
 
-func (op *obcSieve) receive(svMsg *SieveMessage, senderID uint64) error {
+func (op *obcSieve) receive(svMsg *pbft.SieveMessage, senderID uint64) error {
 	if req := svMsg.GetRequest(); req != nil {
 		op.recvRequest(req)
 	} else if complaint := svMsg.GetComplaint(); complaint != nil {
@@ -185,7 +205,7 @@ func (op *obcSieve) receive(svMsg *SieveMessage, senderID uint64) error {
 		// check for sender not needed since verify messages are signed and will be verified
 		op.recvVerify(verify)
 	} else if pbftMsg := svMsg.GetPbftMessage(); pbftMsg != nil {
-		op.pbft.receive(pbftMsg, senderID)
+		op.pbft.Receive(pbftMsg, senderID)
 	} else {
 		err := fmt.Errorf("Received invalid sieve message: %v", svMsg)
 		logger.Error(err.Error())
@@ -209,27 +229,92 @@ func (op *obcSieve) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
 // Close tells us to release resources we are holding
 func (op *obcSieve) Close() {
 	op.complainer.Stop()
-	op.pbft.close()
+	op.pbft.Close()
+}
+
+// Query executes a read-only transaction on all replicas, without ordering
+// it, and returns the result once f+1 replicas agree on it
+func (op *obcSieve) Query(tx *pb.Transaction) ([]byte, error) {
+	txRaw, err := proto.Marshal(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal query transaction: %s", err)
+	}
+	return op.pbft.SubmitQuery(txRaw)
+}
+
+// Overloaded is necessary to implement consensus.Throttler
+func (op *obcSieve) Overloaded() bool {
+	return op.pbft.Ingress.Overloaded()
+}
+
+// AuditTrail is necessary to implement consensus.Auditor
+func (op *obcSieve) AuditTrail(epoch, from, to uint64) ([][]byte, error) {
+	return op.pbft.GetAuditTrail(epoch, from, to)
+}
+
+// RotateSessionKeys is necessary to implement consensus.Rekeyer
+func (op *obcSieve) RotateSessionKeys() error {
+	return op.pbft.RequestSessionKeyRotation()
+}
+
+// Inspect is necessary to implement consensus.Inspector
+func (op *obcSieve) Inspect() (*consensus.ReplicaState, error) {
+	return replicaState(op.pbft.RequestInspection())
+}
+
+// RequestViewChange is necessary to implement consensus.ViewChanger
+func (op *obcSieve) RequestViewChange() error {
+	return op.pbft.RequestViewChange()
+}
+
+// DumpState is necessary to implement consensus.StateDumper
+func (op *obcSieve) DumpState(w io.Writer) error {
+	return op.pbft.RequestStateDump(w)
+}
+
+// StartCPUProfile is necessary to implement consensus.Profiler
+func (op *obcSieve) StartCPUProfile(w io.Writer) error {
+	return pbft.StartCPUProfile(w)
+}
+
+// StopCPUProfile is necessary to implement consensus.Profiler
+func (op *obcSieve) StopCPUProfile() {
+	pbft.StopCPUProfile()
+}
+
+// SetBlockProfileRate is necessary to implement consensus.Profiler
+func (op *obcSieve) SetBlockProfileRate(rate int) {
+	pbft.SetBlockProfileRate(rate)
+}
+
+// WriteBlockProfile is necessary to implement consensus.Profiler
+func (op *obcSieve) WriteBlockProfile(w io.Writer) error {
+	return pbft.WriteBlockProfile(w)
+}
+
+// DumpStacks is necessary to implement consensus.Profiler
+func (op *obcSieve) DumpStacks(w io.Writer) error {
+	return op.pbft.DumpStacks(w)
 }
 
 // called by pbft-core to multicast a message to all replicas
-func (op *obcSieve) broadcast(msgPayload []byte) {
-	svMsg := &SieveMessage{&SieveMessage_PbftMessage{msgPayload}}
+func (op *obcSieve) Broadcast(msgPayload []byte) {
+	svMsg := &pbft.SieveMessage{Payload: &pbft.SieveMessage_PbftMessage{PbftMessage: msgPayload}}
 	op.broadcastMsg(svMsg)
 }
 
 // send a message to a specific replica
-func (op *obcSieve) unicast(msgPayload []byte, receiverID uint64) (err error) {
-	svMsg := &SieveMessage{&SieveMessage_PbftMessage{msgPayload}}
+func (op *obcSieve) Unicast(msgPayload []byte, receiverID uint64) (err error) {
+	svMsg := &pbft.SieveMessage{Payload: &pbft.SieveMessage_PbftMessage{PbftMessage: msgPayload}}
 	op.unicastMsg(svMsg, receiverID)
 	return nil
 }
 
-func (op *obcSieve) sign(msg []byte) ([]byte, error) {
+func (op *obcSieve) Sign(msg []byte) ([]byte, error) {
 	return op.stack.Sign(msg)
 }
 
-func (op *obcSieve) verify(senderID uint64, signature []byte, message []byte) error {
+func (op *obcSieve) Verify(senderID uint64, signature []byte, message []byte) error {
 	senderHandle, err := getValidatorHandle(senderID)
 	if err != nil {
 		return err
@@ -238,42 +323,38 @@ func (op *obcSieve) verify(senderID uint64, signature []byte, message []byte) er
 }
 
 // called by pbft-core to signal when a view change happened
-func (op *obcSieve) viewChange(newView uint64) {
+func (op *obcSieve) ViewChange(newView uint64) {
 	logger.Info("Replica %d observing pbft view change to %d", op.id, newView)
 	op.queuedTx = nil
+	op.isolated = nil
 	op.imminentEpoch = newView
 
-	for idx := range op.pbft.outstandingReqs {
-		delete(op.pbft.outstandingReqs, idx)
+	for idx := range op.pbft.OutstandingReqs {
+		delete(op.pbft.OutstandingReqs, idx)
 	}
-	op.pbft.stopTimer()
+	op.pbft.AdaptiveTimeout.Reset()
+	op.pbft.StopTimer()
 	op.complainer.Restart()
 
-	if op.pbft.primary(newView) == op.id {
-		flush := &Flush{View: newView}
+	if op.pbft.Primary(newView) == op.id {
+		flush := &pbft.Flush{View: newView}
 		flush.ReplicaId = op.id
-		op.pbft.sign(flush)
-		req := &SievePbftMessage{Payload: &SievePbftMessage_Flush{flush}}
+		op.pbft.Sign(flush)
+		req := &pbft.SievePbftMessage{Payload: &pbft.SievePbftMessage_Flush{Flush: flush}}
 		go op.invokePbft(req)
 	}
 }
 
-func (op *obcSieve) broadcastMsg(svMsg *SieveMessage) {
+func (op *obcSieve) broadcastMsg(svMsg *pbft.SieveMessage) {
 	msgPayload, _ := proto.Marshal(svMsg)
-	ocMsg := &pb.Message{
-		Type:    pb.Message_CONSENSUS,
-		Payload: msgPayload,
-	}
+	ocMsg := op.pbft.Wire.Envelope(msgPayload)
 	op.stack.Broadcast(ocMsg, pb.PeerEndpoint_UNDEFINED)
 }
 
 // send a message to a specific replica
-func (op *obcSieve) unicastMsg(svMsg *SieveMessage, receiverID uint64) {
+func (op *obcSieve) unicastMsg(svMsg *pbft.SieveMessage, receiverID uint64) {
 	msgPayload, _ := proto.Marshal(svMsg)
-	ocMsg := &pb.Message{
-		Type:    pb.Message_CONSENSUS,
-		Payload: msgPayload,
-	}
+	ocMsg := op.pbft.Wire.Envelope(msgPayload, receiverID)
 	receiverHandle, err := getValidatorHandle(receiverID)
 	if err != nil {
 		return
@@ -282,13 +363,13 @@ func (op *obcSieve) unicastMsg(svMsg *SieveMessage, receiverID uint64) {
 	op.stack.Unicast(ocMsg, receiverHandle)
 }
 
-func (op *obcSieve) invokePbft(msg *SievePbftMessage) {
+func (op *obcSieve) invokePbft(msg *pbft.SievePbftMessage) {
 	raw, _ := proto.Marshal(msg)
-	op.pbft.request(raw, op.id)
+	op.pbft.Request(raw, op.id)
 }
 
-func (op *obcSieve) recvRequest(req *Request) {
-	if op.pbft.primary(op.epoch) != op.id || !op.pbft.activeView {
+func (op *obcSieve) recvRequest(req *pbft.Request) {
+	if op.pbft.Primary(op.epoch) != op.id || !op.pbft.ActiveView {
 		logger.Debug("Sieve backup %d ignoring request", op.id)
 		return
 	}
@@ -301,7 +382,7 @@ func (op *obcSieve) recvRequest(req *Request) {
 		return
 	}
 
-	logger.Debug("Sieve primary %d received request %s", op.id, hashReq(req))
+	logger.Debug("Sieve primary %d received request %s", op.id, pbft.HashReq(op.pbft.Digest, req))
 	op.queuedTx = append(op.queuedTx, req)
 
 	if op.currentReq == "" {
@@ -309,8 +390,8 @@ func (op *obcSieve) recvRequest(req *Request) {
 	}
 }
 
-func (op *obcSieve) recvComplaint(req *Request, senderID uint64) {
-	if op.pbft.primary(op.epoch) == op.id {
+func (op *obcSieve) recvComplaint(req *pbft.Request, senderID uint64) {
+	if op.pbft.Primary(op.epoch) == op.id {
 		op.recvRequest(req)
 		return
 	}
@@ -330,37 +411,63 @@ func (op *obcSieve) recvComplaint(req *Request, senderID uint64) {
 }
 
 func (op *obcSieve) processRequest() {
-	if len(op.queuedTx) == 0 || op.currentReq != "" {
+	if (len(op.queuedTx) == 0 && len(op.isolated) == 0) || op.currentReq != "" {
 		return
 	}
 
-	req := op.queuedTx[0]
-	op.queuedTx = op.queuedTx[1:]
+	var reqs []*pbft.Request
+	if len(op.isolated) > 0 {
+		reqs, op.isolated = op.isolated[:1], op.isolated[1:]
+	} else {
+		reqs = op.cutBatch()
+	}
 	op.verifyStore = nil
 
-	exec := &Execute{
+	exec := &pbft.Execute{
 		View:        op.epoch,
 		BlockNumber: op.blockNumber + 1,
-		Request:     req,
+		Requests:    reqs,
 		ReplicaId:   op.id,
 	}
-	logger.Debug("Sieve primary %d broadcasting execute epoch=%d, blockNo=%d",
-		op.id, exec.View, exec.BlockNumber)
-	op.broadcastMsg(&SieveMessage{&SieveMessage_Execute{exec}})
+	logger.Debug("Sieve primary %d broadcasting execute epoch=%d, blockNo=%d with %d requests",
+		op.id, exec.View, exec.BlockNumber, len(reqs))
+	op.broadcastMsg(&pbft.SieveMessage{Payload: &pbft.SieveMessage_Execute{Execute: exec}})
 	op.recvExecute(exec)
 }
 
-func (op *obcSieve) recvExecute(exec *Execute) {
-	if !(exec.View >= op.epoch && exec.BlockNumber > op.blockNumber && op.pbft.primary(exec.View) == exec.ReplicaId) {
-		logger.Debug("Replica %d got invalid execute from %d for view %d and block %d", op.pbft.id, exec.ReplicaId, exec.View, exec.BlockNumber)
+// cutBatch takes the requests which queued up while the previous block was
+// executed off the queue, up to the batch size and byte limit. A request
+// larger than the byte limit is executed on its own
+func (op *obcSieve) cutBatch() []*pbft.Request {
+	n, size := 1, proto.Size(op.queuedTx[0])
+	for ; n < len(op.queuedTx) && n < op.batchSize; n++ {
+		size += proto.Size(op.queuedTx[n])
+		if op.batchMaxBytes > 0 && size > op.batchMaxBytes {
+			break
+		}
+	}
+	reqs := op.queuedTx[:n:n]
+	op.queuedTx = op.queuedTx[n:]
+	return reqs
+}
+
+func (op *obcSieve) recvExecute(exec *pbft.Execute) {
+	if !(exec.View >= op.epoch && exec.BlockNumber > op.blockNumber && op.pbft.Primary(exec.View) == exec.ReplicaId) {
+		logger.Debug("Replica %d got invalid execute from %d for view %d and block %d", op.pbft.ID, exec.ReplicaId, exec.View, exec.BlockNumber)
 		return
 	}
 
 	// XXX check req sig
 
-	if !op.deduplicator.IsNew(exec.Request) {
-		logger.Debug("Sieve replica %d received exec of stale request from %d via %d",
-			op.id, exec.Request.ReplicaId, exec.ReplicaId)
+	fresh := false
+	for _, req := range exec.Requests {
+		if op.deduplicator.IsNew(req) {
+			fresh = true
+		}
+	}
+	if !fresh {
+		logger.Debug("Sieve replica %d received exec of stale requests via %d",
+			op.id, exec.ReplicaId)
 		return
 	}
 
@@ -375,7 +482,7 @@ func (op *obcSieve) processExecute() {
 		return
 	}
 
-	primary := op.pbft.primary(op.epoch)
+	primary := op.pbft.Primary(op.epoch)
 	exec := op.queuedExec[primary]
 	delete(op.queuedExec, primary)
 
@@ -383,7 +490,7 @@ func (op *obcSieve) processExecute() {
 		return
 	}
 
-	if !(exec.View == op.epoch && op.pbft.primary(op.epoch) == exec.ReplicaId && op.pbft.activeView) {
+	if !(exec.View == op.epoch && op.pbft.Primary(op.epoch) == exec.ReplicaId && op.pbft.ActiveView) {
 		logger.Debug("Invalid execute from %d", exec.ReplicaId)
 		return
 	}
@@ -394,8 +501,8 @@ func (op *obcSieve) processExecute() {
 		return
 	}
 
-	op.currentReqFull = exec.Request
-	op.currentReq = hashReq(op.currentReqFull)
+	op.currentBatch = exec.Requests
+	op.currentReq = pbft.HashBatch(op.pbft.Digest, op.currentBatch)
 
 	logger.Debug("Sieve replica %d received exec from %d, epoch=%d, blockNo=%d, request=%s",
 		op.id, exec.ReplicaId, exec.View, exec.BlockNumber, op.currentReq)
@@ -412,17 +519,28 @@ func (op *obcSieve) processExecute() {
 
 	op.blockNumber = exec.BlockNumber
 
-	tx := &pb.Transaction{}
-	proto.Unmarshal(exec.Request.Payload, tx)
+	var txs []*pb.Transaction
+	for _, req := range exec.Requests {
+		if !op.deduplicator.IsNew(req) {
+			logger.Debug("Sieve replica %d skipping stale request from %d in block %d", op.id, req.ReplicaId, exec.BlockNumber)
+			continue
+		}
+		tx := &pb.Transaction{}
+		if err := proto.Unmarshal(req.Payload, tx); err != nil {
+			logger.Warning("Sieve replica %d could not unmarshal transaction: %s", op.id, err)
+			continue
+		}
+		txs = append(txs, tx)
+	}
 
 	op.stack.BeginTxBatch(op.currentReq)
-	results, err := op.stack.ExecTxs(op.currentReq, []*pb.Transaction{tx})
+	results, err := op.stack.ExecTxs(op.currentReq, txs)
 	_ = results // XXX what to do?
 	_ = err     // XXX what to do?
 
 	logger.Debug("Sieve replica %d results=%x err=%v using lastPbftExec of %d", op.id, results, err, op.lastExecPbftSeqNo)
 
-	meta, _ := proto.Marshal(&Metadata{op.lastExecPbftSeqNo})
+	meta, _ := proto.Marshal(&pbft.Metadata{SeqNo: op.lastExecPbftSeqNo, Epoch: op.pbft.CurrentEpoch()})
 	op.currentResult, err = op.stack.PreviewCommitTxBatch(op.currentReq, meta)
 	if err != nil {
 		logger.Error("could not preview next block: %s", err)
@@ -432,34 +550,34 @@ func (op *obcSieve) processExecute() {
 
 	logger.Debug("Sieve replica %d executed blockNo=%d, request=%s", op.id, op.blockNumber, op.currentReq)
 
-	verify := &Verify{
+	verify := &pbft.Verify{
 		View:          op.epoch,
 		BlockNumber:   op.blockNumber,
 		RequestDigest: op.currentReq,
 		ResultDigest:  op.currentResult,
 		ReplicaId:     op.id,
 	}
-	op.pbft.sign(verify)
+	op.pbft.Sign(verify)
 
 	logger.Debug("Sieve replica %d sending verify blockNo=%d with result %x",
 		op.id, verify.BlockNumber, op.currentResult)
 
 	op.recvVerify(verify)
-	op.broadcastMsg(&SieveMessage{&SieveMessage_Verify{verify}})
+	op.broadcastMsg(&pbft.SieveMessage{Payload: &pbft.SieveMessage_Verify{Verify: verify}})
 
 	// To prevent races, have the main pbft thread start this timer, as it will need to stop it
-	op.pbft.inject(func() { op.pbft.startTimer(op.pbft.requestTimeout, fmt.Sprintf("new request %s", op.currentReq)) })
+	op.pbft.Inject(func() { op.pbft.StartTimer(op.pbft.GetRequestTimeout(), fmt.Sprintf("new request %s", op.currentReq)) })
 }
 
-func (op *obcSieve) recvVerify(verify *Verify) {
-	if op.pbft.primary(op.epoch) != op.id || !op.pbft.activeView {
+func (op *obcSieve) recvVerify(verify *pbft.Verify) {
+	if op.pbft.Primary(op.epoch) != op.id || !op.pbft.ActiveView {
 		return
 	}
 
 	logger.Debug("Sieve primary %d received verify from %d, blockNo=%d, result %x",
 		op.id, verify.ReplicaId, verify.BlockNumber, verify.ResultDigest)
 
-	if err := op.pbft.verify(verify); err != nil {
+	if err := op.pbft.Verify(verify); err != nil {
 		logger.Warning("Invalid verify message: %s", err)
 		return
 	}
@@ -489,15 +607,15 @@ func (op *obcSieve) recvVerify(verify *Verify) {
 	if len(op.verifyStore) == op.moreCorrectThanByzantineQuorum() {
 		logger.Debug("Sieve primary %d has enough verify records to make decision", op.id)
 		dSet, _ := op.verifyDset(op.verifyStore)
-		verifySet := &VerifySet{
+		verifySet := &pbft.VerifySet{
 			View:          op.epoch,
 			BlockNumber:   op.blockNumber,
 			RequestDigest: op.currentReq,
 			Dset:          dSet,
 		}
 		verifySet.ReplicaId = op.id
-		op.pbft.sign(verifySet)
-		req := &SievePbftMessage{Payload: &SievePbftMessage_VerifySet{verifySet}}
+		op.pbft.Sign(verifySet)
+		req := &pbft.SievePbftMessage{Payload: &pbft.SievePbftMessage_VerifySet{VerifySet: verifySet}}
 		op.invokePbft(req)
 		logger.Debug("Sieve primary %d sent request to PBFT for final ordering", op.id)
 	} else {
@@ -505,14 +623,14 @@ func (op *obcSieve) recvVerify(verify *Verify) {
 	}
 }
 
-func (op *obcSieve) verifyDset(inDset []*Verify) (dSet []*Verify, ok bool) {
-	sortV := make(map[string][]*Verify)
+func (op *obcSieve) verifyDset(inDset []*pbft.Verify) (dSet []*pbft.Verify, ok bool) {
+	sortV := make(map[string][]*pbft.Verify)
 	for _, v := range inDset {
 		s := base64.StdEncoding.EncodeToString(v.ResultDigest)
 		sortV[s] = append(sortV[s], v)
 	}
 	for _, vs := range sortV {
-		if len(vs) >= op.pbft.f+1 {
+		if len(vs) >= op.pbft.F+1 {
 			dSet = vs
 			ok = true
 			return
@@ -524,8 +642,8 @@ func (op *obcSieve) verifyDset(inDset []*Verify) (dSet []*Verify, ok bool) {
 }
 
 // validate checks whether the request is valid syntactically
-func (op *obcSieve) validate(rawReq []byte) error {
-	req := &SievePbftMessage{}
+func (op *obcSieve) ValidateRequest(rawReq []byte) error {
+	req := &pbft.SievePbftMessage{}
 	err := proto.Unmarshal(rawReq, req)
 	if err != nil {
 		return err
@@ -540,17 +658,17 @@ func (op *obcSieve) validate(rawReq []byte) error {
 	}
 }
 
-func (op *obcSieve) validateVerifySet(vset *VerifySet) error {
-	if err := op.pbft.verify(vset); err != nil {
+func (op *obcSieve) validateVerifySet(vset *pbft.VerifySet) error {
+	if err := op.pbft.Verify(vset); err != nil {
 		return err
 	}
-	if vset.ReplicaId != op.pbft.primary(vset.View) {
+	if vset.ReplicaId != op.pbft.Primary(vset.View) {
 		return fmt.Errorf("pbft request from non-primary")
 	}
 
 	dups := make(map[uint64]bool)
 	for _, v := range vset.Dset {
-		if err := op.pbft.verify(v); err != nil {
+		if err := op.pbft.Verify(v); err != nil {
 			logger.Warning("verify-set invalid: %s", err)
 			return err
 		}
@@ -570,9 +688,9 @@ func (op *obcSieve) validateVerifySet(vset *VerifySet) error {
 		}
 	}
 
-	if len(vset.Dset) < op.pbft.f+1 {
+	if len(vset.Dset) < op.pbft.F+1 {
 		err := fmt.Errorf("verify-set invalid: not enough verifies in vset: need at least %d, got %d",
-			op.pbft.f+1, len(vset.Dset))
+			op.pbft.F+1, len(vset.Dset))
 		logger.Error(err.Error())
 		return err
 	}
@@ -588,11 +706,11 @@ func (op *obcSieve) validateVerifySet(vset *VerifySet) error {
 	return nil
 }
 
-func (op *obcSieve) validateFlush(flush *Flush) error {
-	if err := op.pbft.verify(flush); err != nil {
+func (op *obcSieve) validateFlush(flush *pbft.Flush) error {
+	if err := op.pbft.Verify(flush); err != nil {
 		return err
 	}
-	if flush.ReplicaId != op.pbft.primary(flush.View) {
+	if flush.ReplicaId != op.pbft.Primary(flush.View) {
 		return fmt.Errorf("pbft request from non-primary")
 	}
 
@@ -614,32 +732,33 @@ func (op *obcSieve) main() {
 			}
 
 		case exec := <-op.executeChan:
-			op.executeImpl(exec.seqNo, exec.txRaw)
-		case <-op.pbft.closed:
+			op.executeImpl(exec.seqNo, exec.txRaw, exec.done)
+		case <-op.pbft.Closed:
 			logger.Debug("Sieve replica %d requested to stop", op.id)
 			close(op.idleChan)
 			return
 		case update := <-op.stateUpdatingChan:
-			op.pbft.stateUpdating(update.seqNo, update.id)
+			op.pbft.StateUpdating(update.SeqNo, update.ID)
 		case update := <-op.stateUpdatedChan:
 			op.restoreBlockNumber()
 
-			op.lastExecPbftSeqNo = update.seqNo
+			op.lastExecPbftSeqNo = update.SeqNo
 
-			op.pbft.stateUpdated(update.seqNo, update.id)
+			op.pbft.StateUpdated(update.SeqNo, update.ID)
 
-			if op.execOutstanding {
-				op.pbft.execDone()
+			if op.execOutstanding != nil {
+				op.execOutstanding(nil)
+				op.execOutstanding = nil
 				op.execDone()
 			}
 		case c := <-op.custodyTimerChan:
 			if !c.complaint {
 				logger.Warning("Sieve replica %d custody expired, complaining: %s", op.id, c.hash)
-				op.broadcastMsg(&SieveMessage{&SieveMessage_Complaint{c.req.(*Request)}})
+				op.broadcastMsg(&pbft.SieveMessage{Payload: &pbft.SieveMessage_Complaint{Complaint: c.req.(*pbft.Request)}})
 			} else {
-				if op.pbft.activeView {
+				if op.pbft.ActiveView {
 					logger.Debug("Sieve replica %d complaint timeout expired for %s", op.id, c.hash)
-					op.pbft.sendViewChange()
+					op.pbft.SendViewChange()
 				}
 			}
 		case op.idleChan <- struct{}{}:
@@ -650,32 +769,33 @@ func (op *obcSieve) main() {
 
 // called by pbft-core to execute an opaque request,
 // which is a totally-ordered `Decision`
-func (op *obcSieve) execute(seqNo uint64, raw []byte) {
+func (op *obcSieve) Execute(seqNo uint64, raw []byte, done pbft.ExecCallback) {
 	op.executeChan <- &pbftExecute{
 		seqNo: seqNo,
 		txRaw: raw,
+		done:  done,
 	}
 	logger.Debug("Sieve replica %d successfully sent transaction for sequence number %d", op.id, seqNo)
 }
 
-func (op *obcSieve) executeImpl(seqNo uint64, raw []byte) {
-	req := &SievePbftMessage{}
+func (op *obcSieve) executeImpl(seqNo uint64, raw []byte, done pbft.ExecCallback) {
+	req := &pbft.SievePbftMessage{}
 	err := proto.Unmarshal(raw, req)
 	if err != nil {
 		return
 	}
 
 	if vset := req.GetVerifySet(); vset != nil {
-		op.executeVerifySet(vset, seqNo)
+		op.executeVerifySet(vset, seqNo, done)
 	} else if flush := req.GetFlush(); flush != nil {
 		op.executeFlush(flush)
-		op.pbft.execDone()
+		done(nil)
 	} else {
 		logger.Warning("Invalid pbft request")
 	}
 }
 
-func (op *obcSieve) executeVerifySet(vset *VerifySet, seqNo uint64) {
+func (op *obcSieve) executeVerifySet(vset *pbft.VerifySet, seqNo uint64, done pbft.ExecCallback) {
 	sync := false
 
 	logger.Debug("Replica %d received verify-set from pbft, view %d, block %d",
@@ -705,12 +825,6 @@ func (op *obcSieve) executeVerifySet(vset *VerifySet, seqNo uint64) {
 		sync = true
 	}
 
-	op.complainer.Success(op.currentReqFull)
-
-	if !op.deduplicator.Execute(op.currentReqFull) {
-		logger.Error("Replica %d executing stale request %s, this indicates a bug", op.id, op.currentReq)
-	}
-
 	if vset.BlockNumber != op.blockNumber {
 		logger.Debug("Replica %d received verify-set for wrong block: expected %d, got %d",
 			op.id, op.blockNumber, vset.BlockNumber)
@@ -725,11 +839,30 @@ func (op *obcSieve) executeVerifySet(vset *VerifySet, seqNo uint64) {
 
 	dSet, shouldCommit := op.verifyDset(vset.Dset)
 
+	// The requests of a non-deterministic batch are executed again one at a
+	// time, only a non-deterministic request on its own is discarded
+	batch := op.currentBatch
+	if shouldCommit || len(batch) <= 1 {
+		for _, req := range batch {
+			op.complainer.Success(req)
+			if !op.deduplicator.Execute(req) {
+				logger.Debug("Replica %d skipped stale request from %d in block %d", op.id, req.ReplicaId, vset.BlockNumber)
+			}
+		}
+	}
+
 	if !shouldCommit {
 		if !sync {
 			logger.Warning("Sieve replica %d execute vset: not deterministic", op.id)
 
 			op.rollback()
+			op.lastExecPbftSeqNo = seqNo
+			if len(batch) > 1 && op.pbft.Primary(op.epoch) == op.id {
+				logger.Info("Sieve primary %d executing the %d requests of non-deterministic block %d one at a time", op.id, len(batch), vset.BlockNumber)
+				op.isolated = append(append([]*pbft.Request(nil), batch...), op.isolated...)
+			} else if len(batch) == 1 {
+				logger.Warning("Sieve replica %d discarding non-deterministic request %s", op.id, pbft.HashReq(op.pbft.Digest, batch[0]))
+			}
 		} else {
 			logger.Debug("Sieve replica %d told to roll back transactions for a block it doesn't have")
 		}
@@ -755,28 +888,29 @@ func (op *obcSieve) executeVerifySet(vset *VerifySet, seqNo uint64) {
 			logger.Debug("Sieve replica %d must sync to decision %x for block %d", op.id, decision, vset.BlockNumber)
 
 			op.rollback()
-			op.execOutstanding = true
+			op.execOutstanding = done
 			op.sync(seqNo, decision, peers)
 			return
 		}
 	}
-	op.pbft.execDone()
+	done(nil)
 	op.execDone()
 }
 
 func (op *obcSieve) execDone() {
 	op.currentReq = ""
+	op.currentBatch = nil
 
-	if len(op.queuedTx) > 0 {
+	if len(op.queuedTx) > 0 || len(op.isolated) > 0 {
 		op.processRequest()
 	}
 
-	if op.pbft.primary(op.epoch) != op.id {
+	if op.pbft.Primary(op.epoch) != op.id {
 		op.processExecute()
 	}
 }
 
-func (op *obcSieve) executeFlush(flush *Flush) {
+func (op *obcSieve) executeFlush(flush *pbft.Flush) {
 	logger.Debug("Replica %d received flush from pbft", op.id)
 	if flush.View < op.epoch {
 		logger.Warning("Replica %d ignoring old flush for epoch %d, we are in epoch %d",
@@ -786,6 +920,7 @@ func (op *obcSieve) executeFlush(flush *Flush) {
 	op.epoch = flush.View
 	logger.Info("Replica %d advancing epoch to %d", op.id, op.epoch)
 	op.queuedTx = nil
+	op.isolated = nil
 	if op.currentReq != "" {
 		logger.Info("Replica %d rolling back speculative execution", op.id)
 		op.rollback()
@@ -798,23 +933,23 @@ func (op *obcSieve) executeFlush(flush *Flush) {
 	}
 }
 
-func (op *obcSieve) skipTo(seqNo uint64, id []byte, replicas []uint64) {
+func (op *obcSieve) SkipTo(seqNo uint64, id []byte, replicas []uint64) {
 	op.sync(seqNo, id, replicas)
 }
 
 // StateUpdated is a signal from the stack that it has fast-forwarded its state
 func (op *obcSieve) StateUpdated(seqNo uint64, id []byte) {
-	op.stateUpdatedChan <- &checkpointMessage{
-		seqNo: seqNo,
-		id:    id,
+	op.stateUpdatedChan <- &pbft.CheckpointMessage{
+		SeqNo: seqNo,
+		ID:    id,
 	}
 }
 
 // StateUpdating is a signal from the stack that state transfer has started
 func (op *obcSieve) StateUpdating(seqNo uint64, id []byte) {
-	op.stateUpdatingChan <- &checkpointMessage{
-		seqNo: seqNo,
-		id:    id,
+	op.stateUpdatingChan <- &pbft.CheckpointMessage{
+		SeqNo: seqNo,
+		ID:    id,
 	}
 }
 
@@ -823,7 +958,7 @@ func (op *obcSieve) sync(seqNo uint64, id []byte, peers []uint64) {
 		op.rollback()
 	}
 	op.stack.InvalidateState()
-	op.obcGeneric.skipTo(seqNo, id, peers)
+	op.obcGeneric.SkipTo(seqNo, id, peers)
 }
 
 func (op *obcSieve) rollback() {
@@ -835,7 +970,7 @@ func (op *obcSieve) rollback() {
 }
 
 func (op *obcSieve) commit() {
-	meta, _ := proto.Marshal(&Metadata{op.lastExecPbftSeqNo})
+	meta, _ := proto.Marshal(&pbft.Metadata{SeqNo: op.lastExecPbftSeqNo, Epoch: op.pbft.CurrentEpoch()})
 	op.stack.CommitTxBatch(op.currentReq, meta)
 	op.currentReq = ""
 }
@@ -23,15 +23,16 @@ import (
 	"time"
 
 	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
+	"github.com/hyperledger/fabric/consensus/testkit"
+	pb "github.com/hyperledger/fabric/protos"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/spf13/viper"
-
-	pb "github.com/hyperledger/fabric/protos"
 )
 
-func (op *obcSieve) getPBFTCore() *pbftCore {
-	return op.legacyGenericShim.pbft.pbftCore
+func (op *obcSieve) getPBFTCore() *pbft.PbftCore {
+	return op.legacyGenericShim.pbft.PbftCore
 }
 
 func obcSieveHelper(id uint64, config *viper.Viper, stack consensus.Stack) pbftConsumer {
@@ -42,50 +43,50 @@ func obcSieveHelper(id uint64, config *viper.Viper, stack consensus.Stack) pbftC
 func TestSieveNetwork(t *testing.T) {
 	validatorCount := 4
 	net := makeConsumerNetwork(validatorCount, obcSieveHelper)
-	defer net.stop()
+	defer net.Stop()
 
-	net.debug = true
+	net.Debug = true
 
 	req1 := createOcMsgWithChainTx(1)
-	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(req1, net.endpoints[generateBroadcaster(validatorCount)].getHandle())
-	net.process()
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(req1, net.Endpoints[generateBroadcaster(validatorCount)].GetHandle())
+	net.Process()
 	req0 := createOcMsgWithChainTx(2)
-	net.endpoints[0].(*consumerEndpoint).consumer.RecvMsg(req0, net.endpoints[generateBroadcaster(validatorCount)].getHandle())
-	net.process()
+	net.Endpoints[0].(*consumerEndpoint).consumer.RecvMsg(req0, net.Endpoints[generateBroadcaster(validatorCount)].GetHandle())
+	net.Process()
 
-	testblock := func(ep endpoint, blockNo uint64, msg *pb.Message) {
+	testblock := func(ep testkit.Endpoint, blockNo uint64, msg *pb.Message) {
 		cep := ep.(*consumerEndpoint)
 		block, err := cep.consumer.(*obcSieve).stack.GetBlock(blockNo)
 		if err != nil {
-			t.Fatalf("Replica %d could not retrieve block %d: %s", cep.id, blockNo, err)
+			t.Fatalf("Replica %d could not retrieve block %d: %s", cep.ID, blockNo, err)
 		}
 		txs := block.GetTransactions()
 		if len(txs) != 1 {
-			t.Fatalf("Replica %d block %v contains %d transactions, expected 1", cep.id, blockNo, len(txs))
+			t.Fatalf("Replica %d block %v contains %d transactions, expected 1", cep.ID, blockNo, len(txs))
 		}
 		if numTxResults := len(block.NonHashData.TransactionResults); numTxResults != 1 {
-			t.Fatalf("Replica %d block %v has %d txResults, expected 1", cep.id, blockNo, numTxResults)
+			t.Fatalf("Replica %d block %v has %d txResults, expected 1", cep.ID, blockNo, numTxResults)
 		}
 
 		msgTx := &pb.Transaction{}
 		proto.Unmarshal(msg.Payload, msgTx)
 		if !reflect.DeepEqual(txs[0], msgTx) {
-			t.Errorf("Replica %d transaction does not match; is %+v, should be %+v", cep.id, txs[0], msgTx)
+			t.Errorf("Replica %d transaction does not match; is %+v, should be %+v", cep.ID, txs[0], msgTx)
 		}
 	}
 
-	for _, ep := range net.endpoints {
+	for _, ep := range net.Endpoints {
 		cep := ep.(*consumerEndpoint)
 		blockchainSize := cep.consumer.(*obcSieve).stack.GetBlockchainSize() - 1
 		if blockchainSize != 2 {
-			t.Errorf("Replica %d has incorrect blockchain size; is %d, should be 2", cep.id, blockchainSize)
+			t.Errorf("Replica %d has incorrect blockchain size; is %d, should be 2", cep.ID, blockchainSize)
 		}
 		testblock(cep, 1, req1)
 		testblock(cep, 2, req0)
 
 		if cep.consumer.(*obcSieve).epoch != 0 {
 			t.Errorf("Replica %d in epoch %d, expected 0",
-				cep.id, cep.consumer.(*obcSieve).epoch)
+				cep.ID, cep.consumer.(*obcSieve).epoch)
 		}
 	}
 }
@@ -97,14 +98,14 @@ func TestSieveNetwork(t *testing.T) {
 func TestSieveNoDecision(t *testing.T) {
 	validatorCount := 4
 	net := makeConsumerNetwork(validatorCount, obcSieveHelper, func(ce *consumerEndpoint) {
-		ce.consumer.(*obcSieve).pbft.requestTimeout = 400 * time.Millisecond
-		ce.consumer.(*obcSieve).pbft.newViewTimeout = 1200 * time.Millisecond
-		ce.consumer.(*obcSieve).pbft.lastNewViewTimeout = 1200 * time.Millisecond
+		ce.consumer.(*obcSieve).pbft.RequestTimeout = 400 * time.Millisecond
+		ce.consumer.(*obcSieve).pbft.NewViewTimeout = 1200 * time.Millisecond
+		ce.consumer.(*obcSieve).pbft.LastNewViewTimeout = 1200 * time.Millisecond
 	})
-	// net.debug = true // Enable for debug
-	net.testnet.filterFn = func(src int, dst int, raw []byte) []byte {
+	// net.Debug = true // Enable for debug
+	net.Network.FilterFn = func(src int, dst int, raw []byte) []byte {
 		if dst == -1 && src == 0 {
-			sieve := &SieveMessage{}
+			sieve := &pbft.SieveMessage{}
 			if err := proto.Unmarshal(raw, sieve); nil != err {
 				panic("Should only ever encounter sieve messages")
 			}
@@ -115,28 +116,28 @@ func TestSieveNoDecision(t *testing.T) {
 		return raw
 	}
 
-	fmt.Printf("DEBUG: filterFn is %p and net is %p\n", net.testnet.filterFn, net.testnet)
+	fmt.Printf("DEBUG: filterFn is %p and net is %p\n", net.Network.FilterFn, net.Network)
 
-	broadcaster := net.endpoints[generateBroadcaster(validatorCount)].getHandle()
-	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
 
-	go net.processContinually()
+	go net.ProcessContinually()
 	time.Sleep(2 * time.Second)
-	net.endpoints[3].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(2), broadcaster)
+	net.Endpoints[3].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(2), broadcaster)
 	time.Sleep(5 * time.Second)
-	net.stop()
+	net.Stop()
 
-	for _, ep := range net.endpoints {
+	for _, ep := range net.Endpoints {
 		cep := ep.(*consumerEndpoint)
 		newBlocks := cep.consumer.(*obcSieve).stack.GetBlockchainSize() - 1
 		if newBlocks != 2 {
 			t.Errorf("replica %d executed %d requests, expected %d",
-				cep.id, newBlocks, 2)
+				cep.ID, newBlocks, 2)
 		}
 
 		if cep.consumer.(*obcSieve).epoch != 1 {
 			t.Errorf("replica %d in epoch %d, expected 1",
-				cep.id, cep.consumer.(*obcSieve).epoch)
+				cep.ID, cep.consumer.(*obcSieve).epoch)
 		}
 	}
 }
@@ -144,23 +145,23 @@ func TestSieveNoDecision(t *testing.T) {
 func TestSieveReqBackToBack(t *testing.T) {
 	validatorCount := 4
 	net := makeConsumerNetwork(validatorCount, obcSieveHelper)
-	defer net.stop()
+	defer net.Stop()
 
-	var delayPkt []taggedMsg
+	var delayPkt []testkit.TaggedMsg
 	gotExec := 0
-	net.filterFn = func(src int, dst int, payload []byte) []byte {
+	net.FilterFn = func(src int, dst int, payload []byte) []byte {
 		if dst == 3 {
-			sieve := &SieveMessage{}
+			sieve := &pbft.SieveMessage{}
 			proto.Unmarshal(payload, sieve)
 			if gotExec < 2 && sieve.GetPbftMessage() != nil {
-				delayPkt = append(delayPkt, taggedMsg{src, dst, payload})
+				delayPkt = append(delayPkt, testkit.TaggedMsg{Src: src, Dst: dst, Msg: payload})
 				return nil
 			}
 			if sieve.GetExecute() != nil {
 				gotExec++
 				if gotExec == 2 {
 					for _, d := range delayPkt {
-						net.msgs <- d
+						net.Msgs <- d
 					}
 					delayPkt = nil
 				}
@@ -169,23 +170,23 @@ func TestSieveReqBackToBack(t *testing.T) {
 		return payload
 	}
 
-	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), net.endpoints[generateBroadcaster(validatorCount)].getHandle())
-	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(2), net.endpoints[generateBroadcaster(validatorCount)].getHandle())
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), net.Endpoints[generateBroadcaster(validatorCount)].GetHandle())
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(2), net.Endpoints[generateBroadcaster(validatorCount)].GetHandle())
 
-	net.process()
+	net.Process()
 
-	for _, ep := range net.endpoints {
+	for _, ep := range net.Endpoints {
 		cep := ep.(*consumerEndpoint)
 		newBlocks := cep.consumer.(*obcSieve).stack.GetBlockchainSize()
 		newBlocks--
 		if newBlocks != 2 {
 			t.Errorf("Replica %d executed %d requests, expected %d",
-				cep.id, newBlocks, 2)
+				cep.ID, newBlocks, 2)
 		}
 
 		if cep.consumer.(*obcSieve).epoch != 0 {
 			t.Errorf("Replica %d in epoch %d, expected 0",
-				cep.id, cep.consumer.(*obcSieve).epoch)
+				cep.ID, cep.consumer.(*obcSieve).epoch)
 		}
 	}
 }
@@ -195,31 +196,35 @@ func TestSieveNonDeterministic(t *testing.T) {
 	validatorCount := 4
 	net := makeConsumerNetwork(validatorCount, obcSieveHelper, func(ce *consumerEndpoint) {
 		ce.execTxResult = func(tx []*pb.Transaction) ([]byte, error) {
-			res := fmt.Sprintf("%d %s", instResults[ce.id], tx)
-			fmt.Printf("State hash for %d: %s\n", ce.id, res)
+			res := fmt.Sprintf("%d %s", instResults[ce.ID], tx)
+			fmt.Printf("State hash for %d: %s\n", ce.ID, res)
 			return []byte(res), nil
 		}
 	})
-	defer net.stop()
+	defer net.Stop()
 
 	instResults = []int{1, 2, 3, 4}
-	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), net.endpoints[generateBroadcaster(validatorCount)].getHandle())
-	net.process()
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), net.Endpoints[generateBroadcaster(validatorCount)].GetHandle())
+	net.Process()
 
 	instResults = []int{5, 5, 6, 6}
-	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(2), net.endpoints[generateBroadcaster(validatorCount)].getHandle())
+	net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(2), net.Endpoints[generateBroadcaster(validatorCount)].GetHandle())
 
-	net.process()
+	net.Process()
 
-	results := make([][]byte, len(net.endpoints))
-	for _, ep := range net.endpoints {
+	results := make([][]byte, len(net.Endpoints))
+	for _, ep := range net.Endpoints {
 		cep := ep.(*consumerEndpoint)
 		block, err := cep.consumer.(*obcSieve).stack.GetBlock(1)
 		if err != nil {
-			t.Fatalf("Expected replica %d to have one block", cep.id)
+			t.Fatalf("Expected replica %d to have one block", cep.ID)
 		}
+		// Replicas which synced to the decision record the sequence number
+		// they transferred state to as metadata
+		block = proto.Clone(block).(*pb.Block)
+		block.ConsensusMetadata = nil
 		blockRaw, _ := proto.Marshal(block)
-		results[cep.id] = blockRaw
+		results[cep.ID] = blockRaw
 	}
 	if !(reflect.DeepEqual(results[0], results[1]) &&
 		reflect.DeepEqual(results[0], results[2]) &&
@@ -228,10 +233,54 @@ func TestSieveNonDeterministic(t *testing.T) {
 	}
 }
 
+func TestSieveBatchNonDeterministic(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, func(id uint64, config *viper.Viper, stack consensus.Stack) pbftConsumer {
+		config.Set("general.batchsize", 2)
+		return newObcSieve(id, config, stack)
+	}, func(ce *consumerEndpoint) {
+		ce.execTxResult = func(txs []*pb.Transaction) ([]byte, error) {
+			var res []byte
+			for _, tx := range txs {
+				res = append(res, tx.Payload...)
+				if string(tx.Payload) == "3" {
+					res = append(res, fmt.Sprintf(" on replica %d", ce.ID)...)
+				}
+			}
+			return res, nil
+		}
+	})
+	defer net.Stop()
+
+	// Requests 2 and 3 queue up while request 1 is executed, and are
+	// executed as one block, which is non-deterministic due to request 3
+	primary := net.Endpoints[0].(*consumerEndpoint).consumer
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	for i := int64(1); i <= 3; i++ {
+		primary.RecvMsg(createOcMsgWithChainTx(i), broadcaster)
+	}
+	net.Process()
+
+	for _, ep := range net.Endpoints {
+		cep := ep.(*consumerEndpoint)
+		stack := cep.consumer.(*obcSieve).stack
+		if size := stack.GetBlockchainSize() - 1; size != 2 {
+			t.Errorf("Replica %d has %d blocks, expected the non-deterministic request to be discarded and 2 blocks", cep.ID, size)
+			continue
+		}
+		for blockNo, payload := range map[uint64]string{1: "1", 2: "2"} {
+			block, _ := stack.GetBlock(blockNo)
+			if txs := block.GetTransactions(); len(txs) != 1 || string(txs[0].Payload) != payload {
+				t.Errorf("Replica %d expected block %d to hold request %s, got %v", cep.ID, blockNo, payload, txs)
+			}
+		}
+	}
+}
+
 func TestSieveRequestHash(t *testing.T) {
 	validatorCount := 1
 	net := makeConsumerNetwork(validatorCount, obcSieveHelper)
-	defer net.stop()
+	defer net.Stop()
 
 	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY, Payload: make([]byte, 1000)}
 	txPacked, _ := proto.Marshal(tx)
@@ -240,8 +289,8 @@ func TestSieveRequestHash(t *testing.T) {
 		Payload: txPacked,
 	}
 
-	r0 := net.endpoints[0].(*consumerEndpoint)
-	r0.consumer.RecvMsg(msg, r0.getHandle())
+	r0 := net.Endpoints[0].(*consumerEndpoint)
+	r0.consumer.RecvMsg(msg, r0.GetHandle())
 
 	// This used to be enormous, verify that it is short
 	txID := fmt.Sprintf("%v", net.mockLedgers[0].txID)
@@ -257,7 +306,7 @@ func TestSieveCustody(t *testing.T) {
 		config.Set("general.timeout.viewchange", "1600ms")
 		return newObcSieve(id, config, stack)
 	})
-	net.filterFn = func(src int, dst int, payload []byte) []byte {
+	net.FilterFn = func(src int, dst int, payload []byte) []byte {
 		logger.Info("msg from %d to %d", src, dst)
 		if src == 0 {
 			return nil
@@ -265,17 +314,17 @@ func TestSieveCustody(t *testing.T) {
 		return payload
 	}
 
-	go net.processContinually()
-	r2 := net.endpoints[2].(*consumerEndpoint).consumer
-	r2.RecvMsg(createOcMsgWithChainTx(1), net.endpoints[1].getHandle())
+	go net.ProcessContinually()
+	r2 := net.Endpoints[2].(*consumerEndpoint).consumer
+	r2.RecvMsg(createOcMsgWithChainTx(1), net.Endpoints[1].GetHandle())
 	time.Sleep(6 * time.Second)
-	net.stop()
+	net.Stop()
 
-	for _, inst := range net.endpoints {
+	for _, inst := range net.Endpoints {
 		inst := inst.(*consumerEndpoint)
 		_, err := inst.consumer.(*obcSieve).stack.GetBlock(1)
 		if err != nil {
-			t.Errorf("Expected replica %d to have one block", inst.id)
+			t.Errorf("Expected replica %d to have one block", inst.ID)
 			continue
 		}
 	}
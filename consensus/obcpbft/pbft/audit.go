@@ -0,0 +1,179 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+	google_protobuf "google/protobuf"
+)
+
+const (
+	auditRecordPrefix = "audit.record."
+	auditHeadKey      = "audit.head"
+)
+
+// auditTrail records every sequence number the replica executes, along with
+// the commit certificate which justified its execution. Records are signed
+// by the replica and each one carries the digest of its predecessor, so that
+// records cannot be altered or removed later without breaking the chain. The
+// chain runs across epochs, whose records are kept apart as sequence numbers
+// restart at 0 in every epoch
+type auditTrail struct {
+	previous []byte // digest of the last record, persisted as the head of the trail
+}
+
+// newAuditTrail returns nil if general.audit is not set
+func newAuditTrail(config *viper.Viper, persistor Persistor) *auditTrail {
+	if !config.GetBool("general.audit") {
+		return nil
+	}
+	at := &auditTrail{}
+	if head, err := persistor.ReadState(auditHeadKey); err == nil {
+		at.previous = head
+	}
+	return at
+}
+
+// auditRecordKey returns the key of the record of sequence number n of epoch.
+// Records of epoch 0 keep the keys they had before sequence numbers were
+// assigned in epochs, so that trails recorded earlier remain readable
+func auditRecordKey(epoch, n uint64) string {
+	if epoch == 0 {
+		return fmt.Sprintf("%s%020d", auditRecordPrefix, n)
+	}
+	return fmt.Sprintf("%s%020d.%020d", auditRecordPrefix, epoch, n)
+}
+
+// parseAuditRecordKey is the inverse of auditRecordKey
+func parseAuditRecordKey(key string) (epoch, n uint64, ok bool) {
+	fields := strings.Split(strings.TrimPrefix(key, auditRecordPrefix), ".")
+	var err error
+	switch len(fields) {
+	case 1:
+		n, err = strconv.ParseUint(fields[0], 10, 64)
+		return 0, n, err == nil
+	case 2:
+		if epoch, err = strconv.ParseUint(fields[0], 10, 64); err != nil || epoch == 0 {
+			return 0, 0, false
+		}
+		n, err = strconv.ParseUint(fields[1], 10, 64)
+		return epoch, n, err == nil
+	}
+	return 0, 0, false
+}
+
+// recordAudit appends the record for a sequence number we are about to
+// execute to the audit trail
+func (instance *PbftCore) recordAudit(idx msgID, digest string) {
+	if instance.audit == nil {
+		return
+	}
+
+	now := instance.Clock.Now()
+	epoch := instance.CurrentEpoch()
+	ar := &AuditRecord{
+		Epoch:          epoch,
+		SequenceNumber: idx.n,
+		View:           idx.v,
+		RequestDigest:  digest,
+		Previous:       instance.audit.previous,
+		ReplicaId:      instance.ID,
+		Timestamp: &google_protobuf.Timestamp{
+			Seconds: now.Unix(),
+			Nanos:   int32(now.UnixNano() % 1000000000),
+		},
+	}
+	for _, commit := range instance.certStore[idx].commit {
+		if commit.RequestDigest == digest {
+			ar.Commits = append(ar.Commits, commit)
+		}
+	}
+
+	if err := instance.Sign(ar); err != nil {
+		instance.log.Error("Could not sign audit record for seqNo %d: %s", idx.n, err)
+		return
+	}
+	raw, err := proto.Marshal(ar)
+	if err != nil {
+		instance.log.Error("Could not marshal audit record for seqNo %d: %s", idx.n, err)
+		return
+	}
+	head := instance.Digest.hash(raw)
+	if err := instance.persistor.StoreState(auditRecordKey(epoch, idx.n), raw); err != nil {
+		instance.log.Error("Could not persist audit record for seqNo %d: %s", idx.n, err)
+		return
+	}
+	if err := instance.persistor.StoreState(auditHeadKey, head); err != nil {
+		instance.log.Error("Could not persist head of the audit trail: %s", err)
+	}
+	instance.audit.previous = head
+}
+
+// readAuditTrail returns the serialized records of the sequence numbers from
+// from to to of epoch we executed, sequence numbers we did not execute, for
+// instance because we skipped them through state transfer, are omitted
+func (instance *PbftCore) readAuditTrail(epoch, from, to uint64) [][]byte {
+	current := instance.CurrentEpoch()
+	if epoch > current {
+		return nil
+	}
+	if epoch == current && to > instance.LastExec {
+		to = instance.LastExec
+	}
+
+	prefix := auditRecordPrefix
+	if epoch != 0 {
+		prefix = fmt.Sprintf("%s%020d.", auditRecordPrefix, epoch)
+	}
+	set, err := instance.persistor.ReadStateSet(prefix)
+	if err != nil {
+		instance.log.Warning("Could not read the audit trail of epoch %d: %s", epoch, err)
+		return nil
+	}
+	var keys []string
+	for key := range set {
+		if e, n, ok := parseAuditRecordKey(key); ok && e == epoch && n >= from && n <= to {
+			keys = append(keys, key)
+		}
+	}
+	// sequence numbers are zero padded, their keys sort in their order
+	sort.Strings(keys)
+	records := make([][]byte, len(keys))
+	for i, key := range keys {
+		records[i] = set[key]
+	}
+	return records
+}
+
+// GetAuditTrail reads the audit trail of epoch on the PBFT thread, it may be
+// called from any goroutine
+func (instance *PbftCore) GetAuditTrail(epoch, from, to uint64) ([][]byte, error) {
+	if instance.audit == nil {
+		return nil, fmt.Errorf("Replica %d does not keep an audit trail", instance.ID)
+	}
+	done := make(chan [][]byte, 1)
+	instance.Inject(func() {
+		done <- instance.readAuditTrail(epoch, from, to)
+	})
+	return <-done, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestAuditTrail(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.audit", true)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.Stop()
+
+	broadcaster := uint64(generateBroadcaster(validatorCount))
+	var digests []string
+	for i := int64(1); i <= 2; i++ {
+		msg := createPbftRequestWithChainTx(i, broadcaster)
+		digests = append(digests, HashReq(net.pbftEndpoints[0].pbft.Digest, msg))
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- msg
+		if err := net.Process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		trail, err := pep.pbft.GetAuditTrail(0, 0, 100)
+		if err != nil {
+			t.Fatalf("Replica %d could not read its audit trail: %s", pep.pbft.ID, err)
+		}
+		if len(trail) != 2 {
+			t.Fatalf("Replica %d expected 2 audit records, got %d", pep.pbft.ID, len(trail))
+		}
+
+		var previous []byte
+		for i, raw := range trail {
+			ar := &AuditRecord{}
+			if err := proto.Unmarshal(raw, ar); err != nil {
+				t.Fatalf("Could not unmarshal audit record: %s", err)
+			}
+			if ar.SequenceNumber != uint64(i+1) || ar.RequestDigest != digests[i] || ar.ReplicaId != pep.pbft.ID {
+				t.Errorf("Replica %d has an unexpected audit record: %v", pep.pbft.ID, ar)
+			}
+			if len(ar.Commits) < pep.pbft.intersectionQuorum() {
+				t.Errorf("Replica %d expected the record of seqNo %d to carry a commit certificate, got %d commits", pep.pbft.ID, ar.SequenceNumber, len(ar.Commits))
+			}
+			if !bytes.Equal(ar.Previous, previous) {
+				t.Errorf("Replica %d expected the record of seqNo %d to be chained to its predecessor", pep.pbft.ID, ar.SequenceNumber)
+			}
+			if err := verifySignature(pep.sc, ar); err != nil {
+				t.Errorf("Replica %d signed an invalid audit record: %s", pep.pbft.ID, err)
+			}
+			previous = pep.pbft.Digest.hash(raw)
+		}
+
+		// A restarted replica continues the chain
+		if at := newAuditTrail(config, pep.sc); !bytes.Equal(at.previous, previous) {
+			t.Errorf("Replica %d expected a restarted audit trail to continue from the last record", pep.pbft.ID)
+		}
+	}
+}
+
+// Sequence numbers restart in every epoch, the records of an epoch must not
+// overwrite those of the previous one, and the chain runs across epochs
+func TestAuditTrailEpochRollover(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.audit", true)
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	config.Set("general.epoch.length", 4)
+	net := makePBFTNetwork(4, config)
+	defer net.Stop()
+
+	for i := int64(1); i <= 6; i++ {
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(i, 0)
+		if err := net.Process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		var previous []byte
+		for epoch, expected := range []int{4, 2, 0} {
+			trail, err := pep.pbft.GetAuditTrail(uint64(epoch), 0, 100)
+			if err != nil {
+				t.Fatalf("Replica %d could not read its audit trail of epoch %d: %s", pep.pbft.ID, epoch, err)
+			}
+			if len(trail) != expected {
+				t.Fatalf("Replica %d expected %d audit records in epoch %d, got %d", pep.pbft.ID, expected, epoch, len(trail))
+			}
+			for i, raw := range trail {
+				ar := &AuditRecord{}
+				if err := proto.Unmarshal(raw, ar); err != nil {
+					t.Fatalf("Could not unmarshal audit record: %s", err)
+				}
+				if ar.Epoch != uint64(epoch) || ar.SequenceNumber != uint64(i+1) {
+					t.Errorf("Replica %d expected the record of seqNo %d of epoch %d, got seqNo %d of epoch %d", pep.pbft.ID, i+1, epoch, ar.SequenceNumber, ar.Epoch)
+				}
+				if !bytes.Equal(ar.Previous, previous) {
+					t.Errorf("Replica %d expected the record of seqNo %d of epoch %d to be chained to its predecessor", pep.pbft.ID, ar.SequenceNumber, ar.Epoch)
+				}
+				previous = pep.pbft.Digest.hash(raw)
+			}
+		}
+
+		if trail, _ := pep.pbft.GetAuditTrail(0, 2, 3); len(trail) != 2 {
+			t.Errorf("Replica %d expected 2 audit records from seqNo 2 to 3 of epoch 0, got %d", pep.pbft.ID, len(trail))
+		}
+	}
+}
+
+func TestAuditRecordKey(t *testing.T) {
+	for _, idx := range []struct{ epoch, n uint64 }{{0, 0}, {0, 12}, {1, 0}, {3, 7}, {^uint64(0), ^uint64(0)}} {
+		epoch, n, ok := parseAuditRecordKey(auditRecordKey(idx.epoch, idx.n))
+		if !ok || epoch != idx.epoch || n != idx.n {
+			t.Errorf("Expected the key of seqNo %d of epoch %d to parse back, got seqNo %d of epoch %d (%v)", idx.n, idx.epoch, n, epoch, ok)
+		}
+	}
+	if _, _, ok := parseAuditRecordKey(auditHeadKey); ok {
+		t.Errorf("Expected the head of the audit trail not to parse as a record")
+	}
+}
+
+func TestAuditTrailDisabled(t *testing.T) {
+	net := makePBFTNetwork(4, loadConfig())
+	defer net.Stop()
+
+	if _, err := net.pbftEndpoints[0].pbft.GetAuditTrail(0, 0, 100); err == nil {
+		t.Errorf("Expected reading the audit trail to fail when it is disabled")
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/spf13/viper"
+)
+
+// ingressLimit signals backpressure once a replica holds more outstanding
+// requests than it accepts. The count is written by the event thread after
+// each event, and may be read from any goroutine. All methods may be called
+// on a nil ingressLimit, which never signals backpressure
+type ingressLimit struct {
+	limit       int64
+	outstanding int64 // accessed atomically
+}
+
+// newIngressLimit returns nil if general.maxoutstanding is 0
+func newIngressLimit(config *viper.Viper) (*ingressLimit, error) {
+	limit := config.GetInt("general.maxoutstanding")
+	if limit < 0 {
+		return nil, fmt.Errorf("Maximum outstanding requests must not be negative, got %d", limit)
+	}
+	if limit == 0 {
+		return nil, nil
+	}
+	return &ingressLimit{limit: int64(limit)}, nil
+}
+
+// Update records the number of outstanding requests
+func (il *ingressLimit) Update(outstanding int) {
+	if il == nil {
+		return
+	}
+	atomic.StoreInt64(&il.outstanding, int64(outstanding))
+}
+
+// Overloaded returns whether new requests should be rejected or delayed
+func (il *ingressLimit) Overloaded() bool {
+	if il == nil {
+		return false
+	}
+	return atomic.LoadInt64(&il.outstanding) >= il.limit
+}
+
+// updateIngress records the outstanding requests of the replica
+func (instance *PbftCore) updateIngress() {
+	instance.Ingress.Update(len(instance.OutstandingReqs))
+}
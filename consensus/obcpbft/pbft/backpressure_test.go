@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+)
+
+func TestIngressLimitDisabled(t *testing.T) {
+	il, err := newIngressLimit(loadConfig())
+	if err != nil || il != nil {
+		t.Fatalf("Expected backpressure to be disabled by default, got %v, %v", il, err)
+	}
+	il.Update(1000)
+	if il.Overloaded() {
+		t.Errorf("Expected a disabled ingress limit never to signal backpressure")
+	}
+}
+
+func TestNetworkBackpressure(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.maxoutstanding", 2)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.Stop()
+
+	// Requests delivered to a backup only stay outstanding until the primary orders them
+	p := net.pbftEndpoints[1].pbft
+	for i := int64(1); i <= 2; i++ {
+		p.Manager.Queue() <- createPbftRequestWithChainTx(i, 1)
+		p.Manager.Queue() <- WorkEvent(func() {}) // wait for the request to be processed
+		if overloaded := p.Ingress.Overloaded(); overloaded != (i == 2) {
+			t.Errorf("Expected backpressure to be %v with %d outstanding requests", i == 2, i)
+		}
+	}
+
+	p.Manager.Queue() <- WorkEvent(func() {
+		p.OutstandingReqs = make(map[string]*Request)
+	})
+	p.Manager.Queue() <- WorkEvent(func() {})
+	if p.Ingress.Overloaded() {
+		t.Errorf("Expected backpressure to be released once requests are no longer outstanding")
+	}
+}
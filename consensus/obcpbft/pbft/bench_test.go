@@ -0,0 +1,317 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+)
+
+// The benchmarks cover the work a replica does on its event thread for each
+// request, e.g.
+//
+//	go test -run none -bench . ./consensus/obcpbft/pbft
+//
+// They silence the debug logging of the core, which would otherwise dominate
+// what is measured
+
+// benchReplica is a backup outside of any network, the benchmarks hand it
+// the messages of the other replicas directly. Like the replicas of a
+// simulation, it queues its events and executions instead of processing
+// them on goroutines of its own, so that settle runs them on the goroutine
+// of the benchmark
+type benchReplica struct {
+	pbft    *PbftCore
+	manager *simManager
+	dc      *discardConsumer
+}
+
+func newBenchReplica(N int, config *viper.Viper) *benchReplica {
+	if config == nil {
+		config = loadConfig()
+	}
+	config.Set("general.N", N)
+	config.Set("general.f", (N-1)/3)
+
+	br := &benchReplica{dc: &discardConsumer{&simpleConsumer{}}}
+	br.pbft = newPbftCoreWithClock(1, config, br.dc, newVirtualClock(time.Unix(0, 0)))
+	br.manager = &simManager{receiver: br.pbft, events: make(chan interface{}, 1000)}
+	br.pbft.Manager = br.manager
+	br.pbft.execQueue.halt()
+	br.pbft.execQueue = &execQueue{threaded: threaded{make(chan struct{})}, consumer: br.dc, jobs: make(chan execJob, 1)}
+	return br
+}
+
+// deliver processes msg from sender, along with the events and executions
+// it leads to
+func (br *benchReplica) deliver(msg *Message, sender uint64) {
+	sendEvent(br.pbft, pbftMessageEvent{msg: msg, sender: sender})
+	br.settle()
+}
+
+func (br *benchReplica) settle() {
+	for {
+		select {
+		case event := <-br.manager.events:
+			sendEvent(br.pbft, event)
+		case job := <-br.pbft.execQueue.jobs:
+			br.dc.Execute(job.seqNo, job.txRaw, job.done)
+		default:
+			return
+		}
+	}
+}
+
+// benchPhases are the phases of the normal case, in the order a request
+// passes through them
+var benchPhases = []string{"preprepare", "prepare", "commit"}
+
+// benchNormalCase orders b.N requests on a backup of a network of N
+// replicas, and measures the phase named timed, all of them if empty.
+// The other replicas checkpoint along with the backup, so that its logs
+// are garbage collected as they would be in a network
+func benchNormalCase(b *testing.B, N int, timed string) {
+	logging.SetLevel(logging.WARNING, "consensus/obcpbft")
+	defer logging.SetLevel(logging.DEBUG, "consensus/obcpbft")
+	br := newBenchReplica(N, nil)
+	defer br.pbft.Close()
+	instance := br.pbft
+	others := make([]uint64, 0, N-1)
+	for id := 0; id < N; id++ {
+		if uint64(id) != instance.ID {
+			others = append(others, uint64(id))
+		}
+	}
+	quorum := others[:instance.intersectionQuorum()]
+
+	reqs := make([]*Request, b.N)
+	for i := range reqs {
+		reqs[i] = createPbftRequestWithChainTx(int64(i+1), 0)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i, req := range reqs {
+		n := uint64(i + 1)
+		digest := HashReq(instance.Digest, req)
+		for _, phase := range benchPhases {
+			measured := timed == "" || timed == phase
+			if !measured {
+				b.StopTimer()
+			}
+			switch phase {
+			case "preprepare":
+				br.deliver(&Message{&Message_PrePrepare{&PrePrepare{
+					View:            0,
+					SequenceNumber:  n,
+					RequestDigest:   digest,
+					Request:         req,
+					ReplicaId:       0,
+					DigestAlgorithm: instance.Digest.name(),
+				}}}, 0)
+			case "prepare":
+				for _, id := range quorum {
+					if id == 0 {
+						continue // the primary does not prepare
+					}
+					br.deliver(&Message{&Message_Prepare{&Prepare{View: 0, SequenceNumber: n, RequestDigest: digest, ReplicaId: id}}}, id)
+				}
+			case "commit":
+				for _, id := range quorum {
+					br.deliver(&Message{&Message_Commit{&Commit{View: 0, SequenceNumber: n, RequestDigest: digest, ReplicaId: id}}}, id)
+				}
+			}
+			if !measured {
+				b.StartTimer()
+			}
+		}
+
+		b.StopTimer()
+		if id, ok := instance.chkpts[n]; ok {
+			for _, sender := range quorum {
+				br.deliver(&Message{&Message_Checkpoint{&Checkpoint{
+					SequenceNumber:  n,
+					ReplicaId:       sender,
+					Id:              id,
+					DigestAlgorithm: instance.Digest.name(),
+				}}}, sender)
+			}
+		}
+		b.StartTimer()
+	}
+	b.StopTimer()
+
+	if instance.LastExec != uint64(b.N) {
+		b.Fatalf("Expected the backup to execute %d requests, it executed %d", b.N, instance.LastExec)
+	}
+}
+
+func BenchmarkNormalCase(b *testing.B) {
+	for _, N := range []int{4, 7, 13} {
+		N := N
+		b.Run(fmt.Sprintf("N=%d", N), func(b *testing.B) {
+			benchNormalCase(b, N, "")
+		})
+	}
+}
+
+func BenchmarkPhases(b *testing.B) {
+	for _, phase := range benchPhases {
+		phase := phase
+		b.Run(phase, func(b *testing.B) {
+			benchNormalCase(b, 4, phase)
+		})
+	}
+}
+
+func BenchmarkHashReq(b *testing.B) {
+	logging.SetLevel(logging.WARNING, "consensus/obcpbft")
+	defer logging.SetLevel(logging.DEBUG, "consensus/obcpbft")
+	instance := newPbftCore(0, loadConfig(), &discardConsumer{&simpleConsumer{}})
+	defer instance.Close()
+
+	for _, size := range []int{256, 4096, 65536} {
+		req := createPbftRequestWithChainTx(1, 0)
+		req.Payload = make([]byte, size)
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				HashReq(instance.Digest, req)
+			}
+		})
+	}
+}
+
+// BenchmarkRecvMsg measures the decoding and classification of the messages
+// a replica receives, before they are handed to the handler of their type
+func BenchmarkRecvMsg(b *testing.B) {
+	logging.SetLevel(logging.WARNING, "consensus/obcpbft")
+	defer logging.SetLevel(logging.DEBUG, "consensus/obcpbft")
+	instance := newPbftCore(1, loadConfig(), &discardConsumer{&simpleConsumer{}})
+	defer instance.Close()
+
+	req := createPbftRequestWithChainTx(1, 0)
+	digest := HashReq(instance.Digest, req)
+	for _, tc := range []struct {
+		name   string
+		sender uint64
+		msg    *Message
+	}{
+		{"preprepare", 0, &Message{&Message_PrePrepare{&PrePrepare{SequenceNumber: 1, RequestDigest: digest, Request: req, ReplicaId: 0}}}},
+		{"prepare", 2, &Message{&Message_Prepare{&Prepare{SequenceNumber: 1, RequestDigest: digest, ReplicaId: 2}}}},
+		{"commit", 2, &Message{&Message_Commit{&Commit{SequenceNumber: 1, RequestDigest: digest, ReplicaId: 2}}}},
+	} {
+		raw, err := proto.Marshal(tc.msg)
+		if err != nil {
+			b.Fatalf("Could not marshal %s: %s", tc.name, err)
+		}
+		sender := tc.sender
+		b.Run(tc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(raw)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				msg := &Message{}
+				if err := proto.Unmarshal(raw, msg); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := instance.recvMsg(msg, sender); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPersistMessages measures the write of the messages of the normal
+// case to the write-ahead log
+func BenchmarkPersistMessages(b *testing.B) {
+	logging.SetLevel(logging.WARNING, "consensus/obcpbft")
+	defer logging.SetLevel(logging.DEBUG, "consensus/obcpbft")
+	instance := newPbftCore(1, loadConfig(), &discardConsumer{&simpleConsumer{}})
+	defer instance.Close()
+
+	req := createPbftRequestWithChainTx(1, 0)
+	digest := HashReq(instance.Digest, req)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := uint64(i + 1)
+		instance.persistPrePrepare(&PrePrepare{SequenceNumber: n, RequestDigest: digest, Request: req, ReplicaId: 0})
+		instance.persistPrepare(&Prepare{SequenceNumber: n, RequestDigest: digest, ReplicaId: 1})
+		instance.persistCommit(&Commit{SequenceNumber: n, RequestDigest: digest, ReplicaId: 1})
+	}
+}
+
+// benchViewChanges creates the view changes of N replicas which prepared and
+// committed every sequence number of a log of L entries above checkpoint 0
+func benchViewChanges(N int, L uint64) []*ViewChange {
+	vset := make([]*ViewChange, N)
+	for id := range vset {
+		vc := &ViewChange{
+			View:      1,
+			H:         0,
+			ReplicaId: uint64(id),
+			Cset:      []*ViewChange_C{{SequenceNumber: 0, Id: "state"}},
+		}
+		for n := uint64(1); n <= L; n++ {
+			digest := fmt.Sprintf("request %d", n)
+			vc.Pset = append(vc.Pset, &ViewChange_PQ{SequenceNumber: n, Digest: digest, View: 0})
+			vc.Qset = append(vc.Qset, &ViewChange_PQ{SequenceNumber: n, Digest: digest, View: 0})
+		}
+		vset[id] = vc
+	}
+	return vset
+}
+
+// BenchmarkViewChangeAssembly measures how the new primary selects the
+// checkpoint and assigns the sequence numbers of a new view from the view
+// changes of the others, which is quadratic in N and linear in L
+func BenchmarkViewChangeAssembly(b *testing.B) {
+	logging.SetLevel(logging.WARNING, "consensus/obcpbft")
+	defer logging.SetLevel(logging.DEBUG, "consensus/obcpbft")
+	for _, N := range []int{4, 7, 13} {
+		for _, L := range []uint64{20, 80, 320} {
+			config := loadConfig()
+			config.Set("general.N", N)
+			config.Set("general.f", (N-1)/3)
+			config.Set("general.K", int(L/2))
+			config.Set("general.logmultiplier", 2)
+			instance := newPbftCore(0, config, &discardConsumer{&simpleConsumer{}})
+			vset := benchViewChanges(N, L)
+
+			b.Run(fmt.Sprintf("N=%d/L=%d", N, L), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					cp, ok, _ := instance.selectInitialCheckpoint(vset)
+					if !ok {
+						b.Fatal("No checkpoint selected")
+					}
+					if msgList := instance.assignSequenceNumbers(vset, cp.SequenceNumber); msgList == nil {
+						b.Fatal("No sequence numbers assigned")
+					}
+				}
+			})
+			instance.Close()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// isBigRequest returns whether the primary pre-prepares req by its digest
+// only, leaving it to the backups to fetch the request on a miss
+func (instance *PbftCore) isBigRequest(req *Request) bool {
+	return instance.bigRequestSize > 0 && req != nil && proto.Size(req) >= instance.bigRequestSize
+}
+
+// fetchBigRequest asks the primary which sent a digest-only pre-prepare for
+// the request. Should the primary not answer, the request timer expires and
+// the view change fetches the request from all replicas
+func (instance *PbftCore) fetchBigRequest(digest string, primary uint64) error {
+	if instance.bigReqs[digest] {
+		return nil
+	}
+	instance.log.Debug("Fetching request %s from primary %d", digest, primary)
+	instance.bigReqs[digest] = true
+
+	msg := &Message{&Message_FetchRequest{&FetchRequest{
+		RequestDigest: digest,
+		ReplicaId:     instance.ID,
+	}}}
+	msgRaw, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal fetch-request message: %s", err)
+	}
+	return instance.consumer.Unicast(msgRaw, primary)
+}
+
+// recvBigRequest resumes the agreement on the pre-prepares of a request which
+// arrived after them
+func (instance *PbftCore) recvBigRequest(digest string, req *Request) error {
+	if err := instance.consumer.ValidateRequest(req.Payload); err != nil {
+		instance.log.Warning("Request %s did not verify: %s", digest, err)
+		return err
+	}
+	instance.OutstandingReqs[digest] = req
+	instance.AdaptiveTimeout.requestArrived(digest)
+
+	for idx, cert := range instance.certStore {
+		if idx.v != instance.View || cert.digest != digest || cert.prePrepare == nil {
+			continue
+		}
+		if instance.committed(digest, idx.v, idx.n) {
+			// the other replicas agreed while we were waiting for the request
+			instance.recvCommitCert(digest, idx.n)
+			continue
+		}
+		if err := instance.maybeSendPrepare(cert.prePrepare); err != nil {
+			return err
+		}
+		if err := instance.maybeSendCommit(digest, idx.v, idx.n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestBigRequestDigestOnly(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.bigrequestsize", 1)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.Stop()
+
+	var fullPrePrepares, returned int32
+	net.FilterFn = func(src int, dst int, msg []byte) []byte {
+		pm := &Message{}
+		if err := proto.Unmarshal(msg, pm); err != nil {
+			t.Fatal(err)
+		}
+		if pp := pm.GetPrePrepare(); pp != nil && pp.Request != nil {
+			atomic.AddInt32(&fullPrePrepares, 1)
+		}
+		if pm.GetReturnRequest() != nil && src == 0 {
+			atomic.AddInt32(&returned, 1)
+		}
+		return msg
+	}
+
+	msg := createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- msg
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	if fullPrePrepares != 0 {
+		t.Errorf("Expected the primary to send the pre-prepare by digest only, it sent %d with the request", fullPrePrepares)
+	}
+	if returned != int32(validatorCount-1) {
+		t.Errorf("Expected the primary to return the request to %d backups, returned it %d times", validatorCount-1, returned)
+	}
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 1 {
+			t.Errorf("Instance %d executed %d requests, expected 1", pep.ID, pep.sc.executions)
+		}
+	}
+}
+
+func TestBigRequestBelowThreshold(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.bigrequestsize", 1<<20)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.Stop()
+
+	net.FilterFn = func(src int, dst int, msg []byte) []byte {
+		pm := &Message{}
+		if err := proto.Unmarshal(msg, pm); err != nil {
+			t.Fatal(err)
+		}
+		if pp := pm.GetPrePrepare(); pp != nil && pp.Request == nil {
+			t.Errorf("Expected a small request to be sent with the pre-prepare")
+		}
+		if pm.GetFetchRequest() != nil {
+			t.Errorf("Expected no replica to fetch a small request")
+		}
+		return msg
+	}
+
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 1 {
+			t.Errorf("Instance %d executed %d requests, expected 1", pep.ID, pep.sc.executions)
+		}
+	}
+}
+
+func TestBigRequestArrivingAfterCommits(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.bigrequestsize", 1)
+	config.Set("general.timeout.request", "10s")
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.Stop()
+
+	net.FilterFn = func(src int, dst int, msg []byte) []byte {
+		pm := &Message{}
+		if err := proto.Unmarshal(msg, pm); err != nil {
+			t.Fatal(err)
+		}
+		if pm.GetReturnRequest() != nil && dst == 3 {
+			return nil
+		}
+		return msg
+	}
+	go net.ProcessContinually()
+
+	p := net.pbftEndpoints[3].pbft
+	poll := func(cond func() bool) bool {
+		for i := 0; i < 50; i++ {
+			done := make(chan bool)
+			p.Manager.Queue() <- WorkEvent(func() { done <- cond() })
+			if <-done {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+
+	msg := createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
+	digest := HashReq(p.Digest, msg)
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- msg
+	if !poll(func() bool {
+		cert := p.certStore[msgID{0, 1}]
+		return cert != nil && len(cert.commit) == 3
+	}) {
+		t.Fatalf("Expected replica 3 to receive the commits of the other replicas")
+	}
+	if !p.bigReqs[digest] || net.pbftEndpoints[3].sc.executions != 0 {
+		t.Fatalf("Expected replica 3 to wait for the request")
+	}
+
+	p.Manager.Queue() <- returnRequestEvent(msg)
+	if !poll(func() bool { return p.LastExec == 1 }) {
+		t.Fatalf("Expected replica 3 to execute the request once it arrived")
+	}
+	if _, ok := p.OutstandingReqs[digest]; ok {
+		t.Errorf("Expected the request not to be outstanding at replica 3")
+	}
+}
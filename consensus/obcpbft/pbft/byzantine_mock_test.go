@@ -0,0 +1,155 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"github.com/hyperledger/fabric/consensus/testkit"
+	pb "github.com/hyperledger/fabric/protos"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// adversary makes a replica of a pbftNetwork byzantine. Every message the
+// replica sends, broadcasts included, is handed to rewrite once for each
+// receiver, which returns the messages to send in its place, none to
+// suppress it. Rewrite is called on the event thread of the replica, so it
+// may inspect and use its pbftCore. The replica itself runs the protocol
+// correctly, so it keeps its own view of what it should have sent
+type adversary interface {
+	rewrite(pe *pbftEndpoint, msg *Message, dst uint64) []*Message
+}
+
+// withAdversary is passed to makePBFTNetwork to make replica id byzantine
+func withAdversary(id uint64, adv adversary) func(*pbftEndpoint) {
+	return func(pe *pbftEndpoint) {
+		if pe.ID == id {
+			pe.sc.adversary = adv
+		}
+	}
+}
+
+// sendAdversarial passes msgPayload for each receiver through the adversary
+// of the replica, and unicasts what it returns
+func (sc *simpleConsumer) sendAdversarial(msgPayload []byte, receivers []uint64) {
+	msg := &Message{}
+	if err := proto.Unmarshal(msgPayload, msg); err != nil {
+		return
+	}
+	for _, dst := range receivers {
+		handle := testkit.ValidatorHandle(dst)
+		for _, out := range sc.adversary.rewrite(sc.pe, proto.Clone(msg).(*Message), dst) {
+			raw, err := proto.Marshal(out)
+			if err != nil {
+				continue
+			}
+			sc.pe.Unicast(&pb.Message{Payload: raw}, handle)
+		}
+	}
+}
+
+// resign signs s again after an adversary altered it, so that it is not
+// trivially rejected
+func resign(pe *pbftEndpoint, s Signable) {
+	pe.pbft.Sign(s)
+}
+
+// equivocatingPrimary sends a pre-prepare for a different request to the
+// replicas in victims than to the others, so that no request can gather a
+// quorum of prepares
+type equivocatingPrimary struct {
+	victims map[uint64]bool
+}
+
+func newEquivocatingPrimary(victims ...uint64) adversary {
+	ep := &equivocatingPrimary{victims: make(map[uint64]bool)}
+	for _, id := range victims {
+		ep.victims[id] = true
+	}
+	return ep
+}
+
+func (ep *equivocatingPrimary) rewrite(pe *pbftEndpoint, msg *Message, dst uint64) []*Message {
+	pp := msg.GetPrePrepare()
+	if pp == nil || pp.Request == nil || !ep.victims[dst] {
+		return []*Message{msg}
+	}
+	forged := proto.Clone(pp.Request).(*Request)
+	forged.Payload = append([]byte("forged "), forged.Payload...)
+	pp.Request = forged
+	pp.RequestDigest = HashReq(pe.pbft.Digest, forged)
+	return []*Message{msg}
+}
+
+// censoringPrimary never pre-prepares the requests matched by censor, it
+// behaves correctly otherwise
+type censoringPrimary struct {
+	censor func(req *Request) bool
+}
+
+func newCensoringPrimary(censor func(req *Request) bool) adversary {
+	return &censoringPrimary{censor: censor}
+}
+
+func (cp *censoringPrimary) rewrite(pe *pbftEndpoint, msg *Message, dst uint64) []*Message {
+	if pp := msg.GetPrePrepare(); pp != nil {
+		if req := pp.Request; req != nil && cp.censor(req) {
+			return nil
+		}
+		if req := pe.pbft.reqStore[pp.RequestDigest]; req != nil && cp.censor(req) {
+			return nil
+		}
+	}
+	return []*Message{msg}
+}
+
+// silentReplica never sends anything, unlike a crashed replica it keeps
+// receiving and processing messages
+type silentReplica struct{}
+
+func (silentReplica) rewrite(pe *pbftEndpoint, msg *Message, dst uint64) []*Message {
+	return nil
+}
+
+// checkpointLiar reports a state other than its own in its checkpoints
+type checkpointLiar struct{}
+
+func (checkpointLiar) rewrite(pe *pbftEndpoint, msg *Message, dst uint64) []*Message {
+	if chkpt := msg.GetCheckpoint(); chkpt != nil {
+		chkpt.Id = "forged state " + chkpt.Id
+	}
+	return []*Message{msg}
+}
+
+// viewChangeSpammer sends a view change for a view further ahead along with
+// every message, trying to push the others into view changes on its own
+type viewChangeSpammer struct {
+	next uint64 // offset of the next view the spammer asks for
+}
+
+func (vs *viewChangeSpammer) rewrite(pe *pbftEndpoint, msg *Message, dst uint64) []*Message {
+	vs.next++
+	vc := &ViewChange{
+		View:      pe.pbft.View + vs.next,
+		H:         pe.pbft.h,
+		ReplicaId: pe.pbft.ID,
+	}
+	for n, id := range pe.pbft.chkpts {
+		vc.Cset = append(vc.Cset, &ViewChange_C{SequenceNumber: n, Id: id})
+	}
+	resign(pe, vc)
+	return []*Message{msg, {&Message_ViewChange{vc}}}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func byzantineConfig() *viper.Viper {
+	config := loadConfig()
+	config.Set("general.timeout.request", "400ms")
+	config.Set("general.timeout.viewchange", "800ms")
+	return config
+}
+
+// submitToAll hands req to every replica, as a client broadcasting it would
+func submitToAll(net *pbftNetwork, req *Request) {
+	for _, pe := range net.pbftEndpoints {
+		pe.pbft.Manager.Queue() <- req
+	}
+}
+
+// checkCorrectReplicasAgree checks that all replicas but the byzantine one
+// executed the same requests, at least executions of them
+func checkCorrectReplicasAgree(t *testing.T, net *pbftNetwork, byzantine int, executions uint64) {
+	var reference *simpleConsumer
+	for i, pe := range net.pbftEndpoints {
+		if i == byzantine {
+			continue
+		}
+		if pe.sc.executions < executions {
+			t.Errorf("Expected replica %d to execute at least %d requests, got %d", i, executions, pe.sc.executions)
+		}
+		if reference == nil {
+			reference = pe.sc
+			continue
+		}
+		if pe.sc.executions != reference.executions || !reflect.DeepEqual(pe.sc.lastExecution, reference.lastExecution) {
+			t.Errorf("Replica %d executed %d requests ending with %x, replica %d %d ending with %x",
+				i, pe.sc.executions, pe.sc.lastExecution, reference.pe.ID, reference.executions, reference.lastExecution)
+		}
+	}
+}
+
+func TestEquivocatingPrimary(t *testing.T) {
+	net := makePBFTNetwork(4, byzantineConfig(), withAdversary(0, newEquivocatingPrimary(1, 2)))
+	defer net.Stop()
+
+	submitToAll(net, createPbftRequestWithChainTx(1, 0))
+	net.Process()
+
+	for i, pe := range net.pbftEndpoints[1:] {
+		if pe.pbft.View == 0 {
+			t.Errorf("Expected replica %d to leave the view of the equivocating primary", i+1)
+		}
+	}
+	checkCorrectReplicasAgree(t, net, 0, 1)
+}
+
+func TestCensoringPrimary(t *testing.T) {
+	net := makePBFTNetwork(4, byzantineConfig(), withAdversary(0, newCensoringPrimary(func(req *Request) bool {
+		return true
+	})))
+	defer net.Stop()
+
+	submitToAll(net, createPbftRequestWithChainTx(1, 0))
+	net.Process()
+
+	for i, pe := range net.pbftEndpoints[1:] {
+		if pe.pbft.View == 0 {
+			t.Errorf("Expected replica %d to leave the view of the censoring primary", i+1)
+		}
+	}
+	checkCorrectReplicasAgree(t, net, 0, 1)
+}
+
+func TestSilentReplica(t *testing.T) {
+	net := makePBFTNetwork(4, byzantineConfig(), withAdversary(3, silentReplica{}))
+	defer net.Stop()
+
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(1, 0)
+	net.Process()
+
+	checkCorrectReplicasAgree(t, net, 3, 1)
+	for i, pe := range net.pbftEndpoints {
+		if pe.pbft.View != 0 {
+			t.Errorf("Expected replica %d to stay in view 0, got %d", i, pe.pbft.View)
+		}
+	}
+}
+
+func TestCheckpointLiar(t *testing.T) {
+	config := byzantineConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	net := makePBFTNetwork(4, config, withAdversary(3, checkpointLiar{}))
+	defer net.Stop()
+
+	for i := int64(1); i <= 4; i++ {
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(i, 0)
+		net.Process()
+	}
+
+	checkCorrectReplicasAgree(t, net, 3, 4)
+	for i, pe := range net.pbftEndpoints[:3] {
+		if pe.pbft.h != 4 {
+			t.Errorf("Expected replica %d to reach a stable checkpoint at 4 without the liar, low watermark is %d", i, pe.pbft.h)
+		}
+	}
+}
+
+func TestViewChangeSpammer(t *testing.T) {
+	net := makePBFTNetwork(4, byzantineConfig(), withAdversary(3, &viewChangeSpammer{}))
+	defer net.Stop()
+
+	for i := int64(1); i <= 3; i++ {
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(i, 0)
+		net.Process()
+	}
+
+	checkCorrectReplicasAgree(t, net, 3, 3)
+	for i, pe := range net.pbftEndpoints[:3] {
+		if pe.pbft.View != 0 {
+			t.Errorf("Expected replica %d to ignore the view changes of a single replica, moved to view %d", i, pe.pbft.View)
+		}
+	}
+}
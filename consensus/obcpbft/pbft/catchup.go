@@ -0,0 +1,276 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// catchUp tracks the commit certificates we asked the other replicas for
+type catchUp struct {
+	low     uint64                             // first sequence number we asked for
+	high    uint64                             // last sequence number we asked for
+	replies map[uint64]map[uint64]*CatchUpCert // certificates received, by sequence number and sending replica
+}
+
+// catchUp asks the other replicas for the commit certificates of the
+// sequence numbers after lastExec up to target, so that a replica which
+// missed only a few sequence numbers executes them itself, rather than
+// transferring state. It returns false if the gap is too large for
+// catching up, or if the previous catch up made no progress
+func (instance *PbftCore) catchUp(target uint64) bool {
+	if instance.CatchupGap == 0 || instance.SkipInProgress || target <= instance.LastExec {
+		return false
+	}
+	if target-instance.LastExec > instance.CatchupGap {
+		instance.stLog.Debug("%d sequence numbers behind, too many to catch up", target-instance.LastExec)
+		return false
+	}
+	if c := instance.catchup; c != nil && instance.LastExec < c.high {
+		if target <= c.high {
+			return true // already asked
+		}
+		if instance.LastExec < c.low {
+			instance.stLog.Warning("Did not catch up through seqNo %d, giving up", c.high)
+			instance.catchup = nil
+			return false
+		}
+	}
+
+	low := instance.LastExec + 1
+	instance.stLog.Info("Catching up from seqNo %d to %d with commit certificates", low, target)
+	instance.catchup = &catchUp{
+		low:     low,
+		high:    target,
+		replies: make(map[uint64]map[uint64]*CatchUpCert),
+	}
+	instance.Metrics.catchUps.Inc()
+	instance.reliableBroadcast(&Message{&Message_CatchUpRequest{&CatchUpRequest{
+		ReplicaId: instance.ID,
+		Low:       low,
+		High:      target,
+	}}})
+	return true
+}
+
+// maybeCatchUp catches up to the checkpoint at seqNo n, which f+1
+// replicas reported, if we cannot execute the sequence number after our
+// last execution, because we missed some of its messages
+func (instance *PbftCore) maybeCatchUp(n uint64) {
+	if n <= instance.LastExec || instance.CurrentExec != nil {
+		return
+	}
+	for idx, cert := range instance.certStore {
+		if idx.n == instance.LastExec+1 && instance.committed(cert.digest, idx.v, idx.n) {
+			return // it is merely executing slower than the others
+		}
+	}
+	instance.catchUp(n)
+}
+
+// recvCatchUpRequest sends the commit certificates we hold for the
+// requested sequence numbers to the catching up replica
+func (instance *PbftCore) recvCatchUpRequest(cr *CatchUpRequest) error {
+	instance.stLog.Debug("Received catch up request from replica %d for seqNo %d to %d",
+		cr.ReplicaId, cr.Low, cr.High)
+
+	if instance.CatchupGap == 0 || cr.High < cr.Low || cr.High-cr.Low >= instance.CatchupGap {
+		return nil
+	}
+
+	for n := cr.Low; n <= cr.High; n++ {
+		cc := instance.commitCert(n)
+		if cc == nil {
+			continue
+		}
+		msgRaw, err := proto.Marshal(&Message{&Message_CatchUpCert{cc}})
+		if err != nil {
+			return fmt.Errorf("Cannot marshal commit certificate for seqNo %d: %s", n, err)
+		}
+		if err := instance.reliableUnicast(msgRaw, cr.ReplicaId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitCert returns the commit certificate we hold for seqNo n, or nil
+func (instance *PbftCore) commitCert(n uint64) *CatchUpCert {
+	if cc, ok := instance.catchupLog[n]; ok {
+		return cc
+	}
+	for idx, cert := range instance.certStore {
+		if idx.n != n || !instance.committed(cert.digest, idx.v, idx.n) {
+			continue
+		}
+		return &CatchUpCert{
+			ReplicaId:  instance.ID,
+			PrePrepare: cert.prePrepare,
+			Prepare:    cert.prepare,
+			Commit:     cert.commit,
+			Request:    instance.reqStore[cert.digest],
+		}
+	}
+	return nil
+}
+
+// retainCommitCerts keeps the commit certificates of the sequence numbers
+// which are about to fall below the low watermark h, so that we can still
+// serve them to catching up replicas, and forgets the ones which fell too
+// far behind
+func (instance *PbftCore) retainCommitCerts(h uint64) {
+	if instance.CatchupGap == 0 {
+		return
+	}
+	for idx := range instance.certStore {
+		if idx.n <= h && idx.n+instance.CatchupGap > h {
+			if cc := instance.commitCert(idx.n); cc != nil {
+				instance.catchupLog[idx.n] = cc
+			}
+		}
+	}
+	for n := range instance.catchupLog {
+		if n+instance.CatchupGap <= h {
+			delete(instance.catchupLog, n)
+		}
+	}
+}
+
+// recvCatchUpCert records a commit certificate sent in reply to our catch
+// up request. The sending replica is authenticated, but not the messages it
+// forwards, so a certificate is only installed once f+1 replicas sent
+// matching ones
+func (instance *PbftCore) recvCatchUpCert(cc *CatchUpCert) error {
+	c := instance.catchup
+	if c == nil {
+		return nil
+	}
+	pp := cc.PrePrepare
+	if err := instance.checkCatchUpCert(cc); err != nil {
+		return fmt.Errorf("Replica %d rejecting commit certificate from replica %d: %s", instance.ID, cc.ReplicaId, err)
+	}
+	n := pp.SequenceNumber
+	if n < c.low || n > c.high || n <= instance.LastExec {
+		return nil
+	}
+	instance.stLog.Debug("Received commit certificate for view=%d/seqNo=%d from replica %d",
+		pp.View, n, cc.ReplicaId)
+
+	if c.replies[n] == nil {
+		c.replies[n] = make(map[uint64]*CatchUpCert)
+	}
+	c.replies[n][cc.ReplicaId] = cc
+
+	matching := 0
+	for _, reply := range c.replies[n] {
+		if reply.PrePrepare.RequestDigest == pp.RequestDigest {
+			matching++
+		}
+	}
+	if matching < instance.F+1 {
+		return nil
+	}
+
+	instance.installCatchUpCert(cc)
+	delete(c.replies, n)
+	instance.executeOutstanding()
+	return nil
+}
+
+// caughtUp completes the catch up once we executed through the last sequence number we asked for
+func (instance *PbftCore) caughtUp() {
+	if c := instance.catchup; c != nil && instance.LastExec >= c.high {
+		instance.stLog.Info("Caught up through seqNo %d", c.high)
+		instance.catchup = nil
+	}
+}
+
+// checkCatchUpCert verifies that cc holds a pre-prepare of the primary, the
+// request it refers to, and matching prepares and commits of a quorum of
+// distinct replicas
+func (instance *PbftCore) checkCatchUpCert(cc *CatchUpCert) error {
+	pp := cc.PrePrepare
+	if pp == nil {
+		return fmt.Errorf("certificate holds no pre-prepare")
+	}
+	if instance.Primary(pp.View) != pp.ReplicaId {
+		return fmt.Errorf("pre-prepare for view=%d/seqNo=%d is not from the primary", pp.View, pp.SequenceNumber)
+	}
+	if err := checkDigestAlgorithm(instance.Digest, pp.DigestAlgorithm); err != nil {
+		return err
+	}
+	if pp.RequestDigest != "" && (cc.Request == nil || HashReq(instance.Digest, cc.Request) != pp.RequestDigest) {
+		return fmt.Errorf("request does not match the digest of the pre-prepare for view=%d/seqNo=%d", pp.View, pp.SequenceNumber)
+	}
+
+	prepared := make(map[uint64]bool)
+	for _, p := range cc.Prepare {
+		if p.View == pp.View && p.SequenceNumber == pp.SequenceNumber && p.RequestDigest == pp.RequestDigest && p.ReplicaId != pp.ReplicaId {
+			prepared[p.ReplicaId] = true
+		}
+	}
+	if len(prepared) < instance.intersectionQuorum()-1 {
+		return fmt.Errorf("certificate for view=%d/seqNo=%d holds %d matching prepares", pp.View, pp.SequenceNumber, len(prepared))
+	}
+
+	committed := make(map[uint64]bool)
+	for _, c := range cc.Commit {
+		if c.View == pp.View && c.SequenceNumber == pp.SequenceNumber && c.RequestDigest == pp.RequestDigest {
+			committed[c.ReplicaId] = true
+		}
+	}
+	if len(committed) < instance.intersectionQuorum() {
+		return fmt.Errorf("certificate for view=%d/seqNo=%d holds %d matching commits", pp.View, pp.SequenceNumber, len(committed))
+	}
+	return nil
+}
+
+// installCatchUpCert places the commit certificate cc in our message log, as if we had taken part in the agreement
+func (instance *PbftCore) installCatchUpCert(cc *CatchUpCert) {
+	pp := cc.PrePrepare
+	instance.stLog.Info("Installing commit certificate for view=%d/seqNo=%d and digest %s",
+		pp.View, pp.SequenceNumber, pp.RequestDigest)
+
+	for idx := range instance.certStore {
+		if idx.n == pp.SequenceNumber {
+			delete(instance.certStore, idx)
+		}
+	}
+	instance.certStore[msgID{pp.View, pp.SequenceNumber}] = &msgCert{
+		digest:      pp.RequestDigest,
+		prePrepare:  pp,
+		sentPrepare: true,
+		prepare:     cc.Prepare,
+		sentCommit:  true,
+		commit:      cc.Commit,
+	}
+	var msgs []*Message
+	if pp.RequestDigest != "" {
+		instance.reqStore[pp.RequestDigest] = cc.Request
+		msgs = append(msgs, &Message{&Message_Request{cc.Request}})
+	}
+	msgs = append(msgs, &Message{&Message_PrePrepare{pp}})
+	for _, p := range cc.Prepare {
+		msgs = append(msgs, &Message{&Message_Prepare{p}})
+	}
+	for _, c := range cc.Commit {
+		msgs = append(msgs, &Message{&Message_Commit{c}})
+	}
+	instance.persistMessages(msgs...)
+}
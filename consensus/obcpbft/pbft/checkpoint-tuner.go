@@ -0,0 +1,108 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// checkpointTuner adjusts the interval at which the replica takes
+// checkpoints. The interval is K times a power of two, up to a maximum, so
+// that replicas which tuned their intervals independently still checkpoint
+// together at every multiple of the maximum interval. The interval doubles
+// while taking checkpoints costs more than the target share of the time
+// between them, and halves while the log is full, that is while ordering
+// waits for checkpoints to become stable. All methods may be called on a nil
+// checkpointTuner, which keeps the interval at K
+type checkpointTuner struct {
+	min      uint64  // smallest interval, K
+	max      uint64  // largest interval, K times a power of two
+	interval uint64  // current interval
+	overhead float64 // target share of the time spent taking checkpoints
+
+	last    time.Time // when the previous checkpoint was taken
+	stalled bool      // the log was full since the previous checkpoint
+}
+
+// newCheckpointTuner returns nil if general.autocheckpoint.enabled is not
+// set. limit is the least distance above the low watermark up to which the
+// primary may assign sequence numbers, it must reach the largest interval
+func newCheckpointTuner(config *viper.Viper, K uint64, limit uint64) (*checkpointTuner, error) {
+	if !config.GetBool("general.autocheckpoint.enabled") {
+		return nil, nil
+	}
+	max := uint64(config.GetInt("general.autocheckpoint.maxk"))
+	if max < K || max%K != 0 || (max/K)&(max/K-1) != 0 {
+		return nil, fmt.Errorf("Maximum checkpoint interval must be K (%d) times a power of two, got %d", K, max)
+	}
+	if max > limit {
+		return nil, fmt.Errorf("Maximum checkpoint interval %d exceeds the %d sequence numbers the primary may assign above the low watermark", max, limit)
+	}
+	overhead := config.GetFloat64("general.autocheckpoint.overhead")
+	if overhead <= 0 || overhead >= 1 {
+		return nil, fmt.Errorf("Checkpoint overhead must be between 0 and 1, got %v", overhead)
+	}
+	return &checkpointTuner{
+		min:      K,
+		max:      max,
+		interval: K,
+		overhead: overhead,
+	}, nil
+}
+
+// period returns the current checkpoint interval
+func (t *checkpointTuner) period(K uint64) uint64 {
+	if t == nil {
+		return K
+	}
+	return t.interval
+}
+
+// stall records that the log was full
+func (t *checkpointTuner) stall() {
+	if t == nil {
+		return
+	}
+	t.stalled = true
+}
+
+// checkpointed is called once the replica took a checkpoint at now, which
+// took cost to compute
+func (t *checkpointTuner) checkpointed(now time.Time, cost time.Duration) {
+	if t == nil {
+		return
+	}
+	last := t.last
+	t.last = now
+	stalled := t.stalled
+	t.stalled = false
+	if last.IsZero() || !now.After(last) {
+		return
+	}
+
+	share := float64(cost) / float64(now.Sub(last))
+	if share > t.overhead && t.interval < t.max {
+		t.interval *= 2
+		logger.Info("PBFT doubling checkpoint interval to %d, checkpoints took %.1f%% of the time", t.interval, share*100)
+	} else if stalled && share*2 < t.overhead && t.interval > t.min {
+		t.interval /= 2
+		logger.Info("PBFT halving checkpoint interval to %d, the log was full", t.interval)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckpointTunerConfig(t *testing.T) {
+	config := loadConfig()
+	if tuner, err := newCheckpointTuner(config, 10, 20); err != nil || tuner != nil {
+		t.Fatalf("Expected the checkpoint interval to be fixed by default, got %v, %v", tuner, err)
+	}
+	if period := (*checkpointTuner)(nil).period(10); period != 10 {
+		t.Errorf("Expected a fixed checkpoint interval of K, got %d", period)
+	}
+
+	config.Set("general.autocheckpoint.enabled", true)
+	for _, maxK := range []int{5, 30, 40} {
+		config.Set("general.autocheckpoint.maxk", maxK)
+		if _, err := newCheckpointTuner(config, 10, 20); err == nil {
+			t.Errorf("Expected a maximum checkpoint interval of %d to be rejected", maxK)
+		}
+	}
+	config.Set("general.autocheckpoint.maxk", 20)
+	config.Set("general.autocheckpoint.overhead", 1.5)
+	if _, err := newCheckpointTuner(config, 10, 20); err == nil {
+		t.Errorf("Expected a checkpoint overhead above 1 to be rejected")
+	}
+}
+
+func TestCheckpointTunerAdjusts(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.autocheckpoint.enabled", true)
+	config.Set("general.autocheckpoint.maxk", 40)
+	config.Set("general.autocheckpoint.overhead", 0.1)
+	tuner, err := newCheckpointTuner(config, 10, 40)
+	if err != nil {
+		t.Fatalf("Failed to create checkpoint tuner: %s", err)
+	}
+
+	now := time.Unix(1000, 0)
+	tuner.checkpointed(now, 0)
+	for _, expected := range []uint64{20, 40, 40} {
+		now = now.Add(time.Second)
+		tuner.checkpointed(now, 200*time.Millisecond)
+		if p := tuner.period(10); p != expected {
+			t.Errorf("Expected expensive checkpoints to grow the interval to %d, got %d", expected, p)
+		}
+	}
+
+	now = now.Add(time.Second)
+	tuner.checkpointed(now, 10*time.Millisecond)
+	if p := tuner.period(10); p != 40 {
+		t.Errorf("Expected the interval to be kept while the log has room, got %d", p)
+	}
+
+	for _, expected := range []uint64{20, 10, 10} {
+		tuner.stall()
+		now = now.Add(time.Second)
+		tuner.checkpointed(now, 10*time.Millisecond)
+		if p := tuner.period(10); p != expected {
+			t.Errorf("Expected a full log to shrink the interval to %d, got %d", expected, p)
+		}
+	}
+}
+
+func TestMixedCheckpointIntervals(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 4)
+	config.Set("general.autocheckpoint.enabled", true)
+	config.Set("general.autocheckpoint.maxk", 4)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.Stop()
+
+	// Two replicas checkpoint half as often as the others
+	net.pbftEndpoints[0].pbft.tuner.interval = 4
+	net.pbftEndpoints[1].pbft.tuner.interval = 4
+
+	broadcaster := uint64(generateBroadcaster(validatorCount))
+	for i := int64(1); i <= 8; i++ {
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(i, broadcaster)
+		if err := net.Process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.pbft.LastExec != 8 {
+			t.Errorf("Replica %d expected to execute 8 requests, executed %d", pep.pbft.ID, pep.pbft.LastExec)
+		}
+		if pep.pbft.h != 8 {
+			t.Errorf("Replica %d expected the common checkpoint at 8 to become stable, low watermark is %d", pep.pbft.ID, pep.pbft.h)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// This file is the client side of the reply phase: every replica signs a
+// Reply once it executed a request, and f+1 matching replies of distinct
+// replicas prove that the request was ordered and executed, as at least one
+// of them comes from a correct replica
+
+// Verifier checks that signature is a valid signature of msg by the replica
+type Verifier func(replicaID uint64, signature []byte, msg []byte) error
+
+// ReplyCollector assembles the replies to requests into execution
+// certificates. It is not safe for concurrent use
+type ReplyCollector struct {
+	f       int
+	verify  Verifier
+	replies map[string]map[uint64]*Reply // by request digest and replica
+}
+
+// NewReplyCollector creates a collector for a network tolerating f faults
+func NewReplyCollector(f int, verify Verifier) *ReplyCollector {
+	return &ReplyCollector{
+		f:       f,
+		verify:  verify,
+		replies: make(map[string]map[uint64]*Reply),
+	}
+}
+
+// Add verifies a reply and returns the execution certificate of its request
+// once f+1 replicas sent matching replies, nil before. The replies to a
+// request are discarded once its certificate was returned
+func (rc *ReplyCollector) Add(reply *Reply) (*ReplyCertificate, error) {
+	if err := verifySignatureWith(rc.verify, reply); err != nil {
+		return nil, fmt.Errorf("Reply from replica %d failed verification: %s", reply.ReplicaId, err)
+	}
+
+	replies, ok := rc.replies[reply.RequestDigest]
+	if !ok {
+		replies = make(map[uint64]*Reply)
+		rc.replies[reply.RequestDigest] = replies
+	}
+	replies[reply.ReplicaId] = reply
+
+	cert := &ReplyCertificate{}
+	for _, r := range replies {
+		if repliesMatch(r, reply) {
+			cert.Replies = append(cert.Replies, r)
+		}
+	}
+	if len(cert.Replies) <= rc.f {
+		return nil, nil
+	}
+	rc.Forget(reply.RequestDigest)
+	return cert, nil
+}
+
+// Forget discards the replies to a request
+func (rc *ReplyCollector) Forget(digest string) {
+	delete(rc.replies, digest)
+}
+
+// VerifyReplyCertificate checks that cert holds matching, validly signed
+// replies of f+1 distinct replicas, and returns the reply they agree on
+func VerifyReplyCertificate(cert *ReplyCertificate, f int, verify Verifier) (*Reply, error) {
+	if len(cert.Replies) == 0 {
+		return nil, fmt.Errorf("Empty reply certificate")
+	}
+	first := cert.Replies[0]
+	replicas := make(map[uint64]bool)
+	for _, r := range cert.Replies {
+		if !repliesMatch(r, first) {
+			return nil, fmt.Errorf("Reply of replica %d does not match reply of replica %d", r.ReplicaId, first.ReplicaId)
+		}
+		if err := verifySignatureWith(verify, r); err != nil {
+			return nil, fmt.Errorf("Reply from replica %d failed verification: %s", r.ReplicaId, err)
+		}
+		replicas[r.ReplicaId] = true
+	}
+	if len(replicas) <= f {
+		return nil, fmt.Errorf("Reply certificate holds replies of %d replicas, need %d", len(replicas), f+1)
+	}
+	return first, nil
+}
+
+// repliesMatch compares the outcome two replicas report, replicas may have
+// executed the request in different views
+func repliesMatch(a, b *Reply) bool {
+	return a.SequenceNumber == b.SequenceNumber && a.RequestDigest == b.RequestDigest && bytes.Equal(a.Result, b.Result)
+}
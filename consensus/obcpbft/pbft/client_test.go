@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// testVerifier accepts messages signed with themselves
+func testVerifier(replicaID uint64, signature []byte, msg []byte) error {
+	if !bytes.Equal(signature, msg) {
+		return fmt.Errorf("invalid signature of replica %d", replicaID)
+	}
+	return nil
+}
+
+func signedReply(replicaID uint64, result string) *Reply {
+	reply := &Reply{
+		View:           0,
+		SequenceNumber: 1,
+		RequestDigest:  "digest",
+		Result:         []byte(result),
+		ReplicaId:      replicaID,
+	}
+	reply.Signature, _ = reply.serialize()
+	return reply
+}
+
+func TestReplyCollector(t *testing.T) {
+	rc := NewReplyCollector(1, testVerifier)
+
+	for _, reply := range []*Reply{signedReply(0, "state"), signedReply(1, "other"), signedReply(0, "state")} {
+		if cert, err := rc.Add(reply); err != nil || cert != nil {
+			t.Fatalf("Expected no certificate without f+1 matching replies of distinct replicas, got %v, %v", cert, err)
+		}
+	}
+
+	forged := signedReply(3, "state")
+	forged.Signature = []byte("forged")
+	if _, err := rc.Add(forged); err == nil {
+		t.Errorf("Expected a reply with an invalid signature to be rejected")
+	}
+
+	cert, err := rc.Add(signedReply(2, "state"))
+	if err != nil || cert == nil {
+		t.Fatalf("Expected a certificate from matching replies of replicas 0 and 2, got %v, %v", cert, err)
+	}
+	reply, err := VerifyReplyCertificate(cert, 1, testVerifier)
+	if err != nil {
+		t.Fatalf("Expected the certificate to verify: %s", err)
+	}
+	if string(reply.Result) != "state" {
+		t.Errorf("Expected the certificate to attest the matching result, got %s", reply.Result)
+	}
+}
+
+func TestVerifyReplyCertificate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		replies []*Reply
+	}{
+		{"too few replicas", []*Reply{signedReply(0, "state"), signedReply(0, "state")}},
+		{"mismatching results", []*Reply{signedReply(0, "state"), signedReply(1, "other")}},
+		{"empty", nil},
+	} {
+		if _, err := VerifyReplyCertificate(&ReplyCertificate{tc.replies}, 1, testVerifier); err == nil {
+			t.Errorf("Expected a certificate with %s to be rejected", tc.name)
+		}
+	}
+
+	// Replies altered after they were signed
+	altered := []*Reply{signedReply(0, "state"), signedReply(1, "state")}
+	for _, r := range altered {
+		r.SequenceNumber = 2
+	}
+	if _, err := VerifyReplyCertificate(&ReplyCertificate{altered}, 1, testVerifier); err == nil {
+		t.Errorf("Expected a certificate with altered replies to be rejected")
+	}
+}
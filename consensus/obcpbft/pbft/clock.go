@@ -0,0 +1,113 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a source of time for the timers of a replica, which tests and
+// embedders may provide to control the passage of time
+type Clock interface {
+	Now() time.Time                         // the current time
+	After(d time.Duration) <-chan time.Time // fires once d has passed
+}
+
+// wallClock is the clock of the system
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// virtualClock is a clock which only moves forward when told to, so that
+// timeouts can be simulated without waiting for them
+type virtualClock struct {
+	lock    sync.Mutex
+	current time.Time
+	waiters []*virtualWaiter
+}
+
+type virtualWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+type virtualWaiters []*virtualWaiter
+
+func (vw virtualWaiters) Len() int           { return len(vw) }
+func (vw virtualWaiters) Swap(i, j int)      { vw[i], vw[j] = vw[j], vw[i] }
+func (vw virtualWaiters) Less(i, j int) bool { return vw[i].deadline.Before(vw[j].deadline) }
+
+// newVirtualClock creates a virtualClock which reads start until it is advanced
+func newVirtualClock(start time.Time) *virtualClock {
+	return &virtualClock{current: start}
+}
+
+func (vc *virtualClock) Now() time.Time {
+	vc.lock.Lock()
+	defer vc.lock.Unlock()
+	return vc.current
+}
+
+func (vc *virtualClock) After(d time.Duration) <-chan time.Time {
+	vc.lock.Lock()
+	defer vc.lock.Unlock()
+
+	w := &virtualWaiter{
+		deadline: vc.current.Add(d),
+		c:        make(chan time.Time, 1), // so that firing never blocks, as with time.After
+	}
+	if d <= 0 {
+		w.c <- vc.current
+		return w.c
+	}
+	vc.waiters = append(vc.waiters, w)
+	return w.c
+}
+
+// advance moves the clock forward by d, firing the waiters whose deadline
+// passed in the order of their deadlines
+func (vc *virtualClock) advance(d time.Duration) {
+	vc.lock.Lock()
+	defer vc.lock.Unlock()
+
+	vc.current = vc.current.Add(d)
+	sort.Stable(virtualWaiters(vc.waiters))
+	fired := 0
+	for _, w := range vc.waiters {
+		if w.deadline.After(vc.current) {
+			break
+		}
+		w.c <- w.deadline
+		fired++
+	}
+	vc.waiters = vc.waiters[fired:]
+}
+
+// pending returns the number of waiters which have not fired yet
+func (vc *virtualClock) pending() int {
+	vc.lock.Lock()
+	defer vc.lock.Unlock()
+	return len(vc.waiters)
+}
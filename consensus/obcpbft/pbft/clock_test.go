@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestVirtualClockAdvance(t *testing.T) {
+	start := time.Unix(1000, 0)
+	vc := newVirtualClock(start)
+
+	late := vc.After(2 * time.Second)
+	early := vc.After(time.Second)
+	if vc.pending() != 2 {
+		t.Fatalf("Expected two pending waiters, got %d", vc.pending())
+	}
+
+	vc.advance(500 * time.Millisecond)
+	select {
+	case <-early:
+		t.Fatalf("Expected no waiter to fire before its deadline")
+	case <-late:
+		t.Fatalf("Expected no waiter to fire before its deadline")
+	default:
+	}
+
+	vc.advance(time.Second)
+	if fired := <-early; !fired.Equal(start.Add(time.Second)) {
+		t.Errorf("Expected the early waiter to fire at its deadline, fired at %v", fired)
+	}
+	select {
+	case <-late:
+		t.Fatalf("Expected the late waiter not to fire yet")
+	default:
+	}
+
+	vc.advance(time.Second)
+	<-late
+	if vc.pending() != 0 || !vc.Now().Equal(start.Add(2500*time.Millisecond)) {
+		t.Errorf("Expected no pending waiters at 2.5s, got %d at %v", vc.pending(), vc.Now())
+	}
+}
+
+func TestEventTimerVirtualClock(t *testing.T) {
+	events := make(chan interface{}, 1)
+	mr := newMockManager(func(event interface{}) interface{} {
+		events <- event
+		return nil
+	})
+	mr.Start()
+	defer mr.halt()
+	vc := newVirtualClock(time.Unix(0, 0))
+	timer := newClockedEventTimer(mr, vc)
+	defer timer.halt()
+
+	me := &mockEvent{}
+	timer.Reset(time.Hour, me)
+	for vc.pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	vc.advance(59 * time.Minute)
+	select {
+	case <-events:
+		t.Fatalf("Expected the timer not to fire before the virtual clock reached its timeout")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	vc.advance(time.Minute)
+	select {
+	case e := <-events:
+		if e != me {
+			t.Fatalf("Received wrong output from event timer")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for event to fire")
+	}
+}
+
+func TestRequestTimeoutVirtualClock(t *testing.T) {
+	viewChanges := make(chan *ViewChange, 1)
+	mock := &omniProto{
+		broadcastImpl: func(msgPayload []byte) {
+			msg := &Message{}
+			if err := proto.Unmarshal(msgPayload, msg); err != nil {
+				t.Fatalf("Could not unmarshal broadcast message: %s", err)
+			}
+			if vc := msg.GetViewChange(); vc != nil {
+				viewChanges <- vc
+			}
+		},
+		validateImpl:   func(txRaw []byte) error { return nil },
+		signImpl:       func(msg []byte) ([]byte, error) { return msg, nil },
+		viewChangeImpl: func(v uint64) {},
+		verifyImpl:     func(senderID uint64, signature []byte, message []byte) error { return nil },
+		StoreStateImpl: (&mockPersist{}).StoreState,
+	}
+	vc := newVirtualClock(time.Unix(0, 0))
+	instance := newPbftCoreWithClock(1, loadConfig(), mock, vc)
+	instance.Manager.Start()
+	defer instance.Close()
+
+	instance.Manager.Queue() <- createPbftRequestWithChainTx(1, 0)
+	for vc.pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	vc.advance(instance.RequestTimeout - time.Millisecond)
+	select {
+	case <-viewChanges:
+		t.Fatalf("Expected no view change before the request timed out")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	vc.advance(time.Millisecond)
+	select {
+	case v := <-viewChanges:
+		if v.View != 1 {
+			t.Errorf("Expected a view change to view 1, got %d", v.View)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the request timeout to trigger a view change")
+	}
+}
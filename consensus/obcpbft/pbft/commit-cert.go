@@ -0,0 +1,149 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// commitCertWireVersion is the first wire version whose replicas understand
+// threshold signature shares in commits
+const commitCertWireVersion uint32 = 6
+
+// commitStatement is what the replicas sign to certify that a request
+// committed at a sequence number
+func commitStatement(seqNo uint64, digest string) []byte {
+	return []byte(fmt.Sprintf("commit %d %s", seqNo, digest))
+}
+
+// commitCertificates aggregates the commits of a quorum into a single
+// certificate for every request executed, which the consumer persists with
+// the block in its metadata. The certificates are read by the consumer from
+// the execution thread
+type commitCertificates struct {
+	sync.Mutex
+	key   *ThresholdKey
+	certs map[uint64]*CommitCertificate // by sequence number, above the low watermark
+}
+
+// newCommitCertificates returns nil if general.threshold.commits is false
+func newCommitCertificates(threshold *thresholdCheckpoints, config *viper.Viper) (*commitCertificates, error) {
+	if !config.GetBool("general.threshold.commits") {
+		return nil, nil
+	}
+	if threshold == nil {
+		return nil, fmt.Errorf("Commit certificates need a threshold key, set general.threshold.keyfile")
+	}
+	return &commitCertificates{
+		key:   threshold.key,
+		certs: make(map[uint64]*CommitCertificate),
+	}, nil
+}
+
+func (cc *commitCertificates) get(seqNo uint64) *CommitCertificate {
+	cc.Lock()
+	defer cc.Unlock()
+	return cc.certs[seqNo]
+}
+
+func (cc *commitCertificates) put(cert *CommitCertificate) {
+	cc.Lock()
+	defer cc.Unlock()
+	cc.certs[cert.SequenceNumber] = cert
+}
+
+// prune forgets the certificates below h
+func (cc *commitCertificates) prune(h uint64) {
+	cc.Lock()
+	defer cc.Unlock()
+	for n := range cc.certs {
+		if n < h {
+			delete(cc.certs, n)
+		}
+	}
+}
+
+// VerifyCommitCertificate checks that the metadata of a block carries a
+// commit certificate for its sequence number, and returns it. Only the
+// modulus and exponent of key are used, so that light clients and auditors
+// need nothing but the public key of the network to verify that a block is
+// final
+func VerifyCommitCertificate(key *ThresholdKey, metadata []byte) (*CommitCertificate, error) {
+	meta := &Metadata{}
+	if err := proto.Unmarshal(metadata, meta); err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal block metadata: %s", err)
+	}
+	cert := meta.CommitCertificate
+	if cert == nil {
+		return nil, fmt.Errorf("Block %d carries no commit certificate", meta.SeqNo)
+	}
+	if cert.SequenceNumber != meta.SeqNo {
+		return nil, fmt.Errorf("Block %d carries the commit certificate of sequence number %d", meta.SeqNo, cert.SequenceNumber)
+	}
+	if !key.verify(commitStatement(cert.SequenceNumber, cert.RequestDigest), cert.Signature) {
+		return nil, fmt.Errorf("Commit certificate of block %d has an invalid signature", meta.SeqNo)
+	}
+	return cert, nil
+}
+
+// =============================================================================
+// pbftCore glue
+// =============================================================================
+
+// commitShare returns our share of the commit certificate of digest at
+// seqNo, nil if commits are not aggregated or some replica could not decode it
+func (instance *PbftCore) commitShare(seqNo uint64, digest string) []byte {
+	if instance.commitCerts == nil || instance.Wire.version() < commitCertWireVersion {
+		return nil
+	}
+	return instance.commitCerts.key.signShare(commitStatement(seqNo, digest))
+}
+
+// certifyCommit combines the shares of the commits of the request committed
+// at idx into its commit certificate
+func (instance *PbftCore) certifyCommit(idx msgID, digest string) {
+	if instance.commitCerts == nil || digest == "" {
+		return
+	}
+	cert := instance.certStore[idx]
+	shares := make(map[uint64][]byte)
+	for _, commit := range cert.commit {
+		if commit.RequestDigest == digest && commit.Share != nil {
+			shares[commit.ReplicaId] = commit.Share
+		}
+	}
+	signature := instance.commitCerts.key.combineAny(commitStatement(idx.n, digest), shares)
+	if signature == nil {
+		instance.log.Warning("Cannot combine the commit certificate for seqNo %d from the shares of %d replicas", idx.n, len(shares))
+		return
+	}
+	instance.commitCerts.put(&CommitCertificate{SequenceNumber: idx.n, RequestDigest: digest, Signature: signature})
+	instance.Metrics.commitCerts.Inc()
+}
+
+// CommitCertificate returns the commit certificate of the request executed
+// at seqNo, nil if there is none. It may be called from any goroutine
+func (instance *PbftCore) CommitCertificate(seqNo uint64) *CommitCertificate {
+	if instance.commitCerts == nil {
+		return nil
+	}
+	return instance.commitCerts.get(seqNo)
+}
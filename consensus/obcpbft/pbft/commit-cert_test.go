@@ -0,0 +1,87 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestCommitCertificates(t *testing.T) {
+	keys := dealTestKeys(t)
+	keyFile, cleanup := writeThresholdKey(t, keys[0])
+	defer cleanup()
+
+	config := loadConfig()
+	config.Set("general.threshold.keyfile", keyFile)
+	config.Set("general.threshold.commits", true)
+	instance := newPbftCore(0, config, &omniProto{
+		broadcastImpl: func(msgPayload []byte) {},
+		unicastImpl:   func(msgPayload []byte, receiverID uint64) error { return nil },
+	})
+	defer instance.Close()
+
+	if share := instance.commitShare(1, "digest"); share != nil {
+		t.Fatalf("Expected no share before all replicas speak a wire version which carries it")
+	}
+	for _, id := range []uint64{1, 2, 3} {
+		sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_WireHello{newWireNegotiator(id, 4).hello(true)}}, sender: id})
+	}
+	if share := instance.commitShare(1, "digest"); share == nil {
+		t.Fatalf("Expected a share once all replicas speak a wire version which carries it")
+	}
+
+	idx := msgID{v: 0, n: 1}
+	msg := commitStatement(1, "digest")
+	cert := instance.getCert(idx.v, idx.n)
+	cert.commit = []*Commit{
+		{View: 0, SequenceNumber: 1, RequestDigest: "digest", ReplicaId: 0, Share: keys[0].signShare(msg)},
+		{View: 0, SequenceNumber: 1, RequestDigest: "digest", ReplicaId: 1, Share: keys[1].signShare(msg)},
+		{View: 0, SequenceNumber: 1, RequestDigest: "digest", ReplicaId: 3, Share: keys[3].signShare(commitStatement(1, "forged"))},
+		{View: 0, SequenceNumber: 1, RequestDigest: "digest", ReplicaId: 2, Share: keys[2].signShare(msg)},
+	}
+	instance.certifyCommit(idx, "digest")
+	if instance.CommitCertificate(1) == nil {
+		t.Fatalf("Expected the valid shares to combine into a commit certificate despite the invalid one")
+	}
+
+	public := &ThresholdKey{Modulus: keys[0].Modulus, Exponent: keys[0].Exponent}
+	meta, _ := proto.Marshal(&Metadata{SeqNo: 1, CommitCertificate: instance.CommitCertificate(1)})
+	if cc, err := VerifyCommitCertificate(public, meta); err != nil || cc.RequestDigest != "digest" {
+		t.Errorf("Expected the commit certificate of the block to verify with the public key, got %v", err)
+	}
+	meta, _ = proto.Marshal(&Metadata{SeqNo: 2, CommitCertificate: instance.CommitCertificate(1)})
+	if _, err := VerifyCommitCertificate(public, meta); err == nil {
+		t.Errorf("Expected the commit certificate of another block to be rejected")
+	}
+	forged := *instance.CommitCertificate(1)
+	forged.RequestDigest = "forged"
+	meta, _ = proto.Marshal(&Metadata{SeqNo: 1, CommitCertificate: &forged})
+	if _, err := VerifyCommitCertificate(public, meta); err == nil {
+		t.Errorf("Expected a commit certificate for another request to be rejected")
+	}
+	meta, _ = proto.Marshal(&Metadata{SeqNo: 1})
+	if _, err := VerifyCommitCertificate(public, meta); err == nil {
+		t.Errorf("Expected a block without commit certificate to be rejected")
+	}
+
+	instance.moveWatermarks(10)
+	if instance.CommitCertificate(1) != nil {
+		t.Errorf("Expected commit certificates below the low watermark to be pruned")
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// maxDecompressedSize bounds the size of a decompressed payload, so that a
+// small malicious message cannot exhaust the memory of the receiver
+const maxDecompressedSize = 64 << 20
+
+// compressionCodec compresses payloads, replicas announce the names of the
+// codecs they decode in their wire hellos
+type compressionCodec struct {
+	compress   func(payload []byte) ([]byte, error)
+	decompress func(compressed []byte) ([]byte, error)
+}
+
+var compressionCodecs = make(map[string]*compressionCodec)
+
+// registerCompressionCodec makes a compression algorithm available under name
+func registerCompressionCodec(name string, codec *compressionCodec) {
+	if _, ok := compressionCodecs[name]; ok {
+		panic(fmt.Errorf("Compression codec %s registered twice", name))
+	}
+	compressionCodecs[name] = codec
+}
+
+func init() {
+	registerCompressionCodec("gzip", &compressionCodec{
+		compress: func(payload []byte) ([]byte, error) {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			if _, err := w.Write(payload); err != nil {
+				return nil, err
+			}
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+		decompress: func(compressed []byte) ([]byte, error) {
+			r, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return readLimited(r)
+		},
+	})
+}
+
+// readLimited reads r to the end, failing once it yields more than
+// maxDecompressedSize bytes
+func readLimited(r io.Reader) ([]byte, error) {
+	payload, err := ioutil.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed payload exceeds %d bytes", maxDecompressedSize)
+	}
+	return payload, nil
+}
+
+// compressedTag is how every marshaled compressed message starts, the key of
+// the compressed field of the payload oneof
+var compressedTag = proto.EncodeVarint(21<<3 | proto.WireBytes)
+
+// compressWirePayload wraps the marshaled message payload into a compressed
+// message
+func compressWirePayload(payload []byte, algorithm string) ([]byte, error) {
+	codec, ok := compressionCodecs[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression algorithm %s", algorithm)
+	}
+	compressed, err := codec.compress(payload)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&Message{&Message_Compressed{&Compressed{Algorithm: algorithm, Payload: compressed}}})
+}
+
+// decompressWirePayload returns the marshaled message a compressed message
+// wraps, and any other payload as is. Only the leading key is checked, so
+// that uncompressed messages are not unmarshaled twice
+func decompressWirePayload(payload []byte) ([]byte, error) {
+	if !bytes.HasPrefix(payload, compressedTag) {
+		return payload, nil
+	}
+	msg := &Message{}
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return nil, err
+	}
+	compressed := msg.GetCompressed()
+	if compressed == nil {
+		return payload, nil
+	}
+	codec, ok := compressionCodecs[compressed.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("payload compressed with unknown algorithm %s", compressed.Algorithm)
+	}
+	return codec.decompress(compressed.Payload)
+}
@@ -0,0 +1,43 @@
+// +build snappy
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+
+	"github.com/golang/snappy"
+)
+
+func init() {
+	registerCompressionCodec("snappy", &compressionCodec{
+		compress: func(payload []byte) ([]byte, error) {
+			return snappy.Encode(nil, payload), nil
+		},
+		decompress: func(compressed []byte) ([]byte, error) {
+			size, err := snappy.DecodedLen(compressed)
+			if err != nil {
+				return nil, err
+			}
+			if size > maxDecompressedSize {
+				return nil, fmt.Errorf("decompressed payload exceeds %d bytes", maxDecompressedSize)
+			}
+			return snappy.Decode(nil, compressed)
+		},
+	})
+}
@@ -0,0 +1,77 @@
+//go:build snappy
+// +build snappy
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestWireCompressionSnappy(t *testing.T) {
+	payload, _ := proto.Marshal(&Message{&Message_Request{&Request{Payload: bytes.Repeat([]byte("payload"), 100)}}})
+	wn := newWireNegotiator(0, 4)
+	wn.compress([]string{"snappy", "gzip"}, 64)
+	if hello := wn.hello(false); len(hello.Compression) != 2 || hello.Compression[0] != "snappy" {
+		t.Fatalf("Expected the hello to announce snappy before gzip, got %v", hello.Compression)
+	}
+	both := newWireNegotiator(1, 4)
+	both.compress([]string{"gzip", "snappy"}, 0)
+	wn.negotiate(both.hello(false))
+	gzipOnly := newWireNegotiator(2, 4)
+	gzipOnly.compress([]string{"gzip"}, 0)
+	wn.negotiate(gzipOnly.hello(false))
+
+	algorithm := func(msg *pb.Message) string {
+		wrapped := &Message{}
+		if err := proto.Unmarshal(msg.Payload, wrapped); err != nil || wrapped.GetCompressed() == nil {
+			return ""
+		}
+		return wrapped.GetCompressed().Algorithm
+	}
+	for _, c := range []struct {
+		receivers []uint64
+		expected  string
+	}{
+		{[]uint64{1}, "snappy"},
+		{[]uint64{1, 2}, "gzip"},
+		{[]uint64{2}, "gzip"},
+	} {
+		msg := wn.Envelope(payload, c.receivers...)
+		if a := algorithm(msg); a != c.expected {
+			t.Errorf("Expected a payload to %v to be compressed with %s, got %q", c.receivers, c.expected, a)
+		}
+		if opened, err := OpenWireMessage(msg); err != nil || !bytes.Equal(opened, payload) {
+			t.Errorf("Expected the payload to %v to decode, got %v", c.receivers, err)
+		}
+	}
+
+	bomb, _ := compressWirePayload(make([]byte, maxDecompressedSize+1), "snappy")
+	if _, err := OpenWireMessage(&pb.Message{Payload: bomb}); err == nil {
+		t.Errorf("Expected a payload decompressing beyond %d bytes to be rejected", maxDecompressedSize)
+	}
+	corrupt, _ := proto.Marshal(&Message{&Message_Compressed{&Compressed{Algorithm: "snappy", Payload: []byte{0xff}}}})
+	if _, err := OpenWireMessage(&pb.Message{Payload: corrupt}); err == nil {
+		t.Errorf("Expected a corrupt snappy payload to be rejected")
+	}
+}
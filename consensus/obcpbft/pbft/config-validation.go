@@ -0,0 +1,119 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// validateConfig checks the settings of replica id for inconsistencies which
+// would otherwise only surface at runtime, e.g. as a log window nothing can
+// be assigned in, or as view changes started by a timer which was meant to
+// prevent them. It reports all of them at once, along with what to change
+func validateConfig(id uint64, config *viper.Viper) error {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	N := config.GetInt("general.N")
+	f := config.GetInt("general.f")
+	switch {
+	case N < 1:
+		fail("general.N must be at least 1, got %d", N)
+	case f < 0:
+		fail("general.f must not be negative, got %d", f)
+	case 3*f+1 > N:
+		fail("general.f = %d needs general.N >= %d replicas to tolerate %d byzantine faults, got %d; lower f to %d or add replicas", f, 3*f+1, f, N, (N-1)/3)
+	}
+	if N >= 1 && id >= uint64(N) {
+		fail("replica ID %d is out of range for general.N = %d, the validators must be named vp0 to vp%d", id, N, N-1)
+	}
+
+	// L is K times the log multiplier, so that it is a multiple of K by
+	// construction, as long as K is positive
+	K := config.GetInt("general.K")
+	logMultiplier := config.GetInt("general.logmultiplier")
+	if K < 1 {
+		fail("general.K must be at least 1, got %d; the log size L is K * logmultiplier", K)
+	}
+	if logMultiplier < 2 {
+		fail("general.logmultiplier must be at least 2, got %d; the primary assigns sequence numbers up to L/2 above the low watermark", logMultiplier)
+	}
+
+	if length := config.GetInt("general.epoch.length"); length < 0 {
+		fail("general.epoch.length must not be negative, got %d; set it to 0 to end epochs only before the sequence numbers wrap around", length)
+	} else if length > 0 && K > 0 && length%K != 0 {
+		fail("general.epoch.length = %d must be a multiple of general.K = %d, epochs end with a checkpoint; set it to %d", length, K, (length+K-1)/K*K)
+	}
+
+	if workers := config.GetInt("general.queryworkers"); workers < 1 {
+		fail("general.queryworkers must be at least 1, got %d; queries are never executed on the event thread", workers)
+	}
+
+	if aggregators := config.GetInt("general.relay.aggregators"); aggregators < 0 {
+		fail("general.relay.aggregators must not be negative, got %d; set it to 0 to broadcast prepares and commits", aggregators)
+	} else if aggregators > 0 && (aggregators < f+1 || aggregators > N) {
+		fail("general.relay.aggregators = %d must be between f+1 = %d and general.N = %d, so that a correct replica aggregates every view", aggregators, f+1, N)
+	} else if mode := strings.ToLower(config.GetString("general.authentication.mode")); aggregators > 0 && mode != "mac" {
+		fail("general.relay.aggregators = %d requires general.authentication.mode = mac, got %s; signed votes carry no authenticator an aggregator cannot forge", aggregators, mode)
+	}
+
+	period := config.GetInt("general.viewchangeperiod")
+	if period < 0 {
+		fail("general.viewchangeperiod must not be negative, got %d; set it to 0 to disable automatic view changes", period)
+	}
+	if period > 0 && K > 0 && config.GetBool("general.autocheckpoint.enabled") {
+		if maxK := config.GetInt("general.autocheckpoint.maxk"); period*K < maxK {
+			fail("general.viewchangeperiod = %d rotates the primary every %d sequence numbers, before a checkpoint interval tuned up to general.autocheckpoint.maxk = %d completes; set it to at least %d", period, period*K, maxK, (maxK+K-1)/K)
+		}
+	}
+
+	requestTimeout, err := time.ParseDuration(config.GetString("general.timeout.request"))
+	if err != nil {
+		fail("general.timeout.request is not a duration: %s", err)
+	}
+	for _, phase := range []string{"prepare", "commit"} {
+		key := "general.timeout." + phase
+		raw := config.GetString(key)
+		if raw == "" {
+			continue
+		}
+		if timeout, err := time.ParseDuration(raw); err != nil {
+			fail("%s is not a duration: %s", key, err)
+		} else if timeout > 0 && requestTimeout > 0 && timeout >= requestTimeout {
+			fail("%s = %v must be shorter than general.timeout.request = %v, or the request timer expires first and the stalled phase is not reported", key, timeout, requestTimeout)
+		}
+	}
+	if raw := config.GetString("general.timeout.nullrequest"); raw != "" {
+		nullRequestTimeout, err := time.ParseDuration(raw)
+		if err != nil {
+			fail("general.timeout.nullrequest is not a duration: %s", err)
+		} else if nullRequestTimeout > 0 && requestTimeout > 0 && nullRequestTimeout >= requestTimeout {
+			fail("general.timeout.nullrequest = %v must be shorter than general.timeout.request = %v, or the backups start view changes before an idle primary sends its null request", nullRequestTimeout, requestTimeout)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Invalid PBFT configuration of replica %d:\n\t%s", id, strings.Join(problems, "\n\t"))
+}
@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateConfig(t *testing.T) {
+	if err := validateConfig(0, loadConfig()); err != nil {
+		t.Fatalf("Expected the default configuration to be valid, got %s", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		settings map[string]interface{}
+		id       uint64
+		problem  string
+	}{
+		{"f too large", map[string]interface{}{"general.N": 4, "general.f": 2}, 0, "lower f to 1"},
+		{"replica out of range", nil, 4, "vp0 to vp3"},
+		{"K zero", map[string]interface{}{"general.K": 0}, 0, "general.K must be at least 1"},
+		{"log multiplier", map[string]interface{}{"general.logmultiplier": 1}, 0, "general.logmultiplier must be at least 2"},
+		{"negative period", map[string]interface{}{"general.viewchangeperiod": -1}, 0, "general.viewchangeperiod must not be negative"},
+		{"period below maxk", map[string]interface{}{"general.K": 2, "general.viewchangeperiod": 3, "general.autocheckpoint.enabled": true, "general.autocheckpoint.maxk": 20}, 0, "set it to at least 10"},
+		{"request timeout", map[string]interface{}{"general.timeout.request": "soon"}, 0, "general.timeout.request is not a duration"},
+		{"null request timeout", map[string]interface{}{"general.timeout.nullrequest": "soon"}, 0, "general.timeout.nullrequest is not a duration"},
+		{"commit timeout too long", map[string]interface{}{"general.timeout.request": "2s", "general.timeout.commit": "3s"}, 0, "general.timeout.commit = 3s must be shorter"},
+		{"null request too long", map[string]interface{}{"general.timeout.request": "2s", "general.timeout.nullrequest": "2s"}, 0, "must be shorter than general.timeout.request"},
+		{"negative epoch", map[string]interface{}{"general.epoch.length": -1}, 0, "general.epoch.length must not be negative"},
+		{"epoch not a multiple of K", map[string]interface{}{"general.K": 10, "general.epoch.length": 25}, 0, "general.epoch.length = 25 must be a multiple"},
+		{"no query workers", map[string]interface{}{"general.queryworkers": 0}, 0, "general.queryworkers must be at least 1"},
+		{"negative aggregators", map[string]interface{}{"general.relay.aggregators": -1}, 0, "general.relay.aggregators must not be negative"},
+		{"too few aggregators", map[string]interface{}{"general.N": 4, "general.f": 1, "general.relay.aggregators": 1}, 0, "must be between f+1 = 2 and general.N = 4"},
+		{"aggregators without MACs", map[string]interface{}{"general.N": 4, "general.f": 1, "general.relay.aggregators": 2, "general.authentication.mode": "signature"}, 0, "requires general.authentication.mode = mac"},
+	} {
+		config := loadConfig()
+		for k, v := range tc.settings {
+			config.Set(k, v)
+		}
+		err := validateConfig(tc.id, config)
+		if err == nil || !strings.Contains(err.Error(), tc.problem) {
+			t.Errorf("%s: expected an error about %q, got %v", tc.name, tc.problem, err)
+		}
+	}
+
+	config := loadConfig()
+	config.Set("general.K", 0)
+	config.Set("general.timeout.request", "soon")
+	if err := validateConfig(0, config); err == nil || strings.Count(err.Error(), "\n\t") != 2 {
+		t.Errorf("Expected both problems to be reported, got %v", err)
+	}
+}
+
+func TestValidateConfigPanics(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.timeout.nullrequest", "soon")
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Expected an invalid configuration to be rejected at startup")
+		}
+	}()
+	newPbftCoreWithClock(0, config, &discardConsumer{&simpleConsumer{}}, newVirtualClock(time.Unix(0, 0)))
+}
@@ -0,0 +1,71 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"container/list"
+)
+
+// dedupCache remembers the digests of the most recently executed requests,
+// so that retransmitted requests are not ordered and executed again
+type dedupCache struct {
+	size    int
+	order   *list.List               // least recently used digest at the front
+	digests map[string]*list.Element // digest to its element in order
+}
+
+// newDedupCache returns nil if size is 0, which disables deduplication
+func newDedupCache(size int) *dedupCache {
+	if size <= 0 {
+		return nil
+	}
+	return &dedupCache{
+		size:    size,
+		order:   list.New(),
+		digests: make(map[string]*list.Element),
+	}
+}
+
+// add records digest as executed, evicting the least recently used digest
+// if the cache is full
+func (dc *dedupCache) add(digest string) {
+	if dc == nil || digest == "" {
+		return
+	}
+	if e, ok := dc.digests[digest]; ok {
+		dc.order.MoveToBack(e)
+		return
+	}
+	dc.digests[digest] = dc.order.PushBack(digest)
+	if dc.order.Len() > dc.size {
+		oldest := dc.order.Front()
+		dc.order.Remove(oldest)
+		delete(dc.digests, oldest.Value.(string))
+	}
+}
+
+// contains returns whether digest was executed recently, and refreshes it
+func (dc *dedupCache) contains(digest string) bool {
+	if dc == nil {
+		return false
+	}
+	e, ok := dc.digests[digest]
+	if ok {
+		dc.order.MoveToBack(e)
+	}
+	return ok
+}
@@ -0,0 +1,75 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+)
+
+func TestDedupCacheEviction(t *testing.T) {
+	dc := newDedupCache(2)
+	dc.add("a")
+	dc.add("b")
+	if !dc.contains("a") {
+		t.Fatalf("Expected a to be cached")
+	}
+	dc.add("c") // evicts b, as a was used more recently
+
+	if dc.contains("b") {
+		t.Errorf("Expected b to be evicted")
+	}
+	if !dc.contains("a") || !dc.contains("c") {
+		t.Errorf("Expected a and c to be cached")
+	}
+
+	disabled := newDedupCache(0)
+	disabled.add("a")
+	if disabled.contains("a") {
+		t.Errorf("Expected a disabled cache to contain nothing")
+	}
+}
+
+func TestNetworkDuplicateRequest(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.Stop()
+
+	msg := createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
+	for i := 0; i < 2; i++ {
+		for _, pep := range net.pbftEndpoints {
+			pep.pbft.Manager.Queue() <- msg
+		}
+		if err := net.Process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 1 {
+			t.Errorf("Instance %d executed the request %d times, expected once", pep.ID, pep.sc.executions)
+		}
+		if len(pep.pbft.OutstandingReqs) != 0 {
+			t.Errorf("Instance %d should not wait for the retransmitted request", pep.ID)
+		}
+		if pep.pbft.LastExec != 1 {
+			t.Errorf("Instance %d executed up to seqNo %d, expected 1", pep.ID, pep.pbft.LastExec)
+		}
+	}
+	if seqNo := net.pbftEndpoints[0].pbft.seqNo; seqNo != 1 {
+		t.Errorf("Primary ordered the retransmitted request, seqNo is %d", seqNo)
+	}
+}
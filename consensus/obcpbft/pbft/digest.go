@@ -0,0 +1,191 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/util"
+	"golang.org/x/crypto/sha3"
+)
+
+// defaultDigestAlgorithm is the algorithm of replicas which do not
+// announce the algorithm of their digests
+const defaultDigestAlgorithm = "shake256"
+
+// DigestProvider computes the digests identifying requests
+type DigestProvider interface {
+	name() string
+	hash(data []byte) []byte
+}
+
+type shake256Digest struct{}
+
+func (shake256Digest) name() string { return "shake256" }
+
+func (shake256Digest) hash(data []byte) []byte {
+	return util.ComputeCryptoHash(data)
+}
+
+type sha256Digest struct{}
+
+func (sha256Digest) name() string { return "sha256" }
+
+func (sha256Digest) hash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+type sha3Digest struct{}
+
+func (sha3Digest) name() string { return "sha3-256" }
+
+func (sha3Digest) hash(data []byte) []byte {
+	sum := sha3.Sum256(data)
+	return sum[:]
+}
+
+type blake2bDigest struct{}
+
+func (blake2bDigest) name() string { return "blake2b-256" }
+
+func (blake2bDigest) hash(data []byte) []byte {
+	return blake2b256(data)
+}
+
+// newDigestProvider returns the digest provider for the given algorithm
+func newDigestProvider(algorithm string) (DigestProvider, error) {
+	switch strings.ToLower(algorithm) {
+	case "", "shake256":
+		return shake256Digest{}, nil
+	case "sha256":
+		return sha256Digest{}, nil
+	case "sha3-256", "sha3":
+		return sha3Digest{}, nil
+	case "blake2b-256", "blake2b":
+		return blake2bDigest{}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported digest algorithm: %s", algorithm)
+	}
+}
+
+// checkDigestAlgorithm returns an error if the algorithm announced by a
+// message does not match ours
+func checkDigestAlgorithm(provider DigestProvider, announced string) error {
+	if announced == "" {
+		announced = defaultDigestAlgorithm
+	}
+	if announced != provider.name() {
+		return fmt.Errorf("digest algorithm mismatch, message uses %s, replica uses %s", announced, provider.name())
+	}
+	return nil
+}
+
+// =============================================================================
+// BLAKE2b as specified by RFC 7693, unkeyed with a 32 byte digest
+// =============================================================================
+
+var blake2bIV = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+var blake2bSigma = [12][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+}
+
+func rotr64(x uint64, n uint) uint64 {
+	return x>>n | x<<(64-n)
+}
+
+func blake2bCompress(h *[8]uint64, block []byte, t uint64, last bool) {
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(block[i*8:])
+	}
+
+	var v [16]uint64
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= t
+	if last {
+		v[14] = ^v[14]
+	}
+
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] = v[a] + v[b] + x
+		v[d] = rotr64(v[d]^v[a], 32)
+		v[c] = v[c] + v[d]
+		v[b] = rotr64(v[b]^v[c], 24)
+		v[a] = v[a] + v[b] + y
+		v[d] = rotr64(v[d]^v[a], 16)
+		v[c] = v[c] + v[d]
+		v[b] = rotr64(v[b]^v[c], 63)
+	}
+
+	for _, s := range blake2bSigma {
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
+
+func blake2b256(data []byte) []byte {
+	const size = 32
+	h := blake2bIV
+	h[0] ^= 0x01010000 ^ size
+
+	var t uint64
+	for len(data) > 128 {
+		t += 128
+		blake2bCompress(&h, data[:128], t, false)
+		data = data[128:]
+	}
+	var block [128]byte
+	copy(block[:], data)
+	t += uint64(len(data))
+	blake2bCompress(&h, block[:], t, true)
+
+	sum := make([]byte, 64)
+	for i := range h {
+		binary.LittleEndian.PutUint64(sum[i*8:], h[i])
+	}
+	return sum[:size]
+}
@@ -0,0 +1,100 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDigestProviders(t *testing.T) {
+	vectors := []struct {
+		algorithm string
+		data      []byte
+		digest    string
+	}{
+		{"sha256", []byte("abc"), "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"sha3-256", []byte("abc"), "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+		{"blake2b-256", []byte(""), "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"},
+		{"blake2b-256", []byte("abc"), "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		{"blake2b-256", bytes.Repeat([]byte("a"), 200), "6b6e59aaf00eb730cf93de53560846722184bbd92f8368c21ffa95380c2f9fe6"},
+	}
+	for _, v := range vectors {
+		provider, err := newDigestProvider(v.algorithm)
+		if err != nil {
+			t.Fatalf("Failed to create %s provider: %s", v.algorithm, err)
+		}
+		if digest := hex.EncodeToString(provider.hash(v.data)); digest != v.digest {
+			t.Errorf("Expected %s digest %s of %q, got %s", v.algorithm, v.digest, v.data, digest)
+		}
+	}
+
+	if _, err := newDigestProvider("md5"); err == nil {
+		t.Errorf("Expected an unsupported algorithm to be rejected")
+	}
+}
+
+func TestDigestAlgorithmMismatch(t *testing.T) {
+	if err := checkDigestAlgorithm(shake256Digest{}, ""); err != nil {
+		t.Errorf("Expected messages without an algorithm to use the default one: %s", err)
+	}
+	if err := checkDigestAlgorithm(sha256Digest{}, ""); err == nil {
+		t.Errorf("Expected messages without an algorithm not to match sha256")
+	}
+
+	config := loadConfig()
+	config.Set("general.digest", "sha256")
+	p := newPbftCore(1, config, &omniProto{})
+	defer p.Close()
+
+	req := &Request{Payload: []byte("foo")}
+	sendEvent(p, &PrePrepare{
+		View:           0,
+		SequenceNumber: 1,
+		RequestDigest:  HashReq(shake256Digest{}, req),
+		Request:        req,
+		ReplicaId:      0,
+	})
+	if cert := p.certStore[msgID{0, 1}]; cert != nil {
+		t.Errorf("Expected a pre-prepare using a different digest algorithm to be rejected")
+	}
+}
+
+func TestNetworkDigestAlgorithm(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.digest", "blake2b-256")
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.Stop()
+
+	msg := createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- msg
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	digest := HashReq(blake2bDigest{}, msg)
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 1 {
+			t.Errorf("Instance %d executed %d requests, expected 1", pep.ID, pep.sc.executions)
+		}
+		if _, ok := pep.pbft.reqStore[digest]; !ok {
+			t.Errorf("Instance %d did not store the request under its blake2b digest", pep.ID)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	google_protobuf "google/protobuf"
+)
+
+// Sequence numbers are assigned in epochs. Every message bound to a sequence
+// number carries the epoch it was assigned in, and the replicas drop the
+// messages of other epochs than theirs. An epoch ends with the stable
+// checkpoint of its last sequence number, epochEnd, which is at most
+// general.epoch.length sequence numbers, and in any case far enough below the
+// largest sequence number that the watermarks cannot wrap around. Once a
+// request ending the epoch on behalf of an operator executed, epochEnd is
+// moved down to the next checkpoint. The replicas then restart the numbering
+// at 0 in the next epoch, from the state of that checkpoint
+
+const epochKey = "epoch"
+
+// epochReporter is implemented by consumers which record the epoch of their
+// executions, so that a restarted replica can tell whether the last
+// execution belongs to its epoch
+type epochReporter interface {
+	GetLastEpoch() (uint64, error)
+}
+
+// epochResetObserver is implemented by consumers which want to be notified
+// when a request ending the epoch executed. It is invoked on the pbft event
+// thread
+type epochResetObserver interface {
+	EpochResetExecuted(req *Request)
+}
+
+// maxEpochLength returns the longest epoch the replicas with checkpoint
+// period K and log size L support, the high watermark of its last checkpoint
+// must not wrap around
+func maxEpochLength(K uint64, L uint64) uint64 {
+	return (^uint64(0) - L) / K * K
+}
+
+// epochLength returns the configured length of the epochs, which
+// validateConfig checked to be a multiple of K
+func epochLength(length int, K uint64, L uint64) uint64 {
+	if max := maxEpochLength(K, L); length <= 0 || uint64(length) > max {
+		return max
+	}
+	return uint64(length)
+}
+
+// CurrentEpoch returns the epoch of the replica, it may be called from any
+// goroutine, e.g. by the consumer to record the epoch of an execution
+func (instance *PbftCore) CurrentEpoch() uint64 {
+	return atomic.LoadUint64(&instance.epoch)
+}
+
+// restoreEpoch reads the persisted epoch and its last sequence number
+func (instance *PbftCore) restoreEpoch() {
+	raw, err := instance.persistor.ReadState(epochKey)
+	if err != nil || raw == nil {
+		return
+	}
+	if len(raw) != 16 {
+		instance.log.Error("Persisted epoch of %d bytes is malformed, staying in epoch %d", len(raw), instance.epoch)
+		return
+	}
+	atomic.StoreUint64(&instance.epoch, binary.BigEndian.Uint64(raw))
+	instance.epochEnd = binary.BigEndian.Uint64(raw[8:])
+	instance.log.Info("Restored epoch %d, ending at seqNo %d", instance.epoch, instance.epochEnd)
+}
+
+func (instance *PbftCore) encodeEpoch() []byte {
+	raw := make([]byte, 16)
+	binary.BigEndian.PutUint64(raw, instance.epoch)
+	binary.BigEndian.PutUint64(raw[8:], instance.epochEnd)
+	return raw
+}
+
+// epochOf returns the epoch a message bound to a sequence number was sent
+// in, and false for the other messages
+func epochOf(msg interface{}) (uint64, bool) {
+	switch m := msg.(type) {
+	case *PrePrepare:
+		return m.Epoch, true
+	case *Prepare:
+		return m.Epoch, true
+	case *Commit:
+		return m.Epoch, true
+	case *Checkpoint:
+		return m.Epoch, true
+	case *ViewChange:
+		return m.Epoch, true
+	case *NewView:
+		return m.Epoch, true
+	}
+	return 0, false
+}
+
+// filterEpoch drops msg if replica senderID sent it in another epoch than
+// ours. The checkpoints of later epochs are kept apart, they tell us when we
+// fell behind by an epoch
+func (instance *PbftCore) filterEpoch(msg interface{}, senderID uint64) interface{} {
+	epoch, ok := epochOf(msg)
+	if !ok || epoch == instance.epoch {
+		return msg
+	}
+	if chkpt, ok := msg.(*Checkpoint); ok && epoch > instance.epoch {
+		instance.recvLaterEpochCheckpoint(chkpt)
+		return nil
+	}
+	instance.log.Debug("Dropping %T from replica %d sent in epoch %d, we are in epoch %d", msg, senderID, epoch, instance.epoch)
+	return nil
+}
+
+// recvLaterEpochCheckpoint tracks the checkpoints of epochs we did not reach.
+// Once f+1 replicas agree on one, a correct replica reached it, and we
+// transfer its state, entering its epoch once the transfer started
+func (instance *PbftCore) recvLaterEpochCheckpoint(chkpt *Checkpoint) {
+	if prev, ok := instance.epochChkpts[chkpt.ReplicaId]; ok && (prev.Epoch > chkpt.Epoch || prev.Epoch == chkpt.Epoch && prev.SequenceNumber > chkpt.SequenceNumber) {
+		return
+	}
+	instance.epochChkpts[chkpt.ReplicaId] = chkpt
+	if instance.pendingEpoch >= chkpt.Epoch {
+		return
+	}
+
+	var members []uint64
+	for replicaID, other := range instance.epochChkpts {
+		if other.Epoch == chkpt.Epoch && other.SequenceNumber == chkpt.SequenceNumber && other.Id == chkpt.Id {
+			members = append(members, replicaID)
+		}
+	}
+	if len(members) < instance.F+1 {
+		return
+	}
+	snapshotID, err := base64.StdEncoding.DecodeString(chkpt.Id)
+	if err != nil {
+		instance.chkptLog.Error("Replica %d received a weak checkpoint cert for epoch %d which could not be decoded (%s)", instance.ID, chkpt.Epoch, chkpt.Id)
+		return
+	}
+	sort.Sort(sortableUint64Slice(members))
+
+	instance.chkptLog.Warning("Out of date, f+1 nodes agree checkpoint with seqNo %d of epoch %d exists but we are in epoch %d", chkpt.SequenceNumber, chkpt.Epoch, instance.epoch)
+	instance.pendingEpoch = chkpt.Epoch
+	instance.reqStore = make(map[string]*Request) // Discard all our requests, as we will never know which were executed
+	instance.OutstandingReqs = make(map[string]*Request)
+	instance.AdaptiveTimeout.Reset()
+	instance.Phases.Reset()
+	instance.rollbackSpeculation()
+	instance.SkipInProgress = true
+	instance.consumer.InvalidateState()
+	instance.StopTimer()
+	instance.consumer.SkipTo(chkpt.SequenceNumber, snapshotID, members)
+}
+
+// adoptPendingEpoch enters the epoch state transfer brings us to, if it is
+// later than ours
+func (instance *PbftCore) adoptPendingEpoch() {
+	if instance.pendingEpoch <= instance.epoch {
+		return
+	}
+	instance.enterEpoch(instance.pendingEpoch, "")
+}
+
+// maybeRollEpoch enters the next epoch once the checkpoint of the last
+// sequence number of ours is stable
+func (instance *PbftCore) maybeRollEpoch() {
+	if instance.h != instance.epochEnd {
+		return
+	}
+	instance.enterEpoch(instance.epoch+1, instance.chkpts[instance.h])
+	instance.resubmitRequests()
+	if instance.ActiveView {
+		instance.startTimerIfOutstandingRequests()
+	}
+}
+
+// enterEpoch restarts the sequence numbers at 0 in epoch, whose state at
+// sequence number 0 was checkpointed as id, empty if unknown. The message log
+// only holds sequence numbers of the epoch we leave, it is discarded along
+// with the WAL, except for the requests still waiting for a sequence number.
+// The new epoch and the WAL are persisted in the same atomic write
+func (instance *PbftCore) enterEpoch(epoch uint64, id string) {
+	instance.chkptLog.Notice("Leaving epoch %d at seqNo %d, entering epoch %d", instance.epoch, instance.LastExec, epoch)
+
+	atomic.StoreUint64(&instance.epoch, epoch)
+	instance.epochEnd = instance.epochLength
+	instance.h = 0
+	instance.LastExec = 0
+	instance.seqNo = 0
+
+	instance.chkpts = make(map[uint64]string)
+	if id != "" {
+		instance.chkpts[0] = id
+	}
+	instance.certStore = make(map[msgID]*msgCert)
+	instance.checkpointStore = make(map[Checkpoint]bool)
+	instance.viewChangeStore = make(map[vcidx]*ViewChange)
+	instance.newViewStore = make(map[uint64]*NewView)
+	instance.pset = make(map[uint64]*ViewChange_PQ)
+	instance.qset = make(map[qidx]*ViewChange_PQ)
+	instance.lostReqs = make(map[string]msgID)
+	instance.missingReqs = make(map[string]bool)
+	instance.hChkpts = make(map[uint64]uint64)
+	instance.epochChkpts = make(map[uint64]*Checkpoint)
+	instance.catchupLog = make(map[uint64]*CatchUpCert)
+	instance.catchup = nil
+	instance.stableCert = nil
+	for digest := range instance.reqStore {
+		if _, ok := instance.OutstandingReqs[digest]; !ok {
+			delete(instance.reqStore, digest)
+		}
+	}
+	if instance.threshold != nil {
+		instance.threshold.prune(^uint64(0))
+	}
+	if instance.commitCerts != nil {
+		instance.commitCerts.prune(^uint64(0))
+	}
+	if instance.relay != nil {
+		instance.relay.prune(^uint64(0))
+	}
+	instance.updateViewChangeSeqNo()
+	instance.window.resize(0)
+
+	var carry []*Message
+	if id != "" {
+		carry = append(carry, &Message{&Message_Checkpoint{&Checkpoint{
+			SequenceNumber:  0,
+			ReplicaId:       instance.ID,
+			Id:              id,
+			DigestAlgorithm: instance.Digest.name(),
+			Epoch:           epoch,
+		}}})
+	}
+	carry = append(carry, instance.walCarry(0)...)
+	if err := instance.wal.reset(carry, map[string][]byte{epochKey: instance.encodeEpoch()}); err != nil {
+		instance.log.Error("Could not persist epoch %d: %s", epoch, err)
+	}
+}
+
+// endEpoch ends the epoch at the first checkpoint at or above n, once the
+// request at n asked for it. The sequence numbers above were assigned in
+// vain, their requests are assigned again in the next epoch
+func (instance *PbftCore) endEpoch(n uint64) {
+	end := (n + instance.K - 1) / instance.K * instance.K
+	if end >= instance.epochEnd {
+		return
+	}
+	instance.chkptLog.Notice("Ending epoch %d at seqNo %d on request of an operator, instead of at seqNo %d", instance.epoch, end, instance.epochEnd)
+	instance.epochEnd = end
+	if err := instance.persistor.StoreState(epochKey, instance.encodeEpoch()); err != nil {
+		instance.log.Error("Could not persist the end of epoch %d: %s", instance.epoch, err)
+	}
+
+	for idx := range instance.certStore {
+		if idx.n > end {
+			delete(instance.certStore, idx)
+		}
+	}
+	for n := range instance.pset {
+		if n > end {
+			delete(instance.pset, n)
+		}
+	}
+	for idx := range instance.qset {
+		if idx.n > end {
+			delete(instance.qset, idx)
+		}
+	}
+	if instance.seqNo > end {
+		instance.seqNo = end
+	}
+}
+
+// EpochResetRequest returns a request which ends the epoch once it executed
+func (instance *PbftCore) EpochResetRequest() *Request {
+	now := time.Now()
+	return &Request{
+		Timestamp: &google_protobuf.Timestamp{
+			Seconds: now.Unix(),
+			Nanos:   int32(now.UnixNano() % 1000000000),
+		},
+		ReplicaId:  instance.ID,
+		EpochReset: true,
+	}
+}
+
+// validateRequest rejects requests which must not be ordered, the requests
+// ending the epoch carry no payload for the consumer to validate
+func (instance *PbftCore) validateRequest(req *Request) error {
+	if !req.EpochReset {
+		return instance.consumer.ValidateRequest(req.Payload)
+	}
+	if len(req.Payload) > 0 {
+		return fmt.Errorf("Request ending the epoch carries a payload")
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEpochLength(t *testing.T) {
+	max := maxEpochLength(10, 40)
+	if max%10 != 0 || max+40 < max {
+		t.Fatalf("Expected the longest epoch to be a multiple of K whose high watermark does not wrap around, got %d", max)
+	}
+	if l := epochLength(0, 10, 40); l != max {
+		t.Errorf("Expected an unset epoch length to last as long as possible, got %d", l)
+	}
+	if l := epochLength(100, 10, 40); l != 100 {
+		t.Errorf("Expected the configured epoch length 100, got %d", l)
+	}
+}
+
+func TestFilterEpoch(t *testing.T) {
+	instance := newPbftCoreWithClock(1, loadConfig(), &discardConsumer{&simpleConsumer{}}, newVirtualClock(time.Unix(0, 0)))
+	defer instance.Close()
+	instance.epoch = 1
+
+	if msg := instance.filterEpoch(&Prepare{SequenceNumber: 1, Epoch: 0}, 2); msg != nil {
+		t.Errorf("Expected a prepare of an earlier epoch to be dropped")
+	}
+	if msg := instance.filterEpoch(&Commit{SequenceNumber: 1, Epoch: 2}, 2); msg != nil {
+		t.Errorf("Expected a commit of a later epoch to be dropped")
+	}
+	if msg := instance.filterEpoch(&Prepare{SequenceNumber: 1, Epoch: 1}, 2); msg == nil {
+		t.Errorf("Expected a prepare of our epoch to be processed")
+	}
+	if msg := instance.filterEpoch(&Request{}, 2); msg == nil {
+		t.Errorf("Expected a request, which carries no epoch, to be processed")
+	}
+}
+
+func TestEndEpoch(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.K", 2)
+	instance := newPbftCoreWithClock(1, config, &discardConsumer{&simpleConsumer{}}, newVirtualClock(time.Unix(0, 0)))
+	defer instance.Close()
+
+	for n := uint64(1); n <= 6; n++ {
+		instance.getCert(0, n)
+	}
+	instance.seqNo = 6
+	instance.endEpoch(3)
+	if instance.epochEnd != 4 {
+		t.Fatalf("Expected the epoch to end at the checkpoint following seqNo 3, got %d", instance.epochEnd)
+	}
+	if _, ok := instance.certStore[msgID{0, 5}]; ok {
+		t.Errorf("Expected the certificates above the end of the epoch to be discarded")
+	}
+	if _, ok := instance.certStore[msgID{0, 4}]; !ok {
+		t.Errorf("Expected the certificates up to the end of the epoch to be kept")
+	}
+	if instance.seqNo != 4 {
+		t.Errorf("Expected the primary to stop assigning sequence numbers at 4, got %d", instance.seqNo)
+	}
+	if instance.inW(5) {
+		t.Errorf("Expected seqNo 5 to be outside the watermarks of the ending epoch")
+	}
+
+	instance.endEpoch(1)
+	if instance.epochEnd != 2 {
+		t.Errorf("Expected a second reset to move the end further down, got %d", instance.epochEnd)
+	}
+	instance.endEpoch(3)
+	if instance.epochEnd != 2 {
+		t.Errorf("Expected a reset to never move the end of the epoch up, got %d", instance.epochEnd)
+	}
+}
+
+func TestEnterEpochPersists(t *testing.T) {
+	persist := &mockPersist{}
+	stack := &omniProto{
+		ReadStateImpl:    persist.ReadState,
+		ReadStateSetImpl: persist.ReadStateSet,
+		StoreStateImpl:   persist.StoreState,
+		DelStateImpl:     persist.DelState,
+	}
+
+	p := newPbftCore(1, loadConfig(), stack)
+	pending := &Request{Payload: []byte("pending")}
+	pendingDigest := HashReq(p.Digest, pending)
+	p.reqStore[pendingDigest] = pending
+	p.OutstandingReqs[pendingDigest] = pending
+	p.persistRequest(pendingDigest)
+	p.persistPrepare(&Prepare{View: 0, SequenceNumber: 1, RequestDigest: "foo", ReplicaId: 2})
+	p.enterEpoch(1, "chkpt")
+	if p.h != 0 || p.seqNo != 0 || p.CurrentEpoch() != 1 {
+		t.Fatalf("Expected the sequence numbers to start over in epoch 1, got h=%d seqNo=%d epoch=%d", p.h, p.seqNo, p.CurrentEpoch())
+	}
+	p.Close()
+
+	p = newPbftCore(1, loadConfig(), stack)
+	defer p.Close()
+	if p.epoch != 1 {
+		t.Fatalf("Expected epoch 1 to be restored, got %d", p.epoch)
+	}
+	if p.chkpts[0] != "chkpt" {
+		t.Errorf("Expected the checkpoint the epoch started from to be restored, got %v", p.chkpts)
+	}
+	if len(p.certStore) != 0 {
+		t.Errorf("Expected the messages of the earlier epoch to be discarded, got %v", p.certStore)
+	}
+	if p.OutstandingReqs[pendingDigest] == nil {
+		t.Errorf("Expected the pending request to be carried into the new epoch")
+	}
+}
+
+// epochConsumer records the epoch of its last execution
+type epochConsumer struct {
+	*discardConsumer
+	lastEpoch uint64
+}
+
+func (ec *epochConsumer) GetLastEpoch() (uint64, error) {
+	return ec.lastEpoch, nil
+}
+
+func TestRestoreLastSeqNoEpoch(t *testing.T) {
+	ec := &epochConsumer{discardConsumer: &discardConsumer{&simpleConsumer{executions: 1, lastSeqNo: 20}}}
+	ec.StoreState(epochKey, (&PbftCore{epoch: 1, epochEnd: 100}).encodeEpoch())
+
+	instance := newPbftCoreWithClock(1, loadConfig(), ec, newVirtualClock(time.Unix(0, 0)))
+	defer instance.Close()
+	if instance.epoch != 1 || instance.epochEnd != 100 {
+		t.Fatalf("Expected epoch 1 ending at 100 to be restored, got epoch %d ending at %d", instance.epoch, instance.epochEnd)
+	}
+	if instance.LastExec != 0 {
+		t.Errorf("Expected the execution of the earlier epoch not to count in epoch 1, got lastExec %d", instance.LastExec)
+	}
+}
+
+func TestEpochRollover(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	config.Set("general.epoch.length", 4)
+	net := makePBFTNetwork(4, config)
+	defer net.Stop()
+
+	for i := int64(1); i <= 6; i++ {
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(i, 0)
+		if err := net.Process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 6 {
+			t.Errorf("Instance %d executed %d requests, expected 6", pep.ID, pep.sc.executions)
+		}
+		if pep.pbft.epoch != 1 || pep.pbft.LastExec != 2 {
+			t.Errorf("Instance %d should have executed seqNo 2 of epoch 1, executed %d of epoch %d", pep.ID, pep.pbft.LastExec, pep.pbft.epoch)
+		}
+	}
+}
+
+func TestEpochReset(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.K", 2)
+	net := makePBFTNetwork(4, config)
+	defer net.Stop()
+
+	primary := net.pbftEndpoints[0].pbft
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(1, 0)
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- primary.EpochResetRequest()
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	for _, pep := range net.pbftEndpoints {
+		if pep.pbft.epoch != 1 || pep.pbft.h != 0 {
+			t.Errorf("Instance %d should have entered epoch 1 at seqNo 0, is in epoch %d with h=%d", pep.ID, pep.pbft.epoch, pep.pbft.h)
+		}
+	}
+
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(2, 0)
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 2 || pep.sc.lastSeqNo != 1 {
+			t.Errorf("Instance %d should have executed the request following the reset at seqNo 1 of epoch 1, executed %d requests, the last at %d", pep.ID, pep.sc.executions, pep.sc.lastSeqNo)
+		}
+	}
+}
+
+func TestEpochResetRequestCarriesNoPayload(t *testing.T) {
+	instance := newPbftCoreWithClock(1, loadConfig(), &discardConsumer{&simpleConsumer{}}, newVirtualClock(time.Unix(0, 0)))
+	defer instance.Close()
+
+	req := instance.EpochResetRequest()
+	if err := instance.validateRequest(req); err != nil {
+		t.Errorf("Expected the reset request to be valid, got %s", err)
+	}
+	req.Payload = []byte("smuggled")
+	if err := instance.validateRequest(req); err == nil {
+		t.Errorf("Expected a reset request carrying a payload to be rejected")
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// eventHooks are invoked by an eventManager as events pass through it, so
+// that metrics or tracing can be attached without modifying the manager.
+// Any of the hooks may be nil, they must not block
+type eventHooks struct {
+	enqueue func(event interface{}, depth int)             // an event was submitted, depth events are waiting including it
+	dequeue func(event interface{}, depth int)             // an event is handed to the receiver, depth events are still waiting
+	done    func(event interface{}, elapsed time.Duration) // the receiver finished processing an event
+	stuck   func(event interface{}, elapsed time.Duration) // the receiver has been processing an event for longer than the stuck threshold
+}
+
+// instrumentedManager is implemented by an eventManager which invokes eventHooks
+type instrumentedManager interface {
+	instrument(hooks *eventHooks) // adds hooks to the ones invoked
+}
+
+// eventInstrumentation holds the hooks of an eventManager, and the event
+// it is currently delivering, so that events which take longer than the
+// stuck threshold are reported while they are still being processed
+type eventInstrumentation struct {
+	lock       sync.Mutex
+	hooks      []*eventHooks
+	stuckAfter time.Duration // report events processing for longer, 0 if disabled
+	current    interface{}   // the event being delivered, nil if idle
+	since      time.Time     // when delivering the current event started
+	reported   bool          // whether the current event was reported as stuck
+}
+
+// newConfiguredEventManager creates the eventManager delivering events to
+// er, with the event queue and the stuck event threshold configured
+func newConfiguredEventManager(er EventReceiver, config *viper.Viper) (EventManager, error) {
+	queueConfig, err := newEventQueueConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	stuckAfter, err := time.ParseDuration(config.GetString("general.timeout.stuckevent"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse stuck event timeout: %s", err)
+	}
+	if stuckAfter < 0 {
+		return nil, fmt.Errorf("Stuck event timeout must not be negative, got %v", stuckAfter)
+	}
+	em := newBufferedEventManager(er, queueConfig).(*eventManagerImpl)
+	em.instrumentation.stuckAfter = stuckAfter
+	return em, nil
+}
+
+// instrument adds hooks to the ones invoked by the manager
+func (em *eventManagerImpl) instrument(hooks *eventHooks) {
+	ei := &em.instrumentation
+	ei.lock.Lock()
+	defer ei.lock.Unlock()
+	ei.hooks = append(ei.hooks, hooks)
+}
+
+// instrumented returns the hooks to invoke, or nil if there are none
+func (em *eventManagerImpl) instrumented() []*eventHooks {
+	ei := &em.instrumentation
+	ei.lock.Lock()
+	defer ei.lock.Unlock()
+	return ei.hooks
+}
+
+// enqueued invokes the enqueue hooks for an event which was submitted
+func (em *eventManagerImpl) enqueued(event interface{}) {
+	hooks := em.instrumented()
+	if hooks == nil || event == nil {
+		return
+	}
+	depth := em.depth()
+	for _, h := range hooks {
+		if h.enqueue != nil {
+			h.enqueue(event, depth)
+		}
+	}
+}
+
+// deliver hands event to the receiver, invoking the hooks around it. Nil
+// events only probe whether the event thread is idle, they are not reported
+func (em *eventManagerImpl) deliver(event interface{}) {
+	ei := &em.instrumentation
+	hooks := em.instrumented()
+	if event == nil || (hooks == nil && ei.stuckAfter == 0) {
+		em.Inject(event)
+		return
+	}
+
+	depth := em.depth()
+	for _, h := range hooks {
+		if h.dequeue != nil {
+			h.dequeue(event, depth)
+		}
+	}
+
+	start := time.Now()
+	ei.lock.Lock()
+	ei.current, ei.since, ei.reported = event, start, false
+	ei.lock.Unlock()
+
+	em.Inject(event)
+
+	elapsed := time.Since(start)
+	ei.lock.Lock()
+	reported := ei.reported
+	ei.current = nil
+	ei.lock.Unlock()
+
+	if reported {
+		logger.Warning("Event of type %T completed after %v", event, elapsed)
+	}
+	for _, h := range hooks {
+		if h.done != nil {
+			h.done(event, elapsed)
+		}
+	}
+}
+
+// processing returns the event being delivered, and for how long it has
+// been, nil if the event thread is idle or the manager is not instrumented
+func (em *eventManagerImpl) processing() (interface{}, time.Duration) {
+	ei := &em.instrumentation
+	ei.lock.Lock()
+	defer ei.lock.Unlock()
+	if ei.current == nil {
+		return nil, 0
+	}
+	return ei.current, time.Since(ei.since)
+}
+
+// watchLoop reports the event being delivered once it takes longer than the stuck threshold
+func (em *eventManagerImpl) watchLoop() {
+	ei := &em.instrumentation
+	ticker := time.NewTicker(ei.stuckAfter / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			ei.lock.Lock()
+			event, elapsed := ei.current, now.Sub(ei.since)
+			stuck := event != nil && !ei.reported && elapsed > ei.stuckAfter
+			if stuck {
+				ei.reported = true
+			}
+			hooks := ei.hooks
+			ei.lock.Unlock()
+
+			if !stuck {
+				continue
+			}
+			logger.Warning("Event of type %T has been processing for %v, the event thread may be stuck", event, elapsed)
+			for _, h := range hooks {
+				if h.stuck != nil {
+					h.stuck(event, elapsed)
+				}
+			}
+		case <-em.exit:
+			return
+		}
+	}
+}
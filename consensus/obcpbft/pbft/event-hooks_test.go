@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEventHooks(t *testing.T) {
+	trace := make(chan string, 10)
+	config := loadConfig()
+	config.Set("general.eventqueue.capacity", 10)
+	em, err := newConfiguredEventManager(&mockReceiver{}, config)
+	if err != nil {
+		t.Fatalf("Could not create event manager: %s", err)
+	}
+	em.(instrumentedManager).instrument(&eventHooks{
+		enqueue: func(event interface{}, depth int) {
+			trace <- fmt.Sprintf("enqueue %T", event)
+		},
+		dequeue: func(event interface{}, depth int) {
+			trace <- fmt.Sprintf("dequeue %T", event)
+		},
+		done: func(event interface{}, elapsed time.Duration) {
+			trace <- fmt.Sprintf("done %T", event)
+		},
+	})
+	em.Start()
+	defer em.halt()
+
+	em.Queue() <- &mockEvent{}
+	for _, expected := range []string{"enqueue *pbft.mockEvent", "dequeue *pbft.mockEvent", "done *pbft.mockEvent"} {
+		select {
+		case hook := <-trace:
+			if hook != expected {
+				t.Fatalf("Expected hook %q, got %q", expected, hook)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for hook %q", expected)
+		}
+	}
+}
+
+func TestStuckEventReported(t *testing.T) {
+	release := make(chan struct{})
+	stuck := make(chan interface{}, 10)
+	config := loadConfig()
+	config.Set("general.timeout.stuckevent", "20ms")
+	em, err := newConfiguredEventManager(&mockReceiver{
+		processEventImpl: func(event interface{}) interface{} {
+			<-release
+			return nil
+		},
+	}, config)
+	if err != nil {
+		t.Fatalf("Could not create event manager: %s", err)
+	}
+	em.(instrumentedManager).instrument(&eventHooks{
+		stuck: func(event interface{}, elapsed time.Duration) {
+			if elapsed < 20*time.Millisecond {
+				t.Errorf("Expected the event to be reported after the stuck event timeout, reported after %v", elapsed)
+			}
+			stuck <- event
+		},
+	})
+	em.Start()
+	defer em.halt()
+
+	me := &mockEvent{}
+	em.Queue() <- me
+	select {
+	case event := <-stuck:
+		if event != me {
+			t.Fatalf("Expected the blocked event to be reported as stuck, got %v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the stuck event to be reported")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if len(stuck) != 0 {
+		t.Errorf("Expected the stuck event to be reported once, reported %d more times", len(stuck))
+	}
+}
+
+func TestStuckEventConfig(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.timeout.stuckevent", "-1s")
+	if _, err := newConfiguredEventManager(&mockReceiver{}, config); err == nil {
+		t.Fatalf("Expected a negative stuck event timeout to be rejected")
+	}
+}
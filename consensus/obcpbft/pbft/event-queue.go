@@ -0,0 +1,509 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+)
+
+// overflowPolicy decides what happens to events which arrive while the event queue is full
+type overflowPolicy int
+
+const (
+	overflowBlock overflowPolicy = iota // block the sender until the event thread catches up
+	overflowDrop                        // drop the oldest queued client request
+	overflowSpill                       // write client requests to disk until the queue drains
+)
+
+var overflowPolicies = map[string]overflowPolicy{
+	"block": overflowBlock,
+	"drop":  overflowDrop,
+	"spill": overflowSpill,
+}
+
+// eventQueueConfig describes how many events may wait for the event thread
+type eventQueueConfig struct {
+	capacity int            // events queued for the event thread, 0 if they are handed over directly
+	policy   overflowPolicy // what happens to events arriving while the queue is full
+	spillDir string         // directory client requests are spilled to, the system temporary directory if empty
+}
+
+// newEventQueueConfig reads the event queue configuration, it returns nil if events are handed to the event thread directly
+func newEventQueueConfig(config *viper.Viper) (*eventQueueConfig, error) {
+	capacity := config.GetInt("general.eventqueue.capacity")
+	if capacity < 0 {
+		return nil, fmt.Errorf("Event queue capacity must not be negative, got %d", capacity)
+	}
+	if capacity == 0 {
+		return nil, nil
+	}
+	policy, ok := overflowPolicies[config.GetString("general.eventqueue.overflow")]
+	if !ok {
+		return nil, fmt.Errorf("Unknown event queue overflow policy %q, expected block, drop or spill", config.GetString("general.eventqueue.overflow"))
+	}
+	return &eventQueueConfig{
+		capacity: capacity,
+		policy:   policy,
+		spillDir: config.GetString("general.eventqueue.spilldir"),
+	}, nil
+}
+
+// queueStats may be implemented by an eventManager which buffers events
+type queueStats interface {
+	depth() int      // events waiting for the event thread
+	dropped() uint64 // client requests dropped since the manager was created
+	spilled() uint64 // client requests spilled to disk since the manager was created
+}
+
+// eventQueue buffers the events waiting for the event thread, up to its
+// capacity. Protocol-critical events wait in a lane of their own, which the
+// event thread services first, so a backlog of client requests cannot delay
+// failure recovery
+type eventQueue struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	config eventQueueConfig
+	urgent *list.List // protocol-critical events, see isPriorityEvent
+	events *list.List // all other events
+	spill  *spillFile // nil until the first client request is spilled
+	closed bool
+
+	droppedCount uint64
+	spilledCount uint64
+}
+
+func newEventQueue(config *eventQueueConfig) *eventQueue {
+	eq := &eventQueue{
+		config: *config,
+		urgent: list.New(),
+		events: list.New(),
+	}
+	eq.cond = sync.NewCond(&eq.lock)
+	return eq
+}
+
+// push queues event, applying the overflow policy while the queue is full
+func (eq *eventQueue) push(event interface{}) {
+	eq.lock.Lock()
+	defer eq.lock.Unlock()
+
+	if isPriorityEvent(event) {
+		for !eq.closed {
+			if eq.urgent.Len() < eq.config.capacity {
+				eq.urgent.PushBack(event)
+				eq.cond.Broadcast()
+				return
+			}
+			eq.cond.Wait()
+		}
+		return
+	}
+
+	request := isClientRequest(event)
+	for !eq.closed {
+		if request && eq.spill.pending() > 0 {
+			// Keep the order of the client requests, later ones queue up behind the spilled ones
+			if eq.spillEvent(event) {
+				return
+			}
+		}
+
+		if eq.events.Len() < eq.config.capacity {
+			eq.events.PushBack(event)
+			eq.cond.Broadcast()
+			return
+		}
+
+		switch eq.config.policy {
+		case overflowDrop:
+			if eq.dropOldestRequest() {
+				continue
+			}
+			if request {
+				// No client request is queued, so this one is the oldest
+				eq.droppedCount++
+				logger.Warning("Event queue full, dropping client request")
+				return
+			}
+		case overflowSpill:
+			if request && eq.spillEvent(event) {
+				return
+			}
+		}
+		eq.cond.Wait()
+	}
+}
+
+// pop returns the next event, blocking until there is one, it returns false once the queue is closed
+func (eq *eventQueue) pop() (interface{}, bool) {
+	eq.lock.Lock()
+	defer eq.lock.Unlock()
+
+	for eq.urgent.Len() == 0 && eq.events.Len() == 0 && !eq.closed {
+		if !eq.unspill() {
+			eq.cond.Wait()
+		}
+	}
+	if eq.closed {
+		return nil, false
+	}
+
+	var event interface{}
+	if eq.urgent.Len() > 0 {
+		event = eq.urgent.Remove(eq.urgent.Front())
+	} else {
+		event = eq.events.Remove(eq.events.Front())
+		eq.unspill()
+	}
+	eq.cond.Broadcast()
+	return event, true
+}
+
+// close releases the senders and the event thread waiting on the queue, and removes the spill file
+func (eq *eventQueue) close() {
+	eq.lock.Lock()
+	defer eq.lock.Unlock()
+	eq.closed = true
+	eq.spill.remove()
+	eq.cond.Broadcast()
+}
+
+func (eq *eventQueue) depth() int {
+	eq.lock.Lock()
+	defer eq.lock.Unlock()
+	return eq.urgent.Len() + eq.events.Len() + eq.spill.pending()
+}
+
+func (eq *eventQueue) dropped() uint64 {
+	eq.lock.Lock()
+	defer eq.lock.Unlock()
+	return eq.droppedCount
+}
+
+func (eq *eventQueue) spilled() uint64 {
+	eq.lock.Lock()
+	defer eq.lock.Unlock()
+	return eq.spilledCount
+}
+
+// retract removes the queued events equal to event, such as the event of a
+// timer which was stopped after it fired, returning whether it removed any
+func (eq *eventQueue) retract(event interface{}) bool {
+	if event == nil || !reflect.TypeOf(event).Comparable() {
+		return false
+	}
+
+	eq.lock.Lock()
+	defer eq.lock.Unlock()
+
+	removed := false
+	for _, lane := range []*list.List{eq.urgent, eq.events} {
+		for e := lane.Front(); e != nil; {
+			next := e.Next()
+			if e.Value == event {
+				lane.Remove(e)
+				removed = true
+			}
+			e = next
+		}
+	}
+	if removed {
+		eq.cond.Broadcast()
+	}
+	return removed
+}
+
+// dropOldestRequest removes the oldest queued client request, returning false if none is queued
+func (eq *eventQueue) dropOldestRequest() bool {
+	for e := eq.events.Front(); e != nil; e = e.Next() {
+		if isClientRequest(e.Value) {
+			eq.events.Remove(e)
+			eq.droppedCount++
+			logger.Warning("Event queue full, dropping the oldest queued client request")
+			return true
+		}
+	}
+	return false
+}
+
+// spillEvent writes event to the spill file, returning false if it cannot be spilled
+func (eq *eventQueue) spillEvent(event interface{}) bool {
+	if eq.spill == nil {
+		spill, err := newSpillFile(eq.config.spillDir)
+		if err != nil {
+			logger.Error("Cannot create event queue spill file: %s", err)
+			return false
+		}
+		eq.spill = spill
+	}
+	if err := eq.spill.write(event); err != nil {
+		logger.Error("Cannot spill client request to disk: %s", err)
+		return false
+	}
+	eq.spilledCount++
+	return true
+}
+
+// unspill moves the oldest spilled client request back into the queue if there is room, returning whether it did
+func (eq *eventQueue) unspill() bool {
+	if eq.spill.pending() == 0 || eq.events.Len() >= eq.config.capacity {
+		return false
+	}
+	event, err := eq.spill.read()
+	if err != nil {
+		logger.Error("Cannot read spilled client request, discarding the spilled requests: %s", err)
+		eq.spill.reset()
+		return false
+	}
+	eq.events.PushBack(event)
+	return true
+}
+
+// isPriorityEvent returns whether event is critical for the progress of the
+// protocol, such as view changes, checkpoints and timers, which the event
+// thread services ahead of client requests and agreement messages
+func isPriorityEvent(event interface{}) bool {
+	switch et := event.(type) {
+	case viewChangeTimerEvent, prepareTimerEvent, commitTimerEvent, nullRequestEvent, BatchTimerEvent, gossipTimerEvent, recoveryEvent, ackTimerEvent, heartbeatTimerEvent, sessionKeyTimerEvent:
+		return true
+	case ViewChangedEvent, StateUpdatingEvent, StateUpdatedEvent:
+		return true
+	case *ViewChange, *NewView, *Checkpoint, *CheckpointRequest, *CheckpointReply, *Ack:
+		return true
+	case pbftMessageEvent:
+		return isPriorityMessage(et.msg)
+	case *pbftMessage:
+		return isPriorityMessage(et.msg)
+	case BatchMessageEvent:
+		if et.Msg == nil || et.Msg.Type != pb.Message_CONSENSUS {
+			return false
+		}
+		batchMsg := &BatchMessage{}
+		if err := proto.Unmarshal(et.Msg.Payload, batchMsg); err != nil || batchMsg.GetPbftMessage() == nil {
+			return false
+		}
+		msg := &Message{}
+		if err := proto.Unmarshal(batchMsg.GetPbftMessage(), msg); err != nil {
+			return false
+		}
+		return isPriorityMessage(msg)
+	}
+	return false
+}
+
+func isPriorityMessage(msg *Message) bool {
+	return msg.GetViewChange() != nil || msg.GetNewView() != nil || msg.GetCheckpoint() != nil ||
+		msg.GetCheckpointRequest() != nil || msg.GetCheckpointReply() != nil || msg.GetAck() != nil ||
+		msg.GetHeartbeat() != nil
+}
+
+// isClientRequest returns whether event carries a client request, which may be dropped or spilled when the queue overflows
+func isClientRequest(event interface{}) bool {
+	switch et := event.(type) {
+	case *Request:
+		return true
+	case pbftMessageEvent:
+		return et.msg.GetRequest() != nil
+	case *pbftMessage:
+		return et.msg.GetRequest() != nil
+	case BatchMessageEvent:
+		if et.Msg == nil {
+			return false
+		}
+		if et.Msg.Type == pb.Message_CHAIN_TRANSACTION {
+			return true
+		}
+		batchMsg := &BatchMessage{}
+		if err := proto.Unmarshal(et.Msg.Payload, batchMsg); err != nil {
+			return false
+		}
+		return batchMsg.GetRequest() != nil
+	}
+	return false
+}
+
+// ------------------------------------------------------------
+//
+// Spill file
+//
+// ------------------------------------------------------------
+
+// Kinds of spilled events
+const (
+	spilledRequest byte = iota
+	spilledPbftMessage
+	spilledBatchMessage
+)
+
+// spillFile holds client requests in the order they were spilled, each
+// record is the kind of the event, followed by the length prefixed sender
+// and the length prefixed marshaled message
+type spillFile struct {
+	file   *os.File
+	offset int64 // where the next record is read from
+	count  int   // records not read yet
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	file, err := ioutil.TempFile(dir, "pbft-events-")
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{file: file}, nil
+}
+
+func (sf *spillFile) pending() int {
+	if sf == nil {
+		return 0
+	}
+	return sf.count
+}
+
+func (sf *spillFile) write(event interface{}) error {
+	var kind byte
+	var sender []byte
+	var msg proto.Message
+	switch et := event.(type) {
+	case *Request:
+		kind, msg = spilledRequest, et
+	case pbftMessageEvent:
+		kind, msg = spilledPbftMessage, et.msg
+		sender = make([]byte, 8)
+		binary.BigEndian.PutUint64(sender, et.sender)
+	case *pbftMessage:
+		kind, msg = spilledPbftMessage, et.msg
+		sender = make([]byte, 8)
+		binary.BigEndian.PutUint64(sender, et.sender)
+	case BatchMessageEvent:
+		kind, msg = spilledBatchMessage, et.Msg
+		var err error
+		if sender, err = proto.Marshal(et.Sender); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot spill event of type %T", event)
+	}
+
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, 1+4+len(sender)+4+len(raw))
+	record[0] = kind
+	binary.BigEndian.PutUint32(record[1:], uint32(len(sender)))
+	copy(record[5:], sender)
+	binary.BigEndian.PutUint32(record[5+len(sender):], uint32(len(raw)))
+	copy(record[9+len(sender):], raw)
+
+	if _, err := sf.file.Seek(0, os.SEEK_END); err != nil {
+		return err
+	}
+	if _, err := sf.file.Write(record); err != nil {
+		return err
+	}
+	sf.count++
+	return nil
+}
+
+func (sf *spillFile) read() (interface{}, error) {
+	if _, err := sf.file.Seek(sf.offset, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	kind := make([]byte, 1)
+	if _, err := io.ReadFull(sf.file, kind); err != nil {
+		return nil, err
+	}
+	sender, err := readLengthPrefixed(sf.file)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := readLengthPrefixed(sf.file)
+	if err != nil {
+		return nil, err
+	}
+
+	var event interface{}
+	switch kind[0] {
+	case spilledRequest:
+		req := &Request{}
+		err = proto.Unmarshal(raw, req)
+		event = req
+	case spilledPbftMessage:
+		msg := &Message{}
+		err = proto.Unmarshal(raw, msg)
+		event = pbftMessageEvent{msg: msg, sender: binary.BigEndian.Uint64(sender)}
+	case spilledBatchMessage:
+		msg := &pb.Message{}
+		peerID := &pb.PeerID{}
+		if err = proto.Unmarshal(sender, peerID); err == nil {
+			err = proto.Unmarshal(raw, msg)
+		}
+		event = BatchMessageEvent{Msg: msg, Sender: peerID}
+	default:
+		err = fmt.Errorf("unknown spilled event kind %d", kind[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sf.offset += int64(1 + 4 + len(sender) + 4 + len(raw))
+	sf.count--
+	if sf.count == 0 {
+		sf.reset()
+	}
+	return event, nil
+}
+
+// reset discards the records of the spill file
+func (sf *spillFile) reset() {
+	sf.offset = 0
+	sf.count = 0
+	if err := sf.file.Truncate(0); err != nil {
+		logger.Warning("Cannot truncate event queue spill file: %s", err)
+	}
+}
+
+func (sf *spillFile) remove() {
+	if sf == nil {
+		return
+	}
+	sf.file.Close()
+	os.Remove(sf.file.Name())
+}
+
+func readLengthPrefixed(r io.Reader) ([]byte, error) {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(length))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
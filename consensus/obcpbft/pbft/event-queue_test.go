@@ -0,0 +1,204 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func requestEvent(n int64) pbftMessageEvent {
+	return pbftMessageEvent{msg: &Message{&Message_Request{createPbftRequestWithChainTx(n, 0)}}, sender: 0}
+}
+
+func TestEventQueueConfig(t *testing.T) {
+	config := loadConfig()
+	if qc, err := newEventQueueConfig(config); err != nil || qc != nil {
+		t.Fatalf("Expected events to be handed over directly by default, got %+v, %v", qc, err)
+	}
+
+	config.Set("general.eventqueue.capacity", 10)
+	config.Set("general.eventqueue.overflow", "discard")
+	if _, err := newEventQueueConfig(config); err == nil {
+		t.Fatalf("Expected an unknown overflow policy to be rejected")
+	}
+
+	config.Set("general.eventqueue.overflow", "drop")
+	qc, err := newEventQueueConfig(config)
+	if err != nil || qc.capacity != 10 || qc.policy != overflowDrop {
+		t.Fatalf("Expected a capacity of 10 with the drop policy, got %+v, %v", qc, err)
+	}
+}
+
+func TestEventQueueBlock(t *testing.T) {
+	eq := newEventQueue(&eventQueueConfig{capacity: 1, policy: overflowBlock})
+	defer eq.close()
+
+	eq.push(RestoredEvent{})
+	pushed := make(chan struct{})
+	go func() {
+		eq.push(requestEvent(1))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatalf("Expected the sender to block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if event, _ := eq.pop(); event != (RestoredEvent{}) {
+		t.Fatalf("Expected the execution event first, got %v", event)
+	}
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the sender to be released once the queue drained")
+	}
+}
+
+func TestEventQueueDropOldestRequest(t *testing.T) {
+	eq := newEventQueue(&eventQueueConfig{capacity: 2, policy: overflowDrop})
+	defer eq.close()
+
+	eq.push(requestEvent(1))
+	eq.push(RestoredEvent{})
+	eq.push(requestEvent(2))
+
+	if eq.dropped() != 1 {
+		t.Fatalf("Expected one dropped request, got %d", eq.dropped())
+	}
+	if event, _ := eq.pop(); event != (RestoredEvent{}) {
+		t.Fatalf("Expected the execution event to be kept, got %v", event)
+	}
+	event, _ := eq.pop()
+	if req := event.(pbftMessageEvent).msg.GetRequest(); req.Timestamp.Seconds != 2 {
+		t.Fatalf("Expected the newest request to be kept, got %v", req)
+	}
+}
+
+func TestEventQueueSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pbft-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	eq := newEventQueue(&eventQueueConfig{capacity: 1, policy: overflowSpill, spillDir: dir})
+	defer eq.close()
+
+	eq.push(requestEvent(1))
+	eq.push(requestEvent(2))
+	eq.push(BatchMessageEvent{Msg: &pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: []byte("tx")}, Sender: &pb.PeerID{Name: "vp1"}})
+	eq.push(requestEvent(3))
+
+	if eq.spilled() != 3 || eq.depth() != 4 {
+		t.Fatalf("Expected three spilled requests and four queued, got %d and %d", eq.spilled(), eq.depth())
+	}
+
+	for _, n := range []int64{1, 2} {
+		event, _ := eq.pop()
+		if req := event.(pbftMessageEvent).msg.GetRequest(); req.Timestamp.Seconds != n {
+			t.Fatalf("Expected request %d, got %v", n, req)
+		}
+	}
+	event, _ := eq.pop()
+	if bme := event.(BatchMessageEvent); string(bme.Msg.Payload) != "tx" || bme.Sender.Name != "vp1" {
+		t.Fatalf("Expected the spilled transaction of vp1, got %v", bme)
+	}
+	event, _ = eq.pop()
+	if req := event.(pbftMessageEvent).msg.GetRequest(); req.Timestamp.Seconds != 3 {
+		t.Fatalf("Expected request 3, got %v", req)
+	}
+	if eq.depth() != 0 {
+		t.Fatalf("Expected the queue to be drained, %d events left", eq.depth())
+	}
+}
+
+func TestEventQueuePriorityLane(t *testing.T) {
+	eq := newEventQueue(&eventQueueConfig{capacity: 2, policy: overflowBlock})
+	defer eq.close()
+
+	eq.push(requestEvent(1))
+	eq.push(requestEvent(2))
+
+	pushed := make(chan struct{})
+	go func() {
+		eq.push(pbftMessageEvent{msg: &Message{&Message_ViewChange{&ViewChange{View: 1, ReplicaId: 2}}}, sender: 2})
+		eq.push(viewChangeTimerEvent{})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected protocol-critical events not to wait for the queued client requests")
+	}
+
+	if event, _ := eq.pop(); event.(pbftMessageEvent).msg.GetViewChange() == nil {
+		t.Fatalf("Expected the view change first, got %v", event)
+	}
+	if event, _ := eq.pop(); event != (viewChangeTimerEvent{}) {
+		t.Fatalf("Expected the timer event second, got %v", event)
+	}
+	for _, n := range []int64{1, 2} {
+		event, _ := eq.pop()
+		if req := event.(pbftMessageEvent).msg.GetRequest(); req.Timestamp.Seconds != n {
+			t.Fatalf("Expected request %d, got %v", n, req)
+		}
+	}
+}
+
+func TestEventTimerRetractsBufferedEvent(t *testing.T) {
+	events := make(chan interface{}, 1)
+	release := make(chan struct{})
+	mr := newBufferedEventManager(&mockReceiver{
+		processEventImpl: func(event interface{}) interface{} {
+			if event == "hold" {
+				<-release
+				return nil
+			}
+			events <- event
+			return nil
+		},
+	}, &eventQueueConfig{capacity: 10, policy: overflowBlock})
+	mr.Start()
+	defer mr.halt()
+	timer := newEventTimer(mr)
+	defer timer.halt()
+
+	mr.Queue() <- "hold"
+	timer.Reset(time.Millisecond, viewChangeTimerEvent{})
+	for i := 0; mr.(queueStats).depth() == 0; i++ {
+		if i == 1000 {
+			t.Fatalf("Timed out waiting for the timer event to be buffered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	timer.Stop()
+	close(release)
+
+	select {
+	case e := <-events:
+		t.Fatalf("Expected the stopped timer's event to be discarded, received %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,457 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// EventReceiver is a consumer of events, processEvent will be called serially
+// as events arrive
+type EventReceiver interface {
+	// ProcessEvent delivers an event to the eventReceiver, if it returns non-nil, the return is the next processed event
+	ProcessEvent(e interface{}) interface{}
+}
+
+// ------------------------------------------------------------
+//
+// Threaded object
+//
+// ------------------------------------------------------------
+
+// threaded holds an exit channel to allow threads to break from a select
+type threaded struct {
+	exit chan struct{}
+}
+
+// halt tells the threaded object's thread to exit
+func (t *threaded) halt() {
+	select {
+	case <-t.exit:
+		logger.Warning("Attempted to halt a threaded object twice")
+	default:
+		close(t.exit)
+	}
+}
+
+// ------------------------------------------------------------
+//
+// Event Manager
+//
+// ------------------------------------------------------------
+
+// EventManager provides a serialized interface for submitting events to
+// an eventReceiver on the other side of the queue
+type EventManager interface {
+	Inject(interface{})        // A temporary interface to allow the event manager thread to skip the queue
+	Queue() chan<- interface{} // Get a write-only reference to the queue, to submit events
+	Start()                    // Starts the eventManager thread TODO, these thread management things should probably go away
+	halt()                     // Stops the eventManager thread
+}
+
+// eventManagerImpl is an implementation of eventManger
+type eventManagerImpl struct {
+	threaded
+	receiver EventReceiver
+	events   chan interface{}
+	buffer   *eventQueue // events waiting for the event thread, nil if they are handed over directly
+
+	instrumentation eventInstrumentation // hooks invoked as events pass through, see eventHooks
+}
+
+// newEventManager creates an instance of eventManagerImpl
+func newEventManagerImpl(er EventReceiver) EventManager {
+	return &eventManagerImpl{
+		receiver: er,
+		events:   make(chan interface{}),
+		threaded: threaded{make(chan struct{})},
+	}
+}
+
+// newBufferedEventManager creates an instance of eventManagerImpl which
+// queues up to the configured capacity of events for the event thread
+func newBufferedEventManager(er EventReceiver, config *eventQueueConfig) EventManager {
+	em := newEventManagerImpl(er).(*eventManagerImpl)
+	if config != nil {
+		em.buffer = newEventQueue(config)
+	}
+	return em
+}
+
+// Start creates the go routine necessary to deliver events
+func (em *eventManagerImpl) Start() {
+	if em.buffer != nil {
+		go em.intakeLoop()
+	}
+	if em.instrumentation.stuckAfter > 0 {
+		go em.watchLoop()
+	}
+	go em.eventLoop()
+}
+
+// halt stops the eventManager threads
+func (em *eventManagerImpl) halt() {
+	em.threaded.halt()
+	if em.buffer != nil {
+		em.buffer.close()
+	}
+}
+
+// retract discards the buffered events equal to event, see eventRetractor
+func (em *eventManagerImpl) retract(event interface{}) bool {
+	if em.buffer == nil {
+		return false
+	}
+	return em.buffer.retract(event)
+}
+
+func (em *eventManagerImpl) depth() int {
+	if em.buffer == nil {
+		return len(em.events)
+	}
+	return em.buffer.depth()
+}
+
+func (em *eventManagerImpl) dropped() uint64 {
+	if em.buffer == nil {
+		return 0
+	}
+	return em.buffer.dropped()
+}
+
+func (em *eventManagerImpl) spilled() uint64 {
+	if em.buffer == nil {
+		return 0
+	}
+	return em.buffer.spilled()
+}
+
+// Queue returns a write only reference to the event queue
+func (em *eventManagerImpl) Queue() chan<- interface{} {
+	return em.events
+}
+
+// sendEvent performs the event loop on a receiver to completion
+func sendEvent(receiver EventReceiver, event interface{}) {
+	next := event
+	for {
+		// If an event returns something non-nil, then process it as a new event
+		next = receiver.ProcessEvent(next)
+		if next == nil {
+			break
+		}
+	}
+}
+
+// Inject can only safely be called by the eventManager thread itself, it skips the queue
+func (em *eventManagerImpl) Inject(event interface{}) {
+	sendEvent(em.receiver, event)
+}
+
+// intakeLoop moves the submitted events into the buffer
+func (em *eventManagerImpl) intakeLoop() {
+	for {
+		select {
+		case next := <-em.events:
+			em.buffer.push(next)
+			em.enqueued(next)
+		case <-em.exit:
+			return
+		}
+	}
+}
+
+// eventLoop is where the event thread loops, delivering events
+func (em *eventManagerImpl) eventLoop() {
+	if em.buffer != nil {
+		for {
+			next, ok := em.buffer.pop()
+			if !ok {
+				logger.Debug("eventLoop told to exit")
+				return
+			}
+			em.deliver(next)
+		}
+	}
+
+	for {
+		select {
+		case next := <-em.events:
+			em.enqueued(next)
+			em.deliver(next)
+		case <-em.exit:
+			logger.Debug("eventLoop told to exit")
+			return
+		}
+	}
+}
+
+// ------------------------------------------------------------
+//
+// Event Timer
+//
+// ------------------------------------------------------------
+
+// EventTimer is an interface for managing time driven events
+// the special contract eventTimer gives which a traditional golang
+// timer does not, is that if the event thread calls stop, or reset
+// then even if the timer has already fired, the event will not be
+// delivered to the event queue
+type EventTimer interface {
+	SoftReset(duration time.Duration, event interface{}) // start a new countdown, only if one is not already started
+	Reset(duration time.Duration, event interface{})     // start a new countdown, clear any pending events
+	Stop()                                               // stop the countdown, clear any pending events
+	halt()                                               // Stops the eventTimer thread
+}
+
+// eventTimerFactory abstracts the creation of eventTimers, as they may
+// need to be mocked for testing
+type eventTimerFactory interface {
+	createTimer() EventTimer // Creates an eventTimer which is stopped
+}
+
+// eventTimerFactoryImpl implements the eventTimerFactory
+type eventTimerFactoryImpl struct {
+	manager EventManager // The eventManager to use in constructing the event timers
+	clock   Clock        // The clock the event timers count down on
+}
+
+// newEventTimerFactoryImpl creates a new eventTimerFactory for the given eventManager
+func newEventTimerFactoryImpl(manager EventManager) eventTimerFactory {
+	return newClockedEventTimerFactory(manager, wallClock{})
+}
+
+// schedulingClock may be implemented by a clock which runs the timers itself
+// instead of on a thread each, so that a simulation decides when they fire
+type schedulingClock interface {
+	Clock
+	timerFactory(manager EventManager) eventTimerFactory
+}
+
+// newClockedEventTimerFactory creates a new eventTimerFactory for the given eventManager, whose timers count down on clk
+func newClockedEventTimerFactory(manager EventManager, clk Clock) eventTimerFactory {
+	if sc, ok := clk.(schedulingClock); ok {
+		return sc.timerFactory(manager)
+	}
+	return &eventTimerFactoryImpl{manager, clk}
+}
+
+// createTimer creates a new timer which deliver events to the eventManager for this factory
+func (etf *eventTimerFactoryImpl) createTimer() EventTimer {
+	return newClockedEventTimer(etf.manager, etf.clock)
+}
+
+// timerStart is used to deliver the start request to the eventTimer thread
+type timerStart struct {
+	hard     bool          // Whether to reset the timer if it is running
+	event    interface{}   // What event to push onto the event queue
+	duration time.Duration // How long to wait before sending the event
+}
+
+// eventRetractor may be implemented by an eventManager which buffers events,
+// so that a timer which is stopped or reset after it fired can discard its
+// event before the event thread gets to it
+type eventRetractor interface {
+	retract(event interface{}) bool
+}
+
+// eventTimerImpl is an implementation of eventTimer
+type eventTimerImpl struct {
+	threaded                   // Gives us the exit chan
+	timerChan <-chan time.Time // When non-nil, counts down to preparing to do the event
+	startChan chan *timerStart // Channel to deliver the timer start events to the service go routine
+	stopChan  chan struct{}    // Channel to deliver the timer stop events to the service go routine
+	manager   EventManager     // The event manager to deliver the event to after timer expiration
+	clock     Clock            // The clock the timer counts down on
+}
+
+// newEventTimer creates a new instance of eventTimerImpl
+func newEventTimer(manager EventManager) EventTimer {
+	return newClockedEventTimer(manager, wallClock{})
+}
+
+// newClockedEventTimer creates a new instance of eventTimerImpl which counts down on clk
+func newClockedEventTimer(manager EventManager, clk Clock) EventTimer {
+	et := &eventTimerImpl{
+		startChan: make(chan *timerStart),
+		stopChan:  make(chan struct{}),
+		threaded:  threaded{make(chan struct{})},
+		manager:   manager,
+		clock:     clk,
+	}
+	go et.loop()
+	return et
+}
+
+// SoftReset tells the timer to start a new countdown, only if it is not currently counting down
+// this will not clear any pending events
+func (et *eventTimerImpl) SoftReset(timeout time.Duration, event interface{}) {
+	et.startChan <- &timerStart{
+		duration: timeout,
+		event:    event,
+		hard:     true,
+	}
+}
+
+// Reset tells the timer to start counting down from a new timeout, this also clears any pending events
+func (et *eventTimerImpl) Reset(timeout time.Duration, event interface{}) {
+	et.startChan <- &timerStart{
+		duration: timeout,
+		event:    event,
+		hard:     false,
+	}
+}
+
+// Stop tells the timer to stop, and not to deliver any pending events
+func (et *eventTimerImpl) Stop() {
+	et.stopChan <- struct{}{}
+}
+
+// retract discards a delivered event the manager has not handed to the event thread yet
+func (et *eventTimerImpl) retract(event interface{}) {
+	if event == nil {
+		return
+	}
+	if r, ok := et.manager.(eventRetractor); ok && r.retract(event) {
+		logger.Debug("Timer retracted delivered event")
+	}
+}
+
+// loop is where the timer thread lives, looping
+func (et *eventTimerImpl) loop() {
+	var eventDestChan chan<- interface{}
+	var event interface{}
+	var delivered interface{} // last event delivered, which may still be buffered by the manager
+
+	for {
+		// A little state machine, relying on the fact that nil channels will block on read/write indefinitely
+
+		select {
+		case start := <-et.startChan:
+			if et.timerChan != nil {
+				if start.hard {
+					logger.Debug("Resetting a running timer")
+				} else {
+					continue
+				}
+			}
+			logger.Debug("Starting timer")
+			et.timerChan = et.clock.After(start.duration)
+			if eventDestChan != nil {
+				logger.Debug("Timer cleared pending event")
+			}
+			event = start.event
+			eventDestChan = nil
+			et.retract(delivered)
+			delivered = nil
+		case <-et.stopChan:
+			if et.timerChan == nil && eventDestChan == nil {
+				logger.Warning("Attempting to stop an unfired idle timer")
+			}
+			et.timerChan = nil
+			logger.Debug("Stopping timer for")
+			if eventDestChan != nil {
+				logger.Debug("Timer for cleared pending event")
+			}
+			eventDestChan = nil
+			event = nil
+			et.retract(delivered)
+			delivered = nil
+		case <-et.timerChan:
+			logger.Debug("Event timer fired")
+			et.timerChan = nil
+			eventDestChan = et.manager.Queue()
+		case eventDestChan <- event:
+			logger.Debug("Timer event delivered")
+			eventDestChan = nil
+			delivered = event
+		case <-et.exit:
+			logger.Debug("Halting timer")
+			return
+		}
+	}
+}
+
+// ------------------------------------------------------------
+//
+// Event Types
+//
+// ------------------------------------------------------------
+
+// WorkEvent is a temporary type, to inject work
+type WorkEvent func()
+
+// viewChangeTimerEvent is sent when the view change timer expires
+type viewChangeTimerEvent struct{}
+
+// prepareTimerEvent is sent when a sequence number stayed pre-prepared for
+// longer than the prepare timeout
+type prepareTimerEvent struct{}
+
+// commitTimerEvent is sent when a sequence number stayed prepared for
+// longer than the commit timeout
+type commitTimerEvent struct{}
+
+// execDoneEvent is sent when the execution of seqNo completes, state is the
+// state it resulted in, nil if the consumer did not report it
+type execDoneEvent struct {
+	seqNo uint64
+	state []byte
+}
+
+// queryExecutedEvent is sent when the query pool executed the read-only
+// request req, err is the error the consumer returned instead of a result
+type queryExecutedEvent struct {
+	req    *Request
+	digest string
+	result []byte
+	err    error
+}
+
+// StateUpdatedEvent is sent when state transfer completes
+type StateUpdatedEvent CheckpointMessage
+
+// StateUpdatingEvent is sent when state transfer is initiated
+type StateUpdatingEvent CheckpointMessage
+
+// pbftMessageEvent is sent when a consensus messages is received to be sent to pbft
+type pbftMessageEvent pbftMessage
+
+// BatchMessageEvent is sent when a consensus message is received from a peer
+// of the network, to be processed by the consumer of the replica
+type BatchMessageEvent struct {
+	Msg    *pb.Message
+	Sender *pb.PeerID
+}
+
+// BatchTimerEvent is sent when the batch timer of the consumer expires
+type BatchTimerEvent struct{}
+
+// ViewChangedEvent is sent once the replica has moved to a new view
+type ViewChangedEvent struct{}
+
+// returnRequestEvent is sent by pbft when we are forwarded a request
+type returnRequestEvent *Request
+
+// nullRequestEvent provides "keep-alive" null requests
+type nullRequestEvent struct{}
+
+// RestoredEvent is sent once the event thread is running after the state
+// was restored, so that restored outstanding requests are proposed again
+type RestoredEvent struct{}
@@ -14,7 +14,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package obcpbft
+package pbft
 
 import (
 	"testing"
@@ -28,14 +28,14 @@ type mockReceiver struct {
 	processEventImpl func(event interface{}) interface{}
 }
 
-func (mr *mockReceiver) processEvent(event interface{}) interface{} {
+func (mr *mockReceiver) ProcessEvent(event interface{}) interface{} {
 	if mr.processEventImpl != nil {
 		return mr.processEventImpl(event)
 	}
 	return nil
 }
 
-func newMockManager(processEvent func(event interface{}) interface{}) eventManager {
+func newMockManager(processEvent func(event interface{}) interface{}) EventManager {
 	return newEventManagerImpl(&mockReceiver{
 		processEventImpl: processEvent,
 	})
@@ -48,12 +48,12 @@ func TestEventTimerStart(t *testing.T) {
 		events <- event
 		return nil
 	})
-	mr.start()
+	mr.Start()
 	defer mr.halt()
 	timer := newEventTimer(mr)
 	defer timer.halt()
 	me := &mockEvent{}
-	timer.reset(time.Millisecond, me)
+	timer.Reset(time.Millisecond, me)
 
 	select {
 	case e := <-events:
@@ -76,10 +76,10 @@ func TestEventTimerHardReset(t *testing.T) {
 	defer timer.halt()
 	me1 := &mockEvent{}
 	me2 := &mockEvent{}
-	timer.reset(time.Millisecond, me1)
-	timer.reset(time.Millisecond, me2)
+	timer.Reset(time.Millisecond, me1)
+	timer.Reset(time.Millisecond, me2)
 
-	mr.start()
+	mr.Start()
 	defer mr.halt()
 
 	select {
@@ -103,10 +103,10 @@ func TestEventTimerSoftReset(t *testing.T) {
 	defer timer.halt()
 	me1 := &mockEvent{}
 	me2 := &mockEvent{}
-	timer.softReset(time.Millisecond, me1)
-	timer.softReset(time.Millisecond, me2)
+	timer.SoftReset(time.Millisecond, me1)
+	timer.SoftReset(time.Millisecond, me2)
 
-	mr.start()
+	mr.Start()
 	defer mr.halt()
 
 	select {
@@ -129,11 +129,11 @@ func TestEventTimerStop(t *testing.T) {
 	timer := newEventTimer(mr)
 	defer timer.halt()
 	me := &mockEvent{}
-	timer.reset(time.Millisecond, me)
+	timer.Reset(time.Millisecond, me)
 	time.Sleep(100 * time.Millisecond) // Allow the timer to fire
-	timer.stop()
+	timer.Stop()
 
-	mr.start()
+	mr.Start()
 	defer mr.halt()
 
 	select {
@@ -155,10 +155,10 @@ func TestEventManagerLoop(t *testing.T) {
 		success <- struct{}{}
 		return nil
 	})
-	mr.start()
+	mr.Start()
 	defer mr.halt()
 
-	mr.queue() <- &mockEvent{}
+	mr.Queue() <- &mockEvent{}
 
 	select {
 	case <-success:
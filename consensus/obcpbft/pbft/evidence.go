@@ -0,0 +1,155 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	google_protobuf "google/protobuf"
+)
+
+// Faults of other replicas we can prove with the messages they sent
+const (
+	faultConflictingPrePrepare = "conflicting-pre-prepare" // the primary pre-prepared two requests for the same view and sequence number
+	faultBogusCheckpoint       = "bogus-checkpoint"        // a checkpoint which contradicts a stable checkpoint
+	faultForgedSender          = "forged-sender"           // a message claiming to originate from another replica
+)
+
+const evidencePrefix = "evidence."
+
+// faultID identifies the evidence recorded against a replica, a fault is
+// only recorded once per view and sequence number
+type faultID struct {
+	accused uint64
+	fault   string
+	view    uint64
+	n       uint64
+}
+
+func (fid faultID) key() string {
+	return fmt.Sprintf("%s%d.%s.%d.%d", evidencePrefix, fid.accused, fid.fault, fid.view, fid.n)
+}
+
+// recordEvidence signs an evidence bundle for a fault of the accused replica,
+// persists it, and reports it to the consumer
+func (instance *PbftCore) recordEvidence(fault string, accused uint64, v uint64, n uint64, description string, msgs ...*Message) {
+	fid := faultID{accused, fault, v, n}
+	if instance.reportedFaults[fid] {
+		return
+	}
+	instance.reportedFaults[fid] = true
+
+	instance.log.Error("Detected a provable fault of replica %d (%s): %s", accused, fault, description)
+
+	now := instance.Clock.Now()
+	ev := &Evidence{
+		ReplicaId:      instance.ID,
+		Accused:        accused,
+		Fault:          fault,
+		Description:    description,
+		View:           v,
+		SequenceNumber: n,
+		Messages:       msgs,
+		Timestamp: &google_protobuf.Timestamp{
+			Seconds: now.Unix(),
+			Nanos:   int32(now.UnixNano() % 1000000000),
+		},
+	}
+	if err := instance.Sign(ev); err != nil {
+		instance.log.Error("Could not sign evidence against replica %d: %s", accused, err)
+		return
+	}
+	raw, err := proto.Marshal(ev)
+	if err != nil {
+		instance.log.Error("Could not marshal evidence against replica %d: %s", accused, err)
+		return
+	}
+	if err := instance.persistor.StoreState(fid.key(), raw); err != nil {
+		instance.log.Error("Could not persist evidence against replica %d: %s", accused, err)
+	}
+	instance.Metrics.evidence.Inc()
+	instance.consumer.ReportEvidence(ev)
+}
+
+// forgedSender records evidence against a replica which sent a message
+// claiming to originate from another replica, and returns the error
+// rejecting the message
+func (instance *PbftCore) forgedSender(msg *Message, kind string, claimedID uint64, senderID uint64) error {
+	err := fmt.Errorf("Sender ID included in %s message (%v) doesn't match ID corresponding to the receiving stream (%v)", kind, claimedID, senderID)
+	instance.recordEvidence(faultForgedSender, senderID, instance.View, 0, err.Error(), msg)
+	return err
+}
+
+// reportBogusCheckpoints records evidence against the replicas whose
+// checkpoint for the sequence number of the stable checkpoint chkpt differs
+func (instance *PbftCore) reportBogusCheckpoints(chkpt *Checkpoint) {
+	var quorum, bogus []*Message
+	for testChkpt := range instance.checkpointStore {
+		if testChkpt.SequenceNumber != chkpt.SequenceNumber {
+			continue
+		}
+		c := testChkpt
+		if c.Id == chkpt.Id {
+			quorum = append(quorum, &Message{&Message_Checkpoint{&c}})
+		} else {
+			bogus = append(bogus, &Message{&Message_Checkpoint{&c}})
+		}
+	}
+	for _, msg := range bogus {
+		c := msg.GetCheckpoint()
+		instance.recordEvidence(faultBogusCheckpoint, c.ReplicaId, instance.View, c.SequenceNumber,
+			fmt.Sprintf("checkpoint for seqNo %d has digest %s, stable checkpoint has digest %s", c.SequenceNumber, c.Id, chkpt.Id),
+			append([]*Message{msg}, quorum...)...)
+	}
+}
+
+// garbageCollectFaults forgets which faults were recorded below the low
+// watermark, forged senders are recorded at most once per checkpoint period
+func (instance *PbftCore) garbageCollectFaults() {
+	for fid := range instance.reportedFaults {
+		if fid.n < instance.h {
+			delete(instance.reportedFaults, fid)
+		}
+	}
+}
+
+// readEvidence returns the evidence persisted by this replica, ordered by
+// accused replica and fault
+func (instance *PbftCore) readEvidence() ([]*Evidence, error) {
+	raw, err := instance.persistor.ReadStateSet(evidencePrefix)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var evidence []*Evidence
+	for _, key := range keys {
+		ev := &Evidence{}
+		if err := proto.Unmarshal(raw[key], ev); err != nil {
+			return nil, fmt.Errorf("Cannot unmarshal evidence %s: %s", strings.TrimPrefix(key, evidencePrefix), err)
+		}
+		evidence = append(evidence, ev)
+	}
+	return evidence, nil
+}
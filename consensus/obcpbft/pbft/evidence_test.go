@@ -0,0 +1,147 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newEvidenceTestStack(reported *[]*Evidence) *omniProto {
+	persist := &mockPersist{}
+	return &omniProto{
+		ReadStateImpl:    persist.ReadState,
+		ReadStateSetImpl: persist.ReadStateSet,
+		StoreStateImpl:   persist.StoreState,
+		DelStateImpl:     persist.DelState,
+		broadcastImpl:    func(msgPayload []byte) {},
+		validateImpl: func(txRaw []byte) error {
+			return nil
+		},
+		viewChangeImpl: func(curView uint64) {},
+		signImpl: func(msg []byte) ([]byte, error) {
+			return msg, nil
+		},
+		verifyImpl: func(senderID uint64, signature []byte, message []byte) error {
+			if !bytes.Equal(signature, message) {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		},
+		reportEvidenceImpl: func(ev *Evidence) {
+			*reported = append(*reported, ev)
+		},
+	}
+}
+
+func TestConflictingPrePrepareEvidence(t *testing.T) {
+	var reported []*Evidence
+	stack := newEvidenceTestStack(&reported)
+	instance := newPbftCore(1, loadConfig(), stack)
+	defer instance.Close()
+
+	for i := int64(1); i <= 3; i++ {
+		req := createPbftRequestWithChainTx(i, 0)
+		sendEvent(instance, pbftMessageEvent{
+			msg: &Message{&Message_PrePrepare{&PrePrepare{
+				View:           0,
+				SequenceNumber: 1,
+				RequestDigest:  HashReq(instance.Digest, req),
+				Request:        req,
+				ReplicaId:      0,
+			}}},
+			sender: 0,
+		})
+	}
+
+	if len(reported) != 1 {
+		t.Fatalf("Expected a single report of the conflicting pre-prepares, got %d", len(reported))
+	}
+	ev := reported[0]
+	if ev.Fault != faultConflictingPrePrepare || ev.Accused != 0 || ev.ReplicaId != 1 || ev.SequenceNumber != 1 || len(ev.Messages) != 2 {
+		t.Errorf("Unexpected evidence: %v", ev)
+	}
+	if err := verifySignature(stack, ev); err != nil {
+		t.Errorf("Expected the evidence to be signed by the reporting replica: %s", err)
+	}
+
+	stored, err := instance.readEvidence()
+	if err != nil {
+		t.Fatalf("Could not read evidence: %s", err)
+	}
+	if len(stored) != 1 || !bytes.Equal(stored[0].Signature, ev.Signature) {
+		t.Errorf("Expected the reported evidence to be persisted, got %v", stored)
+	}
+}
+
+func TestForgedSenderEvidence(t *testing.T) {
+	var reported []*Evidence
+	clk := newVirtualClock(time.Unix(1000, 0))
+	instance := newPbftCoreWithClock(1, loadConfig(), newEvidenceTestStack(&reported), clk)
+	defer instance.Close()
+
+	forged := &Message{&Message_Commit{&Commit{View: 0, SequenceNumber: 1, ReplicaId: 2}}}
+	for i := 0; i < 2; i++ {
+		if _, err := instance.recvMsg(forged, 3); err == nil {
+			t.Fatalf("Expected a commit claiming to originate from another replica to be rejected")
+		}
+	}
+	if len(reported) != 1 || reported[0].Fault != faultForgedSender || reported[0].Accused != 3 {
+		t.Fatalf("Expected a single report against replica 3, got %v", reported)
+	}
+	if commit := reported[0].Messages[0].GetCommit(); commit == nil || commit.ReplicaId != 2 {
+		t.Errorf("Expected the forged commit to be part of the evidence, got %v", reported[0].Messages)
+	}
+	if ts := reported[0].Timestamp; ts.Seconds != 1000 || ts.Nanos != 0 {
+		t.Errorf("Expected the evidence to be timestamped on the clock of the replica, got %v", ts)
+	}
+
+	if _, err := instance.recvMsg(forged, 2); err != nil {
+		t.Errorf("Expected a commit from its sender to be accepted: %s", err)
+	}
+	if len(reported) != 1 {
+		t.Errorf("Expected no evidence for a well-formed message")
+	}
+}
+
+func TestBogusCheckpointEvidence(t *testing.T) {
+	var reported []*Evidence
+	config := loadConfig()
+	config.Set("general.K", 2)
+	instance := newPbftCore(1, config, newEvidenceTestStack(&reported))
+	defer instance.Close()
+	instance.chkpts[2] = "good"
+
+	sendEvent(instance, &Checkpoint{SequenceNumber: 2, ReplicaId: 3, Id: "bogus"})
+	for _, id := range []uint64{0, 1, 2} {
+		sendEvent(instance, &Checkpoint{SequenceNumber: 2, ReplicaId: id, Id: "good"})
+	}
+	if instance.h != 2 {
+		t.Fatalf("Expected the checkpoint to become stable, low watermark is %d", instance.h)
+	}
+	if len(reported) != 1 || reported[0].Fault != faultBogusCheckpoint || reported[0].Accused != 3 || len(reported[0].Messages) != 4 {
+		t.Fatalf("Expected a report against replica 3 with the bogus checkpoint and the quorum, got %v", reported)
+	}
+
+	// A contradicting checkpoint arriving after the checkpoint became stable
+	sendEvent(instance, &Checkpoint{SequenceNumber: 2, ReplicaId: 0, Id: "bogus"})
+	if len(reported) != 2 || reported[1].Accused != 0 {
+		t.Errorf("Expected a report against replica 0, got %v", reported)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"sync"
+	"time"
+)
+
+// execJob is a committed request waiting to be executed
+type execJob struct {
+	seqNo uint64
+	txRaw []byte
+	done  ExecCallback
+}
+
+// execQueue hands committed requests to the consumer on a goroutine of its
+// own, one at a time and in the order they were submitted. The consumer
+// reports the end of each execution through the callback of the job, which
+// reaches the event thread as an execDoneEvent, so that a slow execution
+// never holds up the processing of prepares, commits and checkpoints for
+// later sequence numbers. The consumer may return before the execution
+// completes, e.g. to hand it to a remote executor
+type execQueue struct {
+	threaded
+	consumer InnerStack
+	jobs     chan execJob
+
+	lock    sync.Mutex
+	running *execJob  // the job handed to the consumer, nil if idle
+	since   time.Time // when the running job was handed to the consumer
+}
+
+func newExecQueue(consumer InnerStack) *execQueue {
+	eq := &execQueue{
+		threaded: threaded{make(chan struct{})},
+		consumer: consumer,
+		jobs:     make(chan execJob, 1), // the pbft core has at most one execution outstanding
+	}
+	go eq.run()
+	return eq
+}
+
+// submit queues a request for execution, it does not wait for the execution
+func (eq *execQueue) submit(seqNo uint64, txRaw []byte, done ExecCallback) {
+	select {
+	case eq.jobs <- execJob{seqNo: seqNo, txRaw: txRaw, done: done}:
+	case <-eq.exit:
+	}
+}
+
+func (eq *execQueue) run() {
+	for {
+		select {
+		case job := <-eq.jobs:
+			eq.setRunning(&job)
+			eq.consumer.Execute(job.seqNo, job.txRaw, job.done)
+			eq.setRunning(nil)
+		case <-eq.exit:
+			return
+		}
+	}
+}
+
+func (eq *execQueue) setRunning(job *execJob) {
+	eq.lock.Lock()
+	defer eq.lock.Unlock()
+	eq.running, eq.since = job, time.Now()
+}
+
+// executing returns the sequence number of the job the consumer is handed,
+// and for how long it has been, ok is false if the queue is idle
+func (eq *execQueue) executing() (seqNo uint64, elapsed time.Duration, ok bool) {
+	eq.lock.Lock()
+	defer eq.lock.Unlock()
+	if eq.running == nil {
+		return 0, 0, false
+	}
+	return eq.running.seqNo, time.Since(eq.since), true
+}
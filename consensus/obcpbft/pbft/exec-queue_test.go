@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExecQueueOrder(t *testing.T) {
+	executed := make(chan uint64, 3)
+	eq := newExecQueue(&omniProto{
+		executeImpl: func(seqNo uint64, txRaw []byte, done ExecCallback) {
+			executed <- seqNo
+		},
+	})
+	defer eq.halt()
+
+	for n := uint64(1); n <= 3; n++ {
+		eq.submit(n, nil, nil)
+	}
+	var order []uint64
+	for i := 0; i < 3; i++ {
+		select {
+		case n := <-executed:
+			order = append(order, n)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for executions, executed %v", order)
+		}
+	}
+	if !reflect.DeepEqual(order, []uint64{1, 2, 3}) {
+		t.Errorf("Expected executions in submission order, got %v", order)
+	}
+}
+
+func TestExecCompletionCarriesState(t *testing.T) {
+	persist := &mockPersist{}
+	instance := newPbftCore(1, loadConfig(), &omniProto{
+		broadcastImpl:  func(msgPayload []byte) {},
+		signImpl:       func(msg []byte) ([]byte, error) { return msg, nil },
+		StoreStateImpl: persist.StoreState,
+		DelStateImpl:   persist.DelState,
+		getStateImpl: func() []byte {
+			t.Errorf("Expected the reported state to be checkpointed, rather than reading it")
+			return nil
+		},
+	})
+	defer instance.Close()
+
+	n := instance.K
+	instance.CurrentExec = &n
+	instance.ProcessEvent(execDoneEvent{seqNo: n + 1, state: []byte("stale")})
+	if instance.CurrentExec == nil || instance.LastExec != 0 {
+		t.Fatalf("Expected the completion of an execution which is not outstanding to be ignored")
+	}
+
+	instance.ProcessEvent(execDoneEvent{seqNo: n, state: []byte("reported")})
+	if instance.CurrentExec != nil || instance.LastExec != n {
+		t.Fatalf("Expected execution %d to complete, lastExec is %d", n, instance.LastExec)
+	}
+	if id := instance.chkpts[n]; id != base64.StdEncoding.EncodeToString([]byte("reported")) {
+		t.Errorf("Expected checkpoint %d of the reported state, got %s", n, id)
+	}
+}
+
+// queueingManager collects the events queued to it, without processing them
+type queueingManager struct {
+	events chan interface{}
+}
+
+func (qm *queueingManager) Inject(event interface{})  {}
+func (qm *queueingManager) Queue() chan<- interface{} { return qm.events }
+func (qm *queueingManager) Start()                    {}
+func (qm *queueingManager) halt()                     {}
+
+func TestExecCompletionOnce(t *testing.T) {
+	instance := newPbftCore(1, loadConfig(), &omniProto{})
+	defer instance.Close()
+	events := make(chan interface{}, 2)
+	manager := instance.Manager
+	instance.Manager = &queueingManager{events}
+	defer func() { instance.Manager = manager }()
+
+	done := instance.ExecCompletion(1)
+	done([]byte("state"))
+	done([]byte("again"))
+	if len(events) != 1 {
+		t.Fatalf("Expected a single completion event, got %d", len(events))
+	}
+	if ev := (<-events).(execDoneEvent); ev.seqNo != 1 || string(ev.state) != "state" {
+		t.Errorf("Unexpected completion event %+v", ev)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+// maybeFetchRequest checks whether we hold a prepare certificate for
+// view=v/seqNo=n without having received its pre-prepare. The 2f matching
+// prepares of distinct backups show that at least f+1 correct backups
+// accepted a pre-prepare for the digest from the primary, so we adopt that
+// pre-prepare once we hold the request, fetching the request from the other
+// replicas by its digest, rather than waiting for a view change or state transfer
+func (instance *PbftCore) maybeFetchRequest(digest string, v uint64, n uint64) error {
+	cert := instance.certStore[msgID{v, n}]
+	if cert == nil || cert.prePrepare != nil || !instance.ActiveView || v != instance.View {
+		return nil
+	}
+	if instance.executedReqs.contains(digest) {
+		return nil
+	}
+
+	quorum := 0
+	for _, p := range cert.prepare {
+		if p.View == v && p.SequenceNumber == n && p.RequestDigest == digest {
+			quorum++
+		}
+	}
+	if quorum < instance.intersectionQuorum()-1 {
+		return nil
+	}
+
+	if _, ok := instance.reqStore[digest]; ok || digest == "" {
+		return instance.adoptPrePrepare(digest, v, n)
+	}
+	if _, ok := instance.lostReqs[digest]; ok {
+		return nil
+	}
+
+	instance.log.Info("Holding a prepare certificate for view=%d/seqNo=%d but missed its pre-prepare, fetching request %s",
+		v, n, digest)
+	instance.lostReqs[digest] = msgID{v, n}
+	return instance.innerBroadcast(&Message{&Message_FetchRequest{&FetchRequest{
+		RequestDigest: digest,
+		ReplicaId:     instance.ID,
+	}}})
+}
+
+// recvLostRequest adopts the pre-prepare of a prepare certificate once
+// the request we fetched for it arrived
+func (instance *PbftCore) recvLostRequest(digest string, req *Request, idx msgID) error {
+	if idx.v != instance.View || !instance.inW(idx.n) || !instance.ActiveView {
+		instance.log.Debug("Received request %s for view=%d/seqNo=%d, which is no longer current", digest, idx.v, idx.n)
+		return nil
+	}
+	if err := instance.consumer.ValidateRequest(req.Payload); err != nil {
+		instance.log.Warning("Request %s did not verify: %s", digest, err)
+		return err
+	}
+	return instance.adoptPrePrepare(digest, idx.v, idx.n)
+}
+
+// adoptPrePrepare installs the pre-prepare the primary sent for
+// view=v/seqNo=n, and resumes the agreement on it
+func (instance *PbftCore) adoptPrePrepare(digest string, v uint64, n uint64) error {
+	delete(instance.lostReqs, digest)
+
+	preprep := &PrePrepare{
+		View:            v,
+		SequenceNumber:  n,
+		RequestDigest:   digest,
+		Request:         instance.reqStore[digest],
+		ReplicaId:       instance.Primary(v),
+		DigestAlgorithm: instance.Digest.name(),
+		Epoch:           instance.epoch,
+	}
+	cert := instance.getCert(v, n)
+	cert.prePrepare = preprep
+	cert.digest = digest
+	instance.persistPrePrepare(preprep)
+
+	if digest != "" {
+		instance.OutstandingReqs[digest] = preprep.Request
+		instance.AdaptiveTimeout.requestArrived(digest)
+	}
+
+	if err := instance.maybeSendPrepare(preprep); err != nil {
+		return err
+	}
+	if err := instance.maybeSendCommit(digest, v, n); err != nil {
+		return err
+	}
+	if instance.committed(digest, v, n) {
+		// the other replicas agreed while we were waiting for the request
+		instance.recvCommitCert(digest, n)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestFetchRequestOfLostPrePrepare(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.Stop()
+
+	var fetched, viewChanges int32
+	net.FilterFn = func(src int, dst int, msg []byte) []byte {
+		pm := &Message{}
+		if err := proto.Unmarshal(msg, pm); err != nil {
+			t.Fatal(err)
+		}
+		if pm.GetPrePrepare() != nil && dst == 3 {
+			return nil
+		}
+		if fr := pm.GetFetchRequest(); fr != nil && src == 3 {
+			atomic.AddInt32(&fetched, 1)
+		}
+		if pm.GetViewChange() != nil {
+			atomic.AddInt32(&viewChanges, 1)
+		}
+		return msg
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(i, uint64(generateBroadcaster(validatorCount)))
+	}
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	if fetched == 0 {
+		t.Errorf("Expected replica 3 to fetch the requests whose pre-prepares it missed")
+	}
+	if viewChanges != 0 {
+		t.Errorf("Expected the requests to commit without a view change, saw %d view-change messages", viewChanges)
+	}
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 3 {
+			t.Errorf("Instance %d executed %d requests, expected 3", pep.ID, pep.sc.executions)
+		}
+	}
+	if p := net.pbftEndpoints[3].pbft; len(p.lostReqs) != 0 {
+		t.Errorf("Expected no requests to remain lost, got %v", p.lostReqs)
+	}
+}
@@ -0,0 +1,214 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+// The fuzz targets need the native fuzzing of go 1.18 or later. Without
+// -fuzz, go test only runs them on their seed corpus, to explore run e.g.
+//
+//	go test -run none -fuzz FuzzRecvMsg ./consensus/obcpbft/pbft
+//
+// The replicas run in a simulation, so every input is reproduced exactly
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/gofuzz"
+	"github.com/op/go-logging"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// fuzzSeedMessages returns well formed messages of the normal case and the
+// view change protocol, for the fuzzer to mutate
+func fuzzSeedMessages() []*Message {
+	digest, _ := newDigestProvider(loadConfig().GetString("general.digest"))
+	req := createPbftRequestWithChainTx(1, 2)
+	d := HashReq(digest, req)
+	return []*Message{
+		{&Message_Request{req}},
+		{&Message_PrePrepare{&PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: d, Request: req, ReplicaId: 0, DigestAlgorithm: digest.name()}}},
+		{&Message_Prepare{&Prepare{View: 0, SequenceNumber: 1, RequestDigest: d, ReplicaId: 2}}},
+		{&Message_Commit{&Commit{View: 0, SequenceNumber: 1, RequestDigest: d, ReplicaId: 2}}},
+		{&Message_Checkpoint{&Checkpoint{SequenceNumber: 10, ReplicaId: 2, Id: "state", DigestAlgorithm: digest.name()}}},
+		{&Message_ViewChange{&ViewChange{View: 1, H: 0, ReplicaId: 2,
+			Pset: []*ViewChange_PQ{{SequenceNumber: 1, Digest: d, View: 0}},
+			Qset: []*ViewChange_PQ{{SequenceNumber: 1, Digest: d, View: 0}}}}},
+		{&Message_NewView{&NewView{View: 1, ReplicaId: 1, Xset: map[uint64]string{1: d}}}},
+		{&Message_FetchRequest{&FetchRequest{RequestDigest: d, ReplicaId: 2}}},
+	}
+}
+
+// fuzzReplicas are the replicas the fuzzed messages are delivered to, the
+// primary and a backup
+var fuzzReplicas = []uint64{0, 1}
+
+// checkInvariants returns an error if the state of a replica is
+// inconsistent, or moved backwards since it was last checked
+func checkInvariants(instance *PbftCore, lastView, lastExec uint64) error {
+	if instance.View < lastView {
+		return fmt.Errorf("replica %d went back from view %d to %d", instance.ID, lastView, instance.View)
+	}
+	if instance.LastExec < lastExec {
+		return fmt.Errorf("replica %d went back from executing %d to %d", instance.ID, lastExec, instance.LastExec)
+	}
+	for idx := range instance.certStore {
+		if idx.n <= instance.h {
+			return fmt.Errorf("replica %d keeps a certificate for seqNo %d, at or below its low watermark %d", instance.ID, idx.n, instance.h)
+		}
+	}
+	for chkpt := range instance.checkpointStore {
+		if chkpt.SequenceNumber <= instance.h {
+			return fmt.Errorf("replica %d keeps a checkpoint for seqNo %d, at or below its low watermark %d", instance.ID, chkpt.SequenceNumber, instance.h)
+		}
+	}
+	for n := range instance.pset {
+		if n <= instance.h {
+			return fmt.Errorf("replica %d keeps a P-set entry for seqNo %d, at or below its low watermark %d", instance.ID, n, instance.h)
+		}
+	}
+	for idx := range instance.qset {
+		if idx.n <= instance.h {
+			return fmt.Errorf("replica %d keeps a Q-set entry for seqNo %d, at or below its low watermark %d", instance.ID, idx.n, instance.h)
+		}
+	}
+	return nil
+}
+
+// fuzzDeliver delivers msgs from sender to the fuzzed replicas of sim, lets
+// the replicas react for a virtual second after each, and checks the
+// invariants of every replica
+func fuzzDeliver(t *testing.T, sim *simulation, msgs []*Message, sender uint64) {
+	views := make([]uint64, len(sim.replicas))
+	execs := make([]uint64, len(sim.replicas))
+	for _, msg := range msgs {
+		for _, id := range fuzzReplicas {
+			sendEvent(sim.replicas[id].pbft, pbftMessageEvent{msg: msg, sender: sender})
+		}
+		sim.run(time.Second)
+		for i, r := range sim.replicas {
+			if err := checkInvariants(r.pbft, views[i], execs[i]); err != nil {
+				t.Fatalf("After %v from %d: %s", msg, sender, err)
+			}
+			views[i], execs[i] = r.pbft.View, r.pbft.LastExec
+		}
+	}
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	for _, msg := range fuzzSeedMessages() {
+		raw, _ := proto.Marshal(msg)
+		f.Add(raw, wireVersion)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, version uint32) {
+		msg := &Message{}
+		if err := proto.Unmarshal(data, msg); err == nil {
+			raw, err := proto.Marshal(msg)
+			if err != nil {
+				t.Fatalf("Decoded message does not marshal: %s", err)
+			}
+			again := &Message{}
+			if err := proto.Unmarshal(raw, again); err != nil || !proto.Equal(msg, again) {
+				t.Fatalf("Decoded message does not survive a round trip: %v", err)
+			}
+		}
+		OpenWireMessage(&pb.Message{Type: pb.Message_CONSENSUS, Payload: data, Version: version})
+	})
+}
+
+func FuzzRecvMsg(f *testing.F) {
+	for _, msg := range fuzzSeedMessages() {
+		raw, _ := proto.Marshal(msg)
+		f.Add(raw, uint8(2))
+	}
+	logging.SetLevel(logging.ERROR, "consensus/obcpbft")
+	defer logging.SetLevel(logging.DEBUG, "consensus/obcpbft")
+
+	f.Fuzz(func(t *testing.T, data []byte, sender uint8) {
+		msg := &Message{}
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return
+		}
+		sim := newSeededSimulation(1, 4, nil)
+		defer sim.close()
+		// senders beyond the network are allowed, a peer may claim any handle
+		fuzzDeliver(t, sim, []*Message{msg}, uint64(sender%8))
+	})
+}
+
+// clampMessage keeps the views, sequence numbers and replica ids of a
+// generated message small, so that it lands within the watermarks and
+// reaches the logic behind the checks of the message headers
+func clampMessage(msg *Message, r *rand.Rand) {
+	view := uint64(r.Intn(3))
+	seqNo := uint64(r.Intn(20))
+	replica := uint64(r.Intn(4))
+	switch m := msg.Payload.(type) {
+	case *Message_Request:
+		m.Request.ReplicaId = replica
+	case *Message_PrePrepare:
+		m.PrePrepare.View, m.PrePrepare.SequenceNumber, m.PrePrepare.ReplicaId = view, seqNo, replica
+	case *Message_Prepare:
+		m.Prepare.View, m.Prepare.SequenceNumber, m.Prepare.ReplicaId = view, seqNo, replica
+	case *Message_Commit:
+		m.Commit.View, m.Commit.SequenceNumber, m.Commit.ReplicaId = view, seqNo, replica
+	case *Message_Checkpoint:
+		m.Checkpoint.SequenceNumber, m.Checkpoint.ReplicaId = seqNo, replica
+	case *Message_ViewChange:
+		m.ViewChange.View, m.ViewChange.H, m.ViewChange.ReplicaId = view, seqNo/10*10, replica
+	case *Message_NewView:
+		m.NewView.View, m.NewView.ReplicaId = view, replica
+	}
+}
+
+// FuzzStructuredRecvMsg delivers sequences of generated messages, which are
+// structurally valid, unlike most of the inputs of FuzzRecvMsg
+func FuzzStructuredRecvMsg(f *testing.F) {
+	f.Add(int64(0), uint8(4))
+	f.Add(int64(1), uint8(8))
+	f.Add(int64(2), uint8(16))
+	logging.SetLevel(logging.ERROR, "consensus/obcpbft")
+	defer logging.SetLevel(logging.DEBUG, "consensus/obcpbft")
+
+	f.Fuzz(func(t *testing.T, seed int64, count uint8) {
+		r := rand.New(rand.NewSource(seed))
+		fuzzer := fuzz.New().RandSource(r).NilChance(0).NumElements(0, 3)
+		sim := newSeededSimulation(seed, 4, nil)
+		defer sim.close()
+		for i := 0; i < int(count%16); i++ {
+			msg := &Message{}
+			fuzzer.Fuzz(msg)
+			if msg.Payload == nil {
+				continue
+			}
+			clampMessage(msg, r)
+			// roundtrip through protobufs, as the network would
+			raw, _ := proto.Marshal(msg)
+			msg = &Message{}
+			if err := proto.Unmarshal(raw, msg); err != nil {
+				t.Fatalf("Generated message does not unmarshal: %s", err)
+			}
+			fuzzDeliver(t, sim, []*Message{msg}, uint64(r.Intn(4)))
+		}
+	})
+}
@@ -14,7 +14,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package obcpbft
+package pbft
 
 import (
 	gp "google/protobuf"
@@ -61,12 +61,12 @@ func TestFuzz(t *testing.T) {
 
 	mock := newFuzzMock()
 	primary := newPbftCore(0, loadConfig(), mock)
-	primary.manager.start()
-	defer primary.close()
+	primary.Manager.Start()
+	defer primary.Close()
 	mock = newFuzzMock()
 	backup := newPbftCore(1, loadConfig(), mock)
-	backup.manager.start()
-	defer backup.close()
+	backup.Manager.Start()
+	defer backup.Close()
 
 	f := fuzz.New()
 
@@ -95,8 +95,8 @@ func TestFuzz(t *testing.T) {
 			senderID = nv.ReplicaId
 		}
 
-		primary.manager.queue() <- &pbftMessageEvent{msg: msg, sender: senderID}
-		backup.manager.queue() <- &pbftMessageEvent{msg: msg, sender: senderID}
+		primary.Manager.Queue() <- &pbftMessageEvent{msg: msg, sender: senderID}
+		backup.Manager.Queue() <- &pbftMessageEvent{msg: msg, sender: senderID}
 	}
 
 	logging.Reset()
@@ -142,14 +142,14 @@ func TestMinimalFuzz(t *testing.T) {
 
 	validatorCount := 4
 	net := makePBFTNetwork(validatorCount, nil)
-	defer net.stop()
+	defer net.Stop()
 	fuzzer := &protoFuzzer{r: rand.New(rand.NewSource(0))}
-	net.filterFn = fuzzer.fuzzPacket
+	net.FilterFn = fuzzer.fuzzPacket
 
 	noExec := 0
 	for reqID := 1; reqID < 30; reqID++ {
 		if reqID%3 == 0 {
-			fuzzer.fuzzNode = fuzzer.r.Intn(len(net.endpoints))
+			fuzzer.fuzzNode = fuzzer.r.Intn(len(net.Endpoints))
 			fmt.Printf("Fuzzing node %d\n", fuzzer.fuzzNode)
 		}
 
@@ -161,34 +161,34 @@ func TestMinimalFuzz(t *testing.T) {
 			t.Fatalf("Failed to marshal TX block: %s", err)
 		}
 		msg := &Message{&Message_Request{&Request{Payload: txPacked, ReplicaId: uint64(generateBroadcaster(validatorCount))}}}
-		for _, ep := range net.endpoints {
-			ep.(*pbftEndpoint).pbft.manager.queue() <- &pbftMessageEvent{msg: msg, sender: msg.GetRequest().ReplicaId}
+		for _, ep := range net.Endpoints {
+			ep.(*pbftEndpoint).pbft.Manager.Queue() <- &pbftMessageEvent{msg: msg, sender: msg.GetRequest().ReplicaId}
 		}
 		if err != nil {
 			t.Fatalf("Request failed: %s", err)
 		}
 
-		err = net.process()
+		err = net.Process()
 		if err != nil {
 			t.Fatalf("Processing failed: %s", err)
 		}
 
 		quorum := 0
-		for _, ep := range net.endpoints {
+		for _, ep := range net.Endpoints {
 			if ep.(*pbftEndpoint).sc.executions > 0 {
 				quorum++
 				ep.(*pbftEndpoint).sc.executions = 0
 			}
 		}
-		if quorum < len(net.endpoints)/3 {
+		if quorum < len(net.Endpoints)/3 {
 			noExec++
 		}
 		if noExec > 1 {
 			noExec = 0
-			for _, ep := range net.endpoints {
-				ep.(*pbftEndpoint).pbft.sendViewChange()
+			for _, ep := range net.Endpoints {
+				ep.(*pbftEndpoint).pbft.SendViewChange()
 			}
-			err = net.process()
+			err = net.Process()
 			if err != nil {
 				t.Fatalf("Processing failed: %s", err)
 			}
@@ -0,0 +1,184 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// gossipTimerEvent is sent when the anti-entropy timer expires
+type gossipTimerEvent struct{}
+
+// requestGossip disseminates client requests by relaying every request a
+// replica sees for the first time to a few random replicas, instead of
+// broadcasting it to all of them. Replicas which were missed pull requests
+// by periodically comparing the digests of their outstanding requests with a
+// random replica
+type requestGossip struct {
+	fanout int
+	period time.Duration
+	timer  EventTimer
+	rand   *rand.Rand
+	pulled map[string]bool // digests fetched in the current anti-entropy round
+}
+
+// newRequestGossip returns nil if general.gossip.fanout is 0
+func newRequestGossip(id uint64, config *viper.Viper) (*requestGossip, error) {
+	fanout := config.GetInt("general.gossip.fanout")
+	if fanout < 0 {
+		return nil, fmt.Errorf("Gossip fanout must not be negative, got %d", fanout)
+	}
+	if fanout == 0 {
+		return nil, nil
+	}
+	period, err := time.ParseDuration(config.GetString("general.gossip.antientropy"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse gossip anti-entropy period: %s", err)
+	}
+	return &requestGossip{
+		fanout: fanout,
+		period: period,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano() + int64(id))),
+		pulled: make(map[string]bool),
+	}, nil
+}
+
+// EnableGossip replaces the broadcast of client requests with gossip, it
+// must be called before the event manager is started
+func (instance *PbftCore) EnableGossip(config *viper.Viper) error {
+	g, err := newRequestGossip(instance.ID, config)
+	if err != nil || g == nil {
+		return err
+	}
+	instance.log.Info("PBFT requests gossiped to %d replicas, anti-entropy every %v", g.fanout, g.period)
+	g.timer = newClockedEventTimerFactory(instance.Manager, instance.Clock).createTimer()
+	instance.Gossip = g
+	instance.scheduleAntiEntropy()
+	return nil
+}
+
+func (instance *PbftCore) scheduleAntiEntropy() {
+	if instance.Gossip.period > 0 {
+		instance.Gossip.timer.Reset(instance.Gossip.period, gossipTimerEvent{})
+	}
+}
+
+// gossipPeers picks up to fanout random replicas other than ourselves and
+// the excluded one
+func (instance *PbftCore) gossipPeers(exclude uint64) []uint64 {
+	var peers []uint64
+	for _, i := range instance.Gossip.rand.Perm(instance.replicaCount) {
+		if id := uint64(i); id != instance.ID && id != exclude {
+			peers = append(peers, id)
+		}
+		if len(peers) == instance.Gossip.fanout {
+			break
+		}
+	}
+	return peers
+}
+
+// gossipRequest relays a request we see for the first time
+func (instance *PbftCore) gossipRequest(req *Request, digest string) {
+	if instance.Gossip == nil {
+		return
+	}
+	msgRaw, err := proto.Marshal(&Message{&Message_GossipRequest{&GossipRequest{
+		Request:   req,
+		ReplicaId: instance.ID,
+	}}})
+	if err != nil {
+		instance.log.Error("Cannot marshal gossip of request %s: %s", digest, err)
+		return
+	}
+	for _, peer := range instance.gossipPeers(req.ReplicaId) {
+		instance.log.Debug("Gossiping request %s to replica %d", digest, peer)
+		instance.consumer.Unicast(msgRaw, peer)
+	}
+}
+
+func (instance *PbftCore) recvGossipRequest(gr *GossipRequest) error {
+	if gr.Request == nil || gr.Request.ReadOnly {
+		return fmt.Errorf("Replica %d received invalid gossip from replica %d", instance.ID, gr.ReplicaId)
+	}
+	return instance.RecvRequest(gr.Request)
+}
+
+// sendRequestDigests sends the digests of our outstanding requests to a
+// random replica, so that it can fetch the ones it misses
+func (instance *PbftCore) sendRequestDigests() {
+	instance.scheduleAntiEntropy()
+	instance.Gossip.pulled = make(map[string]bool)
+
+	if len(instance.OutstandingReqs) == 0 {
+		return
+	}
+	peers := instance.gossipPeers(instance.ID)
+	if len(peers) == 0 {
+		return
+	}
+	rd := &RequestDigests{ReplicaId: instance.ID}
+	for digest := range instance.OutstandingReqs {
+		rd.Digests = append(rd.Digests, digest)
+	}
+	msgRaw, err := proto.Marshal(&Message{&Message_RequestDigests{rd}})
+	if err != nil {
+		instance.log.Error("Cannot marshal request digests: %s", err)
+		return
+	}
+	instance.consumer.Unicast(msgRaw, peers[0])
+}
+
+// recvRequestDigests fetches the requests we miss from the sender
+func (instance *PbftCore) recvRequestDigests(rd *RequestDigests) error {
+	if instance.Gossip == nil {
+		return nil
+	}
+	for _, digest := range rd.Digests {
+		if _, ok := instance.reqStore[digest]; ok || instance.Gossip.pulled[digest] || instance.executedReqs.contains(digest) {
+			continue
+		}
+		instance.log.Debug("Pulling request %s from replica %d", digest, rd.ReplicaId)
+		instance.Gossip.pulled[digest] = true
+		msgRaw, err := proto.Marshal(&Message{&Message_FetchRequest{&FetchRequest{
+			RequestDigest: digest,
+			ReplicaId:     instance.ID,
+		}}})
+		if err != nil {
+			return fmt.Errorf("Cannot marshal fetch-request message: %s", err)
+		}
+		if err := instance.consumer.Unicast(msgRaw, rd.ReplicaId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recvPulledRequest processes a request fetched through anti-entropy, it
+// returns false if the request was not pulled
+func (instance *PbftCore) recvPulledRequest(digest string, req *Request) (bool, error) {
+	if instance.Gossip == nil || !instance.Gossip.pulled[digest] {
+		return false, nil
+	}
+	delete(instance.Gossip.pulled, digest)
+	return true, instance.RecvRequest(req)
+}
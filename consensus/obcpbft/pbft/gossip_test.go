@@ -0,0 +1,52 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+)
+
+func TestGossipPeers(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.gossip.fanout", 3)
+	g, err := newRequestGossip(1, config)
+	if err != nil {
+		t.Fatalf("Failed to create request gossip: %s", err)
+	}
+	instance := &PbftCore{ID: 1, replicaCount: 7, Gossip: g}
+
+	for i := 0; i < 20; i++ {
+		peers := instance.gossipPeers(4)
+		if len(peers) != 3 {
+			t.Fatalf("Expected 3 peers, got %v", peers)
+		}
+		seen := make(map[uint64]bool)
+		for _, peer := range peers {
+			if peer == 1 || peer == 4 || peer >= 7 || seen[peer] {
+				t.Fatalf("Expected distinct peers other than replicas 1 and 4, got %v", peers)
+			}
+			seen[peer] = true
+		}
+	}
+}
+
+func TestGossipDisabled(t *testing.T) {
+	g, err := newRequestGossip(0, loadConfig())
+	if err != nil || g != nil {
+		t.Fatalf("Expected gossip to be disabled by default, got %v, %v", g, err)
+	}
+}
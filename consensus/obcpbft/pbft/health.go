@@ -0,0 +1,156 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/health"
+	"github.com/spf13/viper"
+)
+
+// replicaHealth reports the replica as degraded when consensus is stuck:
+// when requests are outstanding but nothing executed for multiplier times
+// the request timeout, when a view change takes longer than multiplier times
+// the current view change timeout, or when a state transfer made no
+// progress for the state transfer timeout. The event thread refreshes it
+// after every event, the times are only compared when the health is
+// checked, so that a replica whose event thread hangs is reported as well
+type replicaHealth struct {
+	lock                 sync.Mutex
+	name                 string // the health of the replica is registered under
+	clock                Clock
+	multiplier           float64
+	stateTransferTimeout time.Duration
+
+	lastExec          uint64
+	waiting           bool          // whether requests are outstanding
+	progress          time.Time     // last execution, or when requests became outstanding while none were
+	execLimit         time.Duration // how long the replica may wait for an execution
+	viewChange        time.Time     // when the current view change started, zero in an active view
+	viewChangeLimit   time.Duration // how long the current view change may take
+	stateTransfer     time.Time     // when the current state transfer started or last progressed, zero if none
+	stateTransferSeen bool          // whether a state transfer was in progress at the last refresh
+}
+
+// newReplicaHealth reads the general.health section of the configuration,
+// it returns nil if the health check is disabled
+func newReplicaHealth(id uint64, config *viper.Viper, clk Clock) (*replicaHealth, error) {
+	multiplier := config.GetFloat64("general.health.multiplier")
+	if multiplier == 0 {
+		return nil, nil
+	}
+	if multiplier < 1 {
+		return nil, fmt.Errorf("Health multiplier must be at least 1, got %v", multiplier)
+	}
+	stateTransferTimeout, err := time.ParseDuration(config.GetString("general.health.statetransfer"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse health state transfer timeout: %s", err)
+	}
+	if stateTransferTimeout <= 0 {
+		return nil, fmt.Errorf("Health state transfer timeout must be positive, got %v", stateTransferTimeout)
+	}
+	return &replicaHealth{
+		name:                 healthName(id, config.GetString("general.chain")),
+		clock:                clk,
+		multiplier:           multiplier,
+		stateTransferTimeout: stateTransferTimeout,
+		progress:             clk.Now(),
+	}, nil
+}
+
+func healthName(id uint64, chainID string) string {
+	if chainID == "" {
+		return fmt.Sprintf("pbft replica %d", id)
+	}
+	return fmt.Sprintf("pbft replica %d of chain %s", id, chainID)
+}
+
+// update refreshes the health from the state of instance, it must only be
+// called from the event thread
+func (rh *replicaHealth) update(instance *PbftCore) {
+	if rh == nil {
+		return
+	}
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+	now := rh.clock.Now()
+
+	waiting := len(instance.OutstandingReqs) > 0
+	if instance.LastExec != rh.lastExec || (waiting && !rh.waiting) {
+		rh.progress = now
+	}
+	rh.lastExec, rh.waiting = instance.LastExec, waiting
+	rh.execLimit = time.Duration(rh.multiplier * float64(instance.RequestTimeout))
+
+	if instance.ActiveView {
+		rh.viewChange = time.Time{}
+	} else if rh.viewChange.IsZero() {
+		rh.viewChange = now
+	}
+	rh.viewChangeLimit = time.Duration(rh.multiplier * float64(instance.LastNewViewTimeout))
+
+	_, updating := instance.currentEvent.(StateUpdatingEvent)
+	if !instance.SkipInProgress {
+		rh.stateTransfer, rh.stateTransferSeen = time.Time{}, false
+	} else if !rh.stateTransferSeen || updating {
+		rh.stateTransfer, rh.stateTransferSeen = now, true
+	}
+}
+
+// Healthy is necessary to implement health.Checker
+func (rh *replicaHealth) Healthy() error {
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+	now := rh.clock.Now()
+
+	if !rh.viewChange.IsZero() {
+		if elapsed := now.Sub(rh.viewChange); elapsed > rh.viewChangeLimit {
+			return fmt.Errorf("view change in progress for %v", elapsed)
+		}
+	}
+	if rh.stateTransferSeen {
+		if elapsed := now.Sub(rh.stateTransfer); elapsed > rh.stateTransferTimeout {
+			return fmt.Errorf("state transfer made no progress for %v", elapsed)
+		}
+		return nil // nothing executes during state transfer
+	}
+	if rh.waiting {
+		if elapsed := now.Sub(rh.progress); elapsed > rh.execLimit {
+			return fmt.Errorf("requests outstanding but nothing executed for %v, last executed sequence number %d", elapsed, rh.lastExec)
+		}
+	}
+	return nil
+}
+
+// register adds the health of the replica to the health of the peer
+func (rh *replicaHealth) register() {
+	if rh == nil {
+		return
+	}
+	health.DefaultRegistry.Register(rh.name, rh)
+}
+
+// unregister removes the health of the replica from the health of the peer
+func (rh *replicaHealth) unregister() {
+	if rh == nil {
+		return
+	}
+	health.DefaultRegistry.Unregister(rh.name)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/health"
+)
+
+func TestHealthStuckExecution(t *testing.T) {
+	clk := newVirtualClock(time.Unix(0, 0))
+	instance := newPbftCoreWithClock(1, loadConfig(), &omniProto{}, clk)
+	defer instance.Close()
+	limit := time.Duration(instance.health.multiplier * float64(instance.RequestTimeout))
+
+	clk.advance(2 * limit)
+	if err := instance.health.Healthy(); err != nil {
+		t.Errorf("Expected an idle replica to be healthy, got: %s", err)
+	}
+
+	sendEvent(instance, WorkEvent(func() {
+		instance.OutstandingReqs["foo"] = &Request{}
+	}))
+	clk.advance(limit / 2)
+	if err := instance.health.Healthy(); err != nil {
+		t.Errorf("Expected the replica to be healthy while it waits less than %v, got: %s", limit, err)
+	}
+	clk.advance(limit)
+	err := instance.health.Healthy()
+	if err == nil || !strings.Contains(err.Error(), "nothing executed") {
+		t.Errorf("Expected the replica to be degraded once it waited longer than %v, got: %v", limit, err)
+	}
+	if degraded := health.DefaultRegistry.Degraded(); degraded["pbft replica 1"] == nil {
+		t.Errorf("Expected the replica to be reported degraded to the peer, got %v", degraded)
+	}
+
+	sendEvent(instance, WorkEvent(func() {
+		instance.LastExec++
+	}))
+	if err := instance.health.Healthy(); err != nil {
+		t.Errorf("Expected the replica to be healthy once it executed, got: %s", err)
+	}
+}
+
+func TestHealthViewChange(t *testing.T) {
+	clk := newVirtualClock(time.Unix(0, 0))
+	instance := newPbftCoreWithClock(1, loadConfig(), &omniProto{}, clk)
+	defer instance.Close()
+
+	sendEvent(instance, WorkEvent(func() {
+		instance.ActiveView = false
+	}))
+	clk.advance(time.Duration(instance.health.multiplier*float64(instance.LastNewViewTimeout)) + time.Second)
+	if err := instance.health.Healthy(); err == nil || !strings.Contains(err.Error(), "view change") {
+		t.Errorf("Expected a long view change to degrade the replica, got: %v", err)
+	}
+
+	sendEvent(instance, WorkEvent(func() {
+		instance.ActiveView = true
+	}))
+	if err := instance.health.Healthy(); err != nil {
+		t.Errorf("Expected the replica to be healthy once the new view is active, got: %s", err)
+	}
+}
+
+func TestHealthStateTransfer(t *testing.T) {
+	clk := newVirtualClock(time.Unix(0, 0))
+	instance := newPbftCoreWithClock(1, loadConfig(), &omniProto{}, clk)
+	defer instance.Close()
+	timeout := instance.health.stateTransferTimeout
+
+	sendEvent(instance, WorkEvent(func() {
+		instance.SkipInProgress = true
+		instance.OutstandingReqs["foo"] = &Request{}
+	}))
+	clk.advance(timeout / 2)
+	if err := instance.health.Healthy(); err != nil {
+		t.Errorf("Expected a replica transferring state not to expect executions, got: %s", err)
+	}
+	sendEvent(instance, StateUpdatingEvent{SeqNo: instance.LastExec})
+	clk.advance(timeout / 2)
+	if err := instance.health.Healthy(); err != nil {
+		t.Errorf("Expected the progress of the state transfer to be noticed, got: %s", err)
+	}
+	clk.advance(timeout)
+	if err := instance.health.Healthy(); err == nil || !strings.Contains(err.Error(), "state transfer") {
+		t.Errorf("Expected a stalled state transfer to degrade the replica, got: %v", err)
+	}
+}
+
+func TestHealthDisabled(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.health.multiplier", 0)
+	instance := newPbftCore(2, config, &omniProto{})
+	defer instance.Close()
+
+	if instance.health != nil {
+		t.Fatal("Expected no health check when the multiplier is 0")
+	}
+	if _, ok := health.DefaultRegistry.Degraded()["pbft replica 2"]; ok {
+		t.Error("Expected the replica not to be registered")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// heartbeatTimerEvent is sent when it is time to send the next heartbeat
+type heartbeatTimerEvent struct{}
+
+// heartbeatWireVersion is the first wire version whose replicas understand heartbeats
+const heartbeatWireVersion uint32 = 3
+
+// replicaLiveness is what we last heard from a replica
+type replicaLiveness struct {
+	lastHeard time.Time // when any message of the replica last arrived
+	view      uint64    // view of its last heartbeat
+	lastExec  uint64    // last executed sequence number of its last heartbeat
+}
+
+// livenessTable tracks which replicas we heard from recently. Every replica
+// broadcasts a heartbeat each interval, and any of its messages counts as
+// one, so that the failure of a backup is noticed before it matters, rather
+// than only once it fails to take part in a view change or state transfer
+type livenessTable struct {
+	interval time.Duration // between two heartbeats of this replica
+	timeout  time.Duration // silence after which a replica is unresponsive
+	timer    EventTimer
+	replicas map[uint64]*replicaLiveness
+}
+
+// newLivenessTable returns nil if general.heartbeat.interval is 0
+func newLivenessTable(config *viper.Viper) (*livenessTable, error) {
+	interval, err := time.ParseDuration(config.GetString("general.heartbeat.interval"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse heartbeat interval: %s", err)
+	}
+	if interval == 0 {
+		return nil, nil
+	}
+	timeout, err := time.ParseDuration(config.GetString("general.heartbeat.timeout"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse heartbeat timeout: %s", err)
+	}
+	if timeout <= interval {
+		return nil, fmt.Errorf("Heartbeat timeout (%v) must exceed the heartbeat interval (%v)", timeout, interval)
+	}
+	return &livenessTable{
+		interval: interval,
+		timeout:  timeout,
+		replicas: make(map[uint64]*replicaLiveness),
+	}, nil
+}
+
+// heard records that a message of the replica arrived at now
+func (lt *livenessTable) heard(replicaID uint64, now time.Time) *replicaLiveness {
+	rl, ok := lt.replicas[replicaID]
+	if !ok {
+		rl = &replicaLiveness{}
+		lt.replicas[replicaID] = rl
+	}
+	rl.lastHeard = now
+	return rl
+}
+
+// enableHeartbeats starts exchanging heartbeats with the other replicas, it
+// must be called before the event manager is started
+func (instance *PbftCore) enableHeartbeats(config *viper.Viper) error {
+	lt, err := newLivenessTable(config)
+	if err != nil || lt == nil {
+		return err
+	}
+	instance.log.Info("PBFT heartbeats sent every %v, replicas silent for %v are unresponsive", lt.interval, lt.timeout)
+	lt.timer = newClockedEventTimerFactory(instance.Manager, instance.Clock).createTimer()
+	instance.liveness = lt
+	// Give every replica a whole timeout to be heard from before it counts as unresponsive
+	now := instance.Clock.Now()
+	for i := 0; i < instance.N; i++ {
+		if uint64(i) != instance.ID {
+			lt.heard(uint64(i), now)
+		}
+	}
+	lt.timer.Reset(lt.interval, heartbeatTimerEvent{})
+	return nil
+}
+
+// sendHeartbeat announces that we are alive to the replicas which
+// understand heartbeats, and schedules the next heartbeat
+func (instance *PbftCore) sendHeartbeat() {
+	lt := instance.liveness
+	lt.timer.Reset(lt.interval, heartbeatTimerEvent{})
+	msgRaw, err := proto.Marshal(&Message{&Message_Heartbeat{&Heartbeat{
+		ReplicaId: instance.ID,
+		View:      instance.View,
+		LastExec:  instance.LastExec,
+	}}})
+	if err != nil {
+		instance.log.Error("Cannot marshal heartbeat: %s", err)
+		return
+	}
+	for i := 0; i < instance.N; i++ {
+		if id := uint64(i); id != instance.ID && instance.Wire.version(id) >= heartbeatWireVersion {
+			instance.consumer.Unicast(msgRaw, id)
+		}
+	}
+}
+
+// noteAlive records that a message of the replica arrived
+func (instance *PbftCore) noteAlive(replicaID uint64) {
+	if instance.liveness == nil || replicaID == instance.ID {
+		return
+	}
+	instance.liveness.heard(replicaID, instance.Clock.Now())
+}
+
+func (instance *PbftCore) recvHeartbeat(hb *Heartbeat) error {
+	if instance.liveness == nil {
+		return nil
+	}
+	rl := instance.liveness.heard(hb.ReplicaId, instance.Clock.Now())
+	rl.view = hb.View
+	rl.lastExec = hb.LastExec
+	return nil
+}
+
+// responsive returns whether we heard from the replica within the heartbeat
+// timeout. Every replica is responsive while heartbeats are disabled
+func (instance *PbftCore) responsive(replicaID uint64) bool {
+	if instance.liveness == nil || replicaID == instance.ID {
+		return true
+	}
+	rl, ok := instance.liveness.replicas[replicaID]
+	return ok && instance.Clock.Now().Sub(rl.lastHeard) <= instance.liveness.timeout
+}
+
+// responsiveReplicas counts the replicas we heard from within the heartbeat timeout, including ourselves
+func (instance *PbftCore) responsiveReplicas() int {
+	count := 0
+	for i := 0; i < instance.N; i++ {
+		if instance.responsive(uint64(i)) {
+			count++
+		}
+	}
+	return count
+}
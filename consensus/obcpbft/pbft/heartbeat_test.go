@@ -0,0 +1,92 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestLivenessTableConfig(t *testing.T) {
+	lt, err := newLivenessTable(loadConfig())
+	if err != nil || lt != nil {
+		t.Fatalf("Expected heartbeats to be disabled by default, got %v, %v", lt, err)
+	}
+
+	config := loadConfig()
+	config.Set("general.heartbeat.interval", "5s")
+	config.Set("general.heartbeat.timeout", "5s")
+	if _, err := newLivenessTable(config); err == nil {
+		t.Errorf("Expected a timeout not exceeding the interval to be rejected")
+	}
+}
+
+func TestHeartbeatLiveness(t *testing.T) {
+	var receivers []uint64
+	stack := &omniProto{
+		broadcastImpl: func(msgPayload []byte) {},
+		unicastImpl: func(msgPayload []byte, receiverID uint64) error {
+			msg := &Message{}
+			if err := proto.Unmarshal(msgPayload, msg); err != nil {
+				t.Fatalf("Could not unmarshal unicast message: %s", err)
+			}
+			if msg.GetHeartbeat() != nil {
+				receivers = append(receivers, receiverID)
+			}
+			return nil
+		},
+	}
+	config := loadConfig()
+	config.Set("general.heartbeat.interval", "1s")
+	config.Set("general.heartbeat.timeout", "3s")
+	vc := newVirtualClock(time.Unix(0, 0))
+	p := newPbftCoreWithClock(0, config, stack, vc)
+	defer p.Close()
+
+	for _, id := range []uint64{1, 2} {
+		sendEvent(p, pbftMessageEvent{msg: &Message{&Message_WireHello{newWireNegotiator(id, 4).hello(true)}}, sender: id})
+	}
+	old := newWireNegotiator(3, 4)
+	old.max = heartbeatWireVersion - 1
+	sendEvent(p, pbftMessageEvent{msg: &Message{&Message_WireHello{old.hello(true)}}, sender: 3})
+
+	sendEvent(p, heartbeatTimerEvent{})
+	if len(receivers) != 2 || receivers[0] != 1 || receivers[1] != 2 {
+		t.Fatalf("Expected heartbeats to be sent to the replicas speaking wire version %d, sent to %v", heartbeatWireVersion, receivers)
+	}
+
+	vc.advance(2 * time.Second)
+	sendEvent(p, pbftMessageEvent{msg: &Message{&Message_Heartbeat{&Heartbeat{ReplicaId: 1, View: 0, LastExec: 7}}}, sender: 1})
+	vc.advance(2 * time.Second)
+	if !p.responsive(1) || p.liveness.replicas[1].lastExec != 7 {
+		t.Errorf("Expected replica 1 to be responsive at its last executed seqNo 7")
+	}
+	if p.responsive(2) || p.responsive(3) {
+		t.Errorf("Expected replicas 2 and 3 to be unresponsive after 4s without messages")
+	}
+	if count := p.responsiveReplicas(); count != 2 {
+		t.Errorf("Expected replicas 0 and 1 to be responsive, counted %d", count)
+	}
+
+	replicas := []uint64{3, 2, 1}
+	p.rankByCheckpoint(replicas)
+	if replicas[0] != 1 {
+		t.Errorf("Expected state transfer to ask the responsive replica 1 first, got %v", replicas)
+	}
+}
@@ -0,0 +1,1565 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// A hierarchical network splits the replicas into groups, each of which
+// runs its own PBFT instance, so that no instance grows beyond the size
+// PBFT handles well. The primary of the instance of every group leads it,
+// and the leaders run a top level instance among themselves. When a view
+// change replaces the primary of a group, f+1 of its members certify the
+// new primary as leader, and it takes the place of its group in the top
+// level.
+//
+// A payload submitted to a group is ordered by the group first. Its members
+// then sign that the group ordered it, and once f+1 of them did, the leader
+// submits the payload with their signatures to the top level, whose replicas
+// only order payloads so certified. Every leader signs the top level order
+// in turn and sends it to all replicas, and once leaders of f+1 groups did,
+// the leader of every group submits the certified order to its group, whose
+// members check both certificates again, and hand the payloads to their
+// Stack in that order. Each level checks the certificates of the other, so
+// that a faulty leader can neither invent payloads of its group nor an order
+// of the top level, it can only withhold them until its group replaces it.
+//
+// Both instances are embedded Replicas, the hierarchy composes them through
+// their Stacks and does not change the protocol they run. The state of each
+// level is persisted as it executes, and the state of the group level is
+// checkpointed by its instance, so that a member which restarts or falls
+// behind recovers it, transferring it from the other members if needed
+
+const (
+	hierarchyGroupStateKey = "hierarchy.state.group"
+	hierarchyTopStateKey   = "hierarchy.state.top"
+)
+
+// hierarchy is a member of a hierarchical network
+type hierarchy struct {
+	id      uint64
+	stack   Stack
+	groups  [][]uint64     // global IDs of the members of each group
+	groupOf map[uint64]int // group of every replica, by global ID
+	group   int            // index of our group
+	window  uint64         // group level sequence numbers whose snapshots we serve
+
+	config   *viper.Viper
+	opts     []Option
+	topStart sync.Mutex // serializes the creation of topReplica
+
+	groupReplica Replica
+	groupLevel   *hierarchyLevel
+	topLevel     *hierarchyLevel
+
+	lock       sync.Mutex
+	topReplica Replica // nil until we first lead our group
+
+	// leadership
+	view       uint64                                      // of the instance of our group
+	primary    uint64                                      // of view, by global ID
+	leading    bool                                        // whether we lead our group in view
+	leadership *LeaderCertificate                          // of our own, nil when leading as first member in view 0
+	votes      map[uint64]map[uint64]*HierarchyEndorsement // for us to lead, by view and signer
+	leaders    []*LeaderCertificate                        // latest known of every group, nil while its first member leads in view 0
+
+	// the execution of the instance of the group, the same on its members
+	ordered    uint64                  // payloads submitted to the group and ordered so far
+	lastTop    uint64                  // top level sequence number delivered last
+	early      map[uint64]*TopDelivery // delivered before their predecessor, by the top level sequence number it executed before them
+	next       []uint64                // of every group, the lowest index of its payloads not delivered yet
+	ahead      []map[uint64]bool       // of every group, the indices above next which were delivered
+	delivered  uint64                  // payloads handed to the Stack
+	pending    map[uint64][]byte       // payloads our group ordered which were not delivered yet, by index
+	stackState []byte                  // of the Stack after the last payload delivered
+
+	// the snapshots of that execution, and their transfer
+	snapshots map[string]*hierarchySnapshot // by snapshot ID
+	transfer  *hierarchyTransfer            // under way, nil if none
+
+	// the certificates we assemble
+	upward   map[uint64]*endorsedRaw // by index within our group
+	downward map[uint64]*endorsedRaw // by top level sequence number
+}
+
+// endorsedRaw collects the endorsements of a payload or certificate
+type endorsedRaw struct {
+	raws      map[string][]byte                // the endorsed certificates, by digest
+	sigs      map[uint64]*HierarchyEndorsement // by signer
+	submitted bool                             // by us, while leading
+}
+
+func newEndorsedRaw() *endorsedRaw {
+	return &endorsedRaw{raws: make(map[string][]byte), sigs: make(map[uint64]*HierarchyEndorsement)}
+}
+
+// hierarchySnapshot is the serialized hierarchy_state of the group level
+// after a sequence number
+type hierarchySnapshot struct {
+	seqNo uint64
+	raw   []byte
+}
+
+// hierarchyTransfer is the transfer of the state of the group level the
+// instance of our group asked for through SkipTo
+type hierarchyTransfer struct {
+	seqNo      uint64
+	snapshotID []byte
+	state      *HierarchyState // once a member sent it
+	raw        []byte
+}
+
+// NewHierarchicalReplica creates and starts replica id of the hierarchical
+// network whose groups list the global replica IDs of their members, the
+// first member of every group leading it until a view change of the group.
+// The Stack addresses and verifies replicas by their global ID, and receives
+// the payloads of all groups in the order of the top level. It may receive
+// the payloads a restarted replica delivered last once more. The settings of
+// config and opts apply to the instances of both levels, except for N and f,
+// which follow from the size of the groups, and the persistence, which is
+// namespaced in the Persistor of the Stack by level
+func NewHierarchicalReplica(id uint64, groups [][]uint64, config *viper.Viper, stack Stack, opts ...Option) (Replica, error) {
+	h := &hierarchy{
+		id:        id,
+		stack:     stack,
+		groups:    groups,
+		groupOf:   make(map[uint64]int),
+		group:     -1,
+		window:    snapshotWindow(config),
+		config:    config,
+		opts:      opts,
+		votes:     make(map[uint64]map[uint64]*HierarchyEndorsement),
+		leaders:   make([]*LeaderCertificate, len(groups)),
+		early:     make(map[uint64]*TopDelivery),
+		next:      make([]uint64, len(groups)),
+		ahead:     make([]map[uint64]bool, len(groups)),
+		pending:   make(map[uint64][]byte),
+		snapshots: make(map[string]*hierarchySnapshot),
+		upward:    make(map[uint64]*endorsedRaw),
+		downward:  make(map[uint64]*endorsedRaw),
+	}
+	for g, members := range groups {
+		if len(members) == 0 {
+			return nil, fmt.Errorf("Group %d of the hierarchy has no members", g)
+		}
+		for _, member := range members {
+			if _, ok := h.groupOf[member]; ok {
+				return nil, fmt.Errorf("Replica %d is a member of more than one group", member)
+			}
+			h.groupOf[member] = g
+			if member == id {
+				h.group = g
+			}
+		}
+		h.next[g] = 1
+		h.ahead[g] = make(map[uint64]bool)
+	}
+	if h.group < 0 {
+		return nil, fmt.Errorf("Replica %d is not a member of any group of the hierarchy", id)
+	}
+
+	members := groups[h.group]
+	h.primary = members[0]
+	h.groupLevel = &hierarchyLevel{h: h, members: members, prefix: "hierarchy.group.", key: hierarchyGroupStateKey}
+	h.topLevel = &hierarchyLevel{h: h, top: true, prefix: "hierarchy.top.", key: hierarchyTopStateKey}
+	if err := h.restore(); err != nil {
+		return nil, err
+	}
+
+	h.groupReplica = NewReplica(indexOf(members, id), levelConfig(config, fmt.Sprintf("group%d", h.group)), h.groupLevel,
+		append(opts, WithN(len(members), (len(members)-1)/3))...)
+	logger.Info("Replica %d joined group %d of %d replicas of a hierarchy of %d groups, leading it: %v", id, h.group, len(members), len(groups), h.isLeading())
+	return h, nil
+}
+
+// levelConfig copies config for the instance of a level, whose metrics are
+// labeled with chain
+func levelConfig(config *viper.Viper, chain string) *viper.Viper {
+	lc := viper.New()
+	for _, key := range config.AllKeys() {
+		lc.Set(key, config.Get(key))
+	}
+	lc.Set("general.chain", chain)
+	return lc
+}
+
+// snapshotWindow is the number of sequence numbers the instance of a group
+// may lag behind its members, the largest log the instance may grow
+func snapshotWindow(config *viper.Viper) uint64 {
+	K := config.GetInt("general.K")
+	if maxK := config.GetInt("general.autocheckpoint.maxk"); maxK > K {
+		K = maxK
+	}
+	multiplier := config.GetInt("general.logmultiplier")
+	if maxMultiplier := config.GetInt("general.maxlogmultiplier"); maxMultiplier > multiplier {
+		multiplier = maxMultiplier
+	}
+	return uint64(K * multiplier)
+}
+
+func indexOf(members []uint64, id uint64) uint64 {
+	for i, member := range members {
+		if member == id {
+			return uint64(i)
+		}
+	}
+	panic(fmt.Errorf("Replica %d is not among %v", id, members))
+}
+
+// restore recovers the state both levels persisted as they executed
+func (h *hierarchy) restore() error {
+	if seqNo, raw, ok := h.groupLevel.restored(); ok {
+		hs := &HierarchyState{}
+		if err := proto.Unmarshal(raw, hs); err != nil {
+			return fmt.Errorf("Cannot unmarshal the persisted state of group %d: %s", h.group, err)
+		}
+		if len(hs.Progress) != len(h.groups) {
+			return fmt.Errorf("The persisted state of group %d is the one of a hierarchy of %d groups, not %d", h.group, len(hs.Progress), len(h.groups))
+		}
+		h.adopt(hs)
+		id := h.keepSnapshot(seqNo, raw)
+		h.groupLevel.executedTo(seqNo, id)
+		logger.Info("Replica %d restored the state of group %d at seqNo %d, %d payloads delivered", h.id, h.group, seqNo, h.delivered)
+	}
+	if seqNo, chain, ok := h.topLevel.restored(); ok {
+		h.topLevel.executedTo(seqNo, chain)
+	}
+	return nil
+}
+
+func hierarchyDigest(raw []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(raw))
+}
+
+// groupStatement is what the members of group sign to endorse that it
+// ordered the payload with digest as its index-th
+func groupStatement(group int, index uint64, digest string) []byte {
+	return []byte(fmt.Sprintf("group %d ordered %d %s", group, index, digest))
+}
+
+// topStatement is what the group leaders sign to endorse that the top level
+// ordered the group certificate with digest at seqNo, and executed previous
+// before it
+func topStatement(seqNo uint64, previous uint64, digest string) []byte {
+	return []byte(fmt.Sprintf("top ordered %d after %d %s", seqNo, previous, digest))
+}
+
+// topKey identifies what a top level endorsement endorses at its sequence
+// number
+func topKey(previous uint64, digest string) string {
+	return fmt.Sprintf("%d %s", previous, digest)
+}
+
+// leaderStatement is what the members of group sign to endorse that leader
+// is the primary of view of its instance
+func leaderStatement(group int, view uint64, leader uint64) []byte {
+	return []byte(fmt.Sprintf("group %d view %d led by %d", group, view, leader))
+}
+
+// verifyEndorsements checks that f+1 of the replicas sign statement through
+// the endorsements, so that one of them is correct
+func (h *hierarchy) verifyEndorsements(replicas []uint64, endorsements []*HierarchyEndorsement, statement func(e *HierarchyEndorsement) []byte) error {
+	f := (len(replicas) - 1) / 3
+	valid := make(map[uint64]bool)
+	var problems []string
+	for _, e := range endorsements {
+		if !contains(replicas, e.ReplicaId) {
+			problems = append(problems, fmt.Sprintf("replica %d may not endorse it", e.ReplicaId))
+			continue
+		}
+		if err := h.stack.Verify(e.ReplicaId, e.Signature, statement(e)); err != nil {
+			problems = append(problems, fmt.Sprintf("replica %d: %s", e.ReplicaId, err))
+			continue
+		}
+		valid[e.ReplicaId] = true
+	}
+	if len(valid) < f+1 {
+		return fmt.Errorf("endorsed by %d of the %d replicas needed (%s)", len(valid), f+1, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func contains(ids []uint64, id uint64) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Replica interface
+// =============================================================================
+
+// Request is necessary to implement Replica
+func (h *hierarchy) Request(payload []byte) error {
+	raw, err := proto.Marshal(&GroupPayload{Request: payload})
+	if err != nil {
+		return err
+	}
+	return h.groupReplica.Request(raw)
+}
+
+// Receive is necessary to implement Replica
+func (h *hierarchy) Receive(msg []byte, senderID uint64) error {
+	hm := &HierarchyMessage{}
+	if err := proto.Unmarshal(msg, hm); err != nil {
+		return fmt.Errorf("Error unpacking hierarchy message from replica %d: %s", senderID, err)
+	}
+	switch {
+	case hm.Group != nil:
+		members := h.groups[h.group]
+		if !contains(members, senderID) {
+			return fmt.Errorf("Replica %d sent a message of group %d, which it is not a member of", senderID, h.group)
+		}
+		return h.groupReplica.Receive(hm.Group, indexOf(members, senderID))
+	case hm.Top != nil:
+		return h.recvTop(hm.Top, hm.Leader, senderID)
+	case hm.Leader != nil:
+		return h.recvVote(hm.Leader, senderID)
+	case hm.Endorsement != nil:
+		return h.recvEndorsement(hm.Endorsement, senderID)
+	case hm.StateRequest != nil:
+		return h.recvStateRequest(hm.StateRequest, senderID)
+	case hm.State != nil:
+		return h.recvState(hm.State, senderID)
+	}
+	return fmt.Errorf("Replica %d sent an empty hierarchy message", senderID)
+}
+
+// StateUpdating is necessary to implement Replica, the Stack reports the
+// transfer the hierarchy started along with the one of the group level
+func (h *hierarchy) StateUpdating(seqNo uint64, snapshotID []byte) {
+	if t := h.stackTransfer(seqNo); t != nil {
+		h.groupReplica.StateUpdating(t.seqNo, t.snapshotID)
+	}
+}
+
+// StateUpdated is necessary to implement Replica
+func (h *hierarchy) StateUpdated(seqNo uint64, snapshotID []byte) {
+	if t := h.stackTransfer(seqNo); t != nil {
+		h.completeTransfer()
+	}
+}
+
+// Close is necessary to implement Replica
+func (h *hierarchy) Close() {
+	h.groupReplica.Close()
+	if top := h.top(); top != nil {
+		top.Close()
+	}
+}
+
+// =============================================================================
+// Leadership
+// =============================================================================
+
+func (h *hierarchy) isLeading() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.leading
+}
+
+func (h *hierarchy) top() Replica {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.topReplica
+}
+
+// leaderOf returns the global ID of the replica which leads group g, as far
+// as we know
+func (h *hierarchy) leaderOf(g int) uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if cert := h.leaders[g]; cert != nil {
+		return cert.Leader
+	}
+	return h.groups[g][0]
+}
+
+// learnLeader records the leadership of another group, unless we know of a
+// later one
+func (h *hierarchy) learnLeader(cert *LeaderCertificate) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if known := h.leaders[cert.Group]; known == nil || known.View < cert.View {
+		h.leaders[cert.Group] = cert
+	}
+}
+
+// knownLeader reports whether cert is the leadership we recorded for its group
+func (h *hierarchy) knownLeader(cert *LeaderCertificate) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	known := h.leaders[cert.Group]
+	return known != nil && known.View == cert.View && known.Leader == cert.Leader
+}
+
+// verifyLeadership checks that cert proves that leader led its group
+func (h *hierarchy) verifyLeadership(cert *LeaderCertificate, leader uint64) error {
+	g, ok := h.groupOf[leader]
+	if !ok || int(cert.Group) != g || cert.Leader != leader {
+		return fmt.Errorf("certificate of replica %d leading group %d presented by replica %d", cert.Leader, cert.Group, leader)
+	}
+	if h.knownLeader(cert) {
+		return nil
+	}
+	return h.verifyEndorsements(h.groups[g], cert.Endorsements, func(e *HierarchyEndorsement) []byte {
+		return leaderStatement(g, cert.View, cert.Leader)
+	})
+}
+
+// vote signs that leader is the primary of view of our group
+func (h *hierarchy) vote(view uint64, leader uint64) (*LeaderCertificate, error) {
+	sig, err := h.stack.Sign(leaderStatement(h.group, view, leader))
+	if err != nil {
+		return nil, err
+	}
+	return &LeaderCertificate{
+		Group:        uint32(h.group),
+		View:         view,
+		Leader:       leader,
+		Endorsements: []*HierarchyEndorsement{{SequenceNumber: view, ReplicaId: h.id, Signature: sig}},
+	}, nil
+}
+
+// primaryChanged is invoked by the instance of our group on its thread. We
+// vote for its primary to lead us, and hand it what it needs to pick up
+// where the previous leader stopped
+func (h *hierarchy) primaryChanged(view uint64, primary uint64) {
+	h.lock.Lock()
+	if view < h.view {
+		h.lock.Unlock()
+		return
+	}
+	h.view = view
+	h.primary = primary
+	deposed := h.leading && primary != h.id
+	if deposed {
+		h.leading = false
+		h.leadership = nil
+	}
+	for v := range h.votes {
+		if v < view {
+			delete(h.votes, v)
+		}
+	}
+	var indices []uint64
+	for index := range h.pending {
+		indices = append(indices, index)
+	}
+	sort.Sort(sortableUint64Slice(indices))
+	payloads := make([][]byte, len(indices))
+	for i, index := range indices {
+		payloads[i] = h.pending[index]
+	}
+	var forward []*HierarchyEndorsement
+	if primary != h.id {
+		for seqNo, entry := range h.downward {
+			if seqNo <= h.lastTop {
+				continue
+			}
+			for _, e := range entry.sigs {
+				attached := *e
+				attached.Certificate = entry.raws[topKey(e.Previous, e.Digest)]
+				forward = append(forward, &attached)
+			}
+		}
+	}
+	h.lock.Unlock()
+
+	if deposed {
+		logger.Notice("Replica %d no longer leads group %d, replica %d leads it from view %d", h.id, h.group, primary, view)
+	}
+
+	vote, err := h.vote(view, primary)
+	if err != nil {
+		logger.Error("Cannot vote for replica %d to lead group %d in view %d: %s", primary, h.group, view, err)
+		return
+	}
+	if primary != h.id {
+		h.send(&HierarchyMessage{Leader: vote}, primary)
+	} else {
+		if view != 0 || primary != h.groups[h.group][0] {
+			// our own vote asks the other members for theirs
+			h.send(&HierarchyMessage{Leader: vote}, h.groups[h.group]...)
+		}
+		h.recvVote(vote, h.id)
+	}
+
+	for i, index := range indices {
+		h.endorsePayload(index, payloads[i])
+	}
+	for _, e := range forward {
+		h.send(&HierarchyMessage{Endorsement: e}, primary)
+	}
+}
+
+// recvVote collects the votes for us to lead, and answers the request of
+// the primary of our group for our vote
+func (h *hierarchy) recvVote(vote *LeaderCertificate, senderID uint64) error {
+	if int(vote.Group) != h.group || len(vote.Endorsements) != 1 {
+		return fmt.Errorf("Replica %d sent a malformed vote for the leader of group %d", senderID, vote.Group)
+	}
+	e := vote.Endorsements[0]
+	if e.ReplicaId != senderID || !contains(h.groups[h.group], senderID) || e.SequenceNumber != vote.View {
+		return fmt.Errorf("Replica %d sent a vote of replica %d, which may not vote in group %d", senderID, e.ReplicaId, h.group)
+	}
+	if err := h.stack.Verify(e.ReplicaId, e.Signature, leaderStatement(h.group, vote.View, vote.Leader)); err != nil {
+		return fmt.Errorf("Vote of replica %d for replica %d to lead group %d in view %d failed verification: %s", e.ReplicaId, vote.Leader, h.group, vote.View, err)
+	}
+
+	h.lock.Lock()
+	if vote.Leader != h.id {
+		agree := senderID == vote.Leader && vote.View == h.view && vote.Leader == h.primary
+		h.lock.Unlock()
+		if agree {
+			if ours, err := h.vote(vote.View, vote.Leader); err == nil {
+				h.send(&HierarchyMessage{Leader: ours}, vote.Leader)
+			}
+		}
+		return nil
+	}
+	if vote.View < h.view {
+		h.lock.Unlock()
+		return nil
+	}
+	if h.votes[vote.View] == nil {
+		h.votes[vote.View] = make(map[uint64]*HierarchyEndorsement)
+	}
+	h.votes[vote.View][e.ReplicaId] = e
+	h.lock.Unlock()
+
+	h.tryLead()
+	return nil
+}
+
+// tryLead takes the lead of our group once we are the primary of its
+// instance, and f+1 members certified it, unless we lead it as its first
+// member in view 0
+func (h *hierarchy) tryLead() {
+	h.lock.Lock()
+	members := h.groups[h.group]
+	if h.leading || h.primary != h.id {
+		h.lock.Unlock()
+		return
+	}
+	var cert *LeaderCertificate
+	if h.view != 0 || h.id != members[0] {
+		votes := h.votes[h.view]
+		if len(votes) < (len(members)-1)/3+1 {
+			h.lock.Unlock()
+			return
+		}
+		cert = &LeaderCertificate{Group: uint32(h.group), View: h.view, Leader: h.id}
+		for _, e := range votes {
+			cert.Endorsements = append(cert.Endorsements, e)
+		}
+		sort.Sort(endorsementsBySigner(cert.Endorsements))
+	}
+	h.lock.Unlock()
+
+	top := h.startTop()
+
+	h.lock.Lock()
+	if h.leading || h.primary != h.id || (cert != nil && cert.View != h.view) {
+		h.lock.Unlock()
+		return
+	}
+	h.leading = true
+	h.leadership = cert
+	if cert != nil {
+		h.leaders[h.group] = cert
+	}
+	for _, entry := range h.upward {
+		entry.submitted = false
+	}
+	for _, entry := range h.downward {
+		entry.submitted = false
+	}
+	certs := h.certifyAllUpward()
+	deliveries := h.certifyAllDownward()
+	view := h.view
+	h.lock.Unlock()
+
+	logger.Notice("Replica %d leads group %d in view %d", h.id, h.group, view)
+	for _, raw := range certs {
+		h.submit(top, raw)
+	}
+	for _, raw := range deliveries {
+		h.submit(h.groupReplica, raw)
+	}
+}
+
+// startTop creates our instance of the top level the first time we lead our
+// group. Once created it keeps running, it only stops speaking for our
+// group while we do not lead it, as it would block a Receive after Close
+func (h *hierarchy) startTop() Replica {
+	h.topStart.Lock()
+	defer h.topStart.Unlock()
+	if top := h.top(); top != nil {
+		return top
+	}
+	// the instance calls back into the hierarchy as it starts, so it is not
+	// created under the lock
+	top := NewReplica(uint64(h.group), levelConfig(h.config, "top"), h.topLevel,
+		append(h.opts, WithN(len(h.groups), (len(h.groups)-1)/3))...)
+	h.lock.Lock()
+	h.topReplica = top
+	h.lock.Unlock()
+	return top
+}
+
+// submit hands raw to the instance of a level. The instances call into each
+// other as they execute, so they are never waited for
+func (h *hierarchy) submit(replica Replica, raw []byte) {
+	go func() {
+		if err := replica.Request(raw); err != nil {
+			logger.Error("Replica %d cannot submit to a level of the hierarchy: %s", h.id, err)
+		}
+	}()
+}
+
+// recvTop passes a message of the top level to our instance, if the sender
+// leads its group and we lead ours
+func (h *hierarchy) recvTop(msg []byte, leadership *LeaderCertificate, senderID uint64) error {
+	g, ok := h.groupOf[senderID]
+	if !ok {
+		return fmt.Errorf("Replica %d sent a top level message, but is not a member of the hierarchy", senderID)
+	}
+	if leadership != nil {
+		if err := h.verifyLeadership(leadership, senderID); err != nil {
+			return fmt.Errorf("Replica %d sent a top level message with an invalid leadership: %s", senderID, err)
+		}
+		h.learnLeader(leadership)
+	}
+	if leader := h.leaderOf(g); leader != senderID {
+		return fmt.Errorf("Replica %d sent a top level message, but replica %d leads group %d", senderID, leader, g)
+	}
+	top := h.top()
+	if top == nil {
+		return fmt.Errorf("Replica %d sent a top level message to replica %d, which never led its group", senderID, h.id)
+	}
+	return top.Receive(msg, uint64(g))
+}
+
+// =============================================================================
+// Between the levels
+// =============================================================================
+
+// send wraps msg for the receivers, by global ID
+func (h *hierarchy) send(hm *HierarchyMessage, receivers ...uint64) {
+	raw, err := proto.Marshal(hm)
+	if err != nil {
+		logger.Error("Cannot marshal hierarchy message: %s", err)
+		return
+	}
+	for _, id := range receivers {
+		if id == h.id {
+			continue
+		}
+		if err := h.stack.Unicast(raw, id); err != nil {
+			logger.Warning("Cannot send hierarchy message to replica %d: %s", id, err)
+		}
+	}
+}
+
+// validateGroupPayload is the Validate of the instance of the group
+func (h *hierarchy) validateGroupPayload(raw []byte) error {
+	gp := &GroupPayload{}
+	if err := proto.Unmarshal(raw, gp); err != nil {
+		return fmt.Errorf("Cannot unmarshal group payload: %s", err)
+	}
+	if gp.Delivery == nil {
+		return h.stack.Validate(gp.Request)
+	}
+	d := gp.Delivery
+	if err := h.verifyDelivery(d); err != nil {
+		return fmt.Errorf("Delivery of top level sequence number %d is not certified: %s", d.SequenceNumber, err)
+	}
+	if err := h.validateCertificate(d.Certificate); err != nil {
+		return fmt.Errorf("Delivery of top level sequence number %d carries an invalid certificate: %s", d.SequenceNumber, err)
+	}
+	return nil
+}
+
+// verifyDelivery checks that the leaders of f+1 groups endorsed that the top
+// level ordered the certificate of d at its sequence number, after the one
+// it names as previous
+func (h *hierarchy) verifyDelivery(d *TopDelivery) error {
+	if d.Previous >= d.SequenceNumber {
+		return fmt.Errorf("top level sequence number %d cannot follow %d", d.SequenceNumber, d.Previous)
+	}
+	statement := topStatement(d.SequenceNumber, d.Previous, hierarchyDigest(d.Certificate))
+	endorsed := make(map[int]bool)
+	var problems []string
+	for _, e := range d.Endorsements {
+		g, err := h.verifyTopEndorser(e, statement)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		endorsed[g] = true
+	}
+	if f := (len(h.groups) - 1) / 3; len(endorsed) < f+1 {
+		return fmt.Errorf("endorsed by the leaders of %d of the %d groups needed (%s)", len(endorsed), f+1, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// verifyTopEndorser checks that the signer of e led its group, and signed
+// statement, and returns its group
+func (h *hierarchy) verifyTopEndorser(e *HierarchyEndorsement, statement []byte) (int, error) {
+	g, ok := h.groupOf[e.ReplicaId]
+	if !ok {
+		return 0, fmt.Errorf("replica %d is not a member of the hierarchy", e.ReplicaId)
+	}
+	if e.Leadership == nil {
+		if e.ReplicaId != h.groups[g][0] {
+			return 0, fmt.Errorf("replica %d did not prove that it leads group %d", e.ReplicaId, g)
+		}
+	} else if err := h.verifyLeadership(e.Leadership, e.ReplicaId); err != nil {
+		return 0, fmt.Errorf("replica %d: %s", e.ReplicaId, err)
+	}
+	if err := h.stack.Verify(e.ReplicaId, e.Signature, statement); err != nil {
+		return 0, fmt.Errorf("replica %d: %s", e.ReplicaId, err)
+	}
+	return g, nil
+}
+
+// validateCertificate is the Validate of the top level instance
+func (h *hierarchy) validateCertificate(raw []byte) error {
+	cert := &GroupCertificate{}
+	if err := proto.Unmarshal(raw, cert); err != nil {
+		return fmt.Errorf("Cannot unmarshal group certificate: %s", err)
+	}
+	if err := h.verifyCertificate(cert); err != nil {
+		return err
+	}
+	return h.stack.Validate(cert.Payload)
+}
+
+// verifyCertificate checks that f+1 members of its group endorsed cert
+func (h *hierarchy) verifyCertificate(cert *GroupCertificate) error {
+	if int(cert.Group) >= len(h.groups) {
+		return fmt.Errorf("Certificate of unknown group %d", cert.Group)
+	}
+	digest := hierarchyDigest(cert.Payload)
+	if err := h.verifyEndorsements(h.groups[cert.Group], cert.Endorsements, func(e *HierarchyEndorsement) []byte {
+		return groupStatement(int(cert.Group), cert.SequenceNumber, digest)
+	}); err != nil {
+		return fmt.Errorf("Payload %d of group %d is not certified: %s", cert.SequenceNumber, cert.Group, err)
+	}
+	return nil
+}
+
+// executeGroup is the Execute of the instance of the group, it endorses the
+// payloads submitted to the group, and delivers the ones the top level ordered
+func (h *hierarchy) executeGroup(raw []byte) {
+	gp := &GroupPayload{}
+	if err := proto.Unmarshal(raw, gp); err != nil {
+		logger.Error("Cannot unmarshal group payload, which was validated before it was ordered: %s", err)
+		return
+	}
+	if gp.Delivery != nil {
+		h.deliver(gp.Delivery)
+		return
+	}
+
+	h.lock.Lock()
+	h.ordered++
+	index := h.ordered
+	h.pending[index] = gp.Request
+	h.lock.Unlock()
+
+	h.endorsePayload(index, gp.Request)
+}
+
+// endorsePayload signs that our group ordered payload as its index-th, for
+// its leader
+func (h *hierarchy) endorsePayload(index uint64, payload []byte) {
+	digest := hierarchyDigest(payload)
+	sig, err := h.stack.Sign(groupStatement(h.group, index, digest))
+	if err != nil {
+		logger.Error("Cannot endorse payload %d of group %d: %s", index, h.group, err)
+		return
+	}
+	e := &HierarchyEndorsement{SequenceNumber: index, Digest: digest, ReplicaId: h.id, Signature: sig}
+
+	h.lock.Lock()
+	primary := h.primary
+	h.lock.Unlock()
+	if primary == h.id {
+		h.recvUpward(e)
+		return
+	}
+	h.send(&HierarchyMessage{Endorsement: e}, primary)
+}
+
+// executeTop is the Execute of the top level instance, it endorses the order
+// of the group certificates to all replicas, while we lead our group. The
+// top level executed previous last, the sequence numbers in between carried
+// null requests
+func (h *hierarchy) executeTop(seqNo uint64, previous uint64, raw []byte) {
+	h.lock.Lock()
+	leading, leadership := h.leading, h.leadership
+	h.lock.Unlock()
+	if !leading {
+		return
+	}
+
+	digest := hierarchyDigest(raw)
+	sig, err := h.stack.Sign(topStatement(seqNo, previous, digest))
+	if err != nil {
+		logger.Error("Cannot endorse top level sequence number %d: %s", seqNo, err)
+		return
+	}
+	e := &HierarchyEndorsement{SequenceNumber: seqNo, Previous: previous, Digest: digest, ReplicaId: h.id, Signature: sig, Top: true, Certificate: raw, Leadership: leadership}
+	for _, members := range h.groups {
+		h.send(&HierarchyMessage{Endorsement: e}, members...)
+	}
+	h.recvDownward(e)
+}
+
+func (h *hierarchy) recvEndorsement(e *HierarchyEndorsement, senderID uint64) error {
+	if e.Top {
+		// members forward the endorsements of the top level to a new leader
+		if hierarchyDigest(e.Certificate) != e.Digest {
+			return fmt.Errorf("Replica %d sent an endorsement of top level sequence number %d without the certificate it endorses", senderID, e.SequenceNumber)
+		}
+		if e.Previous >= e.SequenceNumber {
+			return fmt.Errorf("Replica %d sent an endorsement of top level sequence number %d following %d", senderID, e.SequenceNumber, e.Previous)
+		}
+		if _, err := h.verifyTopEndorser(e, topStatement(e.SequenceNumber, e.Previous, e.Digest)); err != nil {
+			return fmt.Errorf("Endorsement of top level sequence number %d failed verification: %s", e.SequenceNumber, err)
+		}
+		h.recvDownward(e)
+		return nil
+	}
+	if e.ReplicaId != senderID {
+		return fmt.Errorf("Replica %d sent an endorsement of replica %d", senderID, e.ReplicaId)
+	}
+	if !contains(h.groups[h.group], e.ReplicaId) {
+		return fmt.Errorf("Replica %d endorsed a payload of group %d, which it is not a member of", e.ReplicaId, h.group)
+	}
+	if err := h.stack.Verify(e.ReplicaId, e.Signature, groupStatement(h.group, e.SequenceNumber, e.Digest)); err != nil {
+		return fmt.Errorf("Endorsement of payload %d by replica %d failed verification: %s", e.SequenceNumber, e.ReplicaId, err)
+	}
+	h.recvUpward(e)
+	return nil
+}
+
+type endorsementsBySigner []*HierarchyEndorsement
+
+func (s endorsementsBySigner) Len() int           { return len(s) }
+func (s endorsementsBySigner) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s endorsementsBySigner) Less(i, j int) bool { return s[i].ReplicaId < s[j].ReplicaId }
+
+// recvUpward collects the endorsement of a payload of our group, and
+// submits the payload to the top level once f+1 members endorsed it, if we
+// lead our group
+func (h *hierarchy) recvUpward(e *HierarchyEndorsement) {
+	h.lock.Lock()
+	index := e.SequenceNumber
+	if index < h.next[h.group] || h.ahead[h.group][index] {
+		h.lock.Unlock()
+		return
+	}
+	entry, ok := h.upward[index]
+	if !ok {
+		entry = newEndorsedRaw()
+		h.upward[index] = entry
+	}
+	entry.sigs[e.ReplicaId] = e
+	raw := h.certifyUpward(index)
+	top := h.topReplica
+	h.lock.Unlock()
+	if raw != nil {
+		h.submit(top, raw)
+	}
+}
+
+// certifyUpward returns the certificate of payload index of our group, once
+// we executed it and f+1 members agree with it, if we lead our group and did
+// not submit it yet. It must be called with the lock held
+func (h *hierarchy) certifyUpward(index uint64) []byte {
+	entry := h.upward[index]
+	payload, ok := h.pending[index]
+	if !h.leading || entry == nil || entry.submitted || !ok {
+		return nil
+	}
+	digest := hierarchyDigest(payload)
+	var matching []*HierarchyEndorsement
+	for _, e := range entry.sigs {
+		if e.Digest == digest {
+			matching = append(matching, e)
+		}
+	}
+	if len(matching) < (len(h.groups[h.group])-1)/3+1 {
+		return nil
+	}
+	sort.Sort(endorsementsBySigner(matching))
+	raw, err := proto.Marshal(&GroupCertificate{
+		Group:          uint32(h.group),
+		SequenceNumber: index,
+		Payload:        payload,
+		Endorsements:   matching,
+	})
+	if err != nil {
+		logger.Error("Cannot marshal certificate of payload %d of group %d: %s", index, h.group, err)
+		return nil
+	}
+	entry.submitted = true
+	logger.Debug("Submitting payload %d of group %d to the top level", index, h.group)
+	return raw
+}
+
+// certifyAllUpward certifies every payload which is ready, in order. It
+// must be called with the lock held
+func (h *hierarchy) certifyAllUpward() [][]byte {
+	var indices []uint64
+	for index := range h.upward {
+		indices = append(indices, index)
+	}
+	sort.Sort(sortableUint64Slice(indices))
+	var certs [][]byte
+	for _, index := range indices {
+		if raw := h.certifyUpward(index); raw != nil {
+			certs = append(certs, raw)
+		}
+	}
+	return certs
+}
+
+// recvDownward collects the endorsement of the top level order by a group
+// leader, and submits the certified order to our group once leaders of f+1
+// groups endorsed it, if we lead our group
+func (h *hierarchy) recvDownward(e *HierarchyEndorsement) {
+	h.lock.Lock()
+	if e.SequenceNumber <= h.lastTop {
+		h.lock.Unlock()
+		return
+	}
+	entry, ok := h.downward[e.SequenceNumber]
+	if !ok {
+		entry = newEndorsedRaw()
+		h.downward[e.SequenceNumber] = entry
+	}
+	entry.raws[topKey(e.Previous, e.Digest)] = e.Certificate
+	stripped := *e
+	stripped.Certificate = nil
+	entry.sigs[e.ReplicaId] = &stripped
+	raw := h.certifyDownward(e.SequenceNumber)
+	h.lock.Unlock()
+	if raw != nil {
+		h.submit(h.groupReplica, raw)
+	}
+}
+
+// certifyDownward returns the delivery of top level sequence number seqNo,
+// once leaders of f+1 groups endorsed the same certificate at it, if we lead
+// our group and did not submit it yet. It must be called with the lock held
+func (h *hierarchy) certifyDownward(seqNo uint64) []byte {
+	entry := h.downward[seqNo]
+	if !h.leading || entry == nil || entry.submitted {
+		return nil
+	}
+	var keys []string
+	for key := range entry.raws {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		endorsed := make(map[int]bool)
+		var matching []*HierarchyEndorsement
+		for _, e := range entry.sigs {
+			// the leaders a group had count once
+			if g := h.groupOf[e.ReplicaId]; topKey(e.Previous, e.Digest) == key && !endorsed[g] {
+				endorsed[g] = true
+				matching = append(matching, e)
+			}
+		}
+		if len(endorsed) < (len(h.groups)-1)/3+1 {
+			continue
+		}
+		sort.Sort(endorsementsBySigner(matching))
+		raw, err := proto.Marshal(&GroupPayload{Delivery: &TopDelivery{
+			SequenceNumber: seqNo,
+			Previous:       matching[0].Previous,
+			Certificate:    entry.raws[key],
+			Endorsements:   matching,
+		}})
+		if err != nil {
+			logger.Error("Cannot marshal delivery of top level sequence number %d: %s", seqNo, err)
+			return nil
+		}
+		entry.submitted = true
+		logger.Debug("Delivering top level sequence number %d to group %d", seqNo, h.group)
+		return raw
+	}
+	return nil
+}
+
+// certifyAllDownward certifies every top level sequence number which is
+// ready, in order. It must be called with the lock held
+func (h *hierarchy) certifyAllDownward() [][]byte {
+	var seqNos []uint64
+	for seqNo := range h.downward {
+		seqNos = append(seqNos, seqNo)
+	}
+	sort.Sort(sortableUint64Slice(seqNos))
+	var deliveries [][]byte
+	for _, seqNo := range seqNos {
+		if raw := h.certifyDownward(seqNo); raw != nil {
+			deliveries = append(deliveries, raw)
+		}
+	}
+	return deliveries
+}
+
+// deliver hands the payloads of the top level to the Stack in its order.
+// Every leader delivers every top level sequence number to its group, the
+// group may order them out of sequence, and a faulty or replaced leader may
+// submit a payload of its group twice, so deliveries are chained to the one
+// the top level executed before them, and payloads delivered before are
+// skipped
+func (h *hierarchy) deliver(d *TopDelivery) {
+	h.lock.Lock()
+	if _, ok := h.early[d.Previous]; d.SequenceNumber <= h.lastTop || ok {
+		h.lock.Unlock()
+		return
+	}
+	h.early[d.Previous] = d
+	var payloads [][]byte
+	for {
+		next, ok := h.early[h.lastTop]
+		if !ok {
+			break
+		}
+		delete(h.early, h.lastTop)
+		delete(h.downward, next.SequenceNumber)
+		h.lastTop = next.SequenceNumber
+		if payload := h.firstDelivery(next); payload != nil {
+			payloads = append(payloads, payload)
+		}
+	}
+	first := h.delivered + 1
+	h.delivered += uint64(len(payloads))
+	h.lock.Unlock()
+
+	if len(payloads) == 0 {
+		return
+	}
+	var state []byte
+	for i, payload := range payloads {
+		state = h.stack.Execute(first+uint64(i), payload)
+	}
+	if state == nil {
+		state = h.stack.GetState()
+	}
+	h.lock.Lock()
+	h.stackState = state
+	h.lock.Unlock()
+}
+
+// firstDelivery returns the payload of d, nil if its group delivered it
+// before. The certificate of the group is checked again, as the instance of
+// our group orders what its primary proposes, and relies on the members to
+// validate it. It must be called with the lock held
+func (h *hierarchy) firstDelivery(d *TopDelivery) []byte {
+	cert := &GroupCertificate{}
+	if err := proto.Unmarshal(d.Certificate, cert); err != nil {
+		logger.Error("Skipping top level sequence number %d, whose certificate cannot be unmarshaled: %s", d.SequenceNumber, err)
+		return nil
+	}
+	if err := h.verifyCertificate(cert); err != nil {
+		logger.Error("Skipping top level sequence number %d, whose certificate is invalid: %s", d.SequenceNumber, err)
+		return nil
+	}
+	g, index := cert.Group, cert.SequenceNumber
+	if index < h.next[g] || h.ahead[g][index] {
+		logger.Warning("Skipping payload %d of group %d at top level sequence number %d, it was delivered before", index, g, d.SequenceNumber)
+		return nil
+	}
+	h.ahead[g][index] = true
+	for h.ahead[g][h.next[g]] {
+		delete(h.ahead[g], h.next[g])
+		h.next[g]++
+	}
+	if int(g) == h.group {
+		delete(h.pending, index)
+		delete(h.upward, index)
+	}
+	return cert.Payload
+}
+
+// =============================================================================
+// State of the group level
+// =============================================================================
+
+// snapshot serializes the state of the group level after seqNo, and keeps
+// it for the members which transfer it, it returns its snapshot ID
+func (h *hierarchy) snapshot(seqNo uint64) ([]byte, []byte) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	hs := &HierarchyState{
+		Ordered:    h.ordered,
+		LastTop:    h.lastTop,
+		Delivered:  h.delivered,
+		StackState: h.stackState,
+	}
+	var seqNos []uint64
+	for n := range h.early {
+		seqNos = append(seqNos, n)
+	}
+	sort.Sort(sortableUint64Slice(seqNos))
+	for _, n := range seqNos {
+		hs.Early = append(hs.Early, h.early[n])
+	}
+	for g := range h.groups {
+		progress := &HierarchyProgress{Next: h.next[g]}
+		for index := range h.ahead[g] {
+			progress.Ahead = append(progress.Ahead, index)
+		}
+		sort.Sort(sortableUint64Slice(progress.Ahead))
+		hs.Progress = append(hs.Progress, progress)
+	}
+	var indices []uint64
+	for index := range h.pending {
+		indices = append(indices, index)
+	}
+	sort.Sort(sortableUint64Slice(indices))
+	for _, index := range indices {
+		hs.Pending = append(hs.Pending, &GroupCertificate{Group: uint32(h.group), SequenceNumber: index, Payload: h.pending[index]})
+	}
+	raw, err := proto.Marshal(hs)
+	if err != nil {
+		logger.Error("Cannot marshal the state of group %d: %s", h.group, err)
+		return nil, nil
+	}
+	return h.keepSnapshot(seqNo, raw), raw
+}
+
+// keepSnapshot keeps raw as the snapshot of seqNo, forgetting those the
+// instance can no longer ask for, and returns its ID. It must be called
+// with the lock held
+func (h *hierarchy) keepSnapshot(seqNo uint64, raw []byte) []byte {
+	id := sha256.Sum256(raw)
+	h.snapshots[string(id[:])] = &hierarchySnapshot{seqNo: seqNo, raw: raw}
+	for key, s := range h.snapshots {
+		if s.seqNo+h.window < seqNo {
+			delete(h.snapshots, key)
+		}
+	}
+	return id[:]
+}
+
+// adopt replaces the state of the group level by hs. It must be called with
+// the lock held
+func (h *hierarchy) adopt(hs *HierarchyState) {
+	h.ordered = hs.Ordered
+	h.lastTop = hs.LastTop
+	h.delivered = hs.Delivered
+	h.stackState = hs.StackState
+	h.early = make(map[uint64]*TopDelivery)
+	for _, d := range hs.Early {
+		h.early[d.Previous] = d
+	}
+	for g := range h.groups {
+		h.next[g] = 1
+		h.ahead[g] = make(map[uint64]bool)
+		if g < len(hs.Progress) {
+			h.next[g] = hs.Progress[g].Next
+			for _, index := range hs.Progress[g].Ahead {
+				h.ahead[g][index] = true
+			}
+		}
+	}
+	h.pending = make(map[uint64][]byte)
+	for _, p := range hs.Pending {
+		h.pending[p.SequenceNumber] = p.Payload
+	}
+	for index := range h.upward {
+		if index < h.next[h.group] || h.ahead[h.group][index] {
+			delete(h.upward, index)
+		}
+	}
+	for seqNo := range h.downward {
+		if seqNo <= h.lastTop {
+			delete(h.downward, seqNo)
+		}
+	}
+}
+
+// skipGroup asks the replicas of the instance of our group for the state
+// of the group level at seqNo
+func (h *hierarchy) skipGroup(seqNo uint64, snapshotID []byte, replicas []uint64) {
+	members := h.groups[h.group]
+	var targets []uint64
+	for _, r := range replicas {
+		if r < uint64(len(members)) {
+			targets = append(targets, members[r])
+		}
+	}
+	h.lock.Lock()
+	h.transfer = &hierarchyTransfer{seqNo: seqNo, snapshotID: snapshotID}
+	h.lock.Unlock()
+	logger.Info("Replica %d transferring the state of group %d at seqNo %d from replicas %v", h.id, h.group, seqNo, targets)
+	h.send(&HierarchyMessage{StateRequest: snapshotID}, targets...)
+}
+
+// recvStateRequest sends the snapshot a member asks for, if we kept it
+func (h *hierarchy) recvStateRequest(snapshotID []byte, senderID uint64) error {
+	if !contains(h.groups[h.group], senderID) {
+		return fmt.Errorf("Replica %d asked for the state of group %d, which it is not a member of", senderID, h.group)
+	}
+	h.lock.Lock()
+	s, ok := h.snapshots[string(snapshotID)]
+	h.lock.Unlock()
+	if !ok {
+		logger.Warning("Replica %d asked for the state of group %d with snapshot ID %x, which replica %d does not keep", senderID, h.group, snapshotID, h.id)
+		return nil
+	}
+	h.send(&HierarchyMessage{State: s.raw}, senderID)
+	return nil
+}
+
+// recvState adopts the state a member sent for the transfer under way. If
+// the Stack lags behind it, the Stack transfers its state first
+func (h *hierarchy) recvState(raw []byte, senderID uint64) error {
+	if !contains(h.groups[h.group], senderID) {
+		return fmt.Errorf("Replica %d sent the state of group %d, which it is not a member of", senderID, h.group)
+	}
+	h.lock.Lock()
+	t := h.transfer
+	if t == nil || t.state != nil {
+		h.lock.Unlock()
+		return nil
+	}
+	if id := sha256.Sum256(raw); !bytes.Equal(id[:], t.snapshotID) {
+		h.lock.Unlock()
+		return fmt.Errorf("Replica %d sent a state of group %d other than the one with snapshot ID %x", senderID, h.group, t.snapshotID)
+	}
+	hs := &HierarchyState{}
+	if err := proto.Unmarshal(raw, hs); err != nil || len(hs.Progress) != len(h.groups) {
+		h.lock.Unlock()
+		return fmt.Errorf("Replica %d sent a malformed state of group %d", senderID, h.group)
+	}
+	t.state = hs
+	t.raw = raw
+	behind := hs.Delivered > h.delivered
+	h.lock.Unlock()
+
+	if !behind {
+		h.completeTransfer()
+		return nil
+	}
+	var others []uint64
+	for _, member := range h.groups[h.group] {
+		if member != h.id {
+			others = append(others, member)
+		}
+	}
+	logger.Info("Replica %d transferring the state of its Stack after payload %d", h.id, hs.Delivered)
+	h.stack.SkipTo(hs.Delivered, hs.StackState, others)
+	return nil
+}
+
+// stackTransfer returns the transfer under way if the Stack transfers its
+// state after payload seqNo for it
+func (h *hierarchy) stackTransfer(seqNo uint64) *hierarchyTransfer {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if t := h.transfer; t != nil && t.state != nil && t.state.Delivered == seqNo {
+		return t
+	}
+	return nil
+}
+
+// completeTransfer adopts the transferred state of the group level, and
+// reports it to the instance of our group
+func (h *hierarchy) completeTransfer() {
+	h.lock.Lock()
+	t := h.transfer
+	if t == nil || t.state == nil {
+		h.lock.Unlock()
+		return
+	}
+	h.transfer = nil
+	h.adopt(t.state)
+	h.keepSnapshot(t.seqNo, t.raw)
+	delivered := h.delivered
+	h.lock.Unlock()
+
+	h.groupLevel.executedTo(t.seqNo, t.snapshotID)
+	h.groupLevel.persist(t.seqNo, t.raw)
+	logger.Info("Replica %d transferred the state of group %d at seqNo %d, %d payloads delivered", h.id, h.group, t.seqNo, delivered)
+	h.groupReplica.StateUpdated(t.seqNo, t.snapshotID)
+}
+
+// =============================================================================
+// hierarchyLevel, the Stack of the instance of a level
+// =============================================================================
+
+// hierarchyLevel adapts the Stack of a hierarchy to the instance of one of
+// its levels. The replicas of the group level are addressed by their index
+// among its members, those of the top level by the index of the group they
+// lead
+type hierarchyLevel struct {
+	h       *hierarchy
+	top     bool
+	members []uint64 // global IDs, by replica ID of the group level
+	prefix  string   // of the keys the instance persists
+	key     string   // of the hierarchy_level_state persisted as it executes
+
+	lock      sync.Mutex
+	lastSeqNo uint64
+	executed  bool
+	state     []byte // snapshot ID of the group level, topState of the top level
+}
+
+// size is the number of replicas of the level
+func (hl *hierarchyLevel) size() int {
+	if hl.top {
+		return len(hl.h.groups)
+	}
+	return len(hl.members)
+}
+
+// replica returns the global ID of replica id of the level
+func (hl *hierarchyLevel) replica(id uint64) (uint64, error) {
+	if id >= uint64(hl.size()) {
+		return 0, fmt.Errorf("Replica %d is out of range for a level of %d replicas", id, hl.size())
+	}
+	if hl.top {
+		return hl.h.leaderOf(int(id)), nil
+	}
+	return hl.members[id], nil
+}
+
+// wrap returns msg as a message of the level, false if we may not send it
+// as we do not lead our group
+func (hl *hierarchyLevel) wrap(msg []byte) (*HierarchyMessage, bool) {
+	if !hl.top {
+		return &HierarchyMessage{Group: msg}, true
+	}
+	hl.h.lock.Lock()
+	defer hl.h.lock.Unlock()
+	return &HierarchyMessage{Top: msg, Leader: hl.h.leadership}, hl.h.leading
+}
+
+// Broadcast is necessary to implement Stack
+func (hl *hierarchyLevel) Broadcast(msg []byte) {
+	hm, ok := hl.wrap(msg)
+	if !ok {
+		return
+	}
+	for id := 0; id < hl.size(); id++ {
+		if receiver, err := hl.replica(uint64(id)); err == nil {
+			hl.h.send(hm, receiver)
+		}
+	}
+}
+
+// Unicast is necessary to implement Stack
+func (hl *hierarchyLevel) Unicast(msg []byte, receiverID uint64) error {
+	receiver, err := hl.replica(receiverID)
+	if err != nil {
+		return err
+	}
+	if hm, ok := hl.wrap(msg); ok {
+		hl.h.send(hm, receiver)
+	}
+	return nil
+}
+
+// Validate is necessary to implement Stack
+func (hl *hierarchyLevel) Validate(payload []byte) error {
+	if hl.top {
+		return hl.h.validateCertificate(payload)
+	}
+	return hl.h.validateGroupPayload(payload)
+}
+
+// Execute is necessary to implement Stack, the state the level reaches is
+// persisted once its effects were carried out
+func (hl *hierarchyLevel) Execute(seqNo uint64, payload []byte) []byte {
+	if hl.top {
+		hl.lock.Lock()
+		previous := topPrevious(hl.state)
+		chained := sha256.Sum256(append(append([]byte(nil), hl.state...), payload...))
+		hl.lock.Unlock()
+		state := topState(seqNo, chained[:])
+		hl.h.executeTop(seqNo, previous, payload)
+		hl.executedTo(seqNo, state)
+		hl.persist(seqNo, state)
+		return state
+	}
+
+	hl.h.executeGroup(payload)
+	id, raw := hl.h.snapshot(seqNo)
+	hl.executedTo(seqNo, id)
+	hl.persist(seqNo, raw)
+	return id
+}
+
+// topState is the state of the top level after it executed seqNo, the hash
+// chain of the certificates it executed prefixed by seqNo, so that the
+// execution which follows a state transfer knows its predecessor
+func topState(seqNo uint64, chain []byte) []byte {
+	state := make([]byte, 8, 8+len(chain))
+	binary.BigEndian.PutUint64(state, seqNo)
+	return append(state, chain...)
+}
+
+// topPrevious returns the sequence number the top level executed last
+func topPrevious(state []byte) uint64 {
+	if len(state) < 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(state)
+}
+
+// executedTo records that the level reached state at seqNo
+func (hl *hierarchyLevel) executedTo(seqNo uint64, state []byte) {
+	hl.lock.Lock()
+	defer hl.lock.Unlock()
+	hl.lastSeqNo = seqNo
+	hl.executed = true
+	hl.state = state
+}
+
+// persist stores the state of the level at seqNo, the serialized
+// hierarchy_state of the group level, the topState of the top level
+func (hl *hierarchyLevel) persist(seqNo uint64, state []byte) {
+	raw, err := proto.Marshal(&HierarchyLevelState{SequenceNumber: seqNo, State: state})
+	if err == nil {
+		err = hl.h.stack.StoreState(hl.key, raw)
+	}
+	if err != nil {
+		logger.Error("Replica %d cannot persist the state of its level at seqNo %d: %s", hl.h.id, seqNo, err)
+	}
+}
+
+// restored returns the state the level persisted last, if any
+func (hl *hierarchyLevel) restored() (uint64, []byte, bool) {
+	raw, err := hl.h.stack.ReadState(hl.key)
+	if err != nil {
+		return 0, nil, false
+	}
+	ls := &HierarchyLevelState{}
+	if err := proto.Unmarshal(raw, ls); err != nil {
+		logger.Error("Replica %d cannot unmarshal the persisted state of its level: %s", hl.h.id, err)
+		return 0, nil, false
+	}
+	return ls.SequenceNumber, ls.State, true
+}
+
+// GetState is necessary to implement Stack
+func (hl *hierarchyLevel) GetState() []byte {
+	hl.lock.Lock()
+	defer hl.lock.Unlock()
+	return hl.state
+}
+
+// GetLastSeqNo is necessary to implement Stack
+func (hl *hierarchyLevel) GetLastSeqNo() (uint64, error) {
+	hl.lock.Lock()
+	defer hl.lock.Unlock()
+	if !hl.executed {
+		return 0, fmt.Errorf("no execution yet")
+	}
+	return hl.lastSeqNo, nil
+}
+
+// SkipTo is necessary to implement Stack. The group level transfers its
+// state from the other members, the state of the top level is the snapshot
+// ID already
+func (hl *hierarchyLevel) SkipTo(seqNo uint64, snapshotID []byte, replicas []uint64) {
+	if !hl.top {
+		hl.h.skipGroup(seqNo, snapshotID, replicas)
+		return
+	}
+	hl.executedTo(seqNo, snapshotID)
+	hl.persist(seqNo, snapshotID)
+	top := hl.h.top()
+	go func() {
+		top.StateUpdating(seqNo, snapshotID)
+		top.StateUpdated(seqNo, snapshotID)
+	}()
+}
+
+// primaryChanged is necessary to implement primaryObserver, the primary of
+// the group level leads the group
+func (hl *hierarchyLevel) primaryChanged(view uint64, primary uint64) {
+	if !hl.top {
+		hl.h.primaryChanged(view, hl.members[primary])
+	}
+}
+
+// Sign is necessary to implement Stack
+func (hl *hierarchyLevel) Sign(msg []byte) ([]byte, error) {
+	return hl.h.stack.Sign(msg)
+}
+
+// Verify is necessary to implement Stack
+func (hl *hierarchyLevel) Verify(senderID uint64, signature []byte, msg []byte) error {
+	sender, err := hl.replica(senderID)
+	if err != nil {
+		return err
+	}
+	return hl.h.stack.Verify(sender, signature, msg)
+}
+
+// StoreState is necessary to implement Persistor
+func (hl *hierarchyLevel) StoreState(key string, value []byte) error {
+	return hl.h.stack.StoreState(hl.prefix+key, value)
+}
+
+// ReadState is necessary to implement Persistor
+func (hl *hierarchyLevel) ReadState(key string) ([]byte, error) {
+	return hl.h.stack.ReadState(hl.prefix + key)
+}
+
+// ReadStateSet is necessary to implement Persistor
+func (hl *hierarchyLevel) ReadStateSet(prefix string) (map[string][]byte, error) {
+	set, err := hl.h.stack.ReadStateSet(hl.prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+	stripped := make(map[string][]byte, len(set))
+	for key, value := range set {
+		stripped[strings.TrimPrefix(key, hl.prefix)] = value
+	}
+	return stripped, nil
+}
+
+// DelState is necessary to implement Persistor
+func (hl *hierarchyLevel) DelState(key string) {
+	hl.h.stack.DelState(hl.prefix + key)
+}
@@ -0,0 +1,501 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// hierarchyNet connects the members of a hierarchical network, replicas
+// which are down neither send nor receive
+type hierarchyNet struct {
+	started  chan struct{}
+	lock     sync.Mutex
+	replicas []Replica
+	down     map[uint64]bool
+}
+
+func newHierarchyNet(N int) *hierarchyNet {
+	return &hierarchyNet{started: make(chan struct{}), replicas: make([]Replica, N), down: make(map[uint64]bool)}
+}
+
+func (net *hierarchyNet) replica(id uint64) Replica {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	if net.down[id] {
+		return nil
+	}
+	return net.replicas[id]
+}
+
+func (net *hierarchyNet) setDown(id uint64, down bool) {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	net.down[id] = down
+}
+
+func (net *hierarchyNet) set(id uint64, replica Replica) {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	net.replicas[id] = replica
+}
+
+// hierarchyStack is the Stack of a member of a hierarchical network, its
+// signatures name the replica which made them
+type hierarchyStack struct {
+	id       uint64
+	net      *hierarchyNet
+	lock     sync.Mutex
+	store    mockPersist
+	executed [][]byte
+	seqNos   []uint64
+	skipped  uint64 // payload the Stack transferred its state after
+}
+
+func (hs *hierarchyStack) Broadcast(msg []byte) {
+	panic("hierarchical replicas address their levels only")
+}
+
+func (hs *hierarchyStack) Unicast(msg []byte, receiverID uint64) error {
+	go func() {
+		<-hs.net.started
+		if hs.net.replica(hs.id) == nil {
+			return
+		}
+		if receiver := hs.net.replica(receiverID); receiver != nil {
+			receiver.Receive(msg, hs.id)
+		}
+	}()
+	return nil
+}
+
+func (hs *hierarchyStack) Validate(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("empty payload")
+	}
+	return nil
+}
+
+func (hs *hierarchyStack) Execute(seqNo uint64, payload []byte) []byte {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.executed = append(hs.executed, payload)
+	hs.seqNos = append(hs.seqNos, seqNo)
+	return []byte(fmt.Sprintf("after %d", seqNo))
+}
+
+func (hs *hierarchyStack) GetState() []byte {
+	return nil
+}
+
+func (hs *hierarchyStack) GetLastSeqNo() (uint64, error) {
+	return 0, fmt.Errorf("no execution yet")
+}
+
+func (hs *hierarchyStack) SkipTo(seqNo uint64, snapshotID []byte, replicas []uint64) {
+	hs.lock.Lock()
+	hs.skipped = seqNo
+	hs.lock.Unlock()
+	go func() {
+		replica := hs.net.replica(hs.id)
+		replica.StateUpdating(seqNo, snapshotID)
+		replica.StateUpdated(seqNo, snapshotID)
+	}()
+}
+
+func (hs *hierarchyStack) Sign(msg []byte) ([]byte, error) {
+	return append([]byte(fmt.Sprintf("replica %d:", hs.id)), msg...), nil
+}
+
+func (hs *hierarchyStack) Verify(senderID uint64, signature []byte, msg []byte) error {
+	if !bytes.Equal(signature, append([]byte(fmt.Sprintf("replica %d:", senderID)), msg...)) {
+		return fmt.Errorf("invalid signature of replica %d", senderID)
+	}
+	return nil
+}
+
+// both levels persist their state, the persistence is shared among threads
+
+func (hs *hierarchyStack) StoreState(key string, value []byte) error {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	return hs.store.StoreState(key, value)
+}
+
+func (hs *hierarchyStack) ReadState(key string) ([]byte, error) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	return hs.store.ReadState(key)
+}
+
+func (hs *hierarchyStack) ReadStateSet(prefix string) (map[string][]byte, error) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	return hs.store.ReadStateSet(prefix)
+}
+
+func (hs *hierarchyStack) DelState(key string) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.store.DelState(key)
+}
+
+func (hs *hierarchyStack) executions() ([][]byte, []uint64) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	return append([][]byte(nil), hs.executed...), append([]uint64(nil), hs.seqNos...)
+}
+
+// startHierarchy creates the replicas of a hierarchical network of groups
+func startHierarchy(t *testing.T, groups [][]uint64, config *viper.Viper, opts ...Option) (*hierarchyNet, []*hierarchyStack) {
+	N := 0
+	for _, members := range groups {
+		N += len(members)
+	}
+	net := newHierarchyNet(N)
+	stacks := make([]*hierarchyStack, N)
+	for id := range stacks {
+		stacks[id] = &hierarchyStack{id: uint64(id), net: net}
+		replica, err := NewHierarchicalReplica(uint64(id), groups, config, stacks[id], opts...)
+		if err != nil {
+			t.Fatalf("Failed to create replica %d: %s", id, err)
+		}
+		net.set(uint64(id), replica)
+	}
+	close(net.started)
+	return net, stacks
+}
+
+func (net *hierarchyNet) close() {
+	for id := range net.replicas {
+		if replica := net.replica(uint64(id)); replica != nil {
+			replica.Close()
+		}
+	}
+}
+
+// awaitExecutions waits for the stacks of ids to execute n payloads each
+func awaitExecutions(t *testing.T, stacks []*hierarchyStack, ids []int, n int) {
+	deadline := time.Now().Add(20 * time.Second)
+	for _, id := range ids {
+		for {
+			executed, _ := stacks[id].executions()
+			if len(executed) >= n {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected replica %d to execute %d payloads, it executed %q", id, n, executed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestHierarchicalReplicas(t *testing.T) {
+	groups := [][]uint64{{0, 1, 2, 3}, {4, 5, 6, 7}}
+	net, stacks := startHierarchy(t, groups, loadConfig())
+	defer net.close()
+
+	if err := net.replica(5).Request(nil); err == nil {
+		t.Error("Expected a payload the stack rejects not to be ordered")
+	}
+	for _, id := range []uint64{2, 5} {
+		if err := net.replica(id).Request([]byte(fmt.Sprintf("from %d", id))); err != nil {
+			t.Fatalf("Failed to submit request: %s", err)
+		}
+	}
+	all := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	awaitExecutions(t, stacks, all, 2)
+	expected, _ := stacks[0].executions()
+	for _, id := range all {
+		if executed, seqNos := stacks[id].executions(); !reflect.DeepEqual(executed, expected) || !reflect.DeepEqual(seqNos, []uint64{1, 2}) {
+			t.Errorf("Replica %d executed %q at %v, replica 0 executed %q", id, executed, seqNos, expected)
+		}
+	}
+	if len(expected) != 2 {
+		t.Errorf("Expected both payloads to be executed once, got %q", expected)
+	}
+}
+
+// TestHierarchyLeaderCrash checks that the group of a leader which crashed
+// elects the next primary of its instance as leader, and keeps ordering
+func TestHierarchyLeaderCrash(t *testing.T) {
+	groups := [][]uint64{{0, 1, 2, 3}, {4, 5, 6, 7}}
+	net, stacks := startHierarchy(t, groups, loadConfig(), WithTimeouts(500*time.Millisecond, time.Second, 0))
+	defer net.close()
+
+	crashed := net.replica(0)
+	net.setDown(0, true)
+	crashed.Close()
+
+	for _, id := range []uint64{1, 5} {
+		if err := net.replica(id).Request([]byte(fmt.Sprintf("from %d", id))); err != nil {
+			t.Fatalf("Failed to submit request: %s", err)
+		}
+	}
+	live := []int{1, 2, 3, 4, 5, 6, 7}
+	awaitExecutions(t, stacks, live, 2)
+	expected, _ := stacks[1].executions()
+	for _, id := range live {
+		if executed, _ := stacks[id].executions(); !reflect.DeepEqual(executed, expected) {
+			t.Errorf("Replica %d executed %q, replica 1 executed %q", id, executed, expected)
+		}
+	}
+	if len(expected) != 2 {
+		t.Errorf("Expected both payloads to be executed once, got %q", expected)
+	}
+	if leader := net.replica(1).(*hierarchy); !leader.isLeading() {
+		t.Errorf("Expected replica 1, the primary of group 0 after the crash, to lead it")
+	}
+}
+
+// TestHierarchyRestart checks that a member which restarts recovers the
+// state of its levels, rather than delivering the payloads of its group
+// anew
+func TestHierarchyRestart(t *testing.T) {
+	groups := [][]uint64{{0, 1, 2, 3}, {4, 5, 6, 7}}
+	net, stacks := startHierarchy(t, groups, loadConfig())
+	defer net.close()
+
+	if err := net.replica(2).Request([]byte("before")); err != nil {
+		t.Fatalf("Failed to submit request: %s", err)
+	}
+	awaitExecutions(t, stacks, []int{0, 1, 2, 3, 4, 5, 6, 7}, 1)
+
+	restarted := net.replica(6)
+	net.setDown(6, true)
+	restarted.Close()
+	replica, err := NewHierarchicalReplica(6, groups, loadConfig(), stacks[6])
+	if err != nil {
+		t.Fatalf("Failed to restart replica 6: %s", err)
+	}
+	if h := replica.(*hierarchy); h.delivered != 1 || h.lastTop != 1 {
+		t.Errorf("Expected replica 6 to restore the delivery of the first payload, it delivered %d up to top level sequence number %d", h.delivered, h.lastTop)
+	}
+	net.set(6, replica)
+	net.setDown(6, false)
+
+	if err := net.replica(5).Request([]byte("after")); err != nil {
+		t.Fatalf("Failed to submit request: %s", err)
+	}
+	awaitExecutions(t, stacks, []int{0, 1, 2, 3, 4, 5, 6, 7}, 2)
+	if executed, seqNos := stacks[6].executions(); !reflect.DeepEqual(seqNos, []uint64{1, 2}) {
+		t.Errorf("Expected the restarted replica 6 to execute the second payload only, it executed %q at %v", executed, seqNos)
+	}
+}
+
+// TestHierarchyStateTransfer checks that a member which fell behind the
+// log of its group transfers the state of the group level, and the one of
+// its Stack, from the other members
+func TestHierarchyStateTransfer(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	config.Set("general.catchupgap", 0) // fall behind too far to catch up through commit certificates
+	groups := [][]uint64{{0, 1, 2, 3}, {4, 5, 6, 7}}
+	net, stacks := startHierarchy(t, groups, config)
+	defer net.close()
+
+	net.setDown(6, true)
+	up := []int{0, 1, 2, 3, 4, 5, 7}
+	for i := 1; i <= 6; i++ {
+		if err := net.replica(5).Request([]byte(fmt.Sprintf("payload %d", i))); err != nil {
+			t.Fatalf("Failed to submit request: %s", err)
+		}
+		awaitExecutions(t, stacks, up, i)
+	}
+	net.setDown(6, false)
+
+	for i := 7; i <= 10; i++ {
+		if err := net.replica(5).Request([]byte(fmt.Sprintf("payload %d", i))); err != nil {
+			t.Fatalf("Failed to submit request: %s", err)
+		}
+		awaitExecutions(t, stacks, up, i)
+	}
+	deadline := time.Now().Add(20 * time.Second)
+	for {
+		_, seqNos := stacks[6].executions()
+		stacks[6].lock.Lock()
+		skipped := stacks[6].skipped
+		stacks[6].lock.Unlock()
+		if skipped != 0 && len(seqNos) > 0 && seqNos[len(seqNos)-1] == 10 {
+			for i, seqNo := range seqNos {
+				if seqNo != skipped+uint64(i)+1 {
+					t.Errorf("Expected replica 6 to execute the payloads after %d in sequence, it executed %v", skipped, seqNos)
+				}
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected replica 6 to transfer its state and catch up to payload 10, it transferred after %d and executed %v", skipped, seqNos)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHierarchyMembership(t *testing.T) {
+	stack := &hierarchyStack{net: newHierarchyNet(2)}
+	for _, tc := range []struct {
+		groups  [][]uint64
+		problem string
+	}{
+		{[][]uint64{{0, 1}, {}}, "has no members"},
+		{[][]uint64{{0, 1}, {1, 2}}, "more than one group"},
+		{[][]uint64{{1, 2}}, "not a member of any group"},
+	} {
+		if _, err := NewHierarchicalReplica(0, tc.groups, loadConfig(), stack); err == nil || !strings.Contains(err.Error(), tc.problem) {
+			t.Errorf("Expected groups %v to be rejected as %q, got %v", tc.groups, tc.problem, err)
+		}
+	}
+}
+
+// newTestHierarchy returns replica id of a hierarchy of groups, without
+// the instances of its levels
+func newTestHierarchy(id uint64, groups [][]uint64) *hierarchy {
+	h := &hierarchy{
+		id:       id,
+		stack:    &hierarchyStack{id: id},
+		groups:   groups,
+		groupOf:  make(map[uint64]int),
+		leaders:  make([]*LeaderCertificate, len(groups)),
+		next:     make([]uint64, len(groups)),
+		ahead:    make([]map[uint64]bool, len(groups)),
+		pending:  make(map[uint64][]byte),
+		upward:   make(map[uint64]*endorsedRaw),
+		downward: make(map[uint64]*endorsedRaw),
+	}
+	for g, members := range groups {
+		for _, member := range members {
+			h.groupOf[member] = g
+			if member == id {
+				h.group = g
+			}
+		}
+		h.next[g] = 1
+		h.ahead[g] = make(map[uint64]bool)
+	}
+	return h
+}
+
+func hierarchyEndorse(signer uint64, statement []byte) *HierarchyEndorsement {
+	sig, _ := (&hierarchyStack{id: signer}).Sign(statement)
+	return &HierarchyEndorsement{ReplicaId: signer, Signature: sig}
+}
+
+func TestHierarchyValidatesCertificates(t *testing.T) {
+	h := newTestHierarchy(4, [][]uint64{{0, 1, 2, 3}, {4, 5, 6, 7}})
+
+	payload := []byte("payload")
+	statement := groupStatement(0, 1, hierarchyDigest(payload))
+	cert := &GroupCertificate{Group: 0, SequenceNumber: 1, Payload: payload, Endorsements: []*HierarchyEndorsement{
+		hierarchyEndorse(1, statement),
+		hierarchyEndorse(5, statement),
+	}}
+	raw, _ := proto.Marshal(cert)
+	if err := h.validateCertificate(raw); err == nil {
+		t.Errorf("Expected a certificate endorsed by a single member of the group to be rejected")
+	}
+	cert.Endorsements = append(cert.Endorsements, hierarchyEndorse(2, statement))
+	raw, _ = proto.Marshal(cert)
+	if err := h.validateCertificate(raw); err != nil {
+		t.Errorf("Expected a certificate endorsed by f+1 members of the group to be accepted, got %s", err)
+	}
+
+	topDigest := hierarchyDigest(raw)
+	delivery := &TopDelivery{SequenceNumber: 1, Certificate: raw, Endorsements: []*HierarchyEndorsement{
+		hierarchyEndorse(0, topStatement(2, 0, topDigest)),
+		hierarchyEndorse(5, topStatement(1, 0, topDigest)),
+	}}
+	gpRaw, _ := proto.Marshal(&GroupPayload{Delivery: delivery})
+	if err := h.validateGroupPayload(gpRaw); err == nil {
+		t.Errorf("Expected a delivery endorsed at another sequence number, and by a replica which leads no group, to be rejected")
+	}
+	delivery.Endorsements[1] = hierarchyEndorse(4, topStatement(1, 0, topDigest))
+	gpRaw, _ = proto.Marshal(&GroupPayload{Delivery: delivery})
+	if err := h.validateGroupPayload(gpRaw); err != nil {
+		t.Errorf("Expected a delivery endorsed by f+1 leaders to be accepted, got %s", err)
+	}
+	if delivered := h.firstDelivery(delivery); !bytes.Equal(delivered, payload) {
+		t.Errorf("Expected the certified payload to be delivered, got %q", delivered)
+	}
+}
+
+// TestHierarchyRejectsForgedInnerCertificates checks that a delivery the
+// leaders of f+1 groups endorsed is rejected when the group certificate it
+// carries is not, as with fewer than 4 groups a single leader endorses it
+func TestHierarchyRejectsForgedInnerCertificates(t *testing.T) {
+	h := newTestHierarchy(4, [][]uint64{{0, 1, 2, 3}, {4, 5, 6, 7}})
+
+	payload := []byte("forged")
+	forged := &GroupCertificate{Group: 0, SequenceNumber: 1, Payload: payload, Endorsements: []*HierarchyEndorsement{
+		hierarchyEndorse(0, groupStatement(0, 1, hierarchyDigest(payload))),
+	}}
+	raw, _ := proto.Marshal(forged)
+	digest := hierarchyDigest(raw)
+	delivery := &TopDelivery{SequenceNumber: 1, Certificate: raw, Endorsements: []*HierarchyEndorsement{
+		hierarchyEndorse(0, topStatement(1, 0, digest)),
+	}}
+	if err := h.verifyDelivery(delivery); err != nil {
+		t.Fatalf("Expected the delivery to be endorsed by f+1 leaders, got %s", err)
+	}
+	gpRaw, _ := proto.Marshal(&GroupPayload{Delivery: delivery})
+	if err := h.validateGroupPayload(gpRaw); err == nil || !strings.Contains(err.Error(), "invalid certificate") {
+		t.Errorf("Expected a delivery of a certificate the group did not endorse to be rejected, got %v", err)
+	}
+	if delivered := h.firstDelivery(delivery); delivered != nil {
+		t.Errorf("Expected a delivery of a certificate the group did not endorse to be skipped, got %q", delivered)
+	}
+	if h.next[0] != 1 || len(h.ahead[0]) != 0 {
+		t.Errorf("Expected the skipped delivery not to count as delivered")
+	}
+}
+
+// TestHierarchyVerifiesLeadership checks that the top level endorsements of
+// a replica other than the first member of its group count once f+1 members
+// certified it as leader
+func TestHierarchyVerifiesLeadership(t *testing.T) {
+	h := newTestHierarchy(0, [][]uint64{{0, 1, 2, 3}, {4, 5, 6, 7}})
+
+	statement := topStatement(1, 0, "digest")
+	e := hierarchyEndorse(5, statement)
+	if _, err := h.verifyTopEndorser(e, statement); err == nil {
+		t.Errorf("Expected the endorsement of a replica which did not prove its leadership to be rejected")
+	}
+	e.Leadership = &LeaderCertificate{Group: 1, View: 1, Leader: 5, Endorsements: []*HierarchyEndorsement{
+		hierarchyEndorse(6, leaderStatement(1, 1, 5)),
+	}}
+	if _, err := h.verifyTopEndorser(e, statement); err == nil {
+		t.Errorf("Expected the endorsement of a replica certified by a single member to be rejected")
+	}
+	e.Leadership.Endorsements = append(e.Leadership.Endorsements, hierarchyEndorse(7, leaderStatement(1, 2, 5)))
+	if _, err := h.verifyTopEndorser(e, statement); err == nil {
+		t.Errorf("Expected the endorsement of a replica certified for another view to be rejected")
+	}
+	e.Leadership.Endorsements[1] = hierarchyEndorse(7, leaderStatement(1, 1, 5))
+	if g, err := h.verifyTopEndorser(e, statement); err != nil || g != 1 {
+		t.Errorf("Expected the endorsement of a replica f+1 members certified to count for group 1, got %d, %v", g, err)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ReplicaState is a snapshot of the state of a replica, the consenters report
+// it as a ReplicaState
+type ReplicaState struct {
+	ReplicaID           uint64
+	View                uint64
+	Epoch               uint64
+	ActiveView          bool
+	Primary             uint64
+	SeqNo               uint64
+	LastExec            uint64
+	LowWatermark        uint64
+	LogSize             uint64
+	CheckpointPeriod    uint64
+	OutstandingRequests int
+	StateTransfer       bool
+
+	ViewChanges []ViewChangeVote          // view changes received for views the replica has not installed
+	Checkpoints map[uint64]string         // checkpoints taken by the replica, by sequence number
+	Votes       map[uint64][]CheckpointID // checkpoints received from the replicas, by sequence number
+}
+
+// ViewChangeVote is a view change a replica sent
+type ViewChangeVote struct {
+	View      uint64
+	ReplicaID uint64
+}
+
+// CheckpointID is a checkpoint a replica sent
+type CheckpointID struct {
+	ReplicaID uint64
+	ID        string
+}
+
+// RequestInspection returns a snapshot of the state of the replica, taken
+// on the event thread between two events
+func (instance *PbftCore) RequestInspection() (*ReplicaState, error) {
+	done := make(chan *ReplicaState, 1)
+	instance.Inject(func() {
+		done <- instance.inspect()
+	})
+	return <-done, nil
+}
+
+// inspect takes a snapshot of the state of the replica, it must only be
+// called from the event thread
+func (instance *PbftCore) inspect() *ReplicaState {
+	state := &ReplicaState{
+		ReplicaID:           instance.ID,
+		View:                instance.View,
+		Epoch:               instance.epoch,
+		ActiveView:          instance.ActiveView,
+		Primary:             instance.Primary(instance.View),
+		SeqNo:               instance.seqNo,
+		LastExec:            instance.LastExec,
+		LowWatermark:        instance.h,
+		LogSize:             instance.L,
+		CheckpointPeriod:    instance.K,
+		OutstandingRequests: len(instance.OutstandingReqs),
+		StateTransfer:       instance.SkipInProgress,
+		Checkpoints:         make(map[uint64]string),
+		Votes:               make(map[uint64][]CheckpointID),
+	}
+
+	for idx := range instance.viewChangeStore {
+		if idx.v > instance.View || (idx.v == instance.View && !instance.ActiveView) {
+			state.ViewChanges = append(state.ViewChanges, ViewChangeVote{View: idx.v, ReplicaID: idx.id})
+		}
+	}
+	sort.Sort(viewChangeVotes(state.ViewChanges))
+
+	for n, id := range instance.chkpts {
+		state.Checkpoints[n] = id
+	}
+	for chkpt := range instance.checkpointStore {
+		state.Votes[chkpt.SequenceNumber] = append(state.Votes[chkpt.SequenceNumber], CheckpointID{ReplicaID: chkpt.ReplicaId, ID: chkpt.Id})
+	}
+	for _, votes := range state.Votes {
+		sort.Sort(checkpointIDs(votes))
+	}
+	return state
+}
+
+// ReportState hands report the view, epoch, last execution and low watermark
+// of the replica after every event it processes, the other fields of the
+// ReplicaState are left empty. It is invoked on the event thread, so that
+// test networks can monitor the replicas without waiting for them
+func (instance *PbftCore) ReportState(report func(state *ReplicaState)) {
+	instance.Manager.(instrumentedManager).instrument(&eventHooks{
+		done: func(event interface{}, elapsed time.Duration) {
+			report(&ReplicaState{
+				View:         instance.View,
+				Epoch:        instance.epoch,
+				LastExec:     instance.LastExec,
+				LowWatermark: instance.h,
+			})
+		},
+	})
+}
+
+// ClassifyMessage decodes the type, named like the keys of
+// general.ratelimit.types, view and sequence number of a consensus message,
+// ok is false if payload is not one
+func ClassifyMessage(payload []byte) (kind string, view uint64, seqNo uint64, ok bool) {
+	msg := &Message{}
+	if err := proto.Unmarshal(payload, msg); err != nil || msg.Payload == nil {
+		return "", 0, 0, false
+	}
+	switch m := msg.Payload.(type) {
+	case *Message_PrePrepare:
+		view, seqNo = m.PrePrepare.View, m.PrePrepare.SequenceNumber
+	case *Message_Prepare:
+		view, seqNo = m.Prepare.View, m.Prepare.SequenceNumber
+	case *Message_Commit:
+		view, seqNo = m.Commit.View, m.Commit.SequenceNumber
+	case *Message_Checkpoint:
+		seqNo = m.Checkpoint.SequenceNumber
+	case *Message_ViewChange:
+		view, seqNo = m.ViewChange.View, m.ViewChange.H
+	case *Message_NewView:
+		view = m.NewView.View
+	}
+	return messageKind(msg), view, seqNo, true
+}
+
+type viewChangeVotes []ViewChangeVote
+
+func (a viewChangeVotes) Len() int      { return len(a) }
+func (a viewChangeVotes) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a viewChangeVotes) Less(i, j int) bool {
+	if a[i].View != a[j].View {
+		return a[i].View < a[j].View
+	}
+	return a[i].ReplicaID < a[j].ReplicaID
+}
+
+type checkpointIDs []CheckpointID
+
+func (a checkpointIDs) Len() int           { return len(a) }
+func (a checkpointIDs) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a checkpointIDs) Less(i, j int) bool { return a[i].ReplicaID < a[j].ReplicaID }
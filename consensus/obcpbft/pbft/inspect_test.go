@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	net := makePBFTNetwork(4, nil)
+	defer net.Stop()
+
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(1, 0)
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	instance := net.pbftEndpoints[1].pbft
+	instance.viewChangeStore[vcidx{1, 3}] = &ViewChange{View: 1, ReplicaId: 3}
+	instance.viewChangeStore[vcidx{0, 2}] = &ViewChange{View: 0, ReplicaId: 2} // view 0 is installed
+	instance.chkpts[10] = "ten"
+	instance.checkpointStore[Checkpoint{SequenceNumber: 10, ReplicaId: 2, Id: "ten"}] = true
+	instance.checkpointStore[Checkpoint{SequenceNumber: 10, ReplicaId: 0, Id: "ten"}] = true
+
+	state := instance.inspect()
+	expected := &ReplicaState{
+		ReplicaID:        1,
+		View:             0,
+		ActiveView:       true,
+		Primary:          0,
+		SeqNo:            0, // only the primary assigns sequence numbers
+		LastExec:         1,
+		LowWatermark:     0,
+		LogSize:          instance.L,
+		CheckpointPeriod: instance.K,
+		ViewChanges:      []ViewChangeVote{{View: 1, ReplicaID: 3}},
+		Checkpoints:      map[uint64]string{0: instance.chkpts[0], 10: "ten"},
+		Votes:            map[uint64][]CheckpointID{10: {{ReplicaID: 0, ID: "ten"}, {ReplicaID: 2, ID: "ten"}}},
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Errorf("Expected state\n%+v\ngot\n%+v", expected, state)
+	}
+}
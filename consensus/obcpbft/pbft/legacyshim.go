@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+                 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+// --------------------------------------------------------
+//
+// LegacyShim is a temporary measure to allow the non-batch
+// plugins to continue to function until they are completely
+// deprecated
+//
+// --------------------------------------------------------
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// LegacyShim drives a PbftCore for the classic and sieve consenters
+type LegacyShim struct {
+	*PbftCore
+}
+
+// StateUpdated is an event telling us that the application fast-forwarded its state
+func (instance LegacyShim) StateUpdated(seqNo uint64, id []byte) {
+	logger.Debug("Replica %d queueing message that it has caught up via state transfer", instance.ID)
+	instance.Manager.Queue() <- StateUpdatedEvent{
+		SeqNo: seqNo,
+		ID:    id,
+	}
+}
+
+// StateUpdating is an event telling us that the application is fast-forwarding its state
+func (instance LegacyShim) StateUpdating(seqNo uint64, id []byte) {
+	logger.Debug("Replica %d queueing message that state transfer has been initiated", instance.ID)
+	instance.Manager.Queue() <- StateUpdatingEvent{
+		SeqNo: seqNo,
+		ID:    id,
+	}
+}
+
+// handle new consensus requests
+func (instance LegacyShim) Request(msgPayload []byte, senderID uint64) error {
+	msg := &Message{&Message_Request{&Request{Payload: msgPayload,
+		ReplicaId: senderID}}}
+	instance.Manager.Queue() <- pbftMessageEvent{
+		sender: senderID,
+		msg:    msg,
+	}
+	return nil
+}
+
+// handle internal consensus messages
+func (instance LegacyShim) Receive(msgPayload []byte, senderID uint64) error {
+	msg := &Message{}
+	err := proto.Unmarshal(msgPayload, msg)
+	if err != nil {
+		return fmt.Errorf("Error unpacking payload from message: %s", err)
+	}
+	if !instance.Admit(msg, senderID) {
+		return nil
+	}
+
+	instance.Manager.Queue() <- pbftMessageEvent{
+		msg:    msg,
+		sender: senderID,
+	}
+
+	return nil
+}
+
+// TODO, this should not return an error
+func (instance LegacyShim) RecvMsgSync(msg *Message, senderID uint64) (err error) {
+	if !instance.Admit(msg, senderID) {
+		return nil
+	}
+	instance.Manager.Queue() <- pbftMessageEvent{
+		msg:    msg,
+		sender: senderID,
+	}
+	return nil
+}
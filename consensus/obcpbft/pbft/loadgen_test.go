@@ -0,0 +1,323 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gp "google/protobuf"
+
+	"github.com/op/go-logging"
+)
+
+// The load test doubles as a harness for tuning the configuration, e.g.
+//
+//	go test -run TestLoad -v ./consensus/obcpbft/pbft -load.requests 2000 -load.rate 500 \
+//		-load.set general.K=20,general.logmultiplier=4,general.timeout.request=1s
+var (
+	loadRequests = flag.Int("load.requests", 50, "requests the load test submits")
+	loadRate     = flag.Float64("load.rate", 200, "requests per second the load test submits")
+	loadSize     = flag.Int("load.size", 256, "payload bytes of the requests of the load test")
+	loadN        = flag.Int("load.N", 4, "replicas of the load test network")
+	loadSet      = flag.String("load.set", "", "comma separated key=value overrides of the configuration of the load test")
+)
+
+// loadPhases are the phases a request passes through, in order, the latency
+// of a phase is measured from the end of the previous one
+var loadPhases = []string{"preprepare", "prepare", "commit", "execute"}
+
+// loadProfile describes the load a loadGenerator submits
+type loadProfile struct {
+	requests    int           // requests submitted in total
+	rate        float64       // requests submitted per second
+	payloadSize int           // payload bytes of each request
+	timeout     time.Duration // how long to wait for the requests to execute
+}
+
+// latencySummary summarizes the latencies of one phase
+type latencySummary struct {
+	count               int
+	mean, p50, p99, max time.Duration
+}
+
+func summarize(latencies []time.Duration) latencySummary {
+	if len(latencies) == 0 {
+		return latencySummary{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Sort(durations(sorted))
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+	return latencySummary{
+		count: len(sorted),
+		mean:  total / time.Duration(len(sorted)),
+		p50:   sorted[len(sorted)/2],
+		p99:   sorted[(len(sorted)*99)/100],
+		max:   sorted[len(sorted)-1],
+	}
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+
+// loadReport is what a loadGenerator measured
+type loadReport struct {
+	submitted   int
+	executed    int
+	elapsed     time.Duration // from the first submission to the last execution
+	throughput  float64       // executed requests per second
+	phases      map[string]latencySummary
+	viewChanges uint64 // new views installed by the observed replica
+}
+
+func (lr *loadReport) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d of %d requests executed in %v, %.1f requests per second, %d view changes\n",
+		lr.executed, lr.submitted, lr.elapsed, lr.throughput, lr.viewChanges)
+	for _, phase := range loadPhases {
+		s := lr.phases[phase]
+		fmt.Fprintf(&buf, "  %-10s n=%-5d mean=%-12v p50=%-12v p99=%-12v max=%v\n", phase, s.count, s.mean, s.p50, s.p99, s.max)
+	}
+	return buf.String()
+}
+
+// loadGenerator submits requests to a pbftNetwork, and measures the phases
+// of each request on one observed replica
+type loadGenerator struct {
+	net      *pbftNetwork
+	profile  loadProfile
+	observed *PbftCore
+
+	lock      sync.Mutex
+	submitted map[string]time.Time            // digest -> submission time
+	reached   map[string]map[string]time.Time // digest -> phase -> time the observed replica completed it
+	seqNos    map[string]uint64               // digest -> sequence number the request was last pre-prepared at
+	done      chan struct{}                   // closed once every request executed
+}
+
+// newLoadGenerator creates a loadGenerator for net, observing replica observed
+func newLoadGenerator(net *pbftNetwork, profile loadProfile, observed int) *loadGenerator {
+	lg := &loadGenerator{
+		net:       net,
+		profile:   profile,
+		observed:  net.pbftEndpoints[observed].pbft,
+		submitted: make(map[string]time.Time),
+		reached:   make(map[string]map[string]time.Time),
+		seqNos:    make(map[string]uint64),
+		done:      make(chan struct{}),
+	}
+	lg.observed.Manager.(instrumentedManager).instrument(&eventHooks{
+		done: func(event interface{}, elapsed time.Duration) {
+			lg.observe()
+		},
+	})
+	return lg
+}
+
+// observe records the phases the requests completed on the observed
+// replica, it is called on its event thread after every event
+func (lg *loadGenerator) observe() {
+	instance := lg.observed
+	certs := make(map[string]msgID)
+	for idx, cert := range instance.certStore {
+		if cert.digest != "" {
+			certs[cert.digest] = idx
+		}
+	}
+
+	now := time.Now()
+	lg.lock.Lock()
+	defer lg.lock.Unlock()
+	for digest, reached := range lg.reached {
+		if _, ok := reached["execute"]; ok {
+			continue
+		}
+		idx, ok := certs[digest]
+		if ok {
+			lg.seqNos[digest] = idx.n
+		}
+		if n, ok := lg.seqNos[digest]; ok && n <= instance.LastExec {
+			for _, phase := range loadPhases {
+				if _, ok := reached[phase]; !ok {
+					reached[phase] = now
+				}
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		for _, phase := range []struct {
+			name string
+			done func(string, uint64, uint64) bool
+		}{{"preprepare", instance.prePrepared}, {"prepare", instance.prepared}, {"commit", instance.committed}} {
+			if _, ok := reached[phase.name]; !ok && phase.done(digest, idx.v, idx.n) {
+				reached[phase.name] = now
+			}
+		}
+	}
+	if len(lg.reached) == lg.profile.requests && lg.executed() == lg.profile.requests {
+		select {
+		case <-lg.done:
+		default:
+			close(lg.done)
+		}
+	}
+}
+
+// executed returns the number of requests which executed, it must be
+// called with the lock held
+func (lg *loadGenerator) executed() int {
+	count := 0
+	for _, reached := range lg.reached {
+		if _, ok := reached["execute"]; ok {
+			count++
+		}
+	}
+	return count
+}
+
+// request creates the i-th request of the load
+func (lg *loadGenerator) request(i int, r *rand.Rand) *Request {
+	payload := make([]byte, lg.profile.payloadSize)
+	r.Read(payload)
+	return &Request{
+		Timestamp: &gp.Timestamp{Seconds: time.Now().Unix(), Nanos: int32(i)},
+		Payload:   payload,
+		ReplicaId: uint64(i % len(lg.net.pbftEndpoints)),
+	}
+}
+
+// run submits the load to every replica, as clients broadcasting their
+// requests would, and waits for the requests to execute on the observed
+// replica or the timeout of the profile to expire
+func (lg *loadGenerator) run() *loadReport {
+	viewChanges := lg.observed.Metrics.viewChanges.Value()
+	r := rand.New(rand.NewSource(1))
+	go lg.net.ProcessContinually()
+
+	start := time.Now()
+	for i := 0; i < lg.profile.requests; i++ {
+		if wait := start.Add(time.Duration(float64(i) / lg.profile.rate * float64(time.Second))).Sub(time.Now()); wait > 0 {
+			time.Sleep(wait)
+		}
+		req := lg.request(i, r)
+		digest := HashReq(lg.observed.Digest, req)
+		lg.lock.Lock()
+		lg.submitted[digest] = time.Now()
+		lg.reached[digest] = make(map[string]time.Time)
+		lg.lock.Unlock()
+		for _, pe := range lg.net.pbftEndpoints {
+			pe.pbft.Manager.Queue() <- req
+		}
+	}
+
+	select {
+	case <-lg.done:
+	case <-time.After(lg.profile.timeout):
+	}
+	return lg.report(start, lg.observed.Metrics.viewChanges.Value()-viewChanges)
+}
+
+// report summarizes the phases the requests reached
+func (lg *loadGenerator) report(start time.Time, viewChanges uint64) *loadReport {
+	lg.lock.Lock()
+	defer lg.lock.Unlock()
+
+	lr := &loadReport{
+		submitted:   len(lg.submitted),
+		executed:    lg.executed(),
+		phases:      make(map[string]latencySummary),
+		viewChanges: viewChanges,
+	}
+	latencies := make(map[string][]time.Duration)
+	var last time.Time
+	for digest, reached := range lg.reached {
+		previous := lg.submitted[digest]
+		for _, phase := range loadPhases {
+			at, ok := reached[phase]
+			if !ok {
+				break
+			}
+			latencies[phase] = append(latencies[phase], at.Sub(previous))
+			previous = at
+		}
+		if at, ok := reached["execute"]; ok && at.After(last) {
+			last = at
+		}
+	}
+	for phase, l := range latencies {
+		lr.phases[phase] = summarize(l)
+	}
+	if lr.executed > 0 {
+		lr.elapsed = last.Sub(start)
+		lr.throughput = float64(lr.executed) / lr.elapsed.Seconds()
+	}
+	return lr
+}
+
+func TestLoad(t *testing.T) {
+	logging.SetLevel(logging.WARNING, "consensus/obcpbft")
+	defer logging.SetLevel(logging.DEBUG, "consensus/obcpbft")
+
+	config := loadConfig()
+	if *loadSet != "" {
+		for _, setting := range strings.Split(*loadSet, ",") {
+			kv := strings.SplitN(setting, "=", 2)
+			if len(kv) != 2 {
+				t.Fatalf("Setting %q is not of the form key=value", setting)
+			}
+			config.Set(kv[0], kv[1])
+		}
+	}
+	net := makePBFTNetwork(*loadN, config)
+	defer net.Stop()
+
+	profile := loadProfile{
+		requests:    *loadRequests,
+		rate:        *loadRate,
+		payloadSize: *loadSize,
+		timeout:     time.Duration(float64(*loadRequests) / *loadRate * float64(time.Second)) + 30*time.Second,
+	}
+	report := newLoadGenerator(net, profile, 1).run()
+	t.Logf("Load of %d requests of %d bytes at %v per second:\n%s", profile.requests, profile.payloadSize, profile.rate, report)
+
+	if report.executed != profile.requests {
+		t.Errorf("Expected all %d requests to execute, %d did", profile.requests, report.executed)
+	}
+	for _, phase := range loadPhases {
+		if report.phases[phase].count != report.executed {
+			t.Errorf("Expected the %s latency of each executed request, got %d", phase, report.phases[phase].count)
+		}
+	}
+	if report.throughput <= 0 {
+		t.Errorf("Expected a positive throughput, got %v", report.throughput)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// logWindow is the part of the log the primary assigns sequence numbers in.
+// It grows at checkpoint boundaries while the primary is held back by the
+// window, that is while execution lags behind ordering, and shrinks back once
+// execution caught up. The replicas accept messages within the largest window,
+// so that they do not need to agree on its current size. All methods may be
+// called on a nil logWindow, which keeps the window at half the log size
+type logWindow struct {
+	min  uint64 // smallest log size, K * logmultiplier
+	max  uint64 // largest log size, K * maxlogmultiplier
+	step uint64 // growth and shrinkage per checkpoint, K
+	size uint64 // current log size, the primary assigns up to size/2 above h
+
+	stalled bool // the primary was held back by the window since the last checkpoint
+}
+
+// newLogWindow returns nil if general.maxlogmultiplier is 0
+func newLogWindow(config *viper.Viper, K uint64, logMultiplier uint64) (*logWindow, error) {
+	maxMultiplier := config.GetInt("general.maxlogmultiplier")
+	if maxMultiplier == 0 {
+		return nil, nil
+	}
+	if maxMultiplier < 0 || uint64(maxMultiplier) < logMultiplier {
+		return nil, fmt.Errorf("Maximum log multiplier must be 0 or at least the log multiplier %d, got %d", logMultiplier, maxMultiplier)
+	}
+	return &logWindow{
+		min:  logMultiplier * K,
+		max:  uint64(maxMultiplier) * K,
+		step: K,
+		size: logMultiplier * K,
+	}, nil
+}
+
+// limit returns how far above the low watermark the primary may assign
+// sequence numbers, given the log size L
+func (w *logWindow) limit(L uint64) uint64 {
+	if w == nil {
+		return L / 2
+	}
+	return w.size / 2
+}
+
+// stall records that the primary could not assign a sequence number
+func (w *logWindow) stall() {
+	if w == nil {
+		return
+	}
+	w.stalled = true
+}
+
+// resize is called at checkpoint boundaries, lag is the number of sequence
+// numbers assigned but not executed yet
+func (w *logWindow) resize(lag uint64) {
+	if w == nil {
+		return
+	}
+	if w.stalled && w.size < w.max {
+		w.size += w.step
+		if w.size > w.max {
+			w.size = w.max
+		}
+		logger.Info("PBFT growing log window to %d, %d sequence numbers not executed yet", w.size, lag)
+	} else if !w.stalled && lag < w.size/4 && w.size > w.min {
+		w.size -= w.step
+		if w.size < w.min {
+			w.size = w.min
+		}
+		logger.Info("PBFT shrinking log window to %d, %d sequence numbers not executed yet", w.size, lag)
+	}
+	w.stalled = false
+}
@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestLogWindowConfig(t *testing.T) {
+	config := loadConfig()
+	if w, err := newLogWindow(config, 10, 4); err != nil || w != nil {
+		t.Fatalf("Expected the log window to be fixed by default, got %v, %v", w, err)
+	}
+	if limit := (*logWindow)(nil).limit(40); limit != 20 {
+		t.Errorf("Expected a fixed log window to limit the primary to half the log size, got %d", limit)
+	}
+
+	config.Set("general.maxlogmultiplier", 2)
+	if _, err := newLogWindow(config, 10, 4); err == nil {
+		t.Errorf("Expected a maximum log multiplier below the log multiplier to be rejected")
+	}
+}
+
+func TestLogWindowResize(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.maxlogmultiplier", 6)
+	w, err := newLogWindow(config, 10, 4)
+	if err != nil {
+		t.Fatalf("Failed to create log window: %s", err)
+	}
+
+	for _, expected := range []uint64{50, 60, 60} {
+		w.stall()
+		w.resize(30)
+		if w.size != expected {
+			t.Errorf("Expected a stalled log window to grow to %d, got %d", expected, w.size)
+		}
+	}
+
+	w.resize(20)
+	if w.size != 60 {
+		t.Errorf("Expected the log window to keep its size while execution lags, got %d", w.size)
+	}
+
+	for _, expected := range []uint64{50, 40, 40} {
+		w.resize(0)
+		if w.size != expected {
+			t.Errorf("Expected an idle log window to shrink to %d, got %d", expected, w.size)
+		}
+	}
+}
+
+func TestPrimaryGrowsLogWindow(t *testing.T) {
+	var seqNos []uint64
+	persist := &mockPersist{}
+	stack := &omniProto{
+		ReadStateImpl:    persist.ReadState,
+		ReadStateSetImpl: persist.ReadStateSet,
+		StoreStateImpl:   persist.StoreState,
+		DelStateImpl:     persist.DelState,
+		validateImpl: func(txRaw []byte) error {
+			return nil
+		},
+		broadcastImpl: func(msgPayload []byte) {
+			msg := &Message{}
+			if err := proto.Unmarshal(msgPayload, msg); err != nil {
+				t.Fatalf("Could not unmarshal broadcast message: %s", err)
+			}
+			if pp := msg.GetPrePrepare(); pp != nil {
+				seqNos = append(seqNos, pp.SequenceNumber)
+			}
+		},
+	}
+	config := loadConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	config.Set("general.maxlogmultiplier", 4)
+	instance := newPbftCore(0, config, stack)
+	defer instance.Close()
+
+	if instance.L != 8 {
+		t.Fatalf("Expected replicas to accept messages within the largest log size 8, got %d", instance.L)
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		sendEvent(instance, createPbftRequestWithChainTx(i, 0))
+	}
+	if len(seqNos) != 2 {
+		t.Fatalf("Expected the primary to be held back after 2 pre-prepares, sent %v", seqNos)
+	}
+
+	// A checkpoint while the primary is held back grows the window, and the
+	// primary resumes assigning sequence numbers
+	for _, cert := range instance.certStore {
+		delete(instance.OutstandingReqs, cert.digest) // committed and executed
+	}
+	instance.LastExec = 2
+	instance.moveWatermarks(2)
+	if instance.window.size != 6 || len(seqNos) != 3 || seqNos[2] != 3 {
+		t.Errorf("Expected the primary to pre-prepare seqNo 3 in a window of size 6, window %d, sent %v", instance.window.size, seqNos)
+	}
+}
@@ -0,0 +1,497 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	pb "github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// authenticated messages carry an authenticator instead of a signature
+type authenticated interface {
+	getAuthenticator() *Authenticator
+	setAuthenticator(a *Authenticator)
+	getID() uint64
+	serialize() ([]byte, error)
+}
+
+// sessionKeyTimerEvent is sent when our session key is due for its
+// scheduled rotation
+type sessionKeyTimerEvent struct{}
+
+// ownSessionKey is our half of the pairwise session keys of one epoch
+type ownSessionKey struct {
+	epoch   uint64
+	priv    []byte
+	pub     []byte
+	retired time.Time // when the key was superseded, zero while current
+}
+
+// peerSessionKey is a session key announced by another replica
+type peerSessionKey struct {
+	*SessionKey
+	retired time.Time // when the replica announced a later key, zero while current
+}
+
+type sessionIdx struct {
+	replica   uint64
+	peerEpoch uint64
+	ownEpoch  uint64
+}
+
+// macAuthenticator authenticates prepares and commits with pairwise
+// session MACs instead of signatures. Each replica announces a signed ECDH
+// public key per epoch, the session key between two replicas is derived
+// from the public key of the one and the private key of the other.
+//
+// Keys are rotated every rotation stable checkpoints, every period, and on
+// demand. A superseded key is still accepted while it is the previous one,
+// as the other replicas may not have learned of its successor yet, and for
+// overlap after it was superseded, so that messages in flight survive
+// rotations in quick succession
+type macAuthenticator struct {
+	id       uint64
+	N        int
+	clock    Clock
+	rotation uint64        // stable checkpoints between key rotations, 0 never rotates
+	stable   uint64        // stable checkpoints since the last rotation
+	period   time.Duration // between scheduled key rotations, 0 never rotates
+	overlap  time.Duration // superseded keys older than the previous one are accepted for
+	timer    EventTimer    // of the scheduled rotation, nil if period is 0
+
+	announced bool
+	own       []*ownSessionKey             // the current key is last
+	peers     map[uint64][]*peerSessionKey // the keys each replica announced, its current key is last
+	sessions  map[sessionIdx][]byte        // derived session keys
+}
+
+// newMACAuthenticator returns nil if prepares and commits are not to be
+// authenticated with MACs
+func newMACAuthenticator(id uint64, config *viper.Viper, clk Clock) (*macAuthenticator, error) {
+	switch mode := strings.ToLower(config.GetString("general.authentication.mode")); mode {
+	case "signature", "":
+		return nil, nil
+	case "mac":
+	default:
+		return nil, fmt.Errorf("Invalid authentication mode: %s", mode)
+	}
+
+	rotation := config.GetInt("general.authentication.rotation")
+	if rotation < 0 {
+		return nil, fmt.Errorf("Session key rotation must not be negative, got %d", rotation)
+	}
+	period, err := time.ParseDuration(config.GetString("general.authentication.period"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse session key rotation period: %s", err)
+	}
+	overlap, err := time.ParseDuration(config.GetString("general.authentication.overlap"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse session key overlap: %s", err)
+	}
+	if period < 0 || overlap < 0 {
+		return nil, fmt.Errorf("Session key rotation period (%v) and overlap (%v) must not be negative", period, overlap)
+	}
+
+	ma := &macAuthenticator{
+		id:       id,
+		N:        config.GetInt("general.N"),
+		clock:    clk,
+		rotation: uint64(rotation),
+		period:   period,
+		overlap:  overlap,
+		peers:    make(map[uint64][]*peerSessionKey),
+		sessions: make(map[sessionIdx][]byte),
+	}
+	if err := ma.newOwnKey(0); err != nil {
+		return nil, err
+	}
+	return ma, nil
+}
+
+func (ma *macAuthenticator) newOwnKey(epoch uint64) error {
+	priv, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("Cannot generate session key: %s", err)
+	}
+	now := ma.clock.Now()
+	if len(ma.own) > 0 {
+		ma.current().retired = now
+	}
+	ma.own = append(ma.own, &ownSessionKey{
+		epoch: epoch,
+		priv:  priv,
+		pub:   elliptic.Marshal(elliptic.P256(), x, y),
+	})
+
+	var own []*ownSessionKey
+	for i, key := range ma.own {
+		if ma.accepted(key.retired, len(ma.own)-1-i, now) {
+			own = append(own, key)
+			continue
+		}
+		for idx := range ma.sessions {
+			if idx.ownEpoch == key.epoch {
+				delete(ma.sessions, idx)
+			}
+		}
+	}
+	ma.own = own
+	return nil
+}
+
+// accepted returns whether a key superseded at retired, by age later keys,
+// is still accepted at now
+func (ma *macAuthenticator) accepted(retired time.Time, age int, now time.Time) bool {
+	return age <= 1 || now.Sub(retired) <= ma.overlap
+}
+
+func (ma *macAuthenticator) current() *ownSessionKey {
+	return ma.own[len(ma.own)-1]
+}
+
+// sessionKey returns the current session key, unsigned
+func (ma *macAuthenticator) sessionKey(replyRequested bool) *SessionKey {
+	own := ma.current()
+	return &SessionKey{
+		ReplicaId:      ma.id,
+		Epoch:          own.epoch,
+		PublicKey:      own.pub,
+		ReplyRequested: replyRequested,
+	}
+}
+
+// stableCheckpoint returns whether our session key is due for rotation
+// after a stable checkpoint
+func (ma *macAuthenticator) stableCheckpoint() bool {
+	if ma == nil || ma.rotation == 0 {
+		return false
+	}
+	ma.stable++
+	return ma.stable%ma.rotation == 0
+}
+
+func (ma *macAuthenticator) rotate() error {
+	return ma.newOwnKey(ma.current().epoch + 1)
+}
+
+// addPeerKey records a session key announced by another replica, whose
+// signature has already been checked
+func (ma *macAuthenticator) addPeerKey(sk *SessionKey) error {
+	if sk.ReplicaId == ma.id || sk.ReplicaId >= uint64(ma.N) {
+		return fmt.Errorf("Session key announced by invalid replica %d", sk.ReplicaId)
+	}
+	if x, _ := elliptic.Unmarshal(elliptic.P256(), sk.PublicKey); x == nil {
+		return fmt.Errorf("Session key announced by replica %d is not a valid public key", sk.ReplicaId)
+	}
+
+	// A restarted replica starts over at epoch 0, so a key replaces any
+	// earlier one of the same epoch
+	now := ma.clock.Now()
+	var keys []*peerSessionKey
+	for _, key := range ma.peers[sk.ReplicaId] {
+		if key.Epoch != sk.Epoch {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > 0 && keys[len(keys)-1].retired.IsZero() {
+		keys[len(keys)-1].retired = now
+	}
+	keys = append(keys, &peerSessionKey{SessionKey: sk})
+	var retained []*peerSessionKey
+	for i, key := range keys {
+		if ma.accepted(key.retired, len(keys)-1-i, now) {
+			retained = append(retained, key)
+		}
+	}
+	ma.peers[sk.ReplicaId] = retained
+
+	for idx := range ma.sessions {
+		if idx.replica != sk.ReplicaId {
+			continue
+		}
+		kept := false
+		for _, key := range retained {
+			if key.Epoch == idx.peerEpoch && key.SessionKey != sk {
+				kept = true
+			}
+		}
+		if !kept {
+			delete(ma.sessions, idx)
+		}
+	}
+	return nil
+}
+
+// peerKey returns the key of the replica of the given epoch, if it is
+// still accepted
+func (ma *macAuthenticator) peerKey(replica uint64, epoch uint64) *SessionKey {
+	keys := ma.peers[replica]
+	now := ma.clock.Now()
+	for i, key := range keys {
+		if key.Epoch == epoch && ma.accepted(key.retired, len(keys)-1-i, now) {
+			return key.SessionKey
+		}
+	}
+	return nil
+}
+
+func (ma *macAuthenticator) latestPeerKey(replica uint64) *SessionKey {
+	keys := ma.peers[replica]
+	if len(keys) == 0 {
+		return nil
+	}
+	return keys[len(keys)-1].SessionKey
+}
+
+// session derives the session key between our key own and the key of
+// another replica
+func (ma *macAuthenticator) session(peer *SessionKey, own *ownSessionKey) []byte {
+	idx := sessionIdx{replica: peer.ReplicaId, peerEpoch: peer.Epoch, ownEpoch: own.epoch}
+	if key, ok := ma.sessions[idx]; ok {
+		return key
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), peer.PublicKey)
+	shared, _ := elliptic.P256().ScalarMult(x, y, own.priv)
+	key := sha256.Sum256(shared.Bytes())
+	ma.sessions[idx] = key[:]
+	return key[:]
+}
+
+func computeMAC(key []byte, raw []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(raw)
+	return mac.Sum(nil)
+}
+
+// macs computes a MAC over raw for every other replica, and returns
+// whether it knows the session key of all of them
+func (ma *macAuthenticator) macs(raw []byte) (*Authenticator, bool) {
+	own := ma.current()
+	auth := &Authenticator{
+		Epoch: own.epoch,
+		Macs:  make([][]byte, ma.N),
+	}
+	complete := true
+	for i := uint64(0); i < uint64(ma.N); i++ {
+		if i == ma.id {
+			continue
+		}
+		peer := ma.latestPeerKey(i)
+		if peer == nil {
+			complete = false
+			continue
+		}
+		auth.Macs[i] = computeMAC(ma.session(peer, own), raw)
+	}
+	return auth, complete
+}
+
+// checkMAC returns whether auth holds a valid MAC over raw from replica
+func (ma *macAuthenticator) checkMAC(replica uint64, auth *Authenticator, raw []byte) bool {
+	if ma.id >= uint64(len(auth.Macs)) || len(auth.Macs[ma.id]) == 0 {
+		return false
+	}
+	peer := ma.peerKey(replica, auth.Epoch)
+	if peer == nil {
+		return false
+	}
+	// The sender may not have learned about our latest key yet
+	now := ma.clock.Now()
+	for i, own := range ma.own {
+		if !ma.accepted(own.retired, len(ma.own)-1-i, now) {
+			continue
+		}
+		if hmac.Equal(auth.Macs[ma.id], computeMAC(ma.session(peer, own), raw)) {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// pbftCore glue
+// =============================================================================
+
+// announceSessionKey broadcasts our current session key, signed through
+// the stack
+func (instance *PbftCore) announceSessionKey(replyRequested bool) error {
+	sk := instance.auth.sessionKey(replyRequested)
+	if err := instance.Sign(sk); err != nil {
+		return fmt.Errorf("Cannot sign session key: %s", err)
+	}
+	instance.auth.announced = true
+	return instance.innerBroadcast(&Message{&Message_SessionKey{sk}})
+}
+
+// authenticate attaches an authenticator to a, if MAC authentication is
+// enabled. Replicas whose session key we do not know yet can not check a
+// MAC, so the authenticator is signed until we know all session keys
+func (instance *PbftCore) authenticate(a authenticated) error {
+	if instance.auth == nil {
+		return nil
+	}
+	if !instance.auth.announced {
+		if err := instance.announceSessionKey(true); err != nil {
+			return err
+		}
+	}
+
+	a.setAuthenticator(nil)
+	raw, err := a.serialize()
+	if err != nil {
+		return err
+	}
+	auth, complete := instance.auth.macs(raw)
+	if !complete {
+		if auth.Signature, err = instance.signRaw(raw); err != nil {
+			return err
+		}
+	}
+	a.setAuthenticator(auth)
+	return nil
+}
+
+// checkAuthenticator verifies the MAC addressed to us, falling back to the
+// signature if the sender did not know our session key
+func (instance *PbftCore) checkAuthenticator(a authenticated) error {
+	if instance.auth == nil {
+		return nil
+	}
+	auth := a.getAuthenticator()
+	if auth == nil {
+		return fmt.Errorf("Message from replica %d carries no authenticator", a.getID())
+	}
+
+	a.setAuthenticator(nil)
+	raw, err := a.serialize()
+	a.setAuthenticator(auth)
+	if err != nil {
+		return err
+	}
+
+	if instance.auth.checkMAC(a.getID(), auth, raw) {
+		return nil
+	}
+	if auth.Signature != nil {
+		return instance.VerifyRaw(a.getID(), auth.Signature, raw)
+	}
+	return fmt.Errorf("Message from replica %d carries no valid MAC for replica %d", a.getID(), instance.ID)
+}
+
+func (instance *PbftCore) recvSessionKey(sk *SessionKey) error {
+	if instance.auth == nil {
+		instance.log.Debug("Ignoring session key from replica %d, MAC authentication is disabled", sk.ReplicaId)
+		return nil
+	}
+	instance.log.Debug("Received session key epoch %d from replica %d", sk.Epoch, sk.ReplicaId)
+
+	if err := instance.auth.addPeerKey(sk); err != nil {
+		return err
+	}
+	if !sk.ReplyRequested {
+		return nil
+	}
+
+	reply := instance.auth.sessionKey(false)
+	if err := instance.Sign(reply); err != nil {
+		return fmt.Errorf("Cannot sign session key: %s", err)
+	}
+	msgRaw, err := pb.Marshal(&Message{&Message_SessionKey{reply}})
+	if err != nil {
+		return fmt.Errorf("Cannot marshal session key: %s", err)
+	}
+	return instance.consumer.Unicast(msgRaw, sk.ReplicaId)
+}
+
+// maybeRotateSessionKey starts a new session key epoch every rotation
+// stable checkpoints
+func (instance *PbftCore) maybeRotateSessionKey() {
+	if !instance.auth.stableCheckpoint() {
+		return
+	}
+	instance.rotateSessionKey(false)
+}
+
+// rotateSessionKey starts a new session key epoch and announces it, asking
+// the other replicas to announce their keys again if replyRequested
+func (instance *PbftCore) rotateSessionKey(replyRequested bool) {
+	ma := instance.auth
+	if ma.timer != nil {
+		ma.timer.Reset(ma.period, sessionKeyTimerEvent{})
+	}
+	if err := ma.rotate(); err != nil {
+		instance.log.Error("Could not rotate its session key: %s", err)
+		return
+	}
+	instance.Metrics.keyRotations.Inc()
+	instance.log.Info("Rotated its session key to epoch %d", ma.current().epoch)
+	if err := instance.announceSessionKey(replyRequested); err != nil {
+		instance.log.Error("Could not announce its session key: %s", err)
+	}
+}
+
+// RequestSessionKeyRotation rotates our session key now, rather than at its
+// next scheduled rotation, for instance when it may have been compromised
+func (instance *PbftCore) RequestSessionKeyRotation() error {
+	if instance.auth == nil {
+		return fmt.Errorf("Replica %d does not authenticate with session keys", instance.ID)
+	}
+	instance.Inject(func() {
+		instance.rotateSessionKey(false)
+	})
+	return nil
+}
+
+func (prep *Prepare) getAuthenticator() *Authenticator {
+	return prep.Authenticator
+}
+
+func (prep *Prepare) setAuthenticator(a *Authenticator) {
+	prep.Authenticator = a
+}
+
+func (prep *Prepare) getID() uint64 {
+	return prep.ReplicaId
+}
+
+func (prep *Prepare) serialize() ([]byte, error) {
+	return pb.Marshal(prep)
+}
+
+func (commit *Commit) getAuthenticator() *Authenticator {
+	return commit.Authenticator
+}
+
+func (commit *Commit) setAuthenticator(a *Authenticator) {
+	commit.Authenticator = a
+}
+
+func (commit *Commit) getID() uint64 {
+	return commit.ReplicaId
+}
+
+func (commit *Commit) serialize() ([]byte, error) {
+	return pb.Marshal(commit)
+}
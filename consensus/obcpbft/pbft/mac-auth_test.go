@@ -0,0 +1,217 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+func macConfig() *viper.Viper {
+	config := loadConfig()
+	config.Set("general.authentication.mode", "mac")
+	config.Set("general.authentication.rotation", 1)
+	return config
+}
+
+func TestMACAuthenticatorSessions(t *testing.T) {
+	config := macConfig()
+	vc := newVirtualClock(time.Unix(0, 0))
+	a, err := newMACAuthenticator(0, config, vc)
+	if err != nil {
+		t.Fatalf("Failed to create authenticator: %s", err)
+	}
+	b, _ := newMACAuthenticator(1, config, vc)
+	if err := a.addPeerKey(b.sessionKey(false)); err != nil {
+		t.Fatalf("Failed to add session key: %s", err)
+	}
+	if err := b.addPeerKey(a.sessionKey(false)); err != nil {
+		t.Fatalf("Failed to add session key: %s", err)
+	}
+
+	raw := []byte("prepare")
+	auth, complete := a.macs(raw)
+	if complete {
+		t.Errorf("Expected the authenticator to be incomplete without the keys of replicas 2 and 3")
+	}
+	if !b.checkMAC(0, auth, raw) {
+		t.Fatalf("Expected the MAC of replica 0 to verify")
+	}
+	if b.checkMAC(0, auth, []byte("tampered")) {
+		t.Errorf("Expected the MAC of a tampered message not to verify")
+	}
+
+	// Replica 0 has not learned of the new key yet, which must still work
+	b.rotate()
+	if !b.checkMAC(0, auth, raw) {
+		t.Errorf("Expected a MAC for our previous session key to verify")
+	}
+	vc.advance(time.Minute)
+	b.rotate()
+	if b.checkMAC(0, auth, raw) {
+		t.Errorf("Expected a MAC for an expired session key not to verify")
+	}
+}
+
+func TestMACAuthenticatorOverlap(t *testing.T) {
+	config := macConfig()
+	config.Set("general.authentication.overlap", "10s")
+	vc := newVirtualClock(time.Unix(0, 0))
+	a, _ := newMACAuthenticator(0, config, vc)
+	b, _ := newMACAuthenticator(1, config, vc)
+	a.addPeerKey(b.sessionKey(false))
+	b.addPeerKey(a.sessionKey(false))
+
+	raw := []byte("commit")
+	auth, _ := a.macs(raw)
+
+	// Two rotations in quick succession keep the key the MAC was computed for
+	b.rotate()
+	b.rotate()
+	if !b.checkMAC(0, auth, raw) {
+		t.Fatalf("Expected a MAC for a key superseded within the overlap to verify")
+	}
+	vc.advance(11 * time.Second)
+	if b.checkMAC(0, auth, raw) {
+		t.Errorf("Expected a MAC for a key superseded longer than the overlap ago not to verify")
+	}
+
+	// The same holds for the keys other replicas announce
+	a.rotate()
+	b.addPeerKey(a.sessionKey(false))
+	a.rotate()
+	b.addPeerKey(a.sessionKey(false))
+	if b.peerKey(0, 0) == nil {
+		t.Errorf("Expected the key of replica 0 superseded within the overlap to be accepted")
+	}
+	vc.advance(11 * time.Second)
+	if b.peerKey(0, 0) != nil || b.peerKey(0, 1) == nil {
+		t.Errorf("Expected only the previous key of replica 0 to be accepted once the overlap elapsed")
+	}
+}
+
+func TestSessionKeyRotationSchedule(t *testing.T) {
+	var announced []uint64
+	stack := &omniProto{
+		broadcastImpl: func(msgPayload []byte) {
+			msg := &Message{}
+			if err := proto.Unmarshal(msgPayload, msg); err != nil {
+				t.Fatalf("Could not unmarshal broadcast message: %s", err)
+			}
+			if sk := msg.GetSessionKey(); sk != nil {
+				announced = append(announced, sk.Epoch)
+			}
+		},
+		signImpl: func(msg []byte) ([]byte, error) { return msg, nil },
+	}
+	config := macConfig()
+	config.Set("general.authentication.period", "1h")
+	p := newPbftCoreWithClock(0, config, stack, newVirtualClock(time.Unix(0, 0)))
+	defer p.Close()
+
+	sendEvent(p, sessionKeyTimerEvent{})
+	if epoch := p.auth.current().epoch; epoch != 1 {
+		t.Fatalf("Expected the scheduled rotation to start epoch 1, at %d", epoch)
+	}
+
+	p.Manager.Start()
+	if err := p.RequestSessionKeyRotation(); err != nil {
+		t.Fatalf("Could not request a session key rotation: %s", err)
+	}
+	done := make(chan struct{})
+	p.Inject(func() { close(done) })
+	<-done
+	if epoch := p.auth.current().epoch; epoch != 2 {
+		t.Fatalf("Expected the requested rotation to start epoch 2, at %d", epoch)
+	}
+	if len(announced) != 2 || announced[0] != 1 || announced[1] != 2 {
+		t.Errorf("Expected epochs 1 and 2 to be announced, announced %v", announced)
+	}
+
+	unauthenticated := newPbftCore(1, loadConfig(), &omniProto{})
+	defer unauthenticated.Close()
+	if err := unauthenticated.RequestSessionKeyRotation(); err == nil {
+		t.Errorf("Expected a rotation to be refused without MAC authentication")
+	}
+}
+
+func TestMACAuthenticatorInvalidKey(t *testing.T) {
+	a, _ := newMACAuthenticator(0, macConfig(), wallClock{})
+	if err := a.addPeerKey(&SessionKey{ReplicaId: 1, PublicKey: []byte("garbage")}); err == nil {
+		t.Errorf("Expected an invalid public key to be rejected")
+	}
+	if err := a.addPeerKey(&SessionKey{ReplicaId: 0, PublicKey: a.current().pub}); err == nil {
+		t.Errorf("Expected our own session key to be rejected")
+	}
+}
+
+func TestNetworkMACAuthentication(t *testing.T) {
+	validatorCount := 4
+	config := macConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.Stop()
+
+	broadcaster := uint64(generateBroadcaster(validatorCount))
+	for i := int64(1); i <= 3; i++ {
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(i, broadcaster)
+		if err := net.Process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 3 {
+			t.Errorf("Instance %d executed %d requests, expected 3", pep.ID, pep.sc.executions)
+		}
+		if epoch := pep.pbft.auth.current().epoch; epoch != 1 {
+			t.Errorf("Instance %d should have rotated its session key at the stable checkpoint, is at epoch %d", pep.ID, epoch)
+		}
+	}
+
+	// Once the session keys are known, commits are authenticated by MACs alone
+	cert := net.pbftEndpoints[1].pbft.certStore[msgID{0, 3}]
+	if cert == nil || len(cert.commit) != validatorCount {
+		t.Fatalf("Expected a full commit certificate for seqNo 3, got %v", cert)
+	}
+	for _, commit := range cert.commit {
+		auth := commit.Authenticator
+		if auth == nil || auth.Signature != nil {
+			t.Errorf("Expected commit of replica %d to be authenticated by MACs only, got %v", commit.ReplicaId, auth)
+		}
+	}
+}
+
+func TestMACAuthenticationRejectsForgery(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, macConfig())
+	defer net.Stop()
+
+	p := net.pbftEndpoints[1].pbft
+	commit := &Commit{View: 0, SequenceNumber: 1, RequestDigest: "foo", ReplicaId: 2}
+	if _, err := p.recvMsg(&Message{&Message_Commit{commit}}, 2); err == nil {
+		t.Errorf("Expected a commit without authenticator to be rejected")
+	}
+	commit.Authenticator = &Authenticator{Macs: [][]byte{nil, []byte("forged"), nil, nil}}
+	if _, err := p.recvMsg(&Message{&Message_Commit{commit}}, 2); err == nil {
+		t.Errorf("Expected a commit with a forged MAC to be rejected")
+	}
+}
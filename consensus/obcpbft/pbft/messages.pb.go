@@ -0,0 +1,2097 @@
+// Code generated by protoc-gen-go.
+// source: obcpbft/pbft/messages.proto
+// DO NOT EDIT!
+
+/*
+Package pbft is a generated protocol buffer package.
+
+It is generated from these files:
+	obcpbft/pbft/messages.proto
+
+It has these top-level messages:
+	Message
+	Request
+	TraceContext
+	TraceCarrierEntry
+	PrePrepare
+	Prepare
+	Commit
+	Authenticator
+	SessionKey
+	BlockInfo
+	Checkpoint
+	ViewChange
+	PQset
+	NewView
+	QueryReply
+	FetchRequest
+	RecoveryRequest
+	CheckpointRequest
+	CheckpointReply
+	CatchUpRequest
+	CatchUpCert
+	WireHello
+	Compressed
+	Ack
+	Heartbeat
+	GossipRequest
+	RequestDigests
+	Reply
+	ReplyCertificate
+	Evidence
+	AuditRecord
+	RequestBlock
+	BatchMessage
+	SieveMessage
+	Execute
+	Verify
+	SievePbftMessage
+	VerifySet
+	Flush
+	Metadata
+	HierarchyMessage
+	HierarchyEndorsement
+	LeaderCertificate
+	GroupCertificate
+	GroupPayload
+	TopDelivery
+	HierarchyState
+	HierarchyProgress
+	HierarchyLevelState
+*/
+package pbft
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import google_protobuf "google/protobuf"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type Message struct {
+	// Types that are valid to be assigned to Payload:
+	//	*Message_Request
+	//	*Message_PrePrepare
+	//	*Message_Prepare
+	//	*Message_Commit
+	//	*Message_Checkpoint
+	//	*Message_ViewChange
+	//	*Message_NewView
+	//	*Message_FetchRequest
+	//	*Message_ReturnRequest
+	//	*Message_SessionKey
+	//	*Message_QueryReply
+	//	*Message_RecoveryRequest
+	//	*Message_GossipRequest
+	//	*Message_RequestDigests
+	//	*Message_Reply
+	//	*Message_CheckpointRequest
+	//	*Message_CheckpointReply
+	//	*Message_CatchUpRequest
+	//	*Message_CatchUpCert
+	//	*Message_WireHello
+	//	*Message_Compressed
+	//	*Message_Ack
+	//	*Message_Heartbeat
+	//	*Message_CheckpointShare
+	//	*Message_RelayBundle
+	Payload isMessage_Payload `protobuf_oneof:"payload"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+
+type isMessage_Payload interface {
+	isMessage_Payload()
+}
+
+type Message_Request struct {
+	Request *Request `protobuf:"bytes,1,opt,name=request,oneof"`
+}
+type Message_PrePrepare struct {
+	PrePrepare *PrePrepare `protobuf:"bytes,2,opt,name=pre_prepare,oneof"`
+}
+type Message_Prepare struct {
+	Prepare *Prepare `protobuf:"bytes,3,opt,name=prepare,oneof"`
+}
+type Message_Commit struct {
+	Commit *Commit `protobuf:"bytes,4,opt,name=commit,oneof"`
+}
+type Message_Checkpoint struct {
+	Checkpoint *Checkpoint `protobuf:"bytes,5,opt,name=checkpoint,oneof"`
+}
+type Message_ViewChange struct {
+	ViewChange *ViewChange `protobuf:"bytes,6,opt,name=view_change,oneof"`
+}
+type Message_NewView struct {
+	NewView *NewView `protobuf:"bytes,7,opt,name=new_view,oneof"`
+}
+type Message_FetchRequest struct {
+	FetchRequest *FetchRequest `protobuf:"bytes,8,opt,name=fetch_request,oneof"`
+}
+type Message_ReturnRequest struct {
+	ReturnRequest *Request `protobuf:"bytes,9,opt,name=return_request,oneof"`
+}
+type Message_SessionKey struct {
+	SessionKey *SessionKey `protobuf:"bytes,10,opt,name=session_key,oneof"`
+}
+type Message_QueryReply struct {
+	QueryReply *QueryReply `protobuf:"bytes,11,opt,name=query_reply,oneof"`
+}
+type Message_RecoveryRequest struct {
+	RecoveryRequest *RecoveryRequest `protobuf:"bytes,12,opt,name=recovery_request,oneof"`
+}
+type Message_GossipRequest struct {
+	GossipRequest *GossipRequest `protobuf:"bytes,13,opt,name=gossip_request,oneof"`
+}
+type Message_RequestDigests struct {
+	RequestDigests *RequestDigests `protobuf:"bytes,14,opt,name=request_digests,oneof"`
+}
+type Message_Reply struct {
+	Reply *Reply `protobuf:"bytes,15,opt,name=reply,oneof"`
+}
+type Message_CheckpointRequest struct {
+	CheckpointRequest *CheckpointRequest `protobuf:"bytes,16,opt,name=checkpoint_request,oneof"`
+}
+type Message_CheckpointReply struct {
+	CheckpointReply *CheckpointReply `protobuf:"bytes,17,opt,name=checkpoint_reply,oneof"`
+}
+type Message_CatchUpRequest struct {
+	CatchUpRequest *CatchUpRequest `protobuf:"bytes,18,opt,name=catch_up_request,oneof"`
+}
+type Message_CatchUpCert struct {
+	CatchUpCert *CatchUpCert `protobuf:"bytes,19,opt,name=catch_up_cert,oneof"`
+}
+type Message_WireHello struct {
+	WireHello *WireHello `protobuf:"bytes,20,opt,name=wire_hello,oneof"`
+}
+type Message_Compressed struct {
+	Compressed *Compressed `protobuf:"bytes,21,opt,name=compressed,oneof"`
+}
+type Message_Ack struct {
+	Ack *Ack `protobuf:"bytes,22,opt,name=ack,oneof"`
+}
+type Message_Heartbeat struct {
+	Heartbeat *Heartbeat `protobuf:"bytes,23,opt,name=heartbeat,oneof"`
+}
+type Message_CheckpointShare struct {
+	CheckpointShare *CheckpointShare `protobuf:"bytes,24,opt,name=checkpoint_share,oneof"`
+}
+type Message_RelayBundle struct {
+	RelayBundle *RelayBundle `protobuf:"bytes,25,opt,name=relay_bundle,oneof"`
+}
+
+func (*Message_Request) isMessage_Payload()           {}
+func (*Message_PrePrepare) isMessage_Payload()        {}
+func (*Message_Prepare) isMessage_Payload()           {}
+func (*Message_Commit) isMessage_Payload()            {}
+func (*Message_Checkpoint) isMessage_Payload()        {}
+func (*Message_ViewChange) isMessage_Payload()        {}
+func (*Message_NewView) isMessage_Payload()           {}
+func (*Message_FetchRequest) isMessage_Payload()      {}
+func (*Message_ReturnRequest) isMessage_Payload()     {}
+func (*Message_SessionKey) isMessage_Payload()        {}
+func (*Message_QueryReply) isMessage_Payload()        {}
+func (*Message_RecoveryRequest) isMessage_Payload()   {}
+func (*Message_GossipRequest) isMessage_Payload()     {}
+func (*Message_RequestDigests) isMessage_Payload()    {}
+func (*Message_Reply) isMessage_Payload()             {}
+func (*Message_CheckpointRequest) isMessage_Payload() {}
+func (*Message_CheckpointReply) isMessage_Payload()   {}
+func (*Message_CatchUpRequest) isMessage_Payload()    {}
+func (*Message_CatchUpCert) isMessage_Payload()       {}
+func (*Message_WireHello) isMessage_Payload()         {}
+func (*Message_Compressed) isMessage_Payload()        {}
+func (*Message_Ack) isMessage_Payload()               {}
+func (*Message_Heartbeat) isMessage_Payload()         {}
+func (*Message_CheckpointShare) isMessage_Payload()   {}
+func (*Message_RelayBundle) isMessage_Payload()       {}
+
+func (m *Message) GetPayload() isMessage_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (m *Message) GetRequest() *Request {
+	if x, ok := m.GetPayload().(*Message_Request); ok {
+		return x.Request
+	}
+	return nil
+}
+
+func (m *Message) GetPrePrepare() *PrePrepare {
+	if x, ok := m.GetPayload().(*Message_PrePrepare); ok {
+		return x.PrePrepare
+	}
+	return nil
+}
+
+func (m *Message) GetPrepare() *Prepare {
+	if x, ok := m.GetPayload().(*Message_Prepare); ok {
+		return x.Prepare
+	}
+	return nil
+}
+
+func (m *Message) GetCommit() *Commit {
+	if x, ok := m.GetPayload().(*Message_Commit); ok {
+		return x.Commit
+	}
+	return nil
+}
+
+func (m *Message) GetCheckpoint() *Checkpoint {
+	if x, ok := m.GetPayload().(*Message_Checkpoint); ok {
+		return x.Checkpoint
+	}
+	return nil
+}
+
+func (m *Message) GetViewChange() *ViewChange {
+	if x, ok := m.GetPayload().(*Message_ViewChange); ok {
+		return x.ViewChange
+	}
+	return nil
+}
+
+func (m *Message) GetNewView() *NewView {
+	if x, ok := m.GetPayload().(*Message_NewView); ok {
+		return x.NewView
+	}
+	return nil
+}
+
+func (m *Message) GetFetchRequest() *FetchRequest {
+	if x, ok := m.GetPayload().(*Message_FetchRequest); ok {
+		return x.FetchRequest
+	}
+	return nil
+}
+
+func (m *Message) GetReturnRequest() *Request {
+	if x, ok := m.GetPayload().(*Message_ReturnRequest); ok {
+		return x.ReturnRequest
+	}
+	return nil
+}
+
+func (m *Message) GetSessionKey() *SessionKey {
+	if x, ok := m.GetPayload().(*Message_SessionKey); ok {
+		return x.SessionKey
+	}
+	return nil
+}
+
+func (m *Message) GetQueryReply() *QueryReply {
+	if x, ok := m.GetPayload().(*Message_QueryReply); ok {
+		return x.QueryReply
+	}
+	return nil
+}
+
+func (m *Message) GetRecoveryRequest() *RecoveryRequest {
+	if x, ok := m.GetPayload().(*Message_RecoveryRequest); ok {
+		return x.RecoveryRequest
+	}
+	return nil
+}
+
+func (m *Message) GetGossipRequest() *GossipRequest {
+	if x, ok := m.GetPayload().(*Message_GossipRequest); ok {
+		return x.GossipRequest
+	}
+	return nil
+}
+
+func (m *Message) GetRequestDigests() *RequestDigests {
+	if x, ok := m.GetPayload().(*Message_RequestDigests); ok {
+		return x.RequestDigests
+	}
+	return nil
+}
+
+func (m *Message) GetReply() *Reply {
+	if x, ok := m.GetPayload().(*Message_Reply); ok {
+		return x.Reply
+	}
+	return nil
+}
+
+func (m *Message) GetCheckpointRequest() *CheckpointRequest {
+	if x, ok := m.GetPayload().(*Message_CheckpointRequest); ok {
+		return x.CheckpointRequest
+	}
+	return nil
+}
+
+func (m *Message) GetCheckpointReply() *CheckpointReply {
+	if x, ok := m.GetPayload().(*Message_CheckpointReply); ok {
+		return x.CheckpointReply
+	}
+	return nil
+}
+
+func (m *Message) GetCatchUpRequest() *CatchUpRequest {
+	if x, ok := m.GetPayload().(*Message_CatchUpRequest); ok {
+		return x.CatchUpRequest
+	}
+	return nil
+}
+
+func (m *Message) GetCatchUpCert() *CatchUpCert {
+	if x, ok := m.GetPayload().(*Message_CatchUpCert); ok {
+		return x.CatchUpCert
+	}
+	return nil
+}
+
+func (m *Message) GetWireHello() *WireHello {
+	if x, ok := m.GetPayload().(*Message_WireHello); ok {
+		return x.WireHello
+	}
+	return nil
+}
+
+func (m *Message) GetCompressed() *Compressed {
+	if x, ok := m.GetPayload().(*Message_Compressed); ok {
+		return x.Compressed
+	}
+	return nil
+}
+
+func (m *Message) GetAck() *Ack {
+	if x, ok := m.GetPayload().(*Message_Ack); ok {
+		return x.Ack
+	}
+	return nil
+}
+
+func (m *Message) GetHeartbeat() *Heartbeat {
+	if x, ok := m.GetPayload().(*Message_Heartbeat); ok {
+		return x.Heartbeat
+	}
+	return nil
+}
+
+func (m *Message) GetCheckpointShare() *CheckpointShare {
+	if x, ok := m.GetPayload().(*Message_CheckpointShare); ok {
+		return x.CheckpointShare
+	}
+	return nil
+}
+
+func (m *Message) GetRelayBundle() *RelayBundle {
+	if x, ok := m.GetPayload().(*Message_RelayBundle); ok {
+		return x.RelayBundle
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*Message) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), []interface{}) {
+	return _Message_OneofMarshaler, _Message_OneofUnmarshaler, []interface{}{
+		(*Message_Request)(nil),
+		(*Message_PrePrepare)(nil),
+		(*Message_Prepare)(nil),
+		(*Message_Commit)(nil),
+		(*Message_Checkpoint)(nil),
+		(*Message_ViewChange)(nil),
+		(*Message_NewView)(nil),
+		(*Message_FetchRequest)(nil),
+		(*Message_ReturnRequest)(nil),
+		(*Message_SessionKey)(nil),
+		(*Message_QueryReply)(nil),
+		(*Message_RecoveryRequest)(nil),
+		(*Message_GossipRequest)(nil),
+		(*Message_RequestDigests)(nil),
+		(*Message_Reply)(nil),
+		(*Message_CheckpointRequest)(nil),
+		(*Message_CheckpointReply)(nil),
+		(*Message_CatchUpRequest)(nil),
+		(*Message_CatchUpCert)(nil),
+		(*Message_WireHello)(nil),
+		(*Message_Compressed)(nil),
+		(*Message_Ack)(nil),
+		(*Message_Heartbeat)(nil),
+		(*Message_CheckpointShare)(nil),
+		(*Message_RelayBundle)(nil),
+	}
+}
+
+func _Message_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*Message)
+	// payload
+	switch x := m.Payload.(type) {
+	case *Message_Request:
+		b.EncodeVarint(1<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Request); err != nil {
+			return err
+		}
+	case *Message_PrePrepare:
+		b.EncodeVarint(2<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.PrePrepare); err != nil {
+			return err
+		}
+	case *Message_Prepare:
+		b.EncodeVarint(3<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Prepare); err != nil {
+			return err
+		}
+	case *Message_Commit:
+		b.EncodeVarint(4<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Commit); err != nil {
+			return err
+		}
+	case *Message_Checkpoint:
+		b.EncodeVarint(5<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Checkpoint); err != nil {
+			return err
+		}
+	case *Message_ViewChange:
+		b.EncodeVarint(6<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.ViewChange); err != nil {
+			return err
+		}
+	case *Message_NewView:
+		b.EncodeVarint(7<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.NewView); err != nil {
+			return err
+		}
+	case *Message_FetchRequest:
+		b.EncodeVarint(8<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.FetchRequest); err != nil {
+			return err
+		}
+	case *Message_ReturnRequest:
+		b.EncodeVarint(9<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.ReturnRequest); err != nil {
+			return err
+		}
+	case *Message_SessionKey:
+		b.EncodeVarint(10<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.SessionKey); err != nil {
+			return err
+		}
+	case *Message_QueryReply:
+		b.EncodeVarint(11<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.QueryReply); err != nil {
+			return err
+		}
+	case *Message_RecoveryRequest:
+		b.EncodeVarint(12<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.RecoveryRequest); err != nil {
+			return err
+		}
+	case *Message_GossipRequest:
+		b.EncodeVarint(13<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.GossipRequest); err != nil {
+			return err
+		}
+	case *Message_RequestDigests:
+		b.EncodeVarint(14<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.RequestDigests); err != nil {
+			return err
+		}
+	case *Message_Reply:
+		b.EncodeVarint(15<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Reply); err != nil {
+			return err
+		}
+	case *Message_CheckpointRequest:
+		b.EncodeVarint(16<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.CheckpointRequest); err != nil {
+			return err
+		}
+	case *Message_CheckpointReply:
+		b.EncodeVarint(17<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.CheckpointReply); err != nil {
+			return err
+		}
+	case *Message_CatchUpRequest:
+		b.EncodeVarint(18<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.CatchUpRequest); err != nil {
+			return err
+		}
+	case *Message_CatchUpCert:
+		b.EncodeVarint(19<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.CatchUpCert); err != nil {
+			return err
+		}
+	case *Message_WireHello:
+		b.EncodeVarint(20<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.WireHello); err != nil {
+			return err
+		}
+	case *Message_Compressed:
+		b.EncodeVarint(21<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Compressed); err != nil {
+			return err
+		}
+	case *Message_Ack:
+		b.EncodeVarint(22<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Ack); err != nil {
+			return err
+		}
+	case *Message_Heartbeat:
+		b.EncodeVarint(23<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Heartbeat); err != nil {
+			return err
+		}
+	case *Message_CheckpointShare:
+		b.EncodeVarint(24<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.CheckpointShare); err != nil {
+			return err
+		}
+	case *Message_RelayBundle:
+		b.EncodeVarint(25<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.RelayBundle); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		return fmt.Errorf("Message.Payload has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _Message_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*Message)
+	switch tag {
+	case 1: // payload.request
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Request)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_Request{msg}
+		return true, err
+	case 2: // payload.pre_prepare
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(PrePrepare)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_PrePrepare{msg}
+		return true, err
+	case 3: // payload.prepare
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Prepare)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_Prepare{msg}
+		return true, err
+	case 4: // payload.commit
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Commit)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_Commit{msg}
+		return true, err
+	case 5: // payload.checkpoint
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Checkpoint)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_Checkpoint{msg}
+		return true, err
+	case 6: // payload.view_change
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(ViewChange)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_ViewChange{msg}
+		return true, err
+	case 7: // payload.new_view
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(NewView)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_NewView{msg}
+		return true, err
+	case 8: // payload.fetch_request
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(FetchRequest)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_FetchRequest{msg}
+		return true, err
+	case 9: // payload.return_request
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Request)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_ReturnRequest{msg}
+		return true, err
+	case 10: // payload.session_key
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(SessionKey)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_SessionKey{msg}
+		return true, err
+	case 11: // payload.query_reply
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(QueryReply)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_QueryReply{msg}
+		return true, err
+	case 12: // payload.recovery_request
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(RecoveryRequest)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_RecoveryRequest{msg}
+		return true, err
+	case 13: // payload.gossip_request
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(GossipRequest)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_GossipRequest{msg}
+		return true, err
+	case 14: // payload.request_digests
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(RequestDigests)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_RequestDigests{msg}
+		return true, err
+	case 15: // payload.reply
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Reply)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_Reply{msg}
+		return true, err
+	case 16: // payload.checkpoint_request
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(CheckpointRequest)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_CheckpointRequest{msg}
+		return true, err
+	case 17: // payload.checkpoint_reply
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(CheckpointReply)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_CheckpointReply{msg}
+		return true, err
+	case 18: // payload.catch_up_request
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(CatchUpRequest)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_CatchUpRequest{msg}
+		return true, err
+	case 19: // payload.catch_up_cert
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(CatchUpCert)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_CatchUpCert{msg}
+		return true, err
+	case 20: // payload.wire_hello
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(WireHello)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_WireHello{msg}
+		return true, err
+	case 21: // payload.compressed
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Compressed)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_Compressed{msg}
+		return true, err
+	case 22: // payload.ack
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Ack)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_Ack{msg}
+		return true, err
+	case 23: // payload.heartbeat
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Heartbeat)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_Heartbeat{msg}
+		return true, err
+	case 24: // payload.checkpoint_share
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(CheckpointShare)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_CheckpointShare{msg}
+		return true, err
+	case 25: // payload.relay_bundle
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(RelayBundle)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_RelayBundle{msg}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+type Request struct {
+	Timestamp     *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	Payload       []byte                     `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	ReplicaId     uint64                     `protobuf:"varint,3,opt,name=replica_id" json:"replica_id,omitempty"`
+	Signature     []byte                     `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	ReadOnly      bool                       `protobuf:"varint,5,opt,name=read_only" json:"read_only,omitempty"`
+	Priority      uint32                     `protobuf:"varint,6,opt,name=priority" json:"priority,omitempty"`
+	Trace         *TraceContext              `protobuf:"bytes,7,opt,name=trace" json:"trace,omitempty"`
+	Expiry        *google_protobuf.Timestamp `protobuf:"bytes,8,opt,name=expiry" json:"expiry,omitempty"`
+	CorrelationId string                     `protobuf:"bytes,9,opt,name=correlation_id" json:"correlation_id,omitempty"`
+	EpochReset    bool                       `protobuf:"varint,10,opt,name=epoch_reset" json:"epoch_reset,omitempty"`
+}
+
+func (m *Request) Reset()         { *m = Request{} }
+func (m *Request) String() string { return proto.CompactTextString(m) }
+func (*Request) ProtoMessage()    {}
+
+func (m *Request) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+func (m *Request) GetTrace() *TraceContext {
+	if m != nil {
+		return m.Trace
+	}
+	return nil
+}
+
+func (m *Request) GetExpiry() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Expiry
+	}
+	return nil
+}
+
+type TraceContext struct {
+	TraceId uint64               `protobuf:"varint,1,opt,name=trace_id" json:"trace_id,omitempty"`
+	SpanId  uint64               `protobuf:"varint,2,opt,name=span_id" json:"span_id,omitempty"`
+	Carrier []*TraceCarrierEntry `protobuf:"bytes,3,rep,name=carrier" json:"carrier,omitempty"`
+}
+
+func (m *TraceContext) Reset()         { *m = TraceContext{} }
+func (m *TraceContext) String() string { return proto.CompactTextString(m) }
+func (*TraceContext) ProtoMessage()    {}
+
+func (m *TraceContext) GetCarrier() []*TraceCarrierEntry {
+	if m != nil {
+		return m.Carrier
+	}
+	return nil
+}
+
+// a list rather than a map, as maps do not marshal deterministically and the
+// trace context is part of the digest of its request
+type TraceCarrierEntry struct {
+	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *TraceCarrierEntry) Reset()         { *m = TraceCarrierEntry{} }
+func (m *TraceCarrierEntry) String() string { return proto.CompactTextString(m) }
+func (*TraceCarrierEntry) ProtoMessage()    {}
+
+type PrePrepare struct {
+	View            uint64   `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	SequenceNumber  uint64   `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	RequestDigest   string   `protobuf:"bytes,3,opt,name=request_digest" json:"request_digest,omitempty"`
+	Request         *Request `protobuf:"bytes,4,opt,name=request" json:"request,omitempty"`
+	ReplicaId       uint64   `protobuf:"varint,5,opt,name=replica_id" json:"replica_id,omitempty"`
+	DigestAlgorithm string   `protobuf:"bytes,6,opt,name=digest_algorithm" json:"digest_algorithm,omitempty"`
+	Epoch           uint64   `protobuf:"varint,7,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *PrePrepare) Reset()         { *m = PrePrepare{} }
+func (m *PrePrepare) String() string { return proto.CompactTextString(m) }
+func (*PrePrepare) ProtoMessage()    {}
+
+func (m *PrePrepare) GetRequest() *Request {
+	if m != nil {
+		return m.Request
+	}
+	return nil
+}
+
+type Prepare struct {
+	View           uint64         `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	SequenceNumber uint64         `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	RequestDigest  string         `protobuf:"bytes,3,opt,name=request_digest" json:"request_digest,omitempty"`
+	ReplicaId      uint64         `protobuf:"varint,4,opt,name=replica_id" json:"replica_id,omitempty"`
+	Authenticator  *Authenticator `protobuf:"bytes,5,opt,name=authenticator" json:"authenticator,omitempty"`
+	Epoch          uint64         `protobuf:"varint,6,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *Prepare) Reset()         { *m = Prepare{} }
+func (m *Prepare) String() string { return proto.CompactTextString(m) }
+func (*Prepare) ProtoMessage()    {}
+
+func (m *Prepare) GetAuthenticator() *Authenticator {
+	if m != nil {
+		return m.Authenticator
+	}
+	return nil
+}
+
+type Commit struct {
+	View           uint64         `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	SequenceNumber uint64         `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	RequestDigest  string         `protobuf:"bytes,3,opt,name=request_digest" json:"request_digest,omitempty"`
+	ReplicaId      uint64         `protobuf:"varint,4,opt,name=replica_id" json:"replica_id,omitempty"`
+	Authenticator  *Authenticator `protobuf:"bytes,5,opt,name=authenticator" json:"authenticator,omitempty"`
+	Share          []byte         `protobuf:"bytes,6,opt,name=share,proto3" json:"share,omitempty"`
+	Epoch          uint64         `protobuf:"varint,7,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *Commit) Reset()         { *m = Commit{} }
+func (m *Commit) String() string { return proto.CompactTextString(m) }
+func (*Commit) ProtoMessage()    {}
+
+func (m *Commit) GetAuthenticator() *Authenticator {
+	if m != nil {
+		return m.Authenticator
+	}
+	return nil
+}
+
+type Authenticator struct {
+	Epoch     uint64   `protobuf:"varint,1,opt,name=epoch" json:"epoch,omitempty"`
+	Macs      [][]byte `protobuf:"bytes,2,rep,name=macs,proto3" json:"macs,omitempty"`
+	Signature []byte   `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *Authenticator) Reset()         { *m = Authenticator{} }
+func (m *Authenticator) String() string { return proto.CompactTextString(m) }
+func (*Authenticator) ProtoMessage()    {}
+
+type SessionKey struct {
+	ReplicaId      uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	Epoch          uint64 `protobuf:"varint,2,opt,name=epoch" json:"epoch,omitempty"`
+	PublicKey      []byte `protobuf:"bytes,3,opt,name=public_key,proto3" json:"public_key,omitempty"`
+	ReplyRequested bool   `protobuf:"varint,4,opt,name=reply_requested" json:"reply_requested,omitempty"`
+	Signature      []byte `protobuf:"bytes,5,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *SessionKey) Reset()         { *m = SessionKey{} }
+func (m *SessionKey) String() string { return proto.CompactTextString(m) }
+func (*SessionKey) ProtoMessage()    {}
+
+type BlockInfo struct {
+	BlockNumber uint64 `protobuf:"varint,1,opt,name=block_number" json:"block_number,omitempty"`
+	BlockHash   []byte `protobuf:"bytes,2,opt,name=block_hash,proto3" json:"block_hash,omitempty"`
+}
+
+func (m *BlockInfo) Reset()         { *m = BlockInfo{} }
+func (m *BlockInfo) String() string { return proto.CompactTextString(m) }
+func (*BlockInfo) ProtoMessage()    {}
+
+type Checkpoint struct {
+	SequenceNumber  uint64 `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	ReplicaId       uint64 `protobuf:"varint,2,opt,name=replica_id" json:"replica_id,omitempty"`
+	Id              string `protobuf:"bytes,3,opt,name=id" json:"id,omitempty"`
+	DigestAlgorithm string `protobuf:"bytes,4,opt,name=digest_algorithm" json:"digest_algorithm,omitempty"`
+	Epoch           uint64 `protobuf:"varint,5,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *Checkpoint) Reset()         { *m = Checkpoint{} }
+func (m *Checkpoint) String() string { return proto.CompactTextString(m) }
+func (*Checkpoint) ProtoMessage()    {}
+
+type ViewChange struct {
+	View      uint64           `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	H         uint64           `protobuf:"varint,2,opt,name=h" json:"h,omitempty"`
+	Cset      []*ViewChange_C  `protobuf:"bytes,3,rep,name=cset" json:"cset,omitempty"`
+	Pset      []*ViewChange_PQ `protobuf:"bytes,4,rep,name=pset" json:"pset,omitempty"`
+	Qset      []*ViewChange_PQ `protobuf:"bytes,5,rep,name=qset" json:"qset,omitempty"`
+	ReplicaId uint64           `protobuf:"varint,6,opt,name=replica_id" json:"replica_id,omitempty"`
+	Signature []byte           `protobuf:"bytes,7,opt,name=signature,proto3" json:"signature,omitempty"`
+	Epoch     uint64           `protobuf:"varint,8,opt,name=epoch" json:"epoch,omitempty"`
+	Blacklist *BlacklistState  `protobuf:"bytes,9,opt,name=blacklist" json:"blacklist,omitempty"`
+}
+
+func (m *ViewChange) Reset()         { *m = ViewChange{} }
+func (m *ViewChange) String() string { return proto.CompactTextString(m) }
+func (*ViewChange) ProtoMessage()    {}
+
+func (m *ViewChange) GetCset() []*ViewChange_C {
+	if m != nil {
+		return m.Cset
+	}
+	return nil
+}
+
+func (m *ViewChange) GetPset() []*ViewChange_PQ {
+	if m != nil {
+		return m.Pset
+	}
+	return nil
+}
+
+func (m *ViewChange) GetQset() []*ViewChange_PQ {
+	if m != nil {
+		return m.Qset
+	}
+	return nil
+}
+
+func (m *ViewChange) GetBlacklist() *BlacklistState {
+	if m != nil {
+		return m.Blacklist
+	}
+	return nil
+}
+
+// This message should go away and become a checkpoint once replica_id is removed
+type ViewChange_C struct {
+	SequenceNumber uint64 `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Id             string `protobuf:"bytes,3,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *ViewChange_C) Reset()         { *m = ViewChange_C{} }
+func (m *ViewChange_C) String() string { return proto.CompactTextString(m) }
+func (*ViewChange_C) ProtoMessage()    {}
+
+type ViewChange_PQ struct {
+	SequenceNumber uint64 `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Digest         string `protobuf:"bytes,2,opt,name=digest" json:"digest,omitempty"`
+	View           uint64 `protobuf:"varint,3,opt,name=view" json:"view,omitempty"`
+}
+
+func (m *ViewChange_PQ) Reset()         { *m = ViewChange_PQ{} }
+func (m *ViewChange_PQ) String() string { return proto.CompactTextString(m) }
+func (*ViewChange_PQ) ProtoMessage()    {}
+
+// the state of the primary blacklist as of an installed view, which follows
+// from the new-view messages installed so far, the same on all replicas
+type BlacklistState struct {
+	View     uint64                `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	Cycled   bool                  `protobuf:"varint,2,opt,name=cycled" json:"cycled,omitempty"`
+	Failures []uint64              `protobuf:"varint,3,rep,packed,name=failures" json:"failures,omitempty"`
+	Bans     []*BlacklistState_Ban `protobuf:"bytes,4,rep,name=bans" json:"bans,omitempty"`
+}
+
+func (m *BlacklistState) Reset()         { *m = BlacklistState{} }
+func (m *BlacklistState) String() string { return proto.CompactTextString(m) }
+func (*BlacklistState) ProtoMessage()    {}
+
+func (m *BlacklistState) GetBans() []*BlacklistState_Ban {
+	if m != nil {
+		return m.Bans
+	}
+	return nil
+}
+
+type BlacklistState_Ban struct {
+	ReplicaId uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	From      uint64 `protobuf:"varint,2,opt,name=from" json:"from,omitempty"`
+	Until     uint64 `protobuf:"varint,3,opt,name=until" json:"until,omitempty"`
+}
+
+func (m *BlacklistState_Ban) Reset()         { *m = BlacklistState_Ban{} }
+func (m *BlacklistState_Ban) String() string { return proto.CompactTextString(m) }
+func (*BlacklistState_Ban) ProtoMessage()    {}
+
+type PQset struct {
+	Set []*ViewChange_PQ `protobuf:"bytes,1,rep,name=set" json:"set,omitempty"`
+}
+
+func (m *PQset) Reset()         { *m = PQset{} }
+func (m *PQset) String() string { return proto.CompactTextString(m) }
+func (*PQset) ProtoMessage()    {}
+
+func (m *PQset) GetSet() []*ViewChange_PQ {
+	if m != nil {
+		return m.Set
+	}
+	return nil
+}
+
+type NewView struct {
+	View      uint64            `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	Vset      []*ViewChange     `protobuf:"bytes,2,rep,name=vset" json:"vset,omitempty"`
+	Xset      map[uint64]string `protobuf:"bytes,3,rep,name=xset" json:"xset,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	ReplicaId uint64            `protobuf:"varint,4,opt,name=replica_id" json:"replica_id,omitempty"`
+	Epoch     uint64            `protobuf:"varint,5,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *NewView) Reset()         { *m = NewView{} }
+func (m *NewView) String() string { return proto.CompactTextString(m) }
+func (*NewView) ProtoMessage()    {}
+
+func (m *NewView) GetVset() []*ViewChange {
+	if m != nil {
+		return m.Vset
+	}
+	return nil
+}
+
+func (m *NewView) GetXset() map[uint64]string {
+	if m != nil {
+		return m.Xset
+	}
+	return nil
+}
+
+type QueryReply struct {
+	RequestDigest string `protobuf:"bytes,1,opt,name=request_digest" json:"request_digest,omitempty"`
+	ReplicaId     uint64 `protobuf:"varint,2,opt,name=replica_id" json:"replica_id,omitempty"`
+	Result        []byte `protobuf:"bytes,3,opt,name=result,proto3" json:"result,omitempty"`
+	Error         string `protobuf:"bytes,4,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *QueryReply) Reset()         { *m = QueryReply{} }
+func (m *QueryReply) String() string { return proto.CompactTextString(m) }
+func (*QueryReply) ProtoMessage()    {}
+
+type FetchRequest struct {
+	RequestDigest string `protobuf:"bytes,1,opt,name=request_digest" json:"request_digest,omitempty"`
+	ReplicaId     uint64 `protobuf:"varint,2,opt,name=replica_id" json:"replica_id,omitempty"`
+}
+
+func (m *FetchRequest) Reset()         { *m = FetchRequest{} }
+func (m *FetchRequest) String() string { return proto.CompactTextString(m) }
+func (*FetchRequest) ProtoMessage()    {}
+
+type RecoveryRequest struct {
+	ReplicaId uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	H         uint64 `protobuf:"varint,2,opt,name=h" json:"h,omitempty"`
+	LastExec  uint64 `protobuf:"varint,3,opt,name=last_exec" json:"last_exec,omitempty"`
+}
+
+func (m *RecoveryRequest) Reset()         { *m = RecoveryRequest{} }
+func (m *RecoveryRequest) String() string { return proto.CompactTextString(m) }
+func (*RecoveryRequest) ProtoMessage()    {}
+
+type CheckpointRequest struct {
+	ReplicaId uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	H         uint64 `protobuf:"varint,2,opt,name=h" json:"h,omitempty"`
+}
+
+func (m *CheckpointRequest) Reset()         { *m = CheckpointRequest{} }
+func (m *CheckpointRequest) String() string { return proto.CompactTextString(m) }
+func (*CheckpointRequest) ProtoMessage()    {}
+
+type CheckpointReply struct {
+	ReplicaId            uint64                 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	Stable               *Checkpoint            `protobuf:"bytes,2,opt,name=stable" json:"stable,omitempty"`
+	HighWatermark        uint64                 `protobuf:"varint,3,opt,name=high_watermark" json:"high_watermark,omitempty"`
+	Certificate          []*Checkpoint          `protobuf:"bytes,4,rep,name=certificate" json:"certificate,omitempty"`
+	ThresholdCertificate *CheckpointCertificate `protobuf:"bytes,5,opt,name=threshold_certificate" json:"threshold_certificate,omitempty"`
+}
+
+func (m *CheckpointReply) Reset()         { *m = CheckpointReply{} }
+func (m *CheckpointReply) String() string { return proto.CompactTextString(m) }
+func (*CheckpointReply) ProtoMessage()    {}
+
+func (m *CheckpointReply) GetStable() *Checkpoint {
+	if m != nil {
+		return m.Stable
+	}
+	return nil
+}
+
+func (m *CheckpointReply) GetCertificate() []*Checkpoint {
+	if m != nil {
+		return m.Certificate
+	}
+	return nil
+}
+
+func (m *CheckpointReply) GetThresholdCertificate() *CheckpointCertificate {
+	if m != nil {
+		return m.ThresholdCertificate
+	}
+	return nil
+}
+
+type CatchUpRequest struct {
+	ReplicaId uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	Low       uint64 `protobuf:"varint,2,opt,name=low" json:"low,omitempty"`
+	High      uint64 `protobuf:"varint,3,opt,name=high" json:"high,omitempty"`
+}
+
+func (m *CatchUpRequest) Reset()         { *m = CatchUpRequest{} }
+func (m *CatchUpRequest) String() string { return proto.CompactTextString(m) }
+func (*CatchUpRequest) ProtoMessage()    {}
+
+type CatchUpCert struct {
+	ReplicaId  uint64      `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	PrePrepare *PrePrepare `protobuf:"bytes,2,opt,name=pre_prepare" json:"pre_prepare,omitempty"`
+	Prepare    []*Prepare  `protobuf:"bytes,3,rep,name=prepare" json:"prepare,omitempty"`
+	Commit     []*Commit   `protobuf:"bytes,4,rep,name=commit" json:"commit,omitempty"`
+	Request    *Request    `protobuf:"bytes,5,opt,name=request" json:"request,omitempty"`
+}
+
+func (m *CatchUpCert) Reset()         { *m = CatchUpCert{} }
+func (m *CatchUpCert) String() string { return proto.CompactTextString(m) }
+func (*CatchUpCert) ProtoMessage()    {}
+
+func (m *CatchUpCert) GetPrePrepare() *PrePrepare {
+	if m != nil {
+		return m.PrePrepare
+	}
+	return nil
+}
+
+func (m *CatchUpCert) GetPrepare() []*Prepare {
+	if m != nil {
+		return m.Prepare
+	}
+	return nil
+}
+
+func (m *CatchUpCert) GetCommit() []*Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
+func (m *CatchUpCert) GetRequest() *Request {
+	if m != nil {
+		return m.Request
+	}
+	return nil
+}
+
+// announces the range of wire format versions a replica speaks, replicas
+// exchange them when they connect to agree on the version of the messages
+// they send each other
+type WireHello struct {
+	ReplicaId      uint64   `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	MinVersion     uint32   `protobuf:"varint,2,opt,name=min_version" json:"min_version,omitempty"`
+	MaxVersion     uint32   `protobuf:"varint,3,opt,name=max_version" json:"max_version,omitempty"`
+	Reply          bool     `protobuf:"varint,4,opt,name=reply" json:"reply,omitempty"`
+	Compression    []string `protobuf:"bytes,5,rep,name=compression" json:"compression,omitempty"`
+	Ed25519Key     []byte   `protobuf:"bytes,6,opt,name=ed25519_key,proto3" json:"ed25519_key,omitempty"`
+	KeyEndorsement []byte   `protobuf:"bytes,7,opt,name=key_endorsement,proto3" json:"key_endorsement,omitempty"`
+}
+
+func (m *WireHello) Reset()         { *m = WireHello{} }
+func (m *WireHello) String() string { return proto.CompactTextString(m) }
+func (*WireHello) ProtoMessage()    {}
+
+// wraps a message compressed with an algorithm its receivers announced in
+// their wire hellos
+type Compressed struct {
+	Algorithm string `protobuf:"bytes,1,opt,name=algorithm" json:"algorithm,omitempty"`
+	Payload   []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+}
+
+func (m *Compressed) Reset()         { *m = Compressed{} }
+func (m *Compressed) String() string { return proto.CompactTextString(m) }
+func (*Compressed) ProtoMessage()    {}
+
+// acknowledges the receipt of a view-change, new-view or catch-up message,
+// which its sender retransmits until it is acknowledged
+type Ack struct {
+	ReplicaId uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	Digest    []byte `protobuf:"bytes,2,opt,name=digest,proto3" json:"digest,omitempty"`
+}
+
+func (m *Ack) Reset()         { *m = Ack{} }
+func (m *Ack) String() string { return proto.CompactTextString(m) }
+func (*Ack) ProtoMessage()    {}
+
+// announces that a replica is alive, along with its view and its last
+// executed sequence number, to every other replica
+type Heartbeat struct {
+	ReplicaId uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	View      uint64 `protobuf:"varint,2,opt,name=view" json:"view,omitempty"`
+	LastExec  uint64 `protobuf:"varint,3,opt,name=last_exec" json:"last_exec,omitempty"`
+}
+
+func (m *Heartbeat) Reset()         { *m = Heartbeat{} }
+func (m *Heartbeat) String() string { return proto.CompactTextString(m) }
+func (*Heartbeat) ProtoMessage()    {}
+
+// a share of the threshold signature over a checkpoint, which a quorum of
+// replicas combines into a checkpoint certificate
+type CheckpointShare struct {
+	ReplicaId      uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	SequenceNumber uint64 `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Id             string `protobuf:"bytes,3,opt,name=id" json:"id,omitempty"`
+	Share          []byte `protobuf:"bytes,4,opt,name=share,proto3" json:"share,omitempty"`
+}
+
+func (m *CheckpointShare) Reset()         { *m = CheckpointShare{} }
+func (m *CheckpointShare) String() string { return proto.CompactTextString(m) }
+func (*CheckpointShare) ProtoMessage()    {}
+
+// proves that a quorum of replicas reached a checkpoint, with a single
+// threshold signature rather than the checkpoints of all of them
+type CheckpointCertificate struct {
+	SequenceNumber uint64 `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Id             string `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Signature      []byte `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *CheckpointCertificate) Reset()         { *m = CheckpointCertificate{} }
+func (m *CheckpointCertificate) String() string { return proto.CompactTextString(m) }
+func (*CheckpointCertificate) ProtoMessage()    {}
+
+// proves that a quorum of replicas committed a request at a sequence
+// number, with a single threshold signature rather than their commits
+type CommitCertificate struct {
+	SequenceNumber uint64 `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	RequestDigest  string `protobuf:"bytes,2,opt,name=request_digest" json:"request_digest,omitempty"`
+	Signature      []byte `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *CommitCertificate) Reset()         { *m = CommitCertificate{} }
+func (m *CommitCertificate) String() string { return proto.CompactTextString(m) }
+func (*CommitCertificate) ProtoMessage()    {}
+
+type GossipRequest struct {
+	Request   *Request `protobuf:"bytes,1,opt,name=request" json:"request,omitempty"`
+	ReplicaId uint64   `protobuf:"varint,2,opt,name=replica_id" json:"replica_id,omitempty"`
+}
+
+func (m *GossipRequest) Reset()         { *m = GossipRequest{} }
+func (m *GossipRequest) String() string { return proto.CompactTextString(m) }
+func (*GossipRequest) ProtoMessage()    {}
+
+func (m *GossipRequest) GetRequest() *Request {
+	if m != nil {
+		return m.Request
+	}
+	return nil
+}
+
+type RequestDigests struct {
+	ReplicaId uint64   `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	Digests   []string `protobuf:"bytes,2,rep,name=digests" json:"digests,omitempty"`
+}
+
+func (m *RequestDigests) Reset()         { *m = RequestDigests{} }
+func (m *RequestDigests) String() string { return proto.CompactTextString(m) }
+func (*RequestDigests) ProtoMessage()    {}
+
+// the prepares or commits an aggregator collected for a request, forwarded
+// to all replicas in place of every replica broadcasting its own
+type RelayBundle struct {
+	ReplicaId uint64     `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	Prepares  []*Prepare `protobuf:"bytes,2,rep,name=prepares" json:"prepares,omitempty"`
+	Commits   []*Commit  `protobuf:"bytes,3,rep,name=commits" json:"commits,omitempty"`
+}
+
+func (m *RelayBundle) Reset()         { *m = RelayBundle{} }
+func (m *RelayBundle) String() string { return proto.CompactTextString(m) }
+func (*RelayBundle) ProtoMessage()    {}
+
+func (m *RelayBundle) GetPrepares() []*Prepare {
+	if m != nil {
+		return m.Prepares
+	}
+	return nil
+}
+
+func (m *RelayBundle) GetCommits() []*Commit {
+	if m != nil {
+		return m.Commits
+	}
+	return nil
+}
+
+type Reply struct {
+	View           uint64 `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	SequenceNumber uint64 `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	RequestDigest  string `protobuf:"bytes,3,opt,name=request_digest" json:"request_digest,omitempty"`
+	Result         []byte `protobuf:"bytes,4,opt,name=result,proto3" json:"result,omitempty"`
+	ReplicaId      uint64 `protobuf:"varint,5,opt,name=replica_id" json:"replica_id,omitempty"`
+	Signature      []byte `protobuf:"bytes,6,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *Reply) Reset()         { *m = Reply{} }
+func (m *Reply) String() string { return proto.CompactTextString(m) }
+func (*Reply) ProtoMessage()    {}
+
+type ReplyCertificate struct {
+	Replies []*Reply `protobuf:"bytes,1,rep,name=replies" json:"replies,omitempty"`
+}
+
+func (m *ReplyCertificate) Reset()         { *m = ReplyCertificate{} }
+func (m *ReplyCertificate) String() string { return proto.CompactTextString(m) }
+func (*ReplyCertificate) ProtoMessage()    {}
+
+func (m *ReplyCertificate) GetReplies() []*Reply {
+	if m != nil {
+		return m.Replies
+	}
+	return nil
+}
+
+type Evidence struct {
+	ReplicaId      uint64                     `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	Accused        uint64                     `protobuf:"varint,2,opt,name=accused" json:"accused,omitempty"`
+	Fault          string                     `protobuf:"bytes,3,opt,name=fault" json:"fault,omitempty"`
+	Description    string                     `protobuf:"bytes,4,opt,name=description" json:"description,omitempty"`
+	View           uint64                     `protobuf:"varint,5,opt,name=view" json:"view,omitempty"`
+	SequenceNumber uint64                     `protobuf:"varint,6,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Messages       []*Message                 `protobuf:"bytes,7,rep,name=messages" json:"messages,omitempty"`
+	Timestamp      *google_protobuf.Timestamp `protobuf:"bytes,8,opt,name=timestamp" json:"timestamp,omitempty"`
+	Signature      []byte                     `protobuf:"bytes,9,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *Evidence) Reset()         { *m = Evidence{} }
+func (m *Evidence) String() string { return proto.CompactTextString(m) }
+func (*Evidence) ProtoMessage()    {}
+
+func (m *Evidence) GetMessages() []*Message {
+	if m != nil {
+		return m.Messages
+	}
+	return nil
+}
+
+func (m *Evidence) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+type AuditRecord struct {
+	SequenceNumber uint64                     `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	View           uint64                     `protobuf:"varint,2,opt,name=view" json:"view,omitempty"`
+	RequestDigest  string                     `protobuf:"bytes,3,opt,name=request_digest" json:"request_digest,omitempty"`
+	Commits        []*Commit                  `protobuf:"bytes,4,rep,name=commits" json:"commits,omitempty"`
+	Previous       []byte                     `protobuf:"bytes,5,opt,name=previous,proto3" json:"previous,omitempty"`
+	ReplicaId      uint64                     `protobuf:"varint,6,opt,name=replica_id" json:"replica_id,omitempty"`
+	Timestamp      *google_protobuf.Timestamp `protobuf:"bytes,7,opt,name=timestamp" json:"timestamp,omitempty"`
+	Signature      []byte                     `protobuf:"bytes,8,opt,name=signature,proto3" json:"signature,omitempty"`
+	Epoch          uint64                     `protobuf:"varint,9,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *AuditRecord) Reset()         { *m = AuditRecord{} }
+func (m *AuditRecord) String() string { return proto.CompactTextString(m) }
+func (*AuditRecord) ProtoMessage()    {}
+
+func (m *AuditRecord) GetCommits() []*Commit {
+	if m != nil {
+		return m.Commits
+	}
+	return nil
+}
+
+func (m *AuditRecord) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+type RequestBlock struct {
+	Requests []*Request `protobuf:"bytes,1,rep,name=requests" json:"requests,omitempty"`
+}
+
+func (m *RequestBlock) Reset()         { *m = RequestBlock{} }
+func (m *RequestBlock) String() string { return proto.CompactTextString(m) }
+func (*RequestBlock) ProtoMessage()    {}
+
+func (m *RequestBlock) GetRequests() []*Request {
+	if m != nil {
+		return m.Requests
+	}
+	return nil
+}
+
+type BatchMessage struct {
+	// Types that are valid to be assigned to Payload:
+	//	*BatchMessage_Request
+	//	*BatchMessage_PbftMessage
+	//	*BatchMessage_Complaint
+	Payload isBatchMessage_Payload `protobuf_oneof:"payload"`
+}
+
+func (m *BatchMessage) Reset()         { *m = BatchMessage{} }
+func (m *BatchMessage) String() string { return proto.CompactTextString(m) }
+func (*BatchMessage) ProtoMessage()    {}
+
+type isBatchMessage_Payload interface {
+	isBatchMessage_Payload()
+}
+
+type BatchMessage_Request struct {
+	Request *Request `protobuf:"bytes,1,opt,name=request,oneof"`
+}
+type BatchMessage_PbftMessage struct {
+	PbftMessage []byte `protobuf:"bytes,4,opt,name=pbft_message,proto3,oneof"`
+}
+type BatchMessage_Complaint struct {
+	Complaint *Request `protobuf:"bytes,5,opt,name=complaint,oneof"`
+}
+
+func (*BatchMessage_Request) isBatchMessage_Payload()     {}
+func (*BatchMessage_PbftMessage) isBatchMessage_Payload() {}
+func (*BatchMessage_Complaint) isBatchMessage_Payload()   {}
+
+func (m *BatchMessage) GetPayload() isBatchMessage_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (m *BatchMessage) GetRequest() *Request {
+	if x, ok := m.GetPayload().(*BatchMessage_Request); ok {
+		return x.Request
+	}
+	return nil
+}
+
+func (m *BatchMessage) GetPbftMessage() []byte {
+	if x, ok := m.GetPayload().(*BatchMessage_PbftMessage); ok {
+		return x.PbftMessage
+	}
+	return nil
+}
+
+func (m *BatchMessage) GetComplaint() *Request {
+	if x, ok := m.GetPayload().(*BatchMessage_Complaint); ok {
+		return x.Complaint
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*BatchMessage) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), []interface{}) {
+	return _BatchMessage_OneofMarshaler, _BatchMessage_OneofUnmarshaler, []interface{}{
+		(*BatchMessage_Request)(nil),
+		(*BatchMessage_PbftMessage)(nil),
+		(*BatchMessage_Complaint)(nil),
+	}
+}
+
+func _BatchMessage_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*BatchMessage)
+	// payload
+	switch x := m.Payload.(type) {
+	case *BatchMessage_Request:
+		b.EncodeVarint(1<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Request); err != nil {
+			return err
+		}
+	case *BatchMessage_PbftMessage:
+		b.EncodeVarint(4<<3 | proto.WireBytes)
+		b.EncodeRawBytes(x.PbftMessage)
+	case *BatchMessage_Complaint:
+		b.EncodeVarint(5<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Complaint); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		return fmt.Errorf("BatchMessage.Payload has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _BatchMessage_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*BatchMessage)
+	switch tag {
+	case 1: // payload.request
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Request)
+		err := b.DecodeMessage(msg)
+		m.Payload = &BatchMessage_Request{msg}
+		return true, err
+	case 4: // payload.pbft_message
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeRawBytes(true)
+		m.Payload = &BatchMessage_PbftMessage{x}
+		return true, err
+	case 5: // payload.complaint
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Request)
+		err := b.DecodeMessage(msg)
+		m.Payload = &BatchMessage_Complaint{msg}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+type SieveMessage struct {
+	// Types that are valid to be assigned to Payload:
+	//	*SieveMessage_Request
+	//	*SieveMessage_Execute
+	//	*SieveMessage_Verify
+	//	*SieveMessage_PbftMessage
+	//	*SieveMessage_Complaint
+	Payload isSieveMessage_Payload `protobuf_oneof:"payload"`
+}
+
+func (m *SieveMessage) Reset()         { *m = SieveMessage{} }
+func (m *SieveMessage) String() string { return proto.CompactTextString(m) }
+func (*SieveMessage) ProtoMessage()    {}
+
+type isSieveMessage_Payload interface {
+	isSieveMessage_Payload()
+}
+
+type SieveMessage_Request struct {
+	Request *Request `protobuf:"bytes,1,opt,name=request,oneof"`
+}
+type SieveMessage_Execute struct {
+	Execute *Execute `protobuf:"bytes,2,opt,name=execute,oneof"`
+}
+type SieveMessage_Verify struct {
+	Verify *Verify `protobuf:"bytes,3,opt,name=verify,oneof"`
+}
+type SieveMessage_PbftMessage struct {
+	PbftMessage []byte `protobuf:"bytes,4,opt,name=pbft_message,proto3,oneof"`
+}
+type SieveMessage_Complaint struct {
+	Complaint *Request `protobuf:"bytes,5,opt,name=complaint,oneof"`
+}
+
+func (*SieveMessage_Request) isSieveMessage_Payload()     {}
+func (*SieveMessage_Execute) isSieveMessage_Payload()     {}
+func (*SieveMessage_Verify) isSieveMessage_Payload()      {}
+func (*SieveMessage_PbftMessage) isSieveMessage_Payload() {}
+func (*SieveMessage_Complaint) isSieveMessage_Payload()   {}
+
+func (m *SieveMessage) GetPayload() isSieveMessage_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (m *SieveMessage) GetRequest() *Request {
+	if x, ok := m.GetPayload().(*SieveMessage_Request); ok {
+		return x.Request
+	}
+	return nil
+}
+
+func (m *SieveMessage) GetExecute() *Execute {
+	if x, ok := m.GetPayload().(*SieveMessage_Execute); ok {
+		return x.Execute
+	}
+	return nil
+}
+
+func (m *SieveMessage) GetVerify() *Verify {
+	if x, ok := m.GetPayload().(*SieveMessage_Verify); ok {
+		return x.Verify
+	}
+	return nil
+}
+
+func (m *SieveMessage) GetPbftMessage() []byte {
+	if x, ok := m.GetPayload().(*SieveMessage_PbftMessage); ok {
+		return x.PbftMessage
+	}
+	return nil
+}
+
+func (m *SieveMessage) GetComplaint() *Request {
+	if x, ok := m.GetPayload().(*SieveMessage_Complaint); ok {
+		return x.Complaint
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*SieveMessage) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), []interface{}) {
+	return _SieveMessage_OneofMarshaler, _SieveMessage_OneofUnmarshaler, []interface{}{
+		(*SieveMessage_Request)(nil),
+		(*SieveMessage_Execute)(nil),
+		(*SieveMessage_Verify)(nil),
+		(*SieveMessage_PbftMessage)(nil),
+		(*SieveMessage_Complaint)(nil),
+	}
+}
+
+func _SieveMessage_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*SieveMessage)
+	// payload
+	switch x := m.Payload.(type) {
+	case *SieveMessage_Request:
+		b.EncodeVarint(1<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Request); err != nil {
+			return err
+		}
+	case *SieveMessage_Execute:
+		b.EncodeVarint(2<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Execute); err != nil {
+			return err
+		}
+	case *SieveMessage_Verify:
+		b.EncodeVarint(3<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Verify); err != nil {
+			return err
+		}
+	case *SieveMessage_PbftMessage:
+		b.EncodeVarint(4<<3 | proto.WireBytes)
+		b.EncodeRawBytes(x.PbftMessage)
+	case *SieveMessage_Complaint:
+		b.EncodeVarint(5<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Complaint); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		return fmt.Errorf("SieveMessage.Payload has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _SieveMessage_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*SieveMessage)
+	switch tag {
+	case 1: // payload.request
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Request)
+		err := b.DecodeMessage(msg)
+		m.Payload = &SieveMessage_Request{msg}
+		return true, err
+	case 2: // payload.execute
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Execute)
+		err := b.DecodeMessage(msg)
+		m.Payload = &SieveMessage_Execute{msg}
+		return true, err
+	case 3: // payload.verify
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Verify)
+		err := b.DecodeMessage(msg)
+		m.Payload = &SieveMessage_Verify{msg}
+		return true, err
+	case 4: // payload.pbft_message
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeRawBytes(true)
+		m.Payload = &SieveMessage_PbftMessage{x}
+		return true, err
+	case 5: // payload.complaint
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Request)
+		err := b.DecodeMessage(msg)
+		m.Payload = &SieveMessage_Complaint{msg}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+type Execute struct {
+	View        uint64     `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	BlockNumber uint64     `protobuf:"varint,2,opt,name=block_number" json:"block_number,omitempty"`
+	Requests    []*Request `protobuf:"bytes,3,rep,name=requests" json:"requests,omitempty"`
+	ReplicaId   uint64     `protobuf:"varint,4,opt,name=replica_id" json:"replica_id,omitempty"`
+}
+
+func (m *Execute) Reset()         { *m = Execute{} }
+func (m *Execute) String() string { return proto.CompactTextString(m) }
+func (*Execute) ProtoMessage()    {}
+
+func (m *Execute) GetRequests() []*Request {
+	if m != nil {
+		return m.Requests
+	}
+	return nil
+}
+
+type Verify struct {
+	View          uint64 `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	BlockNumber   uint64 `protobuf:"varint,2,opt,name=block_number" json:"block_number,omitempty"`
+	RequestDigest string `protobuf:"bytes,3,opt,name=request_digest" json:"request_digest,omitempty"`
+	ResultDigest  []byte `protobuf:"bytes,4,opt,name=result_digest,proto3" json:"result_digest,omitempty"`
+	ReplicaId     uint64 `protobuf:"varint,5,opt,name=replica_id" json:"replica_id,omitempty"`
+	Signature     []byte `protobuf:"bytes,6,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *Verify) Reset()         { *m = Verify{} }
+func (m *Verify) String() string { return proto.CompactTextString(m) }
+func (*Verify) ProtoMessage()    {}
+
+type SievePbftMessage struct {
+	// Types that are valid to be assigned to Payload:
+	//	*SievePbftMessage_VerifySet
+	//	*SievePbftMessage_Flush
+	Payload isSievePbftMessage_Payload `protobuf_oneof:"payload"`
+}
+
+func (m *SievePbftMessage) Reset()         { *m = SievePbftMessage{} }
+func (m *SievePbftMessage) String() string { return proto.CompactTextString(m) }
+func (*SievePbftMessage) ProtoMessage()    {}
+
+type isSievePbftMessage_Payload interface {
+	isSievePbftMessage_Payload()
+}
+
+type SievePbftMessage_VerifySet struct {
+	VerifySet *VerifySet `protobuf:"bytes,1,opt,name=verify_set,oneof"`
+}
+type SievePbftMessage_Flush struct {
+	Flush *Flush `protobuf:"bytes,2,opt,name=flush,oneof"`
+}
+
+func (*SievePbftMessage_VerifySet) isSievePbftMessage_Payload() {}
+func (*SievePbftMessage_Flush) isSievePbftMessage_Payload()     {}
+
+func (m *SievePbftMessage) GetPayload() isSievePbftMessage_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (m *SievePbftMessage) GetVerifySet() *VerifySet {
+	if x, ok := m.GetPayload().(*SievePbftMessage_VerifySet); ok {
+		return x.VerifySet
+	}
+	return nil
+}
+
+func (m *SievePbftMessage) GetFlush() *Flush {
+	if x, ok := m.GetPayload().(*SievePbftMessage_Flush); ok {
+		return x.Flush
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*SievePbftMessage) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), []interface{}) {
+	return _SievePbftMessage_OneofMarshaler, _SievePbftMessage_OneofUnmarshaler, []interface{}{
+		(*SievePbftMessage_VerifySet)(nil),
+		(*SievePbftMessage_Flush)(nil),
+	}
+}
+
+func _SievePbftMessage_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*SievePbftMessage)
+	// payload
+	switch x := m.Payload.(type) {
+	case *SievePbftMessage_VerifySet:
+		b.EncodeVarint(1<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.VerifySet); err != nil {
+			return err
+		}
+	case *SievePbftMessage_Flush:
+		b.EncodeVarint(2<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Flush); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		return fmt.Errorf("SievePbftMessage.Payload has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _SievePbftMessage_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*SievePbftMessage)
+	switch tag {
+	case 1: // payload.verify_set
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(VerifySet)
+		err := b.DecodeMessage(msg)
+		m.Payload = &SievePbftMessage_VerifySet{msg}
+		return true, err
+	case 2: // payload.flush
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Flush)
+		err := b.DecodeMessage(msg)
+		m.Payload = &SievePbftMessage_Flush{msg}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+type VerifySet struct {
+	View          uint64    `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	BlockNumber   uint64    `protobuf:"varint,2,opt,name=block_number" json:"block_number,omitempty"`
+	RequestDigest string    `protobuf:"bytes,3,opt,name=request_digest" json:"request_digest,omitempty"`
+	Dset          []*Verify `protobuf:"bytes,4,rep,name=dset" json:"dset,omitempty"`
+	ReplicaId     uint64    `protobuf:"varint,5,opt,name=replica_id" json:"replica_id,omitempty"`
+	Signature     []byte    `protobuf:"bytes,6,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *VerifySet) Reset()         { *m = VerifySet{} }
+func (m *VerifySet) String() string { return proto.CompactTextString(m) }
+func (*VerifySet) ProtoMessage()    {}
+
+func (m *VerifySet) GetDset() []*Verify {
+	if m != nil {
+		return m.Dset
+	}
+	return nil
+}
+
+type Flush struct {
+	View      uint64 `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	ReplicaId uint64 `protobuf:"varint,2,opt,name=replica_id" json:"replica_id,omitempty"`
+	Signature []byte `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *Flush) Reset()         { *m = Flush{} }
+func (m *Flush) String() string { return proto.CompactTextString(m) }
+func (*Flush) ProtoMessage()    {}
+
+type Metadata struct {
+	SeqNo             uint64             `protobuf:"varint,1,opt,name=seqNo" json:"seqNo,omitempty"`
+	CommitCertificate *CommitCertificate `protobuf:"bytes,2,opt,name=commit_certificate" json:"commit_certificate,omitempty"`
+	Epoch             uint64             `protobuf:"varint,3,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *Metadata) Reset()         { *m = Metadata{} }
+func (m *Metadata) String() string { return proto.CompactTextString(m) }
+func (*Metadata) ProtoMessage()    {}
+
+func (m *Metadata) GetCommitCertificate() *CommitCertificate {
+	if m != nil {
+		return m.CommitCertificate
+	}
+	return nil
+}
+
+// carries the messages of a member of a hierarchical network, exactly one
+// of the fields is set, except for leader, which accompanies top
+type HierarchyMessage struct {
+	Group        []byte                `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Top          []byte                `protobuf:"bytes,2,opt,name=top,proto3" json:"top,omitempty"`
+	Endorsement  *HierarchyEndorsement `protobuf:"bytes,3,opt,name=endorsement" json:"endorsement,omitempty"`
+	Leader       *LeaderCertificate    `protobuf:"bytes,4,opt,name=leader" json:"leader,omitempty"`
+	StateRequest []byte                `protobuf:"bytes,5,opt,name=state_request,proto3" json:"state_request,omitempty"`
+	State        []byte                `protobuf:"bytes,6,opt,name=state,proto3" json:"state,omitempty"`
+}
+
+func (m *HierarchyMessage) Reset()         { *m = HierarchyMessage{} }
+func (m *HierarchyMessage) String() string { return proto.CompactTextString(m) }
+func (*HierarchyMessage) ProtoMessage()    {}
+
+func (m *HierarchyMessage) GetEndorsement() *HierarchyEndorsement {
+	if m != nil {
+		return m.Endorsement
+	}
+	return nil
+}
+
+func (m *HierarchyMessage) GetLeader() *LeaderCertificate {
+	if m != nil {
+		return m.Leader
+	}
+	return nil
+}
+
+// the signature of a member that its group ordered a payload, or of a group
+// leader that the top level ordered a group certificate
+type HierarchyEndorsement struct {
+	SequenceNumber uint64             `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Digest         string             `protobuf:"bytes,2,opt,name=digest" json:"digest,omitempty"`
+	ReplicaId      uint64             `protobuf:"varint,3,opt,name=replica_id" json:"replica_id,omitempty"`
+	Signature      []byte             `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	Top            bool               `protobuf:"varint,5,opt,name=top" json:"top,omitempty"`
+	Certificate    []byte             `protobuf:"bytes,6,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	Leadership     *LeaderCertificate `protobuf:"bytes,7,opt,name=leadership" json:"leadership,omitempty"`
+	Previous       uint64             `protobuf:"varint,8,opt,name=previous" json:"previous,omitempty"`
+}
+
+func (m *HierarchyEndorsement) Reset()         { *m = HierarchyEndorsement{} }
+func (m *HierarchyEndorsement) String() string { return proto.CompactTextString(m) }
+func (*HierarchyEndorsement) ProtoMessage()    {}
+
+func (m *HierarchyEndorsement) GetLeadership() *LeaderCertificate {
+	if m != nil {
+		return m.Leadership
+	}
+	return nil
+}
+
+// proves that the primary of a view of the instance of a group leads the
+// group, to the top level
+type LeaderCertificate struct {
+	Group        uint32                  `protobuf:"varint,1,opt,name=group" json:"group,omitempty"`
+	View         uint64                  `protobuf:"varint,2,opt,name=view" json:"view,omitempty"`
+	Leader       uint64                  `protobuf:"varint,3,opt,name=leader" json:"leader,omitempty"`
+	Endorsements []*HierarchyEndorsement `protobuf:"bytes,4,rep,name=endorsements" json:"endorsements,omitempty"`
+}
+
+func (m *LeaderCertificate) Reset()         { *m = LeaderCertificate{} }
+func (m *LeaderCertificate) String() string { return proto.CompactTextString(m) }
+func (*LeaderCertificate) ProtoMessage()    {}
+
+func (m *LeaderCertificate) GetEndorsements() []*HierarchyEndorsement {
+	if m != nil {
+		return m.Endorsements
+	}
+	return nil
+}
+
+// proves that a group ordered a payload, to the top level
+type GroupCertificate struct {
+	Group          uint32                  `protobuf:"varint,1,opt,name=group" json:"group,omitempty"`
+	SequenceNumber uint64                  `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Payload        []byte                  `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
+	Endorsements   []*HierarchyEndorsement `protobuf:"bytes,4,rep,name=endorsements" json:"endorsements,omitempty"`
+}
+
+func (m *GroupCertificate) Reset()         { *m = GroupCertificate{} }
+func (m *GroupCertificate) String() string { return proto.CompactTextString(m) }
+func (*GroupCertificate) ProtoMessage()    {}
+
+func (m *GroupCertificate) GetEndorsements() []*HierarchyEndorsement {
+	if m != nil {
+		return m.Endorsements
+	}
+	return nil
+}
+
+// what the instance of a group orders, exactly one of the fields is set
+type GroupPayload struct {
+	Request  []byte       `protobuf:"bytes,1,opt,name=request,proto3" json:"request,omitempty"`
+	Delivery *TopDelivery `protobuf:"bytes,2,opt,name=delivery" json:"delivery,omitempty"`
+}
+
+func (m *GroupPayload) Reset()         { *m = GroupPayload{} }
+func (m *GroupPayload) String() string { return proto.CompactTextString(m) }
+func (*GroupPayload) ProtoMessage()    {}
+
+func (m *GroupPayload) GetDelivery() *TopDelivery {
+	if m != nil {
+		return m.Delivery
+	}
+	return nil
+}
+
+// proves that the top level ordered a group certificate, to the groups
+type TopDelivery struct {
+	SequenceNumber uint64                  `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Certificate    []byte                  `protobuf:"bytes,2,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	Endorsements   []*HierarchyEndorsement `protobuf:"bytes,3,rep,name=endorsements" json:"endorsements,omitempty"`
+	Previous       uint64                  `protobuf:"varint,4,opt,name=previous" json:"previous,omitempty"`
+}
+
+func (m *TopDelivery) Reset()         { *m = TopDelivery{} }
+func (m *TopDelivery) String() string { return proto.CompactTextString(m) }
+func (*TopDelivery) ProtoMessage()    {}
+
+func (m *TopDelivery) GetEndorsements() []*HierarchyEndorsement {
+	if m != nil {
+		return m.Endorsements
+	}
+	return nil
+}
+
+// the state of a member which follows from the execution of the instance of
+// its group, the same on its members, its digest is checkpointed
+type HierarchyState struct {
+	Ordered    uint64               `protobuf:"varint,1,opt,name=ordered" json:"ordered,omitempty"`
+	LastTop    uint64               `protobuf:"varint,2,opt,name=last_top" json:"last_top,omitempty"`
+	Early      []*TopDelivery       `protobuf:"bytes,3,rep,name=early" json:"early,omitempty"`
+	Progress   []*HierarchyProgress `protobuf:"bytes,4,rep,name=progress" json:"progress,omitempty"`
+	Delivered  uint64               `protobuf:"varint,5,opt,name=delivered" json:"delivered,omitempty"`
+	Pending    []*GroupCertificate  `protobuf:"bytes,6,rep,name=pending" json:"pending,omitempty"`
+	StackState []byte               `protobuf:"bytes,7,opt,name=stack_state,proto3" json:"stack_state,omitempty"`
+}
+
+func (m *HierarchyState) Reset()         { *m = HierarchyState{} }
+func (m *HierarchyState) String() string { return proto.CompactTextString(m) }
+func (*HierarchyState) ProtoMessage()    {}
+
+func (m *HierarchyState) GetEarly() []*TopDelivery {
+	if m != nil {
+		return m.Early
+	}
+	return nil
+}
+
+func (m *HierarchyState) GetProgress() []*HierarchyProgress {
+	if m != nil {
+		return m.Progress
+	}
+	return nil
+}
+
+func (m *HierarchyState) GetPending() []*GroupCertificate {
+	if m != nil {
+		return m.Pending
+	}
+	return nil
+}
+
+// the payloads of a group delivered so far
+type HierarchyProgress struct {
+	Next  uint64   `protobuf:"varint,1,opt,name=next" json:"next,omitempty"`
+	Ahead []uint64 `protobuf:"varint,2,rep,packed,name=ahead" json:"ahead,omitempty"`
+}
+
+func (m *HierarchyProgress) Reset()         { *m = HierarchyProgress{} }
+func (m *HierarchyProgress) String() string { return proto.CompactTextString(m) }
+func (*HierarchyProgress) ProtoMessage()    {}
+
+// the execution of the instance of a level, as persisted by a member
+type HierarchyLevelState struct {
+	SequenceNumber uint64 `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	State          []byte `protobuf:"bytes,2,opt,name=state,proto3" json:"state,omitempty"`
+}
+
+func (m *HierarchyLevelState) Reset()         { *m = HierarchyLevelState{} }
+func (m *HierarchyLevelState) String() string { return proto.CompactTextString(m) }
+func (*HierarchyLevelState) ProtoMessage()    {}
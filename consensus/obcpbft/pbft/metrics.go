@@ -0,0 +1,189 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/metrics"
+)
+
+// pbftMetrics are the metrics a replica exposes through the metrics
+// registry of the peer, or the one set by WithMetrics, labelled with the
+// replica id. Gauges are only written by the event thread, after it has
+// processed an event
+type pbftMetrics struct {
+	view            *metrics.Gauge
+	epoch           *metrics.Gauge
+	seqNo           *metrics.Gauge
+	lastExec        *metrics.Gauge
+	lowWatermark    *metrics.Gauge
+	highWatermark   *metrics.Gauge
+	outstandingReqs *metrics.Gauge
+	activeView      *metrics.Gauge
+	eventQueueDepth *metrics.Gauge
+	chkptInterval   *metrics.Gauge
+	responsive      *metrics.Gauge
+
+	events          *metrics.Counter
+	viewChanges     *metrics.Counter
+	checkpoints     *metrics.Counter
+	stateTransfers  *metrics.Counter
+	eventsDropped   *metrics.Counter
+	eventsSpilled   *metrics.Counter
+	stuckEvents     *metrics.Counter
+	catchUps        *metrics.Counter
+	duplicateReqs   *metrics.Counter
+	recoveries      *metrics.Counter
+	evidence        *metrics.Counter
+	retransmissions *metrics.Counter
+	rateLimited     *metrics.Counter
+	keyRotations    *metrics.Counter
+	thresholdCerts  *metrics.Counter
+	commitCerts     *metrics.Counter
+	RejectedReqs    *metrics.Counter
+	ExpiredReqs     *metrics.Counter
+
+	Speculations         *metrics.Counter
+	speculationRollbacks *metrics.Counter
+
+	softState     map[string]*metrics.Gauge   // entries of each store of the soft state, by softStateStores
+	softStateShed map[string]*metrics.Counter // messages shed because their store was full, by store
+	phaseTimeouts map[string]*metrics.Counter // sequence numbers which exceeded the prepare or commit timeout, by phase
+
+	requestPhase *metrics.Histogram // arrival of a request -> its pre-prepare
+	preparePhase *metrics.Histogram // pre-prepare -> prepared
+	commitPhase  *metrics.Histogram // prepared -> committed
+	executePhase *metrics.Histogram // committed -> executed
+}
+
+func newPbftMetrics(r *metrics.Registry, id uint64, chainID string) *pbftMetrics {
+	labels := metrics.Labels{"replica": strconv.FormatUint(id, 10)}
+	if chainID != "" {
+		labels["chain"] = chainID
+	}
+	with := func(key, value string) metrics.Labels {
+		extended := metrics.Labels{key: value}
+		for k, v := range labels {
+			extended[k] = v
+		}
+		return extended
+	}
+	phase := func(name string) *metrics.Histogram {
+		return r.NewHistogram("pbft_phase_duration_seconds", "Time requests took through each phase of the normal case", with("phase", name), metrics.LatencyBuckets)
+	}
+	store := func(name string) metrics.Labels {
+		return with("store", name)
+	}
+	softState := make(map[string]*metrics.Gauge)
+	for _, name := range softStateStores {
+		softState[name] = r.NewGauge("pbft_soft_state_entries", "Entries of each store of the soft state of the replica", store(name))
+	}
+	softStateShed := make(map[string]*metrics.Counter)
+	for _, name := range []string{"certs", "requests", "viewchanges", "newviews"} {
+		softStateShed[name] = r.NewCounter("pbft_soft_state_shed_total", "Messages shed because the store of the soft state holding them was full", store(name))
+	}
+	phaseTimeouts := make(map[string]*metrics.Counter)
+	for _, name := range []string{"prepare", "commit"} {
+		phaseTimeouts[name] = r.NewCounter("pbft_phase_timeouts_total", "Sequence numbers which did not complete a phase of the normal case within its timeout", with("phase", name))
+	}
+	return &pbftMetrics{
+		view:            r.NewGauge("pbft_view", "Current view of the replica", labels),
+		epoch:           r.NewGauge("pbft_epoch", "Epoch the sequence numbers of the replica are assigned in", labels),
+		seqNo:           r.NewGauge("pbft_seqno", "Highest sequence number the replica has assigned or seen pre-prepared", labels),
+		lastExec:        r.NewGauge("pbft_last_executed_seqno", "Sequence number of the last executed request", labels),
+		lowWatermark:    r.NewGauge("pbft_low_watermark", "Low watermark of the message log", labels),
+		highWatermark:   r.NewGauge("pbft_high_watermark", "High watermark of the message log", labels),
+		outstandingReqs: r.NewGauge("pbft_outstanding_requests", "Requests received but not yet committed", labels),
+		activeView:      r.NewGauge("pbft_active_view", "1 if the replica is in an active view, 0 during a view change", labels),
+		eventQueueDepth: r.NewGauge("pbft_event_queue_depth", "Events waiting in the event manager queue", labels),
+		chkptInterval:   r.NewGauge("pbft_checkpoint_interval", "Sequence numbers between two checkpoints of the replica", labels),
+		responsive:      r.NewGauge("pbft_responsive_replicas", "Replicas, including this one, heard from within the heartbeat timeout", labels),
+
+		events:          r.NewCounter("pbft_events_total", "Events processed by the replica", labels),
+		viewChanges:     r.NewCounter("pbft_view_changes_total", "New views installed by the replica", labels),
+		checkpoints:     r.NewCounter("pbft_stable_checkpoints_total", "Checkpoints which became stable", labels),
+		stateTransfers:  r.NewCounter("pbft_state_transfers_total", "State transfers completed by the replica", labels),
+		catchUps:        r.NewCounter("pbft_catch_ups_total", "Catch ups through commit certificates started by the replica", labels),
+		eventsDropped:   r.NewCounter("pbft_event_queue_dropped_total", "Client requests dropped because the event queue was full", labels),
+		eventsSpilled:   r.NewCounter("pbft_event_queue_spilled_total", "Client requests spilled to disk because the event queue was full", labels),
+		stuckEvents:     r.NewCounter("pbft_stuck_events_total", "Events the event thread processed for longer than the stuck event timeout", labels),
+		duplicateReqs:   r.NewCounter("pbft_duplicate_requests_total", "Requests dropped because they were already executed", labels),
+		recoveries:      r.NewCounter("pbft_proactive_recoveries_total", "Proactive recoveries performed by the replica", labels),
+		evidence:        r.NewCounter("pbft_byzantine_evidence_total", "Provable faults of other replicas the replica recorded evidence for", labels),
+		retransmissions: r.NewCounter("pbft_retransmissions_total", "Critical messages sent again because their receiver did not acknowledge them", labels),
+		rateLimited:     r.NewCounter("pbft_rate_limited_total", "Messages of other replicas dropped because they exceeded their rate limit", labels),
+		keyRotations:    r.NewCounter("pbft_session_key_rotations_total", "Session keys rotated by the replica", labels),
+		thresholdCerts:  r.NewCounter("pbft_checkpoint_certificates_total", "Threshold certificates the replica combined from checkpoint shares", labels),
+		commitCerts:     r.NewCounter("pbft_commit_certificates_total", "Commit certificates the replica combined from the shares in commits", labels),
+		RejectedReqs:    r.NewCounter("pbft_admission_rejected_total", "Client requests rejected by the admission policy or quota", labels),
+		ExpiredReqs:     r.NewCounter("pbft_expired_requests_total", "Client requests dropped because their expiry passed before they were ordered", labels),
+
+		Speculations:         r.NewCounter("pbft_speculative_executions_total", "Requests executed ahead of their commit certificate", labels),
+		speculationRollbacks: r.NewCounter("pbft_speculative_rollbacks_total", "Speculative executions discarded because another request committed at their sequence number", labels),
+
+		softState:     softState,
+		softStateShed: softStateShed,
+		phaseTimeouts: phaseTimeouts,
+
+		requestPhase: phase("request"),
+		preparePhase: phase("prepare"),
+		commitPhase:  phase("commit"),
+		executePhase: phase("execute"),
+	}
+}
+
+// update refreshes the gauges from the state of instance, it must only be
+// called from the event thread
+func (m *pbftMetrics) update(instance *PbftCore) {
+	m.view.Set(float64(instance.View))
+	m.epoch.Set(float64(instance.epoch))
+	m.seqNo.Set(float64(instance.seqNo))
+	m.lastExec.Set(float64(instance.LastExec))
+	m.lowWatermark.Set(float64(instance.h))
+	m.highWatermark.Set(float64(instance.h + instance.L))
+	m.outstandingReqs.Set(float64(len(instance.OutstandingReqs)))
+	if instance.ActiveView {
+		m.activeView.Set(1)
+	} else {
+		m.activeView.Set(0)
+	}
+	if qs, ok := instance.Manager.(queueStats); ok {
+		m.eventQueueDepth.Set(float64(qs.depth()))
+		m.eventsDropped.Add(qs.dropped() - m.eventsDropped.Value())
+		m.eventsSpilled.Add(qs.spilled() - m.eventsSpilled.Value())
+	} else {
+		m.eventQueueDepth.Set(float64(len(instance.Manager.Queue())))
+	}
+	m.chkptInterval.Set(float64(instance.tuner.period(instance.K)))
+	m.responsive.Set(float64(instance.responsiveReplicas()))
+	for name, size := range instance.softStateSizes() {
+		m.softState[name].Set(float64(size))
+	}
+	m.events.Inc()
+}
+
+// eventHooks counts the events which got stuck on the event thread, the
+// hooks run on the threads of the event manager
+func (m *pbftMetrics) eventHooks() *eventHooks {
+	return &eventHooks{
+		stuck: func(event interface{}, elapsed time.Duration) {
+			m.stuckEvents.Inc()
+		},
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/metrics"
+)
+
+func TestMetricsUpdate(t *testing.T) {
+	instance := newPbftCore(3, loadConfig(), &omniProto{})
+	defer instance.Close()
+
+	sendEvent(instance, WorkEvent(func() {
+		instance.View = 7
+		instance.h = 20
+		instance.OutstandingReqs["foo"] = &Request{}
+	}))
+
+	if v := instance.Metrics.view.Value(); v != 7 {
+		t.Errorf("Expected view gauge 7, got %v", v)
+	}
+	if v := instance.Metrics.highWatermark.Value(); v != float64(20+instance.L) {
+		t.Errorf("Expected high watermark gauge %d, got %v", 20+instance.L, v)
+	}
+	if v := instance.Metrics.outstandingReqs.Value(); v != 1 {
+		t.Errorf("Expected one outstanding request, got %v", v)
+	}
+
+	var buf bytes.Buffer
+	metrics.DefaultRegistry.WriteTo(&buf)
+	if !bytes.Contains(buf.Bytes(), []byte(`pbft_view{replica="3"} 7`)) {
+		t.Errorf("Expected the view of replica 3 to be exposed, got:\n%s", buf.String())
+	}
+}
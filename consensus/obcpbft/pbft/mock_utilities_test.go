@@ -0,0 +1,304 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	gp "google/protobuf"
+	"math/rand"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// loadConfig reads the configuration of the obcpbft plugin, which the
+// replicas of the tests are configured from
+func loadConfig() *viper.Viper {
+	config := viper.New()
+	config.SetConfigName("config")
+	config.AddConfigPath("../")
+	if err := config.ReadInConfig(); err != nil {
+		panic(fmt.Errorf("Error reading the obcpbft plugin config: %s", err))
+	}
+	return config
+}
+
+type noopSecurity struct{}
+
+func (ns *noopSecurity) Sign(msg []byte) ([]byte, error) {
+	return nil, nil
+}
+
+func (ns *noopSecurity) Verify(peerID *pb.PeerID, signature []byte, message []byte) error {
+	return nil
+}
+
+type mockPersist struct {
+	store map[string][]byte
+}
+
+func (p *mockPersist) initialize() {
+	if p.store == nil {
+		p.store = make(map[string][]byte)
+	}
+}
+
+func (p *mockPersist) ReadState(key string) ([]byte, error) {
+	p.initialize()
+	if val, ok := p.store[key]; ok {
+		return val, nil
+	}
+	return nil, fmt.Errorf("cannot find key %s", key)
+}
+
+func (p *mockPersist) ReadStateSet(prefix string) (map[string][]byte, error) {
+	if p.store == nil {
+		return nil, fmt.Errorf("no state yet")
+	}
+	ret := make(map[string][]byte)
+	for k, v := range p.store {
+		if len(k) >= len(prefix) && k[0:len(prefix)] == prefix {
+			ret[k] = v
+		}
+	}
+	return ret, nil
+}
+
+func (p *mockPersist) StoreState(key string, value []byte) error {
+	p.initialize()
+	p.store[key] = value
+	return nil
+}
+
+func (p *mockPersist) DelState(key string) {
+	p.initialize()
+	delete(p.store, key)
+}
+
+// Create a message of type `Message_CHAIN_TRANSACTION`
+func createOcMsgWithChainTx(iter int64) (msg *pb.Message) {
+	txTime := &gp.Timestamp{Seconds: iter, Nanos: 0}
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY,
+		Timestamp: txTime,
+		Payload:   []byte(fmt.Sprint(iter)),
+	}
+	txPacked, _ := proto.Marshal(tx)
+	msg = &pb.Message{
+		Type:    pb.Message_CHAIN_TRANSACTION,
+		Payload: txPacked,
+	}
+	return
+}
+
+// Create a message of type `Message_CHAIN_TRANSACTION`
+func createPbftRequestWithChainTx(iter int64, replica uint64) (msg *Request) {
+	txTime := &gp.Timestamp{Seconds: iter, Nanos: 0}
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY,
+		Timestamp: txTime,
+		Payload:   []byte(fmt.Sprint(iter)),
+	}
+	txPacked, _ := proto.Marshal(tx)
+	return createPbftRequest(iter, txPacked, replica)
+}
+
+// Create a request with an arbitrary payload, which pbftCore orders without
+// interpreting it
+func createPbftRequest(iter int64, payload []byte, replica uint64) *Request {
+	return &Request{
+		Timestamp: &gp.Timestamp{Seconds: iter, Nanos: 0},
+		ReplicaId: replica,
+		Payload:   payload,
+	}
+}
+
+func generateBroadcaster(validatorCount int) (requestBroadcaster int) {
+	seed := rand.NewSource(time.Now().UnixNano())
+	rndm := rand.New(seed)
+	requestBroadcaster = rndm.Intn(validatorCount)
+	return
+}
+
+type omniProto struct {
+	// Persistor methods
+	ReadStateImpl    func(key string) ([]byte, error)
+	ReadStateSetImpl func(prefix string) (map[string][]byte, error)
+	StoreStateImpl   func(key string, value []byte) error
+	DelStateImpl     func(key string)
+
+	// Inner Stack methods
+	broadcastImpl       func(msgPayload []byte)
+	unicastImpl         func(msgPayload []byte, receiverID uint64) (err error)
+	executeImpl         func(seqNo uint64, txRaw []byte, done ExecCallback)
+	getStateImpl        func() []byte
+	skipToImpl          func(seqNo uint64, snapshotID []byte, peers []uint64)
+	validateImpl        func(txRaw []byte) error
+	viewChangeImpl      func(curView uint64)
+	signImpl            func(msg []byte) ([]byte, error)
+	verifyImpl          func(senderID uint64, signature []byte, message []byte) error
+	getLastSeqNoImpl    func() (uint64, error)
+	validateStateImpl   func()
+	invalidateStateImpl func()
+	queryImpl           func(txRaw []byte) ([]byte, error)
+	reportEvidenceImpl  func(ev *Evidence)
+	executedImpl        func(txRaw []byte, cert *ReplyCertificate)
+}
+
+func (op *omniProto) Broadcast(msgPayload []byte) {
+	if nil != op.broadcastImpl {
+		op.broadcastImpl(msgPayload)
+		return
+	}
+
+	panic("Unimplemented")
+}
+func (op *omniProto) Unicast(msgPayload []byte, receiverID uint64) (err error) {
+	if nil != op.unicastImpl {
+		return op.unicastImpl(msgPayload, receiverID)
+	}
+
+	panic("Unimplemented")
+}
+func (op *omniProto) Execute(seqNo uint64, txRaw []byte, done ExecCallback) {
+	if nil != op.executeImpl {
+		op.executeImpl(seqNo, txRaw, done)
+		return
+	}
+
+	panic("Unimplemented")
+}
+func (op *omniProto) SkipTo(seqNo uint64, snapshotID []byte, peers []uint64) {
+	if nil != op.skipToImpl {
+		op.skipToImpl(seqNo, snapshotID, peers)
+		return
+	}
+
+	panic("Unimplemented")
+}
+func (op *omniProto) ValidateRequest(txRaw []byte) error {
+	if nil != op.validateImpl {
+		return op.validateImpl(txRaw)
+	}
+
+	panic("Unimplemented")
+}
+func (op *omniProto) ViewChange(curView uint64) {
+	if nil != op.viewChangeImpl {
+		op.viewChangeImpl(curView)
+		return
+	}
+
+	panic("Unimplemented")
+}
+func (op *omniProto) Sign(msg []byte) ([]byte, error) {
+	if nil != op.signImpl {
+		return op.signImpl(msg)
+	}
+
+	panic("Unimplemented")
+}
+func (op *omniProto) Verify(senderID uint64, signature []byte, message []byte) error {
+	if nil != op.verifyImpl {
+		return op.verifyImpl(senderID, signature, message)
+	}
+
+	panic("Unimplemented")
+}
+
+func (op *omniProto) GetLastSeqNo() (uint64, error) {
+	if op.getLastSeqNoImpl != nil {
+		return op.getLastSeqNoImpl()
+	}
+
+	return 0, fmt.Errorf("getLastSeqNo is not implemented")
+}
+
+func (op *omniProto) GetState() []byte {
+	if nil != op.getStateImpl {
+		return op.getStateImpl()
+	}
+
+	panic("Unimplemented")
+}
+
+func (op *omniProto) ReadState(key string) ([]byte, error) {
+	if nil != op.ReadStateImpl {
+		return op.ReadStateImpl(key)
+	}
+	return nil, fmt.Errorf("unimplemented")
+}
+
+func (op *omniProto) ReadStateSet(prefix string) (map[string][]byte, error) {
+	if nil != op.ReadStateImpl {
+		return op.ReadStateSetImpl(prefix)
+	}
+	return nil, fmt.Errorf("unimplemented")
+}
+
+func (op *omniProto) DelState(key string) {
+	if nil != op.DelStateImpl {
+		op.DelStateImpl(key)
+	}
+}
+
+func (op *omniProto) StoreState(key string, value []byte) error {
+	if nil != op.StoreStateImpl {
+		return op.StoreStateImpl(key, value)
+	}
+	return fmt.Errorf("unimplemented")
+}
+
+func (op *omniProto) ValidateState() {
+	if nil != op.validateStateImpl {
+		op.validateStateImpl()
+		return
+	}
+	panic("unimplemented")
+}
+
+func (op *omniProto) InvalidateState() {
+	if nil != op.invalidateStateImpl {
+		op.invalidateStateImpl()
+		return
+	}
+	panic("unimplemented")
+}
+
+func (op *omniProto) ExecuteQuery(txRaw []byte) ([]byte, error) {
+	if nil != op.queryImpl {
+		return op.queryImpl(txRaw)
+	}
+	panic("unimplemented")
+}
+
+func (op *omniProto) ReportEvidence(ev *Evidence) {
+	if nil != op.reportEvidenceImpl {
+		op.reportEvidenceImpl(ev)
+		return
+	}
+	panic("unimplemented")
+}
+
+func (op *omniProto) Executed(txRaw []byte, cert *ReplyCertificate) {
+	if nil != op.executedImpl {
+		op.executedImpl(txRaw, cert)
+		return
+	}
+	panic("unimplemented")
+}
@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/metrics"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Option overrides how NewPbftCore sets up a replica, in place of assigning
+// the fields of the replica once it is created
+type Option func(*coreOptions)
+
+// coreOptions collects the options of a replica, the settings override
+// the configuration before the replica reads it
+type coreOptions struct {
+	settings  map[string]interface{}
+	clock     Clock
+	persistor Persistor
+	registry  *metrics.Registry
+	receiver  EventReceiver
+}
+
+// WithN sets the number of replicas N, and the number of byzantine faults
+// f they tolerate
+func WithN(N, f int) Option {
+	return func(o *coreOptions) {
+		o.settings["general.N"] = N
+		o.settings["general.f"] = f
+	}
+}
+
+// WithTimeouts sets the request, view change and null request timeouts,
+// a timeout of 0 keeps the configured one
+func WithTimeouts(request, viewChange, nullRequest time.Duration) Option {
+	return func(o *coreOptions) {
+		for key, timeout := range map[string]time.Duration{
+			"general.timeout.request":     request,
+			"general.timeout.viewchange":  viewChange,
+			"general.timeout.nullrequest": nullRequest,
+		} {
+			if timeout != 0 {
+				o.settings[key] = timeout.String()
+			}
+		}
+	}
+}
+
+// WithPersistence stores the state of the replica in persistor, instead of
+// the Persistor of its stack
+func WithPersistence(persistor Persistor) Option {
+	return func(o *coreOptions) {
+		o.persistor = persistor
+	}
+}
+
+// WithClock counts the timers of the replica down on clk, instead of the
+// clock of the system
+func WithClock(clk Clock) Option {
+	return func(o *coreOptions) {
+		o.clock = clk
+	}
+}
+
+// WithMetrics registers the metrics of the replica with registry, instead
+// of the registry the peer serves its metrics from
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(o *coreOptions) {
+		o.registry = registry
+	}
+}
+
+// WithReceiver delivers the events of the replica to er, instead of the
+// replica itself, er passes the events it does not handle on to the replica
+func WithReceiver(er EventReceiver) Option {
+	return func(o *coreOptions) {
+		o.receiver = er
+	}
+}
+
+// NewPbftCore creates replica id, configured by config and the options,
+// which override the corresponding settings of config
+func NewPbftCore(id uint64, config *viper.Viper, consumer InnerStack, opts ...Option) *PbftCore {
+	o := &coreOptions{
+		settings:  make(map[string]interface{}),
+		clock:     wallClock{},
+		persistor: consumer,
+		registry:  metrics.DefaultRegistry,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	overrideConfig(config, "", o.settings)
+	return newPbftCoreWithOptions(id, config, consumer, o)
+}
+
+// overrideConfig sets the nested settings on config, below prefix
+func overrideConfig(config *viper.Viper, prefix string, settings map[string]interface{}) {
+	for key, value := range settings {
+		switch value.(type) {
+		case map[string]interface{}, map[interface{}]interface{}:
+			overrideConfig(config, prefix+key+".", cast.ToStringMap(value))
+		default:
+			config.Set(prefix+key, value)
+		}
+	}
+}
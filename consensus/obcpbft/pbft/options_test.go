@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/metrics"
+)
+
+type fixedClock struct {
+	t time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.t
+}
+
+func (c fixedClock) After(d time.Duration) <-chan time.Time {
+	return make(chan time.Time)
+}
+
+func TestNewPbftCoreOptions(t *testing.T) {
+	persist := &mockPersist{}
+	registry := metrics.NewRegistry()
+	start := time.Unix(42, 0)
+	instance := NewPbftCore(1, loadConfig(), &discardConsumer{&simpleConsumer{}},
+		WithN(7, 2),
+		WithTimeouts(3*time.Second, 5*time.Second, 0),
+		WithPersistence(persist),
+		WithClock(fixedClock{start}),
+		WithMetrics(registry),
+	)
+	defer instance.Close()
+
+	if instance.N != 7 || instance.F != 2 {
+		t.Errorf("Expected N = 7, f = 2, got N = %d, f = %d", instance.N, instance.F)
+	}
+	if instance.RequestTimeout != 3*time.Second || instance.NewViewTimeout != 5*time.Second {
+		t.Errorf("Expected the timeouts to be overridden, got %v, %v", instance.RequestTimeout, instance.NewViewTimeout)
+	}
+	if instance.nullRequestTimeout != 0 {
+		t.Errorf("Expected the configured null request timeout to be kept, got %v", instance.nullRequestTimeout)
+	}
+	if now := instance.Clock.Now(); !now.Equal(start) {
+		t.Errorf("Expected the replica to read the time from its clock, got %v", now)
+	}
+
+	req := createPbftRequestWithChainTx(1, 0)
+	digest := HashReq(instance.Digest, req)
+	sendEvent(instance, &PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: digest, Request: req, ReplicaId: 0, DigestAlgorithm: instance.Digest.name()})
+	if len(persist.store) == 0 {
+		t.Error("Expected the replica to persist its log through the persistor of the options")
+	}
+
+	instance.Metrics.update(instance)
+	var buf bytes.Buffer
+	registry.WriteTo(&buf)
+	if !strings.Contains(buf.String(), `pbft_view{replica="1"}`) {
+		t.Errorf("Expected the metrics to be registered with the registry of the options, got:\n%s", buf.String())
+	}
+}
+
+func TestNewPbftCoreInvalidOptions(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Expected options violating 3f+1 <= N to be rejected")
+		}
+	}()
+	NewPbftCore(0, loadConfig(), &discardConsumer{&simpleConsumer{}}, WithN(4, 2))
+}
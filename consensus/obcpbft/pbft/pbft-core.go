@@ -0,0 +1,2200 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+)
+
+// =============================================================================
+// init
+// =============================================================================
+
+var logger *logging.Logger // package-level logger
+
+func init() {
+	logger = logging.MustGetLogger("consensus/obcpbft")
+}
+
+const (
+	// UnreasonableTimeout is an ugly thing, we need to create timers, then stop them before they expire, so use a large timeout
+	UnreasonableTimeout = 100 * time.Hour
+)
+
+// =============================================================================
+// custom interfaces and structure definitions
+// =============================================================================
+
+// ExecCallback reports that an execution completed, with the state it
+// resulted in, or nil to have the state read through getState when it is
+// checkpointed. It may be called from any goroutine, and only once
+type ExecCallback func(state []byte)
+
+// Unless otherwise noted, all methods consume the PBFT thread, and should therefore
+// not rely on PBFT accomplishing any work while that thread is being held.
+// The payloads of requests are opaque to pbftCore, it orders them as they are
+// and leaves their validation and execution to the consumer
+type InnerStack interface {
+	Broadcast(msgPayload []byte)
+	Unicast(msgPayload []byte, receiverID uint64) (err error)
+	Execute(seqNo uint64, payload []byte, done ExecCallback) // This is invoked on the execution queue thread, it may return before the execution completes, which it reports through done
+	GetState() []byte
+	GetLastSeqNo() (uint64, error)
+	SkipTo(seqNo uint64, snapshotID []byte, peers []uint64)
+	ValidateRequest(payload []byte) error // rejects payloads which must not be ordered, before they consume ordering resources
+	ViewChange(curView uint64)
+
+	Sign(msg []byte) ([]byte, error)
+	Verify(senderID uint64, signature []byte, message []byte) error
+
+	InvalidateState()
+	ValidateState()
+
+	ExecuteQuery(payload []byte) ([]byte, error) // executes a read-only request against committed state
+
+	ReportEvidence(ev *Evidence) // notifies of a provable fault of another replica, the evidence was persisted already
+
+	Executed(payload []byte, cert *ReplyCertificate) // hands over the proof that a request we submitted was executed
+
+	Persistor
+}
+
+// This structure handles is used for incoming PBFT bound messages
+type pbftMessage struct {
+	sender uint64
+	msg    *Message
+}
+
+type CheckpointMessage struct {
+	SeqNo uint64
+	ID    []byte
+}
+
+type PbftCore struct {
+	// internal data
+	internalLock      sync.Mutex
+	executing         bool                    // signals that application is executing
+	Closed            chan struct{}           // informs the main thread to exit (never written to, only closed)
+	incomingChan      chan *pbftMessage       // informs the main thread of new messages
+	stateUpdatedChan  chan *CheckpointMessage // informs the main thread the state has updated (via state transfer)
+	stateUpdatingChan chan *CheckpointMessage // informs the main thread the state update has started (via state transfer)
+	execCompleteChan  chan struct{}           // informs the main thread an execution has finished
+
+	idleChan   chan struct{} // Used to detect idleness for testing
+	injectChan chan func()   // Used as a hack to inject work onto the PBFT thread, to be removed eventually
+
+	consumer  InnerStack
+	persistor Persistor // where the replica persists its state, the consumer unless set by WithPersistence
+
+	// PBFT data
+	ActiveView    bool              // view change happening
+	byzantine     bool              // whether this node is intentionally acting as Byzantine; useful for debugging on the testnet
+	F             int               // max. number of faults we can tolerate
+	N             int               // max.number of validators in the network
+	h             uint64            // low watermark
+	ID            uint64            // replica ID; PBFT `i`
+	K             uint64            // checkpoint period
+	logMultiplier uint64            // use this value to calculate log size : k*logMultiplier
+	L             uint64            // log size
+	window        *logWindow        // resizes the part of the log the primary assigns sequence numbers in, nil if fixed
+	tuner         *checkpointTuner  // adjusts the interval the replica checkpoints at, nil if fixed at K
+	LastExec      uint64            // last request we executed
+	replicaCount  int               // number of replicas; PBFT `|R|`
+	seqNo         uint64            // PBFT "n", strictly monotonic increasing sequence number
+	epoch         uint64            // epoch of the sequence numbers, written on the event thread only, read through currentEpoch elsewhere
+	epochEnd      uint64            // last sequence number of the epoch, a multiple of K
+	epochLength   uint64            // sequence numbers of an epoch, unless an operator ends it early
+	View          uint64            // current view
+	chkpts        map[uint64]string // state checkpoints; map lastExec to global hash
+	pset          map[uint64]*ViewChange_PQ
+	qset          map[qidx]*ViewChange_PQ
+
+	SkipInProgress bool                   // Set when we have detected a fall behind scenario until we pick a new starting point
+	hChkpts        map[uint64]uint64      // highest checkpoint sequence number observed for each replica
+	epochChkpts    map[uint64]*Checkpoint // latest checkpoint of a later epoch than ours observed for each replica
+	pendingEpoch   uint64                 // epoch of the checkpoint state transfer brings us to
+
+	CurrentExec        *uint64                  // currently executing request
+	currentExecID      msgID                    // view and sequence number of the currently executing request
+	execQueue          *execQueue               // hands committed requests to the consumer off the event thread
+	TimerActive        bool                     // is the timer running?
+	newViewTimer       EventTimer               // timeout triggering a view change
+	Manager            EventManager             // TODO, remove eventually, the event manager which sends events to pbft
+	Clock              Clock                    // source of time for the event timers
+	RequestTimeout     time.Duration            // progress timeout for requests
+	AdaptiveTimeout    *adaptiveRequestTimeout  // adapts the request timeout to observed latencies, nil if disabled
+	phaseTimeouts      *phaseTimeouts           // bound the prepare and commit phases, nil if disabled
+	NewViewTimeout     time.Duration            // progress timeout for new views
+	newViewTimerReason string                   // what triggered the timer
+	LastNewViewTimeout time.Duration            // last timeout we used during this view change
+	backoff            *viewChangeBackoff       // how lastNewViewTimeout grows and shrinks
+	OutstandingReqs    map[string]*Request      // track whether we are waiting for requests to execute
+	wal                *wal                     // write-ahead log persisting the message log
+	Metrics            *pbftMetrics             // metrics exposed to operators
+	Phases             *phaseLatency            // observes the time requests take through each phase
+	health             *replicaHealth           // reports whether consensus is stuck, nil if disabled
+	verified           map[Signable]struct{}    // messages whose signature was verified before they were delivered
+	auth               *macAuthenticator        // authenticates prepares and commits with session MACs, nil if disabled
+	executedReqs       *dedupCache              // digests of recently executed requests, nil if disabled
+	Digest             DigestProvider           // computes request digests
+	blacklist          *primaryBlacklist        // replicas skipped as primary after repeated failed views, nil if disabled
+	pendingQueries     map[string]*pendingQuery // read-only requests we submitted, waiting for matching replies
+	queryPool          *queryPool               // executes read-only requests off the event thread
+	runningQueries     int                      // read-only requests handed to the query pool, whose results did not arrive yet
+	Ingress            *ingressLimit            // signals backpressure once too many requests are outstanding, nil if disabled
+	softLimits         *softStateLimits         // bounds the stores of the soft state, nil if unbounded
+	Gossip             *requestGossip           // relays requests to random replicas, nil if requests are broadcast
+	relay              *voteRelay               // relays prepares and commits through aggregators, nil if they are broadcast
+	audit              *auditTrail              // signed records of the executed sequence numbers, nil if disabled
+	Scheduler          *requestScheduler        // orders requests waiting for a sequence number by priority, nil if unprioritized
+	replies            *ReplyCollector          // assembles the replies to the requests we submitted, nil if replies are disabled
+	awaitingReplies    map[string]*Request      // requests we submitted, by digest, whose execution certificate is not complete yet
+	speculation        *speculation             // the request executed ahead of its commit certificate, nil if disabled
+	reliable           *reliableSender          // retransmits critical messages until they are acknowledged, nil if disabled
+	limiter            *inboundLimiter          // drops messages of replicas exceeding their rate limit, nil if unlimited
+	recorder           *recorder                // records the messages of the other replicas for replay, nil if disabled
+	Tracing            *requestTracer           // records the spans of the phases of traced requests, nil if disabled
+	liveness           *livenessTable           // when we last heard from every replica, nil if heartbeats are disabled
+
+	log          *replicaLogger // logs the lines of the core with the context of the replica
+	vcLog        *replicaLogger // logs the lines of the view change subsystem
+	chkptLog     *replicaLogger // logs the lines of the checkpoint subsystem
+	stLog        *replicaLogger // logs the lines of the state transfer subsystem
+	currentEvent interface{}    // the event being processed, its sequence number is logged as context
+
+	nullRequestTimer   EventTimer    // timeout triggering a null request
+	nullRequestTimeout time.Duration // duration for this timeout
+
+	recoveryTimer    EventTimer    // timeout triggering a proactive recovery
+	recoveryPeriod   time.Duration // time between two proactive recoveries, 0 if disabled
+	viewChangePeriod uint64        // period between automatic view changes
+	viewChangeSeqNo  uint64        // next seqNo to perform view change
+
+	missingReqs    map[string]bool  // for all the assigned, non-checkpointed requests we might be missing during view-change
+	bigReqs        map[string]bool  // requests of digest-only pre-prepares we asked the primary for
+	lostReqs       map[string]msgID // requests of prepare certificates whose pre-prepare we missed, by digest
+	bigRequestSize int              // requests of at least this many bytes are pre-prepared by digest only, 0 if disabled
+
+	reportedFaults map[faultID]bool // faults of other replicas we recorded evidence for
+
+	CatchupGap uint64                  // most sequence numbers we catch up on through commit certificates, 0 if disabled
+	catchup    *catchUp                // the commit certificates we asked for, nil if we are not catching up
+	catchupLog map[uint64]*CatchUpCert // commit certificates retained below the low watermark, by sequence number
+
+	outageTimeout time.Duration          // silence after which we ask for checkpoint certificates, 0 if disabled
+	lastContact   time.Time              // when a message from another replica last arrived
+	chkptReplies  map[uint64]*Checkpoint // stable checkpoints reported in reply to our checkpoint request, nil if none is outstanding
+	rebuilding    bool                   // the persisted message log was corrupt, and is rebuilt from the stable checkpoint of the network
+	stableCert    []*Checkpoint          // the checkpoints which made our last checkpoint stable
+
+	Wire        *wireNegotiator       // wire format versions agreed with the other replicas
+	ed25519     *ed25519Keys          // Ed25519 keys of the replicas, nil if only the stack signs
+	threshold   *thresholdCheckpoints // threshold certificates of checkpoints, nil if disabled
+	commitCerts *commitCertificates   // threshold certificates of executed requests, nil if disabled
+
+	// implementation of PBFT `in`
+	reqStore        map[string]*Request   // track requests
+	certStore       map[msgID]*msgCert    // track quorum certificates for requests
+	checkpointStore map[Checkpoint]bool   // track checkpoints as set
+	viewChangeStore map[vcidx]*ViewChange // track view-change messages
+	newViewStore    map[uint64]*NewView   // track last new-view we received or sent
+}
+
+type qidx struct {
+	d string
+	n uint64
+}
+
+type msgID struct { // our index through certStore
+	v uint64
+	n uint64
+}
+
+type msgCert struct {
+	digest      string
+	prePrepare  *PrePrepare
+	sentPrepare bool
+	prepare     []*Prepare
+	sentCommit  bool
+	commit      []*Commit
+	phases      phaseTimes // when the certificate completed each phase
+}
+
+type vcidx struct {
+	v  uint64
+	id uint64
+}
+
+type stateTransferMetadata struct {
+	sequenceNumber uint64
+}
+
+type sortableUint64Slice []uint64
+
+func (a sortableUint64Slice) Len() int {
+	return len(a)
+}
+func (a sortableUint64Slice) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a sortableUint64Slice) Less(i, j int) bool {
+	return a[i] < a[j]
+}
+
+// replicasByHeight orders the responsive replicas before the unresponsive
+// ones, then replicas by the highest checkpoint they advertised, highest
+// first, then by id
+type replicasByHeight struct {
+	replicas     []uint64
+	height       map[uint64]uint64
+	unresponsive map[uint64]bool
+}
+
+func (a replicasByHeight) Len() int {
+	return len(a.replicas)
+}
+func (a replicasByHeight) Swap(i, j int) {
+	a.replicas[i], a.replicas[j] = a.replicas[j], a.replicas[i]
+}
+func (a replicasByHeight) Less(i, j int) bool {
+	ui, uj := a.unresponsive[a.replicas[i]], a.unresponsive[a.replicas[j]]
+	if ui != uj {
+		return uj
+	}
+	hi, hj := a.height[a.replicas[i]], a.height[a.replicas[j]]
+	if hi != hj {
+		return hi > hj
+	}
+	return a.replicas[i] < a.replicas[j]
+}
+
+// =============================================================================
+// constructors
+// =============================================================================
+
+func newPbftCore(id uint64, config *viper.Viper, consumer InnerStack) *PbftCore {
+	return NewPbftCore(id, config, consumer)
+}
+
+// newPbftCoreWithClock creates a pbftCore whose timers count down on clk,
+// so that the passage of time can be simulated
+func newPbftCoreWithClock(id uint64, config *viper.Viper, consumer InnerStack, clk Clock) *PbftCore {
+	return NewPbftCore(id, config, consumer, WithClock(clk))
+}
+
+// newPbftCoreWithOptions creates a pbftCore from config, once the options
+// of NewPbftCore are applied to it
+func newPbftCoreWithOptions(id uint64, config *viper.Viper, consumer InnerStack, o *coreOptions) *PbftCore {
+	var err error
+	instance := &PbftCore{}
+	instance.ID = id
+	instance.Clock = o.clock
+	instance.consumer = consumer
+	instance.persistor = o.persistor
+	instance.execQueue = newExecQueue(consumer)
+	instance.Closed = make(chan struct{})
+	instance.incomingChan = make(chan *pbftMessage)
+	instance.stateUpdatedChan = make(chan *CheckpointMessage)
+	instance.stateUpdatingChan = make(chan *CheckpointMessage)
+	instance.execCompleteChan = make(chan struct{})
+	instance.idleChan = make(chan struct{})
+	instance.injectChan = make(chan func())
+
+	var subsystems map[string]*replicaLogger
+	instance.log, subsystems, err = newReplicaLoggers(instance, config)
+	if err != nil {
+		panic(err)
+	}
+	instance.vcLog = subsystems["viewchange"]
+	instance.chkptLog = subsystems["checkpoint"]
+	instance.stLog = subsystems["statetransfer"]
+
+	// TODO Ultimately, the timer factory will be passed in, and the existence of the manager
+	// will be hidden from pbftCore, but in the interest of a small PR, leaving it here for now
+	receiver := o.receiver
+	if receiver == nil {
+		receiver = instance
+	}
+	manager, err := newConfiguredEventManager(receiver, config)
+	if err != nil {
+		panic(err)
+	}
+	instance.Manager = manager
+	etf := newClockedEventTimerFactory(instance.Manager, instance.Clock)
+	instance.newViewTimer = etf.createTimer()
+	instance.nullRequestTimer = etf.createTimer()
+	instance.recoveryTimer = etf.createTimer()
+
+	if err = validateConfig(id, config); err != nil {
+		panic(err)
+	}
+	instance.N = config.GetInt("general.N")
+	instance.F = config.GetInt("general.f")
+	instance.Wire = newWireNegotiator(id, instance.N)
+	instance.Wire.compress(config.GetStringSlice("general.compression.algorithms"), config.GetInt("general.compression.threshold"))
+
+	instance.K = uint64(config.GetInt("general.K"))
+
+	instance.logMultiplier = uint64(config.GetInt("general.logmultiplier"))
+	instance.L = instance.logMultiplier * instance.K // log size
+	instance.window, err = newLogWindow(config, instance.K, instance.logMultiplier)
+	if err != nil {
+		panic(err)
+	}
+	if instance.window != nil {
+		instance.L = instance.window.max
+	}
+	instance.tuner, err = newCheckpointTuner(config, instance.K, instance.logMultiplier*instance.K/2)
+	if err != nil {
+		panic(err)
+	}
+	instance.viewChangePeriod = uint64(config.GetInt("general.viewchangeperiod"))
+	instance.epochLength = epochLength(config.GetInt("general.epoch.length"), instance.K, instance.L)
+	instance.epochEnd = instance.epochLength
+
+	instance.byzantine = config.GetBool("general.byzantine")
+
+	instance.Digest, err = newDigestProvider(config.GetString("general.digest"))
+	if err != nil {
+		panic(err)
+	}
+
+	instance.RequestTimeout, err = time.ParseDuration(config.GetString("general.timeout.request"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse request timeout: %s", err))
+	}
+	instance.AdaptiveTimeout, err = newAdaptiveRequestTimeout(config, instance.Clock)
+	if err != nil {
+		panic(err)
+	}
+	instance.phaseTimeouts, err = newPhaseTimeouts(config)
+	if err != nil {
+		panic(err)
+	}
+	if pt := instance.phaseTimeouts; pt != nil {
+		pt.prepareTimer = etf.createTimer()
+		pt.commitTimer = etf.createTimer()
+	}
+	instance.ed25519, err = newEd25519Keys(id, config)
+	if err != nil {
+		panic(err)
+	}
+	instance.threshold, err = newThresholdCheckpoints(id, instance.N, instance.intersectionQuorum(), config)
+	if err != nil {
+		panic(err)
+	}
+	instance.commitCerts, err = newCommitCertificates(instance.threshold, config)
+	if err != nil {
+		panic(err)
+	}
+	instance.auth, err = newMACAuthenticator(id, config, instance.Clock)
+	if err != nil {
+		panic(err)
+	}
+	if ma := instance.auth; ma != nil && ma.period > 0 {
+		ma.timer = etf.createTimer()
+		ma.timer.Reset(ma.period, sessionKeyTimerEvent{})
+	}
+	instance.NewViewTimeout, err = time.ParseDuration(config.GetString("general.timeout.viewchange"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse new view timeout: %s", err))
+	}
+	instance.backoff, err = newViewChangeBackoff(config, instance.NewViewTimeout)
+	if err != nil {
+		panic(err)
+	}
+	instance.nullRequestTimeout, err = time.ParseDuration(config.GetString("general.timeout.nullrequest"))
+	if err != nil {
+		instance.nullRequestTimeout = 0
+	}
+	instance.recoveryPeriod, err = time.ParseDuration(config.GetString("general.recovery.period"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse recovery period: %s", err))
+	}
+	instance.outageTimeout, err = time.ParseDuration(config.GetString("general.timeout.outage"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse outage timeout: %s", err))
+	}
+	catchupGap := config.GetInt("general.catchupgap")
+	if catchupGap < 0 {
+		panic(fmt.Errorf("Catch up gap must not be negative, got %d", catchupGap))
+	}
+	instance.CatchupGap = uint64(catchupGap)
+	instance.bigRequestSize = config.GetInt("general.bigrequestsize")
+	if instance.bigRequestSize < 0 {
+		panic(fmt.Errorf("Big request size must not be negative, got %d", instance.bigRequestSize))
+	}
+
+	instance.ActiveView = true
+	instance.replicaCount = instance.N
+
+	instance.log.Info("PBFT type = %T", instance.consumer)
+	instance.log.Info("PBFT Max number of validating peers (N) = %v", instance.N)
+	instance.log.Info("PBFT Max number of failing peers (f) = %v", instance.F)
+	instance.log.Info("PBFT byzantine flag = %v", instance.byzantine)
+	instance.log.Info("PBFT digest algorithm = %v", instance.Digest.name())
+	instance.log.Info("PBFT request timeout = %v", instance.RequestTimeout)
+	if pt := instance.phaseTimeouts; pt != nil {
+		instance.log.Info("PBFT prepare phase timeout = %v, commit phase timeout = %v", pt.prepare, pt.commit)
+	}
+	if em := manager.(*eventManagerImpl); em.buffer != nil {
+		instance.log.Info("PBFT event queue capacity = %d, overflow policy = %s", em.buffer.config.capacity, config.GetString("general.eventqueue.overflow"))
+	} else {
+		instance.log.Info("PBFT events handed to the event thread directly")
+	}
+	if stuckAfter := manager.(*eventManagerImpl).instrumentation.stuckAfter; stuckAfter > 0 {
+		instance.log.Info("PBFT events processing for longer than %v are reported as stuck", stuckAfter)
+	}
+	if art := instance.AdaptiveTimeout; art != nil {
+		instance.log.Info("PBFT adaptive request timeout = %v percentile of last %d latencies times %v, between %v and %v",
+			art.percentile, len(art.latencies), art.multiplier, art.floor, art.ceiling)
+	}
+	if instance.auth != nil {
+		instance.log.Info("PBFT authentication mode = mac, session keys rotated every %d stable checkpoints and every %v, superseded keys accepted for %v",
+			instance.auth.rotation, instance.auth.period, instance.auth.overlap)
+	} else {
+		instance.log.Info("PBFT authentication mode = signature")
+	}
+	if instance.ed25519 != nil {
+		instance.log.Info("PBFT signature scheme = ed25519, once all replicas announced their key")
+	} else {
+		instance.log.Info("PBFT signature scheme = ecdsa")
+	}
+	if instance.threshold != nil {
+		instance.log.Info("PBFT checkpoint certificates = threshold signed by %d of %d replicas", instance.threshold.key.Threshold, instance.threshold.key.Replicas)
+	}
+	if instance.commitCerts != nil {
+		instance.log.Info("PBFT commit certificates = threshold signed, persisted with the blocks")
+	}
+	instance.log.Info("PBFT view change timeout = %v", instance.NewViewTimeout)
+	if instance.backoff.max != 0 {
+		instance.log.Info("PBFT view change timeout backoff = times %v up to %v, relaxed after %d commits",
+			instance.backoff.multiplier, instance.backoff.max, instance.backoff.stableCommits)
+	} else {
+		instance.log.Info("PBFT view change timeout backoff = times %v, relaxed after %d commits",
+			instance.backoff.multiplier, instance.backoff.stableCommits)
+	}
+	instance.log.Info("PBFT Checkpoint period (K) = %v", instance.K)
+	instance.log.Info("PBFT Log multiplier = %v", instance.logMultiplier)
+	instance.log.Info("PBFT log size (L) = %v", instance.L)
+	instance.log.Info("PBFT epoch length = %v", instance.epochLength)
+	if instance.window != nil {
+		instance.log.Info("PBFT log window resized between %v and %v", instance.window.min, instance.window.max)
+	}
+	if instance.tuner != nil {
+		instance.log.Info("PBFT checkpoint interval tuned between %v and %v, targeting %v%% overhead", instance.tuner.min, instance.tuner.max, instance.tuner.overhead*100)
+	}
+	instance.log.Info("PBFT WAL segment size = %v", config.GetInt("general.wal.segmentsize"))
+	instance.log.Info("PBFT executed request cache size = %v", config.GetInt("general.dedupcachesize"))
+	instance.log.Info("PBFT maximum outstanding requests = %v", config.GetInt("general.maxoutstanding"))
+	instance.log.Info("PBFT query workers = %v", config.GetInt("general.queryworkers"))
+	if instance.bigRequestSize > 0 {
+		instance.log.Info("PBFT requests of at least %d bytes are pre-prepared by digest only", instance.bigRequestSize)
+	} else {
+		instance.log.Info("PBFT requests are always pre-prepared in full")
+	}
+	if instance.nullRequestTimeout > 0 {
+		instance.log.Info("PBFT null requests timeout = %v", instance.nullRequestTimeout)
+	} else {
+		instance.log.Info("PBFT null requests disabled")
+	}
+	if instance.viewChangePeriod > 0 {
+		instance.log.Info("PBFT view change period = %v", instance.viewChangePeriod)
+	} else {
+		instance.log.Info("PBFT automatic view change disabled")
+	}
+	if instance.CatchupGap > 0 {
+		instance.log.Info("PBFT replicas at most %d sequence numbers behind catch up through commit certificates", instance.CatchupGap)
+	} else {
+		instance.log.Info("PBFT catch up through commit certificates disabled")
+	}
+	if instance.outageTimeout > 0 {
+		instance.log.Info("PBFT outage timeout = %v", instance.outageTimeout)
+	} else {
+		instance.log.Info("PBFT checkpoint certificate exchange on reconnect disabled")
+	}
+	if instance.recoveryPeriod > 0 {
+		instance.log.Info("PBFT proactive recovery period = %v", instance.recoveryPeriod)
+	} else {
+		instance.log.Info("PBFT proactive recovery disabled")
+	}
+
+	// init the logs
+	instance.certStore = make(map[msgID]*msgCert)
+	instance.reqStore = make(map[string]*Request)
+	instance.checkpointStore = make(map[Checkpoint]bool)
+	instance.chkpts = make(map[uint64]string)
+	instance.viewChangeStore = make(map[vcidx]*ViewChange)
+	instance.pset = make(map[uint64]*ViewChange_PQ)
+	instance.qset = make(map[qidx]*ViewChange_PQ)
+	instance.newViewStore = make(map[uint64]*NewView)
+
+	// initialize state transfer
+	instance.hChkpts = make(map[uint64]uint64)
+	instance.epochChkpts = make(map[uint64]*Checkpoint)
+
+	instance.chkpts[0] = "XXX GENESIS"
+
+	instance.LastNewViewTimeout = instance.NewViewTimeout
+	instance.lastContact = instance.Clock.Now()
+	instance.OutstandingReqs = make(map[string]*Request)
+	instance.missingReqs = make(map[string]bool)
+	instance.bigReqs = make(map[string]bool)
+	instance.lostReqs = make(map[string]msgID)
+	instance.catchupLog = make(map[uint64]*CatchUpCert)
+	instance.reportedFaults = make(map[faultID]bool)
+	instance.verified = make(map[Signable]struct{})
+	instance.executedReqs = newDedupCache(config.GetInt("general.dedupcachesize"))
+	instance.pendingQueries = make(map[string]*pendingQuery)
+	instance.queryPool = newQueryPool(config.GetInt("general.queryworkers"), consumer, instance.Manager)
+	instance.Ingress, err = newIngressLimit(config)
+	if err != nil {
+		panic(err)
+	}
+	instance.softLimits, err = newSoftStateLimits(config, instance.replicaCount)
+	if err != nil {
+		panic(err)
+	}
+	if instance.softLimits != nil {
+		instance.log.Info("PBFT soft state bounded to %d certificates, %d requests and %d view-changes", instance.softLimits.certs, instance.softLimits.requests, instance.softLimits.viewChanges)
+	}
+	instance.Scheduler, err = newRequestScheduler(config, consumer)
+	if err != nil {
+		panic(err)
+	}
+	if instance.Scheduler != nil {
+		instance.log.Info("PBFT request priorities enabled, at most %d requests overtake an older one in a row", instance.Scheduler.maxBypass)
+	}
+
+	instance.wal = newWAL(instance.persistor, uint64(config.GetInt("general.wal.segmentsize")))
+	instance.restoreState()
+	instance.audit = newAuditTrail(config, instance.persistor)
+	if config.GetBool("general.replies") {
+		instance.log.Info("PBFT replies to executed requests enabled")
+		instance.replies = NewReplyCollector(instance.F, consumer.Verify)
+		instance.awaitingReplies = make(map[string]*Request)
+	}
+	if instance.audit != nil {
+		instance.log.Info("PBFT audit trail enabled")
+	}
+	instance.speculation = newSpeculation(config, consumer)
+	if instance.speculation != nil {
+		instance.log.Info("PBFT speculative execution of prepared requests enabled")
+	}
+
+	instance.Metrics = newPbftMetrics(o.registry, id, config.GetString("general.chain"))
+	instance.Phases = newPhaseLatency(instance.Clock, instance.Metrics)
+	manager.(instrumentedManager).instrument(instance.Metrics.eventHooks())
+
+	instance.blacklist, err = newPrimaryBlacklist(config, instance.View)
+	if err != nil {
+		panic(err)
+	}
+	if instance.blacklist != nil {
+		instance.restoreBlacklist()
+		instance.log.Info("PBFT primaries are blacklisted for %d views after %d failed views", instance.blacklist.cooldown, instance.blacklist.threshold)
+	} else {
+		instance.log.Info("PBFT primary blacklisting disabled")
+	}
+
+	instance.relay, err = newVoteRelay(config)
+	if err != nil {
+		panic(err)
+	}
+	if instance.relay != nil {
+		instance.log.Info("PBFT prepares and commits relayed through %d aggregators", instance.relay.aggregators)
+	}
+
+	if err := instance.enableReliableSend(config); err != nil {
+		panic(err)
+	}
+
+	if err := instance.enableHeartbeats(config); err != nil {
+		panic(err)
+	}
+
+	instance.limiter, err = newInboundLimiter(config, instance.Clock)
+	if err != nil {
+		panic(err)
+	}
+	if instance.limiter != nil {
+		instance.log.Info("PBFT inbound messages limited to %v per second and replica, bursts of %v", instance.limiter.rate, instance.limiter.burst)
+	}
+
+	instance.recorder, err = newRecorder(config, instance.Clock)
+	if err != nil {
+		panic(err)
+	}
+	if instance.recorder != nil {
+		instance.log.Info("PBFT messages of the other replicas recorded to %s", instance.recorder.path)
+	}
+
+	instance.Tracing, err = newRequestTracer(id, config, instance.Clock)
+	if err != nil {
+		panic(err)
+	}
+	if instance.Tracing != nil {
+		instance.log.Info("PBFT tracing of requests enabled, %v of the submitted requests traced", instance.Tracing.sampleRate)
+	}
+
+	instance.health, err = newReplicaHealth(id, config, instance.Clock)
+	if err != nil {
+		panic(err)
+	}
+	if instance.health != nil {
+		instance.log.Info("PBFT health degraded after %v times the request or view change timeout, or a state transfer stalled for %v", instance.health.multiplier, instance.health.stateTransferTimeout)
+	}
+
+	instance.viewChangeSeqNo = ^uint64(0) // infinity
+	instance.updateViewChangeSeqNo()
+
+	instance.scheduleRecovery(true)
+
+	instance.health.update(instance)
+	instance.health.register()
+
+	return instance
+}
+
+// CreateTimer creates a stopped timer which delivers its event to the
+// event thread of the replica
+func (instance *PbftCore) CreateTimer() EventTimer {
+	return newClockedEventTimerFactory(instance.Manager, instance.Clock).createTimer()
+}
+
+// Close tears down resources opened by newPbftCore
+func (instance *PbftCore) Close() {
+	instance.Manager.halt()
+	instance.execQueue.halt()
+	instance.queryPool.halt()
+	instance.newViewTimer.halt()
+	instance.nullRequestTimer.halt()
+	instance.recoveryTimer.halt()
+	instance.recorder.close()
+	instance.health.unregister()
+	if instance.reliable != nil {
+		instance.reliable.timer.halt()
+	}
+	if instance.liveness != nil {
+		instance.liveness.timer.halt()
+	}
+	if instance.Gossip != nil {
+		instance.Gossip.timer.halt()
+	}
+	if instance.auth != nil && instance.auth.timer != nil {
+		instance.auth.timer.halt()
+	}
+	if instance.phaseTimeouts != nil {
+		instance.phaseTimeouts.prepareTimer.halt()
+		instance.phaseTimeouts.commitTimer.halt()
+	}
+}
+
+// allow the view-change protocol to kick-off when the timer expires
+func (instance *PbftCore) ProcessEvent(e interface{}) interface{} {
+	var err error
+
+	instance.currentEvent = e
+	defer func() { instance.currentEvent = nil }()
+	instance.log.Debug("Processing event")
+	defer instance.Metrics.update(instance)
+	defer instance.health.update(instance)
+	defer instance.updateIngress()
+	defer instance.updatePhaseTimers()
+
+	switch et := e.(type) {
+	case viewChangeTimerEvent:
+		instance.TimerActive = false
+		if instance.ActiveView && instance.purgeExpiredRequests() > 0 && len(instance.OutstandingReqs) == 0 {
+			instance.vcLog.Info("View change timer expired, but the outstanding requests expired as well: %s", instance.newViewTimerReason)
+			instance.startTimerIfOutstandingRequests()
+			break
+		}
+		instance.vcLog.Info("View change timer expired, sending view change: %s", instance.newViewTimerReason)
+		instance.SendViewChange()
+	case prepareTimerEvent:
+		instance.phaseTimerExpired("prepare")
+	case commitTimerEvent:
+		instance.phaseTimerExpired("commit")
+	case *pbftMessage:
+		return pbftMessageEvent(*et)
+	case pbftMessageEvent:
+		msg := et
+		instance.log.Debug("Received incoming message from %v", msg.sender)
+		if msg.sender != instance.ID {
+			instance.recorder.record(msg.sender, msg.msg)
+		}
+		instance.noteContact(msg.sender)
+		instance.noteAlive(msg.sender)
+		next, err := instance.recvMsg(msg.msg, msg.sender)
+		if err != nil {
+			break
+		}
+		instance.acknowledge(msg.msg, msg.sender)
+		return instance.filterEpoch(next, msg.sender)
+	case *Request:
+		err = instance.RecvRequest(et)
+	case *PrePrepare:
+		err = instance.recvPrePrepare(et)
+	case *Prepare:
+		err = instance.recvPrepare(et)
+	case *Commit:
+		err = instance.recvCommit(et)
+	case *Checkpoint:
+		err = instance.recvCheckpoint(et)
+	case *ViewChange:
+		err = instance.recvViewChange(et)
+	case *NewView:
+		err = instance.recvNewView(et)
+	case *FetchRequest:
+		err = instance.recvFetchRequest(et)
+	case returnRequestEvent:
+		err = instance.recvReturnRequest(et)
+	case *SessionKey:
+		err = instance.recvSessionKey(et)
+	case *QueryReply:
+		err = instance.recvQueryReply(et)
+	case queryExecutedEvent:
+		err = instance.replyQuery(et)
+	case StateUpdatingEvent:
+		update := et
+		instance.adoptPendingEpoch()
+		instance.SkipInProgress = true
+		instance.catchup = nil
+		instance.LastExec = update.SeqNo
+		instance.moveWatermarks(instance.LastExec) // The watermark movement handles moving this to a checkpoint boundary
+	case StateUpdatedEvent:
+		update := et
+		seqNo := update.SeqNo
+		instance.stLog.Info("Application caught up via state transfer, lastExec now %d", seqNo)
+		instance.adoptPendingEpoch()
+		// XXX create checkpoint
+		instance.LastExec = seqNo
+		instance.moveWatermarks(instance.LastExec) // The watermark movement handles moving this to a checkpoint boundary
+		instance.SkipInProgress = false
+		instance.Metrics.stateTransfers.Inc()
+		instance.consumer.ValidateState()
+		instance.executeOutstanding()
+	case execDoneEvent:
+		if instance.CurrentExec == nil || *instance.CurrentExec != et.seqNo {
+			instance.log.Warning("Ignoring completion of execution %d, it is not outstanding", et.seqNo)
+			return nil
+		}
+		instance.ExecDoneSync(et.state)
+	case nullRequestEvent:
+		instance.nullRequestHandler()
+	case RestoredEvent:
+		instance.sendWireHello()
+		instance.resumeOutstandingReqs()
+	case recoveryEvent:
+		instance.recover()
+	case *RecoveryRequest:
+		err = instance.recvRecoveryRequest(et)
+	case *CheckpointRequest:
+		err = instance.recvCheckpointRequest(et)
+	case *CheckpointReply:
+		err = instance.recvCheckpointReply(et)
+	case *CatchUpRequest:
+		err = instance.recvCatchUpRequest(et)
+	case *CatchUpCert:
+		err = instance.recvCatchUpCert(et)
+	case *GossipRequest:
+		err = instance.recvGossipRequest(et)
+	case *RelayBundle:
+		err = instance.recvRelayBundle(et)
+	case *RequestDigests:
+		err = instance.recvRequestDigests(et)
+	case *Reply:
+		err = instance.recvReply(et)
+	case *WireHello:
+		err = instance.recvWireHello(et)
+	case *Ack:
+		err = instance.recvAck(et)
+	case ackTimerEvent:
+		instance.retransmit()
+	case *Heartbeat:
+		err = instance.recvHeartbeat(et)
+	case heartbeatTimerEvent:
+		instance.sendHeartbeat()
+	case *CheckpointShare:
+		err = instance.recvCheckpointShare(et)
+	case sessionKeyTimerEvent:
+		instance.rotateSessionKey(false)
+	case gossipTimerEvent:
+		instance.sendRequestDigests()
+	case WorkEvent:
+		et() // Used to allow the caller to steal use of the main thread, to be removed
+	case ViewChangedEvent:
+		instance.consumer.ViewChange(instance.View)
+	default:
+		instance.log.Warning("Received an unknown message type %T", et)
+	}
+
+	if err != nil {
+		instance.log.Warning("%s", err)
+	}
+	return nil
+}
+
+// Allows the caller to inject work onto the main thread
+// This is useful when the caller wants to safely manipulate PBFT state
+func (instance *PbftCore) Inject(work func()) {
+	instance.Manager.Queue() <- WorkEvent(work)
+}
+
+// =============================================================================
+// helper functions for PBFT
+// =============================================================================
+
+// Given a certain view n, what is the expected primary?
+func (instance *PbftCore) Primary(n uint64) uint64 {
+	if instance.blacklist != nil {
+		return instance.blacklist.primary(n)
+	}
+	return n % uint64(instance.replicaCount)
+}
+
+// Is the sequence number between watermarks, and within the epoch?
+func (instance *PbftCore) inW(n uint64) bool {
+	return n-instance.h > 0 && n-instance.h <= instance.L && n <= instance.epochEnd
+}
+
+// Is the view right? And is the sequence number between watermarks?
+func (instance *PbftCore) inWV(v uint64, n uint64) bool {
+	return instance.View == v && instance.inW(n)
+}
+
+// Given a digest/view/seq, is there an entry in the certLog?
+// If so, return it. If not, create it.
+func (instance *PbftCore) getCert(v uint64, n uint64) (cert *msgCert) {
+	idx := msgID{v, n}
+	cert, ok := instance.certStore[idx]
+	if ok {
+		return
+	}
+
+	cert = &msgCert{}
+	instance.certStore[idx] = cert
+	return
+}
+
+// =============================================================================
+// preprepare/prepare/commit quorum checks
+// =============================================================================
+
+// intersectionQuorum returns the number of replicas that have to
+// agree to guarantee that at least one correct replica is shared by
+// two intersection quora
+func (instance *PbftCore) intersectionQuorum() int {
+	return (instance.N + instance.F + 2) / 2
+}
+
+// allCorrectReplicasQuorum returns the number of correct replicas (N-f)
+func (instance *PbftCore) allCorrectReplicasQuorum() int {
+	return (instance.N - instance.F)
+}
+
+func (instance *PbftCore) prePrepared(digest string, v uint64, n uint64) bool {
+	_, mInLog := instance.reqStore[digest]
+
+	if digest != "" && !mInLog {
+		return false
+	}
+
+	if q, ok := instance.qset[qidx{digest, n}]; ok && q.View == v {
+		return true
+	}
+
+	cert := instance.certStore[msgID{v, n}]
+	if cert != nil {
+		p := cert.prePrepare
+		if p != nil && p.View == v && p.SequenceNumber == n && p.RequestDigest == digest {
+			return true
+		}
+	}
+	instance.log.Debug("Do not have view=%d/seqNo=%d pre-prepared",
+		v, n)
+	return false
+}
+
+func (instance *PbftCore) prepared(digest string, v uint64, n uint64) bool {
+	if !instance.prePrepared(digest, v, n) {
+		return false
+	}
+
+	if p, ok := instance.pset[n]; ok && p.View == v && p.Digest == digest {
+		return true
+	}
+
+	quorum := 0
+	cert := instance.certStore[msgID{v, n}]
+	if cert == nil {
+		return false
+	}
+
+	for _, p := range cert.prepare {
+		if p.View == v && p.SequenceNumber == n && p.RequestDigest == digest {
+			quorum++
+		}
+	}
+
+	instance.log.Debug("Prepare count for view=%d/seqNo=%d: %d",
+		v, n, quorum)
+
+	return quorum >= instance.intersectionQuorum()-1
+}
+
+func (instance *PbftCore) committed(digest string, v uint64, n uint64) bool {
+	if !instance.prepared(digest, v, n) {
+		return false
+	}
+
+	quorum := 0
+	cert := instance.certStore[msgID{v, n}]
+	if cert == nil {
+		return false
+	}
+
+	for _, p := range cert.commit {
+		if p.View == v && p.SequenceNumber == n {
+			quorum++
+		}
+	}
+
+	instance.log.Debug("Commit count for view=%d/seqNo=%d: %d",
+		v, n, quorum)
+
+	return quorum >= instance.intersectionQuorum()
+}
+
+// =============================================================================
+// receive methods
+// =============================================================================
+
+// handle new consensus requests
+func (instance *PbftCore) RequestSync(msgPayload []byte, senderID uint64) error {
+	req := &Request{Payload: msgPayload, ReplicaId: senderID}
+	if senderID == instance.ID {
+		instance.Tracing.Submit(req)
+	}
+	msg := &Message{&Message_Request{req}}
+	instance.Manager.Inject(pbftMessageEvent{
+		sender: senderID,
+		msg:    msg,
+	})
+	return nil
+}
+
+func (instance *PbftCore) nullRequestHandler() {
+	if !instance.ActiveView {
+		return
+	}
+
+	if instance.Primary(instance.View) != instance.ID {
+		// backup expected a null request, but primary never sent one
+		instance.log.Info("Null request timer expired, sending view change")
+		instance.SendViewChange()
+	} else {
+		// time for the primary to send a null request
+		// pre-prepare with null digest
+		instance.log.Info("Primary null request timer expired, sending null request")
+		instance.sendPrePrepare(nil, "")
+	}
+}
+
+// handle internal consensus messages
+func (instance *PbftCore) ReceiveSync(msgPayload []byte, senderID uint64) error {
+	msg := &Message{}
+	err := proto.Unmarshal(msgPayload, msg)
+	if err != nil {
+		return fmt.Errorf("Error unpacking payload from message: %s", err)
+	}
+	if !instance.Admit(msg, senderID) {
+		return nil
+	}
+
+	instance.Manager.Inject(pbftMessageEvent{
+		msg:    msg,
+		sender: senderID,
+	})
+
+	return nil
+}
+
+// ReceiveVerifiedSync delivers a message whose signatures, or the
+// signatures of the messages it embeds, have already been verified
+func (instance *PbftCore) ReceiveVerifiedSync(msg *Message, senderID uint64, verified []Signable) {
+	for _, s := range verified {
+		instance.verified[s] = struct{}{}
+	}
+	instance.Manager.Inject(pbftMessageEvent{
+		msg:    msg,
+		sender: senderID,
+	})
+	for _, s := range verified {
+		delete(instance.verified, s)
+	}
+}
+
+// VerifyMessage verifies the signatures carried by msg, and returns the
+// signed messages it verified. It may be called from any goroutine
+func VerifyMessage(verify Verifier, msg *Message) ([]Signable, error) {
+	var signed []Signable
+	if vc := msg.GetViewChange(); vc != nil {
+		signed = append(signed, vc)
+	} else if sk := msg.GetSessionKey(); sk != nil {
+		signed = append(signed, sk)
+	} else if nv := msg.GetNewView(); nv != nil {
+		for _, vc := range nv.Vset {
+			signed = append(signed, vc)
+		}
+	}
+	for _, s := range signed {
+		if err := verifySignatureWith(verify, s); err != nil {
+			return nil, err
+		}
+	}
+	return signed, nil
+}
+
+func (instance *PbftCore) recvMsg(msg *Message, senderID uint64) (interface{}, error) {
+
+	if req := msg.GetRequest(); req != nil {
+		if senderID != req.ReplicaId {
+			return nil, instance.forgedSender(msg, "request", req.ReplicaId, senderID)
+		}
+		return req, nil
+	} else if preprep := msg.GetPrePrepare(); preprep != nil {
+		if senderID != preprep.ReplicaId {
+			return nil, instance.forgedSender(msg, "pre-prepare", preprep.ReplicaId, senderID)
+		}
+		return preprep, nil
+	} else if prep := msg.GetPrepare(); prep != nil {
+		if senderID != prep.ReplicaId {
+			return nil, instance.forgedSender(msg, "prepare", prep.ReplicaId, senderID)
+		}
+		if err := instance.checkAuthenticator(prep); err != nil {
+			return nil, fmt.Errorf("Prepare from replica %d failed authentication: %s", senderID, err)
+		}
+		return prep, nil
+	} else if commit := msg.GetCommit(); commit != nil {
+		if senderID != commit.ReplicaId {
+			return nil, instance.forgedSender(msg, "commit", commit.ReplicaId, senderID)
+		}
+		if err := instance.checkAuthenticator(commit); err != nil {
+			return nil, fmt.Errorf("Commit from replica %d failed authentication: %s", senderID, err)
+		}
+		return commit, nil
+	} else if chkpt := msg.GetCheckpoint(); chkpt != nil {
+		if senderID != chkpt.ReplicaId {
+			return nil, instance.forgedSender(msg, "checkpoint", chkpt.ReplicaId, senderID)
+		}
+		return chkpt, nil
+	} else if vc := msg.GetViewChange(); vc != nil {
+		if senderID != vc.ReplicaId {
+			return nil, instance.forgedSender(msg, "view-change", vc.ReplicaId, senderID)
+		}
+		return vc, nil
+	} else if nv := msg.GetNewView(); nv != nil {
+		if senderID != nv.ReplicaId {
+			return nil, instance.forgedSender(msg, "new-view", nv.ReplicaId, senderID)
+		}
+		return nv, nil
+	} else if fr := msg.GetFetchRequest(); fr != nil {
+		if senderID != fr.ReplicaId {
+			return nil, instance.forgedSender(msg, "fetch-request", fr.ReplicaId, senderID)
+		}
+		return fr, nil
+	} else if req := msg.GetReturnRequest(); req != nil {
+		// it's ok for sender ID and replica ID to differ; we're sending the original request message
+		return returnRequestEvent(req), nil
+	} else if sk := msg.GetSessionKey(); sk != nil {
+		if senderID != sk.ReplicaId {
+			return nil, instance.forgedSender(msg, "session-key", sk.ReplicaId, senderID)
+		}
+		if err := instance.Verify(sk); err != nil {
+			return nil, fmt.Errorf("Session key from replica %d failed verification: %s", senderID, err)
+		}
+		return sk, nil
+	} else if qr := msg.GetQueryReply(); qr != nil {
+		if senderID != qr.ReplicaId {
+			return nil, instance.forgedSender(msg, "query-reply", qr.ReplicaId, senderID)
+		}
+		return qr, nil
+	} else if rr := msg.GetRecoveryRequest(); rr != nil {
+		if senderID != rr.ReplicaId {
+			return nil, instance.forgedSender(msg, "recovery-request", rr.ReplicaId, senderID)
+		}
+		return rr, nil
+	} else if cr := msg.GetCheckpointRequest(); cr != nil {
+		if senderID != cr.ReplicaId {
+			return nil, instance.forgedSender(msg, "checkpoint-request", cr.ReplicaId, senderID)
+		}
+		return cr, nil
+	} else if cr := msg.GetCheckpointReply(); cr != nil {
+		if senderID != cr.ReplicaId {
+			return nil, instance.forgedSender(msg, "checkpoint-reply", cr.ReplicaId, senderID)
+		}
+		return cr, nil
+	} else if cr := msg.GetCatchUpRequest(); cr != nil {
+		if senderID != cr.ReplicaId {
+			return nil, instance.forgedSender(msg, "catch-up-request", cr.ReplicaId, senderID)
+		}
+		return cr, nil
+	} else if cc := msg.GetCatchUpCert(); cc != nil {
+		if senderID != cc.ReplicaId {
+			return nil, instance.forgedSender(msg, "catch-up-cert", cc.ReplicaId, senderID)
+		}
+		return cc, nil
+	} else if gr := msg.GetGossipRequest(); gr != nil {
+		// the relaying replica differs from the one which originated the request
+		if senderID != gr.ReplicaId {
+			return nil, instance.forgedSender(msg, "gossip-request", gr.ReplicaId, senderID)
+		}
+		return gr, nil
+	} else if rd := msg.GetRequestDigests(); rd != nil {
+		if senderID != rd.ReplicaId {
+			return nil, instance.forgedSender(msg, "request-digests", rd.ReplicaId, senderID)
+		}
+		return rd, nil
+	} else if reply := msg.GetReply(); reply != nil {
+		if senderID != reply.ReplicaId {
+			return nil, instance.forgedSender(msg, "reply", reply.ReplicaId, senderID)
+		}
+		return reply, nil
+	} else if wh := msg.GetWireHello(); wh != nil {
+		if senderID != wh.ReplicaId {
+			return nil, instance.forgedSender(msg, "wire-hello", wh.ReplicaId, senderID)
+		}
+		return wh, nil
+	} else if ack := msg.GetAck(); ack != nil {
+		if senderID != ack.ReplicaId {
+			return nil, instance.forgedSender(msg, "ack", ack.ReplicaId, senderID)
+		}
+		return ack, nil
+	} else if hb := msg.GetHeartbeat(); hb != nil {
+		if senderID != hb.ReplicaId {
+			return nil, instance.forgedSender(msg, "heartbeat", hb.ReplicaId, senderID)
+		}
+		return hb, nil
+	} else if cs := msg.GetCheckpointShare(); cs != nil {
+		if senderID != cs.ReplicaId {
+			return nil, instance.forgedSender(msg, "checkpoint-share", cs.ReplicaId, senderID)
+		}
+		return cs, nil
+	} else if rb := msg.GetRelayBundle(); rb != nil {
+		// the votes keep the authenticators of their senders, they are checked one by one
+		if senderID != rb.ReplicaId {
+			return nil, instance.forgedSender(msg, "relay-bundle", rb.ReplicaId, senderID)
+		}
+		return rb, nil
+	}
+
+	return nil, fmt.Errorf("Invalid message: %v", msg)
+}
+
+func (instance *PbftCore) RecvRequest(req *Request) error {
+	digest := HashReq(instance.Digest, req)
+	instance.log.Debug("Received request: %s", ReqLabel(digest, req))
+
+	if req.ReadOnly {
+		return instance.recvQuery(req, digest)
+	}
+
+	if instance.executedReqs.contains(digest) {
+		instance.log.Info("Acknowledging request %s, which was already executed", digest)
+		instance.Metrics.duplicateReqs.Inc()
+		return nil
+	}
+
+	if instance.Expired(req) {
+		if _, ok := instance.OutstandingReqs[digest]; !ok {
+			instance.DropExpired(req, digest)
+		}
+		return nil
+	}
+
+	if err := instance.validateRequest(req); err != nil {
+		instance.log.Warning("Request %s did not verify: %s", digest, err)
+		return err
+	}
+
+	if !instance.admitRequest(digest) {
+		return nil
+	}
+
+	if _, ok := instance.reqStore[digest]; !ok {
+		instance.gossipRequest(req, digest)
+	}
+	instance.reqStore[digest] = req
+	instance.OutstandingReqs[digest] = req
+	instance.traceEnter(digest, "preprepare", instance.View, 0)
+	instance.Phases.arrived(digest)
+	instance.Scheduler.arrive(digest)
+	if instance.replies != nil && req.ReplicaId == instance.ID {
+		instance.awaitingReplies[digest] = req
+	}
+	instance.AdaptiveTimeout.requestArrived(digest)
+	instance.persistRequest(digest)
+	if instance.ActiveView {
+		instance.softStartTimer(instance.GetRequestTimeout(), fmt.Sprintf("new request %s", digest))
+	}
+
+	if instance.Primary(instance.View) == instance.ID && instance.ActiveView { // if we're primary of current view
+		instance.nullRequestTimer.Stop()
+		instance.sendPrePrepare(req, digest)
+	} else {
+		instance.log.Debug("Not primary, not sending pre-prepare for request %s", digest)
+	}
+
+	return nil
+}
+
+func (instance *PbftCore) sendPrePrepare(req *Request, digest string) {
+	instance.log.Debug("Primary issuing pre-prepare for request %s", digest)
+	n := instance.seqNo + 1
+
+	for _, cert := range instance.certStore { // check for other PRE-PREPARE for same digest, but different seqNo
+		if p := cert.prePrepare; p != nil {
+			if p.View == instance.View && p.SequenceNumber != n && p.RequestDigest == digest && digest != "" {
+				instance.log.Info("Other pre-prepare found with same digest but different seqNo: %d instead of %d", p.SequenceNumber, n)
+				return
+			}
+		}
+	}
+
+	if !instance.seqNoAvailable(n) {
+		instance.log.Debug("Primary not sending pre-prepare for request %s because it is out of sequence numbers", digest)
+		instance.window.stall()
+		instance.tuner.stall()
+		return
+	}
+
+	if n > instance.viewChangeSeqNo {
+		instance.log.Info("Primary about to switch to next primary, not sending pre-prepare with seqno=%d", n)
+		return
+	}
+
+	instance.log.Debug("Primary broadcasting pre-prepare for view=%d/seqNo=%d and digest %s",
+		instance.View, n, ReqLabel(digest, req))
+	instance.seqNo = n
+	preprep := &PrePrepare{
+		View:            instance.View,
+		SequenceNumber:  n,
+		RequestDigest:   digest,
+		Request:         req,
+		ReplicaId:       instance.ID,
+		DigestAlgorithm: instance.Digest.name(),
+		Epoch:           instance.epoch,
+	}
+	cert := instance.getCert(instance.View, n)
+	cert.prePrepare = preprep
+	cert.digest = digest
+	instance.Phases.prePrepared(cert)
+	instance.persistPrePrepare(preprep)
+	instance.traceEnter(digest, "prepare", instance.View, n)
+
+	if instance.isBigRequest(req) {
+		instance.log.Debug("Primary pre-preparing big request %s by digest only", digest)
+		digestOnly := *preprep
+		digestOnly.Request = nil
+		instance.innerBroadcast(&Message{&Message_PrePrepare{&digestOnly}})
+	} else {
+		instance.innerBroadcast(&Message{&Message_PrePrepare{preprep}})
+	}
+	instance.maybeSendCommit(digest, instance.View, n)
+}
+
+// seqNoAvailable returns whether the primary may assign sequence number n
+func (instance *PbftCore) seqNoAvailable(n uint64) bool {
+	return instance.inWV(instance.View, n) && n <= instance.h+instance.window.limit(instance.L)
+}
+
+func (instance *PbftCore) resubmitRequests() {
+	if instance.Primary(instance.View) != instance.ID {
+		return
+	}
+
+	instance.purgeExpiredRequests()
+
+	var pending []string
+outer:
+	for d := range instance.OutstandingReqs {
+		for _, cert := range instance.certStore {
+			if cert.digest == d {
+				instance.log.Debug("Already have certificate for request %s not going to resubmit", d)
+				continue outer
+			}
+		}
+		pending = append(pending, d)
+	}
+
+	if instance.Scheduler == nil {
+		for _, d := range pending {
+			instance.log.Debug("Have detected request %s must be resubmitted", d)
+
+			// This is a request that has not been pre-prepared yet
+			// Trigger request processing again.
+			instance.RecvRequest(instance.OutstandingReqs[d])
+		}
+		return
+	}
+
+	instance.Scheduler.prune(instance.OutstandingReqs)
+	for len(pending) > 0 {
+		i, bypass := instance.Scheduler.next(pending, instance.OutstandingReqs)
+		d := pending[i]
+		pending = append(pending[:i], pending[i+1:]...)
+		instance.log.Debug("Have detected request %s with priority %d must be resubmitted", d, instance.OutstandingReqs[d].Priority)
+
+		seqNo := instance.seqNo
+		instance.RecvRequest(instance.OutstandingReqs[d])
+		if instance.seqNo != seqNo {
+			instance.Scheduler.scheduled(bypass)
+		} else if !instance.seqNoAvailable(instance.seqNo + 1) {
+			break
+		}
+	}
+}
+
+func (instance *PbftCore) recvPrePrepare(preprep *PrePrepare) error {
+	instance.log.Debug("Received pre-prepare from replica %d for view=%d/seqNo=%d",
+		preprep.ReplicaId, preprep.View, preprep.SequenceNumber)
+
+	if !instance.ActiveView {
+		instance.log.Debug("Ignoring pre-prepare as we in a view change")
+		return nil
+	}
+
+	if instance.Primary(instance.View) != preprep.ReplicaId {
+		instance.log.Warning("Pre-prepare from other than primary: got %d, should be %d", preprep.ReplicaId, instance.Primary(instance.View))
+		return nil
+	}
+
+	if !instance.inWV(preprep.View, preprep.SequenceNumber) {
+		if preprep.SequenceNumber != instance.h && !instance.SkipInProgress {
+			instance.log.Warning("Pre-prepare view different, or sequence number outside watermarks: preprep.View %d, expected.View %d, seqNo %d, low-mark %d", preprep.View, instance.Primary(instance.View), preprep.SequenceNumber, instance.h)
+		} else {
+			// This is perfectly normal
+			instance.log.Debug("Pre-prepare view different, or sequence number outside watermarks: preprep.View %d, expected.View %d, seqNo %d, low-mark %d", preprep.View, instance.Primary(instance.View), preprep.SequenceNumber, instance.h)
+		}
+
+		return nil
+	}
+
+	if preprep.SequenceNumber > instance.viewChangeSeqNo {
+		instance.log.Info("Received pre-prepare for %d, which should be from the next primary", preprep.SequenceNumber)
+		instance.SendViewChange()
+		return nil
+	}
+
+	if err := checkDigestAlgorithm(instance.Digest, preprep.DigestAlgorithm); err != nil {
+		instance.log.Warning("Rejecting pre-prepare for view=%d/seqNo=%d: %s", preprep.View, preprep.SequenceNumber, err)
+		return nil
+	}
+
+	if instance.executedReqs.contains(preprep.RequestDigest) {
+		instance.log.Warning("Received pre-prepare for view=%d/seqNo=%d with request %s, which was already executed", preprep.View, preprep.SequenceNumber, preprep.RequestDigest)
+		instance.Metrics.duplicateReqs.Inc()
+		return nil
+	}
+
+	if !instance.admitCert(preprep.View, preprep.SequenceNumber) {
+		return nil
+	}
+
+	cert := instance.getCert(preprep.View, preprep.SequenceNumber)
+	if cert.digest != "" && cert.digest != preprep.RequestDigest {
+		instance.log.Warning("Pre-prepare found for same view/seqNo but different digest: received %s, stored %s", preprep.RequestDigest, cert.digest)
+		if cert.prePrepare != nil {
+			instance.recordEvidence(faultConflictingPrePrepare, preprep.ReplicaId, preprep.View, preprep.SequenceNumber,
+				fmt.Sprintf("pre-prepares for view %d/seqNo %d with digests %s and %s", preprep.View, preprep.SequenceNumber, cert.digest, preprep.RequestDigest),
+				&Message{&Message_PrePrepare{cert.prePrepare}}, &Message{&Message_PrePrepare{preprep}})
+		}
+		instance.SendViewChange()
+		return nil
+	}
+
+	cert.prePrepare = preprep
+	cert.digest = preprep.RequestDigest
+
+	// Store the request if, for whatever reason, haven't received it from an earlier broadcast.
+	if _, ok := instance.reqStore[preprep.RequestDigest]; !ok && preprep.RequestDigest != "" && preprep.Request == nil {
+		// digest-only pre-prepare, we prepare once the primary returns the request
+		if err := instance.fetchBigRequest(preprep.RequestDigest, preprep.ReplicaId); err != nil {
+			instance.log.Warning("Could not fetch request %s: %s", preprep.RequestDigest, err)
+		}
+	} else if !ok && preprep.RequestDigest != "" {
+		digest := HashReq(instance.Digest, preprep.Request)
+		if digest != preprep.RequestDigest {
+			instance.log.Warning("Pre-prepare request and request digest do not match: request %s, digest %s",
+				digest, preprep.RequestDigest)
+			return nil
+		}
+		if err := instance.validateRequest(preprep.Request); err != nil {
+			instance.log.Warning("Request %s did not verify: %s", digest, err)
+			return err
+		}
+
+		instance.reqStore[digest] = preprep.Request
+		instance.log.Debug("Storing request %s in outstanding request store", digest)
+		instance.OutstandingReqs[digest] = preprep.Request
+		instance.AdaptiveTimeout.requestArrived(digest)
+	}
+	instance.Phases.prePrepared(cert)
+	instance.persistPrePrepare(preprep)
+	instance.traceEnter(preprep.RequestDigest, "prepare", preprep.View, preprep.SequenceNumber)
+
+	instance.softStartTimer(instance.GetRequestTimeout(), fmt.Sprintf("new pre-prepare for %s", preprep.RequestDigest))
+	instance.nullRequestTimer.Stop()
+
+	return instance.maybeSendPrepare(preprep)
+}
+
+// maybeSendPrepare prepares a pre-prepare once we hold its request
+func (instance *PbftCore) maybeSendPrepare(preprep *PrePrepare) error {
+	cert := instance.getCert(preprep.View, preprep.SequenceNumber)
+	if instance.Primary(instance.View) != instance.ID && instance.prePrepared(preprep.RequestDigest, preprep.View, preprep.SequenceNumber) && !cert.sentPrepare {
+		instance.log.Debug("Backup broadcasting prepare for view=%d/seqNo=%d",
+			preprep.View, preprep.SequenceNumber)
+
+		prep := &Prepare{
+			View:           preprep.View,
+			SequenceNumber: preprep.SequenceNumber,
+			RequestDigest:  preprep.RequestDigest,
+			ReplicaId:      instance.ID,
+			Epoch:          instance.epoch,
+		}
+		if err := instance.authenticate(prep); err != nil {
+			return fmt.Errorf("Cannot authenticate prepare: %s", err)
+		}
+
+		cert.sentPrepare = true
+		instance.recvPrepare(prep)
+		return instance.broadcastVote(prep.View, &Message{&Message_Prepare{prep}})
+	}
+
+	return nil
+}
+
+func (instance *PbftCore) recvPrepare(prep *Prepare) error {
+	instance.log.Debug("Received prepare from replica %d for view=%d/seqNo=%d",
+		prep.ReplicaId, prep.View, prep.SequenceNumber)
+
+	if instance.Primary(prep.View) == prep.ReplicaId {
+		instance.log.Warning("Received prepare from primary, ignoring")
+		return nil
+	}
+
+	if !instance.inWV(prep.View, prep.SequenceNumber) {
+		if prep.SequenceNumber != instance.h && !instance.SkipInProgress {
+			instance.log.Warning("Ignoring prepare for view=%d/seqNo=%d: not in-wv, in view %d, low water mark %d", prep.View, prep.SequenceNumber, instance.View, instance.h)
+		} else {
+			// This is perfectly normal
+			instance.log.Debug("Ignoring prepare for view=%d/seqNo=%d: not in-wv, in view %d, low water mark %d", prep.View, prep.SequenceNumber, instance.View, instance.h)
+		}
+		return nil
+	}
+
+	if !instance.admitCert(prep.View, prep.SequenceNumber) {
+		return nil
+	}
+
+	cert := instance.getCert(prep.View, prep.SequenceNumber)
+
+	if hasPrepare(cert, prep.ReplicaId) {
+		instance.log.Warning("Ignoring duplicate prepare from %d", prep.ReplicaId)
+		return nil
+	}
+	cert.prepare = append(cert.prepare, prep)
+	instance.persistPrepare(prep)
+	instance.relayPrepare(prep)
+
+	if cert.prePrepare == nil {
+		return instance.maybeFetchRequest(prep.RequestDigest, prep.View, prep.SequenceNumber)
+	}
+	return instance.maybeSendCommit(prep.RequestDigest, prep.View, prep.SequenceNumber)
+}
+
+func (instance *PbftCore) maybeSendCommit(digest string, v uint64, n uint64) error {
+	cert := instance.getCert(v, n)
+
+	if instance.prepared(digest, v, n) && !cert.sentCommit {
+		instance.log.Debug("Broadcasting commit for view=%d/seqNo=%d",
+			v, n)
+
+		commit := &Commit{
+			View:           v,
+			SequenceNumber: n,
+			RequestDigest:  digest,
+			ReplicaId:      instance.ID,
+			Share:          instance.commitShare(n, digest),
+			Epoch:          instance.epoch,
+		}
+		if err := instance.authenticate(commit); err != nil {
+			return fmt.Errorf("Cannot authenticate commit: %s", err)
+		}
+
+		cert.sentCommit = true
+		instance.Phases.prepared(cert)
+		instance.traceEnter(digest, "commit", v, n)
+
+		instance.recvCommit(commit)
+		err := instance.broadcastVote(v, &Message{&Message_Commit{commit}})
+		instance.maybeSpeculate()
+		return err
+	}
+
+	return nil
+}
+
+func (instance *PbftCore) recvCommit(commit *Commit) error {
+	instance.log.Debug("Received commit from replica %d for view=%d/seqNo=%d",
+		commit.ReplicaId, commit.View, commit.SequenceNumber)
+
+	if !instance.inWV(commit.View, commit.SequenceNumber) {
+		if commit.SequenceNumber != instance.h && !instance.SkipInProgress {
+			instance.log.Warning("Ignoring commit for view=%d/seqNo=%d: not in-wv, in view %d, high water mark %d", commit.View, commit.SequenceNumber, instance.View, instance.h)
+		} else {
+			// This is perfectly normal
+			instance.log.Debug("Ignoring commit for view=%d/seqNo=%d: not in-wv, in view %d, high water mark %d", commit.View, commit.SequenceNumber, instance.View, instance.h)
+		}
+		return nil
+	}
+
+	if !instance.admitCert(commit.View, commit.SequenceNumber) {
+		return nil
+	}
+
+	cert := instance.getCert(commit.View, commit.SequenceNumber)
+	if hasCommit(cert, commit.ReplicaId) {
+		instance.log.Warning("Ignoring duplicate commit from %d", commit.ReplicaId)
+		return nil
+	}
+	cert.commit = append(cert.commit, commit)
+	instance.persistCommit(commit)
+	instance.relayCommit(commit)
+
+	if instance.committed(commit.RequestDigest, commit.View, commit.SequenceNumber) {
+		instance.Phases.committed(cert)
+		instance.recvCommitCert(commit.RequestDigest, commit.SequenceNumber)
+	}
+
+	return nil
+}
+
+// recvCommitCert is called once the commit certificate for a request is complete
+func (instance *PbftCore) recvCommitCert(digest string, n uint64) {
+	instance.StopTimer()
+	instance.LastNewViewTimeout = instance.backoff.committed(instance.LastNewViewTimeout, instance.NewViewTimeout)
+	delete(instance.OutstandingReqs, digest)
+	instance.AdaptiveTimeout.requestCommitted(digest)
+	instance.Phases.forget(digest)
+	instance.startTimerIfOutstandingRequests()
+	if n == instance.viewChangeSeqNo {
+		instance.log.Info("Cycling view")
+		if instance.blacklist != nil {
+			instance.blacklist.periodicViewChange()
+			instance.persistBlacklist()
+		}
+		instance.SendViewChange()
+	}
+
+	instance.executeOutstanding()
+}
+
+func (instance *PbftCore) executeOutstanding() {
+	if instance.CurrentExec != nil {
+		instance.log.Debug("Not attempting to executeOutstanding because it is currently executing %d", *instance.CurrentExec)
+		return
+	}
+	instance.log.Debug("Attempting to executeOutstanding")
+
+	for idx := range instance.certStore {
+		if instance.executeOne(idx) {
+			break
+		}
+	}
+	instance.maybeSpeculate()
+
+	instance.log.Debug("Certstore %+v", instance.certStore)
+
+	return
+}
+
+func (instance *PbftCore) executeOne(idx msgID) bool {
+	cert := instance.certStore[idx]
+
+	if idx.n != instance.LastExec+1 || cert == nil || cert.prePrepare == nil {
+		return false
+	}
+
+	if instance.SkipInProgress {
+		instance.log.Debug("Currently picking a starting point to resume, will not execute")
+		return false
+	}
+
+	// we now have the right sequence number that doesn't create holes
+
+	digest := cert.digest
+	req := instance.reqStore[digest]
+
+	if !instance.committed(digest, idx.v, idx.n) {
+		return false
+	}
+
+	// we have a commit certificate for this request
+	currentExec := idx.n
+	instance.CurrentExec = &currentExec
+	instance.currentExecID = idx
+	instance.traceEnter(digest, "execute", idx.v, idx.n)
+	instance.recordAudit(idx, digest)
+	instance.certifyCommit(idx, digest)
+
+	if instance.speculation.matches(idx.n, digest) {
+		instance.log.Info("Committing speculatively executed request for view=%d/seqNo=%d and digest %s",
+			idx.v, idx.n, ReqLabel(digest, req))
+		instance.executedReqs.add(digest)
+		instance.confirmSpeculation(idx.n)
+		return true
+	}
+	instance.rollbackSpeculation()
+
+	// null request
+	if digest == "" {
+		instance.log.Info("Executing/committing null request for view=%d/seqNo=%d",
+			idx.v, idx.n)
+		instance.ExecDoneSync(nil)
+	} else if req.EpochReset {
+		instance.log.Info("Executing/committing request to end the epoch for view=%d/seqNo=%d and digest %s",
+			idx.v, idx.n, digest)
+		instance.executedReqs.add(digest)
+		instance.endEpoch(idx.n)
+		if observer, ok := instance.consumer.(epochResetObserver); ok {
+			observer.EpochResetExecuted(req)
+		}
+		instance.ExecDoneSync(nil)
+	} else {
+		instance.log.Info("Executing/committing request for view=%d/seqNo=%d and digest %s",
+			idx.v, idx.n, ReqLabel(digest, req))
+		instance.executedReqs.add(digest)
+
+		// asynchronously execute, the callback reports completion
+		instance.execQueue.submit(idx.n, req.Payload, instance.ExecCompletion(idx.n))
+	}
+	return true
+}
+
+func (instance *PbftCore) Checkpoint(seqNo uint64, id []byte) {
+	if seqNo%instance.K != 0 {
+		instance.chkptLog.Error("Attempted to checkpoint a sequence number (%d) which is not a multiple of the checkpoint interval (%d)", seqNo, instance.K)
+		return
+	}
+
+	idAsString := base64.StdEncoding.EncodeToString(id)
+
+	instance.chkptLog.Debug("Preparing checkpoint for view=%d/seqNo=%d and b64 id of %s",
+		instance.View, seqNo, idAsString)
+
+	chkpt := &Checkpoint{
+		SequenceNumber:  seqNo,
+		ReplicaId:       instance.ID,
+		Id:              idAsString,
+		DigestAlgorithm: instance.Digest.name(),
+		Epoch:           instance.epoch,
+	}
+	instance.chkpts[seqNo] = idAsString
+
+	instance.persistCheckpoint(chkpt)
+	instance.recvCheckpoint(chkpt)
+	instance.innerBroadcast(&Message{&Message_Checkpoint{chkpt}})
+	if instance.threshold != nil {
+		instance.sendCheckpointShare(chkpt)
+	}
+}
+
+// ExecCompletion returns the callback through which the consumer reports
+// that the execution of seqNo completed, it queues an execDoneEvent
+func (instance *PbftCore) ExecCompletion(seqNo uint64) ExecCallback {
+	var once sync.Once
+	return func(state []byte) {
+		once.Do(func() {
+			instance.Manager.Queue() <- execDoneEvent{seqNo: seqNo, state: state}
+		})
+	}
+}
+
+// ExecDoneSync completes the outstanding execution, which resulted in state,
+// nil if the consumer did not report it
+func (instance *PbftCore) ExecDoneSync(state []byte) {
+	if instance.CurrentExec != nil {
+		instance.log.Info("Finished execution %d, trying next", *instance.CurrentExec)
+		instance.LastExec = *instance.CurrentExec
+		if cert := instance.certStore[instance.currentExecID]; cert != nil {
+			instance.Phases.executed(cert)
+			instance.Tracing.finish(cert.digest, "")
+		}
+		instance.caughtUp()
+		instance.sendReply(instance.currentExecID)
+		if instance.LastExec%instance.tuner.period(instance.K) == 0 || instance.LastExec == instance.epochEnd {
+			if instance.seqNo >= instance.h+instance.window.limit(instance.L) {
+				instance.tuner.stall()
+			}
+			start := instance.Clock.Now()
+			if state == nil {
+				state = instance.consumer.GetState()
+			}
+			instance.Checkpoint(instance.LastExec, state)
+			now := instance.Clock.Now()
+			instance.tuner.checkpointed(now, now.Sub(start))
+		}
+
+	} else {
+		// XXX This masks a bug, this should not be called when currentExec is nil
+		instance.log.Warning("Had execDoneSync called, flagging ourselves as out of date")
+		instance.SkipInProgress = true
+	}
+	instance.CurrentExec = nil
+
+	instance.executeOutstanding()
+}
+
+func (instance *PbftCore) moveWatermarks(n uint64) {
+	// round down n to previous low watermark
+	h := n / instance.K * instance.K
+
+	instance.retainCommitCerts(h)
+	instance.Tracing.collect(h)
+
+	for idx, cert := range instance.certStore {
+		if idx.n <= h {
+			instance.chkptLog.Debug("Cleaning quorum certificate for view=%d/seqNo=%d",
+				idx.v, idx.n)
+			delete(instance.reqStore, cert.digest)
+			delete(instance.certStore, idx)
+		}
+	}
+
+	for testChkpt := range instance.checkpointStore {
+		if testChkpt.SequenceNumber <= h {
+			instance.chkptLog.Debug("Cleaning checkpoint message from replica %d, seqNo %d, b64 snapshot id %s",
+				testChkpt.ReplicaId, testChkpt.SequenceNumber, testChkpt.Id)
+			delete(instance.checkpointStore, testChkpt)
+		}
+	}
+
+	for n := range instance.pset {
+		if n <= h {
+			delete(instance.pset, n)
+		}
+	}
+
+	for digest, idx := range instance.lostReqs {
+		if idx.n <= h {
+			delete(instance.lostReqs, digest)
+		}
+	}
+
+	for idx := range instance.qset {
+		if idx.n <= h {
+			delete(instance.qset, idx)
+		}
+	}
+
+	for n := range instance.chkpts {
+		if n < h {
+			delete(instance.chkpts, n)
+		}
+	}
+
+	if instance.threshold != nil {
+		instance.threshold.prune(h)
+	}
+	if instance.commitCerts != nil {
+		instance.commitCerts.prune(h)
+	}
+	if instance.relay != nil {
+		instance.relay.prune(h)
+	}
+
+	instance.h = h
+	instance.persistTruncate(h)
+	instance.garbageCollectFaults()
+	if instance.seqNo > instance.LastExec {
+		instance.window.resize(instance.seqNo - instance.LastExec)
+	} else {
+		instance.window.resize(0)
+	}
+
+	instance.chkptLog.Debug("Updated low watermark to %d",
+		instance.h)
+
+	instance.resubmitRequests()
+}
+
+func (instance *PbftCore) weakCheckpointSetOutOfRange(chkpt *Checkpoint) bool {
+
+	H := instance.h + instance.L
+
+	// Track the last observed checkpoint sequence number if it exceeds our high watermark, keyed by replica to prevent unbounded growth
+	if chkpt.SequenceNumber < H {
+		// For non-byzantine nodes, the checkpoint sequence number increases monotonically
+		delete(instance.hChkpts, chkpt.ReplicaId)
+	} else {
+		// We do not track the highest one, as a byzantine node could pick an arbitrarilly high sequence number
+		// and even if it recovered to be non-byzantine, we would still believe it to be far ahead
+		instance.hChkpts[chkpt.ReplicaId] = chkpt.SequenceNumber
+
+		// If f+1 other replicas have reported checkpoints that were (at one time) outside our watermarks
+		// we need to check to see if we have fallen behind.
+		if len(instance.hChkpts) >= instance.F+1 {
+			chkptSeqNumArray := make([]uint64, len(instance.hChkpts))
+			index := 0
+			for replicaID, hChkpt := range instance.hChkpts {
+				chkptSeqNumArray[index] = hChkpt
+				index++
+				if hChkpt < H {
+					delete(instance.hChkpts, replicaID)
+				}
+			}
+			sort.Sort(sortableUint64Slice(chkptSeqNumArray))
+
+			// If f+1 nodes have issued checkpoints above our high water mark, then
+			// we will never record 2f+1 checkpoints for that sequence number, we are out of date
+			// (This is because all_replicas - missed - me = 3f+1 - f - 1 = 2f)
+			if m := chkptSeqNumArray[len(chkptSeqNumArray)-(instance.F+1)]; m > H {
+				if m <= instance.LastExec {
+					// We caught up through commit certificates, and only need to move our watermarks
+					instance.moveWatermarks(m)
+					return true
+				}
+				if instance.catchUp(m) {
+					// Only a few sequence numbers behind, execute them from their commit certificates
+					return true
+				}
+				instance.chkptLog.Warning("Out of date, f+1 nodes agree checkpoint with seqNo %d exists but our high water mark is %d", chkpt.SequenceNumber, H)
+				instance.reqStore = make(map[string]*Request) // Discard all our requests, as we will never know which were executed, to be addressed in #394
+				instance.moveWatermarks(m)
+				instance.OutstandingReqs = make(map[string]*Request)
+				instance.AdaptiveTimeout.Reset()
+				instance.Phases.Reset()
+				instance.SkipInProgress = true
+				instance.consumer.InvalidateState()
+				instance.StopTimer()
+
+				// TODO, reprocess the already gathered checkpoints, this will make recovery faster, though it is presently correct
+
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// rankByCheckpoint orders replicas by the highest checkpoint each has advertised, highest first,
+// so that state transfer first asks the replicas which are furthest ahead. Replicas whose
+// heartbeats stopped come last. State transfer then prefers the most responsive of them, and
+// fails over to the next when a source stalls
+func (instance *PbftCore) rankByCheckpoint(replicas []uint64) {
+	height := make(map[uint64]uint64)
+	for chkpt := range instance.checkpointStore {
+		if chkpt.SequenceNumber > height[chkpt.ReplicaId] {
+			height[chkpt.ReplicaId] = chkpt.SequenceNumber
+		}
+	}
+	for replicaID, seqNo := range instance.hChkpts {
+		if seqNo > height[replicaID] {
+			height[replicaID] = seqNo
+		}
+	}
+	unresponsive := make(map[uint64]bool)
+	for _, replicaID := range replicas {
+		unresponsive[replicaID] = !instance.responsive(replicaID)
+	}
+	sort.Sort(replicasByHeight{replicas: replicas, height: height, unresponsive: unresponsive})
+}
+
+func (instance *PbftCore) witnessCheckpointWeakCert(chkpt *Checkpoint) {
+	checkpointMembers := make([]uint64, instance.F+1) // Only ever invoked for the first weak cert, so guaranteed to be f+1
+	i := 0
+	for testChkpt := range instance.checkpointStore {
+		if testChkpt.SequenceNumber == chkpt.SequenceNumber && testChkpt.Id == chkpt.Id {
+			checkpointMembers[i] = testChkpt.ReplicaId
+			instance.chkptLog.Debug("Adding replica %d (handle %v) to weak cert", testChkpt.ReplicaId, checkpointMembers[i])
+			i++
+		}
+	}
+
+	snapshotID, err := base64.StdEncoding.DecodeString(chkpt.Id)
+	if nil != err {
+		err = fmt.Errorf("Replica %d received a weak checkpoint cert which could not be decoded (%s)", instance.ID, chkpt.Id)
+		instance.chkptLog.Error("%s", err)
+		return
+	}
+
+	if instance.SkipInProgress {
+		instance.chkptLog.Debug("Catching up, witnessed a weak certificate for checkpoint %d, weak cert attested to by %d of %d (%v)",
+			chkpt.SequenceNumber, i, instance.replicaCount, checkpointMembers)
+		// The view should not be set to active, this should be handled by the yet unimplemented SUSPECT, see https://github.com/hyperledger/fabric/issues/1120
+		instance.rollbackSpeculation()
+		instance.rankByCheckpoint(checkpointMembers)
+		instance.consumer.SkipTo(chkpt.SequenceNumber, snapshotID, checkpointMembers) // This will kick off state transfer if it is not already going, but if it is going, we may transfer to an earlier point
+	}
+}
+
+func (instance *PbftCore) recvCheckpoint(chkpt *Checkpoint) error {
+	instance.chkptLog.Debug("Received checkpoint from replica %d, seqNo %d, digest %s",
+		chkpt.ReplicaId, chkpt.SequenceNumber, chkpt.Id)
+
+	if err := checkDigestAlgorithm(instance.Digest, chkpt.DigestAlgorithm); err != nil {
+		instance.chkptLog.Warning("Rejecting checkpoint from replica %d for seqNo %d: %s", chkpt.ReplicaId, chkpt.SequenceNumber, err)
+		return nil
+	}
+
+	if instance.weakCheckpointSetOutOfRange(chkpt) {
+		return nil
+	}
+
+	if !instance.inW(chkpt.SequenceNumber) {
+		if id, ok := instance.chkpts[chkpt.SequenceNumber]; ok && chkpt.SequenceNumber == instance.h && chkpt.Id != id && !instance.SkipInProgress {
+			instance.recordEvidence(faultBogusCheckpoint, chkpt.ReplicaId, instance.View, chkpt.SequenceNumber,
+				fmt.Sprintf("checkpoint for seqNo %d has digest %s, stable checkpoint has digest %s", chkpt.SequenceNumber, chkpt.Id, id),
+				&Message{&Message_Checkpoint{chkpt}})
+		}
+		if chkpt.SequenceNumber != instance.h && !instance.SkipInProgress {
+			// It is perfectly normal that we receive checkpoints for the watermark we just raised, as we raise it after 2f+1, leaving f replies left
+			instance.chkptLog.Warning("Checkpoint sequence number outside watermarks: seqNo %d, low-mark %d", chkpt.SequenceNumber, instance.h)
+		} else {
+			instance.chkptLog.Debug("Checkpoint sequence number outside watermarks: seqNo %d, low-mark %d", chkpt.SequenceNumber, instance.h)
+		}
+		return nil
+	}
+
+	instance.checkpointStore[*chkpt] = true
+
+	matching := 0
+	for testChkpt := range instance.checkpointStore {
+		if testChkpt.SequenceNumber == chkpt.SequenceNumber && testChkpt.Id == chkpt.Id {
+			matching++
+		}
+	}
+	instance.chkptLog.Debug("Found %d matching checkpoints for seqNo %d, digest %s",
+		matching, chkpt.SequenceNumber, chkpt.Id)
+
+	if matching == instance.F+1 {
+		// We do have a weak cert
+		instance.witnessCheckpointWeakCert(chkpt)
+		instance.maybeCatchUp(chkpt.SequenceNumber)
+	}
+
+	if matching < instance.intersectionQuorum() {
+		// We do not have a quorum yet
+		return nil
+	}
+
+	// It is actually just fine if we do not have this checkpoint
+	// and should not trigger a state transfer
+	// Imagine we are executing sequence number k-1 and we are slow for some reason
+	// then everyone else finishes executing k, and we receive a checkpoint quorum
+	// which we will agree with very shortly, but do not move our watermarks until
+	// we have reached this checkpoint
+	// Note, this is not divergent from the paper, as the paper requires that
+	// the quorum certificate must contain 2f+1 messages, including its own
+	if _, ok := instance.chkpts[chkpt.SequenceNumber]; !ok {
+		instance.chkptLog.Debug("Found checkpoint quorum for seqNo %d, digest %s, but it has not reached this checkpoint itself yet",
+			chkpt.SequenceNumber, chkpt.Id)
+		return nil
+	}
+
+	instance.chkptLog.Debug("Found checkpoint quorum for seqNo %d, digest %s",
+		chkpt.SequenceNumber, chkpt.Id)
+
+	instance.reportBogusCheckpoints(chkpt)
+	instance.stableCert = nil
+	for testChkpt := range instance.checkpointStore {
+		if testChkpt.SequenceNumber == chkpt.SequenceNumber && testChkpt.Id == chkpt.Id {
+			c := testChkpt
+			instance.stableCert = append(instance.stableCert, &c)
+		}
+	}
+	instance.moveWatermarks(chkpt.SequenceNumber)
+	instance.Metrics.checkpoints.Inc()
+	instance.maybeRotateSessionKey()
+	instance.maybeRollEpoch()
+
+	return instance.processNewView()
+}
+
+// used in view-change to fetch missing assigned, non-checkpointed requests
+func (instance *PbftCore) fetchRequests() (err error) {
+	var msg *Message
+	for digest := range instance.missingReqs {
+		msg = &Message{&Message_FetchRequest{&FetchRequest{
+			RequestDigest: digest,
+			ReplicaId:     instance.ID,
+		}}}
+		instance.innerBroadcast(msg)
+	}
+
+	return
+}
+
+func (instance *PbftCore) recvFetchRequest(fr *FetchRequest) (err error) {
+	digest := fr.RequestDigest
+	if _, ok := instance.reqStore[digest]; !ok {
+		return nil // we don't have it either
+	}
+
+	req := instance.reqStore[digest]
+	msg := &Message{&Message_ReturnRequest{ReturnRequest: req}}
+	msgPacked, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("Error marshalling return-request message: %v", err)
+	}
+
+	receiver := fr.ReplicaId
+	err = instance.consumer.Unicast(msgPacked, receiver)
+
+	return
+}
+
+func (instance *PbftCore) recvReturnRequest(req *Request) (err error) {
+	digest := HashReq(instance.Digest, req)
+	if pulled, err := instance.recvPulledRequest(digest, req); pulled {
+		return err
+	}
+	_, missing := instance.missingReqs[digest]
+	big := instance.bigReqs[digest]
+	lost, isLost := instance.lostReqs[digest]
+	if !missing && !big && !isLost {
+		return nil // either the wrong digest, or we got it already from someone else
+	}
+
+	instance.reqStore[digest] = req
+	delete(instance.missingReqs, digest)
+	delete(instance.bigReqs, digest)
+	delete(instance.lostReqs, digest)
+	instance.persistRequest(digest)
+
+	if isLost {
+		return instance.recvLostRequest(digest, req, lost)
+	}
+	if big && instance.ActiveView {
+		return instance.recvBigRequest(digest, req)
+	}
+	return instance.processNewView()
+}
+
+// =============================================================================
+// Misc. methods go here
+// =============================================================================
+
+// Marshals a Message and hands it to the Stack. If toSelf is true,
+// the message is also dispatched to the local instance's RecvMsgSync.
+func (instance *PbftCore) innerBroadcast(msg *Message) error {
+	msgRaw, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("[innerBroadcast] Cannot marshal message: %s", err)
+	}
+
+	doByzantine := false
+	if instance.byzantine {
+		rand1 := rand.New(rand.NewSource(time.Now().UnixNano()))
+		doIt := rand1.Intn(3) // go byzantine about 1/3 of the time
+		if doIt == 1 {
+			doByzantine = true
+		}
+	}
+
+	// testing byzantine fault.
+	if doByzantine {
+		rand2 := rand.New(rand.NewSource(time.Now().UnixNano()))
+		ignoreidx := rand2.Intn(instance.N)
+		for i := 0; i < instance.N; i++ {
+			if i != ignoreidx && uint64(i) != instance.ID { //Pick a random replica and do not send message
+				instance.consumer.Unicast(msgRaw, uint64(i))
+			} else {
+				instance.log.Debug("PBFT byzantine: not broadcasting to replica %v", i)
+			}
+		}
+	} else {
+		instance.consumer.Broadcast(msgRaw)
+	}
+	return nil
+}
+
+func (instance *PbftCore) updateViewChangeSeqNo() {
+	if instance.viewChangePeriod <= 0 {
+		return
+	}
+	instance.viewChangeSeqNo = instance.seqNo + instance.viewChangePeriod*instance.K
+}
+
+func (instance *PbftCore) startTimerIfOutstandingRequests() {
+	if len(instance.OutstandingReqs) > 0 {
+		reqs := func() []string {
+			var r []string
+			for s := range instance.OutstandingReqs {
+				r = append(r, s)
+			}
+			return r
+		}()
+		instance.softStartTimer(instance.GetRequestTimeout(), fmt.Sprintf("outstanding requests %v", reqs))
+	} else if instance.nullRequestTimeout > 0 {
+		timeout := instance.nullRequestTimeout
+		if instance.Primary(instance.View) != instance.ID {
+			// we're waiting for the primary to deliver a null request - give it a bit more time
+			timeout += instance.GetRequestTimeout()
+		}
+		instance.nullRequestTimer.Reset(timeout, nullRequestEvent{})
+	}
+}
+
+// GetRequestTimeout returns the progress timeout for requests, which is the
+// configured request timeout unless adaptive request timeouts are enabled
+func (instance *PbftCore) GetRequestTimeout() time.Duration {
+	return instance.AdaptiveTimeout.timeout(instance.RequestTimeout)
+}
+
+func (instance *PbftCore) softStartTimer(timeout time.Duration, reason string) {
+	instance.log.Debug("Soft starting new view timer for %s: %s", timeout, reason)
+	instance.newViewTimerReason = reason
+	instance.TimerActive = true
+	instance.newViewTimer.SoftReset(timeout, viewChangeTimerEvent{})
+}
+
+func (instance *PbftCore) StartTimer(timeout time.Duration, reason string) {
+	instance.log.Debug("Starting new view timer for %s: %s", timeout, reason)
+	instance.TimerActive = true
+	instance.newViewTimer.Reset(timeout, viewChangeTimerEvent{})
+}
+
+func (instance *PbftCore) StopTimer() {
+	instance.log.Debug("Stopping a running new view timer")
+	instance.TimerActive = false
+	instance.newViewTimer.Stop()
+}
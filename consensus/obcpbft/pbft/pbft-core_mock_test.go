@@ -0,0 +1,246 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/consensus/testkit"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type pbftEndpoint struct {
+	*testkit.NetworkEndpoint
+	pbft *PbftCore
+	sc   *simpleConsumer
+}
+
+func (pe *pbftEndpoint) Deliver(msgPayload []byte, senderHandle *pb.PeerID) {
+	senderID, _ := testkit.ValidatorID(senderHandle)
+	msg := &Message{}
+	err := proto.Unmarshal(msgPayload, msg)
+	if err != nil {
+		panic("Told deliver something which did not unmarshal")
+	}
+
+	pe.pbft.Manager.Queue() <- &pbftMessage{msg: msg, sender: senderID}
+}
+
+func (pe *pbftEndpoint) Stop() {
+	pe.pbft.Close()
+}
+
+func (pe *pbftEndpoint) IsBusy() bool {
+	if pe.pbft.TimerActive || pe.pbft.CurrentExec != nil || pe.pbft.runningQueries > 0 {
+		pe.Net.DebugMsg("TEST: Returning as busy because timer active (%v) or current exec (%v) or running queries (%d)\n", pe.pbft.TimerActive, pe.pbft.CurrentExec, pe.pbft.runningQueries)
+		return true
+	}
+
+	// TODO, this looks racey, but seems fine, because the message send is on an unbuffered
+	// channel, the send blocks until the thread has picked up the new work, still
+	// this will be removed pending the transition to an externally driven state machine
+	select {
+	case pe.pbft.Manager.Queue() <- nil:
+	default:
+		pe.Net.DebugMsg("TEST: Returning as busy no reply on idleChan\n")
+		return true
+	}
+
+	return false
+}
+
+type pbftNetwork struct {
+	*testkit.Network
+	pbftEndpoints []*pbftEndpoint
+}
+
+type simpleConsumer struct {
+	pe            *pbftEndpoint
+	pbftNet       *pbftNetwork
+	executions    uint64
+	lastSeqNo     uint64
+	skipOccurred  bool
+	lastExecution []byte
+	queryImpl     func(txRaw []byte) ([]byte, error)
+	adversary     adversary // rewrites the messages of a byzantine replica, nil if correct
+	evidence      []*Evidence
+	certs         []*ReplyCertificate
+	mockPersist
+}
+
+func (sc *simpleConsumer) Broadcast(msgPayload []byte) {
+	if sc.adversary != nil {
+		var receivers []uint64
+		for id := range sc.pbftNet.pbftEndpoints {
+			if uint64(id) != sc.pe.ID {
+				receivers = append(receivers, uint64(id))
+			}
+		}
+		sc.sendAdversarial(msgPayload, receivers)
+		return
+	}
+	sc.pe.Broadcast(&pb.Message{Payload: msgPayload}, pb.PeerEndpoint_VALIDATOR)
+}
+func (sc *simpleConsumer) Unicast(msgPayload []byte, receiverID uint64) error {
+	if sc.adversary != nil {
+		sc.sendAdversarial(msgPayload, []uint64{receiverID})
+		return nil
+	}
+	return sc.pe.Unicast(&pb.Message{Payload: msgPayload}, testkit.ValidatorHandle(receiverID))
+}
+
+func (sc *simpleConsumer) Close() {
+	// No-op
+}
+
+func (sc *simpleConsumer) ValidateRequest(txRaw []byte) error {
+	return nil
+}
+
+func (sc *simpleConsumer) Sign(msg []byte) ([]byte, error) {
+	return msg, nil
+}
+
+func (sc *simpleConsumer) Verify(senderID uint64, signature []byte, message []byte) error {
+	return nil
+}
+
+func (sc *simpleConsumer) ViewChange(curView uint64) {
+}
+
+func (sc *simpleConsumer) InvalidateState() {}
+func (sc *simpleConsumer) ValidateState()   {}
+
+func (sc *simpleConsumer) ExecuteQuery(txRaw []byte) ([]byte, error) {
+	if sc.queryImpl != nil {
+		return sc.queryImpl(txRaw)
+	}
+	return []byte(fmt.Sprintf("%d:%s", sc.executions, txRaw)), nil
+}
+
+func (sc *simpleConsumer) ReportEvidence(ev *Evidence) {
+	sc.evidence = append(sc.evidence, ev)
+}
+
+func (sc *simpleConsumer) Executed(txRaw []byte, cert *ReplyCertificate) {
+	sc.certs = append(sc.certs, cert)
+}
+
+func (sc *simpleConsumer) SkipTo(seqNo uint64, id []byte, replicas []uint64) {
+	sc.skipOccurred = true
+	sc.executions = seqNo
+	sc.pbftNet.DebugMsg("TEST: skipping to %d\n", seqNo)
+}
+
+func (sc *simpleConsumer) Execute(seqNo uint64, tx []byte, done ExecCallback) {
+	sc.pbftNet.DebugMsg("TEST: executing request\n")
+	sc.lastExecution = tx
+	sc.executions++
+	sc.lastSeqNo = seqNo
+	sc.pbftNet.ReportExecution(int(sc.pe.ID), seqNo, fmt.Sprintf("%x", sha256.Sum256(tx)))
+	done(nil)
+}
+
+func (sc *simpleConsumer) GetState() []byte {
+	return []byte(fmt.Sprintf("%d", sc.executions))
+}
+
+func (sc *simpleConsumer) GetLastSeqNo() (uint64, error) {
+	if sc.executions < 1 {
+		return 0, fmt.Errorf("no execution yet")
+	}
+	return sc.lastSeqNo, nil
+}
+
+// discardConsumer discards the messages and executions of an instance which
+// runs outside of a pbftNetwork
+type discardConsumer struct {
+	*simpleConsumer
+}
+
+func (dc *discardConsumer) Broadcast(msgPayload []byte) {}
+func (dc *discardConsumer) Unicast(msgPayload []byte, receiverID uint64) error {
+	return nil
+}
+func (dc *discardConsumer) Execute(seqNo uint64, tx []byte, done ExecCallback) {
+	dc.executions++
+	dc.lastSeqNo = seqNo
+	done(nil)
+}
+
+// reportState reports the view, low watermark, last execution and epoch of
+// instance to the monitors of net after every event it processes
+func reportState(net *testkit.Network, instance *PbftCore) {
+	instance.ReportState(func(state *ReplicaState) {
+		net.ReportState(int(instance.ID), testkit.ReplicaState{
+			View:         state.View,
+			LowWatermark: state.LowWatermark,
+			LastExec:     state.LastExec,
+			Epoch:        state.Epoch,
+		})
+	})
+}
+
+func makePBFTNetwork(N int, config *viper.Viper, initFNs ...func(*pbftEndpoint)) *pbftNetwork {
+	return makeClockedPBFTNetwork(N, config, wallClock{}, initFNs...)
+}
+
+// makeClockedPBFTNetwork creates a network whose replicas' timers count down on clk
+func makeClockedPBFTNetwork(N int, config *viper.Viper, clk Clock, initFNs ...func(*pbftEndpoint)) *pbftNetwork {
+	if config == nil {
+		config = loadConfig()
+	}
+
+	config.Set("general.N", N)
+	config.Set("general.f", (N-1)/3)
+	endpointFunc := func(id uint64, net *testkit.Network) testkit.Endpoint {
+		tep := testkit.NewNetworkEndpoint(id, net)
+		pe := &pbftEndpoint{
+			NetworkEndpoint: tep,
+		}
+
+		pe.sc = &simpleConsumer{
+			pe: pe,
+		}
+
+		pe.pbft = newPbftCoreWithClock(id, config, pe.sc, clk)
+
+		for _, fn := range initFNs {
+			fn(pe)
+		}
+
+		pe.pbft.Manager.Start()
+
+		return pe
+
+	}
+
+	pn := &pbftNetwork{Network: testkit.NewNetwork(N, endpointFunc)}
+	pn.Classify = ClassifyMessage
+	pn.Attach(testkit.SafetyMonitors()...)
+	pn.pbftEndpoints = make([]*pbftEndpoint, len(pn.Endpoints))
+	for i, ep := range pn.Endpoints {
+		pn.pbftEndpoints[i] = ep.(*pbftEndpoint)
+		pn.pbftEndpoints[i].sc.pbftNet = pn
+		reportState(pn.Network, pn.pbftEndpoints[i].pbft)
+	}
+	return pn
+}
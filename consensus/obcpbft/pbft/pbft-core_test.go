@@ -14,12 +14,11 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package obcpbft
+package pbft
 
 import (
 	"fmt"
 	gp "google/protobuf"
-	"os"
 	"reflect"
 	"strings"
 	"sync"
@@ -29,6 +28,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/op/go-logging"
 
+	"github.com/hyperledger/fabric/consensus/testkit"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -36,36 +36,6 @@ func init() {
 	logging.SetLevel(logging.DEBUG, "")
 }
 
-func TestEnvOverride(t *testing.T) {
-	config := loadConfig()
-
-	key := "general.mode"               // for a key that exists
-	envName := "CORE_PBFT_GENERAL_MODE" // env override name
-	overrideValue := "overide_test"     // value to override default value with
-
-	// test key
-	if ok := config.IsSet("general.mode"); !ok {
-		t.Fatalf("Cannot test env override because \"%s\" does not seem to be set", key)
-	}
-
-	os.Setenv(envName, overrideValue)
-	// The override config value will cause other calls to fail unless unset.
-	defer func() {
-		os.Unsetenv(envName)
-	}()
-
-	if ok := config.IsSet("general.mode"); !ok {
-		t.Fatalf("Env override in place, and key \"%s\" is not set", key)
-	}
-
-	// read key
-	configVal := config.GetString("general.mode")
-	if configVal != overrideValue {
-		t.Fatalf("Env override in place, expected key \"%s\" to be \"%s\" but instead got \"%s\"", key, overrideValue, configVal)
-	}
-
-}
-
 func TestMaliciousPrePrepare(t *testing.T) {
 	mock := &omniProto{
 		broadcastImpl: func(msgPayload []byte) {
@@ -73,7 +43,7 @@ func TestMaliciousPrePrepare(t *testing.T) {
 		},
 	}
 	instance := newPbftCore(1, loadConfig(), mock)
-	defer instance.close()
+	defer instance.Close()
 	instance.replicaCount = 5
 
 	digest1 := "hi there"
@@ -90,10 +60,16 @@ func TestMaliciousPrePrepare(t *testing.T) {
 }
 
 func TestWrongReplicaID(t *testing.T) {
+	persist := &mockPersist{}
 	mock := &omniProto{
 		validateImpl: func(msg []byte) error {
 			return nil
 		},
+		signImpl: func(msg []byte) ([]byte, error) {
+			return msg, nil
+		},
+		StoreStateImpl:     persist.StoreState,
+		reportEvidenceImpl: func(ev *Evidence) {},
 	}
 	instance := newPbftCore(1, loadConfig(), mock)
 
@@ -125,7 +101,7 @@ func TestIncompletePayload(t *testing.T) {
 		},
 	}
 	instance := newPbftCore(1, loadConfig(), mock)
-	defer instance.close()
+	defer instance.Close()
 	instance.replicaCount = 5
 
 	broadcaster := uint64(generateBroadcaster(instance.replicaCount))
@@ -142,30 +118,76 @@ func TestIncompletePayload(t *testing.T) {
 	checkMsg(&Message{&Message_PrePrepare{&PrePrepare{ReplicaId: broadcaster}}}, "Expected to reject empty pre-prepare")
 }
 
+func TestMonitorCatchesRegression(t *testing.T) {
+	net := makePBFTNetwork(4, nil)
+	defer net.Stop()
+	violations := make(chan error, 10)
+	net.Violation = func(err error) {
+		violations <- err
+	}
+
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(1, 0)
+	net.Process()
+	if len(violations) != 0 {
+		t.Fatalf("Expected no violations in the normal case, got %v", <-violations)
+	}
+
+	// the violation is reported right after the event which rolls back the execution
+	instance := net.pbftEndpoints[1].pbft
+	instance.Manager.Queue() <- WorkEvent(func() {
+		instance.LastExec = 0
+	})
+	processed := make(chan struct{})
+	instance.Manager.Queue() <- WorkEvent(func() { close(processed) })
+	<-processed
+	if len(violations) != 1 {
+		t.Fatalf("Expected the rolled back execution to be reported once, got %d violations", len(violations))
+	}
+}
+
 func TestNetwork(t *testing.T) {
 	validatorCount := 7
 	net := makePBFTNetwork(validatorCount, nil)
 
 	msg := createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
-	net.pbftEndpoints[0].pbft.manager.queue() <- msg
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- msg
 
-	err := net.process()
+	err := net.Process()
 	if err != nil {
 		t.Fatalf("Processing failed: %s", err)
 	}
 
 	for _, pep := range net.pbftEndpoints {
 		if pep.sc.executions <= 0 {
-			t.Errorf("Instance %d did not execute transaction", pep.id)
+			t.Errorf("Instance %d did not execute transaction", pep.ID)
 			continue
 		}
 		if pep.sc.executions != 1 {
-			t.Errorf("Instance %d executed more than one transaction", pep.id)
+			t.Errorf("Instance %d executed more than one transaction", pep.ID)
 			continue
 		}
 		if !reflect.DeepEqual(pep.sc.lastExecution, msg.Payload) {
 			t.Errorf("Instance %d executed wrong transaction, %x should be %x",
-				pep.id, pep.sc.lastExecution, msg.Payload)
+				pep.ID, pep.sc.lastExecution, msg.Payload)
+		}
+	}
+}
+
+func TestNetworkOpaquePayload(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.priority.enabled", true)
+	net := makePBFTNetwork(4, config)
+	defer net.Stop()
+
+	payload := []byte("\xff\x00 not a transaction")
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequest(1, payload, 1)
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 1 || !reflect.DeepEqual(pep.sc.lastExecution, payload) {
+			t.Errorf("Instance %d should have executed the opaque payload once, executed %d, last %q", pep.ID, pep.sc.executions, pep.sc.lastExecution)
 		}
 	}
 }
@@ -175,7 +197,7 @@ type checkpointConsumer struct {
 	execWait *sync.WaitGroup
 }
 
-func (cc *checkpointConsumer) execute(seqNo uint64, tx []byte) {
+func (cc *checkpointConsumer) Execute(seqNo uint64, tx []byte, done ExecCallback) {
 }
 
 func TestCheckpoint(t *testing.T) {
@@ -187,7 +209,7 @@ func TestCheckpoint(t *testing.T) {
 	config.Set("general.K", 2)
 	config.Set("general.logmultiplier", 2)
 	net := makePBFTNetwork(validatorCount, config)
-	defer net.stop()
+	defer net.Stop()
 
 	execReq := func(iter int64) {
 		txTime := &gp.Timestamp{Seconds: iter, Nanos: 0}
@@ -197,16 +219,16 @@ func TestCheckpoint(t *testing.T) {
 			t.Fatalf("Failed to marshal TX block: %s", err)
 		}
 		msg := &Message{&Message_Request{&Request{Payload: txPacked, ReplicaId: uint64(generateBroadcaster(validatorCount))}}}
-		net.pbftEndpoints[0].pbft.manager.queue() <- pbftMessageEvent{msg: msg, sender: msg.GetRequest().ReplicaId}
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- pbftMessageEvent{msg: msg, sender: msg.GetRequest().ReplicaId}
 
-		net.process()
+		net.Process()
 	}
 
 	// execWait is 0, and execute will proceed
 	execReq(1)
 	execReq(2)
 	finishWait.Wait()
-	net.process()
+	net.Process()
 
 	for _, pep := range net.pbftEndpoints {
 		if len(pep.pbft.chkpts) != 1 {
@@ -243,16 +265,16 @@ func TestCheckpoint(t *testing.T) {
 	// unblock executes.
 	execWait.Add(-1)
 
-	net.process()
+	net.Process()
 	finishWait.Wait() // Decoupling the execution thread makes this nastiness necessary
-	net.process()
+	net.Process()
 
 	// by now request 7 should have been confirmed and executed
 
 	for _, pep := range net.pbftEndpoints {
 		expectedExecutions := uint64(7)
 		if pep.sc.executions != expectedExecutions {
-			t.Errorf("Should have executed %d, got %d instead for replica %d", expectedExecutions, pep.sc.executions, pep.id)
+			t.Errorf("Should have executed %d, got %d instead for replica %d", expectedExecutions, pep.sc.executions, pep.ID)
 		}
 	}
 }
@@ -260,7 +282,7 @@ func TestCheckpoint(t *testing.T) {
 func TestLostPrePrepare(t *testing.T) {
 	validatorCount := 4
 	net := makePBFTNetwork(validatorCount, nil)
-	defer net.stop()
+	defer net.Stop()
 
 	txTime := &gp.Timestamp{Seconds: 1, Nanos: 0}
 	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY, Timestamp: txTime}
@@ -272,34 +294,31 @@ func TestLostPrePrepare(t *testing.T) {
 		ReplicaId: uint64(generateBroadcaster(validatorCount)),
 	}
 
-	net.pbftEndpoints[0].pbft.manager.queue() <- (req)
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- (req)
 
 	// clear all messages sent by primary
-	msg := <-net.msgs
+	msg := <-net.Msgs
 	prePrep := &Message{}
-	err := proto.Unmarshal(msg.msg, prePrep)
+	err := proto.Unmarshal(msg.Msg, prePrep)
 	if err != nil {
 		t.Fatalf("Error unmarshaling message")
 	}
-	net.clearMessages()
+	net.ClearMessages()
 
 	// deliver pre-prepare to subset of replicas
 	for _, pep := range net.pbftEndpoints[1 : len(net.pbftEndpoints)-1] {
-		pep.pbft.manager.queue() <- prePrep.GetPrePrepare()
+		pep.pbft.Manager.Queue() <- prePrep.GetPrePrepare()
 	}
 
-	err = net.process()
+	err = net.Process()
 	if err != nil {
 		t.Fatalf("Processing failed: %s", err)
 	}
 
+	// replica 3 holds a prepare certificate without the pre-prepare, and fetches the request by its digest
 	for _, pep := range net.pbftEndpoints {
-		if pep.id != 3 && pep.sc.executions != 1 {
-			t.Errorf("Expected execution on replica %d", pep.id)
-			continue
-		}
-		if pep.id == 3 && pep.sc.executions > 0 {
-			t.Errorf("Expected no execution")
+		if pep.sc.executions != 1 {
+			t.Errorf("Expected execution on replica %d", pep.ID)
 			continue
 		}
 	}
@@ -308,7 +327,7 @@ func TestLostPrePrepare(t *testing.T) {
 func TestInconsistentPrePrepare(t *testing.T) {
 	validatorCount := 4
 	net := makePBFTNetwork(validatorCount, nil)
-	defer net.stop()
+	defer net.Stop()
 
 	txTime := &gp.Timestamp{Seconds: 1, Nanos: 0}
 	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY, Timestamp: txTime}
@@ -323,24 +342,24 @@ func TestInconsistentPrePrepare(t *testing.T) {
 		preprep := &PrePrepare{
 			View:           0,
 			SequenceNumber: 1,
-			RequestDigest:  hashReq(req),
+			RequestDigest:  HashReq(shake256Digest{}, req),
 			Request:        req,
 			ReplicaId:      0,
 		}
 		return preprep
 	}
 
-	net.pbftEndpoints[0].pbft.manager.queue() <- makePP(1).Request
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- makePP(1).Request
 
 	// clear all messages sent by primary
-	net.clearMessages()
+	net.ClearMessages()
 
 	// replace with fake messages
-	net.pbftEndpoints[1].pbft.manager.queue() <- makePP(1)
-	net.pbftEndpoints[2].pbft.manager.queue() <- makePP(2)
-	net.pbftEndpoints[3].pbft.manager.queue() <- makePP(3)
+	net.pbftEndpoints[1].pbft.Manager.Queue() <- makePP(1)
+	net.pbftEndpoints[2].pbft.Manager.Queue() <- makePP(2)
+	net.pbftEndpoints[3].pbft.Manager.Queue() <- makePP(3)
 
-	net.process()
+	net.Process()
 
 	for n, pep := range net.pbftEndpoints {
 		if pep.sc.executions < 1 || pep.sc.executions > 3 {
@@ -359,10 +378,10 @@ func TestViewChangeWatermarksMovement(t *testing.T) {
 		},
 		broadcastImpl: func(b []byte) {},
 	})
-	instance.manager.start()
-	instance.activeView = false
-	instance.view = 1
-	instance.lastExec = 10
+	instance.Manager.Start()
+	instance.ActiveView = false
+	instance.View = 1
+	instance.LastExec = 10
 
 	vset := make([]*ViewChange, 3)
 
@@ -421,10 +440,10 @@ func TestViewChangeWatermarksMovement(t *testing.T) {
 
 // This test is designed to detect a conflation of S and S' from the paper in the view change
 func TestViewChangeCheckpointSelection(t *testing.T) {
-	instance := &pbftCore{
-		f:  1,
+	instance := &PbftCore{
+		F:  1,
 		N:  4,
-		id: 0,
+		ID: 0,
 	}
 
 	vset := make([]*ViewChange, 3)
@@ -480,7 +499,7 @@ func TestViewChange(t *testing.T) {
 	config.Set("general.K", 2)
 	config.Set("general.logmultiplier", 2)
 	net := makePBFTNetwork(validatorCount, config)
-	defer net.stop()
+	defer net.Stop()
 
 	execReq := func(iter int64) {
 		txTime := &gp.Timestamp{Seconds: iter, Nanos: 0}
@@ -490,12 +509,12 @@ func TestViewChange(t *testing.T) {
 			t.Fatalf("Failed to marshal TX block: %s", err)
 		}
 		msg := &Message{&Message_Request{&Request{Payload: txPacked, ReplicaId: uint64(generateBroadcaster(validatorCount))}}}
-		net.pbftEndpoints[0].pbft.manager.queue() <- pbftMessageEvent{msg: msg, sender: msg.GetRequest().ReplicaId}
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- pbftMessageEvent{msg: msg, sender: msg.GetRequest().ReplicaId}
 		if err != nil {
 			t.Fatalf("Request failed: %s", err)
 		}
 
-		err = net.process()
+		err = net.Process()
 		if err != nil {
 			t.Fatalf("Processing failed: %s", err)
 		}
@@ -506,15 +525,15 @@ func TestViewChange(t *testing.T) {
 	execReq(3)
 
 	for i := 2; i < len(net.pbftEndpoints); i++ {
-		net.pbftEndpoints[i].pbft.sendViewChange()
+		net.pbftEndpoints[i].pbft.SendViewChange()
 	}
 
-	err := net.process()
+	err := net.Process()
 	if err != nil {
 		t.Fatalf("Processing failed: %s", err)
 	}
 
-	if net.pbftEndpoints[1].pbft.view != 1 || net.pbftEndpoints[0].pbft.view != 1 {
+	if net.pbftEndpoints[1].pbft.View != 1 || net.pbftEndpoints[0].pbft.View != 1 {
 		t.Fatalf("Replicas did not follow f+1 crowd to trigger view-change")
 	}
 
@@ -533,7 +552,7 @@ func TestViewChange(t *testing.T) {
 func TestInconsistentDataViewChange(t *testing.T) {
 	validatorCount := 4
 	net := makePBFTNetwork(validatorCount, nil)
-	defer net.stop()
+	defer net.Stop()
 
 	txTime := &gp.Timestamp{Seconds: 1, Nanos: 0}
 	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY, Timestamp: txTime}
@@ -548,24 +567,24 @@ func TestInconsistentDataViewChange(t *testing.T) {
 		preprep := &PrePrepare{
 			View:           0,
 			SequenceNumber: 1,
-			RequestDigest:  hashReq(req),
+			RequestDigest:  HashReq(shake256Digest{}, req),
 			Request:        req,
 			ReplicaId:      0,
 		}
 		return preprep
 	}
 
-	net.pbftEndpoints[0].pbft.manager.queue() <- makePP(0).Request
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- makePP(0).Request
 
 	// clear all messages sent by primary
-	net.clearMessages()
+	net.ClearMessages()
 
 	// replace with fake messages
-	net.pbftEndpoints[1].pbft.manager.queue() <- makePP(1)
-	net.pbftEndpoints[2].pbft.manager.queue() <- makePP(1)
-	net.pbftEndpoints[3].pbft.manager.queue() <- makePP(0)
+	net.pbftEndpoints[1].pbft.Manager.Queue() <- makePP(1)
+	net.pbftEndpoints[2].pbft.Manager.Queue() <- makePP(1)
+	net.pbftEndpoints[3].pbft.Manager.Queue() <- makePP(0)
 
-	err := net.process()
+	err := net.Process()
 	if err != nil {
 		t.Fatalf("Processing failed: %s", err)
 	}
@@ -581,14 +600,14 @@ func TestInconsistentDataViewChange(t *testing.T) {
 func TestViewChangeWithStateTransfer(t *testing.T) {
 	validatorCount := 4
 	net := makePBFTNetwork(validatorCount, nil)
-	defer net.stop()
+	defer net.Stop()
 
 	var err error
 
 	for _, pep := range net.pbftEndpoints {
 		pep.pbft.K = 2
 		pep.pbft.L = 6
-		pep.pbft.requestTimeout = 500 * time.Millisecond
+		pep.pbft.RequestTimeout = 500 * time.Millisecond
 	}
 
 	txTime := &gp.Timestamp{Seconds: 1, Nanos: 0}
@@ -606,7 +625,7 @@ func TestViewChangeWithStateTransfer(t *testing.T) {
 		preprep := &PrePrepare{
 			View:           0,
 			SequenceNumber: uint64(iter),
-			RequestDigest:  hashReq(req),
+			RequestDigest:  HashReq(shake256Digest{}, req),
 			Request:        req,
 			ReplicaId:      0,
 		}
@@ -615,16 +634,16 @@ func TestViewChangeWithStateTransfer(t *testing.T) {
 
 	// Have primary advance the sequence number past a checkpoint for replicas 0,1,2
 	for i := int64(1); i <= 3; i++ {
-		net.pbftEndpoints[0].pbft.manager.queue() <- makePP(i).Request
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- makePP(i).Request
 
 		// clear all messages sent by primary
-		net.clearMessages()
+		net.ClearMessages()
 
-		net.pbftEndpoints[0].pbft.manager.queue() <- makePP(i)
-		net.pbftEndpoints[1].pbft.manager.queue() <- makePP(i)
-		net.pbftEndpoints[2].pbft.manager.queue() <- makePP(i)
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- makePP(i)
+		net.pbftEndpoints[1].pbft.Manager.Queue() <- makePP(i)
+		net.pbftEndpoints[2].pbft.Manager.Queue() <- makePP(i)
 
-		err = net.process()
+		err = net.Process()
 		if err != nil {
 			t.Fatalf("Processing failed: %s", err)
 		}
@@ -634,24 +653,24 @@ func TestViewChangeWithStateTransfer(t *testing.T) {
 	fmt.Println("Done with stage 1")
 
 	// Add to replica 3's complaint, cause a view change
-	net.pbftEndpoints[1].pbft.sendViewChange()
-	net.pbftEndpoints[2].pbft.sendViewChange()
-	err = net.process()
+	net.pbftEndpoints[1].pbft.SendViewChange()
+	net.pbftEndpoints[2].pbft.SendViewChange()
+	err = net.Process()
 	if err != nil {
 		t.Fatalf("Processing failed: %s", err)
 	}
 
 	fmt.Println("Done with stage 3")
 
-	net.pbftEndpoints[1].pbft.manager.queue() <- makePP(5).Request
-	err = net.process()
+	net.pbftEndpoints[1].pbft.Manager.Queue() <- makePP(5).Request
+	err = net.Process()
 	if err != nil {
 		t.Fatalf("Processing failed: %s", err)
 	}
 
 	for _, pep := range net.pbftEndpoints {
 		if pep.sc.executions != 4 {
-			t.Errorf("Replica %d expected execution through seqNo 5 with one null exec, got %d executions", pep.pbft.id, pep.sc.executions)
+			t.Errorf("Replica %d expected execution through seqNo 5 with one null exec, got %d executions", pep.pbft.ID, pep.sc.executions)
 			continue
 		}
 	}
@@ -665,52 +684,48 @@ func TestNewViewTimeout(t *testing.T) {
 	config := loadConfig()
 	config.Set("general.timeout.request", "400ms")
 	config.Set("general.timeout.viewchange", "800ms")
-	net := makePBFTNetwork(validatorCount, config)
-	defer net.stop()
-
-	replica1Disabled := false
-	net.filterFn = func(src int, dst int, msg []byte) []byte {
-		if dst == -1 && src == 1 && replica1Disabled {
-			return nil
+	vc := newVirtualClock(time.Unix(0, 0))
+	net := makeClockedPBFTNetwork(validatorCount, config, vc)
+	defer net.Stop()
+
+	// elapse lets d pass on the virtual clock, in steps which give the
+	// replicas time to react to the timers which fired
+	elapse := func(d time.Duration) {
+		for step := 50 * time.Millisecond; d > 0; d -= step {
+			vc.advance(step)
+			time.Sleep(time.Millisecond)
 		}
-		return msg
 	}
 
 	broadcaster := uint64(generateBroadcaster(validatorCount))
 
 	req := createPbftRequestWithChainTx(1, broadcaster)
 
-	go net.processContinually()
+	go net.ProcessContinually()
 
 	// This will eventually trigger 1's request timeout
 	// We check that one single timed out replica will not keep trying to change views by itself
-	net.pbftEndpoints[1].pbft.manager.queue() <- req
-	fmt.Println("Debug: Sleeping 1")
-	time.Sleep(5 * millisUntilTimeout * time.Millisecond)
-	fmt.Println("Debug: Waking 1")
+	net.pbftEndpoints[1].pbft.Manager.Queue() <- req
+	elapse(5 * millisUntilTimeout * time.Millisecond)
 
 	// This will eventually trigger 3's request timeout, which will lead to a view change to 1.
 	// However, we disable 1, which will disable the new-view going through.
 	// This checks that replicas will automatically move to view 2 when the view change times out.
 	// However, 2 does not know about the missing request, and therefore the request will not be
 	// pre-prepared and finally executed.
-	replica1Disabled = true
-	net.pbftEndpoints[3].pbft.manager.queue() <- req
-	fmt.Println("Debug: Sleeping 2")
-	time.Sleep(5 * millisUntilTimeout * time.Millisecond)
-	fmt.Println("Debug: Waking 2")
+	net.Inject().From(1).Drop()
+	net.pbftEndpoints[3].pbft.Manager.Queue() <- req
+	elapse(5 * millisUntilTimeout * time.Millisecond)
 
 	// So far, we are in view 2, and replica 1 and 3 (who got the request) in view change to view 3.
 	// Submitting the request to 0 will eventually trigger its view-change timeout, which will make
 	// all replicas move to view 3 and finally process the request.
-	net.pbftEndpoints[0].pbft.manager.queue() <- req
-	fmt.Println("Debug: Sleeping 3")
-	time.Sleep(5 * millisUntilTimeout * time.Millisecond)
-	fmt.Println("Debug: Waking 3")
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- req
+	elapse(5 * millisUntilTimeout * time.Millisecond)
 
 	for i, pep := range net.pbftEndpoints {
-		if pep.pbft.view < 3 {
-			t.Errorf("Should have reached view 3, got %d instead for replica %d", pep.pbft.view, i)
+		if pep.pbft.View < 3 {
+			t.Errorf("Should have reached view 3, got %d instead for replica %d", pep.pbft.View, i)
 		}
 		executionsExpected := uint64(1)
 		if pep.sc.executions != executionsExpected {
@@ -723,38 +738,39 @@ func TestViewChangeUpdateSeqNo(t *testing.T) {
 	millisUntilTimeout := 400 * time.Millisecond
 
 	validatorCount := 4
+	config := loadConfig()
 	config.Set("general.timeout.request", "400ms")
 	config.Set("general.timeout.viewchange", "400ms")
 	net := makePBFTNetwork(validatorCount, config)
 	for _, pe := range net.pbftEndpoints {
-		pe.pbft.lastExec = 99
+		pe.pbft.LastExec = 99
 		pe.pbft.h = 99 / pe.pbft.K * pe.pbft.K
 	}
 	net.pbftEndpoints[0].pbft.seqNo = 99
 
-	go net.processContinually()
+	go net.ProcessContinually()
 
 	broadcaster := uint64(generateBroadcaster(validatorCount))
 
 	req := createPbftRequestWithChainTx(1, broadcaster)
-	net.pbftEndpoints[0].pbft.manager.queue() <- req
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- req
 	time.Sleep(5 * millisUntilTimeout)
 	// Now we all have executed seqNo 100.  After triggering a
 	// view change, the new primary should pick up right after
 	// that.
 
-	net.pbftEndpoints[0].pbft.sendViewChange()
-	net.pbftEndpoints[1].pbft.sendViewChange()
+	net.pbftEndpoints[0].pbft.SendViewChange()
+	net.pbftEndpoints[1].pbft.SendViewChange()
 	time.Sleep(5 * millisUntilTimeout)
 
 	req = createPbftRequestWithChainTx(2, broadcaster)
-	net.pbftEndpoints[1].pbft.manager.queue() <- req
+	net.pbftEndpoints[1].pbft.Manager.Queue() <- req
 	time.Sleep(5 * millisUntilTimeout)
 
-	net.stop()
+	net.Stop()
 	for i, pep := range net.pbftEndpoints {
-		if pep.pbft.view < 1 {
-			t.Errorf("Should have reached view 3, got %d instead for replica %d", pep.pbft.view, i)
+		if pep.pbft.View < 1 {
+			t.Errorf("Should have reached view 3, got %d instead for replica %d", pep.pbft.View, i)
 		}
 		executionsExpected := uint64(2)
 		if pep.sc.executions != executionsExpected {
@@ -769,7 +785,7 @@ func TestSendQueueThrottling(t *testing.T) {
 
 	mock := &omniProto{}
 	instance := newPbftCore(0, loadConfig(), mock)
-	instance.f = 1
+	instance.F = 1
 	instance.K = 2
 	instance.L = 4
 	instance.consumer = &omniProto{
@@ -778,7 +794,7 @@ func TestSendQueueThrottling(t *testing.T) {
 			prePreparesSent++
 		},
 	}
-	defer instance.close()
+	defer instance.Close()
 
 	for j := 0; j < 4; j++ {
 		sendEvent(instance, &Request{
@@ -797,10 +813,10 @@ func TestSendQueueThrottling(t *testing.T) {
 func TestWitnessCheckpointOutOfBounds(t *testing.T) {
 	mock := &omniProto{}
 	instance := newPbftCore(1, loadConfig(), mock)
-	instance.f = 1
+	instance.F = 1
 	instance.K = 2
 	instance.L = 4
-	defer instance.close()
+	defer instance.Close()
 
 	sendEvent(instance, &Checkpoint{
 		SequenceNumber: 6,
@@ -818,14 +834,31 @@ func TestWitnessCheckpointOutOfBounds(t *testing.T) {
 	})
 }
 
+func TestRankByCheckpoint(t *testing.T) {
+	instance := newPbftCore(1, loadConfig(), &omniProto{})
+	defer instance.Close()
+
+	instance.checkpointStore[Checkpoint{SequenceNumber: 10, ReplicaId: 0, Id: "a"}] = true
+	instance.checkpointStore[Checkpoint{SequenceNumber: 20, ReplicaId: 0, Id: "b"}] = true
+	instance.checkpointStore[Checkpoint{SequenceNumber: 10, ReplicaId: 2, Id: "a"}] = true
+	instance.checkpointStore[Checkpoint{SequenceNumber: 10, ReplicaId: 3, Id: "a"}] = true
+	instance.hChkpts[3] = 30
+
+	replicas := []uint64{2, 0, 3}
+	instance.rankByCheckpoint(replicas)
+	if !reflect.DeepEqual(replicas, []uint64{3, 0, 2}) {
+		t.Errorf("Expected replicas to be ranked by their highest advertised checkpoint, got %v", replicas)
+	}
+}
+
 // From issue #687
 func TestWitnessFallBehindMissingPrePrepare(t *testing.T) {
 	mock := &omniProto{}
 	instance := newPbftCore(1, loadConfig(), mock)
-	instance.f = 1
+	instance.F = 1
 	instance.K = 2
 	instance.L = 4
-	defer instance.close()
+	defer instance.Close()
 
 	sendEvent(instance, &Commit{
 		SequenceNumber: 2,
@@ -842,8 +875,9 @@ func TestFallBehind(t *testing.T) {
 	config := loadConfig()
 	config.Set("general.K", 2)
 	config.Set("general.logmultiplier", 2)
+	config.Set("general.catchupgap", 0) // fall behind too far to catch up through commit certificates
 	net := makePBFTNetwork(validatorCount, config)
-	defer net.stop()
+	defer net.Stop()
 
 	execReq := func(iter int64, skipThree bool) {
 		// Create a message of type `Message_CHAIN_TRANSACTION`
@@ -856,11 +890,11 @@ func TestFallBehind(t *testing.T) {
 
 		msg := &Message{&Message_Request{&Request{Payload: txPacked, ReplicaId: uint64(generateBroadcaster(validatorCount))}}}
 
-		net.pbftEndpoints[0].pbft.manager.queue() <- pbftMessageEvent{msg: msg, sender: msg.GetRequest().ReplicaId}
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- pbftMessageEvent{msg: msg, sender: msg.GetRequest().ReplicaId}
 
 		if skipThree {
 			// Send the request for consensus to everone but replica 3
-			net.filterFn = func(src, replica int, msg []byte) []byte {
+			net.FilterFn = func(src, replica int, msg []byte) []byte {
 				if src != -1 && replica == 3 {
 					return nil
 				}
@@ -869,9 +903,9 @@ func TestFallBehind(t *testing.T) {
 			}
 		} else {
 			// Send the request for consensus to everone
-			net.filterFn = nil
+			net.FilterFn = nil
 		}
-		err = net.process()
+		err = net.Process()
 
 		if err != nil {
 			t.Fatalf("Processing failed: %s", err)
@@ -886,7 +920,7 @@ func TestFallBehind(t *testing.T) {
 		execReq(request, false)
 	}
 
-	if !pbft.skipInProgress {
+	if !pbft.SkipInProgress {
 		t.Fatalf("Replica did not detect that it has fallen behind.")
 	}
 
@@ -921,31 +955,31 @@ func TestFallBehind(t *testing.T) {
 
 func TestPbftF0(t *testing.T) {
 	net := makePBFTNetwork(1, nil)
-	defer net.stop()
+	defer net.Stop()
 
 	req := createPbftRequestWithChainTx(1, 0)
 
 	pep0 := net.pbftEndpoints[0]
 
-	pep0.pbft.manager.queue() <- req
+	pep0.pbft.Manager.Queue() <- req
 
-	err := net.process()
+	err := net.Process()
 	if err != nil {
 		t.Fatalf("Processing failed: %s", err)
 	}
 
 	for _, pep := range net.pbftEndpoints {
 		if pep.sc.executions < 1 {
-			t.Errorf("Instance %d did not execute transaction", pep.id)
+			t.Errorf("Instance %d did not execute transaction", pep.ID)
 			continue
 		}
 		if pep.sc.executions >= 2 {
-			t.Errorf("Instance %d executed more than one transaction", pep.id)
+			t.Errorf("Instance %d executed more than one transaction", pep.ID)
 			continue
 		}
 		if !reflect.DeepEqual(pep.sc.lastExecution, req.Payload) {
 			t.Errorf("Instance %d executed wrong transaction, %x should be %x",
-				pep.id, pep.sc.lastExecution, req.Payload)
+				pep.ID, pep.sc.lastExecution, req.Payload)
 		}
 	}
 }
@@ -961,13 +995,13 @@ func TestRequestTimerDuringViewChange(t *testing.T) {
 		},
 	}
 	instance := newPbftCore(1, loadConfig(), mock)
-	instance.manager.start()
-	instance.f = 1
+	instance.Manager.Start()
+	instance.F = 1
 	instance.K = 2
 	instance.L = 4
-	instance.requestTimeout = time.Millisecond
-	instance.activeView = false
-	defer instance.close()
+	instance.RequestTimeout = time.Millisecond
+	instance.ActiveView = false
+	defer instance.Close()
 
 	txTime := &gp.Timestamp{Seconds: 1, Nanos: 0}
 	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY, Timestamp: txTime}
@@ -979,7 +1013,7 @@ func TestRequestTimerDuringViewChange(t *testing.T) {
 		ReplicaId: 1, // Not the primary
 	}
 
-	instance.manager.queue() <- req
+	instance.Manager.Queue() <- req
 
 	time.Sleep(100 * time.Millisecond)
 }
@@ -994,7 +1028,7 @@ func TestReplicaCrash1(t *testing.T) {
 	config.Set("general.K", 2)
 	config.Set("general.logmultiplier", 2)
 	net := makePBFTNetwork(validatorCount, config)
-	defer net.stop()
+	defer net.Stop()
 
 	mkreq := func(n int64) *Request {
 		txTime := &gp.Timestamp{Seconds: n, Nanos: 0}
@@ -1008,31 +1042,31 @@ func TestReplicaCrash1(t *testing.T) {
 		}
 	}
 
-	net.pbftEndpoints[0].pbft.manager.queue() <- mkreq(1)
-	net.process()
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- mkreq(1)
+	net.Process()
 
 	for id := 0; id < 2; id++ {
 		pe := net.pbftEndpoints[id]
 		pe.pbft = newPbftCore(uint64(id), loadConfig(), pe.sc)
-		pe.pbft.manager.start()
+		pe.pbft.Manager.Start()
 		pe.pbft.N = 4
-		pe.pbft.f = (4 - 1) / 3
+		pe.pbft.F = (4 - 1) / 3
 		pe.pbft.K = 2
 		pe.pbft.L = 2 * pe.pbft.K
 	}
 
-	net.pbftEndpoints[0].pbft.manager.queue() <- mkreq(2)
-	net.pbftEndpoints[0].pbft.manager.queue() <- (mkreq(3))
-	net.process()
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- mkreq(2)
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- (mkreq(3))
+	net.Process()
 
 	for _, pep := range net.pbftEndpoints {
 		if pep.sc.executions != 3 {
-			t.Errorf("Expected 3 executions on replica %d, got %d", pep.id, pep.sc.executions)
+			t.Errorf("Expected 3 executions on replica %d, got %d", pep.ID, pep.sc.executions)
 			continue
 		}
 
-		if pep.pbft.view != 0 {
-			t.Errorf("Replica %d should still be in view 0, is %v %d", pep.id, pep.pbft.activeView, pep.pbft.view)
+		if pep.pbft.View != 0 {
+			t.Errorf("Replica %d should still be in view 0, is %v %d", pep.ID, pep.pbft.ActiveView, pep.pbft.View)
 		}
 	}
 }
@@ -1049,26 +1083,11 @@ func TestReplicaCrash2(t *testing.T) {
 	config.Set("general.K", 2)
 	config.Set("general.logmultiplier", 2)
 	net := makePBFTNetwork(validatorCount, config)
-	defer net.stop()
+	defer net.Stop()
 
-	filterMsg := true
-	net.filterFn = func(src int, dst int, msg []byte) []byte {
-		if dst == 3 { // 3 is byz
-			return nil
-		}
-		pm := &Message{}
-		err := proto.Unmarshal(msg, pm)
-		if err != nil {
-			t.Fatal(err)
-		}
-		// filter commits to all but 1
-		commit := pm.GetCommit()
-		if filterMsg && dst != -1 && dst != 1 && commit != nil && commit.View < 2 {
-			logger.Info("filtering commit message from %d to %d", src, dst)
-			return nil
-		}
-		return msg
-	}
+	// 3 is byz, and commits of the first views only reach 1
+	net.Inject().To(3).Drop()
+	commits := net.Inject().To(0, 2).Type("commit").Views(0, 1).Drop()
 
 	mkreq := func(n int64) *Request {
 		txTime := &gp.Timestamp{Seconds: n, Nanos: 0}
@@ -1082,24 +1101,24 @@ func TestReplicaCrash2(t *testing.T) {
 		}
 	}
 
-	net.pbftEndpoints[0].pbft.manager.queue() <- (mkreq(1))
-	net.process()
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- (mkreq(1))
+	net.Process()
 
 	logger.Info("stopping filtering")
-	filterMsg = false
-	primary := net.pbftEndpoints[0].pbft.primary(net.pbftEndpoints[0].pbft.view)
-	net.pbftEndpoints[primary].pbft.manager.queue() <- (mkreq(2))
-	net.pbftEndpoints[primary].pbft.manager.queue() <- (mkreq(3))
-	net.pbftEndpoints[primary].pbft.manager.queue() <- (mkreq(4))
-	go net.processContinually()
+	commits.Remove()
+	primary := net.pbftEndpoints[0].pbft.Primary(net.pbftEndpoints[0].pbft.View)
+	net.pbftEndpoints[primary].pbft.Manager.Queue() <- (mkreq(2))
+	net.pbftEndpoints[primary].pbft.Manager.Queue() <- (mkreq(3))
+	net.pbftEndpoints[primary].pbft.Manager.Queue() <- (mkreq(4))
+	go net.ProcessContinually()
 	time.Sleep(5 * time.Second)
 
 	for _, pep := range net.pbftEndpoints {
-		if pep.id != 3 && pep.sc.executions != 4 {
-			t.Errorf("Expected 4 executions on replica %d, got %d", pep.id, pep.sc.executions)
+		if pep.ID != 3 && pep.sc.executions != 4 {
+			t.Errorf("Expected 4 executions on replica %d, got %d", pep.ID, pep.sc.executions)
 			continue
 		}
-		if pep.id == 3 && pep.sc.executions > 0 {
+		if pep.ID == 3 && pep.sc.executions > 0 {
 			t.Errorf("Expected no execution")
 			continue
 		}
@@ -1117,11 +1136,11 @@ func TestReplicaCrash3(t *testing.T) {
 	config.Set("general.K", 2)
 	config.Set("general.logmultiplier", 2)
 	net := makePBFTNetwork(validatorCount, config)
-	defer net.stop()
+	defer net.Stop()
 
 	twoOffline := false
 	threeOffline := true
-	net.filterFn = func(src int, dst int, msg []byte) []byte {
+	net.FilterFn = func(src int, dst int, msg []byte) []byte {
 		if twoOffline && dst == 2 { // 2 is 'offline'
 			return nil
 		}
@@ -1144,9 +1163,9 @@ func TestReplicaCrash3(t *testing.T) {
 	}
 
 	for i := int64(1); i <= 8; i++ {
-		net.pbftEndpoints[0].pbft.manager.queue() <- (mkreq(i))
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- (mkreq(i))
 	}
-	net.process() // vp0,1,2 should have a stable checkpoint for seqNo 8
+	net.Process() // vp0,1,2 should have a stable checkpoint for seqNo 8
 
 	// Create new pbft instances to restore from persistence
 	for id := 0; id < 2; id++ {
@@ -1154,10 +1173,10 @@ func TestReplicaCrash3(t *testing.T) {
 		config := loadConfig()
 		config.Set("general.K", "2")
 		pe.pbft = newPbftCore(uint64(id), config, pe.sc)
-		pe.pbft.manager.start()
+		pe.pbft.Manager.Start()
 		pe.pbft.N = 4
-		pe.pbft.f = (4 - 1) / 3
-		pe.pbft.requestTimeout = 200 * time.Millisecond
+		pe.pbft.F = (4 - 1) / 3
+		pe.pbft.RequestTimeout = 200 * time.Millisecond
 	}
 
 	threeOffline = false
@@ -1165,31 +1184,31 @@ func TestReplicaCrash3(t *testing.T) {
 
 	// Because vp2 is 'offline', and vp3 is still at the genesis block, the network needs to make a view change
 
-	net.pbftEndpoints[0].pbft.manager.queue() <- (mkreq(9))
-	net.process()
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- (mkreq(9))
+	net.Process()
 
 	// Now vp0,1,3 should be in sync with 9 executions in view 1, and vp2 should be at 8 executions in view 0
 	for i, pep := range net.pbftEndpoints {
 
 		if i == 2 {
 			// 2 is 'offline'
-			if pep.pbft.view != 0 {
-				t.Errorf("Expected replica %d to be in view 0, got %d", pep.id, pep.pbft.view)
+			if pep.pbft.View != 0 {
+				t.Errorf("Expected replica %d to be in view 0, got %d", pep.ID, pep.pbft.View)
 			}
 			expectedExecutions := uint64(8)
 			if pep.sc.executions != expectedExecutions {
-				t.Errorf("Expected %d executions on replica %d, got %d", expectedExecutions, pep.id, pep.sc.executions)
+				t.Errorf("Expected %d executions on replica %d, got %d", expectedExecutions, pep.ID, pep.sc.executions)
 			}
 			continue
 		}
 
-		if pep.pbft.view != 1 {
-			t.Errorf("Expected replica %d to be in view 1, got %d", pep.id, pep.pbft.view)
+		if pep.pbft.View != 1 {
+			t.Errorf("Expected replica %d to be in view 1, got %d", pep.ID, pep.pbft.View)
 		}
 
 		expectedExecutions := uint64(9)
 		if pep.sc.executions != expectedExecutions {
-			t.Errorf("Expected %d executions on replica %d, got %d", expectedExecutions, pep.id, pep.sc.executions)
+			t.Errorf("Expected %d executions on replica %d, got %d", expectedExecutions, pep.ID, pep.sc.executions)
 		}
 	}
 }
@@ -1235,14 +1254,14 @@ func TestReplicaPersistQSet(t *testing.T) {
 	sendEvent(p, &PrePrepare{
 		View:           0,
 		SequenceNumber: 1,
-		RequestDigest:  hashReq(req),
+		RequestDigest:  HashReq(shake256Digest{}, req),
 		Request:        req,
 		ReplicaId:      uint64(0),
 	})
-	p.close()
+	p.Close()
 
 	p = newPbftCore(1, loadConfig(), stack)
-	if !p.prePrepared(hashReq(req), 0, 1) {
+	if !p.prePrepared(HashReq(shake256Digest{}, req), 0, 1) {
 		t.Errorf("did not restore qset properly")
 	}
 }
@@ -1258,22 +1277,46 @@ func TestReplicaPersistDelete(t *testing.T) {
 		DelStateImpl: func(key string) {
 			delete(persist, key)
 		},
+		ReadStateImpl: func(key string) ([]byte, error) {
+			return persist[key], nil
+		},
+		ReadStateSetImpl: func(prefix string) (map[string][]byte, error) {
+			r := make(map[string][]byte)
+			for k, v := range persist {
+				if strings.HasPrefix(k, prefix) {
+					r[k] = v
+				}
+			}
+			return r, nil
+		},
 	}
 	p := newPbftCore(1, loadConfig(), stack)
 	p.reqStore["a"] = &Request{}
 	p.persistRequest("a")
-	if len(persist) != 1 {
+	if records, _ := stack.ReadStateSet(walPrefix); len(records) != 1 {
 		t.Error("expected one persisted entry")
 	}
-	p.persistDelRequest("a")
-	if len(persist) != 0 {
-		t.Error("expected no persisted entry")
+	delete(p.reqStore, "a")
+	p.moveWatermarks(p.K)
+	records, _ := stack.ReadStateSet(walPrefix)
+	for k, v := range records {
+		raw, err := openWALRecord(v)
+		if err != nil {
+			t.Fatalf("could not verify persisted entry %s: %s", k, err)
+		}
+		msg := &Message{}
+		if err := proto.Unmarshal(raw, msg); err != nil {
+			t.Fatalf("could not unmarshal persisted entry %s: %s", k, err)
+		}
+		if msg.GetRequest() != nil {
+			t.Errorf("expected no persisted request, found %s", k)
+		}
 	}
 }
 
 func TestNilCurrentExec(t *testing.T) {
 	p := newPbftCore(1, loadConfig(), &omniProto{})
-	p.execDoneSync() // Per issue 1538, this would cause a Nil pointer dereference
+	p.ExecDoneSync(nil) // Per issue 1538, this would cause a Nil pointer dereference
 }
 
 func TestNetworkNullRequests(t *testing.T) {
@@ -1282,23 +1325,56 @@ func TestNetworkNullRequests(t *testing.T) {
 	config.Set("general.timeout.nullrequest", "200ms")
 	config.Set("general.timeout.request", "500ms")
 	net := makePBFTNetwork(validatorCount, config)
-	defer net.stop()
+	defer net.Stop()
 
 	msg := createPbftRequestWithChainTx(1, 0)
-	net.pbftEndpoints[0].pbft.manager.queue() <- msg
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- msg
 
-	go net.processContinually()
+	go net.ProcessContinually()
 	time.Sleep(2 * time.Second)
 
 	for _, pep := range net.pbftEndpoints {
 		if pep.sc.executions != 1 {
-			t.Errorf("Instance %d executed incorrect number of transactions: %d", pep.id, pep.sc.executions)
+			t.Errorf("Instance %d executed incorrect number of transactions: %d", pep.ID, pep.sc.executions)
+		}
+		if pep.pbft.LastExec <= 1 {
+			t.Errorf("Instance %d: no null requests processed", pep.ID)
+		}
+		if pep.pbft.View != 0 {
+			t.Errorf("Instance %d: expected view=0", pep.ID)
+		}
+	}
+}
+
+// TestNetworkNullRequestsWAN checks that null requests keep a network with
+// the latency and bandwidth of a wide area network from changing views
+func TestNetworkNullRequestsWAN(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.timeout.nullrequest", "200ms")
+	config.Set("general.timeout.request", "500ms")
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.Stop()
+	net.SetNetworkModel(testkit.LinkModel{
+		Latency:   testkit.Normal(10*time.Millisecond, 3*time.Millisecond),
+		Bandwidth: 1 << 20,
+	})
+
+	msg := createPbftRequestWithChainTx(1, 0)
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- msg
+
+	go net.ProcessContinually()
+	time.Sleep(3 * time.Second)
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 1 {
+			t.Errorf("Instance %d executed incorrect number of transactions: %d", pep.ID, pep.sc.executions)
 		}
-		if pep.pbft.lastExec <= 1 {
-			t.Errorf("Instance %d: no null requests processed", pep.id)
+		if pep.pbft.LastExec <= 1 {
+			t.Errorf("Instance %d: no null requests processed", pep.ID)
 		}
-		if pep.pbft.view != 0 {
-			t.Errorf("Instance %d: expected view=0", pep.id)
+		if pep.pbft.View != 0 {
+			t.Errorf("Instance %d: expected view=0, got %d", pep.ID, pep.pbft.View)
 		}
 	}
 }
@@ -1309,25 +1385,25 @@ func TestNetworkNullRequestMissing(t *testing.T) {
 	config.Set("general.timeout.nullrequest", "200ms")
 	config.Set("general.timeout.request", "500ms")
 	net := makePBFTNetwork(validatorCount, config)
-	defer net.stop()
+	defer net.Stop()
 
 	net.pbftEndpoints[0].pbft.nullRequestTimeout = 0
 
 	msg := createPbftRequestWithChainTx(1, 0)
-	net.pbftEndpoints[0].pbft.manager.queue() <- msg
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- msg
 
-	go net.processContinually()
+	go net.ProcessContinually()
 	time.Sleep(3 * time.Second) // Bumped from 2 to 3 seconds because of sporadic CI failures
 
 	for _, pep := range net.pbftEndpoints {
 		if pep.sc.executions != 1 {
-			t.Errorf("Instance %d executed incorrect number of transactions: %d", pep.id, pep.sc.executions)
+			t.Errorf("Instance %d executed incorrect number of transactions: %d", pep.ID, pep.sc.executions)
 		}
-		if pep.pbft.lastExec <= 1 {
-			t.Errorf("Instance %d: no null requests processed", pep.id)
+		if pep.pbft.LastExec <= 1 {
+			t.Errorf("Instance %d: no null requests processed", pep.ID)
 		}
-		if pep.pbft.view != 1 {
-			t.Errorf("Instance %d: expected view=1", pep.id)
+		if pep.pbft.View != 1 {
+			t.Errorf("Instance %d: expected view=1", pep.ID)
 		}
 	}
 }
@@ -1340,22 +1416,22 @@ func TestNetworkPeriodicViewChange(t *testing.T) {
 	config.Set("general.timeout.request", "500ms")
 	config.Set("general.viewchangeperiod", "1")
 	net := makePBFTNetwork(validatorCount, config)
-	defer net.stop()
+	defer net.Stop()
 
 	for n := 1; n < 6; n++ {
 		msg := createPbftRequestWithChainTx(int64(n), 0)
 		for _, pe := range net.pbftEndpoints {
-			pe.pbft.manager.queue() <- msg
+			pe.pbft.Manager.Queue() <- msg
 		}
-		net.process()
+		net.Process()
 	}
 
 	for _, pep := range net.pbftEndpoints {
 		if pep.sc.executions != 5 {
-			t.Errorf("Instance %d executed incorrect number of transactions: %d", pep.id, pep.sc.executions)
+			t.Errorf("Instance %d executed incorrect number of transactions: %d", pep.ID, pep.sc.executions)
 		}
-		if pep.pbft.view != 2 {
-			t.Errorf("Instance %d: expected view=2", pep.id)
+		if pep.pbft.View != 2 {
+			t.Errorf("Instance %d: expected view=2", pep.ID)
 		}
 	}
 }
@@ -1368,7 +1444,7 @@ func TestNetworkPeriodicViewChangeMissing(t *testing.T) {
 	config.Set("general.timeout.request", "500ms")
 	config.Set("general.viewchangeperiod", "1")
 	net := makePBFTNetwork(validatorCount, config)
-	defer net.stop()
+	defer net.Stop()
 
 	net.pbftEndpoints[0].pbft.viewChangePeriod = 0
 	net.pbftEndpoints[0].pbft.viewChangeSeqNo = ^uint64(0)
@@ -1376,17 +1452,82 @@ func TestNetworkPeriodicViewChangeMissing(t *testing.T) {
 	for n := 1; n < 3; n++ {
 		msg := createPbftRequestWithChainTx(int64(n), 0)
 		for _, pe := range net.pbftEndpoints {
-			pe.pbft.manager.queue() <- msg
+			pe.pbft.Manager.Queue() <- msg
 		}
-		net.process()
+		net.Process()
 	}
 
 	for _, pep := range net.pbftEndpoints {
 		if pep.sc.executions != 2 {
-			t.Errorf("Instance %d executed incorrect number of transactions: %d", pep.id, pep.sc.executions)
+			t.Errorf("Instance %d executed incorrect number of transactions: %d", pep.ID, pep.sc.executions)
+		}
+		if pep.pbft.View != 1 {
+			t.Errorf("Instance %d: expected view=1", pep.ID)
+		}
+	}
+}
+
+func TestCatchUpThroughCommitCerts(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.Stop()
+
+	pep := net.pbftEndpoints[3]
+	pbft := pep.pbft
+
+	// Replica 3 misses everything about seqNo 1
+	net.Split(map[string][]int{"majority": {0, 1, 2}, "cutoff": {3}})
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	net.Heal()
+
+	for request := int64(2); uint64(request) <= pbft.L+pbft.K; request++ {
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(request, uint64(generateBroadcaster(validatorCount)))
+		if err := net.Process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
 		}
-		if pep.pbft.view != 1 {
-			t.Errorf("Instance %d: expected view=1", pep.id)
+	}
+
+	if pep.sc.skipOccurred {
+		t.Fatalf("Expected replica 3 to catch up without state transfer")
+	}
+	if pep.sc.executions != pbft.L+pbft.K {
+		t.Fatalf("Expected replica 3 to execute %d requests, executed %d", pbft.L+pbft.K, pep.sc.executions)
+	}
+	if pbft.catchup != nil {
+		t.Errorf("Expected the catch up to be complete")
+	}
+	if v := pbft.Metrics.catchUps.Value(); v == 0 {
+		t.Errorf("Expected the catch up to be counted")
+	}
+}
+
+func TestRequestViewChange(t *testing.T) {
+	net := makePBFTNetwork(4, nil)
+	defer net.Stop()
+
+	// the primary of view 0 is taken down for maintenance
+	if err := net.pbftEndpoints[1].pbft.RequestViewChange(); err != nil {
+		t.Fatalf("Could not request a view change: %s", err)
+	}
+	if err := net.pbftEndpoints[1].pbft.RequestViewChange(); err == nil {
+		t.Errorf("Expected an error requesting a view change during a view change")
+	}
+	if err := net.pbftEndpoints[2].pbft.RequestViewChange(); err != nil {
+		t.Fatalf("Could not request a view change: %s", err)
+	}
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.pbft.View != 1 || !pep.pbft.ActiveView {
+			t.Errorf("Replica %d should be in active view 1, is in view %d (active: %t)", pep.pbft.ID, pep.pbft.View, pep.pbft.ActiveView)
 		}
 	}
 }
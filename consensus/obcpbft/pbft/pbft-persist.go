@@ -0,0 +1,242 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+func (instance *PbftCore) persistMessage(msg *Message) {
+	instance.persistMessages(msg)
+}
+
+// persistMessages appends msgs to the WAL in a single atomic write, so
+// that a certificate is either persisted as a whole or not at all
+func (instance *PbftCore) persistMessages(msgs ...*Message) {
+	if err := instance.wal.append(msgs...); err != nil {
+		instance.log.Warning("Could not persist messages: %s", err)
+	}
+}
+
+func (instance *PbftCore) persistRequest(digest string) {
+	instance.persistMessage(&Message{&Message_Request{instance.reqStore[digest]}})
+}
+
+func (instance *PbftCore) persistPrePrepare(preprep *PrePrepare) {
+	instance.persistMessage(&Message{&Message_PrePrepare{preprep}})
+}
+
+func (instance *PbftCore) persistPrepare(prep *Prepare) {
+	instance.persistMessage(&Message{&Message_Prepare{prep}})
+}
+
+func (instance *PbftCore) persistCommit(commit *Commit) {
+	instance.persistMessage(&Message{&Message_Commit{commit}})
+}
+
+func (instance *PbftCore) persistCheckpoint(chkpt *Checkpoint) {
+	instance.persistMessage(&Message{&Message_Checkpoint{chkpt}})
+}
+
+func (instance *PbftCore) persistViewChange(vc *ViewChange) {
+	instance.persistMessage(&Message{&Message_ViewChange{vc}})
+}
+
+// persistTruncate drops the records below the low watermark h from the WAL,
+// the stored requests and the view change state survive the truncation
+func (instance *PbftCore) persistTruncate(h uint64) {
+	carry := func() []*Message {
+		return instance.walCarry(h)
+	}
+	if err := instance.wal.truncate(h, carry); err != nil {
+		instance.log.Warning("Could not truncate WAL: %s", err)
+	}
+}
+
+// walCarry returns the records which have to survive the truncation of the
+// WAL below h: the stored requests and the view change state
+func (instance *PbftCore) walCarry(h uint64) []*Message {
+	var msgs []*Message
+	for _, req := range instance.reqStore {
+		msgs = append(msgs, &Message{&Message_Request{req}})
+	}
+	vc := &ViewChange{
+		View:      instance.View,
+		H:         h,
+		ReplicaId: instance.ID,
+		Epoch:     instance.epoch,
+	}
+	for _, p := range instance.pset {
+		vc.Pset = append(vc.Pset, p)
+	}
+	for _, q := range instance.qset {
+		vc.Qset = append(vc.Qset, q)
+	}
+	return append(msgs, &Message{&Message_ViewChange{vc}})
+}
+
+// restoreState rebuilds the message log by replaying the WAL. If the WAL
+// is corrupt, the damaged records are quarantined, and the replica starts
+// with an empty message log rather than from a partial one, which might
+// hold a wrong pset or qset, and rebuilds it from the stable checkpoint of
+// the network, transferring state if it fell behind
+func (instance *PbftCore) restoreState() {
+	instance.restoreEpoch()
+	msgs, err := instance.wal.replay()
+	if corrupt, ok := err.(walCorruption); ok {
+		instance.log.Error("Found its persisted message log corrupt, discarding it and rebuilding it from the network: %s", corrupt)
+		if err := instance.wal.quarantine(corrupt); err != nil {
+			instance.log.Error("Could not quarantine its corrupt message log: %s", err)
+		}
+		msgs = nil
+		instance.rebuilding = true
+	} else if err != nil {
+		instance.log.Warning("Could not restore state: %s", err)
+	}
+	for _, msg := range msgs {
+		instance.restoreMessage(msg)
+	}
+
+	highSeq := uint64(0)
+	for seqNo := range instance.chkpts {
+		if seqNo > highSeq {
+			highSeq = seqNo
+		}
+	}
+	instance.moveWatermarks(highSeq)
+
+	instance.restoreLastSeqNo()
+	instance.restoreOutstandingReqs()
+
+	instance.log.Info("Restored state: view: %d, seqNo: %d, certs: %d, pset: %d, qset: %d, reqs: %d, outstanding reqs: %d, chkpts: %d",
+		instance.View, instance.seqNo, len(instance.certStore), len(instance.pset), len(instance.qset), len(instance.reqStore), len(instance.OutstandingReqs), len(instance.chkpts))
+}
+
+// restoreOutstandingReqs marks the restored requests which were neither
+// executed nor committed as outstanding, so that they are proposed again
+// instead of being lost with the restart
+func (instance *PbftCore) restoreOutstandingReqs() {
+	done := make(map[string]bool)
+	for idx, cert := range instance.certStore {
+		if idx.n <= instance.LastExec || instance.committed(cert.digest, idx.v, idx.n) {
+			done[cert.digest] = true
+		}
+	}
+	for n, p := range instance.pset {
+		if n <= instance.LastExec {
+			done[p.Digest] = true
+		}
+	}
+	for idx := range instance.qset {
+		if idx.n <= instance.LastExec {
+			done[idx.d] = true
+		}
+	}
+	for digest, req := range instance.reqStore {
+		if !done[digest] {
+			instance.OutstandingReqs[digest] = req
+		}
+	}
+}
+
+// resumeOutstandingReqs proposes the restored outstanding requests if we are
+// the primary, and otherwise waits for the primary to propose them
+func (instance *PbftCore) resumeOutstandingReqs() {
+	if len(instance.OutstandingReqs) == 0 {
+		return
+	}
+	instance.log.Info("Resuming %d restored outstanding requests", len(instance.OutstandingReqs))
+	instance.resubmitRequests()
+	if instance.ActiveView {
+		instance.startTimerIfOutstandingRequests()
+	}
+}
+
+// restoreMessage applies a single WAL record to the message log, in the
+// same way the record was applied when it was appended
+func (instance *PbftCore) restoreMessage(msg *Message) {
+	updateSeqView := func(v uint64, n uint64) {
+		if instance.View < v {
+			instance.View = v
+		}
+		if instance.seqNo < n {
+			instance.seqNo = n
+		}
+	}
+
+	switch x := msg.Payload.(type) {
+	case *Message_Request:
+		instance.reqStore[HashReq(instance.Digest, x.Request)] = x.Request
+	case *Message_PrePrepare:
+		preprep := x.PrePrepare
+		cert := instance.getCert(preprep.View, preprep.SequenceNumber)
+		cert.prePrepare = preprep
+		cert.digest = preprep.RequestDigest
+		if preprep.Request != nil {
+			instance.reqStore[preprep.RequestDigest] = preprep.Request
+		}
+		updateSeqView(preprep.View, preprep.SequenceNumber)
+	case *Message_Prepare:
+		prep := x.Prepare
+		cert := instance.getCert(prep.View, prep.SequenceNumber)
+		cert.prepare = append(cert.prepare, prep)
+		if prep.ReplicaId == instance.ID {
+			cert.sentPrepare = true
+		}
+	case *Message_Commit:
+		commit := x.Commit
+		cert := instance.getCert(commit.View, commit.SequenceNumber)
+		cert.commit = append(cert.commit, commit)
+		if commit.ReplicaId == instance.ID {
+			cert.sentCommit = true
+		}
+	case *Message_Checkpoint:
+		instance.chkpts[x.Checkpoint.SequenceNumber] = x.Checkpoint.Id
+	case *Message_ViewChange:
+		vc := x.ViewChange
+		instance.pset = make(map[uint64]*ViewChange_PQ)
+		for _, p := range vc.Pset {
+			instance.pset[p.SequenceNumber] = p
+			updateSeqView(p.View, p.SequenceNumber)
+		}
+		instance.qset = make(map[qidx]*ViewChange_PQ)
+		for _, q := range vc.Qset {
+			instance.qset[qidx{q.Digest, q.SequenceNumber}] = q
+			updateSeqView(q.View, q.SequenceNumber)
+		}
+		updateSeqView(vc.View, 0)
+		for idx := range instance.certStore {
+			if idx.v < vc.View {
+				delete(instance.certStore, idx)
+			}
+		}
+	default:
+		instance.log.Warning("Ignoring unexpected WAL record %v", msg)
+	}
+}
+
+func (instance *PbftCore) restoreLastSeqNo() {
+	var err error
+	if instance.LastExec, err = instance.consumer.GetLastSeqNo(); err != nil {
+		instance.log.Warning("Could not restore lastExec: %s", err)
+		instance.LastExec = 0
+	}
+	if reporter, ok := instance.consumer.(epochReporter); ok && instance.LastExec > 0 {
+		if epoch, err := reporter.GetLastEpoch(); err == nil && epoch < instance.epoch {
+			// we entered the epoch at its checkpoint 0, but executed nothing in it yet
+			instance.log.Info("Last execution %d was in epoch %d, nothing executed in epoch %d yet", instance.LastExec, epoch, instance.epoch)
+			instance.LastExec = 0
+		}
+	}
+	instance.log.Info("Restored lastExec: %d", instance.LastExec)
+}
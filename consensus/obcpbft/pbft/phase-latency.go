@@ -0,0 +1,113 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/metrics"
+)
+
+// phaseLatency observes how long requests take through each phase of the
+// normal case on the replica, so that operators can tell what slows the
+// replicas down: a slow request phase points at the primary, which takes
+// long to pre-prepare the requests it received, a slow prepare phase at the
+// network, a slow commit phase at the slowest replicas of the quorum, and a
+// slow execute phase at the executor. Requests the replica only learns of
+// through their pre-prepare are not observed in the request phase, and null
+// requests only in the prepare and commit phases
+type phaseLatency struct {
+	clock    Clock
+	metrics  *pbftMetrics
+	arrivals map[string]time.Time // digest -> arrival of requests not yet pre-prepared
+}
+
+// phaseTimes are the times a certificate completed the phases, zero for
+// the phases it has not completed yet
+type phaseTimes struct {
+	prePrepared time.Time
+	prepared    time.Time
+	committed   time.Time
+}
+
+func newPhaseLatency(clk Clock, m *pbftMetrics) *phaseLatency {
+	return &phaseLatency{clock: clk, metrics: m, arrivals: make(map[string]time.Time)}
+}
+
+// arrived records the arrival of request digest, only its first arrival counts
+func (pl *phaseLatency) arrived(digest string) {
+	if _, ok := pl.arrivals[digest]; !ok {
+		pl.arrivals[digest] = pl.clock.Now()
+	}
+}
+
+// forget discards the arrival of request digest, e.g. once it committed
+// through a pre-prepare the replica did not observe
+func (pl *phaseLatency) forget(digest string) {
+	delete(pl.arrivals, digest)
+}
+
+// Reset forgets the arrivals of all requests, along with the outstanding
+// requests of the replica
+func (pl *phaseLatency) Reset() {
+	pl.arrivals = make(map[string]time.Time)
+}
+
+// prePrepared observes the request phase of the request of cert
+func (pl *phaseLatency) prePrepared(cert *msgCert) {
+	if !cert.phases.prePrepared.IsZero() {
+		return
+	}
+	now := pl.clock.Now()
+	cert.phases.prePrepared = now
+	if arrival, ok := pl.arrivals[cert.digest]; ok && cert.digest != "" {
+		delete(pl.arrivals, cert.digest)
+		pl.metrics.requestPhase.Observe(now.Sub(arrival).Seconds())
+	}
+}
+
+// prepared observes the prepare phase of the request of cert
+func (pl *phaseLatency) prepared(cert *msgCert) {
+	pl.complete(&cert.phases.prepared, cert.phases.prePrepared, pl.metrics.preparePhase)
+}
+
+// committed observes the commit phase of the request of cert
+func (pl *phaseLatency) committed(cert *msgCert) {
+	pl.complete(&cert.phases.committed, cert.phases.prepared, pl.metrics.commitPhase)
+}
+
+// executed observes the execute phase of the request of cert
+func (pl *phaseLatency) executed(cert *msgCert) {
+	if cert.digest == "" {
+		return
+	}
+	var done time.Time
+	pl.complete(&done, cert.phases.committed, pl.metrics.executePhase)
+}
+
+// complete sets *at to now unless it is set already, and observes the time
+// since the previous phase completed, unless the replica did not see it
+// complete, e.g. as it restored the certificate after a restart
+func (pl *phaseLatency) complete(at *time.Time, previous time.Time, h *metrics.Histogram) {
+	if !at.IsZero() {
+		return
+	}
+	*at = pl.clock.Now()
+	if !previous.IsZero() {
+		h.Observe(at.Sub(previous).Seconds())
+	}
+}
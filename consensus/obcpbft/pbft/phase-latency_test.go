@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/metrics"
+)
+
+func TestPhaseLatency(t *testing.T) {
+	clk := newVirtualClock(time.Unix(0, 0))
+	m := newPbftMetrics(metrics.DefaultRegistry, 9, "")
+	pl := newPhaseLatency(clk, m)
+	cert := &msgCert{digest: "foo"}
+
+	pl.arrived("foo")
+	clk.advance(time.Second)
+	pl.arrived("foo") // only the first arrival counts
+	clk.advance(time.Second)
+	pl.prePrepared(cert)
+	clk.advance(3 * time.Second)
+	pl.prepared(cert)
+	clk.advance(4 * time.Second)
+	pl.committed(cert)
+	pl.committed(cert) // every further commit completes the certificate again
+	clk.advance(5 * time.Second)
+	pl.executed(cert)
+
+	for _, tc := range []struct {
+		phase    string
+		h        *metrics.Histogram
+		observed float64
+	}{{"request", m.requestPhase, 2}, {"prepare", m.preparePhase, 3}, {"commit", m.commitPhase, 4}, {"execute", m.executePhase, 5}} {
+		if sum := tc.h.Sum(); sum != tc.observed {
+			t.Errorf("Expected the %s phase to take %vs, observed %vs", tc.phase, tc.observed, sum)
+		}
+	}
+	if count := m.commitPhase.Count(); count != 1 {
+		t.Errorf("Expected the commit phase to be observed once, got %d", count)
+	}
+	if len(pl.arrivals) != 0 {
+		t.Errorf("Expected the arrival to be discarded once the request was pre-prepared, got %v", pl.arrivals)
+	}
+
+	null := &msgCert{}
+	pl.prePrepared(null)
+	pl.prepared(null)
+	pl.committed(null)
+	pl.executed(null)
+	if count := m.executePhase.Count(); count != 1 {
+		t.Errorf("Expected the execution of null requests not to be observed, got %d executions", count)
+	}
+}
+
+func TestPhaseLatencyNetwork(t *testing.T) {
+	net := makePBFTNetwork(4, nil)
+	defer net.Stop()
+
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(1, 0)
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		m := pep.pbft.Metrics
+		requests := uint64(0)
+		if pep.ID == 0 {
+			requests = 1 // the backups only learn of the request through its pre-prepare
+		}
+		if count := m.requestPhase.Count(); count != requests {
+			t.Errorf("Replica %d observed %d request phases, expected %d", pep.ID, count, requests)
+		}
+		for phase, h := range map[string]*metrics.Histogram{"prepare": m.preparePhase, "commit": m.commitPhase, "execute": m.executePhase} {
+			if count := h.Count(); count != 1 {
+				t.Errorf("Replica %d observed %d %s phases, expected 1", pep.ID, count, phase)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// phaseTimeouts bound how long a sequence number may stay in the prepare
+// and in the commit phase of the normal case. The request timer expires
+// alike whether the primary did not pre-prepare the outstanding requests or
+// the replicas did not agree on them. These only expire once the primary
+// did its part: a sequence number pre-prepared but not prepared points at
+// replicas which do not hear each other, or at a primary which sent
+// conflicting pre-prepares, one prepared but not committed at a partitioned
+// quorum. Either way the replica sends a view change, telling the operators
+// which phase stalled
+type phaseTimeouts struct {
+	prepare time.Duration // pre-prepared -> prepared, 0 if unbounded
+	commit  time.Duration // prepared -> committed, 0 if unbounded
+
+	prepareTimer EventTimer
+	commitTimer  EventTimer
+	prepareArmed msgID // the certificate the prepare timer runs for, zero if it is stopped
+	commitArmed  msgID // the certificate the commit timer runs for, zero if it is stopped
+}
+
+// newPhaseTimeouts returns nil if general.timeout.prepare and
+// general.timeout.commit are 0
+func newPhaseTimeouts(config *viper.Viper) (*phaseTimeouts, error) {
+	prepare, err := time.ParseDuration(config.GetString("general.timeout.prepare"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse prepare timeout: %s", err)
+	}
+	commit, err := time.ParseDuration(config.GetString("general.timeout.commit"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse commit timeout: %s", err)
+	}
+	if prepare < 0 || commit < 0 {
+		return nil, fmt.Errorf("Prepare and commit timeouts must not be negative, got %v and %v", prepare, commit)
+	}
+	if prepare == 0 && commit == 0 {
+		return nil, nil
+	}
+	return &phaseTimeouts{prepare: prepare, commit: commit}, nil
+}
+
+// arm points timer at the certificate idx, which entered the phase at
+// since, unless it already runs for it. A zero idx stops the timer
+func (pt *phaseTimeouts) arm(timer EventTimer, armed *msgID, idx msgID, since time.Time, timeout time.Duration, event interface{}, now time.Time) {
+	if timeout == 0 || *armed == idx {
+		return
+	}
+	*armed = idx
+	if idx.n == 0 {
+		timer.Stop()
+		return
+	}
+	timer.Reset(since.Add(timeout).Sub(now), event)
+}
+
+// updatePhaseTimers runs the prepare and commit timers for the lowest
+// sequence numbers of the current view which are stuck in these phases,
+// and stops them while there are none, or no view is active
+func (instance *PbftCore) updatePhaseTimers() {
+	pt := instance.phaseTimeouts
+	if pt == nil {
+		return
+	}
+	var prepare, commit msgID
+	var prepareSince, commitSince time.Time
+	if instance.ActiveView && !instance.SkipInProgress {
+		for n := instance.LastExec + 1; n <= instance.h+instance.L && (prepare.n == 0 || commit.n == 0); n++ {
+			idx := msgID{instance.View, n}
+			cert, ok := instance.certStore[idx]
+			if !ok {
+				continue
+			}
+			switch {
+			case cert.phases.prePrepared.IsZero():
+			case cert.phases.prepared.IsZero():
+				if prepare.n == 0 {
+					prepare, prepareSince = idx, cert.phases.prePrepared
+				}
+			case cert.phases.committed.IsZero():
+				if commit.n == 0 {
+					commit, commitSince = idx, cert.phases.prepared
+				}
+			}
+		}
+	}
+	now := instance.Clock.Now()
+	pt.arm(pt.prepareTimer, &pt.prepareArmed, prepare, prepareSince, pt.prepare, prepareTimerEvent{}, now)
+	pt.arm(pt.commitTimer, &pt.commitArmed, commit, commitSince, pt.commit, commitTimerEvent{}, now)
+}
+
+// phaseTimerExpired sends a view change if the certificate the timer of
+// phase ran for is still stuck in it
+func (instance *PbftCore) phaseTimerExpired(phase string) {
+	pt := instance.phaseTimeouts
+	armed := &pt.prepareArmed
+	if phase == "commit" {
+		armed = &pt.commitArmed
+	}
+	idx := *armed
+	*armed = msgID{}
+	cert, ok := instance.certStore[idx]
+	if idx.n == 0 || !ok || !instance.ActiveView || idx.v != instance.View {
+		return
+	}
+
+	var diagnosis string
+	switch phase {
+	case "prepare":
+		if !cert.phases.prepared.IsZero() {
+			return
+		}
+		matching := 0
+		for _, p := range cert.prepare {
+			if p.RequestDigest == cert.digest {
+				matching++
+			}
+		}
+		diagnosis = fmt.Sprintf("pre-prepared %v ago, %d of %d matching prepares arrived; the replicas do not hear each other, or primary %d sent conflicting pre-prepares",
+			instance.Clock.Now().Sub(cert.phases.prePrepared), matching, instance.intersectionQuorum()-1, instance.Primary(instance.View))
+	case "commit":
+		if !cert.phases.committed.IsZero() {
+			return
+		}
+		diagnosis = fmt.Sprintf("prepared %v ago, %d of %d commits arrived; the quorum is partitioned",
+			instance.Clock.Now().Sub(cert.phases.prepared), len(cert.commit), instance.intersectionQuorum())
+	}
+	instance.Metrics.phaseTimeouts[phase].Inc()
+	instance.vcLog.Warning("Sequence number %d did not complete the %s phase, sending view change: %s", idx.n, phase, diagnosis)
+	instance.SendViewChange()
+}
@@ -0,0 +1,125 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhaseTimeoutsConfig(t *testing.T) {
+	config := loadConfig()
+	if pt, err := newPhaseTimeouts(config); err != nil || pt != nil {
+		t.Errorf("Expected the phase timeouts to be disabled by default, got %v, %v", pt, err)
+	}
+	config.Set("general.timeout.commit", "-1s")
+	if _, err := newPhaseTimeouts(config); err == nil {
+		t.Error("Expected an error for a negative timeout")
+	}
+	config.Set("general.timeout.commit", "soon")
+	if _, err := newPhaseTimeouts(config); err == nil {
+		t.Error("Expected an error for a timeout which is not a duration")
+	}
+}
+
+// newPhaseTimeoutReplica creates backup 1 of a network of 4 replicas, with
+// the given phase timeouts, and pre-prepares a request on it
+func newPhaseTimeoutReplica(prepare, commit string) (*PbftCore, *Request) {
+	config := loadConfig()
+	config.Set("general.timeout.prepare", prepare)
+	config.Set("general.timeout.commit", commit)
+	instance := newPbftCoreWithClock(1, config, &discardConsumer{&simpleConsumer{}}, newVirtualClock(time.Unix(0, 0)))
+
+	req := createPbftRequestWithChainTx(1, 0)
+	digest := HashReq(instance.Digest, req)
+	sendEvent(instance, &PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: digest, Request: req, ReplicaId: 0, DigestAlgorithm: instance.Digest.name()})
+	return instance, req
+}
+
+func TestPrepareTimeout(t *testing.T) {
+	instance, _ := newPhaseTimeoutReplica("1s", "0s")
+	defer instance.Close()
+	if armed := instance.phaseTimeouts.prepareArmed; armed != (msgID{0, 1}) {
+		t.Fatalf("Expected the prepare timer to run for seqNo 1, runs for %v", armed)
+	}
+
+	sendEvent(instance, prepareTimerEvent{})
+	if instance.ActiveView || instance.View != 1 {
+		t.Errorf("Expected a view change once seqNo 1 did not prepare, in view %d", instance.View)
+	}
+	if v := instance.Metrics.phaseTimeouts["prepare"].Value(); v != 1 {
+		t.Errorf("Expected 1 prepare timeout to be counted, got %d", v)
+	}
+	if armed := instance.phaseTimeouts.prepareArmed; armed.n != 0 {
+		t.Errorf("Expected the prepare timer to stop during the view change, runs for %v", armed)
+	}
+}
+
+func TestCommitTimeout(t *testing.T) {
+	instance, req := newPhaseTimeoutReplica("1s", "1s")
+	defer instance.Close()
+	digest := HashReq(instance.Digest, req)
+	for _, id := range []uint64{2, 3} {
+		sendEvent(instance, &Prepare{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: id})
+	}
+	if armed := instance.phaseTimeouts.prepareArmed; armed.n != 0 {
+		t.Errorf("Expected the prepare timer to stop once seqNo 1 prepared, runs for %v", armed)
+	}
+	if armed := instance.phaseTimeouts.commitArmed; armed != (msgID{0, 1}) {
+		t.Fatalf("Expected the commit timer to run for seqNo 1, runs for %v", armed)
+	}
+
+	// a prepare timer which fired before it was stopped is ignored
+	sendEvent(instance, prepareTimerEvent{})
+	if !instance.ActiveView {
+		t.Fatal("Expected a stale prepare timeout not to start a view change")
+	}
+
+	sendEvent(instance, commitTimerEvent{})
+	if instance.ActiveView || instance.View != 1 {
+		t.Errorf("Expected a view change once seqNo 1 did not commit, in view %d", instance.View)
+	}
+	if v := instance.Metrics.phaseTimeouts["commit"].Value(); v != 1 {
+		t.Errorf("Expected 1 commit timeout to be counted, got %d", v)
+	}
+}
+
+func TestPhaseTimeoutsNormalCase(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.timeout.prepare", "500ms")
+	config.Set("general.timeout.commit", "500ms")
+	net := makePBFTNetwork(4, config)
+	defer net.Stop()
+
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(1, 0)
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	time.Sleep(time.Second)
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 1 || pep.pbft.View != 0 {
+			t.Errorf("Instance %d executed %d requests in view %d, expected 1 in view 0", pep.ID, pep.sc.executions, pep.pbft.View)
+		}
+		if pt := pep.pbft.phaseTimeouts; pt.prepareArmed.n != 0 || pt.commitArmed.n != 0 {
+			t.Errorf("Instance %d kept its phase timers running for %v and %v", pep.ID, pt.prepareArmed, pt.commitArmed)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// blacklistKey is the persistor key of the primary blacklist
+const blacklistKey = "blacklist"
+
+// banPeriod is the range of views [from, until) in which a replica may not
+// be primary
+type banPeriod struct {
+	from  uint64
+	until uint64
+}
+
+// primaryBlacklist skips replicas in the primary rotation once the views
+// they led failed too often. Every view-change carries the blacklist of its
+// sender, and a new-view installs the blacklist f+1 of the view-changes it
+// carries agree on, charged with the failed views since. All replicas which
+// accepted a new-view thus agree on the primary of the following views,
+// even if they restarted or missed views in between. A blacklisting only
+// takes effect after the view which was installed when it was decided, so
+// the primary of an installed view never changes
+type primaryBlacklist struct {
+	N         int
+	threshold uint64 // failed views as primary after which a replica is blacklisted
+	cooldown  uint64 // views for which a blacklisted replica is skipped
+
+	failures map[uint64]uint64    // failed views as primary since the replica was last blacklisted
+	banned   map[uint64]banPeriod // the last blacklisting of each replica
+	lastView uint64               // the last installed view
+	cycled   bool                 // whether lastView ended in a periodic view change, rather than a failure
+}
+
+// newPrimaryBlacklist returns nil if blacklisting is disabled
+func newPrimaryBlacklist(config *viper.Viper, view uint64) (*primaryBlacklist, error) {
+	threshold := config.GetInt("general.blacklist.threshold")
+	if threshold <= 0 {
+		return nil, nil
+	}
+	cooldown := config.GetInt("general.blacklist.cooldown")
+	if cooldown <= 0 {
+		return nil, fmt.Errorf("Blacklist cooldown must be positive, got %d", cooldown)
+	}
+	pb := &primaryBlacklist{
+		N:         config.GetInt("general.N"),
+		threshold: uint64(threshold),
+		cooldown:  uint64(cooldown),
+	}
+	pb.adopt(&BlacklistState{View: view})
+	return pb, nil
+}
+
+func (pb *primaryBlacklist) isBanned(replica uint64, v uint64) bool {
+	period, ok := pb.banned[replica]
+	return ok && period.from <= v && v < period.until
+}
+
+// primary returns the primary of view v, which is chosen round robin among
+// the replicas not blacklisted in v
+func (pb *primaryBlacklist) primary(v uint64) uint64 {
+	eligible := make([]uint64, 0, pb.N)
+	for replica := uint64(0); replica < uint64(pb.N); replica++ {
+		if !pb.isBanned(replica, v) {
+			eligible = append(eligible, replica)
+		}
+	}
+	if len(eligible) == 0 {
+		return v % uint64(pb.N)
+	}
+	return eligible[v%uint64(len(eligible))]
+}
+
+// periodicViewChange records that the current view ends because of the
+// automatic rotation of the primary, which is not a failure of the primary
+func (pb *primaryBlacklist) periodicViewChange() {
+	if pb == nil {
+		return
+	}
+	pb.cycled = true
+}
+
+// viewInstalled charges the primaries of the views since the last installed
+// view, up to v, with a failure, and returns the replicas it blacklisted
+func (pb *primaryBlacklist) viewInstalled(v uint64) []uint64 {
+	if pb == nil || v <= pb.lastView {
+		return nil
+	}
+
+	var blacklisted []uint64
+	for w := pb.lastView; w < v; w++ {
+		if w == pb.lastView && pb.cycled {
+			continue
+		}
+		replica := pb.primary(w)
+		pb.failures[replica]++
+		if pb.failures[replica] >= pb.threshold {
+			pb.failures[replica] = 0
+			pb.banned[replica] = banPeriod{from: v + 1, until: v + 1 + pb.cooldown}
+			blacklisted = append(blacklisted, replica)
+		}
+	}
+	pb.lastView = v
+	pb.cycled = false
+	return blacklisted
+}
+
+// state returns the blacklist as carried in view-changes and persisted
+func (pb *primaryBlacklist) state() *BlacklistState {
+	if pb == nil {
+		return nil
+	}
+	bs := &BlacklistState{View: pb.lastView, Cycled: pb.cycled, Failures: make([]uint64, pb.N)}
+	for replica := uint64(0); replica < uint64(pb.N); replica++ {
+		bs.Failures[replica] = pb.failures[replica]
+		if period, ok := pb.banned[replica]; ok {
+			bs.Bans = append(bs.Bans, &BlacklistState_Ban{ReplicaId: replica, From: period.from, Until: period.until})
+		}
+	}
+	return bs
+}
+
+// adopt replaces the blacklist by bs
+func (pb *primaryBlacklist) adopt(bs *BlacklistState) {
+	pb.lastView = bs.View
+	pb.cycled = bs.Cycled
+	pb.failures = make(map[uint64]uint64)
+	for replica, failures := range bs.Failures {
+		if replica < pb.N && failures > 0 {
+			pb.failures[uint64(replica)] = failures
+		}
+	}
+	pb.banned = make(map[uint64]banPeriod)
+	for _, ban := range bs.Bans {
+		if ban.ReplicaId < uint64(pb.N) {
+			pb.banned[ban.ReplicaId] = banPeriod{from: ban.From, until: ban.Until}
+		}
+	}
+}
+
+// agreed returns the blacklist as installed by a new-view for view v which
+// carries vset: the blacklist of the latest view at least quorum of the
+// view-changes agree on, so that a correct replica vouches for it. If they
+// agree on none, the blacklist starts over empty in view v
+func (pb *primaryBlacklist) agreed(vset []*ViewChange, quorum int, v uint64) *primaryBlacklist {
+	votes := make(map[string]int)
+	states := make(map[string]*BlacklistState)
+	for _, vc := range vset {
+		if vc.Blacklist == nil || vc.Blacklist.View >= v {
+			continue
+		}
+		raw, err := proto.Marshal(vc.Blacklist)
+		if err != nil {
+			continue
+		}
+		votes[string(raw)]++
+		states[string(raw)] = vc.Blacklist
+	}
+
+	best := &BlacklistState{View: v}
+	var bestRaw string
+	for raw, count := range votes {
+		bs := states[raw]
+		if count < quorum {
+			continue
+		}
+		if best.View == v || bs.View > best.View || (bs.View == best.View && raw < bestRaw) {
+			best, bestRaw = bs, raw
+		}
+	}
+
+	agreed := &primaryBlacklist{N: pb.N, threshold: pb.threshold, cooldown: pb.cooldown}
+	agreed.adopt(best)
+	return agreed
+}
+
+// newViewPrimary returns the primary of the view of nv, as agreed by the
+// view-changes it carries
+func (instance *PbftCore) newViewPrimary(nv *NewView) uint64 {
+	if instance.blacklist == nil {
+		return instance.Primary(nv.View)
+	}
+	return instance.blacklist.agreed(nv.Vset, instance.F+1, nv.View).primary(nv.View)
+}
+
+// installBlacklist installs the blacklist agreed by the view-changes of nv,
+// charged with the failed views up to the view of nv, and persists it
+func (instance *PbftCore) installBlacklist(nv *NewView) {
+	if instance.blacklist == nil {
+		return
+	}
+	instance.blacklist = instance.blacklist.agreed(nv.Vset, instance.F+1, nv.View)
+	for _, replica := range instance.blacklist.viewInstalled(nv.View) {
+		instance.vcLog.Warning("Blacklisting replica %d as primary after repeated failed views", replica)
+	}
+	instance.persistBlacklist()
+}
+
+func (instance *PbftCore) persistBlacklist() {
+	raw, err := proto.Marshal(instance.blacklist.state())
+	if err != nil {
+		instance.log.Error("Cannot marshal primary blacklist: %s", err)
+		return
+	}
+	if err := instance.persistor.StoreState(blacklistKey, raw); err != nil {
+		instance.log.Warning("Cannot persist primary blacklist: %s", err)
+	}
+}
+
+// restoreBlacklist reads the persisted primary blacklist
+func (instance *PbftCore) restoreBlacklist() {
+	raw, err := instance.persistor.ReadState(blacklistKey)
+	if err != nil || raw == nil {
+		return
+	}
+	bs := &BlacklistState{}
+	if err := proto.Unmarshal(raw, bs); err != nil {
+		instance.log.Error("Persisted primary blacklist is malformed, starting over: %s", err)
+		return
+	}
+	instance.blacklist.adopt(bs)
+	instance.log.Info("Restored primary blacklist as of view %d", bs.View)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrimaryBlacklist(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.blacklist.threshold", 2)
+	config.Set("general.blacklist.cooldown", 3)
+	pb, err := newPrimaryBlacklist(config, 0)
+	if err != nil {
+		t.Fatalf("Failed to create blacklist: %s", err)
+	}
+
+	// Views 0 and 1 failed
+	if blacklisted := pb.viewInstalled(2); len(blacklisted) != 0 {
+		t.Fatalf("Expected no replica to be blacklisted after a single failure, got %v", blacklisted)
+	}
+	// View 2 ended in a periodic view change, views 3, 4 and 5 failed
+	pb.periodicViewChange()
+	blacklisted := pb.viewInstalled(6)
+	if !reflect.DeepEqual(blacklisted, []uint64{0, 1}) {
+		t.Fatalf("Expected replicas 0 and 1 to be blacklisted, got %v", blacklisted)
+	}
+	if pb.failures[2] != 0 {
+		t.Errorf("Expected the periodic view change not to count as a failure of replica 2")
+	}
+
+	expected := map[uint64]uint64{
+		5:  1, // views up to the installed one keep their primary
+		6:  2,
+		7:  3, // views 7 to 9 rotate among replicas 2 and 3
+		8:  2,
+		9:  3,
+		10: 2, // the blacklisting has expired
+		11: 3,
+		12: 0,
+	}
+	for v, primary := range expected {
+		if p := pb.primary(v); p != primary {
+			t.Errorf("Expected replica %d to be primary of view %d, got %d", primary, v, p)
+		}
+	}
+}
+
+func TestPrimaryBlacklistDisabled(t *testing.T) {
+	pb, err := newPrimaryBlacklist(loadConfig(), 0)
+	if err != nil || pb != nil {
+		t.Fatalf("Expected blacklisting to be disabled by default, got %v, %v", pb, err)
+	}
+	pb.periodicViewChange()
+	if blacklisted := pb.viewInstalled(5); blacklisted != nil {
+		t.Errorf("Expected a disabled blacklist to blacklist nothing, got %v", blacklisted)
+	}
+}
+
+func TestPrimaryBlacklistAgreed(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.blacklist.threshold", 1)
+	config.Set("general.blacklist.cooldown", 3)
+	pb, _ := newPrimaryBlacklist(config, 0)
+	pb.viewInstalled(1) // blacklists replica 0 for views 2 to 4
+
+	// Replica 3 restarted and lost its blacklist, and faulty replica 2 claims
+	// that all other replicas are blacklisted
+	forged := &BlacklistState{View: 2, Failures: make([]uint64, 4)}
+	for replica := uint64(0); replica < 4; replica++ {
+		if replica != 2 {
+			forged.Bans = append(forged.Bans, &BlacklistState_Ban{ReplicaId: replica, From: 3, Until: 100})
+		}
+	}
+	vset := []*ViewChange{
+		{ReplicaId: 0, Blacklist: pb.state()},
+		{ReplicaId: 1, Blacklist: pb.state()},
+		{ReplicaId: 2, Blacklist: forged},
+		{ReplicaId: 3},
+	}
+
+	agreed := pb.agreed(vset, 2, 3)
+	if !reflect.DeepEqual(agreed.state(), pb.state()) {
+		t.Fatalf("Expected the blacklist vouched for by f+1 replicas, got %v", agreed.state())
+	}
+	if agreed.primary(3) != pb.primary(3) {
+		t.Errorf("Expected the agreed blacklist to pick primary %d for view 3, got %d", pb.primary(3), agreed.primary(3))
+	}
+
+	// Without f+1 replicas vouching for it, the blacklist starts over
+	agreed = pb.agreed(vset[1:], 2, 3)
+	if len(agreed.banned) != 0 || agreed.lastView != 3 {
+		t.Errorf("Expected an empty blacklist as of view 3, got %v", agreed.state())
+	}
+}
+
+func TestPrimaryBlacklistPersists(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.blacklist.threshold", 1)
+	persist := &mockPersist{}
+	stack := &omniProto{
+		ReadStateImpl:    persist.ReadState,
+		ReadStateSetImpl: persist.ReadStateSet,
+		StoreStateImpl:   persist.StoreState,
+		DelStateImpl:     persist.DelState,
+	}
+
+	p := newPbftCore(1, config, stack)
+	nv := &NewView{View: 2, Vset: []*ViewChange{
+		{ReplicaId: 0, Blacklist: p.blacklist.state()},
+		{ReplicaId: 1, Blacklist: p.blacklist.state()},
+		{ReplicaId: 2, Blacklist: p.blacklist.state()},
+	}}
+	p.installBlacklist(nv)
+	installed := p.blacklist.state()
+	if len(installed.Bans) == 0 {
+		t.Fatalf("Expected the failed views 0 and 1 to blacklist their primaries")
+	}
+	p.Close()
+
+	p = newPbftCore(1, config, stack)
+	defer p.Close()
+	if restored := p.blacklist.state(); !reflect.DeepEqual(restored, installed) {
+		t.Errorf("Expected the installed blacklist %v to be restored, got %v", installed, restored)
+	}
+}
+
+func TestPrimaryBlacklistNetwork(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.blacklist.threshold", 1)
+	config.Set("general.blacklist.cooldown", 5)
+	net := makePBFTNetwork(4, config)
+	defer net.Stop()
+
+	// Replica 3 diverged, as if it had missed the views which banned replica 1
+	net.pbftEndpoints[3].pbft.blacklist.adopt(&BlacklistState{Bans: []*BlacklistState_Ban{{ReplicaId: 1, From: 0, Until: 5}}})
+
+	for round := 0; round < 2; round++ {
+		for _, pep := range net.pbftEndpoints {
+			pep.pbft.SendViewChange()
+		}
+		if err := net.Process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	expected := net.pbftEndpoints[0].pbft.blacklist.state()
+	for _, pep := range net.pbftEndpoints {
+		if !pep.pbft.ActiveView || pep.pbft.View != 2 {
+			t.Errorf("Expected replica %d to be active in view 2, got view %d, active %v", pep.pbft.ID, pep.pbft.View, pep.pbft.ActiveView)
+		}
+		if state := pep.pbft.blacklist.state(); !reflect.DeepEqual(state, expected) {
+			t.Errorf("Expected replica %d to install blacklist %v, got %v", pep.pbft.ID, expected, state)
+		}
+	}
+}
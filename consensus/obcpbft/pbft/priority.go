@@ -0,0 +1,164 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// Priority classes of requests, requests of a higher class are ordered first
+const (
+	PriorityBulk  uint32 = 0 // regular transactions
+	PriorityAdmin uint32 = 1 // administrative transactions, such as chaincode deploys
+)
+
+// requestScheduler decides in which order the primary assigns sequence
+// numbers to the requests waiting for one. Requests of higher priority are
+// ordered first, requests of the same priority in arrival order. To keep bulk
+// traffic from starving, the oldest waiting request is ordered after maxBypass
+// requests in a row overtook it. classify and arrive may be called on a nil
+// requestScheduler, which leaves requests unprioritized
+type requestScheduler struct {
+	prioritizer prioritizer // priority classes of the payloads, nil if the consumer does not distinguish any
+
+	maxBypass int               // how many requests may overtake an older one in a row
+	bypassed  int               // how many requests overtook an older one in a row
+	nextSeq   uint64            // arrival sequence of the next request
+	arrival   map[string]uint64 // arrival sequence of the outstanding requests
+}
+
+// prioritizer is implemented by consumers which know the priority classes of
+// their payloads, pbftCore does not interpret the payloads itself
+type prioritizer interface {
+	Priority(payload []byte) uint32
+}
+
+// newRequestScheduler returns nil if general.priority.enabled is not set
+func newRequestScheduler(config *viper.Viper, consumer interface{}) (*requestScheduler, error) {
+	if !config.GetBool("general.priority.enabled") {
+		return nil, nil
+	}
+	maxBypass := config.GetInt("general.priority.maxbypass")
+	if maxBypass < 1 {
+		return nil, fmt.Errorf("Maximum priority bypass must be at least 1, got %d", maxBypass)
+	}
+	prioritizer, _ := consumer.(prioritizer)
+	return &requestScheduler{
+		prioritizer: prioritizer,
+		maxBypass:   maxBypass,
+		arrival:     make(map[string]uint64),
+	}, nil
+}
+
+// Classify returns the priority class of a payload, as the consumer
+// classifies it
+func (s *requestScheduler) Classify(payload []byte) uint32 {
+	if s == nil || s.prioritizer == nil {
+		return PriorityBulk
+	}
+	return s.prioritizer.Priority(payload)
+}
+
+// arrive records the arrival of an outstanding request
+func (s *requestScheduler) arrive(digest string) {
+	if s == nil {
+		return
+	}
+	if _, ok := s.arrival[digest]; ok {
+		return
+	}
+	s.nextSeq++
+	s.arrival[digest] = s.nextSeq
+}
+
+// prune forgets the arrival of requests which are no longer outstanding
+func (s *requestScheduler) prune(outstanding map[string]*Request) {
+	for digest := range s.arrival {
+		if _, ok := outstanding[digest]; !ok {
+			delete(s.arrival, digest)
+		}
+	}
+}
+
+// next returns the index of the request in pending which should be ordered
+// next, and whether it overtakes an older request
+func (s *requestScheduler) next(pending []string, reqs map[string]*Request) (int, bool) {
+	oldest, best := 0, 0
+	for i, digest := range pending {
+		if s.arrival[digest] < s.arrival[pending[oldest]] {
+			oldest = i
+		}
+		p, bp := reqs[digest].Priority, reqs[pending[best]].Priority
+		if p > bp || (p == bp && s.arrival[digest] < s.arrival[pending[best]]) {
+			best = i
+		}
+	}
+	if best == oldest || s.bypassed >= s.maxBypass {
+		return oldest, false
+	}
+	return best, true
+}
+
+// scheduled is called once the request returned by next was assigned a
+// sequence number
+func (s *requestScheduler) scheduled(bypass bool) {
+	if bypass {
+		s.bypassed++
+	} else {
+		s.bypassed = 0
+	}
+}
+
+// Prioritize orders a batch of requests by descending priority, while
+// keeping the requests of every submitting replica in their original order,
+// as replicas drop requests which are older than one they executed already
+func Prioritize(reqs []*Request) []*Request {
+	queues := make(map[uint64][]*Request)
+	var replicas []uint64
+	for _, req := range reqs {
+		if _, ok := queues[req.ReplicaId]; !ok {
+			replicas = append(replicas, req.ReplicaId)
+		}
+		queues[req.ReplicaId] = append(queues[req.ReplicaId], req)
+	}
+
+	// Among the first waiting request of every replica, pick the one of
+	// highest priority, or the one which was queued first
+	position := make(map[*Request]int)
+	for i, req := range reqs {
+		position[req] = i
+	}
+	ordered := make([]*Request, 0, len(reqs))
+	for len(ordered) < len(reqs) {
+		var best *Request
+		var bestReplica uint64
+		for _, replica := range replicas {
+			q := queues[replica]
+			if len(q) == 0 {
+				continue
+			}
+			if best == nil || q[0].Priority > best.Priority || (q[0].Priority == best.Priority && position[q[0]] < position[best]) {
+				best, bestReplica = q[0], replica
+			}
+		}
+		ordered = append(ordered, best)
+		queues[bestReplica] = queues[bestReplica][1:]
+	}
+	return ordered
+}
@@ -0,0 +1,178 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+
+	"github.com/golang/protobuf/proto"
+	gp "google/protobuf"
+)
+
+func createInvokeTx(iter int64) []byte {
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE,
+		Timestamp: &gp.Timestamp{Seconds: iter, Nanos: 0},
+		Payload:   []byte(fmt.Sprint(iter)),
+	}
+	txPacked, _ := proto.Marshal(tx)
+	return txPacked
+}
+
+// deployPrioritizer classifies deploys as administrative requests
+type deployPrioritizer struct{}
+
+func (dp *deployPrioritizer) Priority(txRaw []byte) uint32 {
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(txRaw, tx); err == nil && tx.Type == pb.Transaction_CHAINCODE_DEPLOY {
+		return PriorityAdmin
+	}
+	return PriorityBulk
+}
+
+func TestRequestSchedulerClassify(t *testing.T) {
+	config := loadConfig()
+	if s, err := newRequestScheduler(config, &deployPrioritizer{}); err != nil || s != nil {
+		t.Fatalf("Expected requests to be unprioritized by default, got %v, %v", s, err)
+	}
+	if p := (*requestScheduler)(nil).Classify(createOcMsgWithChainTx(1).Payload); p != PriorityBulk {
+		t.Errorf("Expected no priorities without a scheduler, got %d", p)
+	}
+
+	config.Set("general.priority.enabled", true)
+	s, err := newRequestScheduler(config, &omniProto{})
+	if err != nil {
+		t.Fatalf("Failed to create request scheduler: %s", err)
+	}
+	if p := s.Classify(createOcMsgWithChainTx(1).Payload); p != PriorityBulk {
+		t.Errorf("Expected payloads to be unprioritized unless the consumer classifies them, got priority %d", p)
+	}
+
+	s, err = newRequestScheduler(config, &deployPrioritizer{})
+	if err != nil {
+		t.Fatalf("Failed to create request scheduler: %s", err)
+	}
+	if p := s.Classify(createOcMsgWithChainTx(1).Payload); p != PriorityAdmin {
+		t.Errorf("Expected the priority of the consumer to be used, got priority %d", p)
+	}
+	if p := s.Classify(createInvokeTx(1)); p != PriorityBulk {
+		t.Errorf("Expected an invoke to be a bulk request, got priority %d", p)
+	}
+
+	config.Set("general.priority.maxbypass", 0)
+	if _, err := newRequestScheduler(config, nil); err == nil {
+		t.Errorf("Expected a maximum bypass of 0 to be rejected")
+	}
+}
+
+func TestRequestSchedulerStarvation(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.priority.enabled", true)
+	config.Set("general.priority.maxbypass", 2)
+	s, err := newRequestScheduler(config, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request scheduler: %s", err)
+	}
+
+	reqs := make(map[string]*Request)
+	var pending []string
+	for _, name := range []string{"b1", "b2", "a1", "a2", "a3"} {
+		reqs[name] = &Request{}
+		if name[0] == 'a' {
+			reqs[name].Priority = PriorityAdmin
+		}
+		s.arrive(name)
+		pending = append(pending, name)
+	}
+
+	var order []string
+	for len(pending) > 0 {
+		i, bypass := s.next(pending, reqs)
+		order = append(order, pending[i])
+		pending = append(pending[:i], pending[i+1:]...)
+		s.scheduled(bypass)
+	}
+	if expected := []string{"a1", "a2", "b1", "a3", "b2"}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected requests to be ordered %v, got %v", expected, order)
+	}
+}
+
+func TestPrimaryOrdersByPriority(t *testing.T) {
+	var prePrepares []*PrePrepare
+	persist := &mockPersist{}
+	stack := &omniProto{
+		ReadStateImpl:    persist.ReadState,
+		ReadStateSetImpl: persist.ReadStateSet,
+		StoreStateImpl:   persist.StoreState,
+		DelStateImpl:     persist.DelState,
+		validateImpl: func(txRaw []byte) error {
+			return nil
+		},
+		broadcastImpl: func(msgPayload []byte) {
+			msg := &Message{}
+			if err := proto.Unmarshal(msgPayload, msg); err != nil {
+				t.Fatalf("Could not unmarshal broadcast message: %s", err)
+			}
+			if pp := msg.GetPrePrepare(); pp != nil {
+				prePrepares = append(prePrepares, pp)
+			}
+		},
+	}
+	config := loadConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	config.Set("general.priority.enabled", true)
+	instance := newPbftCore(0, config, stack)
+	defer instance.Close()
+
+	// The primary runs out of sequence numbers after two requests
+	for i := int64(1); i <= 3; i++ {
+		sendEvent(instance, &Request{Payload: createInvokeTx(i), ReplicaId: 1})
+	}
+	admin := &Request{Payload: createOcMsgWithChainTx(4).Payload, ReplicaId: 1, Priority: PriorityAdmin}
+	sendEvent(instance, admin)
+	if len(prePrepares) != 2 {
+		t.Fatalf("Expected the primary to be held back after 2 pre-prepares, sent %d", len(prePrepares))
+	}
+
+	for _, cert := range instance.certStore {
+		delete(instance.OutstandingReqs, cert.digest) // committed and executed
+	}
+	instance.LastExec = 2
+	instance.moveWatermarks(2)
+	if len(prePrepares) != 4 {
+		t.Fatalf("Expected the primary to pre-prepare the waiting requests, sent %d", len(prePrepares))
+	}
+	if prePrepares[2].RequestDigest != HashReq(instance.Digest, admin) {
+		t.Errorf("Expected the administrative request to be ordered ahead of the older bulk request")
+	}
+}
+
+func TestPrioritizeBatch(t *testing.T) {
+	bulk0 := &Request{ReplicaId: 0}
+	admin0 := &Request{ReplicaId: 0, Priority: PriorityAdmin}
+	bulk1 := &Request{ReplicaId: 1}
+	admin2 := &Request{ReplicaId: 2, Priority: PriorityAdmin}
+
+	ordered := Prioritize([]*Request{bulk0, bulk1, admin0, admin2})
+	if expected := []*Request{admin2, bulk0, admin0, bulk1}; !reflect.DeepEqual(ordered, expected) {
+		t.Errorf("Expected administrative requests first without reordering the requests of a replica, got %v", ordered)
+	}
+}
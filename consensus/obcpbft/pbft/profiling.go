@@ -0,0 +1,103 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+)
+
+// The profiles are those of the process, as the runtime has a single CPU
+// and block profile, so that toggling them through one consenter affects
+// all of the consenters of the process. Stacks are those of the goroutines
+// of the event managers and execution queues of the process, the header of
+// the dump describes those of the consenter it was requested from
+
+// stackFunctions are the functions whose goroutines dumpStacks writes
+var stackFunctions = []string{"pbft.(*eventManagerImpl)", "pbft.(*execQueue)"}
+
+func StartCPUProfile(w io.Writer) error {
+	if err := pprof.StartCPUProfile(w); err != nil {
+		return fmt.Errorf("Cannot start CPU profile: %s", err)
+	}
+	logger.Info("Started CPU profile")
+	return nil
+}
+
+func StopCPUProfile() {
+	pprof.StopCPUProfile()
+	logger.Info("Stopped CPU profile")
+}
+
+func SetBlockProfileRate(rate int) {
+	runtime.SetBlockProfileRate(rate)
+	if rate <= 0 {
+		logger.Info("Disabled block profile")
+		return
+	}
+	logger.Info("Sampling one blocking event per %d nanoseconds blocked", rate)
+}
+
+func WriteBlockProfile(w io.Writer) error {
+	return pprof.Lookup("block").WriteTo(w, 0)
+}
+
+// DumpStacks writes what the event thread and the execution queue of the
+// core are doing, followed by the stacks of their goroutines
+func (instance *PbftCore) DumpStacks(w io.Writer) error {
+	var buf bytes.Buffer
+	if em, ok := instance.Manager.(*eventManagerImpl); ok {
+		if event, elapsed := em.processing(); event != nil {
+			fmt.Fprintf(&buf, "Event thread processing %T for %v, %d events queued\n", event, elapsed, em.depth())
+		} else {
+			fmt.Fprintf(&buf, "Event thread idle, %d events queued\n", em.depth())
+		}
+	}
+	if seqNo, elapsed, ok := instance.execQueue.executing(); ok {
+		fmt.Fprintf(&buf, "Execution of sequence number %d handed to the consumer %v ago\n", seqNo, elapsed)
+	} else {
+		fmt.Fprintf(&buf, "Execution queue idle\n")
+	}
+
+	for _, stack := range strings.Split(string(goroutineStacks()), "\n\n") {
+		for _, fn := range stackFunctions {
+			if strings.Contains(stack, fn) {
+				fmt.Fprintf(&buf, "\n%s\n", stack)
+				break
+			}
+		}
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// goroutineStacks returns the stacks of all goroutines, separated by blank
+// lines
+func goroutineStacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
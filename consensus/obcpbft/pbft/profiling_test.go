@@ -0,0 +1,100 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingConsumer holds up executions until release is closed
+type blockingConsumer struct {
+	*simpleConsumer
+	started chan uint64
+	release chan struct{}
+}
+
+func (bc *blockingConsumer) Execute(seqNo uint64, tx []byte, done ExecCallback) {
+	bc.started <- seqNo
+	<-bc.release
+	done(nil)
+}
+
+func TestDumpStacks(t *testing.T) {
+	bc := &blockingConsumer{&simpleConsumer{}, make(chan uint64, 1), make(chan struct{})}
+	instance := newPbftCore(0, loadConfig(), bc)
+	instance.Manager.Start()
+	defer instance.Close()
+
+	// the goroutines of the consenter may not have reached their loops yet
+	var idle bytes.Buffer
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		idle.Reset()
+		if err := instance.DumpStacks(&idle); err != nil {
+			t.Fatalf("Could not dump stacks: %s", err)
+		}
+		if strings.Contains(idle.String(), "eventLoop") && strings.Contains(idle.String(), "(*execQueue).run") {
+			break
+		}
+	}
+	for _, expected := range []string{"Event thread idle", "Execution queue idle", "(*eventManagerImpl).eventLoop", "(*execQueue).run"} {
+		if !strings.Contains(idle.String(), expected) {
+			t.Errorf("Expected the dump to contain %q, got:\n%s", expected, idle.String())
+		}
+	}
+	if strings.Contains(idle.String(), "TestDumpStacks") {
+		t.Errorf("Expected the dump to only contain the goroutines of the consenter, got:\n%s", idle.String())
+	}
+
+	instance.execQueue.submit(7, nil, func([]byte) {})
+	select {
+	case <-bc.started:
+	case <-time.After(time.Second):
+		t.Fatal("Execution was not handed to the consumer")
+	}
+	var stalled bytes.Buffer
+	instance.DumpStacks(&stalled)
+	close(bc.release)
+	for _, expected := range []string{"Execution of sequence number 7", "(*blockingConsumer).Execute"} {
+		if !strings.Contains(stalled.String(), expected) {
+			t.Errorf("Expected the dump of the stalled execution to contain %q, got:\n%s", expected, stalled.String())
+		}
+	}
+}
+
+func TestCPUProfileToggle(t *testing.T) {
+	var profile bytes.Buffer
+	if err := StartCPUProfile(&profile); err != nil {
+		t.Fatalf("Could not start CPU profile: %s", err)
+	}
+	if err := StartCPUProfile(&bytes.Buffer{}); err == nil {
+		t.Error("Expected a second CPU profile to be refused while one is running")
+	}
+	StopCPUProfile()
+	if profile.Len() == 0 {
+		t.Error("Expected the CPU profile to be written once it stopped")
+	}
+
+	SetBlockProfileRate(1)
+	defer SetBlockProfileRate(0)
+	var block bytes.Buffer
+	if err := WriteBlockProfile(&block); err != nil || block.Len() == 0 {
+		t.Errorf("Expected the block profile to be written, got %d bytes and error %v", block.Len(), err)
+	}
+}
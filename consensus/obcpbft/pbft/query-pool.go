@@ -0,0 +1,74 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+// queryJob is a read-only request waiting to be executed
+type queryJob struct {
+	req    *Request
+	digest string
+}
+
+// queryPool executes read-only requests on goroutines of its own, so that a
+// slow query never holds up the processing of the ordered requests. Each
+// result reaches the event thread as a queryExecutedEvent, which replies to
+// the replica that submitted the query
+type queryPool struct {
+	threaded
+	consumer InnerStack
+	manager  EventManager
+	jobs     chan queryJob
+}
+
+func newQueryPool(workers int, consumer InnerStack, manager EventManager) *queryPool {
+	qp := &queryPool{
+		threaded: threaded{make(chan struct{})},
+		consumer: consumer,
+		manager:  manager,
+		jobs:     make(chan queryJob, workers),
+	}
+	for i := 0; i < workers; i++ {
+		go qp.run()
+	}
+	return qp
+}
+
+// submit queues a read-only request for execution, it returns false without
+// waiting if all workers are busy and the queue is full
+func (qp *queryPool) submit(req *Request, digest string) bool {
+	select {
+	case qp.jobs <- queryJob{req: req, digest: digest}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (qp *queryPool) run() {
+	for {
+		select {
+		case job := <-qp.jobs:
+			result, err := qp.consumer.ExecuteQuery(job.req.Payload)
+			select {
+			case qp.manager.Queue() <- queryExecutedEvent{req: job.req, digest: job.digest, result: result, err: err}:
+			case <-qp.exit:
+				return
+			}
+		case <-qp.exit:
+			return
+		}
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	google_protobuf "google/protobuf"
+)
+
+type queryResult struct {
+	result []byte
+	err    error
+}
+
+// pendingQuery is a read-only request we submitted, waiting for f+1
+// matching replies
+type pendingQuery struct {
+	replies map[uint64]*QueryReply
+	done    chan queryResult
+}
+
+// SubmitQuery broadcasts a read-only request, which every replica executes
+// against its committed state, and blocks until f+1 replicas returned the
+// same result. Read-only requests are never ordered, so they consume no
+// sequence numbers. It must not be called from the event thread
+func (instance *PbftCore) SubmitQuery(payload []byte) ([]byte, error) {
+	digest, done := instance.startQuery(payload)
+
+	select {
+	case r := <-done:
+		return r.result, r.err
+	case <-instance.Clock.After(instance.RequestTimeout):
+		instance.Inject(func() {
+			delete(instance.pendingQueries, digest)
+		})
+		return nil, fmt.Errorf("Query %s timed out waiting for %d matching replies", digest, instance.F+1)
+	}
+}
+
+// startQuery queues the broadcast of a read-only request, and returns its
+// digest along with the channel its result will be delivered on
+func (instance *PbftCore) startQuery(payload []byte) (string, <-chan queryResult) {
+	now := instance.Clock.Now()
+	req := &Request{
+		Timestamp: &google_protobuf.Timestamp{
+			Seconds: now.Unix(),
+			Nanos:   int32(now.UnixNano() % 1000000000),
+		},
+		Payload:   payload,
+		ReplicaId: instance.ID,
+		ReadOnly:  true,
+	}
+	digest := HashReq(instance.Digest, req)
+	done := make(chan queryResult, 1)
+
+	instance.Inject(func() {
+		instance.pendingQueries[digest] = &pendingQuery{
+			replies: make(map[uint64]*QueryReply),
+			done:    done,
+		}
+		instance.innerBroadcast(&Message{&Message_Request{req}})
+		instance.recvQuery(req, digest)
+	})
+	return digest, done
+}
+
+// recvQuery hands a read-only request to the query pool, which executes it
+// off the event thread
+func (instance *PbftCore) recvQuery(req *Request, digest string) error {
+	instance.log.Debug("Received query %s from replica %d", digest, req.ReplicaId)
+
+	if instance.SkipInProgress {
+		instance.log.Debug("Not answering query %s, it is catching up", digest)
+		return nil
+	}
+
+	if !instance.queryPool.submit(req, digest) {
+		instance.log.Warning("Not answering query %s from replica %d, all query workers are busy", digest, req.ReplicaId)
+		return nil
+	}
+	instance.runningQueries++
+	return nil
+}
+
+// replyQuery replies to the replica which submitted an executed read-only
+// request
+func (instance *PbftCore) replyQuery(qe queryExecutedEvent) error {
+	instance.runningQueries--
+
+	reply := &QueryReply{
+		RequestDigest: qe.digest,
+		ReplicaId:     instance.ID,
+	}
+	if qe.err != nil {
+		reply.Error = qe.err.Error()
+	} else {
+		reply.Result = qe.result
+	}
+
+	if qe.req.ReplicaId == instance.ID {
+		return instance.recvQueryReply(reply)
+	}
+	msgRaw, err := proto.Marshal(&Message{&Message_QueryReply{reply}})
+	if err != nil {
+		return fmt.Errorf("Cannot marshal query reply: %s", err)
+	}
+	return instance.consumer.Unicast(msgRaw, qe.req.ReplicaId)
+}
+
+// recvQueryReply completes a pending query once f+1 replicas returned the
+// same result, or fails it once all replicas replied without f+1 agreeing
+func (instance *PbftCore) recvQueryReply(reply *QueryReply) error {
+	pq, ok := instance.pendingQueries[reply.RequestDigest]
+	if !ok {
+		instance.log.Debug("Ignoring reply from replica %d for query %s, which is not pending", reply.ReplicaId, reply.RequestDigest)
+		return nil
+	}
+	if _, ok := pq.replies[reply.ReplicaId]; ok {
+		instance.log.Warning("Ignoring duplicate reply from replica %d for query %s", reply.ReplicaId, reply.RequestDigest)
+		return nil
+	}
+	pq.replies[reply.ReplicaId] = reply
+
+	matching := 0
+	for _, r := range pq.replies {
+		if bytes.Equal(r.Result, reply.Result) && r.Error == reply.Error {
+			matching++
+		}
+	}
+
+	if matching >= instance.F+1 {
+		instance.log.Debug("Received %d matching replies for query %s", matching, reply.RequestDigest)
+		delete(instance.pendingQueries, reply.RequestDigest)
+		if reply.Error != "" {
+			pq.done <- queryResult{err: errors.New(reply.Error)}
+		} else {
+			pq.done <- queryResult{result: reply.Result}
+		}
+	} else if len(pq.replies) == instance.N {
+		delete(instance.pendingQueries, reply.RequestDigest)
+		pq.done <- queryResult{err: fmt.Errorf("Replicas returned diverging results for query %s", reply.RequestDigest)}
+	}
+	return nil
+}
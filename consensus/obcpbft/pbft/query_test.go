@@ -0,0 +1,149 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func waitQueryResult(t *testing.T, done <-chan queryResult) queryResult {
+	select {
+	case r := <-done:
+		return r
+	case <-time.After(time.Second):
+		t.Fatalf("Query did not complete")
+	}
+	return queryResult{}
+}
+
+func TestNetworkQuery(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.Stop()
+
+	msg := createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- msg
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	_, done := net.pbftEndpoints[2].pbft.startQuery([]byte("foo"))
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	r := waitQueryResult(t, done)
+	if r.err != nil {
+		t.Fatalf("Query failed: %s", r.err)
+	}
+	if string(r.result) != "1:foo" {
+		t.Errorf("Expected the query to be answered against the committed state, got %q", r.result)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 1 {
+			t.Errorf("Instance %d executed %d requests, expected the query not to be executed", pep.ID, pep.sc.executions)
+		}
+		if pep.pbft.LastExec != 1 {
+			t.Errorf("Instance %d has lastExec %d, expected the query not to consume a sequence number", pep.ID, pep.pbft.LastExec)
+		}
+		if len(pep.pbft.OutstandingReqs) != 0 {
+			t.Errorf("Instance %d tracks the query as an outstanding request", pep.ID)
+		}
+	}
+	if len(net.pbftEndpoints[2].pbft.pendingQueries) != 0 {
+		t.Errorf("Expected the completed query to no longer be pending")
+	}
+}
+
+func TestNetworkQueryDivergence(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.Stop()
+
+	for _, pep := range net.pbftEndpoints {
+		id := pep.pbft.ID
+		pep.sc.queryImpl = func(txRaw []byte) ([]byte, error) {
+			return []byte(fmt.Sprintf("replica %d", id)), nil
+		}
+	}
+
+	_, done := net.pbftEndpoints[1].pbft.startQuery([]byte("foo"))
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	if r := waitQueryResult(t, done); r.err == nil {
+		t.Errorf("Expected diverging results to fail the query, got %q", r.result)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		pep.sc.queryImpl = func(txRaw []byte) ([]byte, error) {
+			return nil, fmt.Errorf("no such key")
+		}
+	}
+
+	_, done = net.pbftEndpoints[1].pbft.startQuery([]byte("foo"))
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	if r := waitQueryResult(t, done); r.err == nil || r.err.Error() != "no such key" {
+		t.Errorf("Expected the error returned by f+1 replicas, got %v", r.err)
+	}
+}
+
+func TestQueryOffEventThread(t *testing.T) {
+	release := make(chan struct{})
+	replied := make(chan uint64, 1)
+	instance := newPbftCore(1, loadConfig(), &omniProto{
+		queryImpl: func(txRaw []byte) ([]byte, error) {
+			<-release
+			return txRaw, nil
+		},
+		unicastImpl: func(msgPayload []byte, receiverID uint64) error {
+			replied <- receiverID
+			return nil
+		},
+	})
+	instance.Manager.Start()
+	defer instance.Close()
+
+	instance.Manager.Queue() <- &Request{Payload: []byte("foo"), ReplicaId: 0, ReadOnly: true}
+
+	// The event thread keeps processing events while the query executes
+	running := make(chan int, 1)
+	instance.Inject(func() { running <- instance.runningQueries })
+	select {
+	case n := <-running:
+		if n != 1 {
+			t.Fatalf("Expected the query to be running on the query pool, %d are", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Event thread blocked by the query")
+	}
+
+	close(release)
+	select {
+	case id := <-replied:
+		if id != 0 {
+			t.Errorf("Expected the reply to be sent to replica 0, got %d", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Result of the query was not replied")
+	}
+}
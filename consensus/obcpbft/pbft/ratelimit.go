@@ -0,0 +1,155 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// TokenBucket admits up to burst messages at once, and refills at rate
+// messages per second
+type TokenBucket struct {
+	Tokens float64
+	Last   time.Time
+}
+
+// Take refills the bucket for the time elapsed until now, and takes a token
+// from it, it returns false if the bucket is empty
+func (b *TokenBucket) Take(now time.Time, rate float64, burst float64) bool {
+	if elapsed := now.Sub(b.Last); elapsed > 0 {
+		b.Tokens += elapsed.Seconds() * rate
+		if b.Tokens > burst {
+			b.Tokens = burst
+		}
+		b.Last = now
+	}
+	if b.Tokens < 1 {
+		return false
+	}
+	b.Tokens--
+	return true
+}
+
+// bucketKey identifies the bucket of the messages of one type from one replica
+type bucketKey struct {
+	sender uint64
+	kind   string
+}
+
+// inboundLimiter drops the consensus messages a replica sends faster than
+// it is allowed to, so that a faulty replica flooding us with checkpoints or
+// view changes cannot monopolize the event thread. It is consulted before
+// messages are queued, from any goroutine. All methods may be called on a
+// nil inboundLimiter, which admits every message
+type inboundLimiter struct {
+	lock    sync.Mutex
+	clock   Clock
+	rate    float64            // messages per second of the types without an override, 0 if unlimited
+	burst   float64            // messages admitted at once
+	types   map[string]float64 // rates by message type, 0 if unlimited
+	buckets map[bucketKey]*TokenBucket
+}
+
+// newInboundLimiter returns nil if neither general.ratelimit.rate nor any of
+// the per type rates limits the messages of the other replicas
+func newInboundLimiter(config *viper.Viper, clk Clock) (*inboundLimiter, error) {
+	rate := config.GetFloat64("general.ratelimit.rate")
+	if rate < 0 {
+		return nil, fmt.Errorf("Inbound message rate must not be negative, got %v", rate)
+	}
+	limited := rate > 0
+
+	types := make(map[string]float64)
+	for kind, value := range config.GetStringMap("general.ratelimit.types") {
+		typeRate, err := cast.ToFloat64E(value)
+		if err != nil {
+			return nil, fmt.Errorf("Inbound message rate of %s messages must be a number: %s", kind, err)
+		}
+		if typeRate < 0 {
+			return nil, fmt.Errorf("Inbound message rate of %s messages must not be negative, got %v", kind, typeRate)
+		}
+		types[strings.ToLower(kind)] = typeRate
+		limited = limited || typeRate > 0
+	}
+	if !limited {
+		return nil, nil
+	}
+
+	burst := config.GetInt("general.ratelimit.burst")
+	if burst < 1 {
+		return nil, fmt.Errorf("Inbound message burst must be at least 1, got %d", burst)
+	}
+	return &inboundLimiter{
+		clock:   clk,
+		rate:    rate,
+		burst:   float64(burst),
+		types:   types,
+		buckets: make(map[bucketKey]*TokenBucket),
+	}, nil
+}
+
+// messageKind names the type of msg, like the keys of general.ratelimit.types
+func messageKind(msg *Message) string {
+	return strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", msg.Payload), "*pbft.Message_"))
+}
+
+// admit takes a token from the bucket of the type of msg for sender, and
+// returns false if the bucket is empty
+func (il *inboundLimiter) admit(msg *Message, sender uint64) bool {
+	if il == nil {
+		return true
+	}
+	kind := messageKind(msg)
+	rate, ok := il.types[kind]
+	if !ok {
+		rate = il.rate
+	}
+	if rate == 0 {
+		return true
+	}
+
+	il.lock.Lock()
+	defer il.lock.Unlock()
+
+	now := il.clock.Now()
+	key := bucketKey{sender, kind}
+	b, ok := il.buckets[key]
+	if !ok {
+		b = &TokenBucket{Tokens: il.burst, Last: now}
+		il.buckets[key] = b
+	}
+	return b.Take(now, rate, il.burst)
+}
+
+// Admit returns whether msg of another replica may be queued for the event
+// thread, and counts the messages dropped for exceeding the rate limit. Our
+// own messages are always admitted
+func (instance *PbftCore) Admit(msg *Message, senderID uint64) bool {
+	if senderID == instance.ID || instance.limiter.admit(msg, senderID) {
+		return true
+	}
+	instance.log.Debug("Dropping %s message from replica %d, which exceeds its rate limit",
+		messageKind(msg), senderID)
+	instance.Metrics.rateLimited.Inc()
+	return false
+}
@@ -0,0 +1,125 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInboundLimiterDisabled(t *testing.T) {
+	il, err := newInboundLimiter(loadConfig(), wallClock{})
+	if err != nil || il != nil {
+		t.Fatalf("Expected inbound rate limiting to be disabled by default, got %v, %v", il, err)
+	}
+	chkpt := &Message{&Message_Checkpoint{&Checkpoint{ReplicaId: 1}}}
+	for i := 0; i < 1000; i++ {
+		if !il.admit(chkpt, 1) {
+			t.Fatalf("Expected a disabled limiter to admit every message")
+		}
+	}
+
+	config := loadConfig()
+	config.Set("general.ratelimit.rate", -1)
+	if _, err := newInboundLimiter(config, wallClock{}); err == nil {
+		t.Errorf("Expected a negative rate to be rejected")
+	}
+	config = loadConfig()
+	config.Set("general.ratelimit.types", map[string]interface{}{"checkpoint": 10})
+	config.Set("general.ratelimit.burst", 0)
+	if _, err := newInboundLimiter(config, wallClock{}); err == nil {
+		t.Errorf("Expected a burst of 0 to be rejected")
+	}
+}
+
+func TestInboundLimiterBuckets(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.ratelimit.rate", 10)
+	config.Set("general.ratelimit.burst", 2)
+	config.Set("general.ratelimit.types", map[string]interface{}{"ViewChange": 1, "prepare": 0})
+	vc := newVirtualClock(time.Unix(0, 0))
+	il, err := newInboundLimiter(config, vc)
+	if err != nil || il == nil {
+		t.Fatalf("Expected inbound rate limiting to be enabled, got %v, %v", il, err)
+	}
+
+	chkpt := &Message{&Message_Checkpoint{&Checkpoint{ReplicaId: 1}}}
+	viewChange := &Message{&Message_ViewChange{&ViewChange{ReplicaId: 1}}}
+	prepare := &Message{&Message_Prepare{&Prepare{ReplicaId: 1}}}
+
+	for i := 0; i < 2; i++ {
+		if !il.admit(chkpt, 1) || !il.admit(viewChange, 1) {
+			t.Fatalf("Expected the first %d messages to be admitted", i+1)
+		}
+	}
+	if il.admit(chkpt, 1) || il.admit(viewChange, 1) {
+		t.Fatalf("Expected messages beyond the burst to be dropped")
+	}
+	if !il.admit(chkpt, 2) {
+		t.Errorf("Expected the messages of another replica to be limited separately")
+	}
+	for i := 0; i < 100; i++ {
+		if !il.admit(prepare, 1) {
+			t.Fatalf("Expected prepares to be unlimited")
+		}
+	}
+
+	vc.advance(100 * time.Millisecond)
+	if !il.admit(chkpt, 1) {
+		t.Errorf("Expected a checkpoint to be admitted once its bucket refilled")
+	}
+	if il.admit(viewChange, 1) {
+		t.Errorf("Expected view changes to refill at their own rate")
+	}
+	vc.advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		if !il.admit(viewChange, 1) {
+			t.Fatalf("Expected the view change bucket to refill up to the burst")
+		}
+	}
+	if il.admit(viewChange, 1) {
+		t.Errorf("Expected the bucket to hold no more than the burst")
+	}
+}
+
+func TestInboundLimiterDropsBeforeQueueing(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.ratelimit.types", map[string]interface{}{"checkpoint": 1})
+	config.Set("general.ratelimit.burst", 1)
+	instance := newPbftCore(0, config, &omniProto{})
+	defer instance.Close()
+	queued := make(chan interface{}, 10)
+	manager := instance.Manager
+	instance.Manager = &queueingManager{queued}
+	defer func() { instance.Manager = manager }()
+	dropped := instance.Metrics.rateLimited.Value()
+
+	chkpt := &Message{&Message_Checkpoint{&Checkpoint{ReplicaId: 1, SequenceNumber: 10}}}
+	for i := 0; i < 3; i++ {
+		LegacyShim{instance}.RecvMsgSync(chkpt, 1)
+	}
+	own := &Message{&Message_Checkpoint{&Checkpoint{ReplicaId: 0, SequenceNumber: 10}}}
+	for i := 0; i < 3; i++ {
+		LegacyShim{instance}.RecvMsgSync(own, 0)
+	}
+	if len(queued) != 4 {
+		t.Errorf("Expected one checkpoint of replica 1 and all of our own to be queued, got %d events", len(queued))
+	}
+	if count := instance.Metrics.rateLimited.Value() - dropped; count != 2 {
+		t.Errorf("Expected two rate limited messages to be counted, got %d", count)
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// noteContact records that a message from another replica arrived. If none
+// arrived for longer than the outage timeout, the replica assumes it was cut
+// off from the network, and asks the others for their stable checkpoint
+// certificates, so that it learns at once whether it fell behind, rather than
+// only once f+1 replicas happen to send checkpoints above its watermarks. A
+// replica rebuilding its corrupt message log asks as soon as it hears from
+// another replica
+func (instance *PbftCore) noteContact(sender uint64) {
+	if sender == instance.ID {
+		return
+	}
+	if instance.rebuilding && instance.chkptReplies == nil {
+		instance.log.Info("Heard from replica %d, requesting checkpoint certificates to rebuild its message log", sender)
+		instance.requestCheckpointCerts()
+	}
+	if instance.outageTimeout == 0 {
+		return
+	}
+	now := instance.Clock.Now()
+	silent := now.Sub(instance.lastContact)
+	instance.lastContact = now
+	if silent > instance.outageTimeout {
+		instance.log.Info("Heard from replica %d after %v without contact, requesting checkpoint certificates", sender, silent)
+		instance.sendWireHello()
+		instance.requestCheckpointCerts()
+	}
+}
+
+// requestCheckpointCerts asks every replica for its stable checkpoint certificate
+func (instance *PbftCore) requestCheckpointCerts() {
+	instance.chkptReplies = make(map[uint64]*Checkpoint)
+	instance.reliableBroadcast(&Message{&Message_CheckpointRequest{&CheckpointRequest{
+		ReplicaId: instance.ID,
+		H:         instance.h,
+	}}})
+}
+
+// recvCheckpointRequest sends our stable checkpoint, its certificate and our
+// high watermark to the requesting replica, if the checkpoint is above its low watermark
+func (instance *PbftCore) recvCheckpointRequest(cr *CheckpointRequest) error {
+	instance.log.Debug("Received checkpoint request from replica %d, h %d", cr.ReplicaId, cr.H)
+
+	id, ok := instance.chkpts[instance.h]
+	if !ok || instance.h <= cr.H {
+		return nil
+	}
+
+	reply := &CheckpointReply{
+		ReplicaId: instance.ID,
+		Stable: &Checkpoint{
+			SequenceNumber:  instance.h,
+			ReplicaId:       instance.ID,
+			Id:              id,
+			DigestAlgorithm: instance.Digest.name(),
+			Epoch:           instance.epoch,
+		},
+		HighWatermark: instance.h + instance.L,
+	}
+	if cert := instance.thresholdCert(instance.h, id); cert != nil && instance.Wire.version(cr.ReplicaId) >= thresholdWireVersion {
+		reply.ThresholdCertificate = cert
+	} else {
+		for _, chkpt := range instance.stableCert {
+			if chkpt.SequenceNumber == instance.h {
+				reply.Certificate = append(reply.Certificate, chkpt)
+			}
+		}
+	}
+
+	msgRaw, err := proto.Marshal(&Message{&Message_CheckpointReply{reply}})
+	if err != nil {
+		return fmt.Errorf("Cannot marshal checkpoint reply for replica %d: %s", cr.ReplicaId, err)
+	}
+	return instance.reliableUnicast(msgRaw, cr.ReplicaId)
+}
+
+// recvCheckpointReply collects the stable checkpoints of the replicas which
+// answered our checkpoint request. Only the replying replica is authenticated,
+// so the certificate it sends along is checked for consistency, but a stable
+// checkpoint is only acted upon once f+1 replicas reported it, or a valid
+// threshold certificate came along, which proves it stable. If it is above
+// our last execution, we fell behind while disconnected, and move our
+// watermarks and start state transfer to it at once
+func (instance *PbftCore) recvCheckpointReply(reply *CheckpointReply) error {
+	stable := reply.Stable
+	if instance.chkptReplies == nil || stable == nil {
+		return nil
+	}
+	instance.log.Debug("Received checkpoint reply from replica %d, stable checkpoint %d, high watermark %d",
+		reply.ReplicaId, stable.SequenceNumber, reply.HighWatermark)
+
+	if stable.ReplicaId != reply.ReplicaId {
+		return fmt.Errorf("Replica %d received a checkpoint reply from replica %d carrying the checkpoint of replica %d", instance.ID, reply.ReplicaId, stable.ReplicaId)
+	}
+	if err := checkDigestAlgorithm(instance.Digest, stable.DigestAlgorithm); err != nil {
+		return fmt.Errorf("Replica %d rejecting checkpoint reply from replica %d: %s", instance.ID, reply.ReplicaId, err)
+	}
+	if stable.Epoch != instance.epoch {
+		if stable.Epoch > instance.epoch {
+			instance.recvLaterEpochCheckpoint(stable)
+		}
+		return nil
+	}
+	if len(reply.Certificate) > 0 {
+		if err := instance.checkStableCert(stable, reply.Certificate); err != nil {
+			return fmt.Errorf("Replica %d rejecting checkpoint reply from replica %d: %s", instance.ID, reply.ReplicaId, err)
+		}
+	}
+	certified := false
+	if cert := reply.ThresholdCertificate; cert != nil && instance.threshold != nil {
+		if err := instance.threshold.check(stable, cert); err != nil {
+			return fmt.Errorf("Replica %d rejecting checkpoint reply from replica %d: %s", instance.ID, reply.ReplicaId, err)
+		}
+		certified = true
+	}
+	if stable.SequenceNumber <= instance.h {
+		return nil
+	}
+
+	instance.chkptReplies[reply.ReplicaId] = stable
+
+	var replicas []uint64
+	for replicaID, chkpt := range instance.chkptReplies {
+		if chkpt.SequenceNumber == stable.SequenceNumber && chkpt.Id == stable.Id {
+			replicas = append(replicas, replicaID)
+		}
+	}
+	if len(replicas) < instance.F+1 && !certified {
+		return nil
+	}
+	instance.chkptReplies = nil
+
+	if stable.SequenceNumber <= instance.LastExec {
+		instance.log.Debug("Already executed through the stable checkpoint %d of the network", stable.SequenceNumber)
+		if instance.rebuilding {
+			// our ledger is intact, only our watermarks were lost with the message log
+			instance.log.Info("Rebuilt its message log from the stable checkpoint %d of the network", stable.SequenceNumber)
+			instance.rebuilding = false
+			instance.chkpts[stable.SequenceNumber] = stable.Id
+			instance.moveWatermarks(stable.SequenceNumber)
+		}
+		return nil
+	}
+	instance.rebuilding = false
+
+	snapshotID, err := base64.StdEncoding.DecodeString(stable.Id)
+	if nil != err {
+		return fmt.Errorf("Replica %d received a stable checkpoint which could not be decoded (%s)", instance.ID, stable.Id)
+	}
+
+	instance.log.Warning("Out of date, the network reports stable checkpoint %d but only executed through %d", stable.SequenceNumber, instance.LastExec)
+	instance.reqStore = make(map[string]*Request) // Discard all our requests, as we will never know which were executed
+	instance.moveWatermarks(stable.SequenceNumber)
+	instance.OutstandingReqs = make(map[string]*Request)
+	instance.AdaptiveTimeout.Reset()
+	instance.Phases.Reset()
+	instance.SkipInProgress = true
+	instance.consumer.InvalidateState()
+	instance.StopTimer()
+
+	instance.rollbackSpeculation()
+	instance.rankByCheckpoint(replicas)
+	instance.consumer.SkipTo(stable.SequenceNumber, snapshotID, replicas)
+	return nil
+}
+
+// checkStableCert verifies that cert holds matching checkpoints of a quorum of distinct replicas, including the one stable came from
+func (instance *PbftCore) checkStableCert(stable *Checkpoint, cert []*Checkpoint) error {
+	replicas := make(map[uint64]bool)
+	for _, chkpt := range cert {
+		if chkpt.SequenceNumber != stable.SequenceNumber || chkpt.Id != stable.Id {
+			return fmt.Errorf("certificate for checkpoint %d holds checkpoint %d from replica %d with a different digest or sequence number",
+				stable.SequenceNumber, chkpt.SequenceNumber, chkpt.ReplicaId)
+		}
+		replicas[chkpt.ReplicaId] = true
+	}
+	if !replicas[stable.ReplicaId] {
+		return fmt.Errorf("certificate for checkpoint %d lacks the checkpoint of replica %d itself", stable.SequenceNumber, stable.ReplicaId)
+	}
+	if len(replicas) < instance.intersectionQuorum() {
+		return fmt.Errorf("certificate for checkpoint %d holds checkpoints of %d replicas, %d are needed", stable.SequenceNumber, len(replicas), instance.intersectionQuorum())
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestReconnectRequestsCheckpointCerts(t *testing.T) {
+	var requests []*CheckpointRequest
+	skipped := uint64(0)
+	mock := &omniProto{
+		broadcastImpl: func(msgPayload []byte) {
+			msg := &Message{}
+			if err := proto.Unmarshal(msgPayload, msg); err != nil {
+				t.Fatalf("Could not unmarshal broadcast message: %s", err)
+			}
+			if cr := msg.GetCheckpointRequest(); cr != nil {
+				requests = append(requests, cr)
+			}
+		},
+		invalidateStateImpl: func() {},
+		skipToImpl: func(s uint64, id []byte, replicas []uint64) {
+			if string(id) != "ten" {
+				t.Errorf("Expected to skip to snapshot %q, got %q", "ten", id)
+			}
+			if len(replicas) != 2 {
+				t.Errorf("Expected the two replicas which reported the checkpoint as sources, got %v", replicas)
+			}
+			skipped = s
+		},
+	}
+	instance := newPbftCore(3, loadConfig(), mock)
+	defer instance.Close()
+	// the outage ended long ago, and the following messages arrive well within
+	// the timeout however slowly they are processed
+	instance.outageTimeout = time.Hour
+	instance.lastContact = time.Now().Add(-2 * time.Hour)
+
+	id := base64.StdEncoding.EncodeToString([]byte("ten"))
+	chkpt := func(replica uint64) *Checkpoint {
+		return &Checkpoint{SequenceNumber: 10, ReplicaId: replica, Id: id, DigestAlgorithm: instance.Digest.name()}
+	}
+
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_Checkpoint{chkpt(0)}}, sender: 0})
+	if len(requests) != 1 || requests[0].H != 0 {
+		t.Fatalf("Expected a checkpoint request after the outage, got %v", requests)
+	}
+
+	// A reply whose certificate holds a conflicting checkpoint is ignored
+	forged := chkpt(2)
+	forged.Id = "forged"
+	reply := &CheckpointReply{ReplicaId: 0, Stable: chkpt(0), HighWatermark: 50, Certificate: []*Checkpoint{chkpt(0), chkpt(1), forged}}
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_CheckpointReply{reply}}, sender: 0})
+
+	reply = &CheckpointReply{ReplicaId: 0, Stable: chkpt(0), HighWatermark: 50, Certificate: []*Checkpoint{chkpt(0), chkpt(1), chkpt(2)}}
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_CheckpointReply{reply}}, sender: 0})
+	if skipped != 0 {
+		t.Fatalf("Expected a single reply not to trigger state transfer")
+	}
+
+	reply = &CheckpointReply{ReplicaId: 1, Stable: chkpt(1), HighWatermark: 50}
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_CheckpointReply{reply}}, sender: 1})
+	if skipped != 10 {
+		t.Fatalf("Expected f+1 replies to trigger state transfer to seqNo 10, skipped to %d", skipped)
+	}
+	if instance.h != 10 || !instance.SkipInProgress {
+		t.Errorf("Expected the low watermark to move to 10 while state transfer is in progress, got h %d", instance.h)
+	}
+	if instance.chkptReplies != nil {
+		t.Errorf("Expected the checkpoint request to be complete")
+	}
+
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_Checkpoint{chkpt(2)}}, sender: 2})
+	if len(requests) != 1 {
+		t.Errorf("Expected no further checkpoint request while in contact, got %d", len(requests))
+	}
+}
+
+func TestCheckpointRequestReply(t *testing.T) {
+	var replies []*CheckpointReply
+	mock := &omniProto{
+		broadcastImpl: func(msgPayload []byte) {},
+		unicastImpl: func(msgPayload []byte, receiverID uint64) error {
+			msg := &Message{}
+			if err := proto.Unmarshal(msgPayload, msg); err != nil {
+				t.Fatalf("Could not unmarshal unicast message: %s", err)
+			}
+			if receiverID != 3 {
+				t.Errorf("Expected the reply to be sent to replica 3, sent to %d", receiverID)
+			}
+			replies = append(replies, msg.GetCheckpointReply())
+			return nil
+		},
+	}
+	instance := newPbftCore(1, loadConfig(), mock)
+	defer instance.Close()
+
+	instance.chkpts[10] = "ten"
+	instance.LastExec = 10
+	for _, replica := range []uint64{0, 1, 2} {
+		sendEvent(instance, &Checkpoint{SequenceNumber: 10, ReplicaId: replica, Id: "ten", DigestAlgorithm: instance.Digest.name()})
+	}
+	if instance.h != 10 {
+		t.Fatalf("Expected checkpoint 10 to be stable, h is %d", instance.h)
+	}
+
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_CheckpointRequest{&CheckpointRequest{ReplicaId: 3, H: 10}}}, sender: 3})
+	if len(replies) != 0 {
+		t.Fatalf("Expected no reply to a replica which is not behind")
+	}
+
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_CheckpointRequest{&CheckpointRequest{ReplicaId: 3, H: 0}}}, sender: 3})
+	if len(replies) != 1 {
+		t.Fatalf("Expected a reply to the checkpoint request, got %d", len(replies))
+	}
+	reply := replies[0]
+	if reply.Stable.SequenceNumber != 10 || reply.Stable.Id != "ten" || reply.HighWatermark != 10+instance.L {
+		t.Errorf("Unexpected checkpoint reply %+v", reply)
+	}
+	if err := instance.checkStableCert(reply.Stable, reply.Certificate); err != nil {
+		t.Errorf("Expected the reply to carry a valid certificate: %s", err)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// recordHeaderSize is the size of the receive time and sender which precede
+// the message in a record
+const recordHeaderSize = 16
+
+// recorder appends the consensus messages a replica receives from the other
+// replicas to a file, along with the time they were received, so that an
+// incident can be replayed offline against a fresh instance. Each record is
+// prefixed by its length as a uvarint, and sealed with a checksum like the
+// records of the WAL. All methods may be called on a nil recorder, which
+// records nothing
+type recorder struct {
+	lock  sync.Mutex
+	clock Clock
+	path  string
+	file  *os.File
+	out   *bufio.Writer
+}
+
+// recordedMessage is a message read back from a recording
+type recordedMessage struct {
+	at     time.Time
+	sender uint64
+	msg    *Message
+}
+
+// newRecorder returns nil if general.record.file is empty, and an error if
+// the file cannot be opened for appending
+func newRecorder(config *viper.Viper, clk Clock) (*recorder, error) {
+	path := config.GetString("general.record.file")
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open the message recording: %s", err)
+	}
+	return &recorder{
+		clock: clk,
+		path:  path,
+		file:  file,
+		out:   bufio.NewWriter(file),
+	}, nil
+}
+
+// record appends msg from sender to the recording. Records are flushed as
+// they are written, so that the recording survives a crash of the replica
+func (r *recorder) record(sender uint64, msg *Message) {
+	if r == nil {
+		return
+	}
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		logger.Warning("Could not record message from replica %d: %s", sender, err)
+		return
+	}
+	body := make([]byte, recordHeaderSize, recordHeaderSize+len(raw))
+	binary.BigEndian.PutUint64(body, uint64(r.clock.Now().UnixNano()))
+	binary.BigEndian.PutUint64(body[8:], sender)
+	sealed := sealWALRecord(append(body, raw...))
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.file == nil {
+		return
+	}
+	length := make([]byte, binary.MaxVarintLen64)
+	r.out.Write(length[:binary.PutUvarint(length, uint64(len(sealed)))])
+	r.out.Write(sealed)
+	if err := r.out.Flush(); err != nil {
+		logger.Warning("Could not write the message recording %s, recording stopped: %s", r.path, err)
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+// close flushes and closes the recording
+func (r *recorder) close() {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.file == nil {
+		return
+	}
+	r.out.Flush()
+	r.file.Close()
+	r.file = nil
+}
+
+// readRecording decodes the messages of a recording in the order they were
+// received. It stops at the first damaged or truncated record, as the last
+// one may be when the replica crashed, and returns the records before it
+// along with the error
+func readRecording(in io.Reader) ([]*recordedMessage, error) {
+	reader := bufio.NewReader(in)
+	var records []*recordedMessage
+	for {
+		length, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, fmt.Errorf("record %d is truncated: %s", len(records), err)
+		}
+		sealed := make([]byte, length)
+		if _, err := io.ReadFull(reader, sealed); err != nil {
+			return records, fmt.Errorf("record %d is truncated: %s", len(records), err)
+		}
+		body, err := openWALRecord(sealed)
+		if err == nil && len(body) < recordHeaderSize {
+			err = fmt.Errorf("record of %d bytes is too short to hold a header", len(body))
+		}
+		if err != nil {
+			return records, fmt.Errorf("record %d is damaged: %s", len(records), err)
+		}
+		msg := &Message{}
+		if err := proto.Unmarshal(body[recordHeaderSize:], msg); err != nil {
+			return records, fmt.Errorf("record %d does not hold a message: %s", len(records), err)
+		}
+		records = append(records, &recordedMessage{
+			at:     time.Unix(0, int64(binary.BigEndian.Uint64(body))),
+			sender: binary.BigEndian.Uint64(body[8:]),
+			msg:    msg,
+		})
+	}
+}
+
+// replay feeds records into instance in the order they were received. The
+// virtual clock of instance is moved to the time each message was received
+// before it is delivered, so that the timers of instance fire as they did on
+// the recorded replica
+func replay(instance *PbftCore, vc *virtualClock, records []*recordedMessage) {
+	for _, rec := range records {
+		if d := rec.at.Sub(vc.Now()); d > 0 {
+			vc.advance(d)
+		}
+		instance.Manager.Queue() <- pbftMessageEvent{msg: rec.msg, sender: rec.sender}
+	}
+}
+
+// replayRecording creates a fresh instance for replica id, on a virtual
+// clock starting at the time of the first record, and replays the recording
+// at path into it, for debugging an incident offline. The config should be
+// the one of the recorded replica, recording is disabled for the replay. The
+// instance is returned for inspection, and must be closed by the caller
+func replayRecording(path string, id uint64, config *viper.Viper, consumer InnerStack) (*PbftCore, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records, err := readRecording(file)
+	if err != nil {
+		logger.Warning("Replaying the %d records before the end of the recording: %s", len(records), err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("Recording %s holds no messages", path)
+	}
+
+	config.Set("general.record.file", "")
+	vc := newVirtualClock(records[0].at)
+	instance := newPbftCoreWithClock(id, config, consumer, vc)
+	instance.Manager.Start()
+	replay(instance, vc, records)
+	return instance, nil
+}
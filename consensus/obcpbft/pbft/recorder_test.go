@@ -0,0 +1,134 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func makeRecorder(t *testing.T, clk Clock) (*recorder, string) {
+	dir, err := ioutil.TempDir("", "pbft-record")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	config := loadConfig()
+	config.Set("general.record.file", filepath.Join(dir, "messages"))
+	r, err := newRecorder(config, clk)
+	if err != nil {
+		t.Fatalf("Could not create recorder: %s", err)
+	}
+	return r, r.path
+}
+
+func TestRecordAndRead(t *testing.T) {
+	start := time.Unix(1000, 0)
+	vc := newVirtualClock(start)
+	r, path := makeRecorder(t, vc)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	msgs := []*Message{
+		{&Message_Request{createPbftRequestWithChainTx(1, 1)}},
+		{&Message_Prepare{&Prepare{View: 0, SequenceNumber: 1, RequestDigest: "digest", ReplicaId: 2}}},
+		{&Message_Commit{&Commit{View: 0, SequenceNumber: 1, RequestDigest: "digest", ReplicaId: 3}}},
+	}
+	for i, msg := range msgs {
+		r.record(uint64(i+1), msg)
+		vc.advance(time.Second)
+	}
+	r.close()
+	r.record(9, msgs[0]) // ignored once closed
+
+	file, _ := os.Open(path)
+	records, err := readRecording(file)
+	file.Close()
+	if err != nil {
+		t.Fatalf("Could not read recording: %s", err)
+	}
+	if len(records) != len(msgs) {
+		t.Fatalf("Expected %d records, got %d", len(msgs), len(records))
+	}
+	for i, rec := range records {
+		if rec.sender != uint64(i+1) || !rec.at.Equal(start.Add(time.Duration(i)*time.Second)) || !proto.Equal(rec.msg, msgs[i]) {
+			t.Errorf("Record %d does not match the recorded message: %+v", i, rec)
+		}
+	}
+
+	// a record cut short by a crash ends the recording
+	raw, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, raw[:len(raw)-3], 0600)
+	file, _ = os.Open(path)
+	records, err = readRecording(file)
+	file.Close()
+	if err == nil || len(records) != len(msgs)-1 {
+		t.Errorf("Expected the records before the truncated one and an error, got %d records and %v", len(records), err)
+	}
+}
+
+func TestRecordDisabled(t *testing.T) {
+	r, err := newRecorder(loadConfig(), wallClock{})
+	if err != nil || r != nil {
+		t.Fatalf("Expected no recorder by default, got %v, %v", r, err)
+	}
+	r.record(1, &Message{&Message_Request{createPbftRequestWithChainTx(1, 1)}})
+	r.close()
+}
+
+func TestReplayRecording(t *testing.T) {
+	net := makePBFTNetwork(4, nil)
+	r, path := makeRecorder(t, wallClock{})
+	defer os.RemoveAll(filepath.Dir(path))
+	net.pbftEndpoints[3].pbft.recorder = r
+
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(1, 0)
+	net.Process()
+	net.Stop()
+	if net.pbftEndpoints[3].sc.executions != 1 {
+		t.Fatalf("Expected the recorded replica to execute the request")
+	}
+
+	config := loadConfig()
+	config.Set("general.N", 4)
+	config.Set("general.f", 1)
+	rc := &discardConsumer{&simpleConsumer{}}
+	instance, err := replayRecording(path, 3, config, rc)
+	if err != nil {
+		t.Fatalf("Could not replay recording: %s", err)
+	}
+	defer instance.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		processed := make(chan uint64)
+		instance.Manager.Queue() <- WorkEvent(func() { processed <- instance.LastExec })
+		if <-processed == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the replayed instance to execute the recorded request")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rc.executions != 1 {
+		t.Errorf("Expected one execution, got %d", rc.executions)
+	}
+}
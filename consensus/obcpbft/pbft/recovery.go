@@ -0,0 +1,140 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// recoveryEvent is sent when the proactive recovery timer expires
+type recoveryEvent struct{}
+
+// scheduleRecovery arms the recovery timer. The first recovery of replica i
+// happens after (i+1)/N of the period, so that replicas recover one at a
+// time, subsequent recoveries are one period apart
+func (instance *PbftCore) scheduleRecovery(first bool) {
+	if instance.recoveryPeriod == 0 {
+		return
+	}
+	delay := instance.recoveryPeriod
+	if first {
+		delay = delay * time.Duration(instance.ID+1) / time.Duration(instance.N)
+	}
+	instance.recoveryTimer.Reset(delay, recoveryEvent{})
+}
+
+// recover discards the in-memory message log and rebuilds it from the
+// persisted one, starting from the persisted stable checkpoint. It then
+// re-derives its session keys, and asks the other replicas to send their
+// messages for the sequence numbers it has not executed yet again, so that
+// a compromised replica can only affect the network until its next recovery
+func (instance *PbftCore) recover() {
+	instance.scheduleRecovery(false)
+
+	if !instance.ActiveView || instance.SkipInProgress || instance.CurrentExec != nil {
+		instance.log.Info("Postponing proactive recovery, it is changing views, catching up or executing")
+		return
+	}
+
+	instance.log.Info("Starting proactive recovery")
+	h := instance.h
+	instance.certStore = make(map[msgID]*msgCert)
+	instance.checkpointStore = make(map[Checkpoint]bool)
+	instance.chkpts = make(map[uint64]string)
+	instance.chkpts[0] = "XXX GENESIS"
+	instance.hChkpts = make(map[uint64]uint64)
+	instance.pset = make(map[uint64]*ViewChange_PQ)
+	instance.qset = make(map[qidx]*ViewChange_PQ)
+	instance.restoreState()
+	if instance.h < h {
+		// the stable checkpoint was reached through state transfer, and never persisted
+		instance.moveWatermarks(h)
+	}
+
+	if instance.auth != nil {
+		instance.rotateSessionKey(true)
+	}
+
+	for idx, cert := range instance.certStore {
+		if idx.n <= instance.LastExec || cert.digest == "" {
+			continue
+		}
+		if _, ok := instance.reqStore[cert.digest]; !ok {
+			instance.missingReqs[cert.digest] = true
+		}
+	}
+	instance.fetchRequests()
+
+	instance.Metrics.recoveries.Inc()
+	instance.innerBroadcast(&Message{&Message_RecoveryRequest{&RecoveryRequest{
+		ReplicaId: instance.ID,
+		H:         instance.h,
+		LastExec:  instance.LastExec,
+	}}})
+}
+
+// recvRecoveryRequest sends our own checkpoints and our own messages for the
+// sequence numbers the recovering replica has not executed yet again
+func (instance *PbftCore) recvRecoveryRequest(rr *RecoveryRequest) error {
+	instance.log.Debug("Received recovery request from replica %d, h %d, lastExec %d",
+		rr.ReplicaId, rr.H, rr.LastExec)
+
+	var msgs []*Message
+	for n, id := range instance.chkpts {
+		if n > rr.H {
+			msgs = append(msgs, &Message{&Message_Checkpoint{&Checkpoint{
+				SequenceNumber:  n,
+				ReplicaId:       instance.ID,
+				Id:              id,
+				DigestAlgorithm: instance.Digest.name(),
+				Epoch:           instance.epoch,
+			}}})
+		}
+	}
+	for idx, cert := range instance.certStore {
+		if idx.v != instance.View || idx.n <= rr.LastExec {
+			continue
+		}
+		if p := cert.prePrepare; p != nil && p.ReplicaId == instance.ID {
+			msgs = append(msgs, &Message{&Message_PrePrepare{p}})
+		}
+		for _, p := range cert.prepare {
+			if p.ReplicaId == instance.ID {
+				msgs = append(msgs, &Message{&Message_Prepare{p}})
+			}
+		}
+		for _, c := range cert.commit {
+			if c.ReplicaId == instance.ID {
+				msgs = append(msgs, &Message{&Message_Commit{c}})
+			}
+		}
+	}
+
+	for _, msg := range msgs {
+		msgRaw, err := proto.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("Cannot marshal message for recovering replica %d: %s", rr.ReplicaId, err)
+		}
+		if err := instance.consumer.Unicast(msgRaw, rr.ReplicaId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
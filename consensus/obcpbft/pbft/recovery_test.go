@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+)
+
+func TestRecoveryRestoresMessageLog(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.Stop()
+
+	msg := createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- msg
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	p := net.pbftEndpoints[2].pbft
+	digest := HashReq(p.Digest, msg)
+	cert := p.certStore[msgID{0, 1}]
+	if cert == nil || len(cert.commit) != 4 {
+		t.Fatalf("Expected replica 2 to hold a commit certificate for seqNo 1")
+	}
+
+	// A compromised replica tampers with its message log
+	p.Manager.Queue() <- WorkEvent(func() {
+		p.certStore = make(map[msgID]*msgCert)
+		p.certStore[msgID{0, 2}] = &msgCert{digest: "forged"}
+	})
+	p.Manager.Queue() <- recoveryEvent{}
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	if _, ok := p.certStore[msgID{0, 2}]; ok {
+		t.Errorf("Expected the recovery to discard the forged certificate")
+	}
+	cert = p.certStore[msgID{0, 1}]
+	if cert == nil || cert.digest != digest || len(cert.commit) != 4 {
+		t.Errorf("Expected the recovery to restore the commit certificate for seqNo 1, got %+v", cert)
+	}
+	if p.LastExec != 1 || net.pbftEndpoints[2].sc.executions != 1 {
+		t.Errorf("Expected replica 2 to still have executed seqNo 1 once, got lastExec %d and %d executions", p.LastExec, net.pbftEndpoints[2].sc.executions)
+	}
+}
+
+func TestRecoveryFetchesMissingCertificates(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.Stop()
+
+	net.Split(map[string][]int{"majority": {0, 1, 2}, "cutoff": {3}})
+
+	msg := createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- msg
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	if net.pbftEndpoints[3].sc.executions != 0 {
+		t.Fatalf("Expected replica 3 to be cut off")
+	}
+
+	net.Heal()
+	recoveries := net.pbftEndpoints[3].pbft.Metrics.recoveries.Value()
+	net.pbftEndpoints[3].pbft.Manager.Queue() <- recoveryEvent{}
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 1 {
+			t.Errorf("Instance %d executed %d requests, expected 1", pep.ID, pep.sc.executions)
+		}
+	}
+	if p := net.pbftEndpoints[3].pbft; p.Metrics.recoveries.Value() != recoveries+1 {
+		t.Errorf("Expected replica 3 to count its recovery")
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// relayWireVersion is the first wire version whose replicas understand relay bundles
+const relayWireVersion uint32 = 7
+
+// relayIdx identifies the votes for a request at a view and sequence number
+type relayIdx struct {
+	v      uint64
+	n      uint64
+	digest string
+}
+
+// relayBuffer holds the votes an aggregator collected for a request
+type relayBuffer struct {
+	prepares     []*Prepare
+	commits      []*Commit
+	sentPrepares bool
+	sentCommits  bool
+}
+
+// voteRelay replaces the all-to-all broadcast of prepares and commits, which
+// takes O(N²) messages per request, with a relay through a few aggregators.
+// Every replica sends its votes to the aggregators of the view only, which
+// forward the votes for a request in a single bundle to all replicas, once
+// they hold a quorum of them. The votes keep the MAC authenticators of their
+// senders, so an aggregator cannot forge them, and with at least f+1
+// aggregators one of them is correct and forwards every quorum. In signature
+// mode the votes carry no authenticator of their own, which is why relaying
+// requires the mac authentication mode
+type voteRelay struct {
+	aggregators int
+	buffers     map[relayIdx]*relayBuffer
+}
+
+// newVoteRelay returns nil if general.relay.aggregators is 0
+func newVoteRelay(config *viper.Viper) (*voteRelay, error) {
+	aggregators := config.GetInt("general.relay.aggregators")
+	if aggregators < 0 {
+		return nil, fmt.Errorf("Number of relay aggregators must not be negative, got %d", aggregators)
+	}
+	if aggregators == 0 {
+		return nil, nil
+	}
+	if mode := strings.ToLower(config.GetString("general.authentication.mode")); mode != "mac" {
+		return nil, fmt.Errorf("Relaying votes requires the mac authentication mode, got %s", mode)
+	}
+	return &voteRelay{
+		aggregators: aggregators,
+		buffers:     make(map[relayIdx]*relayBuffer),
+	}, nil
+}
+
+// prune discards the votes at or below the low watermark h
+func (vr *voteRelay) prune(h uint64) {
+	for idx := range vr.buffers {
+		if idx.n <= h {
+			delete(vr.buffers, idx)
+		}
+	}
+}
+
+func (vr *voteRelay) buffer(idx relayIdx) *relayBuffer {
+	b, ok := vr.buffers[idx]
+	if !ok {
+		b = &relayBuffer{}
+		vr.buffers[idx] = b
+	}
+	return b
+}
+
+// =============================================================================
+// pbftCore glue
+// =============================================================================
+
+// relaying returns whether votes are relayed, which needs every replica to
+// understand relay bundles
+func (instance *PbftCore) relaying() bool {
+	return instance.relay != nil && instance.Wire.version() >= relayWireVersion
+}
+
+// aggregators returns the replicas aggregating the votes of view v, the
+// primary and the replicas following it
+func (instance *PbftCore) aggregators(v uint64) []uint64 {
+	aggregators := make([]uint64, instance.relay.aggregators)
+	for i := range aggregators {
+		aggregators[i] = (instance.Primary(v) + uint64(i)) % uint64(instance.replicaCount)
+	}
+	return aggregators
+}
+
+func (instance *PbftCore) isAggregator(v uint64, replicaID uint64) bool {
+	for _, id := range instance.aggregators(v) {
+		if id == replicaID {
+			return true
+		}
+	}
+	return false
+}
+
+// broadcastVote sends our prepare or commit for view v to the aggregators of
+// v, or to all replicas if we aggregate v ourselves or votes are not relayed
+func (instance *PbftCore) broadcastVote(v uint64, msg *Message) error {
+	if !instance.relaying() || instance.isAggregator(v, instance.ID) {
+		return instance.innerBroadcast(msg)
+	}
+	msgRaw, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal vote: %s", err)
+	}
+	for _, id := range instance.aggregators(v) {
+		instance.consumer.Unicast(msgRaw, id)
+	}
+	return nil
+}
+
+// relayPrepare collects a prepare we aggregate, and forwards the prepares
+// for its request once they suffice for the receivers to prepare it
+func (instance *PbftCore) relayPrepare(prep *Prepare) {
+	if !instance.relaying() || !instance.isAggregator(prep.View, instance.ID) {
+		return
+	}
+	b := instance.relay.buffer(relayIdx{prep.View, prep.SequenceNumber, prep.RequestDigest})
+	if b.sentPrepares {
+		return
+	}
+	b.prepares = append(b.prepares, prep)
+	if len(b.prepares) < instance.intersectionQuorum()-1 {
+		return
+	}
+	b.sentPrepares = true
+	instance.log.Debug("Relaying %d prepares for view=%d/seqNo=%d", len(b.prepares), prep.View, prep.SequenceNumber)
+	instance.forwardBundle(&RelayBundle{ReplicaId: instance.ID, Prepares: b.prepares})
+}
+
+// relayCommit collects a commit we aggregate, and forwards the commits for
+// its request once they suffice for the receivers to commit it
+func (instance *PbftCore) relayCommit(commit *Commit) {
+	if !instance.relaying() || !instance.isAggregator(commit.View, instance.ID) {
+		return
+	}
+	b := instance.relay.buffer(relayIdx{commit.View, commit.SequenceNumber, commit.RequestDigest})
+	if b.sentCommits {
+		return
+	}
+	b.commits = append(b.commits, commit)
+	if len(b.commits) < instance.intersectionQuorum() {
+		return
+	}
+	b.sentCommits = true
+	instance.log.Debug("Relaying %d commits for view=%d/seqNo=%d", len(b.commits), commit.View, commit.SequenceNumber)
+	instance.forwardBundle(&RelayBundle{ReplicaId: instance.ID, Commits: b.commits})
+}
+
+func (instance *PbftCore) forwardBundle(rb *RelayBundle) {
+	if err := instance.innerBroadcast(&Message{&Message_RelayBundle{rb}}); err != nil {
+		instance.log.Error("Cannot forward relay bundle: %s", err)
+	}
+}
+
+// recvRelayBundle processes the votes an aggregator forwarded. Votes we
+// already hold are skipped silently, as every aggregator forwards them, and
+// votes failing authentication are dropped on their own, as a faulty sender
+// may address a valid MAC to the aggregator only. Without MAC authenticators
+// nothing proves a relayed vote came from its sender, so we drop the bundle
+func (instance *PbftCore) recvRelayBundle(rb *RelayBundle) error {
+	if instance.relay == nil || instance.auth == nil {
+		instance.log.Warning("Dropping relay bundle from replica %d, we do not relay votes", rb.ReplicaId)
+		return nil
+	}
+	for _, prep := range rb.Prepares {
+		if !instance.isAggregator(prep.View, rb.ReplicaId) || instance.filterEpoch(prep, prep.ReplicaId) == nil {
+			continue
+		}
+		if cert, ok := instance.certStore[msgID{prep.View, prep.SequenceNumber}]; ok && hasPrepare(cert, prep.ReplicaId) {
+			continue
+		}
+		if err := instance.checkAuthenticator(prep); err != nil {
+			instance.log.Warning("Dropping prepare from replica %d relayed by replica %d: %s", prep.ReplicaId, rb.ReplicaId, err)
+			continue
+		}
+		if err := instance.recvPrepare(prep); err != nil {
+			return err
+		}
+	}
+	for _, commit := range rb.Commits {
+		if !instance.isAggregator(commit.View, rb.ReplicaId) || instance.filterEpoch(commit, commit.ReplicaId) == nil {
+			continue
+		}
+		if cert, ok := instance.certStore[msgID{commit.View, commit.SequenceNumber}]; ok && hasCommit(cert, commit.ReplicaId) {
+			continue
+		}
+		if err := instance.checkAuthenticator(commit); err != nil {
+			instance.log.Warning("Dropping commit from replica %d relayed by replica %d: %s", commit.ReplicaId, rb.ReplicaId, err)
+			continue
+		}
+		if err := instance.recvCommit(commit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasPrepare(cert *msgCert, replicaID uint64) bool {
+	for _, prep := range cert.prepare {
+		if prep.ReplicaId == replicaID {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCommit(cert *msgCert, replicaID uint64) bool {
+	for _, commit := range cert.commit {
+		if commit.ReplicaId == replicaID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/hyperledger/fabric/consensus/testkit"
+)
+
+// voteMonitor records the prepares and commits which bypassed the
+// aggregators of view 0, and counts the bundles they forwarded
+type voteMonitor struct {
+	testkit.NopMonitor
+	lock        sync.Mutex
+	aggregators map[int]bool
+	bypassed    []string
+	bundles     int
+}
+
+func (vm *voteMonitor) Delivered(src int, dst int, payload []byte) error {
+	vm.lock.Lock()
+	defer vm.lock.Unlock()
+	kind, _, seqNo, ok := ClassifyMessage(payload)
+	if !ok {
+		return nil
+	}
+	switch kind {
+	case "prepare", "commit":
+		if !vm.aggregators[src] && !vm.aggregators[dst] {
+			vm.bypassed = append(vm.bypassed, fmt.Sprintf("%s for seqNo %d from %d to %d", kind, seqNo, src, dst))
+		}
+	case "relaybundle":
+		vm.bundles++
+	}
+	return nil
+}
+
+// helloAll has the replicas of net exchange their wire hellos
+func helloAll(t *testing.T, net *pbftNetwork) {
+	for _, pep := range net.pbftEndpoints {
+		pep.pbft.Manager.Queue() <- RestoredEvent{}
+	}
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+}
+
+func TestRelayNetwork(t *testing.T) {
+	config := macConfig()
+	config.Set("general.relay.aggregators", 3)
+	net := makePBFTNetwork(7, config)
+	defer net.Stop()
+	helloAll(t, net)
+
+	vm := &voteMonitor{aggregators: map[int]bool{0: true, 1: true, 2: true}}
+	net.Attach(vm)
+	for i := int64(1); i <= 3; i++ {
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(i, 0)
+		if err := net.Process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 3 {
+			t.Errorf("Instance %d executed %d requests, expected 3", pep.ID, pep.sc.executions)
+		}
+	}
+	if len(vm.bypassed) != 0 {
+		t.Errorf("Expected all votes to pass through the aggregators, got %v", vm.bypassed)
+	}
+	if vm.bundles == 0 {
+		t.Errorf("Expected the aggregators to forward bundles")
+	}
+}
+
+func TestRelayBundle(t *testing.T) {
+	config := macConfig()
+	config.Set("general.relay.aggregators", 2)
+	instance := newPbftCore(3, config, &omniProto{
+		broadcastImpl: func(msgPayload []byte) {},
+		unicastImpl:   func(msgPayload []byte, receiverID uint64) error { return nil },
+		signImpl:      func(msg []byte) ([]byte, error) { return msg, nil },
+		verifyImpl: func(senderID uint64, signature []byte, message []byte) error {
+			if !bytes.Equal(signature, message) {
+				return fmt.Errorf("bad signature")
+			}
+			return nil
+		},
+	})
+	defer instance.Close()
+
+	if instance.relaying() {
+		t.Fatalf("Expected votes to be broadcast before all replicas speak a wire version which relays them")
+	}
+	for _, id := range []uint64{0, 1, 2} {
+		sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_WireHello{newWireNegotiator(id, 4).hello(true)}}, sender: id})
+	}
+	if !instance.relaying() {
+		t.Fatalf("Expected votes to be relayed once all replicas speak a wire version which relays them")
+	}
+
+	bundle := &RelayBundle{ReplicaId: 2, Prepares: []*Prepare{
+		signedPrepare(&Prepare{View: 0, SequenceNumber: 1, RequestDigest: "foo", ReplicaId: 1}),
+		signedPrepare(&Prepare{View: 0, SequenceNumber: 1, RequestDigest: "foo", ReplicaId: 2}),
+		{View: 0, SequenceNumber: 1, RequestDigest: "foo", ReplicaId: 0, Authenticator: &Authenticator{Signature: []byte("forged")}},
+	}}
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_RelayBundle{bundle}}, sender: 2})
+	if cert, ok := instance.certStore[msgID{0, 1}]; ok && len(cert.prepare) != 0 {
+		t.Fatalf("Expected the bundle of replica 2, which does not aggregate view 0, to be ignored, got %v", cert.prepare)
+	}
+
+	bundle.ReplicaId = 1
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_RelayBundle{bundle}}, sender: 1})
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_RelayBundle{bundle}}, sender: 1})
+	if cert := instance.certStore[msgID{0, 1}]; cert == nil || len(cert.prepare) != 2 {
+		t.Fatalf("Expected the authenticated relayed prepares to be recorded once, got %v", cert)
+	}
+}
+
+// signedPrepare attaches the signature the mock stack of TestRelayBundle accepts
+func signedPrepare(prep *Prepare) *Prepare {
+	raw, _ := prep.serialize()
+	prep.Authenticator = &Authenticator{Signature: raw}
+	return prep
+}
+
+func TestRelayRequiresMACs(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.relay.aggregators", 2)
+	if _, err := newVoteRelay(config); err == nil {
+		t.Fatalf("Expected relaying to be refused in signature mode")
+	}
+
+	// A faulty primary aggregating view 0 forges a commit quorum, which a
+	// replica in signature mode must not accept
+	instance := newPbftCore(3, loadConfig(), &omniProto{
+		broadcastImpl: func(msgPayload []byte) {},
+		unicastImpl:   func(msgPayload []byte, receiverID uint64) error { return nil },
+	})
+	defer instance.Close()
+
+	bundle := &RelayBundle{ReplicaId: 0}
+	for id := uint64(0); id < 3; id++ {
+		bundle.Commits = append(bundle.Commits, &Commit{View: 0, SequenceNumber: 1, RequestDigest: "forged", ReplicaId: id})
+	}
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_RelayBundle{bundle}}, sender: 0})
+	if cert, ok := instance.certStore[msgID{0, 1}]; ok && len(cert.commit) != 0 {
+		t.Fatalf("Expected the forged commits relayed by the primary to be dropped, got %v", cert.commit)
+	}
+}
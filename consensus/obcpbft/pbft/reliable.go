@@ -0,0 +1,191 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// ackTimerEvent is sent when the retransmission timer expires
+type ackTimerEvent struct{}
+
+// ackWireVersion is the first wire version whose replicas acknowledge
+// critical messages
+const ackWireVersion uint32 = 2
+
+type ackKey struct {
+	receiver uint64
+	digest   string
+}
+
+// unackedMsg is a critical message its receiver did not acknowledge yet
+type unackedMsg struct {
+	msgRaw  []byte
+	retries int  // retransmissions left
+	fresh   bool // sent after the timer was started, it is only retransmitted after the next expiry
+}
+
+// reliableSender retransmits view-change, new-view and catch-up messages to
+// the replicas which did not acknowledge them, so that a single lost message
+// does not cost a whole view change timeout
+type reliableSender struct {
+	timeout time.Duration
+	retries int
+	timer   EventTimer
+	unacked map[ackKey]*unackedMsg
+}
+
+// newReliableSender returns nil if general.reliable.timeout is 0
+func newReliableSender(config *viper.Viper) (*reliableSender, error) {
+	timeout, err := time.ParseDuration(config.GetString("general.reliable.timeout"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse retransmission timeout: %s", err)
+	}
+	retries := config.GetInt("general.reliable.retries")
+	if retries < 0 {
+		return nil, fmt.Errorf("Retransmissions must not be negative, got %d", retries)
+	}
+	if timeout == 0 {
+		return nil, nil
+	}
+	return &reliableSender{
+		timeout: timeout,
+		retries: retries,
+		unacked: make(map[ackKey]*unackedMsg),
+	}, nil
+}
+
+// isCriticalMessage returns whether msg is acknowledged by its receivers
+func isCriticalMessage(msg *Message) bool {
+	return msg.GetViewChange() != nil || msg.GetNewView() != nil ||
+		msg.GetCheckpointRequest() != nil || msg.GetCheckpointReply() != nil ||
+		msg.GetCatchUpRequest() != nil || msg.GetCatchUpCert() != nil
+}
+
+// enableReliableSend retransmits critical messages until they are
+// acknowledged, it must be called before the event manager is started
+func (instance *PbftCore) enableReliableSend(config *viper.Viper) error {
+	rs, err := newReliableSender(config)
+	if err != nil || rs == nil {
+		return err
+	}
+	instance.log.Info("PBFT critical messages retransmitted every %v, at most %d times", rs.timeout, rs.retries)
+	rs.timer = newClockedEventTimerFactory(instance.Manager, instance.Clock).createTimer()
+	instance.reliable = rs
+	return nil
+}
+
+// reliableBroadcast broadcasts msg, and retransmits it to every replica
+// which does not acknowledge it
+func (instance *PbftCore) reliableBroadcast(msg *Message) error {
+	if err := instance.innerBroadcast(msg); err != nil {
+		return err
+	}
+	if instance.reliable == nil {
+		return nil
+	}
+	msgRaw, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal message: %s", err)
+	}
+	for i := 0; i < instance.N; i++ {
+		if uint64(i) != instance.ID {
+			instance.expectAck(msgRaw, uint64(i))
+		}
+	}
+	return nil
+}
+
+// reliableUnicast sends msgRaw to the receiver, and retransmits it until the
+// receiver acknowledges it
+func (instance *PbftCore) reliableUnicast(msgRaw []byte, receiverID uint64) error {
+	if err := instance.consumer.Unicast(msgRaw, receiverID); err != nil {
+		return err
+	}
+	instance.expectAck(msgRaw, receiverID)
+	return nil
+}
+
+// expectAck retransmits msgRaw to the receiver until it is acknowledged,
+// replicas which speak an older wire version never acknowledge messages
+func (instance *PbftCore) expectAck(msgRaw []byte, receiverID uint64) {
+	rs := instance.reliable
+	if rs == nil || instance.Wire.version(receiverID) < ackWireVersion {
+		return
+	}
+	key := ackKey{receiverID, base64.StdEncoding.EncodeToString(instance.Digest.hash(msgRaw))}
+	rs.unacked[key] = &unackedMsg{msgRaw: msgRaw, retries: rs.retries, fresh: true}
+	rs.timer.SoftReset(rs.timeout, ackTimerEvent{})
+}
+
+// acknowledge sends an ack for a critical message to its sender
+func (instance *PbftCore) acknowledge(msg *Message, senderID uint64) {
+	if !isCriticalMessage(msg) || instance.Wire.version(senderID) < ackWireVersion {
+		return
+	}
+	msgRaw, err := proto.Marshal(msg)
+	if err != nil {
+		instance.log.Error("Cannot marshal message to acknowledge: %s", err)
+		return
+	}
+	ackRaw, err := proto.Marshal(&Message{&Message_Ack{&Ack{
+		ReplicaId: instance.ID,
+		Digest:    instance.Digest.hash(msgRaw),
+	}}})
+	if err != nil {
+		instance.log.Error("Cannot marshal ack: %s", err)
+		return
+	}
+	instance.consumer.Unicast(ackRaw, senderID)
+}
+
+func (instance *PbftCore) recvAck(ack *Ack) error {
+	if instance.reliable == nil {
+		return nil
+	}
+	delete(instance.reliable.unacked, ackKey{ack.ReplicaId, base64.StdEncoding.EncodeToString(ack.Digest)})
+	return nil
+}
+
+// retransmit sends the messages which were not acknowledged within a whole
+// timeout again, and gives up on those out of retransmissions
+func (instance *PbftCore) retransmit() {
+	rs := instance.reliable
+	for key, m := range rs.unacked {
+		if m.fresh {
+			m.fresh = false
+			continue
+		}
+		if m.retries == 0 {
+			instance.log.Warning("Giving up on the acknowledgement of a message by replica %d", key.receiver)
+			delete(rs.unacked, key)
+			continue
+		}
+		m.retries--
+		instance.log.Debug("Retransmitting unacknowledged message to replica %d", key.receiver)
+		instance.Metrics.retransmissions.Inc()
+		instance.consumer.Unicast(m.msgRaw, key.receiver)
+	}
+	if len(rs.unacked) > 0 {
+		rs.timer.Reset(rs.timeout, ackTimerEvent{})
+	}
+}
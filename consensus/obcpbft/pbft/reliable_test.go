@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// reliableTestCore returns replica 0, which talks acks with replicas 1 and
+// 2, but not with replica 3, and the messages it unicast
+func reliableTestCore(t *testing.T) (*PbftCore, *[]uint64, *[]*Message) {
+	var receivers []uint64
+	var sent []*Message
+	stack := &omniProto{
+		broadcastImpl: func(msgPayload []byte) {},
+		unicastImpl: func(msgPayload []byte, receiverID uint64) error {
+			msg := &Message{}
+			if err := proto.Unmarshal(msgPayload, msg); err != nil {
+				t.Fatalf("Could not unmarshal unicast message: %s", err)
+			}
+			if msg.GetWireHello() == nil {
+				receivers = append(receivers, receiverID)
+				sent = append(sent, msg)
+			}
+			return nil
+		},
+		signImpl:   func(msg []byte) ([]byte, error) { return msg, nil },
+		verifyImpl: func(senderID uint64, signature []byte, message []byte) error { return nil },
+	}
+	config := loadConfig()
+	config.Set("general.reliable.timeout", "1h")
+	config.Set("general.reliable.retries", 2)
+	p := newPbftCore(0, config, stack)
+	for _, id := range []uint64{1, 2} {
+		sendEvent(p, pbftMessageEvent{msg: &Message{&Message_WireHello{newWireNegotiator(id, 4).hello(true)}}, sender: id})
+	}
+	old := newWireNegotiator(3, 4)
+	old.max = 1
+	sendEvent(p, pbftMessageEvent{msg: &Message{&Message_WireHello{old.hello(true)}}, sender: 3})
+	return p, &receivers, &sent
+}
+
+func TestReliableRetransmitsUntilAcked(t *testing.T) {
+	p, receivers, _ := reliableTestCore(t)
+	defer p.Close()
+
+	msg := &Message{&Message_NewView{&NewView{View: 1, ReplicaId: 0}}}
+	p.reliableBroadcast(msg)
+	if len(p.reliable.unacked) != 2 {
+		t.Fatalf("Expected acks from the 2 replicas speaking wire version %d, awaiting %d", ackWireVersion, len(p.reliable.unacked))
+	}
+
+	sendEvent(p, ackTimerEvent{})
+	if len(*receivers) != 0 {
+		t.Fatalf("Expected no retransmission before a whole timeout passed, sent to %v", *receivers)
+	}
+	sendEvent(p, ackTimerEvent{})
+	if len(*receivers) != 2 {
+		t.Fatalf("Expected the new-view to be retransmitted to replicas 1 and 2, sent to %v", *receivers)
+	}
+
+	msgRaw, _ := proto.Marshal(msg)
+	ack := &Ack{ReplicaId: 1, Digest: p.Digest.hash(msgRaw)}
+	sendEvent(p, pbftMessageEvent{msg: &Message{&Message_Ack{ack}}, sender: 1})
+	*receivers = nil
+	sendEvent(p, ackTimerEvent{})
+	if len(*receivers) != 1 || (*receivers)[0] != 2 {
+		t.Fatalf("Expected only replica 2 to be retransmitted to once replica 1 acked, sent to %v", *receivers)
+	}
+
+	sendEvent(p, ackTimerEvent{})
+	if len(p.reliable.unacked) != 0 {
+		t.Errorf("Expected to give up after the retransmissions were exhausted, still awaiting %d acks", len(p.reliable.unacked))
+	}
+}
+
+func TestReliableAcknowledgesCriticalMessages(t *testing.T) {
+	p, receivers, sent := reliableTestCore(t)
+	defer p.Close()
+
+	vc := &Message{&Message_ViewChange{&ViewChange{View: 1, ReplicaId: 1}}}
+	sendEvent(p, pbftMessageEvent{msg: vc, sender: 1})
+	if len(*sent) != 1 || (*sent)[0].GetAck() == nil || (*receivers)[0] != 1 {
+		t.Fatalf("Expected an ack to the sender of the view-change, sent %v", *sent)
+	}
+	vcRaw, _ := proto.Marshal(vc)
+	if ack := (*sent)[0].GetAck(); ack.ReplicaId != 0 || !bytes.Equal(ack.Digest, p.Digest.hash(vcRaw)) {
+		t.Errorf("Expected the ack to carry the digest of the view-change, got %v", ack)
+	}
+
+	*sent = nil
+	sendEvent(p, pbftMessageEvent{msg: &Message{&Message_ViewChange{&ViewChange{View: 1, ReplicaId: 3}}}, sender: 3})
+	sendEvent(p, pbftMessageEvent{msg: &Message{&Message_Prepare{&Prepare{View: 0, SequenceNumber: 1, ReplicaId: 2}}}, sender: 2})
+	for _, msg := range *sent {
+		if msg.GetAck() != nil {
+			t.Errorf("Expected no ack to a replica speaking an old wire version, nor for a prepare")
+		}
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+)
+
+// logSubsystems are the parts of the core which can be logged at a level of
+// their own, through general.logging.<subsystem>. Their lines are logged to
+// the module consensus/obcpbft/<subsystem>
+var logSubsystems = []string{"viewchange", "checkpoint", "statetransfer"}
+
+// contextless logs the lines of a nil replicaLogger
+var contextless = callerLogger("consensus/obcpbft")
+
+// replicaLogger logs the lines of a replica prefixed with its context, i.e.
+// its ID, its current view and the sequence number the line is about, in the
+// form "replica=1 view=0 seqNo=12 | <message>", so that the logs of several
+// replicas can be merged and filtered. The sequence number is the one passed
+// to at, else the one of the message the replica is processing, and omitted
+// if there is none. Lines are logged at the level of the subsystem if one was
+// configured, else at the level of consensus/obcpbft. A nil replicaLogger
+// logs through the package logger, without context
+type replicaLogger struct {
+	module   *logging.Logger
+	level    *logging.Level // level of the subsystem, nil to follow consensus/obcpbft
+	instance *PbftCore
+	seqNo    uint64 // sequence number set through at, 0 if none
+}
+
+// newReplicaLoggers creates the logger of the core, and those of the
+// subsystems keyed by their names
+func newReplicaLoggers(instance *PbftCore, config *viper.Viper) (*replicaLogger, map[string]*replicaLogger, error) {
+	core := newReplicaLogger(instance, "consensus/obcpbft", nil)
+
+	subsystems := make(map[string]*replicaLogger)
+	for _, name := range logSubsystems {
+		var level *logging.Level
+		if configured := config.GetString("general.logging." + name); configured != "" {
+			l, err := logging.LogLevel(configured)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid level %q of log subsystem %s: %s", configured, name, err)
+			}
+			level = &l
+		}
+		subsystems[name] = newReplicaLogger(instance, "consensus/obcpbft/"+name, level)
+		// the replicaLogger checks the level of the subsystem, as only it
+		// knows whether the subsystem follows the level of the package. The
+		// level is only set when it differs, as replicas may be created while
+		// others are logging
+		if logging.GetLevel("consensus/obcpbft/"+name) != logging.DEBUG {
+			logging.SetLevel(logging.DEBUG, "consensus/obcpbft/"+name)
+		}
+	}
+	return core, subsystems, nil
+}
+
+func newReplicaLogger(instance *PbftCore, module string, level *logging.Level) *replicaLogger {
+	return &replicaLogger{module: callerLogger(module), level: level, instance: instance}
+}
+
+// callerLogger returns a logger of module which reports the caller of the
+// replicaLogger as the origin of a line, rather than the replicaLogger
+func callerLogger(module string) *logging.Logger {
+	l := logging.MustGetLogger(module)
+	l.ExtraCalldepth = 3
+	return l
+}
+
+// at returns a logger whose lines are about sequence number n
+func (rl *replicaLogger) at(n uint64) *replicaLogger {
+	if rl == nil {
+		return nil
+	}
+	scoped := *rl
+	scoped.seqNo = n
+	return &scoped
+}
+
+func (rl *replicaLogger) enabled(level logging.Level) bool {
+	if rl.level != nil {
+		return level <= *rl.level
+	}
+	return logger.IsEnabledFor(level)
+}
+
+// context renders the context of a line
+func (rl *replicaLogger) context() string {
+	instance := rl.instance
+	fields := []string{fmt.Sprintf("replica=%d", instance.ID), fmt.Sprintf("view=%d", instance.View)}
+	if seqNo := rl.seqNo; seqNo != 0 {
+		fields = append(fields, fmt.Sprintf("seqNo=%d", seqNo))
+	} else if seqNo, ok := eventSeqNo(instance.currentEvent); ok {
+		fields = append(fields, fmt.Sprintf("seqNo=%d", seqNo))
+	}
+	return strings.Join(fields, " ")
+}
+
+func (rl *replicaLogger) log(level logging.Level, format string, args ...interface{}) {
+	if rl == nil {
+		logAt(contextless, level, format, args...)
+		return
+	}
+	if !rl.enabled(level) {
+		return
+	}
+	logAt(rl.module, level, "%s | %s", rl.context(), fmt.Sprintf(format, args...))
+}
+
+func logAt(l *logging.Logger, level logging.Level, format string, args ...interface{}) {
+	switch level {
+	case logging.CRITICAL:
+		l.Critical(format, args...)
+	case logging.ERROR:
+		l.Error(format, args...)
+	case logging.WARNING:
+		l.Warning(format, args...)
+	case logging.NOTICE:
+		l.Notice(format, args...)
+	case logging.INFO:
+		l.Info(format, args...)
+	default:
+		l.Debug(format, args...)
+	}
+}
+
+// Critical logs a line at level CRITICAL
+func (rl *replicaLogger) Critical(format string, args ...interface{}) {
+	rl.log(logging.CRITICAL, format, args...)
+}
+
+// Error logs a line at level ERROR
+func (rl *replicaLogger) Error(format string, args ...interface{}) {
+	rl.log(logging.ERROR, format, args...)
+}
+
+// Warning logs a line at level WARNING
+func (rl *replicaLogger) Warning(format string, args ...interface{}) {
+	rl.log(logging.WARNING, format, args...)
+}
+
+// Notice logs a line at level NOTICE
+func (rl *replicaLogger) Notice(format string, args ...interface{}) {
+	rl.log(logging.NOTICE, format, args...)
+}
+
+// Info logs a line at level INFO
+func (rl *replicaLogger) Info(format string, args ...interface{}) {
+	rl.log(logging.INFO, format, args...)
+}
+
+// Debug logs a line at level DEBUG
+func (rl *replicaLogger) Debug(format string, args ...interface{}) {
+	rl.log(logging.DEBUG, format, args...)
+}
+
+// eventSeqNo returns the sequence number the event is about, if any
+func eventSeqNo(event interface{}) (uint64, bool) {
+	switch et := event.(type) {
+	case pbftMessageEvent:
+		if msg := et.msg; msg != nil {
+			if preprep := msg.GetPrePrepare(); preprep != nil {
+				return preprep.SequenceNumber, true
+			} else if prep := msg.GetPrepare(); prep != nil {
+				return prep.SequenceNumber, true
+			} else if commit := msg.GetCommit(); commit != nil {
+				return commit.SequenceNumber, true
+			} else if chkpt := msg.GetCheckpoint(); chkpt != nil {
+				return chkpt.SequenceNumber, true
+			}
+		}
+	case *PrePrepare:
+		return et.SequenceNumber, true
+	case *Prepare:
+		return et.SequenceNumber, true
+	case *Commit:
+		return et.SequenceNumber, true
+	case *Checkpoint:
+		return et.SequenceNumber, true
+	case StateUpdatingEvent:
+		return et.SeqNo, true
+	case StateUpdatedEvent:
+		return et.SeqNo, true
+	case execDoneEvent:
+		return et.seqNo, true
+	}
+	return 0, false
+}
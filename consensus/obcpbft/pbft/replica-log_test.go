@@ -0,0 +1,97 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+
+	"github.com/op/go-logging"
+)
+
+// loggedLines returns the lines logged to backend after the first skip, by
+// module
+func loggedLines(backend *logging.MemoryBackend, skip int) map[string][]string {
+	lines := make(map[string][]string)
+	for n := backend.Head(); n != nil; n = n.Next() {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		lines[n.Record.Module] = append(lines[n.Record.Module], n.Record.Message())
+	}
+	return lines
+}
+
+func TestReplicaLogContext(t *testing.T) {
+	backend := logging.InitForTesting(logging.INFO)
+	defer logging.SetLevel(logging.DEBUG, "consensus/obcpbft")
+
+	config := loadConfig()
+	config.Set("general.logging.checkpoint", "warning")
+	config.Set("general.logging.viewchange", "debug")
+	instance := newPbftCore(1, config, &discardConsumer{&simpleConsumer{}})
+	defer instance.Close()
+	instance.View = 3
+	skip := 0
+	for _, l := range loggedLines(backend, 0) {
+		skip += len(l)
+	}
+
+	instance.log.Info("no sequence number")
+	instance.log.at(5).Info("sequence number %d", 5)
+	instance.currentEvent = pbftMessageEvent{msg: &Message{&Message_Prepare{&Prepare{SequenceNumber: 7}}}, sender: 2}
+	instance.log.Info("processing a prepare")
+	instance.currentEvent = nil
+	instance.log.Debug("below the level of the package")
+	instance.chkptLog.Info("below the level of the subsystem")
+	instance.chkptLog.Warning("at the level of the subsystem")
+	instance.vcLog.Debug("above the level of the package")
+	var nilLog *replicaLogger
+	nilLog.Info("without context")
+
+	lines := loggedLines(backend, skip)
+	expected := map[string][]string{
+		"consensus/obcpbft": {
+			"replica=1 view=3 | no sequence number",
+			"replica=1 view=3 seqNo=5 | sequence number 5",
+			"replica=1 view=3 seqNo=7 | processing a prepare",
+			"without context",
+		},
+		"consensus/obcpbft/checkpoint": {"replica=1 view=3 | at the level of the subsystem"},
+		"consensus/obcpbft/viewchange": {"replica=1 view=3 | above the level of the package"},
+	}
+	for module, want := range expected {
+		got := lines[module]
+		if len(got) != len(want) {
+			t.Errorf("Expected %d lines logged to %s, got %q", len(want), module, got)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Expected line %q logged to %s, got %q", want[i], module, got[i])
+			}
+		}
+	}
+}
+
+func TestReplicaLogInvalidLevel(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.logging.statetransfer", "verbose")
+	if _, _, err := newReplicaLoggers(&PbftCore{}, config); err == nil {
+		t.Error("Expected an invalid level to be rejected")
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// Replica is a PBFT replica embedded into a program other than the peer. It
+// orders the opaque payloads submitted to the replicas of its network, and
+// hands them to its Stack in the same order on every correct replica. Its
+// interfaces only use plain Go types, the peer consumes the same protocol
+// through the consenters of the obcpbft plugin, which adapt consensus.Stack
+type Replica interface {
+	Request(payload []byte) error                  // Submits payload to be ordered, validated by the Stack first
+	Receive(msg []byte, senderID uint64) error     // Delivers a message replica senderID sent through its Stack
+	StateUpdating(seqNo uint64, snapshotID []byte) // Reports that the state transfer started by Stack.SkipTo is under way
+	StateUpdated(seqNo uint64, snapshotID []byte)  // Reports that the state transfer started by Stack.SkipTo completed
+	Close()                                        // Stops the replica, it must not receive messages afterwards
+}
+
+// Stack is implemented by the program embedding a Replica. Unless otherwise
+// noted, its methods are called from the thread of the replica, and must not
+// wait for the replica to make progress
+type Stack interface {
+	Broadcast(msg []byte)                                      // Sends msg to every other replica, which passes it to Receive
+	Unicast(msg []byte, receiverID uint64) error               // Sends msg to replica receiverID, which passes it to Receive
+	Validate(payload []byte) error                             // Rejects payloads which must not be ordered
+	Execute(seqNo uint64, payload []byte) (state []byte)       // Called in order from the execution thread, returns the state it resulted in, or nil to have it read through GetState
+	GetState() []byte                                          // Identifies the state after the last execution, replicas checkpoint it
+	GetLastSeqNo() (uint64, error)                             // Sequence number of the last execution, an error if there was none
+	SkipTo(seqNo uint64, snapshotID []byte, replicas []uint64) // Transfers the state checkpointed at seqNo from replicas, reported through StateUpdating and StateUpdated
+	Sign(msg []byte) ([]byte, error)                           // Signs msg as this replica
+	Verify(senderID uint64, signature []byte, msg []byte) error
+	Persistor
+}
+
+// Persistor stores the state a replica recovers after a crash, a
+// consensus.StatePersistor satisfies it
+type Persistor interface {
+	StoreState(key string, value []byte) error
+	ReadState(key string) ([]byte, error)
+	ReadStateSet(prefix string) (map[string][]byte, error)
+	DelState(key string)
+}
+
+// BatchPersistor is implemented by Persistors which can store and delete
+// several keys in a single atomic write
+type BatchPersistor interface {
+	StoreStateBatch(store map[string][]byte, del []string) error
+}
+
+// StoreStateBatch stores and deletes the keys in a single atomic write if
+// the persistor supports it, and one key at a time otherwise
+func StoreStateBatch(persistor Persistor, store map[string][]byte, del []string) error {
+	if batcher, ok := persistor.(BatchPersistor); ok {
+		return batcher.StoreStateBatch(store, del)
+	}
+	for key, value := range store {
+		if err := persistor.StoreState(key, value); err != nil {
+			return err
+		}
+	}
+	for _, key := range del {
+		persistor.DelState(key)
+	}
+	return nil
+}
+
+// primaryObserver is implemented by Stacks which follow the primary of their
+// replica. It is invoked on the thread of the replica once the replica
+// restored its view, before NewReplica returns, and after every view change
+type primaryObserver interface {
+	primaryChanged(view uint64, primary uint64)
+}
+
+// embeddedReplica adapts a Stack to the innerStack of pbftCore, as the obc*
+// consenters adapt the consensus.Stack of the peer
+type embeddedReplica struct {
+	Persistor
+	stack Stack
+	pbft  LegacyShim
+}
+
+// NewReplica creates and starts replica id of the network described by
+// config and the options
+func NewReplica(id uint64, config *viper.Viper, stack Stack, opts ...Option) Replica {
+	er := &embeddedReplica{
+		Persistor: stack,
+		stack:     stack,
+	}
+	er.pbft = LegacyShim{NewPbftCore(id, config, er, opts...)}
+	er.pbft.Manager.Start()
+	er.pbft.Manager.Queue() <- RestoredEvent{}
+	if _, ok := stack.(primaryObserver); ok {
+		reported := make(chan struct{})
+		er.pbft.Inject(func() {
+			er.reportPrimary()
+			close(reported)
+		})
+		<-reported
+	}
+	return er
+}
+
+// reportPrimary tells a Stack which follows the primary about the current one
+func (er *embeddedReplica) reportPrimary() {
+	if observer, ok := er.stack.(primaryObserver); ok {
+		observer.primaryChanged(er.pbft.View, er.pbft.Primary(er.pbft.View))
+	}
+}
+
+// Request is necessary to implement Replica
+func (er *embeddedReplica) Request(payload []byte) error {
+	if err := er.stack.Validate(payload); err != nil {
+		return fmt.Errorf("Invalid request: %s", err)
+	}
+	req := &Request{Payload: payload, ReplicaId: er.pbft.ID, Priority: er.pbft.Scheduler.Classify(payload)}
+	pbftMsg := &Message{&Message_Request{req}}
+	packedPbftMsg, err := proto.Marshal(pbftMsg)
+	if err != nil {
+		return err
+	}
+	er.stack.Broadcast(packedPbftMsg)
+	return er.pbft.RecvMsgSync(pbftMsg, er.pbft.ID)
+}
+
+// Receive is necessary to implement Replica
+func (er *embeddedReplica) Receive(msg []byte, senderID uint64) error {
+	return er.pbft.Receive(msg, senderID)
+}
+
+// StateUpdating is necessary to implement Replica
+func (er *embeddedReplica) StateUpdating(seqNo uint64, snapshotID []byte) {
+	er.pbft.StateUpdating(seqNo, snapshotID)
+}
+
+// StateUpdated is necessary to implement Replica
+func (er *embeddedReplica) StateUpdated(seqNo uint64, snapshotID []byte) {
+	er.pbft.StateUpdated(seqNo, snapshotID)
+}
+
+// Close is necessary to implement Replica
+func (er *embeddedReplica) Close() {
+	er.pbft.Close()
+}
+
+// =============================================================================
+// innerStack interface (functions called by pbft-core)
+// =============================================================================
+
+func (er *embeddedReplica) Broadcast(msgPayload []byte) {
+	er.stack.Broadcast(msgPayload)
+}
+
+func (er *embeddedReplica) Unicast(msgPayload []byte, receiverID uint64) error {
+	return er.stack.Unicast(msgPayload, receiverID)
+}
+
+func (er *embeddedReplica) Execute(seqNo uint64, payload []byte, done ExecCallback) {
+	done(er.stack.Execute(seqNo, payload))
+}
+
+func (er *embeddedReplica) GetState() []byte {
+	return er.stack.GetState()
+}
+
+func (er *embeddedReplica) GetLastSeqNo() (uint64, error) {
+	return er.stack.GetLastSeqNo()
+}
+
+func (er *embeddedReplica) SkipTo(seqNo uint64, snapshotID []byte, replicas []uint64) {
+	er.stack.SkipTo(seqNo, snapshotID, replicas)
+}
+
+func (er *embeddedReplica) ValidateRequest(payload []byte) error {
+	return er.stack.Validate(payload)
+}
+
+func (er *embeddedReplica) Sign(msg []byte) ([]byte, error) {
+	return er.stack.Sign(msg)
+}
+
+func (er *embeddedReplica) Verify(senderID uint64, signature []byte, msg []byte) error {
+	return er.stack.Verify(senderID, signature, msg)
+}
+
+// embedders have no ledger which rejects queries while its state is stale
+func (er *embeddedReplica) InvalidateState() {}
+func (er *embeddedReplica) ValidateState()   {}
+
+func (er *embeddedReplica) ViewChange(curView uint64) {
+	er.reportPrimary()
+}
+
+// embedders are not told about evidence or reply certificates
+func (er *embeddedReplica) ReportEvidence(ev *Evidence)                     {}
+func (er *embeddedReplica) Executed(payload []byte, cert *ReplyCertificate) {}
+
+func (er *embeddedReplica) ExecuteQuery(payload []byte) ([]byte, error) {
+	return nil, fmt.Errorf("Replica %d does not answer queries", er.pbft.ID)
+}
@@ -0,0 +1,127 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStack is the Stack of an embedded replica, it delivers the messages of
+// the replica to the other replicas of its network asynchronously, as a
+// transport of the embedder would
+type memStack struct {
+	mockPersist
+	id       uint64
+	replicas []Replica
+	started  chan struct{} // closed once all replicas are created
+	lock     sync.Mutex
+	executed [][]byte
+	done     chan struct{}
+}
+
+func (ms *memStack) Broadcast(msg []byte) {
+	for id := range ms.replicas {
+		if uint64(id) != ms.id {
+			ms.Unicast(msg, uint64(id))
+		}
+	}
+}
+
+func (ms *memStack) Unicast(msg []byte, receiverID uint64) error {
+	go func() {
+		<-ms.started
+		ms.replicas[receiverID].Receive(msg, ms.id)
+	}()
+	return nil
+}
+
+func (ms *memStack) Validate(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("empty payload")
+	}
+	return nil
+}
+
+func (ms *memStack) Execute(seqNo uint64, payload []byte) []byte {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ms.executed = append(ms.executed, payload)
+	ms.done <- struct{}{}
+	return nil
+}
+
+func (ms *memStack) GetState() []byte {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return []byte(fmt.Sprintf("%d", len(ms.executed)))
+}
+
+func (ms *memStack) GetLastSeqNo() (uint64, error) {
+	return 0, fmt.Errorf("no execution yet")
+}
+
+func (ms *memStack) SkipTo(seqNo uint64, snapshotID []byte, replicas []uint64) {}
+
+func (ms *memStack) Sign(msg []byte) ([]byte, error) {
+	return msg, nil
+}
+
+func (ms *memStack) Verify(senderID uint64, signature []byte, msg []byte) error {
+	return nil
+}
+
+func TestEmbeddedReplicas(t *testing.T) {
+	N := 4
+	replicas := make([]Replica, N)
+	stacks := make([]*memStack, N)
+	done := make(chan struct{}, N)
+	started := make(chan struct{})
+	for id := range stacks {
+		stacks[id] = &memStack{id: uint64(id), replicas: replicas, started: started, done: done}
+	}
+	for id := range replicas {
+		replicas[id] = NewReplica(uint64(id), loadConfig(), stacks[id], WithN(N, 1))
+		defer replicas[id].Close()
+	}
+	close(started)
+
+	if err := replicas[2].Request(nil); err == nil {
+		t.Error("Expected a payload the stack rejects not to be ordered")
+	}
+	payload := []byte("opaque")
+	if err := replicas[2].Request(payload); err != nil {
+		t.Fatalf("Failed to submit request: %s", err)
+	}
+	for i := 0; i < N; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected all %d replicas to execute the request, %d did", N, i)
+		}
+	}
+	for id, ms := range stacks {
+		ms.lock.Lock()
+		if !reflect.DeepEqual(ms.executed, [][]byte{payload}) {
+			t.Errorf("Replica %d executed %q, expected the submitted payload", id, ms.executed)
+		}
+		ms.lock.Unlock()
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// sendReply sends a signed reply to the replica which submitted the request
+// we just executed
+func (instance *PbftCore) sendReply(idx msgID) {
+	if instance.replies == nil {
+		return
+	}
+	cert := instance.certStore[idx]
+	if cert == nil || cert.digest == "" {
+		return // null request
+	}
+	req, ok := instance.reqStore[cert.digest]
+	if !ok {
+		return
+	}
+
+	reply := &Reply{
+		View:           idx.v,
+		SequenceNumber: idx.n,
+		RequestDigest:  cert.digest,
+		Result:         instance.Digest.hash(instance.consumer.GetState()),
+		ReplicaId:      instance.ID,
+	}
+	if err := instance.Sign(reply); err != nil {
+		instance.log.Error("Could not sign reply for seqNo %d: %s", idx.n, err)
+		return
+	}
+
+	if req.ReplicaId == instance.ID {
+		if err := instance.recvReply(reply); err != nil {
+			instance.log.Error("Could not process its own reply for seqNo %d: %s", idx.n, err)
+		}
+		return
+	}
+	msgRaw, err := proto.Marshal(&Message{&Message_Reply{reply}})
+	if err != nil {
+		instance.log.Error("Could not marshal reply for seqNo %d: %s", idx.n, err)
+		return
+	}
+	instance.log.Debug("Sending reply for seqNo %d to replica %d", idx.n, req.ReplicaId)
+	instance.consumer.Unicast(msgRaw, req.ReplicaId)
+}
+
+// recvReply collects the replies to the requests we submitted, and hands the
+// execution certificate of a request to the consumer once f+1 replicas agree
+func (instance *PbftCore) recvReply(reply *Reply) error {
+	if instance.replies == nil {
+		return nil
+	}
+	req, ok := instance.awaitingReplies[reply.RequestDigest]
+	if !ok {
+		instance.log.Debug("Ignoring reply from replica %d for request %s it is not awaiting", reply.ReplicaId, reply.RequestDigest)
+		return nil
+	}
+
+	cert, err := instance.replies.Add(reply)
+	if err != nil || cert == nil {
+		return err
+	}
+	instance.log.Debug("Assembled execution certificate for request %s, seqNo %d", reply.RequestDigest, reply.SequenceNumber)
+	delete(instance.awaitingReplies, reply.RequestDigest)
+	instance.consumer.Executed(req.Payload, cert)
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+)
+
+func TestExecutionCertificate(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.replies", true)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.Stop()
+
+	broadcaster := generateBroadcaster(validatorCount)
+	msg := createPbftRequestWithChainTx(1, uint64(broadcaster))
+	for _, pep := range net.pbftEndpoints {
+		pep.pbft.Manager.Queue() <- msg
+	}
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.ID == uint64(broadcaster) {
+			continue
+		}
+		if len(pep.sc.certs) != 0 {
+			t.Errorf("Replica %d did not submit the request, but received an execution certificate", pep.ID)
+		}
+	}
+
+	pep := net.pbftEndpoints[broadcaster]
+	if len(pep.sc.certs) != 1 {
+		t.Fatalf("Expected the submitting replica to receive an execution certificate, got %d", len(pep.sc.certs))
+	}
+	reply, err := VerifyReplyCertificate(pep.sc.certs[0], pep.pbft.F, pep.sc.Verify)
+	if err != nil {
+		t.Fatalf("Expected the execution certificate to verify: %s", err)
+	}
+	if reply.SequenceNumber != 1 || reply.RequestDigest != HashReq(pep.pbft.Digest, msg) {
+		t.Errorf("Expected the certificate to attest the execution of the request at seqNo 1, got %v", reply)
+	}
+	if _, ok := pep.pbft.awaitingReplies[reply.RequestDigest]; ok {
+		t.Errorf("Expected the submitting replica to stop awaiting replies")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"time"
+)
+
+// expiryObserver is implemented by consumers which want to be notified when
+// pbftCore drops a request whose expiry passed before it was ordered. It is
+// invoked on the pbft event thread
+type expiryObserver interface {
+	RequestExpired(payload []byte)
+}
+
+// Expired returns whether the expiry the client attached to req passed, a
+// request without expiry never expires
+func (instance *PbftCore) Expired(req *Request) bool {
+	if req.Expiry == nil {
+		return false
+	}
+	expiry := time.Unix(req.Expiry.Seconds, int64(req.Expiry.Nanos))
+	return !instance.Clock.Now().Before(expiry)
+}
+
+// DropExpired accounts for the expired request req and notifies the consumer
+func (instance *PbftCore) DropExpired(req *Request, digest string) {
+	instance.log.Info("Dropping request %s, its expiry passed before it was ordered", ReqLabel(digest, req))
+	instance.Metrics.ExpiredReqs.Inc()
+	if observer, ok := instance.consumer.(expiryObserver); ok {
+		observer.RequestExpired(req.Payload)
+	}
+}
+
+// purgeExpiredRequests drops the outstanding requests whose expiry passed and
+// returns how many it dropped. Requests which were pre-prepared, or which a
+// view change may carry into the next view, are kept: the clocks of the
+// replicas do not agree, a request the primary assigned a sequence number to
+// must execute on every replica
+func (instance *PbftCore) purgeExpiredRequests() int {
+	var expired []string
+	for digest, req := range instance.OutstandingReqs {
+		if instance.Expired(req) {
+			expired = append(expired, digest)
+		}
+	}
+	if len(expired) == 0 {
+		return 0
+	}
+
+	assigned := make(map[string]bool)
+	for _, cert := range instance.certStore {
+		if cert.prePrepare != nil {
+			assigned[cert.digest] = true
+		}
+	}
+	for _, p := range instance.pset {
+		assigned[p.Digest] = true
+	}
+	for _, q := range instance.qset {
+		assigned[q.Digest] = true
+	}
+
+	purged := 0
+	for _, digest := range expired {
+		if assigned[digest] {
+			continue
+		}
+		req := instance.OutstandingReqs[digest]
+		delete(instance.OutstandingReqs, digest)
+		delete(instance.reqStore, digest)
+		delete(instance.awaitingReplies, digest)
+		instance.Phases.forget(digest)
+		instance.AdaptiveTimeout.requestDropped(digest)
+		instance.DropExpired(req, digest)
+		purged++
+	}
+	return purged
+}
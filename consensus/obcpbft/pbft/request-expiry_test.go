@@ -0,0 +1,128 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+
+	gp "google/protobuf"
+)
+
+// expiryConsumer records the payloads of the requests pbftCore dropped
+// because they expired
+type expiryConsumer struct {
+	*discardConsumer
+	expired [][]byte
+}
+
+func (ec *expiryConsumer) RequestExpired(payload []byte) {
+	ec.expired = append(ec.expired, payload)
+}
+
+// newExpiryReplica creates backup 1 of a network of 4 replicas on a virtual
+// clock, which starts at the Unix epoch
+func newExpiryReplica() (*PbftCore, *expiryConsumer, *virtualClock) {
+	clock := newVirtualClock(time.Unix(0, 0))
+	consumer := &expiryConsumer{discardConsumer: &discardConsumer{&simpleConsumer{}}}
+	return newPbftCoreWithClock(1, loadConfig(), consumer, clock), consumer, clock
+}
+
+func createExpiringRequest(iter int64, expiry int64) *Request {
+	req := createPbftRequestWithChainTx(iter, 0)
+	req.Expiry = &gp.Timestamp{Seconds: expiry}
+	return req
+}
+
+func TestExpiredRequestOnArrival(t *testing.T) {
+	instance, consumer, _ := newExpiryReplica()
+	defer instance.Close()
+
+	req := createExpiringRequest(1, 0)
+	sendEvent(instance, req)
+	if _, ok := instance.reqStore[HashReq(instance.Digest, req)]; ok {
+		t.Error("Expected a request which arrived expired not to be stored")
+	}
+	if len(consumer.expired) != 1 {
+		t.Errorf("Expected the consumer to be notified of 1 expired request, got %d", len(consumer.expired))
+	}
+	if v := instance.Metrics.ExpiredReqs.Value(); v != 1 {
+		t.Errorf("Expected 1 expired request to be counted, got %d", v)
+	}
+
+	sendEvent(instance, createExpiringRequest(2, 10))
+	if len(instance.OutstandingReqs) != 1 {
+		t.Errorf("Expected the request which did not expire yet to be outstanding")
+	}
+}
+
+func TestExpiredRequestsPurgedOnTimeout(t *testing.T) {
+	instance, consumer, clock := newExpiryReplica()
+	defer instance.Close()
+
+	sendEvent(instance, createExpiringRequest(1, 10))
+	sendEvent(instance, createExpiringRequest(2, 20))
+	clock.advance(25 * time.Second)
+
+	sendEvent(instance, viewChangeTimerEvent{})
+	if !instance.ActiveView || instance.View != 0 {
+		t.Errorf("Expected no view change once all outstanding requests expired, in view %d", instance.View)
+	}
+	if len(instance.OutstandingReqs) != 0 || len(instance.reqStore) != 0 || len(consumer.expired) != 2 {
+		t.Errorf("Expected the expired requests to be dropped, %d outstanding, %d stored and %d expired",
+			len(instance.OutstandingReqs), len(instance.reqStore), len(consumer.expired))
+	}
+	if instance.TimerActive {
+		t.Error("Expected the request timer to stay stopped without outstanding requests")
+	}
+}
+
+func TestExpiredRequestsPurgedBeforeViewChange(t *testing.T) {
+	instance, consumer, clock := newExpiryReplica()
+	defer instance.Close()
+
+	sendEvent(instance, createExpiringRequest(1, 10))
+	sendEvent(instance, createExpiringRequest(2, 20))
+	clock.advance(15 * time.Second)
+
+	sendEvent(instance, viewChangeTimerEvent{})
+	if instance.ActiveView || instance.View != 1 {
+		t.Errorf("Expected a view change for the request which did not expire, in view %d", instance.View)
+	}
+	if len(instance.OutstandingReqs) != 1 || len(consumer.expired) != 1 {
+		t.Errorf("Expected 1 of 2 requests to be purged, %d are outstanding and %d expired", len(instance.OutstandingReqs), len(consumer.expired))
+	}
+}
+
+func TestExpiredRequestKeptOncePrePrepared(t *testing.T) {
+	instance, consumer, clock := newExpiryReplica()
+	defer instance.Close()
+
+	req := createExpiringRequest(1, 10)
+	digest := HashReq(instance.Digest, req)
+	sendEvent(instance, req)
+	sendEvent(instance, &PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: digest, Request: req, ReplicaId: 0, DigestAlgorithm: instance.Digest.name()})
+	clock.advance(15 * time.Second)
+
+	sendEvent(instance, viewChangeTimerEvent{})
+	if instance.ActiveView || instance.View != 1 {
+		t.Errorf("Expected a view change for the pre-prepared request, in view %d", instance.View)
+	}
+	if _, ok := instance.reqStore[digest]; !ok || len(consumer.expired) != 0 {
+		t.Error("Expected the pre-prepared request to be kept, although it expired")
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+// RequestStatus is the progress of a request through the replica, the
+// consenters report it as a RequestStatus
+type RequestStatus struct {
+	Phase RequestPhase
+	SeqNo uint64 // sequence number the request was assigned, once it was pre-prepared
+}
+
+// RequestPhase is a phase a request passes through, in the order of the
+// phases of RequestPhase
+type RequestPhase int
+
+// The phases of a request, in the order it passes through them
+const (
+	RequestUnknown RequestPhase = iota
+	RequestQueued
+	RequestPrePrepared
+	RequestPrepared
+	RequestCommitted
+	RequestExecuted
+)
+
+// RequestStatus returns how far the request whose payload matches progressed
+// on the replica, it must only be called from the event thread. Requests are
+// forgotten once a stable checkpoint covers their execution, their status is
+// unknown afterwards
+func (instance *PbftCore) RequestStatus(match func(payload []byte) bool) *RequestStatus {
+	status := &RequestStatus{Phase: RequestUnknown}
+	for idx, cert := range instance.certStore {
+		req, ok := instance.reqStore[cert.digest]
+		if !ok || !match(req.Payload) {
+			continue
+		}
+		var phase RequestPhase
+		switch {
+		case instance.committed(cert.digest, idx.v, idx.n) && idx.n <= instance.LastExec:
+			phase = RequestExecuted
+		case instance.committed(cert.digest, idx.v, idx.n):
+			phase = RequestCommitted
+		case instance.prepared(cert.digest, idx.v, idx.n):
+			phase = RequestPrepared
+		case instance.prePrepared(cert.digest, idx.v, idx.n):
+			phase = RequestPrePrepared
+		default:
+			continue
+		}
+		if phase > status.Phase {
+			status.Phase = phase
+			status.SeqNo = idx.n
+		}
+	}
+	if status.Phase != RequestUnknown {
+		return status
+	}
+
+	for _, req := range instance.OutstandingReqs {
+		if match(req.Payload) {
+			status.Phase = RequestQueued
+			break
+		}
+	}
+	return status
+}
@@ -0,0 +1,55 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func matchPayload(payload []byte) func([]byte) bool {
+	return func(p []byte) bool {
+		return bytes.Equal(p, payload)
+	}
+}
+
+func TestRequestStatusPhases(t *testing.T) {
+	instance := newPbftCoreWithClock(1, loadConfig(), &discardConsumer{&simpleConsumer{}}, newVirtualClock(time.Unix(0, 0)))
+	defer instance.Close()
+
+	req := createPbftRequestWithChainTx(1, 0)
+	digest := HashReq(instance.Digest, req)
+	expect := func(phase RequestPhase, seqNo uint64) {
+		status := instance.RequestStatus(matchPayload(req.Payload))
+		if status.Phase != phase || status.SeqNo != seqNo {
+			t.Fatalf("Expected the request to be in phase %d at seqNo %d, is in phase %d at seqNo %d", phase, seqNo, status.Phase, status.SeqNo)
+		}
+	}
+
+	expect(RequestUnknown, 0)
+	sendEvent(instance, req)
+	expect(RequestQueued, 0)
+	sendEvent(instance, &PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: digest, Request: req, ReplicaId: 0, DigestAlgorithm: instance.Digest.name()})
+	expect(RequestPrePrepared, 1)
+	sendEvent(instance, &Prepare{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: 2})
+	expect(RequestPrepared, 1)
+
+	if status := instance.RequestStatus(matchPayload([]byte("other"))); status.Phase != RequestUnknown {
+		t.Errorf("Expected another request to be unknown, is in phase %d", status.Phase)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// adaptiveRequestTimeout derives the request timeout from the latencies
+// between the arrival and the commit of recent requests. The timeout is a
+// percentile of the last window latencies times a multiplier, bounded by a
+// floor and a ceiling. All methods may be called on a nil
+// adaptiveRequestTimeout, which tracks nothing
+type adaptiveRequestTimeout struct {
+	percentile float64
+	multiplier float64
+	floor      time.Duration
+	ceiling    time.Duration
+
+	latencies []time.Duration // ring buffer of the last observed latencies
+	next      int             // next position to write in latencies
+	full      bool            // whether latencies has wrapped around
+	arrivals  map[string]time.Time
+	clock     Clock // the clock the arrivals and commits are timed on
+}
+
+// newAdaptiveRequestTimeout reads the general.timeout.adaptive section of the
+// configuration, it returns nil if adaptive request timeouts are disabled
+func newAdaptiveRequestTimeout(config *viper.Viper, clk Clock) (*adaptiveRequestTimeout, error) {
+	if !config.GetBool("general.timeout.adaptive.enabled") {
+		return nil, nil
+	}
+
+	window := config.GetInt("general.timeout.adaptive.window")
+	if window <= 0 {
+		return nil, fmt.Errorf("adaptive request timeout window must be positive, got %d", window)
+	}
+	percentile := config.GetFloat64("general.timeout.adaptive.percentile")
+	if percentile <= 0 || percentile > 100 {
+		return nil, fmt.Errorf("adaptive request timeout percentile must be in (0, 100], got %v", percentile)
+	}
+	multiplier := config.GetFloat64("general.timeout.adaptive.multiplier")
+	if multiplier < 1 {
+		return nil, fmt.Errorf("adaptive request timeout multiplier must be at least 1, got %v", multiplier)
+	}
+	floor, err := time.ParseDuration(config.GetString("general.timeout.adaptive.floor"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse adaptive request timeout floor: %s", err)
+	}
+	ceiling, err := time.ParseDuration(config.GetString("general.timeout.adaptive.ceiling"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse adaptive request timeout ceiling: %s", err)
+	}
+	if floor <= 0 || ceiling < floor {
+		return nil, fmt.Errorf("adaptive request timeout bounds must satisfy 0 < floor <= ceiling, got floor %v and ceiling %v", floor, ceiling)
+	}
+
+	return &adaptiveRequestTimeout{
+		percentile: percentile,
+		multiplier: multiplier,
+		floor:      floor,
+		ceiling:    ceiling,
+		latencies:  make([]time.Duration, window),
+		arrivals:   make(map[string]time.Time),
+		clock:      clk,
+	}, nil
+}
+
+// requestArrived records the arrival of a request, only its first arrival counts
+func (art *adaptiveRequestTimeout) requestArrived(digest string) {
+	if art == nil {
+		return
+	}
+	if _, ok := art.arrivals[digest]; !ok {
+		art.arrivals[digest] = art.clock.Now()
+	}
+}
+
+// requestCommitted observes the latency of a request which arrived earlier
+func (art *adaptiveRequestTimeout) requestCommitted(digest string) {
+	if art == nil {
+		return
+	}
+	arrival, ok := art.arrivals[digest]
+	if !ok {
+		return
+	}
+	delete(art.arrivals, digest)
+	art.observe(art.clock.Now().Sub(arrival))
+}
+
+// requestDropped forgets the arrival of a request which will not commit,
+// without observing its latency
+func (art *adaptiveRequestTimeout) requestDropped(digest string) {
+	if art == nil {
+		return
+	}
+	delete(art.arrivals, digest)
+}
+
+// Reset forgets the arrivals of all requests, but keeps the observed latencies
+func (art *adaptiveRequestTimeout) Reset() {
+	if art == nil {
+		return
+	}
+	art.arrivals = make(map[string]time.Time)
+}
+
+func (art *adaptiveRequestTimeout) observe(latency time.Duration) {
+	art.latencies[art.next] = latency
+	art.next = (art.next + 1) % len(art.latencies)
+	if art.next == 0 {
+		art.full = true
+	}
+}
+
+// timeout returns the adapted request timeout, or staticTimeout until a full
+// window of latencies has been observed
+func (art *adaptiveRequestTimeout) timeout(staticTimeout time.Duration) time.Duration {
+	if art == nil || !art.full {
+		return staticTimeout
+	}
+
+	sorted := make(sortableDurationSlice, len(art.latencies))
+	copy(sorted, art.latencies)
+	sort.Sort(sorted)
+	index := int(math.Ceil(art.percentile/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+
+	timeout := time.Duration(float64(sorted[index]) * art.multiplier)
+	if timeout < art.floor {
+		return art.floor
+	}
+	if timeout > art.ceiling {
+		return art.ceiling
+	}
+	return timeout
+}
+
+type sortableDurationSlice []time.Duration
+
+func (a sortableDurationSlice) Len() int {
+	return len(a)
+}
+func (a sortableDurationSlice) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a sortableDurationSlice) Less(i, j int) bool {
+	return a[i] < a[j]
+}
@@ -0,0 +1,127 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRequestTimeoutDisabled(t *testing.T) {
+	config := loadConfig()
+	art, err := newAdaptiveRequestTimeout(config, wallClock{})
+	if err != nil {
+		t.Fatalf("Failed to read default configuration: %s", err)
+	}
+	if art != nil {
+		t.Fatalf("Adaptive request timeout should be disabled by default")
+	}
+
+	pbft := newPbftCore(0, config, &omniProto{})
+	defer pbft.Close()
+	pbft.RequestTimeout = 42 * time.Second
+	if timeout := pbft.GetRequestTimeout(); timeout != 42*time.Second {
+		t.Errorf("Expected the static request timeout, got %v", timeout)
+	}
+}
+
+func TestAdaptiveRequestTimeout(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.timeout.adaptive.enabled", true)
+	config.Set("general.timeout.adaptive.window", 10)
+	config.Set("general.timeout.adaptive.percentile", 90)
+	config.Set("general.timeout.adaptive.multiplier", 2)
+	config.Set("general.timeout.adaptive.floor", "50ms")
+	config.Set("general.timeout.adaptive.ceiling", "5s")
+	art, err := newAdaptiveRequestTimeout(config, wallClock{})
+	if err != nil {
+		t.Fatalf("Failed to create adaptive request timeout: %s", err)
+	}
+
+	static := time.Second
+	for i := 1; i < 10; i++ {
+		art.observe(time.Duration(i) * 100 * time.Millisecond)
+	}
+	if timeout := art.timeout(static); timeout != static {
+		t.Fatalf("Expected the static timeout before the window is full, got %v", timeout)
+	}
+
+	art.observe(time.Second)
+	if timeout := art.timeout(static); timeout != 1800*time.Millisecond {
+		t.Errorf("Expected twice the 90th percentile of 900ms, got %v", timeout)
+	}
+
+	for i := 0; i < 10; i++ {
+		art.observe(time.Millisecond)
+	}
+	if timeout := art.timeout(static); timeout != 50*time.Millisecond {
+		t.Errorf("Expected the timeout to be raised to the floor, got %v", timeout)
+	}
+
+	for i := 0; i < 10; i++ {
+		art.observe(time.Minute)
+	}
+	if timeout := art.timeout(static); timeout != 5*time.Second {
+		t.Errorf("Expected the timeout to be lowered to the ceiling, got %v", timeout)
+	}
+}
+
+func TestAdaptiveRequestTimeoutArrivals(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.timeout.adaptive.enabled", true)
+	config.Set("general.timeout.adaptive.window", 1)
+	clk := newVirtualClock(time.Unix(0, 0))
+	art, err := newAdaptiveRequestTimeout(config, clk)
+	if err != nil {
+		t.Fatalf("Failed to create adaptive request timeout: %s", err)
+	}
+
+	art.requestCommitted("unknown")
+	if art.full {
+		t.Fatalf("Committing a request which never arrived should not be observed")
+	}
+
+	art.requestArrived("forgotten")
+	art.Reset()
+	art.requestCommitted("forgotten")
+	if art.full {
+		t.Fatalf("Committing a request after a reset should not be observed")
+	}
+
+	art.requestArrived("req")
+	clk.advance(300 * time.Millisecond)
+	art.requestCommitted("req")
+	if !art.full {
+		t.Fatalf("Committing an arrived request should be observed")
+	}
+	if art.latencies[0] != 300*time.Millisecond {
+		t.Errorf("Expected the latency to be measured on the clock of the replica, got %v", art.latencies[0])
+	}
+	if len(art.arrivals) != 0 {
+		t.Errorf("Committed request should no longer be tracked")
+	}
+}
+
+func TestAdaptiveRequestTimeoutInvalid(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.timeout.adaptive.enabled", true)
+	config.Set("general.timeout.adaptive.floor", "10s")
+	config.Set("general.timeout.adaptive.ceiling", "1s")
+	if _, err := newAdaptiveRequestTimeout(config, wallClock{}); err == nil {
+		t.Errorf("A ceiling below the floor should be rejected")
+	}
+}
@@ -14,11 +14,11 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package obcpbft
+package pbft
 
 import pb "github.com/golang/protobuf/proto"
 
-type signable interface {
+type Signable interface {
 	getSignature() []byte
 	setSignature(s []byte)
 	getID() uint64
@@ -26,13 +26,29 @@ type signable interface {
 	serialize() ([]byte, error)
 }
 
-func (instance *pbftCore) sign(s signable) error {
+// replicaSigned returns whether s is only ever verified by other replicas,
+// rather than by clients or operators, so that it may be signed with the
+// Ed25519 key of this replica
+func replicaSigned(s Signable) bool {
+	switch s.(type) {
+	case *ViewChange, *Verify, *VerifySet, *Flush:
+		return true
+	}
+	return false
+}
+
+func (instance *PbftCore) Sign(s Signable) error {
 	s.setSignature(nil)
 	raw, err := s.serialize()
 	if err != nil {
 		return err
 	}
-	signedRaw, err := instance.consumer.sign(raw)
+	var signedRaw []byte
+	if replicaSigned(s) {
+		signedRaw, err = instance.signRaw(raw)
+	} else {
+		signedRaw, err = instance.consumer.Sign(raw)
+	}
 	if err != nil {
 		return err
 	}
@@ -41,7 +57,19 @@ func (instance *pbftCore) sign(s signable) error {
 	return nil
 }
 
-func (instance *pbftCore) verify(s signable) error {
+func (instance *PbftCore) Verify(s Signable) error {
+	if _, ok := instance.verified[s]; ok {
+		return nil
+	}
+	return verifySignatureWith(instance.VerifyRaw, s)
+}
+
+// verifySignature checks the signature of s, it may be called from any goroutine
+func verifySignature(consumer InnerStack, s Signable) error {
+	return verifySignatureWith(consumer.Verify, s)
+}
+
+func verifySignatureWith(verify Verifier, s Signable) error {
 	origSig := s.getSignature()
 	s.setSignature(nil)
 	raw, err := s.serialize()
@@ -49,7 +77,7 @@ func (instance *pbftCore) verify(s signable) error {
 	if err != nil {
 		return err
 	}
-	return instance.consumer.verify(s.getID(), origSig, raw)
+	return verify(s.getID(), origSig, raw)
 }
 
 func (vc *ViewChange) getSignature() []byte {
@@ -131,3 +159,83 @@ func (msg *Flush) setID(id uint64) {
 func (msg *Flush) serialize() ([]byte, error) {
 	return pb.Marshal(msg)
 }
+
+func (sk *SessionKey) getSignature() []byte {
+	return sk.Signature
+}
+
+func (sk *SessionKey) setSignature(sig []byte) {
+	sk.Signature = sig
+}
+
+func (sk *SessionKey) getID() uint64 {
+	return sk.ReplicaId
+}
+
+func (sk *SessionKey) setID(id uint64) {
+	sk.ReplicaId = id
+}
+
+func (sk *SessionKey) serialize() ([]byte, error) {
+	return pb.Marshal(sk)
+}
+
+func (ev *Evidence) getSignature() []byte {
+	return ev.Signature
+}
+
+func (ev *Evidence) setSignature(sig []byte) {
+	ev.Signature = sig
+}
+
+func (ev *Evidence) getID() uint64 {
+	return ev.ReplicaId
+}
+
+func (ev *Evidence) setID(id uint64) {
+	ev.ReplicaId = id
+}
+
+func (ev *Evidence) serialize() ([]byte, error) {
+	return pb.Marshal(ev)
+}
+
+func (ar *AuditRecord) getSignature() []byte {
+	return ar.Signature
+}
+
+func (ar *AuditRecord) setSignature(sig []byte) {
+	ar.Signature = sig
+}
+
+func (ar *AuditRecord) getID() uint64 {
+	return ar.ReplicaId
+}
+
+func (ar *AuditRecord) setID(id uint64) {
+	ar.ReplicaId = id
+}
+
+func (ar *AuditRecord) serialize() ([]byte, error) {
+	return pb.Marshal(ar)
+}
+
+func (r *Reply) getSignature() []byte {
+	return r.Signature
+}
+
+func (r *Reply) setSignature(sig []byte) {
+	r.Signature = sig
+}
+
+func (r *Reply) getID() uint64 {
+	return r.ReplicaId
+}
+
+func (r *Reply) setID(id uint64) {
+	r.ReplicaId = id
+}
+
+func (r *Reply) serialize() ([]byte, error) {
+	return pb.Marshal(r)
+}
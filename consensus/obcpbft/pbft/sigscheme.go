@@ -0,0 +1,175 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// ed25519WireVersion is the first wire version whose replicas may announce
+// Ed25519 keys in their hellos
+const ed25519WireVersion uint32 = 4
+
+// ed25519SignatureTag precedes Ed25519 signatures, which tells them apart
+// from the DER encoded ECDSA signatures of the stack
+const ed25519SignatureTag byte = 0xed
+
+// ed25519Keys holds the Ed25519 key this replica signs consensus messages
+// with, and the keys the other replicas announced. Each key is endorsed by
+// a signature of the stack, so that it is bound to the enrollment identity
+// of its replica. Replicas only sign with Ed25519 once every other replica
+// announced a key, so that signatures embedded in other messages, such as
+// the view-changes of a new-view, can be verified by all of them
+type ed25519Keys struct {
+	sync.RWMutex
+	id          uint64
+	N           int
+	priv        ed25519.PrivateKey
+	pub         ed25519.PublicKey
+	endorsement []byte                       // of our key, nil until the first hello
+	keys        map[uint64]ed25519.PublicKey // announced by the other replicas
+}
+
+// newEd25519Keys returns nil if general.signature.scheme is ecdsa
+func newEd25519Keys(id uint64, config *viper.Viper) (*ed25519Keys, error) {
+	switch scheme := strings.ToLower(config.GetString("general.signature.scheme")); scheme {
+	case "ecdsa", "":
+		return nil, nil
+	case "ed25519":
+	default:
+		return nil, fmt.Errorf("Invalid signature scheme: %s", scheme)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot generate Ed25519 key: %s", err)
+	}
+	return &ed25519Keys{
+		id:   id,
+		N:    config.GetInt("general.N"),
+		priv: priv,
+		pub:  pub,
+		keys: make(map[uint64]ed25519.PublicKey),
+	}, nil
+}
+
+// endorsed returns what the stack signs to endorse the key of a replica
+func endorsed(replicaID uint64, key []byte) ([]byte, error) {
+	return proto.Marshal(&WireHello{ReplicaId: replicaID, Ed25519Key: key})
+}
+
+// complete returns whether every other replica announced its key
+func (ek *ed25519Keys) complete() bool {
+	ek.RLock()
+	defer ek.RUnlock()
+	return len(ek.keys) == ek.N-1
+}
+
+func (ek *ed25519Keys) key(replicaID uint64) ed25519.PublicKey {
+	ek.RLock()
+	defer ek.RUnlock()
+	if replicaID == ek.id {
+		return ek.pub
+	}
+	return ek.keys[replicaID]
+}
+
+// =============================================================================
+// pbftCore glue
+// =============================================================================
+
+// wireHello returns our wire hello, announcing our Ed25519 key if enabled
+func (instance *PbftCore) wireHello(reply bool) *WireHello {
+	hello := instance.Wire.hello(reply)
+	ek := instance.ed25519
+	if ek == nil {
+		return hello
+	}
+	if ek.endorsement == nil {
+		raw, err := endorsed(instance.ID, ek.pub)
+		if err == nil {
+			ek.endorsement, err = instance.consumer.Sign(raw)
+		}
+		if err != nil {
+			instance.log.Error("Cannot endorse its Ed25519 key, signing with the stack: %s", err)
+			return hello
+		}
+	}
+	hello.Ed25519Key = ek.pub
+	hello.KeyEndorsement = ek.endorsement
+	return hello
+}
+
+// recvEd25519Key records the Ed25519 key announced in a hello, after
+// checking that the stack endorsed it for the replica
+func (instance *PbftCore) recvEd25519Key(hello *WireHello) error {
+	ek := instance.ed25519
+	if ek == nil || hello.Ed25519Key == nil || hello.ReplicaId == instance.ID {
+		return nil
+	}
+	if len(hello.Ed25519Key) != ed25519.PublicKeySize {
+		return fmt.Errorf("Ed25519 key of replica %d has %d bytes", hello.ReplicaId, len(hello.Ed25519Key))
+	}
+	raw, err := endorsed(hello.ReplicaId, hello.Ed25519Key)
+	if err != nil {
+		return err
+	}
+	if err := instance.consumer.Verify(hello.ReplicaId, hello.KeyEndorsement, raw); err != nil {
+		return fmt.Errorf("Ed25519 key of replica %d is not endorsed: %s", hello.ReplicaId, err)
+	}
+
+	ek.Lock()
+	defer ek.Unlock()
+	ek.keys[hello.ReplicaId] = ed25519.PublicKey(hello.Ed25519Key)
+	if len(ek.keys) == ek.N-1 {
+		instance.log.Info("Learned the Ed25519 keys of all replicas")
+	}
+	return nil
+}
+
+// signRaw signs raw with Ed25519 once all replicas announced their key and
+// speak a wire version which may carry Ed25519 signatures, and through the
+// stack otherwise
+func (instance *PbftCore) signRaw(raw []byte) ([]byte, error) {
+	ek := instance.ed25519
+	if ek == nil || !ek.complete() || instance.Wire.version() < ed25519WireVersion {
+		return instance.consumer.Sign(raw)
+	}
+	return append([]byte{ed25519SignatureTag}, ed25519.Sign(ek.priv, raw)...), nil
+}
+
+// VerifyRaw checks a signature of raw by the replica, made with either
+// scheme. It may be called from any goroutine
+func (instance *PbftCore) VerifyRaw(replicaID uint64, signature []byte, raw []byte) error {
+	if instance.ed25519 == nil || len(signature) != ed25519.SignatureSize+1 || signature[0] != ed25519SignatureTag {
+		return instance.consumer.Verify(replicaID, signature, raw)
+	}
+	key := instance.ed25519.key(replicaID)
+	if key == nil {
+		return fmt.Errorf("no Ed25519 key known for replica %d", replicaID)
+	}
+	if !ed25519.Verify(key, raw, signature[1:]) {
+		return fmt.Errorf("invalid Ed25519 signature of replica %d", replicaID)
+	}
+	return nil
+}
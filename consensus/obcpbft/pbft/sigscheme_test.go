@@ -0,0 +1,111 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+)
+
+// newSchemeTestStack signs by prefixing the message with the replica id
+func newSchemeTestStack() *omniProto {
+	return &omniProto{
+		broadcastImpl: func(msgPayload []byte) {},
+		unicastImpl:   func(msgPayload []byte, receiverID uint64) error { return nil },
+		signImpl: func(msg []byte) ([]byte, error) {
+			return append([]byte("replica 0:"), msg...), nil
+		},
+		verifyImpl: func(senderID uint64, signature []byte, msg []byte) error {
+			if !bytes.Equal(signature, append([]byte(fmt.Sprintf("replica %d:", senderID)), msg...)) {
+				return fmt.Errorf("invalid stack signature of replica %d", senderID)
+			}
+			return nil
+		},
+	}
+}
+
+// announcedKey returns the hello of a replica announcing an Ed25519 key
+func announcedKey(t *testing.T, id uint64, endorser uint64) (*WireHello, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := newWireNegotiator(id, 4).hello(true)
+	hello.Ed25519Key = pub
+	raw, _ := endorsed(id, pub)
+	hello.KeyEndorsement = append([]byte(fmt.Sprintf("replica %d:", endorser)), raw...)
+	return hello, priv
+}
+
+func TestEd25519Signatures(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.signature.scheme", "ed25519")
+	p := newPbftCore(0, config, newSchemeTestStack())
+	defer p.Close()
+
+	if hello := p.wireHello(false); len(hello.Ed25519Key) != ed25519.PublicKeySize || hello.KeyEndorsement == nil {
+		t.Fatalf("Expected our hello to announce an endorsed Ed25519 key, got %v", hello)
+	}
+
+	vc := &ViewChange{View: 1, ReplicaId: 0}
+	if err := p.Sign(vc); err != nil || vc.Signature[0] == ed25519SignatureTag {
+		t.Fatalf("Expected the stack to sign until all replicas announced a key, got %v, %v", vc.Signature, err)
+	}
+
+	forged, _ := announcedKey(t, 3, 2)
+	sendEvent(p, pbftMessageEvent{msg: &Message{&Message_WireHello{forged}}, sender: 3})
+	if p.ed25519.key(3) != nil {
+		t.Fatalf("Expected a key endorsed by another replica to be ignored")
+	}
+
+	privs := make(map[uint64]ed25519.PrivateKey)
+	for _, id := range []uint64{1, 2, 3} {
+		hello, priv := announcedKey(t, id, id)
+		privs[id] = priv
+		sendEvent(p, pbftMessageEvent{msg: &Message{&Message_WireHello{hello}}, sender: id})
+	}
+
+	vc = &ViewChange{View: 1, ReplicaId: 0}
+	if err := p.Sign(vc); err != nil || vc.Signature[0] != ed25519SignatureTag {
+		t.Fatalf("Expected an Ed25519 signature once all replicas announced a key, got %v, %v", vc.Signature, err)
+	}
+	if err := p.Verify(vc); err != nil {
+		t.Errorf("Expected our Ed25519 signature to verify: %s", err)
+	}
+	reply := &Reply{ReplicaId: 0}
+	if err := p.Sign(reply); err != nil || reply.Signature[0] == ed25519SignatureTag {
+		t.Errorf("Expected replies, which clients verify, to be signed by the stack")
+	}
+
+	other := &ViewChange{View: 1, ReplicaId: 2}
+	raw, _ := other.serialize()
+	other.Signature = append([]byte{ed25519SignatureTag}, ed25519.Sign(privs[2], raw)...)
+	if err := p.Verify(other); err != nil {
+		t.Errorf("Expected the Ed25519 signature of replica 2 to verify: %s", err)
+	}
+	other.View = 2
+	if err := p.Verify(other); err == nil {
+		t.Errorf("Expected the Ed25519 signature of a tampered view-change not to verify")
+	}
+	other.ReplicaId = 1
+	other.View = 1
+	if err := p.Verify(other); err == nil {
+		t.Errorf("Expected the Ed25519 signature of replica 2 not to verify for replica 1")
+	}
+}
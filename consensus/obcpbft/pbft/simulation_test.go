@@ -0,0 +1,410 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+var simSeed = flag.Int64("simseed", 0, "seed of the pbft simulations, a random one if 0")
+
+// simEvent is something happening at a point of virtual time
+type simEvent struct {
+	at    time.Time
+	order uint64 // breaks ties independently of the order events were scheduled in
+	seq   uint64 // breaks the remaining ties
+	fire  func()
+}
+
+type simEvents []*simEvent
+
+func (se simEvents) Len() int      { return len(se) }
+func (se simEvents) Swap(i, j int) { se[i], se[j] = se[j], se[i] }
+func (se simEvents) Less(i, j int) bool {
+	if !se[i].at.Equal(se[j].at) {
+		return se[i].at.Before(se[j].at)
+	}
+	if se[i].order != se[j].order {
+		return se[i].order < se[j].order
+	}
+	return se[i].seq < se[j].seq
+}
+func (se *simEvents) Push(x interface{}) { *se = append(*se, x.(*simEvent)) }
+func (se *simEvents) Pop() interface{} {
+	old := *se
+	ev := old[len(old)-1]
+	*se = old[:len(old)-1]
+	return ev
+}
+
+// simulation runs a network of pbft replicas on a single thread. Messages
+// are delivered after a latency derived from the seed, timers fire on a
+// virtual clock, and requests are broadcast by replicas chosen by a PRNG
+// seeded with the seed, so that every interleaving is reproduced by its
+// seed. Latencies are derived from the contents of the messages rather than
+// the order they were sent in, which may vary with the iteration order of maps
+type simulation struct {
+	seed       int64
+	rand       *rand.Rand
+	start      time.Time
+	now        time.Time
+	events     simEvents
+	seq        uint64
+	maxLatency time.Duration
+	replicas   []*simReplica
+	crashed    map[uint64]bool
+	trace      []string
+}
+
+// simReplica is the stack of a simulated replica, its events and executions
+// are processed on the thread of the simulation
+type simReplica struct {
+	*simpleConsumer
+	sim     *simulation
+	id      uint64
+	pbft    *PbftCore
+	manager *simManager
+}
+
+// simManager hands the events a replica queues to the simulation
+type simManager struct {
+	receiver EventReceiver
+	events   chan interface{}
+}
+
+func (sm *simManager) Inject(event interface{})  { sendEvent(sm.receiver, event) }
+func (sm *simManager) Queue() chan<- interface{} { return sm.events }
+func (sm *simManager) Start()                    {}
+func (sm *simManager) halt()                     {}
+
+// simClock is the virtual clock of a simulation, its timers fire as
+// simulation events
+type simClock struct {
+	sim *simulation
+}
+
+func (sc *simClock) Now() time.Time {
+	return sc.sim.now
+}
+
+func (sc *simClock) After(d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	sc.sim.schedule(d, 0, func() { c <- sc.sim.now })
+	return c
+}
+
+func (sc *simClock) timerFactory(manager EventManager) eventTimerFactory {
+	return &simTimerFactory{sim: sc.sim, manager: manager}
+}
+
+type simTimerFactory struct {
+	sim     *simulation
+	manager EventManager
+}
+
+func (stf *simTimerFactory) createTimer() EventTimer {
+	return &simTimer{sim: stf.sim, manager: stf.manager}
+}
+
+// simTimer injects its event into the replica when the simulation reaches
+// its deadline, unless it was stopped or reset since
+type simTimer struct {
+	sim        *simulation
+	manager    EventManager
+	running    bool
+	generation uint64 // of the countdown, incremented to cancel it
+}
+
+func (st *simTimer) SoftReset(d time.Duration, event interface{}) {
+	if !st.running {
+		st.Reset(d, event)
+	}
+}
+
+func (st *simTimer) Reset(d time.Duration, event interface{}) {
+	st.generation++
+	st.running = true
+	generation := st.generation
+	st.sim.schedule(d, 0, func() {
+		if st.generation != generation {
+			return
+		}
+		st.running = false
+		st.manager.Inject(event)
+	})
+}
+
+func (st *simTimer) Stop() {
+	st.generation++
+	st.running = false
+}
+
+func (st *simTimer) halt() {
+	st.Stop()
+}
+
+// newSimulation creates a simulation of N replicas, seeded with -simseed or
+// a random seed, which is logged so that a failing run can be reproduced
+func newSimulation(t *testing.T, N int, config *viper.Viper) *simulation {
+	seed := *simSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("Simulating with seed %d, rerun with -simseed %d to reproduce", seed, seed)
+	return newSeededSimulation(seed, N, config)
+}
+
+func newSeededSimulation(seed int64, N int, config *viper.Viper) *simulation {
+	if config == nil {
+		config = loadConfig()
+	}
+	config.Set("general.N", N)
+	config.Set("general.f", (N-1)/3)
+
+	sim := &simulation{
+		seed:       seed,
+		rand:       rand.New(rand.NewSource(seed)),
+		start:      time.Unix(0, 0),
+		maxLatency: 10 * time.Millisecond,
+		crashed:    make(map[uint64]bool),
+	}
+	sim.now = sim.start
+	sim.replicas = make([]*simReplica, N)
+	clk := &simClock{sim}
+	for id := range sim.replicas {
+		r := &simReplica{simpleConsumer: &simpleConsumer{}, sim: sim, id: uint64(id)}
+		sim.replicas[id] = r
+		r.pbft = newPbftCoreWithClock(r.id, config, r, clk)
+		r.manager = &simManager{receiver: r.pbft, events: make(chan interface{}, 1000)}
+		r.pbft.Manager = r.manager
+		r.pbft.execQueue.halt()
+		r.pbft.execQueue = &execQueue{threaded: threaded{make(chan struct{})}, consumer: r, jobs: make(chan execJob, 1)}
+	}
+	return sim
+}
+
+// schedule makes fire happen once d passed on the virtual clock
+func (sim *simulation) schedule(d time.Duration, order uint64, fire func()) {
+	sim.seq++
+	heap.Push(&sim.events, &simEvent{at: sim.now.Add(d), order: order, seq: sim.seq, fire: fire})
+}
+
+// at runs fn once d passed on the virtual clock
+func (sim *simulation) at(d time.Duration, fn func()) {
+	sim.schedule(d, 0, fn)
+}
+
+func (sim *simulation) record(format string, args ...interface{}) {
+	sim.trace = append(sim.trace, fmt.Sprintf("%v ", sim.now.Sub(sim.start))+fmt.Sprintf(format, args...))
+}
+
+// send delivers payload from src to dst after a latency derived from the
+// seed, the link and the payload
+func (sim *simulation) send(src, dst uint64, payload []byte) {
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, []uint64{uint64(sim.seed), src, dst})
+	h.Write(payload)
+	order := h.Sum64()
+	sim.schedule(time.Duration(order%uint64(sim.maxLatency)), order, func() {
+		sim.deliver(src, dst, payload)
+	})
+}
+
+func (sim *simulation) deliver(src, dst uint64, payload []byte) {
+	if sim.crashed[src] || sim.crashed[dst] || dst >= uint64(len(sim.replicas)) {
+		return
+	}
+	msg := &Message{}
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		panic(fmt.Sprintf("Replica %d sent a message which does not unmarshal: %s", src, err))
+	}
+	sim.record("%d->%d %s", src, dst, messageKind(msg))
+	sendEvent(sim.replicas[dst].pbft, &pbftMessage{msg: msg, sender: src})
+}
+
+// request broadcasts the nth request from a replica which did not crash,
+// chosen by the PRNG
+func (sim *simulation) request(n int64) {
+	var live []uint64
+	for _, r := range sim.replicas {
+		if !sim.crashed[r.id] {
+			live = append(live, r.id)
+		}
+	}
+	broadcaster := live[sim.rand.Intn(len(live))]
+	req := createPbftRequestWithChainTx(n, broadcaster)
+	sim.record("request %d at %d", n, broadcaster)
+	payload, _ := proto.Marshal(&Message{&Message_Request{req}})
+	for _, r := range sim.replicas {
+		if r.id != broadcaster {
+			sim.send(broadcaster, r.id, payload)
+		}
+	}
+	sendEvent(sim.replicas[broadcaster].pbft, req)
+}
+
+// crash cuts a replica off, it neither sends nor receives messages
+func (sim *simulation) crash(id uint64) {
+	sim.record("crash %d", id)
+	sim.crashed[id] = true
+}
+
+// settle processes the events the replicas queued and the executions they
+// requested, until all replicas are idle
+func (sim *simulation) settle() {
+	for busy := true; busy; {
+		busy = false
+		for _, r := range sim.replicas {
+			select {
+			case event := <-r.manager.events:
+				sendEvent(r.pbft, event)
+				busy = true
+			case job := <-r.pbft.execQueue.jobs:
+				r.Execute(job.seqNo, job.txRaw, job.done)
+				busy = true
+			default:
+			}
+		}
+	}
+}
+
+// run processes the simulation events in the order of their virtual time,
+// until d passed on the virtual clock
+func (sim *simulation) run(d time.Duration) {
+	deadline := sim.now.Add(d)
+	sim.settle()
+	for len(sim.events) > 0 && !sim.events[0].at.After(deadline) {
+		ev := heap.Pop(&sim.events).(*simEvent)
+		sim.now = ev.at
+		ev.fire()
+		sim.settle()
+	}
+	sim.now = deadline
+}
+
+func (sim *simulation) close() {
+	for _, r := range sim.replicas {
+		r.pbft.Close()
+	}
+}
+
+func (r *simReplica) Broadcast(msgPayload []byte) {
+	if r.sim.crashed[r.id] {
+		return
+	}
+	for dst := range r.sim.replicas {
+		if uint64(dst) != r.id {
+			r.sim.send(r.id, uint64(dst), msgPayload)
+		}
+	}
+}
+
+func (r *simReplica) Unicast(msgPayload []byte, receiverID uint64) error {
+	if !r.sim.crashed[r.id] {
+		r.sim.send(r.id, receiverID, msgPayload)
+	}
+	return nil
+}
+
+func (r *simReplica) Execute(seqNo uint64, tx []byte, done ExecCallback) {
+	r.sim.record("replica %d executes %d", r.id, seqNo)
+	r.lastExecution = tx
+	r.executions++
+	r.lastSeqNo = seqNo
+	done(nil)
+}
+
+func (r *simReplica) SkipTo(seqNo uint64, id []byte, replicas []uint64) {
+	r.sim.record("replica %d skips to %d", r.id, seqNo)
+	r.skipOccurred = true
+	r.executions = seqNo
+}
+
+// simulateRequests submits requests at random times, and returns the trace
+func simulateRequests(seed int64, requests int) (*simulation, []string) {
+	config := loadConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	sim := newSeededSimulation(seed, 4, config)
+	for n := 1; n <= requests; n++ {
+		n := int64(n)
+		sim.at(time.Duration(sim.rand.Intn(1000))*time.Millisecond, func() { sim.request(n) })
+	}
+	sim.run(10 * time.Second)
+	return sim, sim.trace
+}
+
+func TestSimulationReproducible(t *testing.T) {
+	seed := *simSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("Simulating with seed %d, rerun with -simseed %d to reproduce", seed, seed)
+
+	sim, first := simulateRequests(seed, 5)
+	defer sim.close()
+	for _, r := range sim.replicas {
+		if r.executions != 5 {
+			t.Errorf("Expected replica %d to execute 5 requests, got %d", r.id, r.executions)
+		}
+	}
+
+	sim, second := simulateRequests(seed, 5)
+	defer sim.close()
+	if len(first) != len(second) {
+		t.Fatalf("Expected the same seed to reproduce the same %d steps, got %d", len(first), len(second))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Expected the same seed to reproduce the same steps, step %d was %q, then %q", i, first[i], second[i])
+		}
+	}
+}
+
+func TestSimulatedPrimaryCrash(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.timeout.request", "400ms")
+	config.Set("general.timeout.viewchange", "800ms")
+	sim := newSimulation(t, 4, config)
+	defer sim.close()
+
+	sim.crash(0)
+	sim.request(1)
+	sim.run(10 * time.Second)
+
+	for _, r := range sim.replicas[1:] {
+		if r.pbft.View < 1 {
+			t.Errorf("Expected replica %d to move past the view of the crashed primary, it is in view %d", r.id, r.pbft.View)
+		}
+		if r.executions != 1 {
+			t.Errorf("Expected replica %d to execute the request, got %d executions", r.id, r.executions)
+		}
+	}
+	if t.Failed() {
+		t.Logf("Trace:\n%s", sim.trace)
+	}
+}
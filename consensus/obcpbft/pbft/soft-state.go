@@ -0,0 +1,162 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// softStateStores are the stores of the soft state whose sizes the replica
+// reports, as the store label of pbft_soft_state_entries
+var softStateStores = []string{"certs", "requests", "outstanding", "viewchanges", "newviews", "missing"}
+
+// softStateLimits bounds the stores of the soft state a faulty replica
+// could otherwise grow without bound, and defines what is shed once a store
+// is full:
+//
+//   - certs: prepares, commits and pre-prepares which would start a new
+//     certificate are dropped, the certificates in progress complete
+//   - requests: client requests not yet held are dropped, as under
+//     backpressure, and retransmitted by their clients. Requests carried by
+//     pre-prepares are always stored, as ordering depends on them. The
+//     outstanding requests are a subset of the requests held
+//   - viewchanges: the view change, or new view, for the highest view is
+//     shed, as a view change installs the lowest view f+1 replicas vote for
+//
+// The missing requests are reported but not bounded, they are bounded by
+// the log size as they are the requests of a new view. All methods may be
+// called on a nil softStateLimits, which leaves every store unlimited
+type softStateLimits struct {
+	certs       int
+	requests    int
+	viewChanges int // bounds the view changes and the new views separately
+}
+
+// newSoftStateLimits returns nil if none of the stores is bounded. The view
+// changes must hold those of the N replicas for one view
+func newSoftStateLimits(config *viper.Viper, N int) (*softStateLimits, error) {
+	limits := &softStateLimits{
+		certs:       config.GetInt("general.softstate.certs"),
+		requests:    config.GetInt("general.softstate.requests"),
+		viewChanges: config.GetInt("general.softstate.viewchanges"),
+	}
+	for name, limit := range map[string]int{"certs": limits.certs, "requests": limits.requests, "viewchanges": limits.viewChanges} {
+		if limit < 0 {
+			return nil, fmt.Errorf("Soft state bound of %s must not be negative, got %d", name, limit)
+		}
+	}
+	if limits.viewChanges != 0 && limits.viewChanges < N {
+		return nil, fmt.Errorf("Soft state bound of viewchanges must be at least N=%d, got %d", N, limits.viewChanges)
+	}
+	if limits.certs == 0 && limits.requests == 0 && limits.viewChanges == 0 {
+		return nil, nil
+	}
+	return limits, nil
+}
+
+// softStateSizes returns the number of entries of each store of the soft
+// state, keyed as softStateStores, it must only be called from the event
+// thread
+func (instance *PbftCore) softStateSizes() map[string]int {
+	return map[string]int{
+		"certs":       len(instance.certStore),
+		"requests":    len(instance.reqStore),
+		"outstanding": len(instance.OutstandingReqs),
+		"viewchanges": len(instance.viewChangeStore),
+		"newviews":    len(instance.newViewStore),
+		"missing":     len(instance.missingReqs),
+	}
+}
+
+// admitCert returns whether a message for view v and sequence number n may
+// be stored, i.e. its certificate exists or the certificates are not full
+func (instance *PbftCore) admitCert(v, n uint64) bool {
+	limits := instance.softLimits
+	if limits == nil || limits.certs == 0 {
+		return true
+	}
+	if _, ok := instance.certStore[msgID{v, n}]; ok || len(instance.certStore) < limits.certs {
+		return true
+	}
+	instance.log.at(n).Warning("Shedding message for view %d, holding the maximum of %d certificates", v, limits.certs)
+	instance.Metrics.softStateShed["certs"].Inc()
+	return false
+}
+
+// admitRequest returns whether client request digest may be stored, i.e.
+// it is held already or the requests are not full
+func (instance *PbftCore) admitRequest(digest string) bool {
+	limits := instance.softLimits
+	if limits == nil || limits.requests == 0 {
+		return true
+	}
+	if _, ok := instance.reqStore[digest]; ok || len(instance.reqStore) < limits.requests {
+		return true
+	}
+	instance.log.Warning("Shedding request %s, holding the maximum of %d requests", digest, limits.requests)
+	instance.Metrics.softStateShed["requests"].Inc()
+	return false
+}
+
+// admitViewChange returns whether vc may be stored. Once the view changes
+// are full, the one for the highest view is shed, vc if it is that one
+func (instance *PbftCore) admitViewChange(vc *ViewChange) bool {
+	limits := instance.softLimits
+	if limits == nil || limits.viewChanges == 0 || len(instance.viewChangeStore) < limits.viewChanges {
+		return true
+	}
+	var highest vcidx
+	found := false
+	for idx := range instance.viewChangeStore {
+		if !found || idx.v > highest.v || (idx.v == highest.v && idx.id > highest.id) {
+			highest, found = idx, true
+		}
+	}
+	instance.Metrics.softStateShed["viewchanges"].Inc()
+	if vc.View >= highest.v {
+		instance.vcLog.Warning("Shedding view-change from replica %d for view %d, holding the maximum of %d view-changes", vc.ReplicaId, vc.View, limits.viewChanges)
+		return false
+	}
+	instance.vcLog.Warning("Shedding view-change from replica %d for view %d, to hold the one of replica %d for view %d", highest.id, highest.v, vc.ReplicaId, vc.View)
+	delete(instance.viewChangeStore, highest)
+	return true
+}
+
+// admitNewView returns whether nv may be stored. Once the new views are
+// full, the one for the highest view is shed, nv if it is that one
+func (instance *PbftCore) admitNewView(nv *NewView) bool {
+	limits := instance.softLimits
+	if limits == nil || limits.viewChanges == 0 || len(instance.newViewStore) < limits.viewChanges {
+		return true
+	}
+	var highest uint64
+	for v := range instance.newViewStore {
+		if v > highest {
+			highest = v
+		}
+	}
+	instance.Metrics.softStateShed["newviews"].Inc()
+	if nv.View >= highest {
+		instance.vcLog.Warning("Shedding new-view for view %d, holding the maximum of %d new-views", nv.View, limits.viewChanges)
+		return false
+	}
+	instance.vcLog.Warning("Shedding new-view for view %d, to hold the one for view %d", highest, nv.View)
+	delete(instance.newViewStore, highest)
+	return true
+}
@@ -0,0 +1,114 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSoftStateLimitsConfig(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.softstate.certs", 0)
+	config.Set("general.softstate.requests", 0)
+	config.Set("general.softstate.viewchanges", 0)
+	if limits, err := newSoftStateLimits(config, 4); err != nil || limits != nil {
+		t.Errorf("Expected unbounded soft state, got %v, %v", limits, err)
+	}
+
+	config.Set("general.softstate.requests", -1)
+	if _, err := newSoftStateLimits(config, 4); err == nil {
+		t.Error("Expected an error for a negative bound")
+	}
+
+	config.Set("general.softstate.requests", 0)
+	config.Set("general.softstate.viewchanges", 3)
+	if _, err := newSoftStateLimits(config, 4); err == nil {
+		t.Error("Expected an error for a view change bound below N")
+	}
+}
+
+func TestSoftStateShedRequests(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.softstate.requests", 2)
+	instance := newPbftCoreWithClock(1, config, &discardConsumer{&simpleConsumer{}}, newVirtualClock(time.Unix(0, 0)))
+	defer instance.Close()
+	shed := instance.Metrics.softStateShed["requests"].Value()
+
+	for i := int64(1); i <= 3; i++ {
+		sendEvent(instance, createPbftRequestWithChainTx(i, 0))
+	}
+	if len(instance.reqStore) != 2 || len(instance.OutstandingReqs) != 2 {
+		t.Errorf("Expected the backup to hold 2 requests, holds %d, %d outstanding", len(instance.reqStore), len(instance.OutstandingReqs))
+	}
+	if v := instance.Metrics.softStateShed["requests"].Value() - shed; v != 1 {
+		t.Errorf("Expected 1 request to be shed, got %v", v)
+	}
+
+	// the requests of pre-prepares are held regardless
+	req := createPbftRequestWithChainTx(4, 0)
+	digest := HashReq(instance.Digest, req)
+	sendEvent(instance, &PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: digest, Request: req, ReplicaId: 0, DigestAlgorithm: instance.Digest.name()})
+	if _, ok := instance.reqStore[digest]; !ok {
+		t.Error("Expected the request of the pre-prepare to be held")
+	}
+
+	instance.Metrics.update(instance)
+	if v := instance.Metrics.softState["requests"].Value(); v != 3 {
+		t.Errorf("Expected the requests gauge to report 3 requests, got %v", v)
+	}
+}
+
+func TestSoftStateShedCerts(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.softstate.certs", 2)
+	instance := newPbftCoreWithClock(1, config, &discardConsumer{&simpleConsumer{}}, newVirtualClock(time.Unix(0, 0)))
+	defer instance.Close()
+
+	for n := uint64(1); n <= 3; n++ {
+		sendEvent(instance, &Prepare{View: 0, SequenceNumber: n, RequestDigest: "foo", ReplicaId: 2})
+	}
+	if len(instance.certStore) != 2 {
+		t.Errorf("Expected 2 certificates, got %d", len(instance.certStore))
+	}
+	sendEvent(instance, &Commit{View: 0, SequenceNumber: 2, RequestDigest: "foo", ReplicaId: 2})
+	if cert := instance.certStore[msgID{0, 2}]; cert == nil || len(cert.commit) != 1 {
+		t.Error("Expected the certificates in progress to keep collecting votes")
+	}
+}
+
+func TestSoftStateShedViewChanges(t *testing.T) {
+	config := byzantineConfig()
+	config.Set("general.softstate.viewchanges", 4)
+	net := makePBFTNetwork(4, config, withAdversary(3, &viewChangeSpammer{}))
+	defer net.Stop()
+
+	for i := int64(1); i <= 3; i++ {
+		net.pbftEndpoints[0].pbft.Manager.Queue() <- createPbftRequestWithChainTx(i, 0)
+		net.Process()
+	}
+
+	checkCorrectReplicasAgree(t, net, 3, 3)
+	for i, pe := range net.pbftEndpoints[:3] {
+		if size := len(pe.pbft.viewChangeStore); size > 4 {
+			t.Errorf("Expected replica %d to hold at most 4 view changes, holds %d", i, size)
+		}
+		if pe.pbft.Metrics.softStateShed["viewchanges"].Value() == 0 {
+			t.Errorf("Expected replica %d to shed the view changes of the spammer", i)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"github.com/spf13/viper"
+)
+
+// speculativeStack is implemented by consumers which can execute a request
+// against a shadow state before it committed. The methods are invoked on the
+// pbft event thread
+type speculativeStack interface {
+	Speculate(seqNo uint64, txRaw []byte) // executes the request, keeping its effects apart from the committed state
+	Confirm(seqNo uint64)                 // commits the effects of the speculative execution of seqNo, then completes the execution with execDoneSync
+	Rollback(seqNo uint64)                // discards the effects of the speculative execution of seqNo, before returning
+}
+
+// speculation tracks the request the replica executed speculatively. Once a
+// request is prepared, and all requests before it are executed, the replica
+// executes it while the commits are still in flight. The execution is
+// confirmed if the same request commits at its sequence number, and rolled
+// back if a view change assigns the sequence number to another request
+type speculation struct {
+	stack  speculativeStack
+	active bool   // a speculative execution awaits confirmation
+	n      uint64 // sequence number of the speculatively executed request
+	digest string // digest of the speculatively executed request
+}
+
+// newSpeculation returns nil unless general.speculative is set, and the
+// consumer supports speculative execution
+func newSpeculation(config *viper.Viper, consumer InnerStack) *speculation {
+	if !config.GetBool("general.speculative") {
+		return nil
+	}
+	stack, ok := consumer.(speculativeStack)
+	if !ok {
+		logger.Warning("PBFT speculative execution is not supported by the consumer, executing requests once they commit")
+		return nil
+	}
+	return &speculation{stack: stack}
+}
+
+// matches returns whether the request with digest at seqNo n was executed
+// speculatively
+func (s *speculation) matches(n uint64, digest string) bool {
+	return s != nil && s.active && s.n == n && s.digest == digest
+}
+
+// maybeSpeculate executes the next request speculatively, if it is prepared
+// but has not committed yet
+func (instance *PbftCore) maybeSpeculate() {
+	s := instance.speculation
+	if s == nil || s.active || instance.CurrentExec != nil || instance.SkipInProgress || !instance.ActiveView {
+		return
+	}
+	n := instance.LastExec + 1
+	cert := instance.certStore[msgID{instance.View, n}]
+	if cert == nil || cert.digest == "" {
+		return
+	}
+	if !instance.prepared(cert.digest, instance.View, n) || instance.committed(cert.digest, instance.View, n) {
+		return
+	}
+	req, ok := instance.reqStore[cert.digest]
+	if !ok || req.EpochReset {
+		return
+	}
+
+	instance.log.Debug("Speculatively executing request for view=%d/seqNo=%d and digest %s",
+		instance.View, n, cert.digest)
+	s.active = true
+	s.n = n
+	s.digest = cert.digest
+	instance.Metrics.Speculations.Inc()
+	s.stack.Speculate(n, req.Payload)
+}
+
+// confirmSpeculation commits the speculative execution of seqNo n
+func (instance *PbftCore) confirmSpeculation(n uint64) {
+	s := instance.speculation
+	instance.log.Debug("Confirming speculative execution of seqNo %d", n)
+	s.active = false
+	s.stack.Confirm(n)
+}
+
+// rollbackSpeculation discards the outstanding speculative execution, if any
+func (instance *PbftCore) rollbackSpeculation() {
+	s := instance.speculation
+	if s == nil || !s.active {
+		return
+	}
+	instance.log.Info("Rolling back speculative execution of seqNo %d, digest %s", s.n, s.digest)
+	s.active = false
+	instance.Metrics.speculationRollbacks.Inc()
+	s.stack.Rollback(s.n)
+}
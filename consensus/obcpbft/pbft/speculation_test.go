@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"reflect"
+	"testing"
+)
+
+// speculativeTestStack records the speculative executions the pbft core
+// asks for
+type speculativeTestStack struct {
+	*omniProto
+	instance   *PbftCore
+	speculated []uint64
+	confirmed  []uint64
+	rolledBack []uint64
+	execs      chan uint64
+}
+
+func newSpeculativeTestStack() *speculativeTestStack {
+	persist := &mockPersist{}
+	stack := &speculativeTestStack{execs: make(chan uint64, 10)}
+	stack.omniProto = &omniProto{
+		broadcastImpl:  func(msgPayload []byte) {},
+		StoreStateImpl: persist.StoreState,
+		DelStateImpl:   persist.DelState,
+		signImpl:       func(msg []byte) ([]byte, error) { return msg, nil },
+		getStateImpl:   func() []byte { return []byte("state") },
+		executeImpl: func(seqNo uint64, txRaw []byte, done ExecCallback) {
+			stack.execs <- seqNo
+		},
+	}
+	return stack
+}
+
+func (stack *speculativeTestStack) Speculate(seqNo uint64, txRaw []byte) {
+	stack.speculated = append(stack.speculated, seqNo)
+}
+
+func (stack *speculativeTestStack) Confirm(seqNo uint64) {
+	stack.confirmed = append(stack.confirmed, seqNo)
+	stack.instance.ExecDoneSync(nil)
+}
+
+func (stack *speculativeTestStack) Rollback(seqNo uint64) {
+	stack.rolledBack = append(stack.rolledBack, seqNo)
+}
+
+// certify adds the pre-prepare and prepares for a request at view v and
+// seqNo n to the log of instance, and the commits if commit is set
+func certify(instance *PbftCore, v uint64, n uint64, req *Request, commit bool) {
+	digest := HashReq(instance.Digest, req)
+	instance.reqStore[digest] = req
+	cert := instance.getCert(v, n)
+	cert.digest = digest
+	cert.prePrepare = &PrePrepare{View: v, SequenceNumber: n, RequestDigest: digest, ReplicaId: v % uint64(instance.replicaCount)}
+	for i := uint64(0); i < uint64(instance.replicaCount); i++ {
+		if i == v%uint64(instance.replicaCount) {
+			continue
+		}
+		cert.prepare = append(cert.prepare, &Prepare{View: v, SequenceNumber: n, RequestDigest: digest, ReplicaId: i})
+		if commit {
+			cert.commit = append(cert.commit, &Commit{View: v, SequenceNumber: n, RequestDigest: digest, ReplicaId: i})
+		}
+	}
+}
+
+func TestSpeculationDisabled(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.speculative", true)
+	instance := newPbftCore(1, config, &omniProto{})
+	defer instance.Close()
+	if instance.speculation != nil {
+		t.Errorf("Expected speculative execution to be disabled for a consumer which does not support it")
+	}
+}
+
+func TestSpeculativeExecution(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.speculative", true)
+	stack := newSpeculativeTestStack()
+	instance := newPbftCore(1, config, stack)
+	defer instance.Close()
+	stack.instance = instance
+
+	req1 := createPbftRequestWithChainTx(1, 0)
+	certify(instance, 0, 1, req1, false)
+	instance.maybeSpeculate()
+	if !reflect.DeepEqual(stack.speculated, []uint64{1}) {
+		t.Fatalf("Expected the prepared request to be executed speculatively, speculated %v", stack.speculated)
+	}
+
+	certify(instance, 0, 1, req1, true)
+	instance.executeOutstanding()
+	if !reflect.DeepEqual(stack.confirmed, []uint64{1}) || instance.LastExec != 1 {
+		t.Fatalf("Expected the speculative execution to be confirmed once the request committed, confirmed %v, last executed %d", stack.confirmed, instance.LastExec)
+	}
+	if len(stack.execs) != 0 {
+		t.Errorf("Expected the confirmed request not to be executed again")
+	}
+
+	// A view change assigns seqNo 2 to another request than the speculatively executed one
+	certify(instance, 0, 2, createPbftRequestWithChainTx(2, 0), false)
+	instance.maybeSpeculate()
+	instance.View = 1
+	certify(instance, 1, 2, createPbftRequestWithChainTx(3, 0), true)
+	instance.executeOutstanding()
+	if !reflect.DeepEqual(stack.rolledBack, []uint64{2}) {
+		t.Errorf("Expected the speculative execution of seqNo 2 to be rolled back, rolled back %v", stack.rolledBack)
+	}
+	if n := <-stack.execs; n != 2 {
+		t.Errorf("Expected the request committed at seqNo 2 to be executed, executed %d", n)
+	}
+}
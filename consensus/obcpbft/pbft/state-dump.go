@@ -0,0 +1,239 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// stateDump is the soft state of a replica, as written by DumpState for the
+// analysis of wedged replicas. Its fields are sorted, so that the dumps of
+// the replicas of a network can be compared with diff
+type stateDump struct {
+	ReplicaID       uint64            `json:"replicaID"`
+	View            uint64            `json:"view"`
+	ActiveView      bool              `json:"activeView"`
+	SeqNo           uint64            `json:"seqNo"`
+	LastExec        uint64            `json:"lastExec"`
+	LowWatermark    uint64            `json:"lowWatermark"`
+	HighWatermark   uint64            `json:"highWatermark"`
+	StateTransfer   bool              `json:"stateTransfer"`
+	Certs           []certDump        `json:"certs"`
+	Requests        []string          `json:"requests"`            // digests of the requests in the request store
+	Outstanding     []string          `json:"outstandingRequests"` // digests of the requests waiting to execute
+	ViewChanges     []viewChangeDump  `json:"viewChanges"`
+	NewViews        []newViewDump     `json:"newViews"`
+	Checkpoints     map[uint64]string `json:"checkpoints"` // checkpoints of the replica, by sequence number
+	CheckpointVotes []checkpointDump  `json:"checkpointVotes"`
+	HighCheckpoints map[uint64]uint64 `json:"highCheckpoints"` // highest checkpoint sequence number seen from each replica
+}
+
+// certDump is an entry of the certificate store
+type certDump struct {
+	View        uint64   `json:"view"`
+	SeqNo       uint64   `json:"seqNo"`
+	Digest      string   `json:"digest"`
+	PrePrepared bool     `json:"prePrepared"`
+	SentPrepare bool     `json:"sentPrepare"`
+	Prepares    []uint64 `json:"prepares"` // replicas whose prepare was received
+	SentCommit  bool     `json:"sentCommit"`
+	Commits     []uint64 `json:"commits"` // replicas whose commit was received
+}
+
+type viewChangeDump struct {
+	View      uint64         `json:"view"`
+	ReplicaID uint64         `json:"replicaID"`
+	H         uint64         `json:"h"`
+	Cset      []checkpointID `json:"cset"`
+	Pset      []pqDump       `json:"pset"`
+	Qset      []pqDump       `json:"qset"`
+}
+
+type newViewDump struct {
+	View      uint64            `json:"view"`
+	ReplicaID uint64            `json:"replicaID"`
+	Vset      []uint64          `json:"vset"` // replicas whose view change the new view includes
+	Xset      map[uint64]string `json:"xset"`
+}
+
+type checkpointID struct {
+	SeqNo uint64 `json:"seqNo"`
+	ID    string `json:"id"`
+}
+
+type checkpointDump struct {
+	SeqNo     uint64 `json:"seqNo"`
+	ReplicaID uint64 `json:"replicaID"`
+	ID        string `json:"id"`
+}
+
+type pqDump struct {
+	SeqNo  uint64 `json:"seqNo"`
+	Digest string `json:"digest"`
+	View   uint64 `json:"view"`
+}
+
+// RequestStateDump writes the soft state of the replica to w as JSON. The
+// state is taken on the event thread between two events, and written once
+// the event thread moved on
+func (instance *PbftCore) RequestStateDump(w io.Writer) error {
+	done := make(chan *stateDump, 1)
+	instance.Inject(func() {
+		done <- instance.dumpState()
+	})
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(<-done)
+}
+
+// dumpState takes the soft state of the replica, it must only be called
+// from the event thread
+func (instance *PbftCore) dumpState() *stateDump {
+	dump := &stateDump{
+		ReplicaID:       instance.ID,
+		View:            instance.View,
+		ActiveView:      instance.ActiveView,
+		SeqNo:           instance.seqNo,
+		LastExec:        instance.LastExec,
+		LowWatermark:    instance.h,
+		HighWatermark:   instance.h + instance.L,
+		StateTransfer:   instance.SkipInProgress,
+		Certs:           []certDump{},
+		Requests:        []string{},
+		Outstanding:     []string{},
+		ViewChanges:     []viewChangeDump{},
+		NewViews:        []newViewDump{},
+		Checkpoints:     make(map[uint64]string),
+		CheckpointVotes: []checkpointDump{},
+		HighCheckpoints: make(map[uint64]uint64),
+	}
+
+	for idx, cert := range instance.certStore {
+		cd := certDump{
+			View:        idx.v,
+			SeqNo:       idx.n,
+			Digest:      cert.digest,
+			PrePrepared: cert.prePrepare != nil,
+			SentPrepare: cert.sentPrepare,
+			Prepares:    []uint64{},
+			SentCommit:  cert.sentCommit,
+			Commits:     []uint64{},
+		}
+		for _, p := range cert.prepare {
+			cd.Prepares = append(cd.Prepares, p.ReplicaId)
+		}
+		for _, c := range cert.commit {
+			cd.Commits = append(cd.Commits, c.ReplicaId)
+		}
+		sort.Sort(sortableUint64Slice(cd.Prepares))
+		sort.Sort(sortableUint64Slice(cd.Commits))
+		dump.Certs = append(dump.Certs, cd)
+	}
+	sort.Sort(certDumps(dump.Certs))
+
+	for digest := range instance.reqStore {
+		dump.Requests = append(dump.Requests, digest)
+	}
+	sort.Strings(dump.Requests)
+	for digest := range instance.OutstandingReqs {
+		dump.Outstanding = append(dump.Outstanding, digest)
+	}
+	sort.Strings(dump.Outstanding)
+
+	for _, vc := range instance.viewChangeStore {
+		vcd := viewChangeDump{View: vc.View, ReplicaID: vc.ReplicaId, H: vc.H, Cset: []checkpointID{}, Pset: dumpPQ(vc.Pset), Qset: dumpPQ(vc.Qset)}
+		for _, c := range vc.Cset {
+			vcd.Cset = append(vcd.Cset, checkpointID{SeqNo: c.SequenceNumber, ID: c.Id})
+		}
+		dump.ViewChanges = append(dump.ViewChanges, vcd)
+	}
+	sort.Sort(viewChangeDumps(dump.ViewChanges))
+
+	for _, nv := range instance.newViewStore {
+		nvd := newViewDump{View: nv.View, ReplicaID: nv.ReplicaId, Vset: []uint64{}, Xset: make(map[uint64]string)}
+		for _, vc := range nv.Vset {
+			nvd.Vset = append(nvd.Vset, vc.ReplicaId)
+		}
+		sort.Sort(sortableUint64Slice(nvd.Vset))
+		for n, d := range nv.Xset {
+			nvd.Xset[n] = d
+		}
+		dump.NewViews = append(dump.NewViews, nvd)
+	}
+	sort.Sort(newViewDumps(dump.NewViews))
+
+	for n, id := range instance.chkpts {
+		dump.Checkpoints[n] = id
+	}
+	for chkpt := range instance.checkpointStore {
+		dump.CheckpointVotes = append(dump.CheckpointVotes, checkpointDump{SeqNo: chkpt.SequenceNumber, ReplicaID: chkpt.ReplicaId, ID: chkpt.Id})
+	}
+	sort.Sort(checkpointDumps(dump.CheckpointVotes))
+	for replica, n := range instance.hChkpts {
+		dump.HighCheckpoints[replica] = n
+	}
+	return dump
+}
+
+func dumpPQ(set []*ViewChange_PQ) []pqDump {
+	dumped := []pqDump{}
+	for _, pq := range set {
+		dumped = append(dumped, pqDump{SeqNo: pq.SequenceNumber, Digest: pq.Digest, View: pq.View})
+	}
+	return dumped
+}
+
+type certDumps []certDump
+
+func (a certDumps) Len() int      { return len(a) }
+func (a certDumps) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a certDumps) Less(i, j int) bool {
+	if a[i].View != a[j].View {
+		return a[i].View < a[j].View
+	}
+	return a[i].SeqNo < a[j].SeqNo
+}
+
+type viewChangeDumps []viewChangeDump
+
+func (a viewChangeDumps) Len() int      { return len(a) }
+func (a viewChangeDumps) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a viewChangeDumps) Less(i, j int) bool {
+	if a[i].View != a[j].View {
+		return a[i].View < a[j].View
+	}
+	return a[i].ReplicaID < a[j].ReplicaID
+}
+
+type newViewDumps []newViewDump
+
+func (a newViewDumps) Len() int           { return len(a) }
+func (a newViewDumps) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a newViewDumps) Less(i, j int) bool { return a[i].View < a[j].View }
+
+type checkpointDumps []checkpointDump
+
+func (a checkpointDumps) Len() int      { return len(a) }
+func (a checkpointDumps) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a checkpointDumps) Less(i, j int) bool {
+	if a[i].SeqNo != a[j].SeqNo {
+		return a[i].SeqNo < a[j].SeqNo
+	}
+	return a[i].ReplicaID < a[j].ReplicaID
+}
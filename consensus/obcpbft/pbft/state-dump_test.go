@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStateDump(t *testing.T) {
+	net := makePBFTNetwork(4, nil)
+	defer net.Stop()
+
+	req := createPbftRequestWithChainTx(1, 0)
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- req
+	if err := net.Process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	instance := net.pbftEndpoints[1].pbft
+	digest := HashReq(instance.Digest, req)
+	instance.viewChangeStore[vcidx{1, 3}] = &ViewChange{
+		View:      1,
+		H:         0,
+		ReplicaId: 3,
+		Cset:      []*ViewChange_C{{SequenceNumber: 0, Id: "zero"}},
+		Pset:      []*ViewChange_PQ{{SequenceNumber: 1, Digest: digest, View: 0}},
+	}
+	instance.checkpointStore[Checkpoint{SequenceNumber: 10, ReplicaId: 2, Id: "ten"}] = true
+
+	var buf bytes.Buffer
+	if err := instance.RequestStateDump(&buf); err != nil {
+		t.Fatalf("Could not dump the state: %s", err)
+	}
+	dump := &stateDump{}
+	if err := json.Unmarshal(buf.Bytes(), dump); err != nil {
+		t.Fatalf("Could not decode the dump: %s\n%s", err, buf.String())
+	}
+
+	if dump.ReplicaID != 1 || dump.View != 0 || !dump.ActiveView || dump.LastExec != 1 || dump.HighWatermark != instance.L {
+		t.Errorf("Unexpected replica state in dump %+v", dump)
+	}
+	expectedCert := certDump{
+		View:        0,
+		SeqNo:       1,
+		Digest:      digest,
+		PrePrepared: true,
+		SentPrepare: true,
+		Prepares:    []uint64{1, 2, 3},
+		SentCommit:  true,
+		Commits:     []uint64{0, 1, 2, 3},
+	}
+	if len(dump.Certs) != 1 || !reflect.DeepEqual(dump.Certs[0], expectedCert) {
+		t.Errorf("Expected certificate %+v, got %+v", expectedCert, dump.Certs)
+	}
+	if !reflect.DeepEqual(dump.Requests, []string{digest}) {
+		t.Errorf("Expected the request store to hold %s, got %v", digest, dump.Requests)
+	}
+	expectedVC := viewChangeDump{
+		View:      1,
+		ReplicaID: 3,
+		Cset:      []checkpointID{{SeqNo: 0, ID: "zero"}},
+		Pset:      []pqDump{{SeqNo: 1, Digest: digest, View: 0}},
+		Qset:      []pqDump{},
+	}
+	if len(dump.ViewChanges) != 1 || !reflect.DeepEqual(dump.ViewChanges[0], expectedVC) {
+		t.Errorf("Expected view change %+v, got %+v", expectedVC, dump.ViewChanges)
+	}
+	if !reflect.DeepEqual(dump.CheckpointVotes, []checkpointDump{{SeqNo: 10, ReplicaID: 2, ID: "ten"}}) {
+		t.Errorf("Unexpected checkpoint votes %+v", dump.CheckpointVotes)
+	}
+	if dump.Checkpoints[0] != instance.chkpts[0] {
+		t.Errorf("Expected checkpoint 0 of the replica, got %v", dump.Checkpoints)
+	}
+}
@@ -0,0 +1,407 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// thresholdWireVersion is the first wire version whose replicas understand checkpoint shares
+const thresholdWireVersion uint32 = 5
+
+// thresholdCombinations bounds the subsets of shares tried when some shares
+// are invalid, as a combination costs threshold modular exponentiations
+const thresholdCombinations = 64
+
+// ThresholdKey is the share of a replica of a threshold RSA key, after
+// Shoup, "Practical Threshold Signatures". Any threshold of the replicas
+// sign a message together, each with its own share, and the result is an
+// ordinary RSA signature under the public key
+type ThresholdKey struct {
+	Modulus   *big.Int `json:"modulus"`
+	Exponent  int64    `json:"exponent"`
+	Replicas  int      `json:"replicas"`  // number of shares dealt
+	Threshold int      `json:"threshold"` // number of shares needed to sign
+	ReplicaID uint64   `json:"replica_id"`
+	Share     *big.Int `json:"share"`
+}
+
+// DealThresholdKeys generates a threshold RSA key with a modulus of bits
+// bits, and returns the JSON encoded key share of each of the replicas, to
+// be handed to them through general.threshold.keyfile. The dealer learns the
+// whole private key, and must forget it afterwards
+func DealThresholdKeys(replicas, threshold, bits int) ([][]byte, error) {
+	if threshold < 1 || threshold > replicas {
+		return nil, fmt.Errorf("Threshold %d must be between 1 and the number of replicas %d", threshold, replicas)
+	}
+	e := big.NewInt(65537) // a prime exceeding the number of replicas, so it is coprime to replicas!
+	var n, m, d *big.Int
+	for d == nil {
+		p, err := rand.Prime(rand.Reader, bits/2)
+		if err != nil {
+			return nil, err
+		}
+		q, err := rand.Prime(rand.Reader, bits-bits/2)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+		n = new(big.Int).Mul(p, q)
+		m = new(big.Int).Mul(new(big.Int).Sub(p, big.NewInt(1)), new(big.Int).Sub(q, big.NewInt(1)))
+		d = new(big.Int).ModInverse(e, m)
+	}
+
+	// f(x) = d + a_1 x + ... + a_{threshold-1} x^{threshold-1} mod m
+	coefficients := []*big.Int{d}
+	for i := 1; i < threshold; i++ {
+		a, err := rand.Int(rand.Reader, m)
+		if err != nil {
+			return nil, err
+		}
+		coefficients = append(coefficients, a)
+	}
+	keys := make([][]byte, replicas)
+	for id := 0; id < replicas; id++ {
+		x := big.NewInt(int64(id + 1))
+		share := new(big.Int)
+		for i := len(coefficients) - 1; i >= 0; i-- {
+			share.Mul(share, x)
+			share.Add(share, coefficients[i])
+			share.Mod(share, m)
+		}
+		raw, err := json.Marshal(&ThresholdKey{
+			Modulus:   n,
+			Exponent:  e.Int64(),
+			Replicas:  replicas,
+			Threshold: threshold,
+			ReplicaID: uint64(id),
+			Share:     share,
+		})
+		if err != nil {
+			return nil, err
+		}
+		keys[id] = raw
+	}
+	return keys, nil
+}
+
+// delta is replicas!, which makes the Lagrange coefficients integers
+func (tk *ThresholdKey) delta() *big.Int {
+	return new(big.Int).MulRange(1, int64(tk.Replicas))
+}
+
+// digest maps msg onto the multiplicative group modulo the modulus
+func (tk *ThresholdKey) digest(msg []byte) *big.Int {
+	size := (tk.Modulus.BitLen()+7)/8 + 16
+	var expanded []byte
+	for counter := uint32(0); len(expanded) < size; counter++ {
+		h := sha256.New()
+		binary.Write(h, binary.BigEndian, counter)
+		h.Write(msg)
+		expanded = h.Sum(expanded)
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(expanded[:size]), tk.Modulus)
+}
+
+// signShare returns the share of this replica of the signature over msg,
+// x^(2 delta share)
+func (tk *ThresholdKey) signShare(msg []byte) []byte {
+	exp := new(big.Int).Mul(big.NewInt(2), tk.delta())
+	exp.Mul(exp, tk.Share)
+	return new(big.Int).Exp(tk.digest(msg), exp, tk.Modulus).Bytes()
+}
+
+// combine computes the signature over msg from the shares of exactly
+// threshold replicas, it returns nil if any of the shares is invalid
+func (tk *ThresholdKey) combine(msg []byte, shares map[uint64][]byte) []byte {
+	n := tk.Modulus
+	delta := tk.delta()
+	w := big.NewInt(1)
+	for j, share := range shares {
+		// lambda_j = delta * prod_{j' != j} j' / (j' - j), for the indices j+1 of the shares
+		num := new(big.Int).Set(delta)
+		den := big.NewInt(1)
+		for k := range shares {
+			if k == j {
+				continue
+			}
+			num.Mul(num, big.NewInt(int64(k+1)))
+			den.Mul(den, big.NewInt(int64(k)-int64(j)))
+		}
+		lambda, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+		if rem.Sign() != 0 {
+			return nil
+		}
+		base := new(big.Int).SetBytes(share)
+		exp := lambda.Mul(lambda, big.NewInt(2))
+		if exp.Sign() < 0 {
+			if base.ModInverse(base, n) == nil {
+				return nil
+			}
+			exp.Neg(exp)
+		}
+		w.Mul(w, base.Exp(base, exp, n))
+		w.Mod(w, n)
+	}
+
+	// w^e = x^(4 delta^2), and 4 delta^2 is coprime to e, so that
+	// a 4 delta^2 + b e = 1 yields y = w^a x^b with y^e = x
+	x := tk.digest(msg)
+	e := big.NewInt(tk.Exponent)
+	eprime := new(big.Int).Mul(delta, delta)
+	eprime.Mul(eprime, big.NewInt(4))
+	a, b := new(big.Int), new(big.Int)
+	if new(big.Int).GCD(a, b, eprime, e).Cmp(big.NewInt(1)) != 0 {
+		return nil
+	}
+	y := new(big.Int).Mul(modExp(w, a, n), modExp(x, b, n))
+	y.Mod(y, n)
+	if !tk.verify(msg, y.Bytes()) {
+		return nil
+	}
+	return y.Bytes()
+}
+
+// modExp computes base^exp mod n, for negative exponents too
+func modExp(base, exp, n *big.Int) *big.Int {
+	if exp.Sign() >= 0 {
+		return new(big.Int).Exp(base, exp, n)
+	}
+	inv := new(big.Int).ModInverse(base, n)
+	if inv == nil {
+		return big.NewInt(0)
+	}
+	return inv.Exp(inv, new(big.Int).Neg(exp), n)
+}
+
+// verify checks a combined signature over msg against the public key
+func (tk *ThresholdKey) verify(msg []byte, signature []byte) bool {
+	y := new(big.Int).SetBytes(signature)
+	if y.Cmp(tk.Modulus) >= 0 {
+		return false
+	}
+	return y.Exp(y, big.NewInt(tk.Exponent), tk.Modulus).Cmp(tk.digest(msg)) == 0
+}
+
+// checkpointStatement is what the replicas sign to certify a checkpoint
+func checkpointStatement(seqNo uint64, id string) []byte {
+	return []byte(fmt.Sprintf("checkpoint %d %s", seqNo, id))
+}
+
+// thresholdCheckpoints aggregates the checkpoints of a quorum into a
+// single certificate, which replaces the checkpoints of all of them in
+// checkpoint replies
+type thresholdCheckpoints struct {
+	key    *ThresholdKey
+	shares map[Checkpoint]map[uint64][]byte // shares received, by the checkpoint they sign, without replica id
+	tried  map[Checkpoint]int               // shares combined by the last failed attempt
+	certs  map[uint64]*CheckpointCertificate
+}
+
+// newThresholdCheckpoints returns nil if general.threshold.keyfile is empty
+func newThresholdCheckpoints(id uint64, N int, quorum int, config *viper.Viper) (*thresholdCheckpoints, error) {
+	keyFile := config.GetString("general.threshold.keyfile")
+	if keyFile == "" {
+		return nil, nil
+	}
+	raw, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read threshold key: %s", err)
+	}
+	key := &ThresholdKey{}
+	if err := json.Unmarshal(raw, key); err != nil {
+		return nil, fmt.Errorf("Cannot parse threshold key %s: %s", keyFile, err)
+	}
+	if key.Modulus == nil || key.Share == nil || key.Exponent <= int64(N) {
+		return nil, fmt.Errorf("Threshold key %s is incomplete", keyFile)
+	}
+	if key.ReplicaID != id || key.Replicas != N || key.Threshold != quorum {
+		return nil, fmt.Errorf("Threshold key %s is the share of replica %d of %d with threshold %d, need the share of replica %d of %d with threshold %d",
+			keyFile, key.ReplicaID, key.Replicas, key.Threshold, id, N, quorum)
+	}
+	return &thresholdCheckpoints{
+		key:    key,
+		shares: make(map[Checkpoint]map[uint64][]byte),
+		tried:  make(map[Checkpoint]int),
+		certs:  make(map[uint64]*CheckpointCertificate),
+	}, nil
+}
+
+// add records the share of a replica, and returns the certificate of the
+// checkpoint once the shares of a threshold of replicas combine into one
+func (tc *thresholdCheckpoints) add(cs *CheckpointShare) *CheckpointCertificate {
+	if cert, ok := tc.certs[cs.SequenceNumber]; ok && cert.Id == cs.Id {
+		return nil
+	}
+	idx := Checkpoint{SequenceNumber: cs.SequenceNumber, Id: cs.Id}
+	shares, ok := tc.shares[idx]
+	if !ok {
+		shares = make(map[uint64][]byte)
+		tc.shares[idx] = shares
+	}
+	shares[cs.ReplicaId] = cs.Share
+	if len(shares) < tc.key.Threshold || len(shares) == tc.tried[idx] {
+		return nil
+	}
+
+	signature := tc.key.combineAny(checkpointStatement(cs.SequenceNumber, cs.Id), shares)
+	if signature == nil {
+		tc.tried[idx] = len(shares)
+		return nil
+	}
+
+	cert := &CheckpointCertificate{SequenceNumber: cs.SequenceNumber, Id: cs.Id, Signature: signature}
+	tc.certs[cs.SequenceNumber] = cert
+	delete(tc.shares, idx)
+	delete(tc.tried, idx)
+	return cert
+}
+
+// combineAny computes the signature over msg from the shares of any
+// threshold of the replicas, trying subsets in turn as some shares may be
+// invalid. It returns nil if none of the subsets tried combine
+func (tk *ThresholdKey) combineAny(msg []byte, shares map[uint64][]byte) []byte {
+	var ids []uint64
+	for id := range shares {
+		ids = append(ids, id)
+	}
+	sort.Sort(sortableUint64Slice(ids))
+	var signature []byte
+	attempts := 0
+	eachSubset(ids, tk.Threshold, func(subset []uint64) bool {
+		selected := make(map[uint64][]byte)
+		for _, id := range subset {
+			selected[id] = shares[id]
+		}
+		signature = tk.combine(msg, selected)
+		attempts++
+		return signature == nil && attempts < thresholdCombinations
+	})
+	return signature
+}
+
+// eachSubset calls fn with every subset of ids of size k, until it returns false
+func eachSubset(ids []uint64, k int, fn func([]uint64) bool) {
+	subset := make([]uint64, 0, k)
+	var walk func(start int) bool
+	walk = func(start int) bool {
+		if len(subset) == k {
+			return fn(subset)
+		}
+		for i := start; i <= len(ids)-(k-len(subset)); i++ {
+			subset = append(subset, ids[i])
+			more := walk(i + 1)
+			subset = subset[:len(subset)-1]
+			if !more {
+				return false
+			}
+		}
+		return true
+	}
+	walk(0)
+}
+
+// check verifies that cert certifies stable
+func (tc *thresholdCheckpoints) check(stable *Checkpoint, cert *CheckpointCertificate) error {
+	if cert.SequenceNumber != stable.SequenceNumber || cert.Id != stable.Id {
+		return fmt.Errorf("threshold certificate for checkpoint %d certifies checkpoint %d with a different digest or sequence number",
+			stable.SequenceNumber, cert.SequenceNumber)
+	}
+	if !tc.key.verify(checkpointStatement(cert.SequenceNumber, cert.Id), cert.Signature) {
+		return fmt.Errorf("threshold certificate for checkpoint %d has an invalid signature", stable.SequenceNumber)
+	}
+	return nil
+}
+
+// prune forgets the shares and certificates of checkpoints below h
+func (tc *thresholdCheckpoints) prune(h uint64) {
+	for idx := range tc.shares {
+		if idx.SequenceNumber < h {
+			delete(tc.shares, idx)
+			delete(tc.tried, idx)
+		}
+	}
+	for n := range tc.certs {
+		if n < h {
+			delete(tc.certs, n)
+		}
+	}
+}
+
+// =============================================================================
+// pbftCore glue
+// =============================================================================
+
+// sendCheckpointShare signs the checkpoint with our key share, and sends
+// the share to the replicas which understand it
+func (instance *PbftCore) sendCheckpointShare(chkpt *Checkpoint) {
+	cs := &CheckpointShare{
+		ReplicaId:      instance.ID,
+		SequenceNumber: chkpt.SequenceNumber,
+		Id:             chkpt.Id,
+		Share:          instance.threshold.key.signShare(checkpointStatement(chkpt.SequenceNumber, chkpt.Id)),
+	}
+	instance.recvCheckpointShare(cs)
+	msgRaw, err := proto.Marshal(&Message{&Message_CheckpointShare{cs}})
+	if err != nil {
+		instance.log.Error("Cannot marshal checkpoint share: %s", err)
+		return
+	}
+	for i := 0; i < instance.N; i++ {
+		if id := uint64(i); id != instance.ID && instance.Wire.version(id) >= thresholdWireVersion {
+			instance.consumer.Unicast(msgRaw, id)
+		}
+	}
+}
+
+func (instance *PbftCore) recvCheckpointShare(cs *CheckpointShare) error {
+	if instance.threshold == nil {
+		return nil
+	}
+	if cs.SequenceNumber < instance.h || cs.SequenceNumber > instance.h+instance.L {
+		instance.log.Debug("Ignoring checkpoint share of replica %d for seqNo %d outside watermarks", cs.ReplicaId, cs.SequenceNumber)
+		return nil
+	}
+	if cert := instance.threshold.add(cs); cert != nil {
+		instance.log.Debug("Combined threshold certificate for checkpoint %d", cert.SequenceNumber)
+		instance.Metrics.thresholdCerts.Inc()
+	}
+	return nil
+}
+
+// thresholdCert returns the threshold certificate of the checkpoint at seqNo, or nil
+func (instance *PbftCore) thresholdCert(seqNo uint64, id string) *CheckpointCertificate {
+	if instance.threshold == nil {
+		return nil
+	}
+	if cert, ok := instance.threshold.certs[seqNo]; ok && cert.Id == id {
+		return cert
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dealTestKeys deals a small threshold key, 3 of 4, for speed
+func dealTestKeys(t *testing.T) []*ThresholdKey {
+	raw, err := DealThresholdKeys(4, 3, 512)
+	if err != nil {
+		t.Fatalf("Could not deal threshold keys: %s", err)
+	}
+	keys := make([]*ThresholdKey, len(raw))
+	for i := range raw {
+		keys[i] = &ThresholdKey{}
+		if err := json.Unmarshal(raw[i], keys[i]); err != nil {
+			t.Fatalf("Could not parse key share %d: %s", i, err)
+		}
+	}
+	return keys
+}
+
+// writeThresholdKey writes a key share to a file, for general.threshold.keyfile
+func writeThresholdKey(t *testing.T, key *ThresholdKey) (string, func()) {
+	dir, err := ioutil.TempDir("", "threshold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.json")
+	raw, _ := json.Marshal(key)
+	if err := ioutil.WriteFile(keyFile, raw, 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return keyFile, func() { os.RemoveAll(dir) }
+}
+
+func TestThresholdSignatures(t *testing.T) {
+	keys := dealTestKeys(t)
+	msg := checkpointStatement(10, "ten")
+	shares := make(map[uint64][]byte)
+	for i, key := range keys {
+		shares[uint64(i)] = key.signShare(msg)
+	}
+
+	var first []byte
+	eachSubset([]uint64{0, 1, 2, 3}, 3, func(subset []uint64) bool {
+		selected := make(map[uint64][]byte)
+		for _, id := range subset {
+			selected[id] = shares[id]
+		}
+		signature := keys[0].combine(msg, selected)
+		if signature == nil {
+			t.Errorf("Expected the shares of replicas %v to combine", subset)
+			return true
+		}
+		if first == nil {
+			first = signature
+		} else if !bytes.Equal(first, signature) {
+			t.Errorf("Expected the shares of replicas %v to combine into the same signature", subset)
+		}
+		return true
+	})
+	if !keys[3].verify(msg, first) {
+		t.Errorf("Expected the combined signature to verify")
+	}
+	if keys[3].verify(checkpointStatement(20, "ten"), first) {
+		t.Errorf("Expected the combined signature not to verify for another checkpoint")
+	}
+
+	tc := &thresholdCheckpoints{
+		key:    keys[0],
+		shares: make(map[Checkpoint]map[uint64][]byte),
+		tried:  make(map[Checkpoint]int),
+		certs:  make(map[uint64]*CheckpointCertificate),
+	}
+	forged := keys[3].signShare(checkpointStatement(20, "ten"))
+	for _, cs := range []*CheckpointShare{
+		{ReplicaId: 0, SequenceNumber: 10, Id: "ten", Share: shares[0]},
+		{ReplicaId: 1, SequenceNumber: 10, Id: "ten", Share: shares[1]},
+		{ReplicaId: 3, SequenceNumber: 10, Id: "ten", Share: forged},
+	} {
+		if cert := tc.add(cs); cert != nil {
+			t.Fatalf("Expected no certificate while a share is invalid")
+		}
+	}
+	cert := tc.add(&CheckpointShare{ReplicaId: 2, SequenceNumber: 10, Id: "ten", Share: shares[2]})
+	if cert == nil {
+		t.Fatalf("Expected the valid shares to combine despite the invalid one")
+	}
+	if err := tc.check(&Checkpoint{SequenceNumber: 10, Id: "ten"}, cert); err != nil {
+		t.Errorf("Expected the certificate to verify: %s", err)
+	}
+	if err := tc.check(&Checkpoint{SequenceNumber: 10, Id: "eleven"}, cert); err == nil {
+		t.Errorf("Expected the certificate not to certify another checkpoint")
+	}
+
+	tc.prune(20)
+	if len(tc.certs) != 0 || len(tc.shares) != 0 {
+		t.Errorf("Expected certificates and shares below the low watermark to be pruned")
+	}
+}
+
+func TestThresholdCheckpointReply(t *testing.T) {
+	keys := dealTestKeys(t)
+	keyFile, cleanup := writeThresholdKey(t, keys[3])
+	defer cleanup()
+
+	skipped := uint64(0)
+	mock := &omniProto{
+		broadcastImpl:       func(msgPayload []byte) {},
+		invalidateStateImpl: func() {},
+		skipToImpl: func(s uint64, id []byte, replicas []uint64) {
+			skipped = s
+		},
+	}
+	config := loadConfig()
+	config.Set("general.threshold.keyfile", keyFile)
+	instance := newPbftCore(3, config, mock)
+	defer instance.Close()
+	if instance.threshold == nil {
+		t.Fatalf("Expected threshold certificates to be enabled")
+	}
+	instance.requestCheckpointCerts()
+
+	id := base64.StdEncoding.EncodeToString([]byte("ten"))
+	msg := checkpointStatement(10, id)
+	stable := &Checkpoint{SequenceNumber: 10, ReplicaId: 0, Id: id, DigestAlgorithm: instance.Digest.name()}
+
+	bogus := &CheckpointCertificate{SequenceNumber: 10, Id: id, Signature: []byte("bogus")}
+	reply := &CheckpointReply{ReplicaId: 0, Stable: stable, HighWatermark: 50, ThresholdCertificate: bogus}
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_CheckpointReply{reply}}, sender: 0})
+	if skipped != 0 {
+		t.Fatalf("Expected a reply with an invalid threshold certificate to be rejected")
+	}
+
+	shares := map[uint64][]byte{0: keys[0].signShare(msg), 1: keys[1].signShare(msg), 2: keys[2].signShare(msg)}
+	cert := &CheckpointCertificate{SequenceNumber: 10, Id: id, Signature: keys[0].combine(msg, shares)}
+	reply = &CheckpointReply{ReplicaId: 0, Stable: stable, HighWatermark: 50, ThresholdCertificate: cert}
+	sendEvent(instance, pbftMessageEvent{msg: &Message{&Message_CheckpointReply{reply}}, sender: 0})
+	if skipped != 10 {
+		t.Fatalf("Expected a single reply with a threshold certificate to trigger state transfer to seqNo 10, skipped to %d", skipped)
+	}
+}
+
+func TestThresholdKeyMismatch(t *testing.T) {
+	keys := dealTestKeys(t)
+	keyFile, cleanup := writeThresholdKey(t, keys[1])
+	defer cleanup()
+
+	config := loadConfig()
+	config.Set("general.threshold.keyfile", keyFile)
+	if _, err := newThresholdCheckpoints(2, 4, 3, config); err == nil {
+		t.Errorf("Expected the key share of another replica to be rejected")
+	}
+	if tc, err := newThresholdCheckpoints(1, 4, 3, config); tc == nil || err != nil {
+		t.Errorf("Expected the key share of the replica to load, got %v", err)
+	}
+}
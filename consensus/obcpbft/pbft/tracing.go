@@ -0,0 +1,306 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// SpanContext identifies a span of a trace. The context of the span of the
+// submission of a request travels with the request, in its trace field, so
+// that the spans every replica records for the request join one trace.
+// Tracers which identify spans by more than two IDs, e.g. OpenTracing
+// implementations, also set Carrier, the span context they injected into a
+// text map, and extract the parent of a span from it
+type SpanContext struct {
+	TraceID uint64
+	SpanID  uint64
+	Carrier map[string]string
+}
+
+// Span is an operation of a trace, as in OpenTracing
+type Span interface {
+	// SetTag annotates the span
+	SetTag(key string, value interface{})
+	// Finish ends the span at end, it is called once
+	Finish(end time.Time)
+	// Context identifies the span
+	Context() SpanContext
+}
+
+// Tracer exports the spans the replicas record. It is the subset of an
+// OpenTracing tracer the core needs, NewOpenTracingTracer adapts an
+// OpenTracing implementation
+type Tracer interface {
+	// StartSpan starts a span of operation at start, as a child of parent,
+	// or as the root of a new trace if parent is the zero SpanContext
+	StartSpan(operation string, parent SpanContext, start time.Time) Span
+}
+
+var (
+	tracerLock   sync.RWMutex
+	globalTracer Tracer
+)
+
+// SetTracer installs the tracer the spans of the replicas are exported to,
+// nil to log them
+func SetTracer(t Tracer) {
+	tracerLock.Lock()
+	defer tracerLock.Unlock()
+	globalTracer = t
+}
+
+func currentTracer() Tracer {
+	tracerLock.RLock()
+	defer tracerLock.RUnlock()
+	if globalTracer == nil {
+		return defaultTracer
+	}
+	return globalTracer
+}
+
+// requestTracer records the spans of the phases of the traced requests, and
+// decides which of the requests the replica submits are traced. The phases
+// are preprepare, prepare, commit and execute, a phase starts once the
+// previous one completed, i.e. the preprepare phase once the request
+// arrived, the prepare phase once it is pre-prepared, and so on
+type requestTracer struct {
+	lock       sync.Mutex
+	clock      Clock
+	replica    uint64
+	sampleRate float64
+	rand       *rand.Rand
+	requests   map[string]*requestTrace // by digest
+	roots      map[uint64]Span          // spans of the submissions of the replica, by span ID
+}
+
+// requestTrace is the span of the phase a traced request is in
+type requestTrace struct {
+	parent SpanContext
+	phase  string
+	seqNo  uint64 // sequence number the request was last assigned, 0 if none
+	span   Span
+}
+
+// newRequestTracer creates the requestTracer of replica id, nil if
+// tracing is disabled
+func newRequestTracer(id uint64, config *viper.Viper, clk Clock) (*requestTracer, error) {
+	rate := config.GetFloat64("general.tracing.samplerate")
+	if rate == 0 {
+		return nil, nil
+	}
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("Tracing sample rate must be between 0 and 1, got %v", rate)
+	}
+	return &requestTracer{
+		clock:      clk,
+		replica:    id,
+		sampleRate: rate,
+		rand:       rand.New(rand.NewSource(clk.Now().UnixNano() + int64(id))),
+		requests:   make(map[string]*requestTrace),
+		roots:      make(map[uint64]Span),
+	}, nil
+}
+
+// Submit starts the trace of req, a request the replica submits, if it is
+// sampled. It must be called before req is hashed or sent
+func (rt *requestTracer) Submit(req *Request) {
+	if rt == nil {
+		return
+	}
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	if rt.rand.Float64() >= rt.sampleRate {
+		return
+	}
+	root := currentTracer().StartSpan("submission", SpanContext{}, rt.clock.Now())
+	root.SetTag("replica", rt.replica)
+	ctx := root.Context()
+	rt.roots[ctx.SpanID] = root
+	req.Trace = traceContext(ctx)
+}
+
+// traceContext is the wire form of ctx, with its carrier sorted by key so
+// that the request carrying it marshals the same on every replica
+func traceContext(ctx SpanContext) *TraceContext {
+	tc := &TraceContext{TraceId: ctx.TraceID, SpanId: ctx.SpanID}
+	keys := make([]string, 0, len(ctx.Carrier))
+	for key := range ctx.Carrier {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tc.Carrier = append(tc.Carrier, &TraceCarrierEntry{Key: key, Value: ctx.Carrier[key]})
+	}
+	return tc
+}
+
+// spanContext is the SpanContext of the wire form tc
+func spanContext(tc *TraceContext) SpanContext {
+	ctx := SpanContext{TraceID: tc.TraceId, SpanID: tc.SpanId}
+	if len(tc.Carrier) > 0 {
+		ctx.Carrier = make(map[string]string, len(tc.Carrier))
+		for _, entry := range tc.Carrier {
+			ctx.Carrier[entry.Key] = entry.Value
+		}
+	}
+	return ctx
+}
+
+// enter finishes the span of the phase request digest is in, and starts the
+// span of phase, unless the request is in phase already or is not traced
+func (rt *requestTracer) enter(digest string, req *Request, phase string, v, n uint64) {
+	if rt == nil || req == nil || req.Trace == nil {
+		return
+	}
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	now := rt.clock.Now()
+	trace, ok := rt.requests[digest]
+	if !ok {
+		trace = &requestTrace{parent: spanContext(req.Trace)}
+		rt.requests[digest] = trace
+	}
+	if trace.phase == phase {
+		return
+	}
+	if trace.span != nil {
+		trace.span.Finish(now)
+	}
+	trace.phase = phase
+	if n != 0 {
+		trace.seqNo = n
+	}
+	trace.span = currentTracer().StartSpan(phase, trace.parent, now)
+	trace.span.SetTag("replica", rt.replica)
+	trace.span.SetTag("view", v)
+	if n != 0 {
+		trace.span.SetTag("seqNo", n)
+	}
+}
+
+// finish ends the trace of request digest on the replica, along with the
+// span of its submission if the replica submitted it. Outcome tags the span
+// of the last phase, empty if the request executed
+func (rt *requestTracer) finish(digest string, outcome string) {
+	if rt == nil {
+		return
+	}
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	trace, ok := rt.requests[digest]
+	if !ok {
+		return
+	}
+	delete(rt.requests, digest)
+	now := rt.clock.Now()
+	if outcome != "" {
+		trace.span.SetTag("outcome", outcome)
+	}
+	trace.span.Finish(now)
+	if root, ok := rt.roots[trace.parent.SpanID]; ok {
+		delete(rt.roots, trace.parent.SpanID)
+		root.Finish(now)
+	}
+}
+
+// collect ends the traces of the requests assigned a sequence number up to
+// h which did not execute on the replica, e.g. as it transferred its state
+func (rt *requestTracer) collect(h uint64) {
+	if rt == nil {
+		return
+	}
+	rt.lock.Lock()
+	var discarded []string
+	for digest, trace := range rt.requests {
+		if trace.seqNo != 0 && trace.seqNo <= h {
+			discarded = append(discarded, digest)
+		}
+	}
+	rt.lock.Unlock()
+	for _, digest := range discarded {
+		rt.finish(digest, "discarded")
+	}
+}
+
+// traceEnter notes that request digest entered phase at view v and
+// sequence number n, 0 if it has none yet
+func (instance *PbftCore) traceEnter(digest string, phase string, v, n uint64) {
+	if instance.Tracing == nil || digest == "" {
+		return
+	}
+	instance.Tracing.enter(digest, instance.reqStore[digest], phase, v, n)
+}
+
+// spanIDs draws the IDs of the spans a tracer starts
+type spanIDs struct {
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+func newSpanIDs() *spanIDs {
+	return &spanIDs{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// next returns a random non-zero ID
+func (ids *spanIDs) next() uint64 {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	return uint64(ids.rand.Int63()) + 1
+}
+
+// logTracer logs the spans, it is used when no tracer is installed
+type logTracer struct {
+	ids *spanIDs
+}
+
+var defaultTracer Tracer = &logTracer{ids: newSpanIDs()}
+
+type logSpan struct {
+	operation string
+	ctx       SpanContext
+	parent    uint64
+	start     time.Time
+	tags      []string
+}
+
+func (lt *logTracer) StartSpan(operation string, parent SpanContext, start time.Time) Span {
+	span := &logSpan{operation: operation, ctx: SpanContext{TraceID: parent.TraceID}, parent: parent.SpanID, start: start}
+	if parent.TraceID == 0 {
+		span.ctx.TraceID = lt.ids.next()
+	}
+	span.ctx.SpanID = lt.ids.next()
+	return span
+}
+
+func (ls *logSpan) SetTag(key string, value interface{}) {
+	ls.tags = append(ls.tags, fmt.Sprintf("%s=%v", key, value))
+}
+
+func (ls *logSpan) Finish(end time.Time) {
+	logger.Info("Trace %x span %x (parent %x) %s took %v %v", ls.ctx.TraceID, ls.ctx.SpanID, ls.parent, ls.operation, end.Sub(ls.start), ls.tags)
+}
+
+func (ls *logSpan) Context() SpanContext {
+	return ls.ctx
+}
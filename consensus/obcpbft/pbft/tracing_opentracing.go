@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// openTracingTracer exports the spans of the replicas to an OpenTracing
+// implementation. The context of a span travels between the replicas in the
+// TextMap format, as the carrier of its SpanContext
+type openTracingTracer struct {
+	tracer opentracing.Tracer
+	ids    *spanIDs
+}
+
+type openTracingSpan struct {
+	span opentracing.Span
+	ctx  SpanContext
+}
+
+// NewOpenTracingTracer adapts tracer, to install it through SetTracer
+func NewOpenTracingTracer(tracer opentracing.Tracer) Tracer {
+	return &openTracingTracer{tracer: tracer, ids: newSpanIDs()}
+}
+
+func (ot *openTracingTracer) StartSpan(operation string, parent SpanContext, start time.Time) Span {
+	options := []opentracing.StartSpanOption{opentracing.StartTime(start)}
+	if len(parent.Carrier) > 0 {
+		// the parent may have been injected by another implementation, the
+		// span then starts a trace of its own
+		parentCtx, err := ot.tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier(parent.Carrier))
+		if err != nil {
+			logger.Warning("Cannot extract the context of span %x of trace %x, starting a new trace: %s", parent.SpanID, parent.TraceID, err)
+		} else {
+			options = append(options, opentracing.ChildOf(parentCtx))
+		}
+	}
+	span := ot.tracer.StartSpan(operation, options...)
+
+	ctx := SpanContext{TraceID: parent.TraceID, SpanID: ot.ids.next()}
+	if ctx.TraceID == 0 {
+		ctx.TraceID = ot.ids.next()
+	}
+	carrier := opentracing.TextMapCarrier{}
+	if err := ot.tracer.Inject(span.Context(), opentracing.TextMap, carrier); err != nil {
+		logger.Warning("Cannot inject the context of span %s, its children will start traces of their own: %s", operation, err)
+	} else {
+		ctx.Carrier = carrier
+	}
+	return &openTracingSpan{span: span, ctx: ctx}
+}
+
+func (ots *openTracingSpan) SetTag(key string, value interface{}) {
+	ots.span.SetTag(key, value)
+}
+
+func (ots *openTracingSpan) Finish(end time.Time) {
+	ots.span.FinishWithOptions(opentracing.FinishOptions{FinishTime: end})
+}
+
+func (ots *openTracingSpan) Context() SpanContext {
+	return ots.ctx
+}
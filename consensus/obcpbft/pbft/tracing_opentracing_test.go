@@ -0,0 +1,104 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestOpenTracingTracer(t *testing.T) {
+	tracer := mocktracer.New()
+	SetTracer(NewOpenTracingTracer(tracer))
+	defer SetTracer(nil)
+
+	config := loadConfig()
+	config.Set("general.tracing.samplerate", 1)
+	net := makePBFTNetwork(4, config)
+	defer net.Stop()
+
+	req := createPbftRequestWithChainTx(1, 0)
+	net.pbftEndpoints[0].pbft.Tracing.Submit(req)
+	if req.Trace == nil || len(req.Trace.Carrier) == 0 {
+		t.Fatalf("Expected the request to carry the injected context of its submission, got %v", req.Trace)
+	}
+	for i := 1; i < len(req.Trace.Carrier); i++ {
+		if req.Trace.Carrier[i-1].Key >= req.Trace.Carrier[i].Key {
+			t.Errorf("Expected the carrier to be sorted by key, got %v", req.Trace.Carrier)
+			break
+		}
+	}
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- req
+	net.Process()
+
+	// the other replicas only know the submission through the carrier the
+	// request brought them
+	spans := tracer.FinishedSpans()
+	var root *mocktracer.MockSpan
+	for _, span := range spans {
+		if span.OperationName == "submission" {
+			root = span
+		}
+	}
+	if root == nil {
+		t.Fatalf("Expected the span of the submission to finish once the request executed, got %v", spans)
+	}
+	if root.ParentID != 0 {
+		t.Errorf("Expected the submission to start a trace, its parent is %d", root.ParentID)
+	}
+	phases := make(map[uint64][]string)
+	for _, span := range spans {
+		if span == root {
+			continue
+		}
+		if span.SpanContext.TraceID != root.SpanContext.TraceID || span.ParentID != root.SpanContext.SpanID {
+			t.Errorf("Expected span %s to be a child of the submission %v, got %v", span.OperationName, root.SpanContext, span)
+		}
+		if span.StartTime.IsZero() || span.FinishTime.Before(span.StartTime) {
+			t.Errorf("Expected span %s to finish after it started, got %v to %v", span.OperationName, span.StartTime, span.FinishTime)
+		}
+		if span.OperationName != "preprepare" && span.Tag("seqNo") != uint64(1) {
+			t.Errorf("Expected span %s to be tagged with sequence number 1, got %v", span.OperationName, span.Tag("seqNo"))
+		}
+		replica := span.Tag("replica").(uint64)
+		phases[replica] = append(phases[replica], span.OperationName)
+	}
+	expectPhases(t, phases)
+}
+
+func TestOpenTracingTracerForeignParent(t *testing.T) {
+	tracer := mocktracer.New()
+	ot := NewOpenTracingTracer(tracer)
+	start := time.Unix(1, 0)
+
+	// a parent injected by another implementation cannot be extracted
+	foreign := SpanContext{TraceID: 7, SpanID: 8, Carrier: map[string]string{"uber-trace-id": "1:2:0:1"}}
+	span := ot.StartSpan("prepare", foreign, start)
+	span.Finish(start.Add(time.Second))
+	if ctx := span.Context(); ctx.TraceID != 7 || ctx.SpanID == 0 || len(ctx.Carrier) == 0 {
+		t.Errorf("Expected the span to keep the trace of its parent and carry its own context, got %+v", ctx)
+	}
+	finished := tracer.FinishedSpans()
+	if len(finished) != 1 || finished[0].ParentID != 0 {
+		t.Fatalf("Expected the span to start a trace of its own, got %v", finished)
+	}
+	if !finished[0].StartTime.Equal(start) || finished[0].FinishTime.Sub(finished[0].StartTime) != time.Second {
+		t.Errorf("Expected the span to keep the times of the replica, got %v to %v", finished[0].StartTime, finished[0].FinishTime)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedSpan struct {
+	operation string
+	ctx       SpanContext
+	parent    SpanContext
+	tags      map[string]interface{}
+	finished  bool
+}
+
+func (rs *recordedSpan) SetTag(key string, value interface{}) { rs.tags[key] = value }
+func (rs *recordedSpan) Finish(end time.Time)                 { rs.finished = true }
+func (rs *recordedSpan) Context() SpanContext                 { return rs.ctx }
+
+// recordingTracer keeps the spans the replicas start
+type recordingTracer struct {
+	lock  sync.Mutex
+	next  uint64
+	spans []*recordedSpan
+}
+
+func (rt *recordingTracer) StartSpan(operation string, parent SpanContext, start time.Time) Span {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	rt.next++
+	span := &recordedSpan{operation: operation, ctx: parent, parent: parent, tags: make(map[string]interface{})}
+	if parent.TraceID == 0 {
+		span.ctx.TraceID = rt.next
+	}
+	span.ctx.SpanID = rt.next
+	rt.spans = append(rt.spans, span)
+	return span
+}
+
+func TestTraceRequestPhases(t *testing.T) {
+	tracer := &recordingTracer{}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	config := loadConfig()
+	config.Set("general.tracing.samplerate", 1)
+	net := makePBFTNetwork(4, config)
+	defer net.Stop()
+
+	req := createPbftRequestWithChainTx(1, 0)
+	net.pbftEndpoints[0].pbft.Tracing.Submit(req)
+	if req.Trace == nil {
+		t.Fatalf("Expected the request to be traced")
+	}
+	net.pbftEndpoints[0].pbft.Manager.Queue() <- req
+	net.Process()
+
+	tracer.lock.Lock()
+	defer tracer.lock.Unlock()
+	root := tracer.spans[0]
+	if root.operation != "submission" || !root.finished {
+		t.Fatalf("Expected the span of the submission to finish once the request executed, got %+v", root)
+	}
+	phases := make(map[uint64][]string)
+	for _, span := range tracer.spans[1:] {
+		if span.ctx.TraceID != root.ctx.TraceID || span.parent.TraceID != root.ctx.TraceID || span.parent.SpanID != root.ctx.SpanID {
+			t.Errorf("Expected span %s to be a child of the submission, got %+v", span.operation, span)
+		}
+		if !span.finished {
+			t.Errorf("Expected span %s of replica %v to be finished", span.operation, span.tags["replica"])
+		}
+		if span.operation != "preprepare" && span.tags["seqNo"] != uint64(1) {
+			t.Errorf("Expected span %s to be tagged with sequence number 1, got %v", span.operation, span.tags["seqNo"])
+		}
+		replica := span.tags["replica"].(uint64)
+		phases[replica] = append(phases[replica], span.operation)
+	}
+	expectPhases(t, phases)
+}
+
+// expectPhases checks the phases each of 4 replicas recorded spans of, in
+// order, for a request replica 0 submitted
+func expectPhases(t *testing.T, phases map[uint64][]string) {
+	for id := uint64(0); id < 4; id++ {
+		expected := []string{"prepare", "commit", "execute"}
+		if id == 0 {
+			expected = append([]string{"preprepare"}, expected...)
+		}
+		if len(phases[id]) != len(expected) {
+			t.Errorf("Expected replica %d to record phases %v, got %v", id, expected, phases[id])
+			continue
+		}
+		for i := range expected {
+			if phases[id][i] != expected[i] {
+				t.Errorf("Expected replica %d to record phases %v, got %v", id, expected, phases[id])
+				break
+			}
+		}
+	}
+}
+
+func TestTraceSampleRate(t *testing.T) {
+	config := loadConfig()
+	if rt, err := newRequestTracer(0, config, wallClock{}); rt != nil || err != nil {
+		t.Errorf("Expected tracing to be disabled by default, got %v, %v", rt, err)
+	}
+	config.Set("general.tracing.samplerate", 2)
+	if _, err := newRequestTracer(0, config, wallClock{}); err == nil {
+		t.Errorf("Expected a sample rate above 1 to be rejected")
+	}
+
+	config.Set("general.tracing.samplerate", 0.5)
+	rt, _ := newRequestTracer(0, config, wallClock{})
+	traced := 0
+	for i := 0; i < 1000; i++ {
+		req := createPbftRequestWithChainTx(int64(i), 0)
+		rt.Submit(req)
+		if req.Trace != nil {
+			traced++
+		}
+	}
+	if traced < 400 || traced > 600 {
+		t.Errorf("Expected about half of the requests to be traced, %d of 1000 were", traced)
+	}
+}
@@ -17,17 +17,30 @@ specific language governing permissions and limitations
 under the License.
 */
 
-package obcpbft
+package pbft
 
 import (
 	"encoding/base64"
-
-	"github.com/hyperledger/fabric/core/util"
+	"fmt"
 
 	"github.com/golang/protobuf/proto"
 )
 
-func hashReq(req *Request) string {
+func HashReq(digest DigestProvider, req *Request) string {
 	raw, _ := proto.Marshal(req)
-	return base64.StdEncoding.EncodeToString(util.ComputeCryptoHash(raw))
+	return base64.StdEncoding.EncodeToString(digest.hash(raw))
+}
+
+// ReqLabel identifies req in the logs by its digest, and the correlation ID
+// its client attached, if any
+func ReqLabel(digest string, req *Request) string {
+	if req == nil || req.CorrelationId == "" {
+		return digest
+	}
+	return fmt.Sprintf("%s (correlation %s)", digest, req.CorrelationId)
+}
+
+func HashBatch(digest DigestProvider, reqs []*Request) string {
+	raw, _ := proto.Marshal(&RequestBlock{reqs})
+	return base64.StdEncoding.EncodeToString(digest.hash(raw))
 }
@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// viewChangeBackoff is the policy by which the new view timeout grows while
+// view changes fail, and shrinks back once requests commit again
+type viewChangeBackoff struct {
+	multiplier    float64       // factor applied to the timeout after each failed view change
+	max           time.Duration // upper bound of the timeout, 0 if unbounded
+	stableCommits uint64        // commits after which the timeout is relaxed
+	decay         float64       // factor the timeout is divided by when relaxed, 0 to reset it
+
+	commits uint64 // commits since the timeout was last escalated or relaxed
+}
+
+// newViewChangeBackoff reads the general.timeout.backoff section of the
+// configuration, base is the initial new view timeout
+func newViewChangeBackoff(config *viper.Viper, base time.Duration) (*viewChangeBackoff, error) {
+	multiplier := config.GetFloat64("general.timeout.backoff.multiplier")
+	if multiplier < 1 {
+		return nil, fmt.Errorf("view change backoff multiplier must be at least 1, got %v", multiplier)
+	}
+	max, err := time.ParseDuration(config.GetString("general.timeout.backoff.max"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse view change backoff maximum: %s", err)
+	}
+	if max != 0 && max < base {
+		return nil, fmt.Errorf("view change backoff maximum %v is below the view change timeout %v", max, base)
+	}
+	stableCommits := config.GetInt("general.timeout.backoff.stablecommits")
+	if stableCommits < 1 {
+		return nil, fmt.Errorf("view change backoff stable commits must be positive, got %d", stableCommits)
+	}
+	decay := config.GetFloat64("general.timeout.backoff.decay")
+	if decay != 0 && decay < 1 {
+		return nil, fmt.Errorf("view change backoff decay must be 0 or at least 1, got %v", decay)
+	}
+
+	return &viewChangeBackoff{
+		multiplier:    multiplier,
+		max:           max,
+		stableCommits: uint64(stableCommits),
+		decay:         decay,
+	}, nil
+}
+
+// escalate returns the timeout to use for the next view change, after one
+// using timeout failed
+func (b *viewChangeBackoff) escalate(timeout time.Duration) time.Duration {
+	b.commits = 0
+	next := time.Duration(float64(timeout) * b.multiplier)
+	if b.max != 0 && next > b.max {
+		next = b.max
+	}
+	return next
+}
+
+// committed records a committed request, and returns the relaxed timeout once
+// enough requests committed since the last escalation, never below base
+func (b *viewChangeBackoff) committed(timeout, base time.Duration) time.Duration {
+	b.commits++
+	if b.commits < b.stableCommits {
+		return timeout
+	}
+	b.commits = 0
+	if b.decay == 0 {
+		return base
+	}
+	next := time.Duration(float64(timeout) / b.decay)
+	if next < base {
+		next = base
+	}
+	return next
+}
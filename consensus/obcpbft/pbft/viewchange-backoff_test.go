@@ -0,0 +1,92 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestViewChangeBackoffDefault(t *testing.T) {
+	base := 2 * time.Second
+	b, err := newViewChangeBackoff(loadConfig(), base)
+	if err != nil {
+		t.Fatalf("Failed to create backoff: %s", err)
+	}
+
+	timeout := base
+	for i := 0; i < 3; i++ {
+		timeout = b.escalate(timeout)
+	}
+	if timeout != 16*time.Second {
+		t.Errorf("Expected the timeout to double without bound, got %v", timeout)
+	}
+	if timeout = b.committed(timeout, base); timeout != base {
+		t.Errorf("Expected the timeout to reset on the first commit, got %v", timeout)
+	}
+}
+
+func TestViewChangeBackoffPolicy(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.timeout.backoff.multiplier", 3)
+	config.Set("general.timeout.backoff.max", "10s")
+	config.Set("general.timeout.backoff.stablecommits", 2)
+	config.Set("general.timeout.backoff.decay", 2)
+	base := time.Second
+	b, err := newViewChangeBackoff(config, base)
+	if err != nil {
+		t.Fatalf("Failed to create backoff: %s", err)
+	}
+
+	timeout := b.escalate(base)
+	if timeout != 3*time.Second {
+		t.Errorf("Expected a timeout of 3s, got %v", timeout)
+	}
+	if timeout = b.escalate(b.escalate(timeout)); timeout != 10*time.Second {
+		t.Errorf("Expected the timeout to be capped at 10s, got %v", timeout)
+	}
+
+	expected := []time.Duration{10 * time.Second, 5 * time.Second, 5 * time.Second, 2500 * time.Millisecond,
+		2500 * time.Millisecond, 1250 * time.Millisecond, 1250 * time.Millisecond, time.Second}
+	for i, e := range expected {
+		if timeout = b.committed(timeout, base); timeout != e {
+			t.Errorf("Expected a timeout of %v after %d commits, got %v", e, i+1, timeout)
+		}
+	}
+
+	b.committed(timeout, base)
+	if timeout = b.escalate(timeout); timeout != 3*time.Second {
+		t.Errorf("Expected a timeout of 3s, got %v", timeout)
+	}
+	if timeout = b.committed(timeout, base); timeout != 3*time.Second {
+		t.Errorf("Expected an escalation to restart the stable period, got %v", timeout)
+	}
+}
+
+func TestViewChangeBackoffInvalid(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.timeout.backoff.max", "1s")
+	if _, err := newViewChangeBackoff(config, 2*time.Second); err == nil {
+		t.Errorf("Expected a maximum below the view change timeout to be rejected")
+	}
+
+	config = loadConfig()
+	config.Set("general.timeout.backoff.multiplier", 0.5)
+	if _, err := newViewChangeBackoff(config, 2*time.Second); err == nil {
+		t.Errorf("Expected a shrinking multiplier to be rejected")
+	}
+}
@@ -14,7 +14,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package obcpbft
+package pbft
 
 import (
 	"encoding/base64"
@@ -22,11 +22,11 @@ import (
 	"reflect"
 )
 
-func (instance *pbftCore) correctViewChange(vc *ViewChange) bool {
+func (instance *PbftCore) correctViewChange(vc *ViewChange) bool {
 	for _, p := range append(vc.Pset, vc.Qset...) {
 		if !(p.View < vc.View && p.SequenceNumber > vc.H && p.SequenceNumber <= vc.H+instance.L) {
-			logger.Debug("Replica %d invalid p entry in view-change: vc(v:%d h:%d) p(v:%d n:%d)",
-				instance.id, vc.View, vc.H, p.View, p.SequenceNumber)
+			instance.vcLog.Debug("Invalid p entry in view-change: vc(v:%d h:%d) p(v:%d n:%d)",
+				vc.View, vc.H, p.View, p.SequenceNumber)
 			return false
 		}
 	}
@@ -34,8 +34,8 @@ func (instance *pbftCore) correctViewChange(vc *ViewChange) bool {
 	for _, c := range vc.Cset {
 		// PBFT: the paper says c.n > vc.h
 		if !(c.SequenceNumber >= vc.H && c.SequenceNumber <= vc.H+instance.L) {
-			logger.Debug("Replica %d invalid c entry in view-change: vc(v:%d h:%d) c(n:%d)",
-				instance.id, vc.View, vc.H, c.SequenceNumber)
+			instance.vcLog.Debug("Invalid c entry in view-change: vc(v:%d h:%d) c(n:%d)",
+				vc.View, vc.H, c.SequenceNumber)
 			return false
 		}
 	}
@@ -43,7 +43,7 @@ func (instance *pbftCore) correctViewChange(vc *ViewChange) bool {
 	return true
 }
 
-func (instance *pbftCore) calcPSet() map[uint64]*ViewChange_PQ {
+func (instance *PbftCore) calcPSet() map[uint64]*ViewChange_PQ {
 	pset := make(map[uint64]*ViewChange_PQ)
 
 	for n, p := range instance.pset {
@@ -79,7 +79,7 @@ func (instance *pbftCore) calcPSet() map[uint64]*ViewChange_PQ {
 	return pset
 }
 
-func (instance *pbftCore) calcQSet() map[qidx]*ViewChange_PQ {
+func (instance *PbftCore) calcQSet() map[qidx]*ViewChange_PQ {
 	qset := make(map[qidx]*ViewChange_PQ)
 
 	for n, q := range instance.qset {
@@ -117,32 +117,55 @@ func (instance *pbftCore) calcQSet() map[qidx]*ViewChange_PQ {
 	return qset
 }
 
-func (instance *pbftCore) sendViewChange() error {
-	instance.stopTimer()
+// RequestViewChange has the replica vote for the next view on behalf of an
+// operator, the other replicas join the view change once f+1 voted for it
+func (instance *PbftCore) RequestViewChange() error {
+	done := make(chan error, 1)
+	instance.Inject(func() {
+		if !instance.ActiveView {
+			done <- fmt.Errorf("Replica %d is already changing to view %d", instance.ID, instance.View)
+			return
+		}
+		instance.vcLog.Notice("Operator requested a view change away from view %d, primary %d", instance.View, instance.Primary(instance.View))
+		instance.SendViewChange()
+		done <- nil
+	})
+	return <-done
+}
+
+func (instance *PbftCore) SendViewChange() error {
+	instance.StopTimer()
 
-	delete(instance.newViewStore, instance.view)
-	instance.view++
-	instance.activeView = false
+	delete(instance.newViewStore, instance.View)
+	instance.View++
+	instance.ActiveView = false
 
 	instance.pset = instance.calcPSet()
 	instance.qset = instance.calcQSet()
 
+	if responsive := instance.responsiveReplicas(); responsive < instance.allCorrectReplicasQuorum() {
+		instance.vcLog.Warning("Heard from only %d replicas recently, view %d needs %d of them to be installed",
+			responsive, instance.View, instance.allCorrectReplicasQuorum())
+	}
+
 	// clear old messages
 	for idx := range instance.certStore {
-		if idx.v < instance.view {
+		if idx.v < instance.View {
 			delete(instance.certStore, idx)
 		}
 	}
 	for idx := range instance.viewChangeStore {
-		if idx.v < instance.view {
+		if idx.v < instance.View {
 			delete(instance.viewChangeStore, idx)
 		}
 	}
 
 	vc := &ViewChange{
-		View:      instance.view,
+		View:      instance.View,
 		H:         instance.h,
-		ReplicaId: instance.id,
+		ReplicaId: instance.ID,
+		Epoch:     instance.epoch,
+		Blacklist: instance.blacklist.state(),
 	}
 
 	for n, id := range instance.chkpts {
@@ -160,36 +183,41 @@ func (instance *pbftCore) sendViewChange() error {
 		vc.Qset = append(vc.Qset, q)
 	}
 
-	instance.sign(vc)
+	instance.Sign(vc)
+	instance.persistViewChange(vc)
 
-	logger.Info("Replica %d sending view-change, v:%d, h:%d, |C|:%d, |P|:%d, |Q|:%d",
-		instance.id, vc.View, vc.H, len(vc.Cset), len(vc.Pset), len(vc.Qset))
+	instance.vcLog.Info("Sending view-change, v:%d, h:%d, |C|:%d, |P|:%d, |Q|:%d",
+		vc.View, vc.H, len(vc.Cset), len(vc.Pset), len(vc.Qset))
 
 	instance.recvViewChange(vc)
-	return instance.innerBroadcast(&Message{&Message_ViewChange{vc}})
+	return instance.reliableBroadcast(&Message{&Message_ViewChange{vc}})
 }
 
-func (instance *pbftCore) recvViewChange(vc *ViewChange) error {
-	logger.Info("Replica %d received view-change from replica %d, v:%d, h:%d, |C|:%d, |P|:%d, |Q|:%d",
-		instance.id, vc.ReplicaId, vc.View, vc.H, len(vc.Cset), len(vc.Pset), len(vc.Qset))
+func (instance *PbftCore) recvViewChange(vc *ViewChange) error {
+	instance.vcLog.Info("Received view-change from replica %d, v:%d, h:%d, |C|:%d, |P|:%d, |Q|:%d",
+		vc.ReplicaId, vc.View, vc.H, len(vc.Cset), len(vc.Pset), len(vc.Qset))
 
-	if err := instance.verify(vc); err != nil {
-		logger.Warning("Replica %d found incorrect signature in view-change message: %s", instance.id, err)
+	if err := instance.Verify(vc); err != nil {
+		instance.vcLog.Warning("Found incorrect signature in view-change message: %s", err)
 		return nil
 	}
 
-	if vc.View < instance.view {
-		logger.Warning("Replica %d found view-change message for old view", instance.id)
+	if vc.View < instance.View {
+		instance.vcLog.Warning("Found view-change message for old view")
 		return nil
 	}
 
 	if !instance.correctViewChange(vc) {
-		logger.Warning("Replica %d found view-change message incorrect", instance.id)
+		instance.vcLog.Warning("Found view-change message incorrect")
 		return nil
 	}
 
 	if _, ok := instance.viewChangeStore[vcidx{vc.View, vc.ReplicaId}]; ok {
-		logger.Warning("Replica %d already has a view change message for view %d from replica %d", instance.id, vc.View, vc.ReplicaId)
+		instance.vcLog.Warning("Already have a view change message for view %d from replica %d", vc.View, vc.ReplicaId)
+		return nil
+	}
+
+	if !instance.admitViewChange(vc) {
 		return nil
 	}
 
@@ -203,7 +231,7 @@ func (instance *pbftCore) recvViewChange(vc *ViewChange) error {
 	replicas := make(map[uint64]bool)
 	minView := uint64(0)
 	for idx := range instance.viewChangeStore {
-		if idx.v <= instance.view {
+		if idx.v <= instance.View {
 			continue
 		}
 
@@ -212,29 +240,36 @@ func (instance *pbftCore) recvViewChange(vc *ViewChange) error {
 			minView = idx.v
 		}
 	}
-	if len(replicas) >= instance.f+1 {
-		logger.Info("Replica %d received f+1 view-change messages, triggering view-change to view %d",
-			instance.id, minView)
+	if len(replicas) >= instance.F+1 {
+		instance.vcLog.Info("Received f+1 view-change messages, triggering view-change to view %d",
+			minView)
 		// subtract one, because sendViewChange() increments
-		instance.view = minView - 1
-		return instance.sendViewChange()
+		instance.View = minView - 1
+		return instance.SendViewChange()
 	}
 
 	quorum := 0
 	for idx := range instance.viewChangeStore {
-		if idx.v == instance.view {
+		if idx.v == instance.View {
 			quorum++
 		}
 	}
-	logger.Debug("Replica %d now has %d view change requests for view %d", instance.id, quorum, instance.view)
+	instance.vcLog.Debug("Now have %d view change requests for view %d", quorum, instance.View)
 
-	if !instance.activeView && vc.View == instance.view && quorum >= instance.allCorrectReplicasQuorum() {
+	if !instance.ActiveView && vc.View == instance.View && quorum >= instance.allCorrectReplicasQuorum() {
 		if quorum == instance.allCorrectReplicasQuorum() {
-			instance.startTimer(instance.lastNewViewTimeout, "new view change")
-			instance.lastNewViewTimeout = 2 * instance.lastNewViewTimeout
+			timeout := instance.LastNewViewTimeout
+			if primary := instance.Primary(instance.View); !instance.responsive(primary) {
+				// Do not wait out an escalated timeout for a primary whose heartbeats stopped
+				instance.vcLog.Info("Have not heard from replica %d, the primary of view %d, waiting %v for its new view",
+					primary, instance.View, instance.NewViewTimeout)
+				timeout = instance.NewViewTimeout
+			}
+			instance.StartTimer(timeout, "new view change")
+			instance.LastNewViewTimeout = instance.backoff.escalate(instance.LastNewViewTimeout)
 		}
 
-		if instance.primary(instance.view) == instance.id {
+		if instance.Primary(instance.View) == instance.ID {
 			return instance.sendNewView()
 		}
 	}
@@ -243,10 +278,10 @@ func (instance *pbftCore) recvViewChange(vc *ViewChange) error {
 
 }
 
-func (instance *pbftCore) sendNewView() (err error) {
+func (instance *PbftCore) sendNewView() (err error) {
 
-	if _, ok := instance.newViewStore[instance.view]; ok {
-		logger.Debug("Replica %d already has new view in store for view %d, skipping", instance.id, instance.view)
+	if _, ok := instance.newViewStore[instance.View]; ok {
+		instance.vcLog.Debug("Already have new view in store for view %d, skipping", instance.View)
 		return
 	}
 
@@ -254,105 +289,116 @@ func (instance *pbftCore) sendNewView() (err error) {
 
 	cp, ok, _ := instance.selectInitialCheckpoint(vset)
 	if !ok {
-		logger.Info("Replica %d could not find consistent checkpoint: %+v", instance.id, instance.viewChangeStore)
+		instance.vcLog.Info("Could not find consistent checkpoint: %+v", instance.viewChangeStore)
 		return
 	}
 
 	msgList := instance.assignSequenceNumbers(vset, cp.SequenceNumber)
 	if msgList == nil {
-		logger.Info("Replica %d could not assign sequence numbers for new view", instance.id)
+		instance.vcLog.Info("Could not assign sequence numbers for new view")
 		return
 	}
 
 	nv := &NewView{
-		View:      instance.view,
+		View:      instance.View,
 		Vset:      vset,
 		Xset:      msgList,
-		ReplicaId: instance.id,
+		ReplicaId: instance.ID,
+		Epoch:     instance.epoch,
+	}
+	if primary := instance.newViewPrimary(nv); primary != instance.ID {
+		instance.vcLog.Warning("Not sending new-view for view %d, its view-changes agree on replica %d as primary", nv.View, primary)
+		return
 	}
 
-	logger.Info("Replica %d is new primary, sending new-view, v:%d, X:%+v",
-		instance.id, nv.View, nv.Xset)
+	instance.vcLog.Info("New primary sending new-view, v:%d, X:%+v",
+		nv.View, nv.Xset)
 
-	err = instance.innerBroadcast(&Message{&Message_NewView{nv}})
+	err = instance.reliableBroadcast(&Message{&Message_NewView{nv}})
 	if err != nil {
 		return err
 	}
-	instance.newViewStore[instance.view] = nv
+	instance.newViewStore[instance.View] = nv
 	return instance.processNewView()
 }
 
-func (instance *pbftCore) recvNewView(nv *NewView) error {
-	logger.Info("Replica %d received new-view %d",
-		instance.id, nv.View)
+func (instance *PbftCore) recvNewView(nv *NewView) error {
+	instance.vcLog.Info("Received new-view %d",
+		nv.View)
 
-	if !(nv.View > 0 && nv.View >= instance.view && instance.primary(nv.View) == nv.ReplicaId && instance.newViewStore[nv.View] == nil) {
-		logger.Info("Replica %d rejecting invalid new-view from %d, v:%d",
-			instance.id, nv.ReplicaId, nv.View)
+	if !(nv.View > 0 && nv.View >= instance.View && instance.newViewPrimary(nv) == nv.ReplicaId && instance.newViewStore[nv.View] == nil) {
+		instance.vcLog.Info("Rejecting invalid new-view from %d, v:%d",
+			nv.ReplicaId, nv.View)
 		return nil
 	}
 
 	for _, vc := range nv.Vset {
-		if err := instance.verify(vc); err != nil {
-			logger.Warning("Replica %d found incorrect view-change signature in new-view message: %s", instance.id, err)
+		if err := instance.Verify(vc); err != nil {
+			instance.vcLog.Warning("Found incorrect view-change signature in new-view message: %s", err)
 			return nil
 		}
 	}
 
+	if !instance.admitNewView(nv) {
+		return nil
+	}
+
 	instance.newViewStore[nv.View] = nv
 	return instance.processNewView()
 }
 
-func (instance *pbftCore) processNewView() error {
+func (instance *PbftCore) processNewView() error {
 	var newRequestMissing bool
-	nv, ok := instance.newViewStore[instance.view]
+	nv, ok := instance.newViewStore[instance.View]
 	if !ok {
-		logger.Debug("Replica %d ignoring processNewView as it could not find view %d in its newViewStore", instance.id, instance.view)
+		instance.vcLog.Debug("Ignoring processNewView as it could not find view %d in its newViewStore", instance.View)
 		return nil
 	}
 
-	if instance.activeView {
-		logger.Info("Replica %d ignoring new-view from %d, v:%d: we are active in view %d",
-			instance.id, nv.ReplicaId, nv.View, instance.view)
+	if instance.ActiveView {
+		instance.vcLog.Info("Ignoring new-view from %d, v:%d: we are active in view %d",
+			nv.ReplicaId, nv.View, instance.View)
 		return nil
 	}
 
 	cp, ok, replicas := instance.selectInitialCheckpoint(nv.Vset)
 	if !ok {
-		logger.Warning("Replica %d could not determine initial checkpoint: %+v",
-			instance.id, instance.viewChangeStore)
-		return instance.sendViewChange()
+		instance.vcLog.Warning("Could not determine initial checkpoint: %+v",
+			instance.viewChangeStore)
+		return instance.SendViewChange()
 	}
 
 	msgList := instance.assignSequenceNumbers(nv.Vset, cp.SequenceNumber)
 	if msgList == nil {
-		logger.Warning("Replica %d could not assign sequence numbers: %+v",
-			instance.id, instance.viewChangeStore)
-		return instance.sendViewChange()
+		instance.vcLog.Warning("Could not assign sequence numbers: %+v",
+			instance.viewChangeStore)
+		return instance.SendViewChange()
 	}
 
 	if !(len(msgList) == 0 && len(nv.Xset) == 0) && !reflect.DeepEqual(msgList, nv.Xset) {
-		logger.Warning("Replica %d failed to verify new-view Xset: computed %+v, received %+v",
-			instance.id, msgList, nv.Xset)
-		return instance.sendViewChange()
+		instance.vcLog.Warning("Failed to verify new-view Xset: computed %+v, received %+v",
+			msgList, nv.Xset)
+		return instance.SendViewChange()
 	}
 
 	if instance.h < cp.SequenceNumber {
 		instance.moveWatermarks(cp.SequenceNumber)
 	}
 
-	if instance.lastExec < cp.SequenceNumber {
-		logger.Warning("Replica %d missing base checkpoint %d (%s)", instance.id, cp.SequenceNumber, cp.Id)
+	if instance.LastExec < cp.SequenceNumber {
+		instance.vcLog.Warning("Missing base checkpoint %d (%s)", cp.SequenceNumber, cp.Id)
 
 		snapshotID, err := base64.StdEncoding.DecodeString(cp.Id)
 		if nil != err {
-			err = fmt.Errorf("Replica %d received a view change who's hash could not be decoded (%s)", instance.id, cp.Id)
-			logger.Error(err.Error())
+			err = fmt.Errorf("Replica %d received a view change who's hash could not be decoded (%s)", instance.ID, cp.Id)
+			instance.vcLog.Error("%s", err)
 			return nil
 		}
 
-		instance.consumer.skipTo(cp.SequenceNumber, snapshotID, replicas)
-		instance.lastExec = cp.SequenceNumber
+		instance.rollbackSpeculation()
+		instance.rankByCheckpoint(replicas)
+		instance.consumer.SkipTo(cp.SequenceNumber, snapshotID, replicas)
+		instance.LastExec = cp.SequenceNumber
 	}
 
 	for n, d := range nv.Xset {
@@ -367,11 +413,11 @@ func (instance *pbftCore) processNewView() error {
 			}
 
 			if _, ok := instance.reqStore[d]; !ok {
-				logger.Warning("Replica %d missing assigned, non-checkpointed request %s",
-					instance.id, d)
+				instance.vcLog.Warning("Missing assigned, non-checkpointed request %s",
+					d)
 				if _, ok := instance.missingReqs[d]; !ok {
-					logger.Warning("Replica %v requesting to fetch %s",
-						instance.id, d)
+					instance.vcLog.Warning("Replica %v requesting to fetch %s",
+						instance.ID, d)
 					newRequestMissing = true
 					instance.missingReqs[d] = true
 				}
@@ -388,62 +434,76 @@ func (instance *pbftCore) processNewView() error {
 	return nil
 }
 
-func (instance *pbftCore) processNewView2(nv *NewView) error {
-	logger.Info("Replica %d accepting new-view to view %d", instance.id, instance.view)
+func (instance *PbftCore) processNewView2(nv *NewView) error {
+	instance.vcLog.Info("Accepting new-view to view %d", instance.View)
 
-	instance.stopTimer()
-	instance.nullRequestTimer.stop()
+	instance.StopTimer()
+	instance.nullRequestTimer.Stop()
 
-	instance.activeView = true
-	delete(instance.newViewStore, instance.view-1)
+	instance.ActiveView = true
+	instance.Metrics.viewChanges.Inc()
+	instance.installBlacklist(nv)
+	delete(instance.newViewStore, instance.View-1)
 
 	instance.seqNo = 0
+	var msgs []*Message
 	for n, d := range nv.Xset {
 		preprep := &PrePrepare{
-			View:           instance.view,
+			View:           instance.View,
 			SequenceNumber: n,
 			RequestDigest:  d,
-			ReplicaId:      instance.id,
+			ReplicaId:      instance.ID,
+			Epoch:          instance.epoch,
 		}
-		cert := instance.getCert(instance.view, n)
+		cert := instance.getCert(instance.View, n)
 		cert.prePrepare = preprep
 		cert.digest = d
 		if n > instance.seqNo {
 			instance.seqNo = n
 		}
-		instance.persistQSet()
+		msgs = append(msgs, &Message{&Message_PrePrepare{preprep}})
 	}
+	instance.persistMessages(msgs...)
 
 	instance.updateViewChangeSeqNo()
 
-	if instance.primary(instance.view) != instance.id {
+	if s := instance.speculation; s != nil && s.active && nv.Xset[s.n] != s.digest {
+		instance.rollbackSpeculation()
+	}
+
+	if instance.Primary(instance.View) != instance.ID {
 		for n, d := range nv.Xset {
 			prep := &Prepare{
-				View:           instance.view,
+				View:           instance.View,
 				SequenceNumber: n,
 				RequestDigest:  d,
-				ReplicaId:      instance.id,
+				ReplicaId:      instance.ID,
+				Epoch:          instance.epoch,
+			}
+			if err := instance.authenticate(prep); err != nil {
+				instance.vcLog.Error("Cannot authenticate prepare: %s", err)
+				continue
 			}
-			cert := instance.getCert(instance.view, n)
+			cert := instance.getCert(instance.View, n)
 			cert.sentPrepare = true
 			instance.recvPrepare(prep)
-			instance.innerBroadcast(&Message{&Message_Prepare{prep}})
+			instance.broadcastVote(prep.View, &Message{&Message_Prepare{prep}})
 		}
 	} else {
-		logger.Debug("Replica %d is now primary, attempting to resubmit requests", instance.id)
+		instance.vcLog.Debug("Now primary, attempting to resubmit requests")
 		instance.resubmitRequests()
 	}
 
 	instance.startTimerIfOutstandingRequests()
 
-	logger.Debug("Replica %d done cleaning view change artifacts, calling into consumer", instance.id)
+	instance.vcLog.Debug("Done cleaning view change artifacts, calling into consumer")
 
-	instance.manager.inject(viewChangedEvent{})
+	instance.Manager.Inject(ViewChangedEvent{})
 
 	return nil
 }
 
-func (instance *pbftCore) getViewChanges() (vset []*ViewChange) {
+func (instance *PbftCore) getViewChanges() (vset []*ViewChange) {
 	for _, vc := range instance.viewChangeStore {
 		vset = append(vset, vc)
 	}
@@ -451,26 +511,26 @@ func (instance *pbftCore) getViewChanges() (vset []*ViewChange) {
 	return
 }
 
-func (instance *pbftCore) selectInitialCheckpoint(vset []*ViewChange) (checkpoint ViewChange_C, ok bool, replicas []uint64) {
+func (instance *PbftCore) selectInitialCheckpoint(vset []*ViewChange) (checkpoint ViewChange_C, ok bool, replicas []uint64) {
 	checkpoints := make(map[ViewChange_C][]*ViewChange)
 	for _, vc := range vset {
 		for _, c := range vc.Cset { // TODO, verify that we strip duplicate checkpoints from this set
 			checkpoints[*c] = append(checkpoints[*c], vc)
-			logger.Debug("Replica %d appending checkpoint from replica %d with seqNo=%d, h=%d, and checkpoint digest %s", instance.id, vc.ReplicaId, vc.H, c.SequenceNumber, c.Id)
+			instance.vcLog.Debug("Appending checkpoint from replica %d with seqNo=%d, h=%d, and checkpoint digest %s", vc.ReplicaId, vc.H, c.SequenceNumber, c.Id)
 		}
 	}
 
 	if len(checkpoints) == 0 {
-		logger.Debug("Replica %d has no checkpoints to select from: %d %s",
-			instance.id, len(instance.viewChangeStore), checkpoints)
+		instance.vcLog.Debug("Have no checkpoints to select from: %d %v",
+			len(instance.viewChangeStore), checkpoints)
 		return
 	}
 
 	for idx, vcList := range checkpoints {
 		// need weak certificate for the checkpoint
-		if len(vcList) <= instance.f { // type casting necessary to match types
-			logger.Debug("Replica %d has no weak certificate for n:%d, vcList was %d long",
-				instance.id, idx.SequenceNumber, len(vcList))
+		if len(vcList) <= instance.F { // type casting necessary to match types
+			instance.vcLog.Debug("Have no weak certificate for n:%d, vcList was %d long",
+				idx.SequenceNumber, len(vcList))
 			continue
 		}
 
@@ -485,7 +545,7 @@ func (instance *pbftCore) selectInitialCheckpoint(vset []*ViewChange) (checkpoin
 		}
 
 		if quorum < instance.intersectionQuorum() {
-			logger.Debug("Replica %d has no quorum for n:%d", instance.id, idx.SequenceNumber)
+			instance.vcLog.Debug("Have no quorum for n:%d", idx.SequenceNumber)
 			continue
 		}
 
@@ -503,7 +563,7 @@ func (instance *pbftCore) selectInitialCheckpoint(vset []*ViewChange) (checkpoin
 	return
 }
 
-func (instance *pbftCore) assignSequenceNumbers(vset []*ViewChange, h uint64) (msgList map[uint64]string) {
+func (instance *PbftCore) assignSequenceNumbers(vset []*ViewChange, h uint64) (msgList map[uint64]string) {
 	msgList = make(map[uint64]string)
 
 	maxN := h + 1
@@ -546,7 +606,7 @@ nLoop:
 					}
 				}
 
-				if quorum < instance.f+1 {
+				if quorum < instance.F+1 {
 					continue
 				}
 
@@ -578,7 +638,7 @@ nLoop:
 			continue nLoop
 		}
 
-		logger.Warning("Replica %d could not assign value to contents of seqNo %d, found only %d missing P entries", instance.id, n, quorum)
+		instance.vcLog.Warning("Could not assign value to contents of seqNo %d, found only %d missing P entries", n, quorum)
 		return nil
 	}
 
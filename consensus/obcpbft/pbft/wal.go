@@ -0,0 +1,386 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	walPrefix             = "wal."
+	walManifestKey        = "walmanifest"
+	walQuarantinePrefix   = "walquarantine."
+	defaultWALSegmentSize = 1000
+)
+
+var walChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// wal is an append-only write-ahead log of pbft messages, kept through the
+// StatePersistor of the stack. Each record is stored under the key
+// wal.<segment>.<record>, so that the keys sort in the order in which the
+// records were appended. A segment holds at most segmentSize records, and
+// is deleted as a whole once all its records are below the low watermark.
+// Every record carries a checksum, and a manifest, written in the same
+// batch as the records, lists how many records each segment holds, so that
+// replay detects damaged as well as lost records
+type wal struct {
+	persistor   Persistor
+	segmentSize uint64
+
+	segment  uint64            // segment records are currently appended to
+	record   uint64            // index of the next record in the segment
+	segments map[uint64]uint64 // highest sequence number recorded in each segment
+	counts   map[uint64]uint64 // number of records in each segment, as listed by the manifest
+}
+
+// walCorruption lists the records which failed verification on replay, by
+// key, along with the reason
+type walCorruption map[string]string
+
+func (c walCorruption) Error() string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	msg := fmt.Sprintf("%d damaged or missing WAL records:", len(c))
+	for _, key := range keys {
+		msg += fmt.Sprintf(" %s (%s)", key, c[key])
+	}
+	return msg
+}
+
+func newWAL(persistor Persistor, segmentSize uint64) *wal {
+	if segmentSize == 0 {
+		segmentSize = defaultWALSegmentSize
+	}
+	return &wal{
+		persistor:   persistor,
+		segmentSize: segmentSize,
+		segments:    make(map[uint64]uint64),
+		counts:      make(map[uint64]uint64),
+	}
+}
+
+// sealWALRecord prefixes raw with its checksum
+func sealWALRecord(raw []byte) []byte {
+	sealed := make([]byte, 4, 4+len(raw))
+	binary.BigEndian.PutUint32(sealed, crc32.Checksum(raw, walChecksumTable))
+	return append(sealed, raw...)
+}
+
+// openWALRecord verifies the checksum of a sealed record and strips it
+func openWALRecord(sealed []byte) ([]byte, error) {
+	if len(sealed) < 4 {
+		return nil, fmt.Errorf("record of %d bytes is too short to hold a checksum", len(sealed))
+	}
+	raw := sealed[4:]
+	if sum := crc32.Checksum(raw, walChecksumTable); sum != binary.BigEndian.Uint32(sealed) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	return raw, nil
+}
+
+// encodeWALManifest encodes the record count of every segment
+func encodeWALManifest(counts map[uint64]uint64) []byte {
+	raw := make([]byte, 0, 2*binary.MaxVarintLen64*len(counts))
+	buf := make([]byte, binary.MaxVarintLen64)
+	for segment, count := range counts {
+		raw = append(raw, buf[:binary.PutUvarint(buf, segment)]...)
+		raw = append(raw, buf[:binary.PutUvarint(buf, count)]...)
+	}
+	return sealWALRecord(raw)
+}
+
+func decodeWALManifest(sealed []byte) (map[uint64]uint64, error) {
+	raw, err := openWALRecord(sealed)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[uint64]uint64)
+	for len(raw) > 0 {
+		segment, n := binary.Uvarint(raw)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed segment")
+		}
+		count, m := binary.Uvarint(raw[n:])
+		if m <= 0 {
+			return nil, fmt.Errorf("malformed record count of segment %d", segment)
+		}
+		counts[segment] = count
+		raw = raw[n+m:]
+	}
+	return counts, nil
+}
+
+func walSegmentPrefix(segment uint64) string {
+	return fmt.Sprintf("%s%010d.", walPrefix, segment)
+}
+
+func walKey(segment uint64, record uint64) string {
+	return fmt.Sprintf("%s%010d", walSegmentPrefix(segment), record)
+}
+
+// walSeqNo returns the sequence number a record is kept for. Requests and
+// view changes are not bound to a sequence number and return 0, they have to
+// be carried over when their segment is truncated
+func walSeqNo(msg *Message) uint64 {
+	switch x := msg.Payload.(type) {
+	case *Message_PrePrepare:
+		return x.PrePrepare.SequenceNumber
+	case *Message_Prepare:
+		return x.Prepare.SequenceNumber
+	case *Message_Commit:
+		return x.Commit.SequenceNumber
+	case *Message_Checkpoint:
+		return x.Checkpoint.SequenceNumber
+	}
+	return 0
+}
+
+// append writes msgs to the current segment in a single atomic write, so
+// that a crash cannot leave only some of them in the log, starting a new
+// segment once the current one is full
+func (w *wal) append(msgs ...*Message) error {
+	return w.write(msgs, nil, nil)
+}
+
+// write appends msgs, deletes the segments drop and stores the keys of state
+// in a single atomic write, along with the updated manifest. The log is only
+// advanced once the write succeeded
+func (w *wal) write(msgs []*Message, drop []uint64, state map[string][]byte) error {
+	counts := make(map[uint64]uint64, len(w.counts)+1)
+	for segment, count := range w.counts {
+		counts[segment] = count
+	}
+
+	var del []string
+	for _, segment := range drop {
+		records, err := w.persistor.ReadStateSet(walSegmentPrefix(segment))
+		if err != nil {
+			return fmt.Errorf("Could not read WAL segment %d: %s", segment, err)
+		}
+		for key := range records {
+			del = append(del, key)
+		}
+		delete(counts, segment)
+	}
+
+	store := make(map[string][]byte, len(msgs)+len(state)+1)
+	for key, value := range state {
+		store[key] = value
+	}
+	segments := make([]uint64, len(msgs))
+	segment, record := w.segment, w.record
+	for i, msg := range msgs {
+		raw, err := proto.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("Could not marshal WAL record: %s", err)
+		}
+		store[walKey(segment, record)] = sealWALRecord(raw)
+		segments[i] = segment
+		counts[segment]++
+		record++
+		if record == w.segmentSize {
+			segment++
+			record = 0
+		}
+	}
+	store[walManifestKey] = encodeWALManifest(counts)
+	if err := StoreStateBatch(w.persistor, store, del); err != nil {
+		return fmt.Errorf("Could not store WAL records: %s", err)
+	}
+
+	for i, msg := range msgs {
+		w.track(segments[i], msg)
+	}
+	for _, segment := range drop {
+		delete(w.segments, segment)
+	}
+	w.counts = counts
+	w.segment, w.record = segment, record
+	return nil
+}
+
+// track notes that segment holds msg
+func (w *wal) track(segment uint64, msg *Message) {
+	highest, ok := w.segments[segment]
+	if seqNo := walSeqNo(msg); !ok || seqNo > highest {
+		w.segments[segment] = seqNo
+	}
+}
+
+// rotate starts a new segment, unless the current one is still empty
+func (w *wal) rotate() {
+	if w.record == 0 {
+		return
+	}
+	w.segment++
+	w.record = 0
+}
+
+// truncatable returns the segments other than the current one whose records
+// are all below the low watermark h, in ascending order
+func (w *wal) truncatable(h uint64) []uint64 {
+	var segments []uint64
+	for segment, seqNo := range w.segments {
+		if segment != w.segment && seqNo < h {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Sort(sortableUint64Slice(segments))
+	return segments
+}
+
+// truncate deletes the segments whose records are all below the low
+// watermark h. As those segments may hold records which are still needed,
+// carry is invoked to append them again, in the same atomic write which
+// deletes the segments, so that a crash cannot lose them
+func (w *wal) truncate(h uint64, carry func() []*Message) error {
+	w.rotate()
+	segments := w.truncatable(h)
+	if len(segments) == 0 {
+		return nil
+	}
+
+	return w.write(carry(), segments, nil)
+}
+
+// reset replaces all records of the log by msgs, and stores the keys of
+// state in the same atomic write, so that a crash leaves either the old log
+// and state or the new ones
+func (w *wal) reset(msgs []*Message, state map[string][]byte) error {
+	w.rotate()
+	var segments []uint64
+	for segment := range w.counts {
+		segments = append(segments, segment)
+	}
+	sort.Sort(sortableUint64Slice(segments))
+	return w.write(msgs, segments, state)
+}
+
+// replay returns all records of the log in the order they were appended, and
+// positions the log on a new segment following the last one read. Records
+// which fail their checksum, are not listed by the manifest, or which the
+// manifest lists but are missing, are reported as a walCorruption, along
+// with the records which passed verification
+func (w *wal) replay() ([]*Message, error) {
+	records, err := w.persistor.ReadStateSet(walPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read WAL: %s", err)
+	}
+
+	corrupt := make(walCorruption)
+	counts := make(map[uint64]uint64)
+	if sealed, err := w.persistor.ReadState(walManifestKey); err == nil && sealed != nil {
+		if counts, err = decodeWALManifest(sealed); err != nil {
+			corrupt[walManifestKey] = err.Error()
+			counts = make(map[uint64]uint64)
+		}
+	} else if len(records) > 0 {
+		corrupt[walManifestKey] = "manifest missing"
+	}
+
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // keys are zero padded, so they sort in append order
+
+	var msgs []*Message
+	for _, key := range keys {
+		var segment, record uint64
+		if _, err := fmt.Sscanf(key, walPrefix+"%d.%d", &segment, &record); err != nil {
+			corrupt[key] = "malformed key"
+			continue
+		}
+		if record >= counts[segment] {
+			corrupt[key] = "not listed by the manifest"
+			continue
+		}
+		raw, err := openWALRecord(records[key])
+		if err != nil {
+			corrupt[key] = err.Error()
+			continue
+		}
+		msg := &Message{}
+		if err := proto.Unmarshal(raw, msg); err != nil {
+			corrupt[key] = err.Error()
+			continue
+		}
+		msgs = append(msgs, msg)
+
+		w.track(segment, msg)
+		if segment >= w.segment {
+			w.segment = segment + 1
+			w.record = 0
+		}
+	}
+	for segment, count := range counts {
+		for record := uint64(0); record < count; record++ {
+			if _, ok := records[walKey(segment, record)]; !ok {
+				corrupt[walKey(segment, record)] = "missing"
+			}
+		}
+		if segment >= w.segment {
+			w.segment = segment + 1
+			w.record = 0
+		}
+	}
+	w.counts = counts
+
+	if len(corrupt) > 0 {
+		return msgs, corrupt
+	}
+	return msgs, nil
+}
+
+// quarantine moves the damaged records out of the log, under the
+// walquarantine. prefix, so that they remain available for inspection, and
+// discards the rest of the log, which is no longer known to be complete
+func (w *wal) quarantine(corrupt walCorruption) error {
+	records, err := w.persistor.ReadStateSet(walPrefix)
+	if err != nil {
+		return fmt.Errorf("Could not read WAL: %s", err)
+	}
+	store := make(map[string][]byte)
+	var del []string
+	for key, value := range records {
+		if _, ok := corrupt[key]; ok {
+			store[walQuarantinePrefix+key] = value
+		}
+		del = append(del, key)
+	}
+	if _, ok := corrupt[walManifestKey]; ok {
+		if sealed, err := w.persistor.ReadState(walManifestKey); err == nil && sealed != nil {
+			store[walQuarantinePrefix+walManifestKey] = sealed
+		}
+	}
+	store[walManifestKey] = encodeWALManifest(nil)
+	if err := StoreStateBatch(w.persistor, store, del); err != nil {
+		return fmt.Errorf("Could not quarantine WAL records: %s", err)
+	}
+	w.segments = make(map[uint64]uint64)
+	w.counts = make(map[uint64]uint64)
+	w.rotate()
+	return nil
+}
@@ -0,0 +1,366 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func walPrepare(n uint64) *Message {
+	return &Message{&Message_Prepare{&Prepare{View: 0, SequenceNumber: n, RequestDigest: "foo", ReplicaId: 1}}}
+}
+
+func TestWALReplayOrder(t *testing.T) {
+	persist := &mockPersist{}
+	w := newWAL(persist, 3)
+
+	var appended []*Message
+	for n := uint64(1); n <= 10; n++ {
+		msg := walPrepare(n)
+		appended = append(appended, msg)
+		if err := w.append(msg); err != nil {
+			t.Fatalf("Failed to append record: %s", err)
+		}
+	}
+	if len(w.segments) != 4 {
+		t.Errorf("Expected 10 records to span 4 segments, got %d", len(w.segments))
+	}
+
+	restored := newWAL(persist, 3)
+	msgs, err := restored.replay()
+	if err != nil {
+		t.Fatalf("Failed to replay WAL: %s", err)
+	}
+	if len(msgs) != len(appended) {
+		t.Fatalf("Expected %d records, got %d", len(appended), len(msgs))
+	}
+	for i := range msgs {
+		if !proto.Equal(msgs[i], appended[i]) {
+			t.Errorf("Record %d was replayed out of order: %v", i, msgs[i])
+		}
+	}
+	if !reflect.DeepEqual(restored.segments, w.segments) {
+		t.Errorf("Replayed segments %v do not match the appended ones %v", restored.segments, w.segments)
+	}
+	if restored.segment != 4 || restored.record != 0 {
+		t.Errorf("Expected the replayed WAL to continue on a new segment, got segment %d record %d", restored.segment, restored.record)
+	}
+}
+
+func TestWALTruncate(t *testing.T) {
+	persist := &mockPersist{}
+	w := newWAL(persist, 3)
+	for n := uint64(1); n <= 7; n++ {
+		w.append(walPrepare(n))
+	}
+
+	req := &Message{&Message_Request{&Request{Payload: []byte("carried")}}}
+	carried := false
+	err := w.truncate(4, func() []*Message {
+		carried = true
+		return []*Message{req}
+	})
+	if err != nil {
+		t.Fatalf("Failed to truncate WAL: %s", err)
+	}
+	if !carried {
+		t.Fatalf("Expected records to be carried over")
+	}
+
+	msgs, err := newWAL(persist, 3).replay()
+	if err != nil {
+		t.Fatalf("Failed to replay WAL: %s", err)
+	}
+	var seqNos []uint64
+	for _, msg := range msgs[:len(msgs)-1] {
+		seqNos = append(seqNos, msg.GetPrepare().SequenceNumber)
+	}
+	if !reflect.DeepEqual(seqNos, []uint64{4, 5, 6, 7}) {
+		t.Errorf("Expected only the segments holding records at or above 4 to remain, got %v", seqNos)
+	}
+	if !proto.Equal(msgs[len(msgs)-1], req) {
+		t.Errorf("Expected the carried record to be replayed last, got %v", msgs[len(msgs)-1])
+	}
+
+	// The segment holding only the carried record is replaced by a new carry
+	w.truncate(4, func() []*Message {
+		return []*Message{req}
+	})
+	msgs, err = newWAL(persist, 3).replay()
+	if err != nil {
+		t.Fatalf("Failed to replay WAL: %s", err)
+	}
+	if len(msgs) != 5 {
+		t.Errorf("Expected the carried record to be replayed once, got %d records", len(msgs))
+	}
+}
+
+// batchPersist applies batches to the underlying mockPersist atomically,
+// unless failing is set, in which case nothing is written
+type batchPersist struct {
+	mockPersist
+	batches int
+	failing bool
+}
+
+func (p *batchPersist) StoreStateBatch(store map[string][]byte, del []string) error {
+	if p.failing {
+		return fmt.Errorf("disk full")
+	}
+	p.batches++
+	for key, value := range store {
+		p.StoreState(key, value)
+	}
+	for _, key := range del {
+		p.DelState(key)
+	}
+	return nil
+}
+
+func TestWALAtomicAppend(t *testing.T) {
+	persist := &batchPersist{}
+	w := newWAL(persist, 3)
+	if err := w.append(walPrepare(1), walPrepare(2), walPrepare(3), walPrepare(4)); err != nil {
+		t.Fatalf("Failed to append records: %s", err)
+	}
+	if persist.batches != 1 {
+		t.Errorf("Expected the records to be stored in a single batch, used %d", persist.batches)
+	}
+	if w.segment != 1 || w.record != 1 {
+		t.Errorf("Expected the records to span two segments, positioned at segment %d record %d", w.segment, w.record)
+	}
+
+	persist.failing = true
+	if err := w.append(walPrepare(5), walPrepare(6)); err == nil {
+		t.Fatalf("Expected the failed batch to be reported")
+	}
+	if w.segment != 1 || w.record != 1 {
+		t.Errorf("Expected the failed batch not to advance the log, positioned at segment %d record %d", w.segment, w.record)
+	}
+	if records, _ := persist.ReadStateSet(walPrefix); len(records) != 4 {
+		t.Errorf("Expected none of the records of the failed batch to be stored, found %d records", len(records))
+	}
+
+	// A failed truncation neither loses the carried records nor the segments
+	persist.failing = true
+	req := &Message{&Message_Request{&Request{Payload: []byte("carried")}}}
+	if err := w.truncate(4, func() []*Message { return []*Message{req} }); err == nil {
+		t.Fatalf("Expected the failed truncation to be reported")
+	}
+	persist.failing = false
+	msgs, err := newWAL(persist, 3).replay()
+	if err != nil {
+		t.Fatalf("Failed to replay WAL: %s", err)
+	}
+	if len(msgs) != 4 {
+		t.Errorf("Expected the failed truncation to leave the log unchanged, got %d records", len(msgs))
+	}
+
+	batches := persist.batches
+	if err := w.truncate(4, func() []*Message { return []*Message{req} }); err != nil {
+		t.Fatalf("Failed to truncate WAL: %s", err)
+	}
+	if persist.batches != batches+1 {
+		t.Errorf("Expected the truncation to be a single batch, used %d", persist.batches-batches)
+	}
+	msgs, _ = newWAL(persist, 3).replay()
+	if len(msgs) != 2 || !proto.Equal(msgs[1], req) {
+		t.Errorf("Expected the record at 4 and the carried record to remain, got %v", msgs)
+	}
+}
+
+func TestWALRestoreCerts(t *testing.T) {
+	persist := &mockPersist{}
+	stack := &omniProto{
+		ReadStateImpl:    persist.ReadState,
+		ReadStateSetImpl: persist.ReadStateSet,
+		StoreStateImpl:   persist.StoreState,
+		DelStateImpl:     persist.DelState,
+	}
+
+	p := newPbftCore(1, loadConfig(), stack)
+	req := &Request{Payload: []byte("foo")}
+	digest := HashReq(p.Digest, req)
+	p.persistPrePrepare(&PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: digest, Request: req, ReplicaId: 0})
+	for _, id := range []uint64{1, 2, 3} {
+		p.persistPrepare(&Prepare{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: id})
+		p.persistCommit(&Commit{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: id})
+	}
+	p.Close()
+
+	p = newPbftCore(1, loadConfig(), stack)
+	defer p.Close()
+	if !p.committed(digest, 0, 1) {
+		t.Fatalf("Expected the commit certificate to be restored")
+	}
+	cert := p.certStore[msgID{0, 1}]
+	if !cert.sentPrepare || !cert.sentCommit {
+		t.Errorf("Expected the restored certificate to record our own prepare and commit")
+	}
+	if p.seqNo != 1 {
+		t.Errorf("Expected seqNo 1 to be restored, got %d", p.seqNo)
+	}
+}
+
+func TestWALRestoreOutstandingRequests(t *testing.T) {
+	persist := &mockPersist{}
+	var prePrepares []*PrePrepare
+	stack := &omniProto{
+		ReadStateImpl:    persist.ReadState,
+		ReadStateSetImpl: persist.ReadStateSet,
+		StoreStateImpl:   persist.StoreState,
+		DelStateImpl:     persist.DelState,
+		validateImpl: func(txRaw []byte) error {
+			return nil
+		},
+		broadcastImpl: func(msgPayload []byte) {
+			msg := &Message{}
+			if err := proto.Unmarshal(msgPayload, msg); err != nil {
+				t.Fatalf("Could not unmarshal broadcast message: %s", err)
+			}
+			if pp := msg.GetPrePrepare(); pp != nil {
+				prePrepares = append(prePrepares, pp)
+			}
+		},
+	}
+
+	p := newPbftCore(1, loadConfig(), stack)
+	pending := &Request{Payload: []byte("pending")}
+	committed := &Request{Payload: []byte("committed")}
+	pendingDigest := HashReq(p.Digest, pending)
+	committedDigest := HashReq(p.Digest, committed)
+	p.reqStore[pendingDigest] = pending
+	p.persistRequest(pendingDigest)
+	p.persistPrePrepare(&PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: committedDigest, Request: committed, ReplicaId: 0})
+	for _, id := range []uint64{1, 2, 3} {
+		p.persistPrepare(&Prepare{View: 0, SequenceNumber: 1, RequestDigest: committedDigest, ReplicaId: id})
+		p.persistCommit(&Commit{View: 0, SequenceNumber: 1, RequestDigest: committedDigest, ReplicaId: id})
+	}
+	p.Close()
+
+	// The restarted primary proposes the pending request again
+	p = newPbftCore(0, loadConfig(), stack)
+	defer p.Close()
+	if len(p.OutstandingReqs) != 1 || p.OutstandingReqs[pendingDigest] == nil {
+		t.Fatalf("Expected only the pending request to be outstanding, got %v", p.OutstandingReqs)
+	}
+	sendEvent(p, RestoredEvent{})
+	if len(prePrepares) != 1 || prePrepares[0].RequestDigest != pendingDigest || prePrepares[0].SequenceNumber != 2 {
+		t.Errorf("Expected the restarted primary to pre-prepare the pending request with seqNo 2, got %v", prePrepares)
+	}
+}
+
+func TestWALDetectsCorruption(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		damage func(p *mockPersist)
+		good   int
+	}{
+		{"checksum", func(p *mockPersist) { p.store[walKey(0, 1)][5] ^= 0xff }, 3},
+		{"missing", func(p *mockPersist) { delete(p.store, walKey(0, 1)) }, 3},
+		{"unlisted", func(p *mockPersist) { p.store[walKey(1, 2)] = p.store[walKey(0, 1)] }, 4},
+	} {
+		name := c.name
+		persist := &mockPersist{}
+		w := newWAL(persist, 3)
+		for n := uint64(1); n <= 4; n++ {
+			w.append(walPrepare(n))
+		}
+		c.damage(persist)
+
+		restored := newWAL(persist, 3)
+		msgs, err := restored.replay()
+		corrupt, ok := err.(walCorruption)
+		if !ok {
+			t.Errorf("%s: expected the corruption to be detected, got %v", name, err)
+			continue
+		}
+		if len(corrupt) != 1 || len(msgs) != c.good {
+			t.Errorf("%s: expected a single bad record, got %v and %d good records", name, corrupt, len(msgs))
+		}
+
+		if err := restored.quarantine(corrupt); err != nil {
+			t.Fatalf("%s: failed to quarantine: %s", name, err)
+		}
+		if records, _ := persist.ReadStateSet(walPrefix); len(records) != 0 {
+			t.Errorf("%s: expected the corrupt log to be discarded, %d records remain", name, len(records))
+		}
+		if name != "missing" {
+			if quarantined, _ := persist.ReadStateSet(walQuarantinePrefix); len(quarantined) != 1 {
+				t.Errorf("%s: expected the bad record to be quarantined, got %d records", name, len(quarantined))
+			}
+		}
+		if _, err := newWAL(persist, 3).replay(); err != nil {
+			t.Errorf("%s: expected the log to be clean after the quarantine, got %s", name, err)
+		}
+	}
+}
+
+func TestWALRebuildCorruptLog(t *testing.T) {
+	persist := &mockPersist{}
+	var broadcasts []*Message
+	stack := &omniProto{
+		ReadStateImpl:    persist.ReadState,
+		ReadStateSetImpl: persist.ReadStateSet,
+		StoreStateImpl:   persist.StoreState,
+		DelStateImpl:     persist.DelState,
+		broadcastImpl: func(msgPayload []byte) {
+			msg := &Message{}
+			proto.Unmarshal(msgPayload, msg)
+			broadcasts = append(broadcasts, msg)
+		},
+		getLastSeqNoImpl: func() (uint64, error) {
+			return 20, nil
+		},
+	}
+
+	p := newPbftCore(1, loadConfig(), stack)
+	p.persistViewChange(&ViewChange{View: 1, Pset: []*ViewChange_PQ{{SequenceNumber: 5, Digest: "foo", View: 0}}})
+	p.Close()
+	for key, value := range persist.store {
+		if len(key) > len(walPrefix) && key[:len(walPrefix)] == walPrefix {
+			value[len(value)-1] ^= 0xff
+		}
+	}
+
+	p = newPbftCore(1, loadConfig(), stack)
+	defer p.Close()
+	if len(p.pset) != 0 || p.View != 0 {
+		t.Fatalf("Expected nothing to be restored from the corrupt log, got view %d and pset %v", p.View, p.pset)
+	}
+	if !p.rebuilding {
+		t.Fatalf("Expected the replica to rebuild its message log")
+	}
+
+	p.noteContact(0)
+	if len(broadcasts) != 1 || broadcasts[0].GetCheckpointRequest() == nil {
+		t.Fatalf("Expected the replica to request checkpoint certificates, sent %v", broadcasts)
+	}
+	for _, id := range []uint64{0, 2} {
+		p.recvCheckpointReply(&CheckpointReply{
+			ReplicaId: id,
+			Stable:    &Checkpoint{SequenceNumber: 10, ReplicaId: id, Id: "state", DigestAlgorithm: p.Digest.name()},
+		})
+	}
+	if p.rebuilding || p.h != 10 {
+		t.Errorf("Expected the message log to be rebuilt from the stable checkpoint, rebuilding %v, h %d", p.rebuilding, p.h)
+	}
+}
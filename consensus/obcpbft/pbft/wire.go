@@ -0,0 +1,273 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+const (
+	wireVersion    uint32 = 7 // wire format of the messages this replica creates
+	minWireVersion uint32 = 0 // oldest wire format this replica still decodes and sends
+)
+
+// wireUpgrades translate a payload of a version into the next version, and
+// wireDowngrades into the previous version. A change of the wire format adds
+// an entry to each, so that replicas keep talking to replicas which were not
+// upgraded yet, until minWireVersion is raised
+var wireUpgrades = map[uint32]func(payload []byte) ([]byte, error){
+	// version 1 added the version of the envelope and wire hellos, it did
+	// not change the encoding of the payloads
+	0: func(payload []byte) ([]byte, error) { return payload, nil },
+	// version 2 added acks, which are only sent to replicas speaking it
+	1: func(payload []byte) ([]byte, error) { return payload, nil },
+	// version 3 added heartbeats, which are only sent to replicas speaking it
+	2: func(payload []byte) ([]byte, error) { return payload, nil },
+	// version 4 added Ed25519 signatures, which are only made once all
+	// replicas announced an Ed25519 key in their hellos
+	3: func(payload []byte) ([]byte, error) { return payload, nil },
+	// version 5 added checkpoint shares and threshold certificates in
+	// checkpoint replies, which are only sent to replicas speaking it
+	4: func(payload []byte) ([]byte, error) { return payload, nil },
+	// version 6 added threshold signature shares to commits, which are
+	// only attached once all replicas speak it, as replicas speaking an
+	// older version would drop the share and fail to verify the MAC
+	5: func(payload []byte) ([]byte, error) { return payload, nil },
+	// version 7 added relay bundles, prepares and commits are only relayed
+	// through aggregators once all replicas speak it
+	6: func(payload []byte) ([]byte, error) { return payload, nil },
+}
+
+var wireDowngrades = map[uint32]func(payload []byte) ([]byte, error){
+	1: func(payload []byte) ([]byte, error) { return payload, nil },
+	2: func(payload []byte) ([]byte, error) { return payload, nil },
+	3: func(payload []byte) ([]byte, error) { return payload, nil },
+	4: func(payload []byte) ([]byte, error) { return payload, nil },
+	5: func(payload []byte) ([]byte, error) { return payload, nil },
+	6: func(payload []byte) ([]byte, error) { return payload, nil },
+	7: func(payload []byte) ([]byte, error) { return payload, nil },
+}
+
+// OpenWireMessage returns the payload of a consensus message translated
+// from the version it was sent in to wireVersion, and decompressed. It holds
+// no state, and may be called concurrently
+func OpenWireMessage(ocMsg *pb.Message) ([]byte, error) {
+	if ocMsg.Version < minWireVersion || ocMsg.Version > wireVersion {
+		return nil, fmt.Errorf("message of wire version %d, only versions %d to %d are supported", ocMsg.Version, minWireVersion, wireVersion)
+	}
+	payload := ocMsg.Payload
+	for v := ocMsg.Version; v < wireVersion; v++ {
+		var err error
+		if payload, err = wireUpgrades[v](payload); err != nil {
+			return nil, fmt.Errorf("cannot upgrade message from wire version %d: %s", v, err)
+		}
+	}
+	return decompressWirePayload(payload)
+}
+
+// wireNegotiator keeps the wire version agreed with, and the compression
+// algorithms decoded by, every other replica, through the wire hellos replicas
+// exchange when they connect
+type wireNegotiator struct {
+	sync.Mutex
+	id       uint64
+	N        int
+	min, max uint32            // range of versions this replica speaks
+	versions map[uint64]uint32 // version agreed with each replica, absent until it sent a hello
+
+	compression []string                   // algorithms we decode, in the order we prefer to compress with them
+	threshold   int                        // payloads of at least this many bytes are compressed, 0 if disabled
+	decoders    map[uint64]map[string]bool // algorithms each replica decodes
+}
+
+func newWireNegotiator(id uint64, N int) *wireNegotiator {
+	return &wireNegotiator{
+		id:       id,
+		N:        N,
+		min:      minWireVersion,
+		max:      wireVersion,
+		versions: make(map[uint64]uint32),
+		decoders: make(map[uint64]map[string]bool),
+	}
+}
+
+// compress enables the compression of payloads of at least threshold bytes
+// with the first of the algorithms all receivers decode. It panics on
+// algorithms this replica was built without
+func (wn *wireNegotiator) compress(algorithms []string, threshold int) {
+	for _, name := range algorithms {
+		if _, ok := compressionCodecs[name]; !ok {
+			panic(fmt.Errorf("Unknown compression algorithm %s", name))
+		}
+	}
+	wn.compression = algorithms
+	wn.threshold = threshold
+}
+
+// hello announces the versions and compression algorithms we speak
+func (wn *wireNegotiator) hello(reply bool) *WireHello {
+	return &WireHello{
+		ReplicaId:   wn.id,
+		MinVersion:  wn.min,
+		MaxVersion:  wn.max,
+		Reply:       reply,
+		Compression: wn.compression,
+	}
+}
+
+// negotiate records the hello of another replica, and agrees on the
+// highest version both replicas speak
+func (wn *wireNegotiator) negotiate(hello *WireHello) (uint32, error) {
+	wn.Lock()
+	defer wn.Unlock()
+
+	agreed := wn.max
+	if hello.MaxVersion < agreed {
+		agreed = hello.MaxVersion
+	}
+	if agreed < wn.min || agreed < hello.MinVersion {
+		delete(wn.versions, hello.ReplicaId)
+		return 0, fmt.Errorf("replica %d speaks wire versions %d to %d, we speak %d to %d", hello.ReplicaId, hello.MinVersion, hello.MaxVersion, wn.min, wn.max)
+	}
+	wn.versions[hello.ReplicaId] = agreed
+	decoders := make(map[string]bool)
+	for _, name := range hello.Compression {
+		decoders[name] = true
+	}
+	wn.decoders[hello.ReplicaId] = decoders
+	return agreed, nil
+}
+
+// receivers returns the replicas a message is sent to, all other replicas
+// if none are given
+func (wn *wireNegotiator) receivers(ids []uint64) []uint64 {
+	if len(ids) > 0 {
+		return ids
+	}
+	for i := 0; i < wn.N; i++ {
+		if uint64(i) != wn.id {
+			ids = append(ids, uint64(i))
+		}
+	}
+	return ids
+}
+
+// version returns the version to send a message in to the receivers, all
+// other replicas if there are none. A message must be decodable by all its
+// receivers, so this is the lowest version agreed with any of them. Replicas
+// which did not send a hello yet are assumed to speak only our oldest version
+func (wn *wireNegotiator) version(receivers ...uint64) uint32 {
+	wn.Lock()
+	defer wn.Unlock()
+
+	version := wn.max
+	for _, id := range wn.receivers(receivers) {
+		agreed, ok := wn.versions[id]
+		if !ok {
+			agreed = wn.min
+		}
+		if agreed < version {
+			version = agreed
+		}
+	}
+	return version
+}
+
+// algorithm returns the algorithm to compress a payload of size bytes with
+// for the receivers, the first of ours they all decode, "" if it is not to be
+// compressed
+func (wn *wireNegotiator) algorithm(size int, receivers ...uint64) string {
+	if wn.threshold == 0 || size < wn.threshold {
+		return ""
+	}
+	wn.Lock()
+	defer wn.Unlock()
+
+	receivers = wn.receivers(receivers)
+outer:
+	for _, name := range wn.compression {
+		for _, id := range receivers {
+			if !wn.decoders[id][name] {
+				continue outer
+			}
+		}
+		return name
+	}
+	return ""
+}
+
+// Envelope wraps payload into a consensus message the receivers, all other
+// replicas if there are none, can decode, compressing it if they all agreed
+// on an algorithm
+func (wn *wireNegotiator) Envelope(payload []byte, receivers ...uint64) *pb.Message {
+	if algorithm := wn.algorithm(len(payload), receivers...); algorithm != "" {
+		if compressed, err := compressWirePayload(payload, algorithm); err != nil {
+			logger.Error("Replica %d cannot compress message with %s, sending it uncompressed: %s", wn.id, algorithm, err)
+		} else {
+			payload = compressed
+		}
+	}
+
+	version := wn.version(receivers...)
+	for v := wireVersion; v > version; v-- {
+		downgraded, err := wireDowngrades[v](payload)
+		if err != nil {
+			logger.Error("Replica %d cannot downgrade message to wire version %d, sending version %d: %s", wn.id, v-1, v, err)
+			version = v
+			break
+		}
+		payload = downgraded
+	}
+	return &pb.Message{
+		Type:    pb.Message_CONSENSUS,
+		Payload: payload,
+		Version: version,
+	}
+}
+
+// sendWireHello announces the wire versions we speak to all replicas, once
+// we start and whenever we reconnect after an outage
+func (instance *PbftCore) sendWireHello() {
+	instance.innerBroadcast(&Message{&Message_WireHello{instance.wireHello(false)}})
+}
+
+// recvWireHello agrees on a wire version with the replica which sent the
+// hello, and answers it with our own hello, unless it was an answer already
+func (instance *PbftCore) recvWireHello(hello *WireHello) error {
+	version, err := instance.Wire.negotiate(hello)
+	if err != nil {
+		return fmt.Errorf("Replica %d cannot talk to replica %d: %s", instance.ID, hello.ReplicaId, err)
+	}
+	instance.log.Debug("Talking to replica %d in wire version %d", hello.ReplicaId, version)
+	if err := instance.recvEd25519Key(hello); err != nil {
+		instance.log.Warning("Ignoring Ed25519 key: %s", err)
+	}
+	if hello.Reply {
+		return nil
+	}
+
+	msgRaw, err := proto.Marshal(&Message{&Message_WireHello{instance.wireHello(true)}})
+	if err != nil {
+		return fmt.Errorf("Cannot marshal wire hello for replica %d: %s", hello.ReplicaId, err)
+	}
+	return instance.consumer.Unicast(msgRaw, hello.ReplicaId)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pbft
+
+import (
+	"bytes"
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestOpenWireMessage(t *testing.T) {
+	payload := []byte("payload")
+	for _, version := range []uint32{0, wireVersion} {
+		opened, err := OpenWireMessage(&pb.Message{Type: pb.Message_CONSENSUS, Payload: payload, Version: version})
+		if err != nil {
+			t.Errorf("Expected a message of wire version %d to decode: %s", version, err)
+		} else if !bytes.Equal(opened, payload) {
+			t.Errorf("Expected the payload of wire version %d to be preserved, got %q", version, opened)
+		}
+	}
+
+	if _, err := OpenWireMessage(&pb.Message{Type: pb.Message_CONSENSUS, Payload: payload, Version: wireVersion + 1}); err == nil {
+		t.Errorf("Expected a message of a future wire version to be rejected")
+	}
+}
+
+func TestWireNegotiation(t *testing.T) {
+	wn := newWireNegotiator(0, 4)
+	if v := wn.version(); v != minWireVersion {
+		t.Errorf("Expected to send the oldest version before any hello, got %d", v)
+	}
+
+	old := newWireNegotiator(3, 4)
+	old.max = 0
+	for _, id := range []uint64{1, 2} {
+		hello := newWireNegotiator(id, 4).hello(false)
+		if v, err := wn.negotiate(hello); err != nil || v != wireVersion {
+			t.Errorf("Expected to agree on version %d with replica %d, got %d, %v", wireVersion, id, v, err)
+		}
+	}
+	if v, err := wn.negotiate(old.hello(false)); err != nil || v != 0 {
+		t.Errorf("Expected to agree on version 0 with the old replica, got %d, %v", v, err)
+	}
+
+	if v := wn.version(1); v != wireVersion {
+		t.Errorf("Expected to unicast to an upgraded replica in version %d, got %d", wireVersion, v)
+	}
+	if msg := wn.Envelope([]byte("payload")); msg.Version != 0 {
+		t.Errorf("Expected broadcasts to be decodable by the old replica, got version %d", msg.Version)
+	}
+
+	future := &WireHello{ReplicaId: 2, MinVersion: wireVersion + 1, MaxVersion: wireVersion + 2}
+	if _, err := wn.negotiate(future); err == nil {
+		t.Errorf("Expected no agreement with a replica which dropped all our versions")
+	}
+}
+
+func TestWireHelloExchange(t *testing.T) {
+	var sent []*WireHello
+	stack := &omniProto{
+		unicastImpl: func(msgPayload []byte, receiverID uint64) error {
+			msg := &Message{}
+			proto.Unmarshal(msgPayload, msg)
+			if wh := msg.GetWireHello(); wh != nil && receiverID == 2 {
+				sent = append(sent, wh)
+			}
+			return nil
+		},
+	}
+	p := newPbftCore(0, loadConfig(), stack)
+	defer p.Close()
+
+	hello := newWireNegotiator(2, 4).hello(false)
+	sendEvent(p, pbftMessageEvent{msg: &Message{&Message_WireHello{hello}}, sender: 2})
+	if len(sent) != 1 || !sent[0].Reply || sent[0].MaxVersion != wireVersion {
+		t.Fatalf("Expected a single hello in reply, sent %v", sent)
+	}
+	if v := p.Wire.version(2); v != wireVersion {
+		t.Errorf("Expected to talk to replica 2 in version %d, got %d", wireVersion, v)
+	}
+
+	hello.Reply = true
+	sendEvent(p, pbftMessageEvent{msg: &Message{&Message_WireHello{hello}}, sender: 2})
+	if len(sent) != 1 {
+		t.Errorf("Expected a reply not to be answered, sent %d hellos", len(sent))
+	}
+}
+
+func TestWireCompression(t *testing.T) {
+	payload, _ := proto.Marshal(&Message{&Message_Request{&Request{Payload: bytes.Repeat([]byte("payload"), 100)}}})
+	wn := newWireNegotiator(0, 4)
+	wn.compress([]string{"gzip"}, 64)
+	for _, id := range []uint64{1, 2} {
+		peer := newWireNegotiator(id, 4)
+		peer.compress([]string{"gzip"}, 0)
+		wn.negotiate(peer.hello(false))
+	}
+	wn.negotiate(newWireNegotiator(3, 4).hello(false))
+
+	msg := wn.Envelope(payload, 1, 2)
+	if len(msg.Payload) >= len(payload) {
+		t.Fatalf("Expected the payload to be compressed, got %d bytes for %d", len(msg.Payload), len(payload))
+	}
+	if opened, err := OpenWireMessage(msg); err != nil || !bytes.Equal(opened, payload) {
+		t.Errorf("Expected the compressed payload to decode, got %v", err)
+	}
+
+	if msg := wn.Envelope(payload); !bytes.Equal(msg.Payload, payload) {
+		t.Errorf("Expected no compression for a replica which does not decode gzip")
+	}
+	hello, _ := proto.Marshal(&Message{&Message_WireHello{wn.hello(false)}})
+	if msg := wn.Envelope(hello, 1); !bytes.Equal(msg.Payload, hello) {
+		t.Errorf("Expected no compression below the threshold")
+	}
+
+	unknown, _ := proto.Marshal(&Message{&Message_Compressed{&Compressed{Algorithm: "lzw", Payload: payload}}})
+	if _, err := OpenWireMessage(&pb.Message{Payload: unknown}); err == nil {
+		t.Errorf("Expected a payload of an unknown algorithm to be rejected")
+	}
+	bomb, _ := compressWirePayload(make([]byte, maxDecompressedSize+1), "gzip")
+	if _, err := OpenWireMessage(&pb.Message{Payload: bomb}); err == nil {
+		t.Errorf("Expected a payload decompressing beyond %d bytes to be rejected", maxDecompressedSize)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected an unknown configured algorithm to panic")
+		}
+	}()
+	wn.compress([]string{"lzw"}, 64)
+}
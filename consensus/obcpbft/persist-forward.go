@@ -18,6 +18,7 @@ package obcpbft
 
 import (
 	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
 )
 
 type persistForward struct {
@@ -39,3 +40,7 @@ func (p persistForward) StoreState(key string, val []byte) error {
 func (p persistForward) DelState(key string) {
 	p.persistor.DelState(key)
 }
+
+func (p persistForward) StoreStateBatch(store map[string][]byte, del []string) error {
+	return pbft.StoreStateBatch(p.persistor, store, del)
+}
@@ -0,0 +1,55 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// openSealedTx returns the transaction sealed in tx, opened through the
+// stack, or tx itself if it is not sealed. Sealed transactions are relayed
+// and ordered as ciphertext, and only opened here, when they execute
+func openSealedTx(stack interface{}, tx *pb.Transaction) (*pb.Transaction, error) {
+	if tx.Type != pb.Transaction_CHAIN_SEALED {
+		return tx, nil
+	}
+	opener, ok := stack.(consensus.PayloadOpener)
+	if !ok {
+		return nil, fmt.Errorf("stack cannot open sealed transaction %s", tx.Uuid)
+	}
+	raw, err := opener.OpenPayload(tx.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open sealed transaction %s: %s", tx.Uuid, err)
+	}
+	sealed := &pb.Transaction{}
+	if err := proto.Unmarshal(raw, sealed); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal transaction sealed in %s: %s", tx.Uuid, err)
+	}
+	if sealed.Type == pb.Transaction_CHAIN_SEALED {
+		return nil, fmt.Errorf("transaction %s is sealed twice", tx.Uuid)
+	}
+	if sealed.CorrelationID == "" {
+		// the correlation ID of the envelope is visible before it is opened
+		sealed.CorrelationID = tx.CorrelationID
+	}
+	return sealed, nil
+}
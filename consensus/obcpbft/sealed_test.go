@@ -0,0 +1,89 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// xorOpener seals by flipping every bit of the payload
+type xorOpener struct{}
+
+func (xorOpener) OpenPayload(sealed []byte) ([]byte, error) {
+	if len(sealed) == 0 {
+		return nil, fmt.Errorf("empty sealed payload")
+	}
+	opened := make([]byte, len(sealed))
+	for i := range sealed {
+		opened[i] = ^sealed[i]
+	}
+	return opened, nil
+}
+
+func TestOpenSealedTx(t *testing.T) {
+	inner := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, Uuid: "inner", Payload: []byte("secret")}
+	raw, _ := proto.Marshal(inner)
+	sealedPayload, _ := xorOpener{}.OpenPayload(raw)
+	sealed := &pb.Transaction{Type: pb.Transaction_CHAIN_SEALED, Uuid: "outer", Payload: sealedPayload}
+
+	plain := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, Uuid: "plain"}
+	if tx, err := openSealedTx(nil, plain); tx != plain || err != nil {
+		t.Errorf("Expected a transaction which is not sealed to be returned as is")
+	}
+
+	tx, err := openSealedTx(xorOpener{}, sealed)
+	if err != nil {
+		t.Fatalf("Expected the sealed transaction to open: %s", err)
+	}
+	if tx.Uuid != "inner" || !bytes.Equal(tx.Payload, []byte("secret")) {
+		t.Errorf("Expected the sealed transaction, got %v", tx)
+	}
+
+	if _, err := openSealedTx(struct{}{}, sealed); err == nil {
+		t.Errorf("Expected a stack which cannot open sealed transactions to reject them")
+	}
+	if _, err := openSealedTx(xorOpener{}, &pb.Transaction{Type: pb.Transaction_CHAIN_SEALED}); err == nil {
+		t.Errorf("Expected a sealed transaction which does not open to be rejected")
+	}
+
+	raw, _ = proto.Marshal(sealed)
+	twice, _ := xorOpener{}.OpenPayload(raw)
+	if _, err := openSealedTx(xorOpener{}, &pb.Transaction{Type: pb.Transaction_CHAIN_SEALED, Payload: twice}); err == nil {
+		t.Errorf("Expected a transaction sealed twice to be rejected")
+	}
+}
+
+func TestOpenSealedTxCorrelationID(t *testing.T) {
+	inner := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, Uuid: "inner"}
+	raw, _ := proto.Marshal(inner)
+	sealedPayload, _ := xorOpener{}.OpenPayload(raw)
+	sealed := &pb.Transaction{Type: pb.Transaction_CHAIN_SEALED, Uuid: "outer", Payload: sealedPayload, CorrelationID: "order-42"}
+
+	tx, err := openSealedTx(xorOpener{}, sealed)
+	if err != nil {
+		t.Fatalf("Expected the sealed transaction to open: %s", err)
+	}
+	if tx.CorrelationID != "order-42" {
+		t.Errorf("Expected the correlation ID of the envelope to be carried into the opened transaction, got %q", tx.CorrelationID)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"sync/atomic"
+
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
+)
+
+// validationJob is a message waiting to be validated, result receives the
+// event to queue for the message, or nil if the message is to be dropped
+type validationJob struct {
+	msg    pbft.BatchMessageEvent
+	result chan interface{}
+}
+
+// validationPool unmarshals and verifies incoming messages on a number of
+// worker goroutines, so that only the ordered state machine mutations are
+// left to the event thread. The resulting events are queued in the order
+// the messages were submitted, regardless of which worker validated them
+type validationPool struct {
+	exit     chan struct{} // closed once the pool is halted
+	validate func(pbft.BatchMessageEvent) interface{}
+	manager  pbft.EventManager
+
+	jobs    chan *validationJob // jobs waiting for a worker
+	ordered chan *validationJob // jobs in submission order, waiting to be queued
+	pending int64               // jobs submitted but not yet queued
+}
+
+func newValidationPool(workers int, validate func(pbft.BatchMessageEvent) interface{}, manager pbft.EventManager) *validationPool {
+	vp := &validationPool{
+		exit:     make(chan struct{}),
+		validate: validate,
+		manager:  manager,
+		jobs:     make(chan *validationJob, workers),
+		ordered:  make(chan *validationJob, 2*workers),
+	}
+	for i := 0; i < workers; i++ {
+		go vp.work()
+	}
+	go vp.sequence()
+	return vp
+}
+
+// submit hands a message to the pool, it blocks while the pool is saturated
+func (vp *validationPool) submit(msg pbft.BatchMessageEvent) {
+	job := &validationJob{
+		msg:    msg,
+		result: make(chan interface{}, 1),
+	}
+	atomic.AddInt64(&vp.pending, 1)
+	select {
+	case vp.ordered <- job:
+	case <-vp.exit:
+		return
+	}
+	select {
+	case vp.jobs <- job:
+	case <-vp.exit:
+	}
+}
+
+// busy returns whether submitted messages have not been queued yet
+func (vp *validationPool) busy() bool {
+	return atomic.LoadInt64(&vp.pending) > 0
+}
+
+func (vp *validationPool) work() {
+	for {
+		select {
+		case job := <-vp.jobs:
+			job.result <- vp.validate(job.msg)
+		case <-vp.exit:
+			return
+		}
+	}
+}
+
+// sequence queues the validated events in submission order
+func (vp *validationPool) sequence() {
+	for {
+		var job *validationJob
+		select {
+		case job = <-vp.ordered:
+		case <-vp.exit:
+			return
+		}
+
+		var event interface{}
+		select {
+		case event = <-job.result:
+		case <-vp.exit:
+			return
+		}
+
+		if event != nil {
+			select {
+			case vp.manager.Queue() <- event:
+			case <-vp.exit:
+				return
+			}
+		}
+		atomic.AddInt64(&vp.pending, -1)
+	}
+}
+
+// halt stops the workers of the pool, messages not queued yet are dropped
+func (vp *validationPool) halt() {
+	close(vp.exit)
+}
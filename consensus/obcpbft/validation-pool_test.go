@@ -0,0 +1,111 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/consensus/obcpbft/pbft"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type queueManager struct {
+	pbft.EventManager // only Queue is used by the pool
+	events            chan interface{}
+}
+
+func (qm *queueManager) Inject(event interface{}) {}
+func (qm *queueManager) Queue() chan<- interface{} {
+	return qm.events
+}
+func (qm *queueManager) Start() {}
+
+func TestValidationPoolOrder(t *testing.T) {
+	qm := &queueManager{events: make(chan interface{})}
+	validate := func(msg pbft.BatchMessageEvent) interface{} {
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		if string(msg.Msg.Payload) == "drop" {
+			return nil
+		}
+		return msg
+	}
+	vp := newValidationPool(4, validate, qm)
+	defer vp.halt()
+
+	count := 50
+	go func() {
+		for i := 0; i < count; i++ {
+			payload := []byte{byte(i)}
+			if i%10 == 0 {
+				payload = []byte("drop")
+			}
+			vp.submit(pbft.BatchMessageEvent{Msg: &pb.Message{Payload: payload}})
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		if i%10 == 0 {
+			continue
+		}
+		select {
+		case event := <-qm.events:
+			if payload := event.(pbft.BatchMessageEvent).Msg.Payload; payload[0] != byte(i) {
+				t.Fatalf("Expected message %d to be queued, got %d", i, payload[0])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for message %d", i)
+		}
+	}
+
+	for vp.busy() {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNetworkBatchValidationPool(t *testing.T) {
+	envName := "CORE_PBFT_GENERAL_VALIDATIONWORKERS"
+	os.Setenv(envName, "4")
+	defer os.Unsetenv(envName)
+
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchHelper, func(ce *consumerEndpoint) {
+		ce.consumer.(*obcBatch).batchSize = 1
+	})
+	defer net.Stop()
+
+	for _, ep := range net.Endpoints {
+		if ep.(*consumerEndpoint).consumer.(*obcBatch).validationPool == nil {
+			t.Fatalf("Expected replica %d to validate messages with a pool", ep.(*consumerEndpoint).ID)
+		}
+	}
+
+	broadcaster := net.Endpoints[generateBroadcaster(validatorCount)].GetHandle()
+	for i := int64(1); i <= 3; i++ {
+		net.Endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(i), broadcaster)
+		net.Process()
+	}
+
+	for _, ep := range net.Endpoints {
+		ce := ep.(*consumerEndpoint)
+		if seqNo := ce.consumer.getPBFTCore().LastExec; seqNo != 3 {
+			t.Errorf("Replica %d executed up to %d, expected 3", ce.ID, seqNo)
+		}
+	}
+}
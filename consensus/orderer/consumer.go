@@ -0,0 +1,359 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orderer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/core/comm"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func init() {
+	consensus.RegisterPlugin(&consensus.Plugin{Name: "external", Shared: NewConsumer, New: NewConsumer})
+}
+
+// maxAhead bounds how many batches beyond the next one to execute are
+// buffered, so that an orderer racing ahead of the others cannot exhaust
+// our memory
+const maxAhead = 64
+
+// Consumer is the consenter of a peer which executes the transactions an
+// external ordering service orders, rather than ordering them itself. It
+// submits the transactions of its clients to the ordering service, and
+// executes the ordered batches it streams from it, one block per batch.
+// The ordering service runs on several orderers, up to f of which may be
+// faulty, so a batch is only executed once f+1 orderers delivered it
+type Consumer struct {
+	stack    consensus.Stack
+	orderers []*ordererConn
+	f        int
+	retry    time.Duration // between attempts to resume a stream
+	timeout  time.Duration // of a transaction submission
+	ctx      context.Context
+	cancel   context.CancelFunc
+	batches  chan deliveredBatch
+	streams  sync.WaitGroup
+	done     chan struct{} // closed once the consumer stopped executing
+
+	lock     sync.Mutex
+	next     uint64        // sequence number of the next batch to execute
+	advanced chan struct{} // closed once next advances
+}
+
+type ordererConn struct {
+	address string
+	conn    *grpc.ClientConn
+	client  AtomicBroadcastClient
+}
+
+// deliveredBatch is a batch streamed from the orderer of the given index
+type deliveredBatch struct {
+	orderer int
+	batch   *OrderedBatch
+}
+
+// batchVotes collects the batches the orderers delivered for a sequence number
+type batchVotes struct {
+	voted   map[int]bool             // the orderers which delivered a batch
+	batches map[string]*OrderedBatch // by digest
+	count   map[string]int           // orderers which delivered the batch, by digest
+}
+
+// NewConsumer connects stack to the orderers at the configured addresses
+func NewConsumer(stack consensus.Stack) consensus.Consenter {
+	addresses := viper.GetStringSlice("peer.validator.consensus.orderer.addresses")
+	f := viper.GetInt("peer.validator.consensus.orderer.f")
+	if f < 0 || len(addresses) < 2*f+1 {
+		panic(fmt.Errorf("Need at least 2f+1 = %d orderer addresses to tolerate f = %d faulty orderers, got %d", 2*f+1, f, len(addresses)))
+	}
+	conns := make([]*grpc.ClientConn, len(addresses))
+	for i, address := range addresses {
+		conn, err := comm.NewClientConnectionWithAddress(address, false, comm.TLSEnabled(), comm.InitTLSForPeer())
+		if err != nil {
+			panic(fmt.Errorf("Cannot connect to the orderer at %s: %s", address, err))
+		}
+		conns[i] = conn
+	}
+	retry, err := time.ParseDuration(viper.GetString("peer.validator.consensus.orderer.retry"))
+	if err != nil || retry <= 0 {
+		panic(fmt.Errorf("Cannot parse ordering service retry interval: %v", err))
+	}
+	logger.Info("Executing the batches at least %d of the orderers at %v deliver", f+1, addresses)
+	return newConsumer(stack, addresses, conns, f, retry)
+}
+
+func newConsumer(stack consensus.Stack, addresses []string, conns []*grpc.ClientConn, f int, retry time.Duration) *Consumer {
+	c := &Consumer{
+		stack:    stack,
+		f:        f,
+		retry:    retry,
+		timeout:  3 * time.Second,
+		batches:  make(chan deliveredBatch),
+		done:     make(chan struct{}),
+		advanced: make(chan struct{}),
+	}
+	for i, conn := range conns {
+		c.orderers = append(c.orderers, &ordererConn{address: addresses[i], conn: conn, client: NewAtomicBroadcastClient(conn)})
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.next = c.lastSeqNo() + 1
+	for i := range c.orderers {
+		c.streams.Add(1)
+		go c.stream(i)
+	}
+	go c.consume()
+	return c
+}
+
+// RecvMsg is called for Message_CHAIN_TRANSACTION messages, which are
+// submitted to the first orderer accepting them
+func (c *Consumer) RecvMsg(msg *pb.Message, senderHandle *pb.PeerID) error {
+	if msg.Type != pb.Message_CHAIN_TRANSACTION {
+		return fmt.Errorf("Unexpected message type %s, transactions are ordered by the ordering service", msg.Type)
+	}
+	var err error
+	for _, o := range c.orderers {
+		ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+		_, err = o.client.Broadcast(ctx, &BroadcastMessage{Transaction: msg.Payload})
+		cancel()
+		if err == nil {
+			return nil
+		}
+		logger.Warning("Failed to submit transaction to the orderer at %s: %v", o.address, err)
+	}
+	return fmt.Errorf("Failed to submit transaction to the ordering service: %v", err)
+}
+
+// StateUpdating is called once state transfer is initiated, currently unused
+func (c *Consumer) StateUpdating(tag uint64, id []byte) {
+	// ignored as it is never initiated
+}
+
+// StateUpdated is called once state transfer finishes, currently unused
+func (c *Consumer) StateUpdated(tag uint64, id []byte) {
+	// ignored as it is never initiated
+}
+
+// Close disconnects from the orderers, once the batch being executed is
+// committed
+func (c *Consumer) Close() {
+	c.cancel()
+	<-c.done
+	c.streams.Wait()
+	for _, o := range c.orderers {
+		o.conn.Close()
+	}
+}
+
+// nextSeqNo returns the sequence number of the next batch to execute, and a
+// channel which is closed once it advances
+func (c *Consumer) nextSeqNo() (uint64, <-chan struct{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.next, c.advanced
+}
+
+func (c *Consumer) advance() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.next++
+	close(c.advanced)
+	c.advanced = make(chan struct{})
+}
+
+// stream hands the batches the orderer of index i delivers to consume,
+// resuming the stream at the next batch to execute whenever it breaks. An
+// orderer skipping a sequence number is faulty or no longer retains the
+// batch, its stream is dropped and resumed
+func (c *Consumer) stream(i int) {
+	defer c.streams.Done()
+	o := c.orderers[i]
+	for {
+		expected, _ := c.nextSeqNo()
+		stream, err := o.client.Deliver(c.ctx, &DeliverRequest{Start: expected})
+		for err == nil {
+			var batch *OrderedBatch
+			if batch, err = stream.Recv(); err != nil {
+				break
+			}
+			if batch.SeqNo < expected {
+				continue
+			}
+			if batch.SeqNo > expected {
+				err = fmt.Errorf("batch %d does not follow batch %d", batch.SeqNo, expected-1)
+				break
+			}
+			for {
+				next, advanced := c.nextSeqNo()
+				if batch.SeqNo < next+maxAhead {
+					break
+				}
+				select {
+				case <-advanced:
+				case <-c.ctx.Done():
+					return
+				}
+			}
+			select {
+			case c.batches <- deliveredBatch{orderer: i, batch: batch}:
+			case <-c.ctx.Done():
+				return
+			}
+			expected++
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
+		logger.Warning("Ordered batch stream of %s from %d interrupted, resuming in %v: %s", o.address, expected, c.retry, err)
+		select {
+		case <-time.After(c.retry):
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// consume executes the batches in sequence number order, each once f+1
+// orderers delivered it. As at most f orderers are faulty, at least one
+// correct orderer ordered the batch
+func (c *Consumer) consume() {
+	defer close(c.done)
+	votes := make(map[uint64]*batchVotes)
+	for {
+		var d deliveredBatch
+		select {
+		case d = <-c.batches:
+		case <-c.ctx.Done():
+			return
+		}
+		next, _ := c.nextSeqNo()
+		if d.batch.SeqNo < next {
+			continue
+		}
+		v, ok := votes[d.batch.SeqNo]
+		if !ok {
+			v = &batchVotes{voted: make(map[int]bool), batches: make(map[string]*OrderedBatch), count: make(map[string]int)}
+			votes[d.batch.SeqNo] = v
+		}
+		if !v.add(d) {
+			continue
+		}
+
+		for v := votes[next]; v != nil; v = votes[next] {
+			batch := v.agreed(c.f + 1)
+			if batch == nil {
+				break
+			}
+			if err := c.execute(batch); err != nil {
+				logger.Warning("Retrying ordered batch %d in %v: %s", next, c.retry, err)
+				select {
+				case <-time.After(c.retry):
+					continue
+				case <-c.ctx.Done():
+					return
+				}
+			}
+			delete(votes, next)
+			c.advance()
+			next++
+		}
+	}
+}
+
+// add records the batch an orderer delivered, it returns false if the
+// orderer already delivered a batch for the sequence number
+func (v *batchVotes) add(d deliveredBatch) bool {
+	if v.voted[d.orderer] {
+		return false
+	}
+	raw, err := proto.Marshal(d.batch)
+	if err != nil {
+		logger.Warning("Cannot marshal ordered batch %d: %s", d.batch.SeqNo, err)
+		return false
+	}
+	digest := string(util.ComputeCryptoHash(raw))
+	v.voted[d.orderer] = true
+	v.batches[digest] = d.batch
+	v.count[digest]++
+	return true
+}
+
+// agreed returns the batch quorum orderers delivered, if any
+func (v *batchVotes) agreed(quorum int) *OrderedBatch {
+	for digest, count := range v.count {
+		if count >= quorum {
+			return v.batches[digest]
+		}
+	}
+	return nil
+}
+
+// execute executes and commits batch as the next block
+func (c *Consumer) execute(batch *OrderedBatch) error {
+	txs := make([]*pb.Transaction, 0, len(batch.Transactions))
+	for _, raw := range batch.Transactions {
+		tx := &pb.Transaction{}
+		if err := proto.Unmarshal(raw, tx); err != nil {
+			logger.Warning("Cannot unmarshal transaction of ordered batch %d: %s", batch.SeqNo, err)
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	meta, err := proto.Marshal(&Metadata{SeqNo: batch.SeqNo})
+	if err != nil {
+		return err
+	}
+
+	id := batch
+	if err := c.stack.BeginTxBatch(id); err != nil {
+		return fmt.Errorf("Cannot begin ordered batch %d: %s", batch.SeqNo, err)
+	}
+	if _, err := c.stack.ExecTxs(id, txs); err != nil {
+		c.stack.RollbackTxBatch(id)
+		return fmt.Errorf("Cannot execute ordered batch %d: %s", batch.SeqNo, err)
+	}
+	if _, err := c.stack.CommitTxBatch(id, meta); err != nil {
+		c.stack.RollbackTxBatch(id)
+		return fmt.Errorf("Cannot commit ordered batch %d: %s", batch.SeqNo, err)
+	}
+	logger.Debug("Committed ordered batch %d with %d transactions", batch.SeqNo, len(txs))
+	return nil
+}
+
+// lastSeqNo returns the sequence number of the last batch committed to the ledger
+func (c *Consumer) lastSeqNo() uint64 {
+	raw, err := c.stack.GetBlockHeadMetadata()
+	if err != nil {
+		return 0
+	}
+	meta := &Metadata{}
+	if err := proto.Unmarshal(raw, meta); err != nil {
+		logger.Warning("Cannot unmarshal the metadata of the last block, consuming all retained batches: %s", err)
+		return 0
+	}
+	return meta.SeqNo
+}
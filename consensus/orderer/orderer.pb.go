@@ -0,0 +1,199 @@
+// Code generated by protoc-gen-go.
+// source: orderer/orderer.proto
+// DO NOT EDIT!
+
+/*
+Package orderer is a generated protocol buffer package.
+
+It is generated from these files:
+
+	orderer/orderer.proto
+
+It has these top-level messages:
+
+	BroadcastMessage
+	BroadcastResponse
+	DeliverRequest
+	OrderedBatch
+	Metadata
+*/
+package orderer
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type BroadcastMessage struct {
+	Transaction []byte `protobuf:"bytes,1,opt,name=transaction,proto3" json:"transaction,omitempty"`
+}
+
+func (m *BroadcastMessage) Reset()         { *m = BroadcastMessage{} }
+func (m *BroadcastMessage) String() string { return proto.CompactTextString(m) }
+func (*BroadcastMessage) ProtoMessage()    {}
+
+type BroadcastResponse struct {
+}
+
+func (m *BroadcastResponse) Reset()         { *m = BroadcastResponse{} }
+func (m *BroadcastResponse) String() string { return proto.CompactTextString(m) }
+func (*BroadcastResponse) ProtoMessage()    {}
+
+// DeliverRequest subscribes to the ordered batches from sequence number start on
+type DeliverRequest struct {
+	Start uint64 `protobuf:"varint,1,opt,name=start" json:"start,omitempty"`
+}
+
+func (m *DeliverRequest) Reset()         { *m = DeliverRequest{} }
+func (m *DeliverRequest) String() string { return proto.CompactTextString(m) }
+func (*DeliverRequest) ProtoMessage()    {}
+
+type OrderedBatch struct {
+	SeqNo        uint64   `protobuf:"varint,1,opt,name=seq_no" json:"seq_no,omitempty"`
+	Transactions [][]byte `protobuf:"bytes,2,rep,name=transactions,proto3" json:"transactions,omitempty"`
+}
+
+func (m *OrderedBatch) Reset()         { *m = OrderedBatch{} }
+func (m *OrderedBatch) String() string { return proto.CompactTextString(m) }
+func (*OrderedBatch) ProtoMessage()    {}
+
+// Metadata is committed with the block of every executed batch, so that the
+// execution side resumes the stream after its last batch
+type Metadata struct {
+	SeqNo uint64 `protobuf:"varint,1,opt,name=seq_no" json:"seq_no,omitempty"`
+}
+
+func (m *Metadata) Reset()         { *m = Metadata{} }
+func (m *Metadata) String() string { return proto.CompactTextString(m) }
+func (*Metadata) ProtoMessage()    {}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// Client API for AtomicBroadcast service
+
+type AtomicBroadcastClient interface {
+	Broadcast(ctx context.Context, in *BroadcastMessage, opts ...grpc.CallOption) (*BroadcastResponse, error)
+	Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (AtomicBroadcast_DeliverClient, error)
+}
+
+type atomicBroadcastClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAtomicBroadcastClient(cc *grpc.ClientConn) AtomicBroadcastClient {
+	return &atomicBroadcastClient{cc}
+}
+
+func (c *atomicBroadcastClient) Broadcast(ctx context.Context, in *BroadcastMessage, opts ...grpc.CallOption) (*BroadcastResponse, error) {
+	out := new(BroadcastResponse)
+	err := grpc.Invoke(ctx, "/orderer.AtomicBroadcast/Broadcast", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *atomicBroadcastClient) Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (AtomicBroadcast_DeliverClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_AtomicBroadcast_serviceDesc.Streams[0], c.cc, "/orderer.AtomicBroadcast/Deliver", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &atomicBroadcastDeliverClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AtomicBroadcast_DeliverClient interface {
+	Recv() (*OrderedBatch, error)
+	grpc.ClientStream
+}
+
+type atomicBroadcastDeliverClient struct {
+	grpc.ClientStream
+}
+
+func (x *atomicBroadcastDeliverClient) Recv() (*OrderedBatch, error) {
+	m := new(OrderedBatch)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for AtomicBroadcast service
+
+type AtomicBroadcastServer interface {
+	Broadcast(context.Context, *BroadcastMessage) (*BroadcastResponse, error)
+	Deliver(*DeliverRequest, AtomicBroadcast_DeliverServer) error
+}
+
+func RegisterAtomicBroadcastServer(s *grpc.Server, srv AtomicBroadcastServer) {
+	s.RegisterService(&_AtomicBroadcast_serviceDesc, srv)
+}
+
+func _AtomicBroadcast_Broadcast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(BroadcastMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AtomicBroadcastServer).Broadcast(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _AtomicBroadcast_Deliver_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DeliverRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AtomicBroadcastServer).Deliver(m, &atomicBroadcastDeliverServer{stream})
+}
+
+type AtomicBroadcast_DeliverServer interface {
+	Send(*OrderedBatch) error
+	grpc.ServerStream
+}
+
+type atomicBroadcastDeliverServer struct {
+	grpc.ServerStream
+}
+
+func (x *atomicBroadcastDeliverServer) Send(m *OrderedBatch) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _AtomicBroadcast_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "orderer.AtomicBroadcast",
+	HandlerType: (*AtomicBroadcastServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Broadcast",
+			Handler:    _AtomicBroadcast_Broadcast_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Deliver",
+			Handler:       _AtomicBroadcast_Deliver_Handler,
+			ServerStreams: true,
+		},
+	},
+}
@@ -0,0 +1,241 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orderer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// sequencer orders transactions in arrival order, one batch each
+type sequencer struct {
+	lock   sync.Mutex
+	seqNo  uint64
+	server *Server
+}
+
+func (s *sequencer) submit(tx *pb.Transaction) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seqNo++
+	s.server.Ordered(s.seqNo, []*pb.Transaction{tx})
+	return nil
+}
+
+// mockLedger is the stack of an executing peer
+type mockLedger struct {
+	consensus.Stack
+	lock   sync.Mutex
+	batch  []*pb.Transaction
+	blocks []string // UUIDs of the committed transactions, in order
+	meta   []byte
+}
+
+func (ml *mockLedger) GetBlockHeadMetadata() ([]byte, error) {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	return ml.meta, nil
+}
+
+func (ml *mockLedger) BeginTxBatch(id interface{}) error {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	ml.batch = nil
+	return nil
+}
+
+func (ml *mockLedger) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error) {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	ml.batch = append(ml.batch, txs...)
+	return nil, nil
+}
+
+func (ml *mockLedger) CommitTxBatch(id interface{}, metadata []byte) (*pb.Block, error) {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	for _, tx := range ml.batch {
+		ml.blocks = append(ml.blocks, tx.Uuid)
+	}
+	ml.meta = metadata
+	return &pb.Block{}, nil
+}
+
+func (ml *mockLedger) RollbackTxBatch(id interface{}) error { return nil }
+
+func (ml *mockLedger) committed() string {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	return fmt.Sprint(ml.blocks)
+}
+
+func (ml *mockLedger) waitFor(t *testing.T, expected string) {
+	for deadline := time.Now().Add(5 * time.Second); ml.committed() != expected; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the ledger to hold %s, it holds %s", expected, ml.committed())
+		}
+	}
+}
+
+func startServer(t *testing.T, retain int) *Server {
+	seq := &sequencer{}
+	seq.server = NewServer(seq.submit, retain)
+	if err := seq.server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	return seq.server
+}
+
+func connect(t *testing.T, stack consensus.Stack, f int, servers ...*Server) *Consumer {
+	var addresses []string
+	var conns []*grpc.ClientConn
+	for _, server := range servers {
+		conn, err := grpc.Dial(server.Addr().String(), grpc.WithInsecure())
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, server.Addr().String())
+		conns = append(conns, conn)
+	}
+	return newConsumer(stack, addresses, conns, f, 10*time.Millisecond)
+}
+
+func submit(t *testing.T, c *Consumer, uuid string) {
+	raw, _ := proto.Marshal(&pb.Transaction{Uuid: uuid})
+	if err := c.RecvMsg(&pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: raw}, nil); err != nil {
+		t.Fatalf("Could not submit transaction %s: %s", uuid, err)
+	}
+}
+
+func TestConsumerExecutesOrderedBatches(t *testing.T) {
+	server := startServer(t, 10)
+	defer server.Close()
+
+	ledger := &mockLedger{}
+	c := connect(t, ledger, 0, server)
+	submit(t, c, "a")
+	submit(t, c, "b")
+	ledger.waitFor(t, "[a b]")
+	c.Close()
+
+	// The stream resumes after the last committed batch, rather than
+	// executing the retained batches again
+	other := connect(t, &mockLedger{}, 0, server)
+	defer other.Close()
+	submit(t, other, "c")
+
+	c = connect(t, ledger, 0, server)
+	defer c.Close()
+	ledger.waitFor(t, "[a b c]")
+	submit(t, c, "d")
+	ledger.waitFor(t, "[a b c d]")
+}
+
+func TestConsumerRequiresAgreement(t *testing.T) {
+	var servers []*Server
+	for i := 0; i < 4; i++ {
+		server := NewServer(func(tx *pb.Transaction) error { return nil }, 10)
+		if err := server.Listen("127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		servers = append(servers, server)
+	}
+
+	// Orderer 0 is faulty and forges the batches it delivers, orderer 3 has
+	// not ordered the second batch yet
+	servers[0].Ordered(1, []*pb.Transaction{{Uuid: "forged"}})
+	servers[0].Ordered(2, []*pb.Transaction{{Uuid: "forged"}})
+	for _, server := range servers[1:] {
+		server.Ordered(1, []*pb.Transaction{{Uuid: "a"}})
+	}
+	servers[1].Ordered(2, []*pb.Transaction{{Uuid: "b"}})
+
+	ledger := &mockLedger{}
+	c := connect(t, ledger, 1, servers...)
+	defer c.Close()
+	ledger.waitFor(t, "[a]")
+	time.Sleep(50 * time.Millisecond)
+	if committed := ledger.committed(); committed != "[a]" {
+		t.Fatalf("Expected batch 2 to wait for a second orderer, the ledger holds %s", committed)
+	}
+	servers[2].Ordered(2, []*pb.Transaction{{Uuid: "b"}})
+	ledger.waitFor(t, "[a b]")
+}
+
+func TestConsumerRejectsGaps(t *testing.T) {
+	server := startServer(t, 10)
+	defer server.Close()
+	server.Ordered(1, []*pb.Transaction{{Uuid: "a"}})
+	server.Ordered(3, []*pb.Transaction{{Uuid: "c"}})
+
+	ledger := &mockLedger{}
+	c := connect(t, ledger, 0, server)
+	defer c.Close()
+	ledger.waitFor(t, "[a]")
+	time.Sleep(50 * time.Millisecond)
+	if committed := ledger.committed(); committed != "[a]" {
+		t.Fatalf("Expected batch 3 not to be executed after batch 1, the ledger holds %s", committed)
+	}
+}
+
+func TestServerDeliverPruned(t *testing.T) {
+	server := startServer(t, 2)
+	defer server.Close()
+	for i := uint64(1); i <= 3; i++ {
+		server.Ordered(i, []*pb.Transaction{{Uuid: fmt.Sprint(i)}})
+	}
+
+	conn, err := grpc.Dial(server.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewAtomicBroadcastClient(conn)
+
+	stream, err := client.Deliver(context.Background(), &DeliverRequest{Start: 1})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if grpc.Code(err) != codes.OutOfRange {
+		t.Errorf("Expected batch 1 to be out of range, got %v", err)
+	}
+
+	stream, err = client.Deliver(context.Background(), &DeliverRequest{Start: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []uint64{2, 3} {
+		batch, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if batch.SeqNo != expected {
+			t.Errorf("Expected batch %d, got %d", expected, batch.SeqNo)
+		}
+	}
+}
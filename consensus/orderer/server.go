@@ -0,0 +1,186 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package orderer
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger *logging.Logger // package-level logger
+
+func init() {
+	logger = logging.MustGetLogger("consensus/orderer")
+}
+
+// Server serves the batches a consenter in the standalone ordering role
+// orders to the peers which execute them. The consenter hands every batch
+// to Ordered instead of executing it, and the server retains the last
+// batches for peers which reconnect
+type Server struct {
+	submit func(tx *pb.Transaction) error // orders tx
+	retain int
+
+	lock     sync.Mutex
+	batches  []*OrderedBatch // the retained batches, by ascending sequence number
+	pruned   uint64          // highest sequence number no longer retained
+	notify   chan struct{}   // closed once the next batch is ordered
+	exit     chan struct{}
+	grpc     *grpc.Server
+	listener net.Listener
+}
+
+// NewServer creates a Server which submits the transactions it receives
+// for ordering to submit, and retains the last retain ordered batches
+func NewServer(submit func(tx *pb.Transaction) error, retain int) *Server {
+	if retain < 1 {
+		retain = 1
+	}
+	return &Server{
+		submit: submit,
+		retain: retain,
+		notify: make(chan struct{}),
+		exit:   make(chan struct{}),
+	}
+}
+
+// Listen serves the AtomicBroadcast service on address
+func (s *Server) Listen(address string) error {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("Cannot listen for the ordering service on %s: %s", address, err)
+	}
+	s.lock.Lock()
+	s.listener = lis
+	s.grpc = grpc.NewServer()
+	RegisterAtomicBroadcastServer(s.grpc, s)
+	s.lock.Unlock()
+
+	logger.Info("Serving ordered batches on %s", lis.Addr())
+	go s.grpc.Serve(lis)
+	return nil
+}
+
+// Addr returns the address the service listens on, nil before Listen
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops serving, subscribed peers are disconnected
+func (s *Server) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	close(s.exit)
+	if s.grpc != nil {
+		s.grpc.Stop()
+	}
+}
+
+// Ordered records the batch of sequence number seqNo, the consenter calls it
+// in ascending sequence number order
+func (s *Server) Ordered(seqNo uint64, txs []*pb.Transaction) {
+	batch := &OrderedBatch{SeqNo: seqNo}
+	for _, tx := range txs {
+		raw, err := proto.Marshal(tx)
+		if err != nil {
+			logger.Error("Cannot marshal transaction %s of ordered batch %d: %s", tx.Uuid, seqNo, err)
+			continue
+		}
+		batch.Transactions = append(batch.Transactions, raw)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if n := len(s.batches); n > 0 && s.batches[n-1].SeqNo >= seqNo {
+		logger.Warning("Discarding batch %d, it was ordered after batch %d", seqNo, s.batches[n-1].SeqNo)
+		return
+	}
+	s.batches = append(s.batches, batch)
+	if len(s.batches) > s.retain {
+		s.pruned = s.batches[0].SeqNo
+		s.batches = s.batches[1:]
+	}
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// Broadcast submits a transaction for ordering
+func (s *Server) Broadcast(ctx context.Context, msg *BroadcastMessage) (*BroadcastResponse, error) {
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(msg.Transaction, tx); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "Cannot unmarshal transaction: %s", err)
+	}
+	if err := s.submit(tx); err != nil {
+		return nil, grpc.Errorf(codes.Unavailable, "Cannot order transaction %s: %s", tx.Uuid, err)
+	}
+	return &BroadcastResponse{}, nil
+}
+
+// Deliver streams the ordered batches from the requested sequence number on,
+// as they are ordered, until the peer disconnects
+func (s *Server) Deliver(req *DeliverRequest, stream AtomicBroadcast_DeliverServer) error {
+	next := req.Start
+	for {
+		batches, notify, err := s.since(next)
+		if err != nil {
+			return err
+		}
+		for _, batch := range batches {
+			if err := stream.Send(batch); err != nil {
+				return err
+			}
+			next = batch.SeqNo + 1
+		}
+		if len(batches) > 0 {
+			continue
+		}
+		select {
+		case <-notify:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.exit:
+			return grpc.Errorf(codes.Unavailable, "Ordering service is shutting down")
+		}
+	}
+}
+
+// since returns the retained batches from sequence number next on, and a
+// channel which is closed once another batch is ordered
+func (s *Server) since(next uint64) ([]*OrderedBatch, <-chan struct{}, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if next <= s.pruned {
+		return nil, nil, grpc.Errorf(codes.OutOfRange, "Batch %d is no longer retained, the oldest retained batch follows batch %d", next, s.pruned)
+	}
+	i := sort.Search(len(s.batches), func(i int) bool { return s.batches[i].SeqNo >= next })
+	return append([]*OrderedBatch(nil), s.batches[i:]...), s.notify, nil
+}
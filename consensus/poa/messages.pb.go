@@ -0,0 +1,135 @@
+// Code generated by protoc-gen-go.
+// source: poa/messages.proto
+// DO NOT EDIT!
+
+/*
+Package poa is a generated protocol buffer package.
+
+It is generated from these files:
+
+	poa/messages.proto
+
+It has these top-level messages:
+
+	Message
+	Batch
+*/
+package poa
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// Message is the payload of the CONSENSUS messages the validators exchange
+type Message struct {
+	// Types that are valid to be assigned to Payload:
+	//	*Message_Transaction
+	//	*Message_Batch
+	Payload isMessage_Payload `protobuf_oneof:"payload"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+
+type isMessage_Payload interface {
+	isMessage_Payload()
+}
+
+type Message_Transaction struct {
+	Transaction []byte `protobuf:"bytes,1,opt,name=transaction,proto3,oneof"`
+}
+type Message_Batch struct {
+	Batch *Batch `protobuf:"bytes,2,opt,name=batch,oneof"`
+}
+
+func (*Message_Transaction) isMessage_Payload() {}
+func (*Message_Batch) isMessage_Payload()       {}
+
+func (m *Message) GetPayload() isMessage_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (m *Message) GetTransaction() []byte {
+	if x, ok := m.GetPayload().(*Message_Transaction); ok {
+		return x.Transaction
+	}
+	return nil
+}
+
+func (m *Message) GetBatch() *Batch {
+	if x, ok := m.GetPayload().(*Message_Batch); ok {
+		return x.Batch
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*Message) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), []interface{}) {
+	return _Message_OneofMarshaler, _Message_OneofUnmarshaler, []interface{}{
+		(*Message_Transaction)(nil),
+		(*Message_Batch)(nil),
+	}
+}
+
+func _Message_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*Message)
+	// payload
+	switch x := m.Payload.(type) {
+	case *Message_Transaction:
+		b.EncodeVarint(1<<3 | proto.WireBytes)
+		b.EncodeRawBytes(x.Transaction)
+	case *Message_Batch:
+		b.EncodeVarint(2<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Batch); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		return fmt.Errorf("Message.Payload has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _Message_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*Message)
+	switch tag {
+	case 1: // payload.transaction
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeRawBytes(true)
+		m.Payload = &Message_Transaction{x}
+		return true, err
+	case 2: // payload.batch
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Batch)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_Batch{msg}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+// Batch is cut by the validator whose turn it is, the next block
+type Batch struct {
+	Height       uint64   `protobuf:"varint,1,opt,name=height" json:"height,omitempty"`
+	Turn         uint64   `protobuf:"varint,2,opt,name=turn" json:"turn,omitempty"`
+	ReplicaId    uint64   `protobuf:"varint,3,opt,name=replica_id" json:"replica_id,omitempty"`
+	Transactions [][]byte `protobuf:"bytes,4,rep,name=transactions,proto3" json:"transactions,omitempty"`
+}
+
+func (m *Batch) Reset()         { *m = Batch{} }
+func (m *Batch) String() string { return proto.CompactTextString(m) }
+func (*Batch) ProtoMessage()    {}
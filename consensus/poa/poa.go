@@ -0,0 +1,402 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger *logging.Logger // package-level logger
+
+func init() {
+	logger = logging.MustGetLogger("consensus/poa")
+	consensus.RegisterPlugin(&consensus.Plugin{Name: "poa", Shared: New, New: New})
+}
+
+// executedWindow is how many executed transactions are remembered, so that
+// a transaction broadcast after its block was cut is not ordered again
+const executedWindow = 10000
+
+// PoA is a proof-of-authority consensus plugin for small consortia of
+// trusted validators. Instead of agreeing on every block, the validators take
+// turns cutting blocks on a fixed schedule, the validator whose turn it is
+// broadcasts the block and every validator executes it. If the validator
+// whose turn it is does not cut a block in time, the others skip its turn.
+//
+// PoA tolerates crashed validators, not byzantine ones, and a validator which
+// misses a block stays behind, as there is no state transfer
+type PoA struct {
+	stack        consensus.Stack
+	id           uint64
+	n            uint64
+	batchSize    int
+	batchTimeout time.Duration
+	turnTimeout  time.Duration
+
+	incoming chan *incoming
+	exit     chan struct{}
+
+	// owned by the event loop
+	height     uint64            // number of the next block
+	turn       uint64            // turns skipped at this height
+	future     map[uint64]*Batch // blocks which arrived ahead of the ones before them, by number
+	pending    []*pb.Transaction // transactions waiting for a block, in arrival order
+	known      map[string]bool   // UUIDs of the pending transactions
+	executed   map[string]bool   // UUIDs of the last executed transactions
+	execOrder  []string          // executed UUIDs, oldest first
+	batchTimer *time.Timer       // expires when the validator whose turn it is cuts a block
+	turnTimer  *time.Timer       // expires when the turn of another validator is skipped
+	batchArmed bool              // whether batchTimer is running
+	turnArmed  bool              // whether turnTimer is running
+}
+
+// incoming is a message handed to the event loop
+type incoming struct {
+	msg    *Message
+	sender *pb.PeerID
+}
+
+// New creates a PoA consenter on stack
+func New(stack consensus.Stack) consensus.Consenter {
+	handle, _, err := stack.GetNetworkHandles()
+	if err != nil {
+		panic(fmt.Errorf("Cannot obtain the handle of this validator: %s", err))
+	}
+	id, err := validatorID(handle)
+	if err != nil {
+		panic(err)
+	}
+	return newPoA(id, loadConfig(), stack)
+}
+
+func newPoA(id uint64, config *viper.Viper, stack consensus.Stack) *PoA {
+	var err error
+	p := &PoA{
+		stack:    stack,
+		id:       id,
+		n:        uint64(config.GetInt("N")),
+		incoming: make(chan *incoming, 100),
+		exit:     make(chan struct{}),
+		known:    make(map[string]bool),
+		future:   make(map[uint64]*Batch),
+		executed: make(map[string]bool),
+	}
+	if p.n == 0 || id >= p.n {
+		panic(fmt.Errorf("Validator %d is not one of the %d validators taking turns", id, p.n))
+	}
+	p.batchSize = config.GetInt("batch.size")
+	if p.batchSize < 1 {
+		panic(fmt.Errorf("Batch size must be at least 1, got %d", p.batchSize))
+	}
+	if p.batchTimeout, err = time.ParseDuration(config.GetString("timeout.batch")); err != nil {
+		panic(fmt.Errorf("Cannot parse batch timeout: %s", err))
+	}
+	if p.turnTimeout, err = time.ParseDuration(config.GetString("timeout.turn")); err != nil {
+		panic(fmt.Errorf("Cannot parse turn timeout: %s", err))
+	}
+	if p.turnTimeout <= p.batchTimeout {
+		panic(fmt.Errorf("Turn timeout %v must exceed the batch timeout %v", p.turnTimeout, p.batchTimeout))
+	}
+	p.height = stack.GetBlockchainSize()
+
+	logger.Info("PoA validator %d of %d", p.id, p.n)
+	logger.Info("PoA batch size = %d", p.batchSize)
+	logger.Info("PoA batch timeout = %v", p.batchTimeout)
+	logger.Info("PoA turn timeout = %v", p.turnTimeout)
+
+	p.batchTimer = time.NewTimer(p.batchTimeout)
+	p.batchTimer.Stop()
+	p.turnTimer = time.NewTimer(p.turnTimeout)
+	p.turnTimer.Stop()
+	go p.loop()
+	return p
+}
+
+// RecvMsg is called for Message_CHAIN_TRANSACTION and Message_CONSENSUS messages
+func (p *PoA) RecvMsg(msg *pb.Message, senderHandle *pb.PeerID) error {
+	switch msg.Type {
+	case pb.Message_CHAIN_TRANSACTION:
+		tx := &pb.Transaction{}
+		if err := proto.Unmarshal(msg.Payload, tx); err != nil {
+			return fmt.Errorf("Error unmarshalling payload of received Message:%s.", msg.Type)
+		}
+		payload, err := proto.Marshal(&Message{&Message_Transaction{msg.Payload}})
+		if err != nil {
+			return err
+		}
+		if err := p.stack.Broadcast(&pb.Message{Type: pb.Message_CONSENSUS, Payload: payload}, pb.PeerEndpoint_VALIDATOR); err != nil {
+			return fmt.Errorf("Failed to broadcast transaction: %v", err)
+		}
+		p.incoming <- &incoming{msg: &Message{&Message_Transaction{msg.Payload}}, sender: senderHandle}
+	case pb.Message_CONSENSUS:
+		poaMsg := &Message{}
+		if err := proto.Unmarshal(msg.Payload, poaMsg); err != nil {
+			return fmt.Errorf("Error unmarshalling payload of received Message:%s.", msg.Type)
+		}
+		p.incoming <- &incoming{msg: poaMsg, sender: senderHandle}
+	default:
+		return fmt.Errorf("Unexpected message type %s", msg.Type)
+	}
+	return nil
+}
+
+// StateUpdating is called once state transfer is initiated, currently unused
+func (p *PoA) StateUpdating(tag uint64, id []byte) {
+	// ignored as it is never initiated
+}
+
+// StateUpdated is called once state transfer finishes, currently unused
+func (p *PoA) StateUpdated(tag uint64, id []byte) {
+	// ignored as it is never initiated
+}
+
+// Close stops the PoA consenter, pending transactions are discarded
+func (p *PoA) Close() {
+	close(p.exit)
+}
+
+// leader returns the validator whose turn it is to cut block height after turn skipped turns
+func (p *PoA) leader(height, turn uint64) uint64 {
+	return (height + turn) % p.n
+}
+
+func (p *PoA) loop() {
+	for {
+		select {
+		case in := <-p.incoming:
+			if tx := in.msg.GetTransaction(); tx != nil {
+				p.recvTransaction(tx)
+			} else if batch := in.msg.GetBatch(); batch != nil {
+				p.recvBatch(batch, in.sender)
+			}
+		case <-p.batchTimer.C:
+			p.batchArmed = false
+			p.cut()
+		case <-p.turnTimer.C:
+			p.turnArmed = false
+			p.skipTurn()
+		case <-p.exit:
+			p.batchTimer.Stop()
+			p.turnTimer.Stop()
+			return
+		}
+	}
+}
+
+func (p *PoA) recvTransaction(raw []byte) {
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(raw, tx); err != nil {
+		logger.Warning("PoA validator %d could not unmarshal transaction: %s", p.id, err)
+		return
+	}
+	if p.known[tx.Uuid] || p.executed[tx.Uuid] {
+		return
+	}
+	p.known[tx.Uuid] = true
+	p.pending = append(p.pending, tx)
+	p.schedule()
+}
+
+// schedule arms the timer matching our role at the current turn, or cuts a
+// block at once if it is our turn and a full batch is pending
+func (p *PoA) schedule() {
+	if len(p.pending) == 0 {
+		return
+	}
+	if p.leader(p.height, p.turn) == p.id {
+		if len(p.pending) >= p.batchSize {
+			p.cut()
+		} else if !p.batchArmed {
+			p.batchTimer.Reset(p.batchTimeout)
+			p.batchArmed = true
+		}
+		return
+	}
+	if !p.turnArmed {
+		p.turnTimer.Reset(p.turnTimeout)
+		p.turnArmed = true
+	}
+}
+
+// cut broadcasts the pending transactions as the next block, if it is our turn
+func (p *PoA) cut() {
+	if len(p.pending) == 0 || p.leader(p.height, p.turn) != p.id {
+		return
+	}
+	count := len(p.pending)
+	if count > p.batchSize {
+		count = p.batchSize
+	}
+	batch := &Batch{
+		Height:    p.height,
+		Turn:      p.turn,
+		ReplicaId: p.id,
+	}
+	for _, tx := range p.pending[:count] {
+		raw, err := proto.Marshal(tx)
+		if err != nil {
+			logger.Error("PoA validator %d could not marshal transaction %s: %s", p.id, tx.Uuid, err)
+			return
+		}
+		batch.Transactions = append(batch.Transactions, raw)
+	}
+
+	logger.Info("PoA validator %d cutting block %d with %d transactions", p.id, p.height, count)
+	payload, err := proto.Marshal(&Message{&Message_Batch{batch}})
+	if err != nil {
+		logger.Error("PoA validator %d could not marshal block %d: %s", p.id, p.height, err)
+		return
+	}
+	if err := p.stack.Broadcast(&pb.Message{Type: pb.Message_CONSENSUS, Payload: payload}, pb.PeerEndpoint_VALIDATOR); err != nil {
+		logger.Error("PoA validator %d could not broadcast block %d: %s", p.id, p.height, err)
+	}
+	p.execute(batch)
+}
+
+// recvBatch executes the block of the validator whose turn it was
+func (p *PoA) recvBatch(batch *Batch, sender *pb.PeerID) {
+	senderID, err := validatorID(sender)
+	if err != nil || senderID != batch.ReplicaId || p.leader(batch.Height, batch.Turn) != senderID {
+		logger.Warning("PoA validator %d discarding block %d from %v, it was not its turn", p.id, batch.Height, sender)
+		return
+	}
+	if batch.Height < p.height {
+		logger.Debug("PoA validator %d discarding block %d from validator %d, already executed", p.id, batch.Height, senderID)
+		return
+	}
+	if batch.Height >= p.height+p.n {
+		logger.Warning("PoA validator %d missed blocks %d to %d, it is behind", p.id, p.height, batch.Height-1)
+		return
+	}
+	if batch.Height > p.height {
+		// the block overtook the ones before it, which are on their way
+		p.future[batch.Height] = batch
+		return
+	}
+	p.execute(batch)
+	for next, ok := p.future[p.height]; ok; next, ok = p.future[p.height] {
+		delete(p.future, next.Height)
+		p.execute(next)
+	}
+}
+
+// execute executes and commits batch as the next block
+func (p *PoA) execute(batch *Batch) {
+	txs := make([]*pb.Transaction, 0, len(batch.Transactions))
+	for _, raw := range batch.Transactions {
+		tx := &pb.Transaction{}
+		if err := proto.Unmarshal(raw, tx); err != nil {
+			logger.Warning("PoA validator %d could not unmarshal transaction of block %d: %s", p.id, batch.Height, err)
+			continue
+		}
+		txs = append(txs, tx)
+	}
+
+	id := batch
+	if err := p.stack.BeginTxBatch(id); err != nil {
+		logger.Error("PoA validator %d could not begin block %d: %s", p.id, batch.Height, err)
+		return
+	}
+	if _, err := p.stack.ExecTxs(id, txs); err != nil {
+		logger.Error("PoA validator %d could not execute block %d: %s", p.id, batch.Height, err)
+		p.stack.RollbackTxBatch(id)
+		return
+	}
+	if _, err := p.stack.CommitTxBatch(id, nil); err != nil {
+		logger.Error("PoA validator %d could not commit block %d: %s", p.id, batch.Height, err)
+		p.stack.RollbackTxBatch(id)
+		return
+	}
+	logger.Debug("PoA validator %d committed block %d of validator %d", p.id, batch.Height, batch.ReplicaId)
+
+	for _, tx := range txs {
+		p.markExecuted(tx.Uuid)
+	}
+	remaining := p.pending[:0]
+	for _, tx := range p.pending {
+		if p.executed[tx.Uuid] {
+			delete(p.known, tx.Uuid)
+			continue
+		}
+		remaining = append(remaining, tx)
+	}
+	p.pending = remaining
+
+	p.height++
+	p.turn = 0
+	p.stopTimers()
+	p.schedule()
+}
+
+// skipTurn moves on to the next validator, as the one whose turn it is did not cut a block in time
+func (p *PoA) skipTurn() {
+	if len(p.pending) == 0 {
+		return
+	}
+	logger.Warning("PoA validator %d skipping the turn of validator %d for block %d", p.id, p.leader(p.height, p.turn), p.height)
+	p.turn++
+	if p.leader(p.height, p.turn) == p.id {
+		p.cut() // the block is overdue already
+		return
+	}
+	p.schedule()
+}
+
+func (p *PoA) markExecuted(uuid string) {
+	if p.executed[uuid] {
+		return
+	}
+	p.executed[uuid] = true
+	p.execOrder = append(p.execOrder, uuid)
+	if len(p.execOrder) > executedWindow {
+		delete(p.executed, p.execOrder[0])
+		p.execOrder = p.execOrder[1:]
+	}
+}
+
+func (p *PoA) stopTimers() {
+	if p.batchArmed && !p.batchTimer.Stop() {
+		<-p.batchTimer.C
+	}
+	p.batchArmed = false
+	if p.turnArmed && !p.turnTimer.Stop() {
+		<-p.turnTimer.C
+	}
+	p.turnArmed = false
+}
+
+// validatorID extracts X from the vpX handle of a validator
+func validatorID(handle *pb.PeerID) (uint64, error) {
+	if handle == nil || !strings.HasPrefix(handle.Name, "vp") {
+		return 0, fmt.Errorf("PoA expects validators named vpX, where X is between 0 and N-1, got %v", handle)
+	}
+	id, err := strconv.ParseUint(handle.Name[2:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Error extracting ID from \"%s\" handle: %v", handle.Name, err)
+	}
+	return id, nil
+}
@@ -0,0 +1,243 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poa
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// mockValidator is the stack of one validator of a mockNetwork, it
+// delivers the messages broadcast to it in order
+type mockValidator struct {
+	consensus.Stack
+	id      uint64
+	net     *mockNetwork
+	poa     *PoA
+	inbox   chan *mockMessage
+	crashed bool
+
+	lock    sync.Mutex
+	batch   []*pb.Transaction
+	blocks  [][]string // UUIDs of the transactions of the committed blocks
+	leaders []uint64   // validators which cut the committed blocks
+}
+
+type mockMessage struct {
+	msg    *pb.Message
+	sender *pb.PeerID
+}
+
+type mockNetwork struct {
+	validators []*mockValidator
+}
+
+func (mv *mockValidator) GetNetworkHandles() (*pb.PeerID, []*pb.PeerID, error) {
+	return mv.handle(), nil, nil
+}
+
+func (mv *mockValidator) handle() *pb.PeerID {
+	return &pb.PeerID{Name: fmt.Sprintf("vp%d", mv.id)}
+}
+
+func (mv *mockValidator) Broadcast(msg *pb.Message, peerType pb.PeerEndpoint_Type) error {
+	for _, v := range mv.net.validators {
+		if v != mv && !v.crashed {
+			v.inbox <- &mockMessage{msg, mv.handle()}
+		}
+	}
+	return nil
+}
+
+func (mv *mockValidator) GetBlockchainSize() uint64 {
+	mv.lock.Lock()
+	defer mv.lock.Unlock()
+	return uint64(len(mv.blocks)) + 1
+}
+
+func (mv *mockValidator) BeginTxBatch(id interface{}) error {
+	mv.lock.Lock()
+	defer mv.lock.Unlock()
+	mv.batch = nil
+	return nil
+}
+
+func (mv *mockValidator) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error) {
+	mv.lock.Lock()
+	defer mv.lock.Unlock()
+	mv.batch = append(mv.batch, txs...)
+	return nil, nil
+}
+
+func (mv *mockValidator) CommitTxBatch(id interface{}, metadata []byte) (*pb.Block, error) {
+	mv.lock.Lock()
+	defer mv.lock.Unlock()
+	var uuids []string
+	for _, tx := range mv.batch {
+		uuids = append(uuids, tx.Uuid)
+	}
+	mv.blocks = append(mv.blocks, uuids)
+	mv.leaders = append(mv.leaders, id.(*Batch).ReplicaId)
+	return &pb.Block{}, nil
+}
+
+func (mv *mockValidator) RollbackTxBatch(id interface{}) error { return nil }
+
+func (mv *mockValidator) cutBy() []uint64 {
+	mv.lock.Lock()
+	defer mv.lock.Unlock()
+	return append([]uint64(nil), mv.leaders...)
+}
+
+func (mv *mockValidator) committed() [][]string {
+	mv.lock.Lock()
+	defer mv.lock.Unlock()
+	return append([][]string(nil), mv.blocks...)
+}
+
+func newTestConfig() *viper.Viper {
+	config := viper.New()
+	config.Set("N", 4)
+	config.Set("batch.size", 1)
+	config.Set("timeout.batch", "10ms")
+	config.Set("timeout.turn", "200ms")
+	return config
+}
+
+func makeMockNetwork(t *testing.T, crashed ...uint64) *mockNetwork {
+	net := &mockNetwork{}
+	for id := uint64(0); id < 4; id++ {
+		net.validators = append(net.validators, &mockValidator{id: id, net: net, inbox: make(chan *mockMessage, 100)})
+	}
+	for _, id := range crashed {
+		net.validators[id].crashed = true
+	}
+	for _, mv := range net.validators {
+		if mv.crashed {
+			continue
+		}
+		mv.poa = newPoA(mv.id, newTestConfig(), mv)
+		go func(mv *mockValidator) {
+			for in := range mv.inbox {
+				mv.poa.RecvMsg(in.msg, in.sender)
+			}
+		}(mv)
+	}
+	return net
+}
+
+func (net *mockNetwork) stop() {
+	for _, mv := range net.validators {
+		if !mv.crashed {
+			mv.poa.Close()
+		}
+	}
+}
+
+func submit(t *testing.T, mv *mockValidator, uuid string) {
+	raw, _ := proto.Marshal(&pb.Transaction{Uuid: uuid})
+	if err := mv.poa.RecvMsg(&pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: raw}, nil); err != nil {
+		t.Fatalf("Could not submit transaction %s: %s", uuid, err)
+	}
+}
+
+// waitForBlocks waits until every live validator committed count blocks, and checks that they committed the same ones
+func (net *mockNetwork) waitForBlocks(t *testing.T, count int) [][]string {
+	deadline := time.Now().Add(5 * time.Second)
+	var reference [][]string
+	for _, mv := range net.validators {
+		if mv.crashed {
+			continue
+		}
+		for len(mv.committed()) < count {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for validator %d to commit %d blocks, committed %v", mv.id, count, mv.committed())
+			}
+			time.Sleep(time.Millisecond)
+		}
+		blocks := mv.committed()
+		if reference == nil {
+			reference = blocks
+			continue
+		}
+		if fmt.Sprint(blocks) != fmt.Sprint(reference) {
+			t.Fatalf("Validator %d committed %v, expected %v", mv.id, blocks, reference)
+		}
+	}
+	return reference
+}
+
+func TestPoATakesTurns(t *testing.T) {
+	net := makeMockNetwork(t)
+	defer net.stop()
+
+	for i := 0; i < 6; i++ {
+		submit(t, net.validators[i%4], fmt.Sprintf("tx%d", i))
+		net.waitForBlocks(t, i+1)
+	}
+	blocks := net.waitForBlocks(t, 6)
+	for i, block := range blocks {
+		if len(block) != 1 || block[0] != fmt.Sprintf("tx%d", i) {
+			t.Errorf("Expected block %d to hold tx%d, got %v", i+1, i, block)
+		}
+	}
+	for _, mv := range net.validators {
+		// block 1 is cut by vp1, block 2 by vp2 and so on
+		if leaders := fmt.Sprint(mv.cutBy()); leaders != "[1 2 3 0 1 2]" {
+			t.Errorf("Expected validator %d to commit blocks cut in turn, they were cut by %s", mv.id, leaders)
+		}
+	}
+}
+
+func TestPoASkipsCrashedValidator(t *testing.T) {
+	net := makeMockNetwork(t, 2)
+	defer net.stop()
+
+	// Block 1 is cut by vp1, block 2 would be cut by vp2, which crashed
+	submit(t, net.validators[0], "tx0")
+	net.waitForBlocks(t, 1)
+
+	start := time.Now()
+	submit(t, net.validators[0], "tx1")
+	blocks := net.waitForBlocks(t, 2)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected block 2 to be cut after the turn of vp2 was skipped, cut after %v", elapsed)
+	}
+	if fmt.Sprint(blocks) != "[[tx0] [tx1]]" {
+		t.Errorf("Unexpected blocks %v", blocks)
+	}
+	if leaders := fmt.Sprint(net.validators[0].cutBy()); leaders != "[1 3]" {
+		t.Errorf("Expected vp3 to cut block 2 in place of vp2, blocks were cut by %s", leaders)
+	}
+}
+
+func TestPoANewRejectsUnknownValidator(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a validator outside of the N validators to be rejected")
+		}
+	}()
+	newPoA(4, newTestConfig(), &mockValidator{})
+}
@@ -0,0 +1,122 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consensus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Capability is a feature a consensus plugin supports, or requires of the peer
+type Capability uint
+
+const (
+	// SupportsBFT plugins tolerate byzantine validators, not only crashed ones
+	SupportsBFT Capability = 1 << iota
+	// SupportsNonDeterminism plugins detect transactions whose execution
+	// differs between validators, and discard them
+	SupportsNonDeterminism
+	// SupportsStateTransfer plugins bring validators which fell behind up to date
+	SupportsStateTransfer
+	// RequiresPersistence plugins persist their protocol state through the
+	// stack, which needs the peer's file system path
+	RequiresPersistence
+)
+
+var capabilityNames = map[Capability]string{
+	SupportsBFT:            "bft",
+	SupportsNonDeterminism: "nondeterminism",
+	SupportsStateTransfer:  "statetransfer",
+	RequiresPersistence:    "persistence",
+}
+
+// ParseCapability returns the capability of the given name, e.g. "bft"
+func ParseCapability(name string) (Capability, error) {
+	for c, n := range capabilityNames {
+		if strings.EqualFold(n, name) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown consensus capability %s", name)
+}
+
+// Has returns whether all capabilities of other are part of c
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+func (c Capability) String() string {
+	var names []string
+	for bit := Capability(1); bit <= c && bit != 0; bit <<= 1 {
+		if c&bit == 0 {
+			continue
+		}
+		if name, ok := capabilityNames[bit]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("capability(%d)", uint(bit)))
+		}
+	}
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+// Plugin describes a consensus plugin to the controller
+type Plugin struct {
+	Name         string
+	Capabilities func() Capability           // the capabilities of the plugin as it is configured, nil if it has none
+	Shared       func(stack Stack) Consenter // returns the instance the peer starts with, which may be shared
+	New          func(stack Stack) Consenter // creates an instance which is not shared, so that it can be closed when it is replaced
+}
+
+var registry = struct {
+	sync.Mutex
+	plugins map[string]*Plugin
+}{plugins: make(map[string]*Plugin)}
+
+// RegisterPlugin makes a plugin available to the controller, plugins
+// register themselves when their package is initialized
+func RegisterPlugin(plugin *Plugin) {
+	registry.Lock()
+	defer registry.Unlock()
+	name := strings.ToLower(plugin.Name)
+	if _, ok := registry.plugins[name]; ok {
+		panic(fmt.Errorf("Consensus plugin %s registered twice", name))
+	}
+	registry.plugins[name] = plugin
+}
+
+// LookupPlugin returns the registered plugin of the given name
+func LookupPlugin(name string) (*Plugin, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	plugin, ok := registry.plugins[strings.ToLower(name)]
+	return plugin, ok
+}
+
+// PluginNames returns the names of the registered plugins, sorted
+func PluginNames() []string {
+	registry.Lock()
+	defer registry.Unlock()
+	var names []string
+	for name := range registry.plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,276 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testkit
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Mutator alters a message sent by a byzantine replica, it may return a
+// different payload, or nil to drop the message
+type Mutator func(src int, dst int, payload []byte) []byte
+
+type link struct {
+	src int
+	dst int
+}
+
+// PartitionPolicy decides what happens to messages between replicas in
+// different partitions
+type PartitionPolicy int
+
+const (
+	// DropAcross loses the messages, like a link which is down
+	DropAcross PartitionPolicy = iota
+	// BufferAcross holds the messages back until the partitions are healed,
+	// like a link which is congested, and then delivers them in order
+	BufferAcross
+)
+
+// faults holds the failures injected into the network
+type faults struct {
+	lock         sync.RWMutex
+	links        map[link]*linkState // links with a model of their own
+	defaultLinks map[link]*linkState // links following the model of the network
+	defaultModel LinkModel
+	rand         *rand.Rand     // source of the latencies, seeded with 1 unless set
+	partition    map[int]string // replica -> name of its partition, nil when the network is whole
+	policy       PartitionPolicy
+	held         []TaggedMsg // messages buffered across partitions
+	crashed      map[int]bool
+	mutators     map[int][]Mutator
+	rules        []*Rule
+}
+
+func (f *faults) isCrashed(id int) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.crashed[id]
+}
+
+func (f *faults) isPartitioned(src, dst int) bool {
+	if f.partition == nil {
+		return false
+	}
+	srcGroup, ok := f.partition[src]
+	if !ok {
+		return true
+	}
+	dstGroup, ok := f.partition[dst]
+	if !ok {
+		return true
+	}
+	return srcGroup != dstGroup
+}
+
+// apply drops messages to or from crashed replicas, drops or buffers
+// messages across partitions, and passes the remaining messages through the
+// mutators of the sender
+func (f *faults) apply(src int, dst int, payload []byte) []byte {
+	f.lock.Lock()
+	if f.crashed[src] || f.crashed[dst] {
+		f.lock.Unlock()
+		return nil
+	}
+	if f.isPartitioned(src, dst) {
+		if f.policy == BufferAcross {
+			f.held = append(f.held, TaggedMsg{src, dst, payload})
+		}
+		f.lock.Unlock()
+		return nil
+	}
+	mutators := f.mutators[src]
+	f.lock.Unlock()
+
+	for _, mutate := range mutators {
+		if payload == nil {
+			break
+		}
+		payload = mutate(src, dst, payload)
+	}
+	return payload
+}
+
+// delay blocks for the time the model of the link between src and dst
+// takes to deliver a message of size bytes
+func (f *faults) delay(src, dst int, size int) {
+	f.lock.Lock()
+	delay := f.linkDelay(src, dst, size, time.Now())
+	f.lock.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// SetLatency delays the delivery of every message on the network by the
+// given duration. As messages are delivered in order, this slows the whole
+// network rather than reordering messages
+func (net *Network) SetLatency(latency time.Duration) {
+	net.SetNetworkModel(LinkModel{Latency: Constant(latency)})
+}
+
+// SetLinkLatency delays the delivery of messages from src to dst by the
+// given duration, overriding the latency set with SetLatency
+func (net *Network) SetLinkLatency(src, dst int, latency time.Duration) {
+	net.SetLinkModel(src, dst, LinkModel{Latency: Constant(latency)})
+}
+
+// Partition splits the network into the given groups of replicas, messages
+// are only delivered between replicas of the same group. Replicas which are
+// not part of any group are isolated from the rest of the network. The
+// groups are named by their index, "0", "1" and so on
+func (net *Network) Partition(groups ...[]int) {
+	named := make(map[string][]int)
+	for i, group := range groups {
+		named[strconv.Itoa(i)] = group
+	}
+	net.Split(named)
+}
+
+// Split splits the network into the named partitions, messages are only
+// delivered between replicas of the same partition. Replicas which are not
+// part of any partition are isolated from the rest of the network. What
+// happens to the messages between partitions is decided by the partition
+// policy
+func (net *Network) Split(partitions map[string][]int) {
+	net.faults.lock.Lock()
+	defer net.faults.lock.Unlock()
+	net.faults.partition = make(map[int]string)
+	for name, members := range partitions {
+		for _, id := range members {
+			net.faults.partition[id] = name
+		}
+	}
+}
+
+// SetPartitionPolicy decides whether the messages between partitions are
+// dropped, the default, or buffered until the partitions are healed
+func (net *Network) SetPartitionPolicy(policy PartitionPolicy) {
+	net.faults.lock.Lock()
+	defer net.faults.lock.Unlock()
+	net.faults.policy = policy
+}
+
+// Rejoin merges the named partitions into the first of them, and delivers
+// the messages buffered between their replicas
+func (net *Network) Rejoin(names ...string) {
+	net.faults.lock.Lock()
+	if net.faults.partition != nil && len(names) > 0 {
+		merged := make(map[string]bool)
+		for _, name := range names {
+			merged[name] = true
+		}
+		for id, name := range net.faults.partition {
+			if merged[name] {
+				net.faults.partition[id] = names[0]
+			}
+		}
+	}
+	net.faults.lock.Unlock()
+	net.releaseHeld()
+}
+
+// Heal removes any partition of the network, and delivers the messages
+// buffered between the partitions
+func (net *Network) Heal() {
+	net.faults.lock.Lock()
+	net.faults.partition = nil
+	net.faults.lock.Unlock()
+	net.releaseHeld()
+}
+
+// releaseHeld delivers the buffered messages which no longer cross a
+// partition, in the order they were sent
+func (net *Network) releaseHeld() {
+	net.faults.lock.Lock()
+	var released, held []TaggedMsg
+	for _, tm := range net.faults.held {
+		if net.faults.isPartitioned(tm.Src, tm.Dst) {
+			held = append(held, tm)
+		} else {
+			released = append(released, tm)
+		}
+	}
+	net.faults.held = held
+	net.faults.lock.Unlock()
+
+	for _, tm := range released {
+		for _, payload := range net.filter(tm.Src, tm.Dst, tm.Msg) {
+			net.faults.delay(tm.Src, tm.Dst, len(payload))
+			net.Endpoints[tm.Dst].Deliver(payload, net.Endpoints[tm.Src].GetHandle())
+			net.delivered(tm.Src, tm.Dst, payload)
+		}
+	}
+}
+
+// Buffered returns the number of messages held back between partitions
+func (net *Network) Buffered() int {
+	net.faults.lock.RLock()
+	defer net.faults.lock.RUnlock()
+	return len(net.faults.held)
+}
+
+// Crash stops the endpoint with the given id, it will neither send nor
+// receive messages until it is restarted
+func (net *Network) Crash(id int) {
+	net.faults.lock.Lock()
+	if net.faults.crashed == nil {
+		net.faults.crashed = make(map[int]bool)
+	}
+	alreadyCrashed := net.faults.crashed[id]
+	net.faults.crashed[id] = true
+	net.faults.lock.Unlock()
+
+	if !alreadyCrashed {
+		net.Endpoints[id].Stop()
+	}
+}
+
+// Restart replaces a crashed endpoint with ep, which is typically built
+// from the state the crashed endpoint persisted, and reconnects it
+func (net *Network) Restart(id int, ep Endpoint) {
+	net.faults.lock.Lock()
+	net.Endpoints[id] = ep
+	delete(net.faults.crashed, id)
+	net.faults.lock.Unlock()
+
+	for _, m := range net.monitors.list() {
+		m.Restarted(id)
+	}
+}
+
+// Mutate makes the replica with the given id byzantine, every message it
+// sends is passed through the mutator before delivery
+func (net *Network) Mutate(id int, mutator Mutator) {
+	net.faults.lock.Lock()
+	defer net.faults.lock.Unlock()
+	if net.faults.mutators == nil {
+		net.faults.mutators = make(map[int][]Mutator)
+	}
+	net.faults.mutators[id] = append(net.faults.mutators[id], mutator)
+}
+
+// ClearMutators makes all replicas correct again
+func (net *Network) ClearMutators() {
+	net.faults.lock.Lock()
+	defer net.faults.lock.Unlock()
+	net.faults.mutators = nil
+}
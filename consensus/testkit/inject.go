@@ -0,0 +1,311 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testkit
+
+import (
+	"sync"
+	"time"
+)
+
+// Classifier decodes the type, view and sequence number of a payload, so
+// that rules can be scoped to them. It returns ok false for payloads it
+// cannot decode, these only match rules which are not scoped by type, view
+// or sequence number
+type Classifier func(payload []byte) (kind string, view uint64, seqNo uint64, ok bool)
+
+type action int
+
+const (
+	dropAction action = iota
+	delayAction
+	duplicateAction
+	reorderAction
+	corruptAction
+)
+
+type span struct {
+	lo, hi uint64
+}
+
+func (s *span) contains(n uint64) bool {
+	return s == nil || (s.lo <= n && n <= s.hi)
+}
+
+// Rule is a fault injected into the network for the messages it matches. A
+// rule is started with Inject, scoped with From, To, Type, Views, SeqNos and
+// Times, and installed by one of Drop, Delay, Duplicate, Reorder or Corrupt,
+// for instance
+//
+//	net.Inject().From(0).To(2, 3).Type("commit").Views(0, 1).Drop()
+//
+// Unscoped, a rule matches every message. Rules apply in the order they were
+// installed, after the crashes, partitions, mutators and the FilterFn
+type Rule struct {
+	net    *Network
+	src    map[int]bool // nil matches any sender
+	dst    map[int]bool // nil matches any receiver
+	kinds  map[string]bool
+	views  *span
+	seqNos *span
+	limit  int // messages the rule applies to before it expires, 0 if unlimited
+
+	action  action
+	latency time.Duration
+	copies  int
+	corrupt Mutator
+
+	lock    sync.Mutex
+	matched int
+	held    map[link][]byte // messages held back by a reorder rule, by link
+}
+
+// Inject starts a rule matching every message on the network
+func (net *Network) Inject() *Rule {
+	return &Rule{net: net}
+}
+
+func idSet(ids []int) map[int]bool {
+	set := make(map[int]bool)
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// From scopes the rule to messages sent by the given replicas
+func (r *Rule) From(ids ...int) *Rule {
+	r.src = idSet(ids)
+	return r
+}
+
+// To scopes the rule to messages delivered to the given replicas
+func (r *Rule) To(ids ...int) *Rule {
+	r.dst = idSet(ids)
+	return r
+}
+
+// Type scopes the rule to messages of the given types, as named by the
+// Classify function of the network
+func (r *Rule) Type(kinds ...string) *Rule {
+	r.kinds = make(map[string]bool)
+	for _, kind := range kinds {
+		r.kinds[kind] = true
+	}
+	return r
+}
+
+// Views scopes the rule to messages of views lo to hi, inclusive
+func (r *Rule) Views(lo, hi uint64) *Rule {
+	r.views = &span{lo, hi}
+	return r
+}
+
+// SeqNos scopes the rule to messages of sequence numbers lo to hi, inclusive
+func (r *Rule) SeqNos(lo, hi uint64) *Rule {
+	r.seqNos = &span{lo, hi}
+	return r
+}
+
+// Times expires the rule once it applied to n messages
+func (r *Rule) Times(n int) *Rule {
+	r.limit = n
+	return r
+}
+
+// Drop installs the rule, dropping the messages it matches
+func (r *Rule) Drop() *Rule {
+	r.action = dropAction
+	return r.install()
+}
+
+// Delay installs the rule, delaying the delivery of the messages it matches
+// by latency. Like SetLinkLatency, this slows the link rather than reordering
+func (r *Rule) Delay(latency time.Duration) *Rule {
+	r.action = delayAction
+	r.latency = latency
+	return r.install()
+}
+
+// Duplicate installs the rule, delivering the messages it matches copies
+// more times
+func (r *Rule) Duplicate(copies int) *Rule {
+	r.action = duplicateAction
+	r.copies = copies
+	return r.install()
+}
+
+// Reorder installs the rule, holding back each message it matches until the
+// next message on the same link, which is delivered first. Messages still
+// held back are delivered when the rule is removed
+func (r *Rule) Reorder() *Rule {
+	r.action = reorderAction
+	r.held = make(map[link][]byte)
+	return r.install()
+}
+
+// Corrupt installs the rule, passing the messages it matches through
+// mutate, or flipping the bits of their last byte if mutate is nil
+func (r *Rule) Corrupt(mutate Mutator) *Rule {
+	r.action = corruptAction
+	r.corrupt = mutate
+	if r.corrupt == nil {
+		r.corrupt = flipLastByte
+	}
+	return r.install()
+}
+
+func flipLastByte(src int, dst int, payload []byte) []byte {
+	if len(payload) == 0 {
+		return payload
+	}
+	corrupted := append([]byte(nil), payload...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	return corrupted
+}
+
+func (r *Rule) install() *Rule {
+	r.net.faults.lock.Lock()
+	defer r.net.faults.lock.Unlock()
+	r.net.faults.rules = append(r.net.faults.rules, r)
+	return r
+}
+
+// Matched returns the number of messages the rule applied to
+func (r *Rule) Matched() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.matched
+}
+
+// Remove uninstalls the rule, and delivers the messages it held back
+func (r *Rule) Remove() {
+	r.net.faults.lock.Lock()
+	rules := r.net.faults.rules[:0]
+	for _, other := range r.net.faults.rules {
+		if other != r {
+			rules = append(rules, other)
+		}
+	}
+	r.net.faults.rules = rules
+	r.net.faults.lock.Unlock()
+
+	r.lock.Lock()
+	held := r.held
+	r.held = nil
+	r.lock.Unlock()
+	for l, payload := range held {
+		r.net.Endpoints[l.dst].Deliver(payload, r.net.Endpoints[l.src].GetHandle())
+		r.net.delivered(l.src, l.dst, payload)
+	}
+}
+
+// ClearRules removes all rules, delivering the messages they held back
+func (net *Network) ClearRules() {
+	net.faults.lock.RLock()
+	rules := append([]*Rule(nil), net.faults.rules...)
+	net.faults.lock.RUnlock()
+	for _, r := range rules {
+		r.Remove()
+	}
+}
+
+// matches returns whether the rule applies to payload, it must be called
+// with the lock of the rule held
+func (r *Rule) matches(src, dst int, payload []byte) bool {
+	if r.limit > 0 && r.matched >= r.limit {
+		return false
+	}
+	if (r.src != nil && !r.src[src]) || (r.dst != nil && !r.dst[dst]) {
+		return false
+	}
+	if r.kinds == nil && r.views == nil && r.seqNos == nil {
+		return true
+	}
+	if r.net.Classify == nil {
+		return false
+	}
+	kind, view, seqNo, ok := r.net.Classify(payload)
+	if !ok || (r.kinds != nil && !r.kinds[kind]) {
+		return false
+	}
+	return r.views.contains(view) && r.seqNos.contains(seqNo)
+}
+
+// apply returns the payloads to deliver in place of payload
+func (r *Rule) apply(src, dst int, payload []byte) [][]byte {
+	r.lock.Lock()
+	l := link{src, dst}
+	var released []byte
+	if r.action == reorderAction {
+		released = r.held[l]
+		delete(r.held, l)
+	}
+	if !r.matches(src, dst, payload) {
+		r.lock.Unlock()
+		if released != nil {
+			return [][]byte{payload, released}
+		}
+		return [][]byte{payload}
+	}
+	r.matched++
+	if r.action == reorderAction && released == nil && r.held != nil {
+		r.held[l] = payload
+		r.lock.Unlock()
+		return nil
+	}
+	r.lock.Unlock()
+
+	switch r.action {
+	case dropAction:
+		return nil
+	case delayAction:
+		time.Sleep(r.latency)
+	case duplicateAction:
+		payloads := [][]byte{payload}
+		for i := 0; i < r.copies; i++ {
+			payloads = append(payloads, payload)
+		}
+		return payloads
+	case corruptAction:
+		if payload = r.corrupt(src, dst, payload); payload == nil {
+			return nil
+		}
+	case reorderAction:
+		if released != nil {
+			return [][]byte{payload, released}
+		}
+	}
+	return [][]byte{payload}
+}
+
+// inject passes a message from src to dst through the rules, and returns
+// the payloads to deliver in its place
+func (f *faults) inject(src, dst int, payload []byte) [][]byte {
+	f.lock.RLock()
+	rules := append([]*Rule(nil), f.rules...)
+	f.lock.RUnlock()
+
+	payloads := [][]byte{payload}
+	for _, r := range rules {
+		var next [][]byte
+		for _, p := range payloads {
+			next = append(next, r.apply(src, dst, p)...)
+		}
+		payloads = next
+	}
+	return payloads
+}
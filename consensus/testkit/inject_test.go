@@ -0,0 +1,137 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testkit
+
+import (
+	"fmt"
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// classifyTest decodes payloads of the form "kind view seqNo"
+func classifyTest(payload []byte) (kind string, view uint64, seqNo uint64, ok bool) {
+	_, err := fmt.Sscanf(string(payload), "%s %d %d", &kind, &view, &seqNo)
+	return kind, view, seqNo, err == nil
+}
+
+func makeClassifiedNetwork(N int) (*Network, []*recordingEndpoint) {
+	net, eps := makeRecordingNetwork(N)
+	net.Classify = classifyTest
+	return net, eps
+}
+
+func received(ep *recordingEndpoint) []string {
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+	var msgs []string
+	for _, payload := range ep.received {
+		msgs = append(msgs, string(payload))
+	}
+	return msgs
+}
+
+func TestInjectDropScoped(t *testing.T) {
+	net, eps := makeClassifiedNetwork(4)
+	defer net.Stop()
+
+	rule := net.Inject().From(0).To(2, 3).Type("commit").Views(0, 1).SeqNos(5, 10).Drop()
+	for _, payload := range []string{"commit 1 5", "commit 2 5", "commit 1 11", "prepare 1 5", "unclassified"} {
+		broadcastFrom(eps[0], payload)
+	}
+	broadcastFrom(eps[1], "commit 1 5")
+	net.Process()
+
+	if eps[1].count() != 5 {
+		t.Errorf("Replica 1 is out of scope and should have received all 5 messages, got %q", received(eps[1]))
+	}
+	if eps[2].count() != 5 || eps[3].count() != 5 {
+		t.Errorf("Replicas 2 and 3 should have received all but the scoped commit, got %q and %q", received(eps[2]), received(eps[3]))
+	}
+	if rule.Matched() != 2 {
+		t.Errorf("Expected the rule to have applied twice, got %d", rule.Matched())
+	}
+
+	rule.Remove()
+	broadcastFrom(eps[0], "commit 1 5")
+	net.Process()
+	if eps[2].count() != 6 {
+		t.Errorf("Expected the message to be delivered once the rule was removed")
+	}
+}
+
+func TestInjectDuplicateTimes(t *testing.T) {
+	net, eps := makeClassifiedNetwork(2)
+	defer net.Stop()
+
+	net.Inject().Duplicate(2).Times(1)
+	broadcastFrom(eps[0], "first")
+	broadcastFrom(eps[0], "second")
+	net.Process()
+
+	if expected := "[first first first second]"; fmt.Sprint(received(eps[1])) != expected {
+		t.Errorf("Expected only the first message to be duplicated, got %q", received(eps[1]))
+	}
+}
+
+func TestInjectReorder(t *testing.T) {
+	net, eps := makeClassifiedNetwork(2)
+	defer net.Stop()
+
+	rule := net.Inject().Type("checkpoint").Reorder()
+	broadcastFrom(eps[0], "checkpoint 0 10")
+	broadcastFrom(eps[0], "commit 0 11")
+	broadcastFrom(eps[0], "checkpoint 0 20")
+	net.Process()
+
+	if expected := "[commit 0 11 checkpoint 0 10]"; fmt.Sprint(received(eps[1])) != expected {
+		t.Errorf("Expected the first checkpoint to be delivered after the next message, got %q", received(eps[1]))
+	}
+	rule.Remove()
+	if eps[1].count() != 3 || received(eps[1])[2] != "checkpoint 0 20" {
+		t.Errorf("Expected the held back checkpoint to be delivered once the rule was removed, got %q", received(eps[1]))
+	}
+}
+
+func TestInjectCorrupt(t *testing.T) {
+	net, eps := makeClassifiedNetwork(3)
+	defer net.Stop()
+
+	net.Inject().To(1).Corrupt(nil)
+	net.Inject().To(2).Corrupt(func(src int, dst int, payload []byte) []byte {
+		return []byte("evil")
+	})
+	eps[0].Unicast(&pb.Message{Payload: []byte("good")}, ValidatorHandle(1))
+	broadcastFrom(eps[0], "good")
+	net.Process()
+
+	for _, msg := range received(eps[1]) {
+		if msg == "good" {
+			t.Errorf("Expected replica 1 to receive corrupted messages only, got %q", received(eps[1]))
+		}
+	}
+	if fmt.Sprint(received(eps[2])) != "[evil]" {
+		t.Errorf("Expected replica 2 to receive the mutated message, got %q", received(eps[2]))
+	}
+
+	net.ClearRules()
+	broadcastFrom(eps[0], "good")
+	net.Process()
+	if received(eps[2])[1] != "good" {
+		t.Errorf("Expected the message to be delivered intact once the rules were cleared")
+	}
+}
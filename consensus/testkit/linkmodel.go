@@ -0,0 +1,134 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testkit
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Distribution draws the latency of a message from r
+type Distribution func(r *rand.Rand) time.Duration
+
+// Constant is the distribution of a fixed latency
+func Constant(latency time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		return latency
+	}
+}
+
+// Uniform is the distribution of latencies spread evenly between lo and hi
+func Uniform(lo, hi time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		if hi <= lo {
+			return lo
+		}
+		return lo + time.Duration(r.Int63n(int64(hi-lo)))
+	}
+}
+
+// Normal is the distribution of latencies around mean, deviating from it by
+// jitter, which is the standard deviation. Latencies are never negative
+func Normal(mean, jitter time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		latency := mean + time.Duration(r.NormFloat64()*float64(jitter))
+		if latency < 0 {
+			return 0
+		}
+		return latency
+	}
+}
+
+// LinkModel describes the conditions of a link. The delivery of a message
+// waits for the messages sent on the link before it to be transmitted, for
+// its own transmission at the bandwidth of the link, and for a latency drawn
+// from the distribution of the link
+type LinkModel struct {
+	Latency   Distribution // latency of each message, none if nil
+	Bandwidth int          // bytes per second the link transmits, 0 if unlimited
+}
+
+// linkState is the model of a link, along with the time its transmission of
+// the messages sent so far completes
+type linkState struct {
+	model     LinkModel
+	busyUntil time.Time
+}
+
+// SetNetworkModel applies model to every link which has no model of its own
+func (net *Network) SetNetworkModel(model LinkModel) {
+	net.faults.lock.Lock()
+	defer net.faults.lock.Unlock()
+	net.faults.defaultModel = model
+	net.faults.defaultLinks = nil
+}
+
+// SetLinkModel applies model to the link from src to dst, overriding the
+// model of the network
+func (net *Network) SetLinkModel(src, dst int, model LinkModel) {
+	net.faults.lock.Lock()
+	defer net.faults.lock.Unlock()
+	if net.faults.links == nil {
+		net.faults.links = make(map[link]*linkState)
+	}
+	net.faults.links[link{src, dst}] = &linkState{model: model}
+}
+
+// SetModelSeed seeds the source the latencies are drawn from, so that the
+// delays of a test can be reproduced. The source is seeded with 1 by default
+func (net *Network) SetModelSeed(seed int64) {
+	net.faults.lock.Lock()
+	defer net.faults.lock.Unlock()
+	net.faults.rand = rand.New(rand.NewSource(seed))
+}
+
+// linkDelay returns how long to wait before delivering a message of size
+// bytes from src to dst, and accounts for its transmission on the link. It
+// must be called with the lock held
+func (f *faults) linkDelay(src, dst int, size int, now time.Time) time.Duration {
+	l := link{src, dst}
+	state, ok := f.links[l]
+	if !ok {
+		if f.defaultModel.Latency == nil && f.defaultModel.Bandwidth == 0 {
+			return 0
+		}
+		if f.defaultLinks == nil {
+			f.defaultLinks = make(map[link]*linkState)
+		}
+		if state, ok = f.defaultLinks[l]; !ok {
+			state = &linkState{model: f.defaultModel}
+			f.defaultLinks[l] = state
+		}
+	}
+
+	var delay time.Duration
+	if state.model.Bandwidth > 0 {
+		start := now
+		if state.busyUntil.After(now) {
+			start = state.busyUntil
+		}
+		state.busyUntil = start.Add(time.Duration(size) * time.Second / time.Duration(state.model.Bandwidth))
+		delay = state.busyUntil.Sub(now)
+	}
+	if state.model.Latency != nil {
+		if f.rand == nil {
+			f.rand = rand.New(rand.NewSource(1))
+		}
+		delay += state.model.Latency(f.rand)
+	}
+	return delay
+}
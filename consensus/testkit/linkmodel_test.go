@@ -0,0 +1,100 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testkit
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDistributions(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		if d := Uniform(10*time.Millisecond, 20*time.Millisecond)(r); d < 10*time.Millisecond || d >= 20*time.Millisecond {
+			t.Fatalf("Uniform latency %v out of bounds", d)
+		}
+		if d := Normal(time.Millisecond, 10*time.Millisecond)(r); d < 0 {
+			t.Fatalf("Normal latency %v is negative", d)
+		}
+	}
+	if d := Constant(time.Second)(r); d != time.Second {
+		t.Errorf("Expected a constant latency of 1s, got %v", d)
+	}
+}
+
+func TestModelSeedReproducible(t *testing.T) {
+	draw := func() []time.Duration {
+		net, _ := makeRecordingNetwork(2)
+		defer net.Stop()
+		net.SetNetworkModel(LinkModel{Latency: Normal(time.Second, 100*time.Millisecond)})
+		net.SetModelSeed(42)
+		var delays []time.Duration
+		for i := 0; i < 10; i++ {
+			delays = append(delays, net.faults.linkDelay(0, 1, 10, time.Now()))
+		}
+		return delays
+	}
+	first, second := draw(), draw()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Expected the same seed to draw the same latencies, got %v and %v", first, second)
+		}
+	}
+}
+
+func TestLinkBandwidth(t *testing.T) {
+	net, _ := makeRecordingNetwork(3)
+	defer net.Stop()
+	net.SetNetworkModel(LinkModel{Bandwidth: 1000})
+	net.SetLinkModel(0, 2, LinkModel{Latency: Constant(time.Second)})
+
+	now := time.Now()
+	if d := net.faults.linkDelay(0, 1, 100, now); d != 100*time.Millisecond {
+		t.Errorf("Expected 100 bytes to take 100ms at 1000 bytes per second, took %v", d)
+	}
+	if d := net.faults.linkDelay(0, 1, 100, now); d != 200*time.Millisecond {
+		t.Errorf("Expected the second message to wait for the first, took %v", d)
+	}
+	if d := net.faults.linkDelay(1, 0, 100, now); d != 100*time.Millisecond {
+		t.Errorf("Expected the reverse link to be idle, took %v", d)
+	}
+	if d := net.faults.linkDelay(0, 1, 100, now.Add(time.Second)); d != 100*time.Millisecond {
+		t.Errorf("Expected the link to be idle once it transmitted the earlier messages, took %v", d)
+	}
+	if d := net.faults.linkDelay(0, 2, 100, now); d != time.Second {
+		t.Errorf("Expected the model of the link to override the network model, took %v", d)
+	}
+}
+
+func TestLinkModelDelivery(t *testing.T) {
+	net, eps := makeRecordingNetwork(2)
+	defer net.Stop()
+	net.SetLinkModel(0, 1, LinkModel{Latency: Uniform(10*time.Millisecond, 20*time.Millisecond), Bandwidth: 10000})
+
+	start := time.Now()
+	broadcastFrom(eps[0], strings.Repeat("x", 500))
+	net.Process()
+
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("Message was delivered after %v, expected at least 50ms to transmit and 10ms of latency", elapsed)
+	}
+	if eps[1].count() != 1 {
+		t.Errorf("Replica 1 received %d messages, expected 1", eps[1].count())
+	}
+}
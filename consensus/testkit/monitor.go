@@ -0,0 +1,225 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testkit
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ReplicaState is the part of the state of a replica the monitors check
+type ReplicaState struct {
+	View         uint64
+	LowWatermark uint64
+	LastExec     uint64
+	Epoch        uint64 // sequence numbers start over in every epoch
+}
+
+// Monitor observes the network while it runs, and checks invariants as
+// messages are delivered and replicas execute or change state. A monitor
+// returns an error at the first violation, which is reported to the
+// Violation function of the network. Monitors are invoked from the
+// delivery goroutines and the event threads of the replicas, so they must
+// be safe for concurrent use
+type Monitor interface {
+	Delivered(src int, dst int, payload []byte) error        // a message was handed to dst
+	Executed(replica int, seqNo uint64, digest string) error // replica executed the request with digest at seqNo
+	Observed(replica int, state ReplicaState) error          // replica reported its state
+	Restarted(replica int)                                   // replica was replaced, its state may start over
+}
+
+// NopMonitor implements every method of Monitor without checking anything,
+// it is intended to be embedded by monitors which only check some events
+type NopMonitor struct{}
+
+// Delivered implements Monitor
+func (NopMonitor) Delivered(src int, dst int, payload []byte) error { return nil }
+
+// Executed implements Monitor
+func (NopMonitor) Executed(replica int, seqNo uint64, digest string) error { return nil }
+
+// Observed implements Monitor
+func (NopMonitor) Observed(replica int, state ReplicaState) error { return nil }
+
+// Restarted implements Monitor
+func (NopMonitor) Restarted(replica int) {}
+
+// monitors holds the monitors attached to the network
+type monitors struct {
+	lock     sync.RWMutex
+	attached []Monitor
+}
+
+func (m *monitors) list() []Monitor {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.attached
+}
+
+// Attach adds monitors to the network, they observe every message delivered
+// and every execution and state reported from then on
+func (net *Network) Attach(monitors ...Monitor) {
+	net.monitors.lock.Lock()
+	defer net.monitors.lock.Unlock()
+	net.monitors.attached = append(append([]Monitor(nil), net.monitors.attached...), monitors...)
+}
+
+// violated reports a violated invariant to the Violation function of the
+// network, or panics if there is none, so that the test fails with the
+// stack of the replica which violated it
+func (net *Network) violated(err error) {
+	if net.Violation != nil {
+		net.Violation(err)
+		return
+	}
+	panic(fmt.Sprintf("Invariant violated: %s", err))
+}
+
+// delivered passes a message handed to dst to the monitors
+func (net *Network) delivered(src int, dst int, payload []byte) {
+	for _, m := range net.monitors.list() {
+		if err := m.Delivered(src, dst, payload); err != nil {
+			net.violated(err)
+		}
+	}
+}
+
+// ReportExecution passes the execution of the request with digest at seqNo
+// by replica to the monitors, it is intended to be called by endpoints
+func (net *Network) ReportExecution(replica int, seqNo uint64, digest string) {
+	for _, m := range net.monitors.list() {
+		if err := m.Executed(replica, seqNo, digest); err != nil {
+			net.violated(err)
+		}
+	}
+}
+
+// ReportState passes the state of replica to the monitors, it is intended
+// to be called by endpoints whenever their state may have changed
+func (net *Network) ReportState(replica int, state ReplicaState) {
+	for _, m := range net.monitors.list() {
+		if err := m.Observed(replica, state); err != nil {
+			net.violated(err)
+		}
+	}
+}
+
+// execution identifies a sequence number within an epoch
+type execution struct {
+	epoch uint64
+	seqNo uint64
+}
+
+// agreementMonitor checks that no two replicas execute different requests
+// at the same sequence number of the same epoch
+type agreementMonitor struct {
+	NopMonitor
+	lock     sync.Mutex
+	executed map[execution]string // first execution -> digest
+	executor map[execution]int    // first execution -> replica
+	epochs   map[int]uint64       // replica -> last reported epoch
+}
+
+// NewAgreementMonitor returns a monitor checking that all replicas execute
+// the same request at each sequence number, the epoch of an execution is
+// the one the replica last reported in its state
+func NewAgreementMonitor() Monitor {
+	return &agreementMonitor{
+		executed: make(map[execution]string),
+		executor: make(map[execution]int),
+		epochs:   make(map[int]uint64),
+	}
+}
+
+func (am *agreementMonitor) Executed(replica int, seqNo uint64, digest string) error {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	exec := execution{am.epochs[replica], seqNo}
+	first, ok := am.executed[exec]
+	if !ok {
+		am.executed[exec] = digest
+		am.executor[exec] = replica
+		return nil
+	}
+	if first != digest {
+		return fmt.Errorf("replica %d executed %s at seqNo %d of epoch %d, but replica %d executed %s", replica, digest, seqNo, exec.epoch, am.executor[exec], first)
+	}
+	return nil
+}
+
+func (am *agreementMonitor) Observed(replica int, state ReplicaState) error {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	am.epochs[replica] = state.Epoch
+	return nil
+}
+
+func (am *agreementMonitor) Restarted(replica int) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	delete(am.epochs, replica)
+}
+
+// progressMonitor checks that the view, epoch, low watermark and last
+// execution of every replica never decrease, the low watermark and last
+// execution start over when the replica enters a later epoch
+type progressMonitor struct {
+	NopMonitor
+	lock  sync.Mutex
+	state map[int]ReplicaState // replica -> last reported state
+}
+
+// NewProgressMonitor returns a monitor checking that replicas never move
+// back to an earlier view, epoch, low watermark or execution
+func NewProgressMonitor() Monitor {
+	return &progressMonitor{state: make(map[int]ReplicaState)}
+}
+
+func (pm *progressMonitor) Observed(replica int, state ReplicaState) error {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	last, ok := pm.state[replica]
+	pm.state[replica] = state
+	if !ok {
+		return nil
+	}
+	switch {
+	case state.View < last.View:
+		return fmt.Errorf("replica %d moved back from view %d to %d", replica, last.View, state.View)
+	case state.Epoch < last.Epoch:
+		return fmt.Errorf("replica %d moved back from epoch %d to %d", replica, last.Epoch, state.Epoch)
+	case state.Epoch > last.Epoch:
+		return nil
+	case state.LowWatermark < last.LowWatermark:
+		return fmt.Errorf("replica %d moved its low watermark back from %d to %d", replica, last.LowWatermark, state.LowWatermark)
+	case state.LastExec < last.LastExec:
+		return fmt.Errorf("replica %d moved back from executing %d to %d", replica, last.LastExec, state.LastExec)
+	}
+	return nil
+}
+
+func (pm *progressMonitor) Restarted(replica int) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	delete(pm.state, replica)
+}
+
+// SafetyMonitors returns the monitors for the invariants every correct
+// replica must maintain
+func SafetyMonitors() []Monitor {
+	return []Monitor{NewAgreementMonitor(), NewProgressMonitor()}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testkit
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// deliveryMonitor counts the messages delivered to each replica
+type deliveryMonitor struct {
+	NopMonitor
+	lock      sync.Mutex
+	delivered map[int]int
+}
+
+func (dm *deliveryMonitor) Delivered(src int, dst int, payload []byte) error {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	dm.delivered[dst]++
+	if string(payload) == "forbidden" {
+		return fmt.Errorf("replica %d sent a forbidden message to %d", src, dst)
+	}
+	return nil
+}
+
+func makeMonitoredNetwork(N int, monitors ...Monitor) (*Network, []*recordingEndpoint, *[]error) {
+	net, eps := makeRecordingNetwork(N)
+	violations := &[]error{}
+	lock := &sync.Mutex{}
+	net.Violation = func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		*violations = append(*violations, err)
+	}
+	net.Attach(monitors...)
+	return net, eps, violations
+}
+
+func TestMonitorObservesDelivery(t *testing.T) {
+	dm := &deliveryMonitor{delivered: make(map[int]int)}
+	net, eps, violations := makeMonitoredNetwork(3, dm)
+	defer net.Stop()
+
+	broadcastFrom(eps[0], "allowed")
+	net.Process()
+	if dm.delivered[1] != 1 || dm.delivered[2] != 1 || dm.delivered[0] != 0 {
+		t.Errorf("Expected the monitor to observe the broadcast to replicas 1 and 2, observed %v", dm.delivered)
+	}
+	if len(*violations) != 0 {
+		t.Errorf("Expected no violations, got %v", *violations)
+	}
+
+	broadcastFrom(eps[0], "forbidden")
+	net.Process()
+	if len(*violations) != 2 {
+		t.Errorf("Expected a violation for each delivery of the forbidden message, got %v", *violations)
+	}
+}
+
+func TestAgreementMonitor(t *testing.T) {
+	net, _, violations := makeMonitoredNetwork(3, NewAgreementMonitor())
+	defer net.Stop()
+
+	net.ReportExecution(0, 1, "a")
+	net.ReportExecution(1, 1, "a")
+	net.ReportExecution(1, 2, "b")
+	if len(*violations) != 0 {
+		t.Fatalf("Expected matching executions to be accepted, got %v", *violations)
+	}
+	net.ReportExecution(2, 1, "c")
+	if len(*violations) != 1 {
+		t.Fatalf("Expected the diverging execution to be reported, got %v", *violations)
+	}
+}
+
+func TestAgreementMonitorEpochs(t *testing.T) {
+	net, _, violations := makeMonitoredNetwork(2, NewAgreementMonitor())
+	defer net.Stop()
+
+	net.ReportExecution(0, 1, "a")
+	net.ReportState(0, ReplicaState{Epoch: 1})
+	net.ReportExecution(0, 1, "b")
+	if len(*violations) != 0 {
+		t.Fatalf("Expected a sequence number of a later epoch to be executed anew, got %v", *violations)
+	}
+	net.ReportExecution(1, 1, "a")
+	net.ReportState(1, ReplicaState{Epoch: 1})
+	net.ReportExecution(1, 1, "c")
+	if len(*violations) != 1 {
+		t.Fatalf("Expected the diverging execution in the later epoch to be reported, got %v", *violations)
+	}
+}
+
+func TestProgressMonitor(t *testing.T) {
+	net, _, violations := makeMonitoredNetwork(2, NewProgressMonitor())
+	defer net.Stop()
+
+	net.ReportState(0, ReplicaState{View: 1, LowWatermark: 10, LastExec: 12})
+	net.ReportState(1, ReplicaState{View: 0, LowWatermark: 0, LastExec: 3})
+	net.ReportState(0, ReplicaState{View: 2, LowWatermark: 10, LastExec: 12})
+	if len(*violations) != 0 {
+		t.Fatalf("Expected progress to be accepted, got %v", *violations)
+	}
+
+	net.ReportState(0, ReplicaState{View: 1, LowWatermark: 10, LastExec: 12})
+	net.ReportState(0, ReplicaState{View: 1, LowWatermark: 0, LastExec: 12})
+	net.ReportState(0, ReplicaState{View: 1, LowWatermark: 0, LastExec: 2})
+	if len(*violations) != 3 {
+		t.Fatalf("Expected each regression to be reported, got %v", *violations)
+	}
+
+	net.Crash(1)
+	net.Restart(1, net.Endpoints[1])
+	net.ReportState(1, ReplicaState{})
+	if len(*violations) != 3 {
+		t.Errorf("Expected a restarted replica to start over, got %v", (*violations)[3:])
+	}
+
+	net.ReportState(1, ReplicaState{View: 1, LowWatermark: 0, LastExec: 0, Epoch: 1})
+	if len(*violations) != 3 {
+		t.Fatalf("Expected a later epoch to start the sequence numbers over, got %v", (*violations)[3:])
+	}
+	net.ReportState(1, ReplicaState{View: 1, LowWatermark: 10, LastExec: 12})
+	if len(*violations) != 4 {
+		t.Errorf("Expected moving back to an earlier epoch to be reported, got %v", (*violations)[3:])
+	}
+}
+
+func TestMonitorPanicsWithoutViolation(t *testing.T) {
+	net, _ := makeRecordingNetwork(2)
+	defer net.Stop()
+	net.Attach(SafetyMonitors()...)
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a violation to panic when the network has no Violation function")
+		}
+	}()
+	net.ReportExecution(0, 1, "a")
+	net.ReportExecution(1, 1, "b")
+}
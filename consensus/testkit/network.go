@@ -0,0 +1,369 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testkit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Endpoint is a replica attached to the test network
+type Endpoint interface {
+	Stop()
+	Deliver([]byte, *pb.PeerID)
+	GetHandle() *pb.PeerID
+	GetID() uint64
+	IsBusy() bool
+}
+
+// TaggedMsg is a message in flight on the test network, a Dst of -1 indicates a broadcast
+type TaggedMsg struct {
+	Src int
+	Dst int
+	Msg []byte
+}
+
+// FilterFunc is invoked for every message on the network, it may return
+// a modified payload, or nil to drop the message. A dst of -1 indicates
+// that the message is being queued for broadcast
+type FilterFunc func(src int, dst int, payload []byte) []byte
+
+// Network is an in-process network connecting a set of endpoints
+type Network struct {
+	Debug     bool
+	N         int
+	Endpoints []Endpoint
+	Msgs      chan TaggedMsg
+	FilterFn  FilterFunc
+	Classify  Classifier      // decodes the payloads for the rules scoped by type, view or sequence number
+	Violation func(err error) // invoked when an attached monitor detects a violation, nil panics
+
+	closed   chan struct{}
+	faults   faults
+	monitors monitors
+}
+
+// NetworkEndpoint implements the network facing portion of the consensus
+// stack (consensus.NetworkStack) on top of a Network, it is intended to be
+// embedded by Endpoint implementations
+type NetworkEndpoint struct {
+	ID  uint64
+	Net *Network
+}
+
+// NewNetworkEndpoint creates a NetworkEndpoint for replica id attached to net
+func NewNetworkEndpoint(id uint64, net *Network) *NetworkEndpoint {
+	return &NetworkEndpoint{
+		ID:  id,
+		Net: net,
+	}
+}
+
+// GetID returns the replica id of this endpoint
+func (ep *NetworkEndpoint) GetID() uint64 {
+	return ep.ID
+}
+
+// GetHandle returns the peer handle of this endpoint
+func (ep *NetworkEndpoint) GetHandle() *pb.PeerID {
+	return ValidatorHandle(ep.ID)
+}
+
+// GetNetworkInfo returns the endpoints of this replica and of the whole network
+func (ep *NetworkEndpoint) GetNetworkInfo() (self *pb.PeerEndpoint, network []*pb.PeerEndpoint, err error) {
+	oSelf, oNetwork, _ := ep.GetNetworkHandles()
+	self = &pb.PeerEndpoint{
+		ID:   oSelf,
+		Type: pb.PeerEndpoint_VALIDATOR,
+	}
+
+	network = make([]*pb.PeerEndpoint, len(oNetwork))
+	for i, id := range oNetwork {
+		network[i] = &pb.PeerEndpoint{
+			ID:   id,
+			Type: pb.PeerEndpoint_VALIDATOR,
+		}
+	}
+	return
+}
+
+// GetNetworkHandles returns the handles of this replica and of the whole network
+func (ep *NetworkEndpoint) GetNetworkHandles() (self *pb.PeerID, network []*pb.PeerID, err error) {
+	if nil == ep.Net {
+		err = fmt.Errorf("Network not initialized")
+		return
+	}
+	self = ep.GetHandle()
+	network = make([]*pb.PeerID, len(ep.Net.Endpoints))
+	for i, oep := range ep.Net.Endpoints {
+		if nil != oep {
+			// In case this is invoked before all endpoints are initialized, this emulates a real network as well
+			network[i] = oep.GetHandle()
+		}
+	}
+	return
+}
+
+// Broadcast delivers to all endpoints.  In contrast to the stack
+// Broadcast, this will also deliver back to the replica.  We keep
+// this behavior, because it exposes subtle bugs in the
+// implementation.
+func (ep *NetworkEndpoint) Broadcast(msg *pb.Message, peerType pb.PeerEndpoint_Type) error {
+	ep.Net.broadcastFilter(ep, msg.Payload)
+	return nil
+}
+
+// Unicast queues a message for delivery to a single endpoint
+func (ep *NetworkEndpoint) Unicast(msg *pb.Message, receiverHandle *pb.PeerID) error {
+	receiverID, err := ValidatorID(receiverHandle)
+	if err != nil {
+		return fmt.Errorf("Couldn't unicast message to %s: %v", receiverHandle.Name, err)
+	}
+	ep.Net.QueueMessage(TaggedMsg{int(ep.ID), int(receiverID), msg.Payload})
+	return nil
+}
+
+// ValidatorHandle returns the peer handle of the replica with the given id
+func ValidatorHandle(id uint64) *pb.PeerID {
+	return &pb.PeerID{Name: "vp" + strconv.FormatUint(id, 10)}
+}
+
+// ValidatorID returns the replica id of the given peer handle
+func ValidatorID(handle *pb.PeerID) (uint64, error) {
+	if !strings.HasPrefix(handle.Name, "vp") {
+		return 0, fmt.Errorf("Handle \"%s\" is not of the form vpX", handle.Name)
+	}
+	id, err := strconv.ParseUint(handle.Name[2:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Error extracting ID from \"%s\" handle: %v", handle.Name, err)
+	}
+	return id, nil
+}
+
+// NewNetwork creates a network of N endpoints, created by initFn
+func NewNetwork(N int, initFn func(id uint64, network *Network) Endpoint) *Network {
+	net := &Network{}
+	net.N = N
+	net.Msgs = make(chan TaggedMsg, 100)
+	net.closed = make(chan struct{})
+	net.Endpoints = make([]Endpoint, N)
+
+	for i := range net.Endpoints {
+		net.Endpoints[i] = initFn(uint64(i), net)
+	}
+
+	return net
+}
+
+// QueueMessage places a message on the network, blocking if the queue is full
+func (net *Network) QueueMessage(tm TaggedMsg) {
+	select {
+	case net.Msgs <- tm:
+	default:
+		fmt.Println("TEST NET: Message cannot be queued without blocking, consider increasing the queue size")
+		net.Msgs <- tm
+	}
+}
+
+// DebugMsg prints the message when the network is in debug mode
+func (net *Network) DebugMsg(msg string, args ...interface{}) {
+	if net.Debug {
+		fmt.Printf(msg, args...)
+	}
+}
+
+func (net *Network) broadcastFilter(ep *NetworkEndpoint, payload []byte) {
+	select {
+	case <-net.closed:
+		fmt.Println("WARNING! Attempted to send a request to a closed network, ignoring")
+		return
+	default:
+	}
+	if net.faults.isCrashed(int(ep.ID)) {
+		net.DebugMsg("TEST: suppressing broadcast from crashed replica %d\n", ep.ID)
+		return
+	}
+	if net.FilterFn != nil {
+		payload = net.FilterFn(int(ep.ID), -1, payload)
+		net.DebugMsg("TEST: filtered message\n")
+	}
+	if payload != nil {
+		net.DebugMsg("TEST: attempting to queue message %p\n", payload)
+		net.QueueMessage(TaggedMsg{int(ep.ID), -1, payload})
+		net.DebugMsg("TEST: message queued successfully %p\n", payload)
+	} else {
+		net.DebugMsg("TEST: suppressing message with payload %p\n", payload)
+	}
+}
+
+// filter applies the injected faults, the FilterFn and the rules to a
+// message from src to dst, and returns the payloads to deliver in its place
+func (net *Network) filter(src int, dst int, payload []byte) [][]byte {
+	payload = net.faults.apply(src, dst, payload)
+	if payload != nil && net.FilterFn != nil {
+		payload = net.FilterFn(src, dst, payload)
+	}
+	if payload == nil {
+		return nil
+	}
+	return net.faults.inject(src, dst, payload)
+}
+
+func (net *Network) deliverFilter(msg TaggedMsg) {
+	net.DebugMsg("TEST: deliver\n")
+	senderHandle := net.Endpoints[msg.Src].GetHandle()
+	if msg.Dst == -1 {
+		net.DebugMsg("TEST: Sending broadcast %v\n", net.Endpoints)
+		wg := &sync.WaitGroup{}
+		wg.Add(len(net.Endpoints))
+		for id, ep := range net.Endpoints {
+			net.DebugMsg("TEST: Looping broadcast %d\n", ep.GetID())
+			lid := id
+			lep := ep
+			go func() {
+				defer wg.Done()
+				if msg.Src == lid {
+					net.DebugMsg("TEST: Skipping local delivery %d %d\n", lid, msg.Src)
+					// do not deliver to local replica
+					return
+				}
+				net.DebugMsg("TEST: Filtering %d\n", lid)
+				payloads := net.filter(msg.Src, lid, msg.Msg)
+				net.DebugMsg("TEST: Delivering %d\n", lid)
+				for _, payload := range payloads {
+					net.faults.delay(msg.Src, lid, len(payload))
+					net.DebugMsg("TEST: Sending message %d\n", lid)
+					lep.Deliver(payload, senderHandle)
+					net.delivered(msg.Src, lid, payload)
+					net.DebugMsg("TEST: Sent message %d\n", lid)
+				}
+			}()
+		}
+		wg.Wait()
+	} else {
+		net.DebugMsg("TEST: Filtering %d\n", msg.Dst)
+		for _, payload := range net.filter(msg.Src, msg.Dst, msg.Msg) {
+			net.faults.delay(msg.Src, msg.Dst, len(payload))
+			net.DebugMsg("TEST: Sending unicast\n")
+			net.Endpoints[msg.Dst].Deliver(payload, senderHandle)
+			net.delivered(msg.Src, msg.Dst, payload)
+		}
+	}
+}
+
+func (net *Network) processMessageFromChannel(msg TaggedMsg, ok bool) bool {
+	if !ok {
+		net.DebugMsg("TEST: message channel closed, exiting\n")
+		return false
+	}
+	net.DebugMsg("TEST: new message, delivering\n")
+	net.deliverFilter(msg)
+	return true
+}
+
+// Process delivers messages until the network is idle, that is until there
+// are no more messages queued and none of the endpoints reports busy
+func (net *Network) Process() error {
+	retry := true
+	countdown := time.After(60 * time.Second)
+	for {
+		net.DebugMsg("TEST: process looping\n")
+		select {
+		case msg, ok := <-net.Msgs:
+			retry = true
+			net.DebugMsg("TEST: processing message without testing for idle\n")
+			if !net.processMessageFromChannel(msg, ok) {
+				return nil
+			}
+		case <-net.closed:
+			return nil
+		case <-countdown:
+			panic("Test network took more than 60 seconds to resolve requests, this usually indicates a hang")
+		default:
+			if !retry {
+				return nil
+			}
+
+			var busy []int
+			for i, ep := range net.Endpoints {
+				if net.faults.isCrashed(i) {
+					continue
+				}
+				if ep.IsBusy() {
+					busy = append(busy, i)
+				}
+			}
+			if len(busy) == 0 {
+				retry = false
+				continue
+			}
+
+			net.DebugMsg("TEST: some replicas are busy, waiting: %v\n", busy)
+			select {
+			case msg, ok := <-net.Msgs:
+				retry = true
+				if !net.processMessageFromChannel(msg, ok) {
+					return nil
+				}
+				continue
+			case <-time.After(100 * time.Millisecond):
+				continue
+			}
+		}
+	}
+}
+
+// ProcessContinually delivers messages until the network is stopped
+func (net *Network) ProcessContinually() {
+	for {
+		select {
+		case msg, ok := <-net.Msgs:
+			if !net.processMessageFromChannel(msg, ok) {
+				return
+			}
+		case <-net.closed:
+			return
+		}
+	}
+}
+
+// ClearMessages drops all queued messages
+func (net *Network) ClearMessages() {
+	for {
+		select {
+		case <-net.Msgs:
+		default:
+			return
+		}
+	}
+}
+
+// Stop closes the network and stops all endpoints which have not crashed
+func (net *Network) Stop() {
+	close(net.closed)
+	for i, ep := range net.Endpoints {
+		if net.faults.isCrashed(i) {
+			continue
+		}
+		ep.Stop()
+	}
+}
@@ -0,0 +1,245 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testkit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type recordingEndpoint struct {
+	*NetworkEndpoint
+	lock     sync.Mutex
+	received [][]byte
+	stopped  bool
+}
+
+func (re *recordingEndpoint) Deliver(payload []byte, sender *pb.PeerID) {
+	re.lock.Lock()
+	defer re.lock.Unlock()
+	re.received = append(re.received, payload)
+}
+
+func (re *recordingEndpoint) Stop() {
+	re.stopped = true
+}
+
+func (re *recordingEndpoint) IsBusy() bool {
+	return false
+}
+
+func (re *recordingEndpoint) count() int {
+	re.lock.Lock()
+	defer re.lock.Unlock()
+	return len(re.received)
+}
+
+func makeRecordingNetwork(N int) (*Network, []*recordingEndpoint) {
+	eps := make([]*recordingEndpoint, N)
+	net := NewNetwork(N, func(id uint64, net *Network) Endpoint {
+		eps[id] = &recordingEndpoint{NetworkEndpoint: NewNetworkEndpoint(id, net)}
+		return eps[id]
+	})
+	return net, eps
+}
+
+func broadcastFrom(ep *recordingEndpoint, payload string) {
+	ep.Broadcast(&pb.Message{Payload: []byte(payload)}, pb.PeerEndpoint_VALIDATOR)
+}
+
+func TestBroadcastSkipsSender(t *testing.T) {
+	net, eps := makeRecordingNetwork(4)
+	defer net.Stop()
+
+	broadcastFrom(eps[0], "hello")
+	net.Process()
+
+	if eps[0].count() != 0 {
+		t.Errorf("Sender should not receive its own broadcast")
+	}
+	for _, ep := range eps[1:] {
+		if ep.count() != 1 {
+			t.Errorf("Replica %d received %d messages, expected 1", ep.ID, ep.count())
+		}
+	}
+}
+
+func TestUnicast(t *testing.T) {
+	net, eps := makeRecordingNetwork(4)
+	defer net.Stop()
+
+	eps[0].Unicast(&pb.Message{Payload: []byte("hello")}, ValidatorHandle(2))
+	net.Process()
+
+	for i, ep := range eps {
+		expected := 0
+		if i == 2 {
+			expected = 1
+		}
+		if ep.count() != expected {
+			t.Errorf("Replica %d received %d messages, expected %d", i, ep.count(), expected)
+		}
+	}
+}
+
+func TestPartitionAndHeal(t *testing.T) {
+	net, eps := makeRecordingNetwork(4)
+	defer net.Stop()
+
+	net.Partition([]int{0, 1}, []int{2})
+	broadcastFrom(eps[0], "partitioned")
+	broadcastFrom(eps[2], "partitioned")
+	net.Process()
+
+	expected := []int{0, 1, 0, 0}
+	for i, ep := range eps {
+		if ep.count() != expected[i] {
+			t.Errorf("Replica %d received %d messages while partitioned, expected %d", i, ep.count(), expected[i])
+		}
+	}
+
+	net.Heal()
+	broadcastFrom(eps[0], "healed")
+	net.Process()
+
+	expected = []int{0, 2, 1, 1}
+	for i, ep := range eps {
+		if ep.count() != expected[i] {
+			t.Errorf("Replica %d received %d messages after healing, expected %d", i, ep.count(), expected[i])
+		}
+	}
+}
+
+func TestCrashAndRestart(t *testing.T) {
+	net, eps := makeRecordingNetwork(4)
+	defer net.Stop()
+
+	net.Crash(3)
+	if !eps[3].stopped {
+		t.Fatalf("Crashed replica should have been stopped")
+	}
+	broadcastFrom(eps[0], "crashed")
+	broadcastFrom(eps[3], "crashed")
+	net.Process()
+
+	if eps[3].count() != 0 {
+		t.Errorf("Crashed replica should not receive messages")
+	}
+	if eps[1].count() != 1 {
+		t.Errorf("Replica 1 received %d messages, expected only the one from replica 0", eps[1].count())
+	}
+
+	restarted := &recordingEndpoint{NetworkEndpoint: NewNetworkEndpoint(3, net)}
+	net.Restart(3, restarted)
+	broadcastFrom(eps[0], "restarted")
+	net.Process()
+
+	if restarted.count() != 1 {
+		t.Errorf("Restarted replica received %d messages, expected 1", restarted.count())
+	}
+}
+
+func TestMutate(t *testing.T) {
+	net, eps := makeRecordingNetwork(4)
+	defer net.Stop()
+
+	net.Mutate(1, func(src int, dst int, payload []byte) []byte {
+		if dst == 2 {
+			return nil
+		}
+		return []byte("evil")
+	})
+	broadcastFrom(eps[1], "good")
+	broadcastFrom(eps[0], "good")
+	net.Process()
+
+	if eps[2].count() != 1 || string(eps[2].received[0]) != "good" {
+		t.Errorf("Replica 2 should only have received the message from replica 0, got %q", eps[2].received)
+	}
+	if eps[3].count() != 2 || string(eps[3].received[0]) != "evil" {
+		t.Errorf("Replica 3 should have received the mutated message first, got %q", eps[3].received)
+	}
+
+	net.ClearMutators()
+	broadcastFrom(eps[1], "good")
+	net.Process()
+
+	if string(eps[2].received[1]) != "good" {
+		t.Errorf("Replica 2 should have received an unmutated message, got %q", eps[2].received[1])
+	}
+}
+
+func TestLinkLatency(t *testing.T) {
+	net, eps := makeRecordingNetwork(2)
+	defer net.Stop()
+
+	latency := 50 * time.Millisecond
+	net.SetLinkLatency(0, 1, latency)
+	start := time.Now()
+	broadcastFrom(eps[0], "slow")
+	net.Process()
+
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Errorf("Message was delivered after %v, expected at least %v", elapsed, latency)
+	}
+	if eps[1].count() != 1 {
+		t.Errorf("Replica 1 received %d messages, expected 1", eps[1].count())
+	}
+}
+
+func TestSplitBufferAndRejoin(t *testing.T) {
+	net, eps := makeRecordingNetwork(5)
+	defer net.Stop()
+
+	net.SetPartitionPolicy(BufferAcross)
+	net.Split(map[string][]int{"majority": {0, 1, 2}, "minority": {3}, "isolated": {4}})
+	broadcastFrom(eps[0], "first")
+	broadcastFrom(eps[3], "second")
+	net.Process()
+
+	expected := []int{0, 1, 1, 0, 0}
+	for i, ep := range eps {
+		if ep.count() != expected[i] {
+			t.Errorf("Replica %d received %d messages while split, expected %d", i, ep.count(), expected[i])
+		}
+	}
+	if net.Buffered() != 6 {
+		t.Errorf("Expected the 6 messages across partitions to be buffered, got %d", net.Buffered())
+	}
+
+	net.Rejoin("majority", "minority")
+	if eps[3].count() != 1 || string(eps[3].received[0]) != "first" {
+		t.Errorf("Expected replica 3 to receive the buffered message once rejoined, got %q", eps[3].received)
+	}
+	if eps[1].count() != 2 || string(eps[1].received[1]) != "second" {
+		t.Errorf("Expected replica 1 to receive the buffered message after the one sent within its partition, got %q", eps[1].received)
+	}
+	if eps[4].count() != 0 || net.Buffered() != 2 {
+		t.Errorf("Expected the messages to the isolated replica to stay buffered, %d buffered", net.Buffered())
+	}
+
+	net.Heal()
+	if eps[4].count() != 2 || string(eps[4].received[0]) != "first" {
+		t.Errorf("Expected the isolated replica to receive the buffered messages in order once healed, got %q", eps[4].received)
+	}
+	if net.Buffered() != 0 {
+		t.Errorf("Expected no messages to stay buffered once healed")
+	}
+}
@@ -21,7 +21,9 @@ import (
 	"io"
 	"os"
 	"path"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -44,6 +46,11 @@ var columnfamilies = []string{
 	persistCF,    // persistent per-peer state (consensus)
 }
 
+// DefaultChainID identifies the chain stored in the database returned by GetDBHandle
+const DefaultChainID = ""
+
+var validChainID = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
 // OpenchainDB encapsulates rocksdb's structures
 type OpenchainDB struct {
 	DB           *gorocksdb.DB
@@ -52,14 +59,22 @@ type OpenchainDB struct {
 	StateDeltaCF *gorocksdb.ColumnFamilyHandle
 	IndexesCF    *gorocksdb.ColumnFamilyHandle
 	PersistCF    *gorocksdb.ColumnFamilyHandle
+
+	chainID string
 }
 
 var openchainDB *OpenchainDB
 var isOpen bool
 
+var chainDBs = make(map[string]*OpenchainDB)
+var chainDBsLock sync.Mutex
+
 // CreateDB creates a rocks db database
 func CreateDB() error {
-	dbPath := getDBPath()
+	return createDB(getDBPath())
+}
+
+func createDB(dbPath string) error {
 	dbLogger.Debug("Creating DB at [%s]", dbPath)
 	missing, err := dirMissingOrEmpty(dbPath)
 	if err != nil {
@@ -108,6 +123,90 @@ func GetDBHandle() *OpenchainDB {
 	return openchainDB
 }
 
+// GetChainDBHandle returns a handle to the OpenchainDB holding the given chain.
+// The default chain is held by the database returned by GetDBHandle, every
+// other chain by a database of its own, which must have been created with
+// CreateChainDB
+func GetChainDBHandle(chainID string) (*OpenchainDB, error) {
+	if chainID == DefaultChainID {
+		return GetDBHandle(), nil
+	}
+
+	chainDBsLock.Lock()
+	defer chainDBsLock.Unlock()
+	if chainDB, ok := chainDBs[chainID]; ok {
+		return chainDB, nil
+	}
+
+	dbPath, err := getChainDBPath(chainID)
+	if err != nil {
+		return nil, err
+	}
+	missing, err := dirMissingOrEmpty(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if missing {
+		return nil, fmt.Errorf("Chain [%s] does not exist", chainID)
+	}
+	chainDB, err := openDBAt(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	chainDB.chainID = chainID
+	chainDBs[chainID] = chainDB
+	return chainDB, nil
+}
+
+// CreateChainDB creates the database holding the given chain
+func CreateChainDB(chainID string) error {
+	if chainID == DefaultChainID {
+		return fmt.Errorf("The default chain cannot be created")
+	}
+	dbPath, err := getChainDBPath(chainID)
+	if err != nil {
+		return err
+	}
+	return createDB(dbPath)
+}
+
+// ListChains returns the IDs of the chains created with CreateChainDB
+func ListChains() ([]string, error) {
+	chainsPath := getChainsPath()
+	exists, err := dirExists(chainsPath)
+	if err != nil || !exists {
+		return nil, err
+	}
+	f, err := os.Open(chainsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	var chainIDs []string
+	for _, name := range names {
+		if validChainID.MatchString(name) {
+			chainIDs = append(chainIDs, name)
+		}
+	}
+	return chainIDs, nil
+}
+
+func closeChainDBs() {
+	chainDBsLock.Lock()
+	openDBs := make([]*OpenchainDB, 0, len(chainDBs))
+	for _, chainDB := range chainDBs {
+		openDBs = append(openDBs, chainDB)
+	}
+	chainDBsLock.Unlock()
+	for _, chainDB := range openDBs {
+		chainDB.CloseDB()
+	}
+}
+
 // GetFromBlockchainCF get value for given key from column family - blockchainCF
 func (openchainDB *OpenchainDB) GetFromBlockchainCF(key []byte) ([]byte, error) {
 	return openchainDB.Get(openchainDB.BlockchainCF, key)
@@ -172,6 +271,17 @@ func getDBPath() string {
 	return dbPath + "db"
 }
 
+func getChainsPath() string {
+	return path.Join(path.Dir(getDBPath()), "chains")
+}
+
+func getChainDBPath(chainID string) (string, error) {
+	if !validChainID.MatchString(chainID) {
+		return "", fmt.Errorf("Invalid chain ID [%s], only letters, digits, '_' and '-' are allowed", chainID)
+	}
+	return path.Join(getChainsPath(), chainID, "db"), nil
+}
+
 func createDBIfDBPathEmpty() error {
 	dbPath := getDBPath()
 	missing, err := dirMissingOrEmpty(dbPath)
@@ -193,7 +303,15 @@ func openDB() (*OpenchainDB, error) {
 		return openchainDB, nil
 	}
 
-	dbPath := getDBPath()
+	db, err := openDBAt(getDBPath())
+	if err != nil {
+		return nil, err
+	}
+	isOpen = true
+	return db, nil
+}
+
+func openDBAt(dbPath string) (*OpenchainDB, error) {
 	opts := gorocksdb.NewDefaultOptions()
 	defer opts.Destroy()
 
@@ -213,9 +331,15 @@ func openDB() (*OpenchainDB, error) {
 		fmt.Println("Error opening DB", err)
 		return nil, err
 	}
-	isOpen = true
 	// XXX should we close cfHandlers[0]?
-	return &OpenchainDB{db, cfHandlers[1], cfHandlers[2], cfHandlers[3], cfHandlers[4], cfHandlers[5]}, nil
+	return &OpenchainDB{
+		DB:           db,
+		BlockchainCF: cfHandlers[1],
+		StateCF:      cfHandlers[2],
+		StateDeltaCF: cfHandlers[3],
+		IndexesCF:    cfHandlers[4],
+		PersistCF:    cfHandlers[5],
+	}, nil
 }
 
 // CloseDB releases all column family handles and closes rocksdb
@@ -226,7 +350,13 @@ func (openchainDB *OpenchainDB) CloseDB() {
 	openchainDB.IndexesCF.Destroy()
 	openchainDB.PersistCF.Destroy()
 	openchainDB.DB.Close()
-	isOpen = false
+	if openchainDB.chainID == DefaultChainID {
+		isOpen = false
+		return
+	}
+	chainDBsLock.Lock()
+	defer chainDBsLock.Unlock()
+	delete(chainDBs, openchainDB.chainID)
 }
 
 // DeleteState delets ALL state keys/values from the DB. This is generally
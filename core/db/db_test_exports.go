@@ -54,6 +54,7 @@ func (testDB *TestDBWrapper) CreateFreshDB(t testing.TB) {
 
 func (testDB *TestDBWrapper) cleanup() {
 	if testDB.performCleanup {
+		closeChainDBs()
 		GetDBHandle().CloseDB()
 		testDB.performCleanup = false
 	}
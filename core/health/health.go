@@ -0,0 +1,106 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health collects the health of the components of the peer, and
+// reports it over HTTP to orchestration systems, which alert on or restart
+// degraded peers
+package health
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Checker is implemented by components which report their health
+type Checker interface {
+	Healthy() error // nil if the component is healthy, else why it is degraded
+}
+
+// Registry holds the checkers of the components by name
+type Registry struct {
+	lock     sync.RWMutex
+	checkers map[string]Checker
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{checkers: make(map[string]Checker)}
+}
+
+// DefaultRegistry is the registry the peer serves its health from
+var DefaultRegistry = NewRegistry()
+
+// Register adds c under name, replacing any checker previously registered
+// under it
+func (r *Registry) Register(name string, c Checker) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.checkers[name] = c
+}
+
+// Unregister removes the checker registered under name
+func (r *Registry) Unregister(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.checkers, name)
+}
+
+// Degraded returns why the degraded components are, by name, empty if all
+// components are healthy
+func (r *Registry) Degraded() map[string]error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	degraded := make(map[string]error)
+	for name, c := range r.checkers {
+		if err := c.Healthy(); err != nil {
+			degraded[name] = err
+		}
+	}
+	return degraded
+}
+
+// ServeHTTP implements http.Handler, it responds with status 200 if all
+// components are healthy, else with status 503 and why each degraded
+// component is
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	degraded := r.Degraded()
+	if len(degraded) == 0 {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "OK")
+		return
+	}
+
+	names := make([]string, 0, len(degraded))
+	for name := range degraded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s: %s\n", name, degraded[name])
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	buf.WriteTo(w)
+}
+
+// Handler returns the HTTP handler serving the health of DefaultRegistry
+func Handler() http.Handler {
+	return DefaultRegistry
+}
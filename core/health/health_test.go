@@ -0,0 +1,61 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type checkerFunc func() error
+
+func (f checkerFunc) Healthy() error {
+	return f()
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	var replicaErr error
+	r.Register("replica", checkerFunc(func() error { return replicaErr }))
+	r.Register("ledger", checkerFunc(func() error { return nil }))
+
+	get := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/health", nil)
+		if err != nil {
+			t.Fatalf("Could not create request: %s", err)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get(); rec.Code != http.StatusOK || rec.Body.String() != "OK\n" {
+		t.Errorf("Expected healthy components to respond 200 OK, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	replicaErr = fmt.Errorf("nothing executed for 10s")
+	if rec := get(); rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "replica: nothing executed for 10s\n" {
+		t.Errorf("Expected a degraded component to respond 503 with its reason, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	r.Unregister("replica")
+	if rec := get(); rec.Code != http.StatusOK {
+		t.Errorf("Expected the unregistered component not to be checked, got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -43,6 +43,7 @@ type blockchainIndexer interface {
 
 // Implementation for sync indexer
 type blockchainIndexerSync struct {
+	openchainDB *db.OpenchainDB
 }
 
 func newBlockchainIndexerSync() *blockchainIndexerSync {
@@ -54,12 +55,13 @@ func (indexer *blockchainIndexerSync) isSynchronous() bool {
 }
 
 func (indexer *blockchainIndexerSync) start(blockchain *blockchain) error {
+	indexer.openchainDB = blockchain.openchainDB
 	return nil
 }
 
 func (indexer *blockchainIndexerSync) createIndexesSync(
 	block *protos.Block, blockNumber uint64, blockHash []byte, writeBatch *gorocksdb.WriteBatch) error {
-	return addIndexDataForPersistence(block, blockNumber, blockHash, writeBatch)
+	return addIndexDataForPersistence(indexer.openchainDB, block, blockNumber, blockHash, writeBatch)
 }
 
 func (indexer *blockchainIndexerSync) createIndexesAsync(block *protos.Block, blockNumber uint64, blockHash []byte) error {
@@ -67,11 +69,11 @@ func (indexer *blockchainIndexerSync) createIndexesAsync(block *protos.Block, bl
 }
 
 func (indexer *blockchainIndexerSync) fetchBlockNumberByBlockHash(blockHash []byte) (uint64, error) {
-	return fetchBlockNumberByBlockHashFromDB(blockHash)
+	return fetchBlockNumberByBlockHashFromDB(indexer.openchainDB, blockHash)
 }
 
 func (indexer *blockchainIndexerSync) fetchTransactionIndexByUUID(txUUID string) (uint64, uint64, error) {
-	return fetchTransactionIndexByUUIDFromDB(txUUID)
+	return fetchTransactionIndexByUUIDFromDB(indexer.openchainDB, txUUID)
 }
 
 func (indexer *blockchainIndexerSync) stop() {
@@ -79,8 +81,7 @@ func (indexer *blockchainIndexerSync) stop() {
 }
 
 // Functions for persisting and retrieving index data
-func addIndexDataForPersistence(block *protos.Block, blockNumber uint64, blockHash []byte, writeBatch *gorocksdb.WriteBatch) error {
-	openchainDB := db.GetDBHandle()
+func addIndexDataForPersistence(openchainDB *db.OpenchainDB, block *protos.Block, blockNumber uint64, blockHash []byte, writeBatch *gorocksdb.WriteBatch) error {
 	cf := openchainDB.IndexesCF
 
 	// add blockhash -> blockNumber
@@ -112,8 +113,8 @@ func addIndexDataForPersistence(block *protos.Block, blockNumber uint64, blockHa
 	return nil
 }
 
-func fetchBlockNumberByBlockHashFromDB(blockHash []byte) (uint64, error) {
-	blockNumberBytes, err := db.GetDBHandle().GetFromIndexesCF(encodeBlockHashKey(blockHash))
+func fetchBlockNumberByBlockHashFromDB(openchainDB *db.OpenchainDB, blockHash []byte) (uint64, error) {
+	blockNumberBytes, err := openchainDB.GetFromIndexesCF(encodeBlockHashKey(blockHash))
 	if err != nil {
 		return 0, err
 	}
@@ -121,8 +122,8 @@ func fetchBlockNumberByBlockHashFromDB(blockHash []byte) (uint64, error) {
 	return blockNumber, nil
 }
 
-func fetchTransactionIndexByUUIDFromDB(txUUID string) (uint64, uint64, error) {
-	blockNumTxIndexBytes, err := db.GetDBHandle().GetFromIndexesCF(encodeTxUUIDKey(txUUID))
+func fetchTransactionIndexByUUIDFromDB(openchainDB *db.OpenchainDB, txUUID string) (uint64, uint64, error) {
+	blockNumTxIndexBytes, err := openchainDB.GetFromIndexesCF(encodeTxUUIDKey(txUUID))
 	if err != nil {
 		return 0, 0, err
 	}
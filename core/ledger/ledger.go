@@ -77,31 +77,93 @@ var (
 
 // Ledger - the struct for openchain ledger
 type Ledger struct {
-	blockchain *blockchain
-	state      *state.State
-	currentID  interface{}
+	chainID     string
+	openchainDB *db.OpenchainDB
+	blockchain  *blockchain
+	state       *state.State
+	currentID   interface{}
+
+	listenersLock sync.RWMutex
+	listeners     map[BlockListener]struct{}
+}
+
+// BlockListener is notified of every block added to the ledger, either by
+// committing a transaction batch or by putting a raw block on the chain.
+// Listeners are invoked synchronously and must not block
+type BlockListener interface {
+	BlockAdded(blockNumber uint64, block *protos.Block)
 }
 
 var ledger *Ledger
 var ledgerError error
 var once sync.Once
 
+var chainLedgers = make(map[string]*Ledger)
+var chainLedgersLock sync.Mutex
+
 // GetLedger - gives a reference to a 'singleton' ledger
 func GetLedger() (*Ledger, error) {
 	once.Do(func() {
-		ledger, ledgerError = newLedger()
+		ledger, ledgerError = newLedger(db.DefaultChainID)
 	})
 	return ledger, ledgerError
 }
 
-func newLedger() (*Ledger, error) {
-	blockchain, err := newBlockchain()
+// GetChainLedger - gives a reference to the ledger of the given chain. The
+// ledger of the default chain is the one returned by GetLedger, every other
+// chain has a ledger of its own, isolated in a separate database, which must
+// have been created with CreateChainLedger
+func GetChainLedger(chainID string) (*Ledger, error) {
+	if chainID == db.DefaultChainID {
+		return GetLedger()
+	}
+	chainLedgersLock.Lock()
+	defer chainLedgersLock.Unlock()
+	if chainLedger, ok := chainLedgers[chainID]; ok {
+		return chainLedger, nil
+	}
+	chainLedger, err := newLedger(chainID)
 	if err != nil {
 		return nil, err
 	}
+	chainLedgers[chainID] = chainLedger
+	return chainLedger, nil
+}
+
+// CreateChainLedger creates the ledger of a new chain, it returns an error if
+// the chain already exists. Chains are local to the peer, they are not part of
+// the replicated state and must be created on every peer hosting them
+func CreateChainLedger(chainID string) (*Ledger, error) {
+	if err := db.CreateChainDB(chainID); err != nil {
+		return nil, newLedgerError(ErrorTypeInvalidArgument, fmt.Sprintf("Cannot create chain [%s]: %s", chainID, err))
+	}
+	return GetChainLedger(chainID)
+}
 
-	state := state.NewState()
-	return &Ledger{blockchain, state, nil}, nil
+// ListChains returns the IDs of all chains other than the default chain
+func ListChains() ([]string, error) {
+	return db.ListChains()
+}
+
+func newLedger(chainID string) (*Ledger, error) {
+	openchainDB, err := db.GetChainDBHandle(chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	blockchain, err := newBlockchain(openchainDB)
+	if err != nil {
+		return nil, err
+	}
+
+	state := state.NewState(openchainDB)
+	return &Ledger{chainID: chainID, openchainDB: openchainDB, blockchain: blockchain, state: state}, nil
+}
+
+// GetChainID returns the ID of the chain held by this ledger, the default
+// chain is identified by an empty ID
+func (ledger *Ledger) GetChainID() string {
+	return ledger.chainID
 }
 
 /////////////////// Transaction-batch related methods ///////////////////////////////
@@ -166,7 +228,7 @@ func (ledger *Ledger) CommitTxBatch(id interface{}, transactions []*protos.Trans
 	ledger.state.AddChangesForPersistence(newBlockNumber, writeBatch)
 	opt := gorocksdb.NewDefaultWriteOptions()
 	defer opt.Destroy()
-	dbErr := db.GetDBHandle().DB.Write(opt, writeBatch)
+	dbErr := ledger.openchainDB.DB.Write(opt, writeBatch)
 	if dbErr != nil {
 		ledger.resetForNextTxGroup(false)
 		ledger.blockchain.blockPersistenceStatus(false)
@@ -176,7 +238,8 @@ func (ledger *Ledger) CommitTxBatch(id interface{}, transactions []*protos.Trans
 	ledger.resetForNextTxGroup(true)
 	ledger.blockchain.blockPersistenceStatus(true)
 
-	sendProducerBlockEvent(block)
+	ledger.notifyBlockListeners(newBlockNumber, block)
+	ledger.sendProducerBlockEvent(block)
 	return nil
 }
 
@@ -270,8 +333,8 @@ func (ledger *Ledger) SetStateMultipleKeys(chaincodeID string, kvs map[string][]
 // should be used when transferring the state from one peer to another peer. You must call
 // stateSnapshot.Release() once you are done with the snapsnot to free up resources.
 func (ledger *Ledger) GetStateSnapshot() (*state.StateSnapshot, error) {
-	dbSnapshot := db.GetDBHandle().GetSnapshot()
-	blockHeight, err := fetchBlockchainSizeFromSnapshot(dbSnapshot)
+	dbSnapshot := ledger.openchainDB.GetSnapshot()
+	blockHeight, err := fetchBlockchainSizeFromSnapshot(ledger.openchainDB, dbSnapshot)
 	if err != nil {
 		dbSnapshot.Release()
 		return nil, err
@@ -292,6 +355,36 @@ func (ledger *Ledger) GetStateDelta(blockNumber uint64) (*statemgmt.StateDelta,
 	return ledger.state.FetchStateDeltaFromDB(blockNumber)
 }
 
+// GetStateDeltaRange composes the state deltas for the blocks fromBlockNumber
+// through toBlockNumber (inclusive) into a single delta which contains only the
+// keys whose value differs between the state at block fromBlockNumber-1 and the
+// state at block toBlockNumber. Applying the returned delta is equivalent to
+// applying the deltas for each block in the range in order.
+// If any of the deltas in the range is not available because it has been
+// discarded, returns nil,nil.
+func (ledger *Ledger) GetStateDeltaRange(fromBlockNumber uint64, toBlockNumber uint64) (*statemgmt.StateDelta, error) {
+	if fromBlockNumber > toBlockNumber {
+		return nil, newLedgerError(ErrorTypeInvalidArgument,
+			fmt.Sprintf("fromBlockNumber [%d] is greater than toBlockNumber [%d]", fromBlockNumber, toBlockNumber))
+	}
+	if toBlockNumber >= ledger.GetBlockchainSize() {
+		return nil, ErrOutOfBounds
+	}
+	composedDelta := statemgmt.NewStateDelta()
+	for blockNumber := fromBlockNumber; blockNumber <= toBlockNumber; blockNumber++ {
+		delta, err := ledger.state.FetchStateDeltaFromDB(blockNumber)
+		if err != nil {
+			return nil, err
+		}
+		if delta == nil {
+			return nil, nil
+		}
+		composedDelta.ApplyChanges(delta)
+	}
+	composedDelta.RemoveNoOps()
+	return composedDelta, nil
+}
+
 // ApplyStateDelta applies a state delta to the current state. This is an
 // in memory change only. You must call ledger.CommitStateDelta to persist
 // the change to the DB.
@@ -384,10 +477,37 @@ func (ledger *Ledger) PutRawBlock(block *protos.Block, blockNumber uint64) error
 	if err != nil {
 		return err
 	}
-	sendProducerBlockEvent(block)
+	ledger.notifyBlockListeners(blockNumber, block)
+	ledger.sendProducerBlockEvent(block)
 	return nil
 }
 
+// RegisterBlockListener registers a listener to be notified of every block
+// subsequently added to the ledger
+func (ledger *Ledger) RegisterBlockListener(listener BlockListener) {
+	ledger.listenersLock.Lock()
+	defer ledger.listenersLock.Unlock()
+	if ledger.listeners == nil {
+		ledger.listeners = make(map[BlockListener]struct{})
+	}
+	ledger.listeners[listener] = struct{}{}
+}
+
+// UnregisterBlockListener stops notifying a previously registered listener
+func (ledger *Ledger) UnregisterBlockListener(listener BlockListener) {
+	ledger.listenersLock.Lock()
+	defer ledger.listenersLock.Unlock()
+	delete(ledger.listeners, listener)
+}
+
+func (ledger *Ledger) notifyBlockListeners(blockNumber uint64, block *protos.Block) {
+	ledger.listenersLock.RLock()
+	defer ledger.listenersLock.RUnlock()
+	for listener := range ledger.listeners {
+		listener.BlockAdded(blockNumber, block)
+	}
+}
+
 // VerifyChain will verify the integrety of the blockchain. This is accomplished
 // by ensuring that the previous block hash stored in each block matches
 // the actual hash of the previous block in the chain. The return value is the
@@ -455,6 +575,15 @@ func (ledger *Ledger) resetForNextTxGroup(txCommited bool) {
 	ledger.state.ClearInMemoryChanges(txCommited)
 }
 
+// sendProducerBlockEvent sends a block event for blocks of the default chain,
+// block events do not identify the chain they belong to
+func (ledger *Ledger) sendProducerBlockEvent(block *protos.Block) {
+	if ledger.chainID != db.DefaultChainID {
+		return
+	}
+	sendProducerBlockEvent(block)
+}
+
 func sendProducerBlockEvent(block *protos.Block) {
 
 	// Remove payload from deploy transactions. This is done to make block
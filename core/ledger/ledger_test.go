@@ -210,6 +210,77 @@ func TestLedgerPutRawBlock(t *testing.T) {
 	testutil.AssertNil(t, ledgerTestWrapper.GetBlockByNumber(2))
 }
 
+type recordingBlockListener struct {
+	blockNumbers []uint64
+}
+
+func (listener *recordingBlockListener) BlockAdded(blockNumber uint64, block *protos.Block) {
+	listener.blockNumbers = append(listener.blockNumbers, blockNumber)
+}
+
+func TestLedgerBlockListener(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+	listener := &recordingBlockListener{}
+	ledger.RegisterBlockListener(listener)
+
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin("txUuid")
+	ledger.SetState("chaincode1", "key1", []byte("value1"))
+	ledger.TxFinished("txUuid", true)
+	transaction, _ := buildTestTx(t)
+	ledger.CommitTxBatch(1, []*protos.Transaction{transaction}, nil, []byte("proof"))
+
+	block := new(protos.Block)
+	block.PreviousBlockHash = []byte("foo")
+	block.StateHash = []byte("bar")
+	ledger.PutRawBlock(block, 4)
+	testutil.AssertEquals(t, listener.blockNumbers, []uint64{0, 4})
+
+	ledger.UnregisterBlockListener(listener)
+	ledger.PutRawBlock(block, 5)
+	testutil.AssertEquals(t, listener.blockNumbers, []uint64{0, 4})
+}
+
+func TestChainLedgerIsolation(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	defaultLedger := ledgerTestWrapper.ledger
+
+	_, err := GetChainLedger("chain1")
+	testutil.AssertError(t, err, "Expected an error for a chain which was not created")
+	_, err = CreateChainLedger("../chain1")
+	testutil.AssertError(t, err, "Expected an error for an invalid chain ID")
+
+	chainLedger, err := CreateChainLedger("chain1")
+	testutil.AssertNoError(t, err, "Error while creating chain")
+	testutil.AssertEquals(t, chainLedger.GetChainID(), "chain1")
+	_, err = CreateChainLedger("chain1")
+	testutil.AssertError(t, err, "Expected an error for a chain which already exists")
+
+	chainLedger.BeginTxBatch(1)
+	chainLedger.TxBegin("txUuid")
+	chainLedger.SetState("chaincode1", "key1", []byte("value1"))
+	chainLedger.TxFinished("txUuid", true)
+	transaction, uuid := buildTestTx(t)
+	chainLedger.CommitTxBatch(1, []*protos.Transaction{transaction}, nil, []byte("proof"))
+
+	value, _ := chainLedger.GetState("chaincode1", "key1", true)
+	testutil.AssertEquals(t, value, []byte("value1"))
+	testutil.AssertEquals(t, chainLedger.GetBlockchainSize(), uint64(1))
+
+	testutil.AssertNil(t, ledgerTestWrapper.GetState("chaincode1", "key1", true))
+	testutil.AssertEquals(t, defaultLedger.GetBlockchainSize(), uint64(0))
+	_, err = defaultLedger.GetTransactionByUUID(uuid)
+	testutil.AssertEquals(t, err, ErrResourceNotFound)
+
+	sameLedger, err := GetChainLedger("chain1")
+	testutil.AssertNoError(t, err, "Error while getting chain")
+	testutil.AssertSame(t, sameLedger, chainLedger)
+	chains, err := ListChains()
+	testutil.AssertNoError(t, err, "Error while listing chains")
+	testutil.AssertEquals(t, chains, []string{"chain1"})
+}
+
 func TestLedgerSetRawState(t *testing.T) {
 	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
 	ledger := ledgerTestWrapper.ledger
@@ -536,6 +607,64 @@ func TestRollBackwardsAndForwards(t *testing.T) {
 	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode4", "key4", true), []byte("value4C"))
 }
 
+func TestGetStateDeltaRange(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	// Block 0
+	ledger.BeginTxBatch(0)
+	ledger.TxBegin("txUuid1")
+	ledger.SetState("chaincode1", "key1", []byte("value1A"))
+	ledger.SetState("chaincode2", "key2", []byte("value2A"))
+	ledger.TxFinished("txUuid1", true)
+	transaction, _ := buildTestTx(t)
+	ledger.CommitTxBatch(0, []*protos.Transaction{transaction}, nil, []byte("proof"))
+
+	// Block 1
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin("txUuid1")
+	ledger.SetState("chaincode1", "key1", []byte("value1B"))
+	ledger.SetState("chaincode3", "key3", []byte("value3B"))
+	ledger.TxFinished("txUuid1", true)
+	transaction, _ = buildTestTx(t)
+	ledger.CommitTxBatch(1, []*protos.Transaction{transaction}, nil, []byte("proof"))
+
+	// Block 2, restores key1 and removes key3 so that only key2 differs from block 0
+	ledger.BeginTxBatch(2)
+	ledger.TxBegin("txUuid1")
+	ledger.SetState("chaincode1", "key1", []byte("value1A"))
+	ledger.DeleteState("chaincode3", "key3")
+	ledger.SetState("chaincode2", "key2", []byte("value2C"))
+	ledger.TxFinished("txUuid1", true)
+	transaction, _ = buildTestTx(t)
+	ledger.CommitTxBatch(2, []*protos.Transaction{transaction}, nil, []byte("proof"))
+
+	delta, err := ledger.GetStateDeltaRange(1, 2)
+	testutil.AssertNoError(t, err, "Error while getting state delta range")
+	testutil.AssertEquals(t, delta.GetUpdatedChaincodeIds(true), []string{"chaincode2"})
+	testutil.AssertEquals(t, delta.Get("chaincode2", "key2").GetValue(), []byte("value2C"))
+	testutil.AssertEquals(t, delta.Get("chaincode2", "key2").GetPreviousValue(), []byte("value2A"))
+
+	// Roll back to block 0 and then forwards again using the composed delta
+	delta.RollBackwards = true
+	ledgerTestWrapper.ApplyStateDelta(1, delta)
+	ledgerTestWrapper.CommitStateDelta(1)
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode1", "key1", true), []byte("value1A"))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode2", "key2", true), []byte("value2A"))
+
+	delta.RollBackwards = false
+	ledgerTestWrapper.ApplyStateDelta(2, delta)
+	ledgerTestWrapper.CommitStateDelta(2)
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode1", "key1", true), []byte("value1A"))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode2", "key2", true), []byte("value2C"))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode3", "key3", true), nil)
+
+	_, err = ledger.GetStateDeltaRange(2, 1)
+	testutil.AssertError(t, err, "Expected an error for an inverted block range")
+	_, err = ledger.GetStateDeltaRange(1, 3)
+	testutil.AssertEquals(t, err, ErrOutOfBounds)
+}
+
 func TestInvalidOrderDelta(t *testing.T) {
 	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
 	ledger := ledgerTestWrapper.ledger
@@ -28,9 +28,10 @@ var testDBWrapper = db.NewTestDBWrapper()
 //InitTestLedger provides a ledger for testing. This method creates a fresh db and constructs a ledger instance on that.
 func InitTestLedger(t *testing.T) *Ledger {
 	testDBWrapper.CreateFreshDB(t)
+	chainLedgers = make(map[string]*Ledger)
 	_, err := GetLedger()
 	testutil.AssertNoError(t, err, "Error while constructing ledger")
-	newLedger, err := newLedger()
+	newLedger, err := newLedger(db.DefaultChainID)
 	testutil.AssertNoError(t, err, "Error while constructing ledger")
 	ledger = newLedger
 	return newLedger
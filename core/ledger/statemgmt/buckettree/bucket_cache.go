@@ -39,23 +39,25 @@ type bucketCache struct {
 	lock      sync.RWMutex
 	size      uint64
 	maxSize   uint64
+
+	openchainDB *db.OpenchainDB
 }
 
-func newBucketCache(maxSizeMBs int) *bucketCache {
+func newBucketCache(openchainDB *db.OpenchainDB, maxSizeMBs int) *bucketCache {
 	isEnabled := true
 	if maxSizeMBs <= 0 {
 		isEnabled = false
 	} else {
 		logger.Info("Constructing bucket-cache with max bucket cache size = [%d] MBs", maxSizeMBs)
 	}
-	return &bucketCache{c: make(map[bucketKey]*bucketNode), maxSize: uint64(maxSizeMBs * 1024 * 1024), isEnabled: isEnabled}
+	return &bucketCache{c: make(map[bucketKey]*bucketNode), maxSize: uint64(maxSizeMBs * 1024 * 1024), isEnabled: isEnabled, openchainDB: openchainDB}
 }
 
 func (cache *bucketCache) loadAllBucketNodesFromDB() {
 	if !cache.isEnabled {
 		return
 	}
-	openchainDB := db.GetDBHandle()
+	openchainDB := cache.openchainDB
 	itr := openchainDB.GetStateCFIterator()
 	defer itr.Close()
 	itr.Seek([]byte{byte(0)})
@@ -116,13 +118,13 @@ func (cache *bucketCache) putWithoutLock(key bucketKey, node *bucketNode) {
 func (cache *bucketCache) get(key bucketKey) (*bucketNode, error) {
 	defer perfstat.UpdateTimeStat("timeSpent", time.Now())
 	if !cache.isEnabled {
-		return fetchBucketNodeFromDB(&key)
+		return fetchBucketNodeFromDB(cache.openchainDB, &key)
 	}
 	cache.lock.RLock()
 	defer cache.lock.RUnlock()
 	bucketNode := cache.c[key]
 	if bucketNode == nil {
-		return fetchBucketNodeFromDB(&key)
+		return fetchBucketNodeFromDB(cache.openchainDB, &key)
 	}
 	return bucketNode, nil
 }
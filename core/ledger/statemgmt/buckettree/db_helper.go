@@ -21,8 +21,7 @@ import (
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 )
 
-func fetchDataNodeFromDB(dataKey *dataKey) (*dataNode, error) {
-	openchainDB := db.GetDBHandle()
+func fetchDataNodeFromDB(openchainDB *db.OpenchainDB, dataKey *dataKey) (*dataNode, error) {
 	nodeBytes, err := openchainDB.GetFromStateCF(dataKey.getEncodedBytes())
 	if err != nil {
 		return nil, err
@@ -39,8 +38,7 @@ func fetchDataNodeFromDB(dataKey *dataKey) (*dataNode, error) {
 	return unmarshalDataNode(dataKey, nodeBytes), nil
 }
 
-func fetchBucketNodeFromDB(bucketKey *bucketKey) (*bucketNode, error) {
-	openchainDB := db.GetDBHandle()
+func fetchBucketNodeFromDB(openchainDB *db.OpenchainDB, bucketKey *bucketKey) (*bucketNode, error) {
 	nodeBytes, err := openchainDB.GetFromStateCF(bucketKey.getEncodedBytes())
 	if err != nil {
 		return nil, err
@@ -53,9 +51,8 @@ func fetchBucketNodeFromDB(bucketKey *bucketKey) (*bucketNode, error) {
 
 type rawKey []byte
 
-func fetchDataNodesFromDBFor(bucketKey *bucketKey) (dataNodes, error) {
+func fetchDataNodesFromDBFor(openchainDB *db.OpenchainDB, bucketKey *bucketKey) (dataNodes, error) {
 	logger.Debug("Fetching from DB data nodes for bucket [%s]", bucketKey)
-	openchainDB := db.GetDBHandle()
 	itr := openchainDB.GetStateCFIterator()
 	defer itr.Close()
 	minimumDataKeyBytes := minimumPossibleDataKeyBytesFor(bucketKey)
@@ -34,8 +34,8 @@ type RangeScanIterator struct {
 	done                bool
 }
 
-func newRangeScanIterator(chaincodeID string, startKey string, endKey string) (*RangeScanIterator, error) {
-	dbItr := db.GetDBHandle().GetStateCFIterator()
+func newRangeScanIterator(openchainDB *db.OpenchainDB, chaincodeID string, startKey string, endKey string) (*RangeScanIterator, error) {
+	dbItr := openchainDB.GetStateCFIterator()
 	itr := &RangeScanIterator{
 		dbItr:       dbItr,
 		chaincodeID: chaincodeID,
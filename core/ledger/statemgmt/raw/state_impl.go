@@ -25,12 +25,13 @@ import (
 // StateImpl implements raw state management. This implementation does not support computation of crypto-hash of the state.
 // It simply stores the compositeKey and value in the db
 type StateImpl struct {
-	stateDelta *statemgmt.StateDelta
+	stateDelta  *statemgmt.StateDelta
+	openchainDB *db.OpenchainDB
 }
 
-// NewRawState constructs new instance of raw state
-func NewRawState() *StateImpl {
-	return &StateImpl{}
+// NewRawState constructs new instance of raw state persisted in the given db
+func NewRawState(openchainDB *db.OpenchainDB) *StateImpl {
+	return &StateImpl{openchainDB: openchainDB}
 }
 
 // Initialize - method implementation for interface 'statemgmt.HashableState'
@@ -41,7 +42,7 @@ func (impl *StateImpl) Initialize(configs map[string]interface{}) error {
 // Get - method implementation for interface 'statemgmt.HashableState'
 func (impl *StateImpl) Get(chaincodeID string, key string) ([]byte, error) {
 	compositeKey := statemgmt.ConstructCompositeKey(chaincodeID, key)
-	openchainDB := db.GetDBHandle()
+	openchainDB := impl.openchainDB
 	return openchainDB.GetFromStateCF(compositeKey)
 }
 
@@ -67,7 +68,7 @@ func (impl *StateImpl) AddChangesForPersistence(writeBatch *gorocksdb.WriteBatch
 	if delta == nil {
 		return nil
 	}
-	openchainDB := db.GetDBHandle()
+	openchainDB := impl.openchainDB
 	updatedChaincodeIds := delta.GetUpdatedChaincodeIds(false)
 	for _, updatedChaincodeID := range updatedChaincodeIds {
 		updates := delta.GetUpdates(updatedChaincodeID)
@@ -0,0 +1,61 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statemgmt
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// EncodeSnapshotChunk encodes a run of marshalled state deltas, as sent for a state snapshot, as a single chunk
+// The encoding only depends on the deltas and their order, so that peers which hold the same state produce the same chunks
+func EncodeSnapshotChunk(deltas [][]byte) []byte {
+	buffer := proto.NewBuffer([]byte{})
+	err := buffer.EncodeVarint(uint64(len(deltas)))
+	if err != nil {
+		// in protobuf code the error return is always nil
+		panic(fmt.Errorf("This error should not occur: %s", err))
+	}
+	for _, delta := range deltas {
+		err = buffer.EncodeRawBytes(delta)
+		if err != nil {
+			panic(fmt.Errorf("This error should not occur: %s", err))
+		}
+	}
+	return buffer.Bytes()
+}
+
+// DecodeSnapshotChunk returns the marshalled state deltas held by a chunk encoded with EncodeSnapshotChunk
+func DecodeSnapshotChunk(chunk []byte) ([][]byte, error) {
+	buffer := proto.NewBuffer(chunk)
+	size, err := buffer.DecodeVarint()
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshaling snapshot chunk size: %s", err)
+	}
+	if size > uint64(len(chunk)) {
+		return nil, fmt.Errorf("Snapshot chunk claims %d deltas, but is only %d bytes long", size, len(chunk))
+	}
+	deltas := make([][]byte, size)
+	for i := range deltas {
+		deltas[i], err = buffer.DecodeRawBytes(true)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshaling snapshot chunk delta %d: %s", i, err)
+		}
+	}
+	return deltas, nil
+}
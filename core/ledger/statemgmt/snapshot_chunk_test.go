@@ -0,0 +1,42 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statemgmt
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+)
+
+func TestSnapshotChunkEncoding(t *testing.T) {
+	var deltas [][]byte
+	for _, key := range []string{"key1", "key2", "key3"} {
+		delta := NewStateDelta()
+		delta.Set("chaincode1", key, []byte("value of "+key), nil)
+		deltas = append(deltas, delta.Marshal())
+	}
+
+	chunk := EncodeSnapshotChunk(deltas)
+	testutil.AssertEquals(t, EncodeSnapshotChunk(deltas), chunk)
+
+	decoded, err := DecodeSnapshotChunk(chunk)
+	testutil.AssertNoError(t, err, "Error decoding snapshot chunk")
+	testutil.AssertEquals(t, decoded, deltas)
+
+	_, err = DecodeSnapshotChunk(chunk[:len(chunk)-1])
+	testutil.AssertError(t, err, "Expected a truncated chunk not to decode")
+}
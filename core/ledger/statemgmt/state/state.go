@@ -33,8 +33,6 @@ var logger = logging.MustGetLogger("state")
 
 const detaultStateImpl = "buckettree"
 
-var stateImpl statemgmt.HashableState
-
 // State structure for maintaining world state.
 // This encapsulates a particular implementation for managing the state persistence
 // This is not thread safe
@@ -46,19 +44,21 @@ type State struct {
 	txStateDeltaHash      map[string][]byte
 	updateStateImpl       bool
 	historyStateDeltaSize uint64
+	openchainDB           *db.OpenchainDB
 }
 
-// NewState constructs a new State. This Initializes encapsulated state implementation
-func NewState() *State {
+// NewState constructs a new State persisted in the given db. This Initializes encapsulated state implementation
+func NewState(openchainDB *db.OpenchainDB) *State {
 	initConfig()
 	logger.Info("Initializing state implementation [%s]", stateImplName)
+	var stateImpl statemgmt.HashableState
 	switch stateImplName {
 	case "buckettree":
-		stateImpl = buckettree.NewStateImpl()
+		stateImpl = buckettree.NewStateImpl(openchainDB)
 	case "trie":
-		stateImpl = trie.NewStateTrie()
+		stateImpl = trie.NewStateTrie(openchainDB)
 	case "raw":
-		stateImpl = raw.NewRawState()
+		stateImpl = raw.NewRawState(openchainDB)
 	default:
 		panic("Should not reach here. Configs should have checked for the stateImplName being a valid names ")
 	}
@@ -67,7 +67,7 @@ func NewState() *State {
 		panic(fmt.Errorf("Error during initialization of state implementation: %s", err))
 	}
 	return &State{stateImpl, statemgmt.NewStateDelta(), statemgmt.NewStateDelta(), "", make(map[string][]byte),
-		false, uint64(deltaHistorySize)}
+		false, uint64(deltaHistorySize), openchainDB}
 }
 
 // TxBegin marks begin of a new tx. If a tx is already in progress, this call panics
@@ -262,12 +262,12 @@ func (state *State) getStateDelta() *statemgmt.StateDelta {
 // GetSnapshot returns a snapshot of the global state for the current block. stateSnapshot.Release()
 // must be called once you are done.
 func (state *State) GetSnapshot(blockNumber uint64, dbSnapshot *gorocksdb.Snapshot) (*StateSnapshot, error) {
-	return newStateSnapshot(blockNumber, dbSnapshot)
+	return newStateSnapshot(state.stateImpl, blockNumber, dbSnapshot)
 }
 
 // FetchStateDeltaFromDB fetches the StateDelta corrsponding to given blockNumber
 func (state *State) FetchStateDeltaFromDB(blockNumber uint64) (*statemgmt.StateDelta, error) {
-	stateDeltaBytes, err := db.GetDBHandle().GetFromStateDeltaCF(encodeStateDeltaKey(blockNumber))
+	stateDeltaBytes, err := state.openchainDB.GetFromStateDeltaCF(encodeStateDeltaKey(blockNumber))
 	if err != nil {
 		return nil, err
 	}
@@ -289,7 +289,7 @@ func (state *State) AddChangesForPersistence(blockNumber uint64, writeBatch *gor
 	state.stateImpl.AddChangesForPersistence(writeBatch)
 
 	serializedStateDelta := state.stateDelta.Marshal()
-	cf := db.GetDBHandle().StateDeltaCF
+	cf := state.openchainDB.StateDeltaCF
 	logger.Debug("Adding state-delta corresponding to block number[%d]", blockNumber)
 	writeBatch.PutCF(cf, encodeStateDeltaKey(blockNumber), serializedStateDelta)
 	if blockNumber >= state.historyStateDeltaSize {
@@ -324,7 +324,7 @@ func (state *State) CommitStateDelta() error {
 	state.stateImpl.AddChangesForPersistence(writeBatch)
 	opt := gorocksdb.NewDefaultWriteOptions()
 	defer opt.Destroy()
-	return db.GetDBHandle().DB.Write(opt, writeBatch)
+	return state.openchainDB.DB.Write(opt, writeBatch)
 }
 
 // DeleteState deletes ALL state keys/values from the DB. This is generally
@@ -332,7 +332,7 @@ func (state *State) CommitStateDelta() error {
 // a snapshot.
 func (state *State) DeleteState() error {
 	state.ClearInMemoryChanges(false)
-	err := db.GetDBHandle().DeleteState()
+	err := state.openchainDB.DeleteState()
 	if err != nil {
 		logger.Error("Error deleting state", err)
 	}
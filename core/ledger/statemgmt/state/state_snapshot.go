@@ -29,7 +29,7 @@ type StateSnapshot struct {
 }
 
 // newStateSnapshot creates a new snapshot of the global state for the current block.
-func newStateSnapshot(blockNumber uint64, dbSnapshot *gorocksdb.Snapshot) (*StateSnapshot, error) {
+func newStateSnapshot(stateImpl statemgmt.HashableState, blockNumber uint64, dbSnapshot *gorocksdb.Snapshot) (*StateSnapshot, error) {
 	itr, err := stateImpl.GetStateSnapshotIterator(dbSnapshot)
 	if err != nil {
 		return nil, err
@@ -105,6 +105,22 @@ func (stateDelta *StateDelta) ApplyChanges(anotherStateDelta *StateDelta) {
 	}
 }
 
+// RemoveNoOps drops the updates whose value is identical to their previous value, and
+// the chaincodes left without any updates. This is useful after merging several deltas
+// with ApplyChanges, where a key may have been changed and later restored
+func (stateDelta *StateDelta) RemoveNoOps() {
+	for chaincodeID, chaincodeStateDelta := range stateDelta.ChaincodeStateDeltas {
+		for key, updatedValue := range chaincodeStateDelta.UpdatedKVs {
+			if updatedValue.isNoOp() {
+				delete(chaincodeStateDelta.UpdatedKVs, key)
+			}
+		}
+		if !chaincodeStateDelta.hasChanges() {
+			delete(stateDelta.ChaincodeStateDeltas, chaincodeID)
+		}
+	}
+}
+
 // IsEmpty checks whether StateDelta contains any data
 func (stateDelta *StateDelta) IsEmpty() bool {
 	return len(stateDelta.ChaincodeStateDeltas) == 0
@@ -230,6 +246,15 @@ func (updatedValue *UpdatedValue) IsDelete() bool {
 	return updatedValue.Value == nil
 }
 
+// isNoOp checks whether the value is the same as the previous value.
+// A nil value (delete) is distinct from an empty byte array
+func (updatedValue *UpdatedValue) isNoOp() bool {
+	if (updatedValue.Value == nil) != (updatedValue.PreviousValue == nil) {
+		return false
+	}
+	return bytes.Equal(updatedValue.Value, updatedValue.PreviousValue)
+}
+
 // GetValue returns the value
 func (updatedValue *UpdatedValue) GetValue() []byte {
 	return updatedValue.Value
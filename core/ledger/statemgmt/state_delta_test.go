@@ -51,6 +51,20 @@ func TestStateDeltaCryptoHash(t *testing.T) {
 	testutil.AssertEquals(t, stateDelta.ComputeCryptoHash(), testutil.ComputeCryptoHash([]byte("chaincodeID1key1value1key2value2chaincodeID2key1key2value2")))
 }
 
+func TestStateDeltaRemoveNoOps(t *testing.T) {
+	stateDelta := NewStateDelta()
+	stateDelta.Set("chaincode1", "key1", []byte("value1"), []byte("value1"))
+	stateDelta.Set("chaincode1", "key2", []byte("value2"), []byte("value1"))
+	stateDelta.Delete("chaincode2", "key1", nil)
+	stateDelta.Set("chaincode3", "key1", []byte{}, nil)
+	stateDelta.RemoveNoOps()
+
+	testutil.AssertEquals(t, stateDelta.GetUpdatedChaincodeIds(true), []string{"chaincode1", "chaincode3"})
+	testutil.AssertNil(t, stateDelta.Get("chaincode1", "key1"))
+	testutil.AssertNotNil(t, stateDelta.Get("chaincode1", "key2"))
+	testutil.AssertNotNil(t, stateDelta.Get("chaincode3", "key1"))
+}
+
 func TestStateDeltaEmptyArrayValue(t *testing.T) {
 	stateDelta := NewStateDelta()
 	stateDelta.Set("chaincode1", "key1", []byte("value1"), nil)
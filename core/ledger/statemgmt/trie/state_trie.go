@@ -35,16 +35,17 @@ type StateTrie struct {
 	persistedStateHash     []byte
 	lastComputedCryptoHash []byte
 	recomputeCryptoHash    bool
+	openchainDB            *db.OpenchainDB
 }
 
-// NewStateTrie contructs a new empty StateTrie
-func NewStateTrie() *StateTrie {
-	return &StateTrie{}
+// NewStateTrie contructs a new empty StateTrie persisted in the given db
+func NewStateTrie(openchainDB *db.OpenchainDB) *StateTrie {
+	return &StateTrie{openchainDB: openchainDB}
 }
 
 // Initialize the state trie with the root key
 func (stateTrie *StateTrie) Initialize(configs map[string]interface{}) error {
-	rootNode, err := fetchTrieNodeFromDB(rootTrieKey)
+	rootNode, err := fetchTrieNodeFromDB(stateTrie.openchainDB, rootTrieKey)
 	if err != nil {
 		panic(fmt.Errorf("Error in fetching root node from DB while initializing state trie: %s", err))
 	}
@@ -57,7 +58,7 @@ func (stateTrie *StateTrie) Initialize(configs map[string]interface{}) error {
 
 // Get the value for a given chaincode ID and key
 func (stateTrie *StateTrie) Get(chaincodeID string, key string) ([]byte, error) {
-	trieNode, err := fetchTrieNodeFromDB(newTrieKey(chaincodeID, key))
+	trieNode, err := fetchTrieNodeFromDB(stateTrie.openchainDB, newTrieKey(chaincodeID, key))
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +118,7 @@ func (stateTrie *StateTrie) ComputeCryptoHash() ([]byte, error) {
 
 func (stateTrie *StateTrie) processChangedNode(changedNode *trieNode) error {
 	stateTrieLogger.Debug("Enter - processChangedNode() for node [%s]", changedNode)
-	dbNode, err := fetchTrieNodeFromDB(changedNode.trieKey)
+	dbNode, err := fetchTrieNodeFromDB(stateTrie.openchainDB, changedNode.trieKey)
 	if err != nil {
 		return err
 	}
@@ -154,7 +155,7 @@ func (stateTrie *StateTrie) AddChangesForPersistence(writeBatch *gorocksdb.Write
 		return nil
 	}
 
-	openchainDB := db.GetDBHandle()
+	openchainDB := stateTrie.openchainDB
 	lowestLevel := stateTrie.trieDelta.getLowestLevel()
 	for level := lowestLevel; level >= 0; level-- {
 		changedNodes := stateTrie.trieDelta.deltaMap[level]
@@ -181,10 +182,10 @@ func (stateTrie *StateTrie) PerfHintKeyChanged(chaincodeID string, key string) {
 
 // GetStateSnapshotIterator - method implementation for interface 'statemgmt.HashableState'
 func (stateTrie *StateTrie) GetStateSnapshotIterator(snapshot *gorocksdb.Snapshot) (statemgmt.StateSnapshotIterator, error) {
-	return newStateSnapshotIterator(snapshot)
+	return newStateSnapshotIterator(stateTrie.openchainDB, snapshot)
 }
 
 // GetRangeScanIterator returns an iterator for performing a range scan between the start and end keys
 func (stateTrie *StateTrie) GetRangeScanIterator(chaincodeID string, startKey string, endKey string) (statemgmt.RangeScanIterator, error) {
-	return newRangeScanIterator(chaincodeID, startKey, endKey)
+	return newRangeScanIterator(stateTrie.openchainDB, chaincodeID, startKey, endKey)
 }
@@ -18,9 +18,8 @@ package trie
 
 import "github.com/hyperledger/fabric/core/db"
 
-func fetchTrieNodeFromDB(key *trieKey) (*trieNode, error) {
+func fetchTrieNodeFromDB(openchainDB *db.OpenchainDB, key *trieKey) (*trieNode, error) {
 	stateTrieLogger.Debug("Enter fetchTrieNodeFromDB() for trieKey [%s]", key)
-	openchainDB := db.GetDBHandle()
 	trieNodeBytes, err := openchainDB.GetFromStateCF(key.getEncodedBytes())
 	if err != nil {
 		stateTrieLogger.Error("Error in retrieving trie node from DB for triekey [%s]. Error:%s", key, err)
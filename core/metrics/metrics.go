@@ -0,0 +1,278 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics provides counters, gauges and histograms which are exposed over HTTP
+// in the Prometheus text exposition format
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Labels distinguish metrics sharing the same name, such as the metrics of
+// different consensus replicas
+type Labels map[string]string
+
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%s", k, strconv.Quote(l[k]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// with returns a copy of the labels with key set to value
+func (l Labels) with(key, value string) Labels {
+	copied := make(Labels, len(l)+1)
+	for k, v := range l {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
+
+// Counter is a metric which only goes up
+type Counter struct {
+	value uint64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add increments the counter by delta
+func (c *Counter) Add(delta uint64) {
+	atomic.AddUint64(&c.value, delta)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+func (c *Counter) write(w io.Writer, name string, labels Labels) {
+	fmt.Fprintf(w, "%s%s %d\n", name, labels, c.Value())
+}
+
+// Gauge is a metric which may go up and down
+type Gauge struct {
+	bits uint64
+}
+
+// Set sets the gauge to value
+func (g *Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(value))
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func (g *Gauge) write(w io.Writer, name string, labels Labels) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, strconv.FormatFloat(g.Value(), 'g', -1, 64))
+}
+
+// LatencyBuckets are upper bounds, in seconds, of histogram buckets suited to
+// latencies from a millisecond to ten seconds
+var LatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram is a metric which counts observations into buckets by value
+type Histogram struct {
+	lock   sync.Mutex
+	bounds []float64 // upper bounds of the buckets, ascending
+	counts []uint64  // observations of each bucket, the last one counts those above all bounds
+	sum    float64
+	count  uint64
+}
+
+// Observe adds value to the histogram
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.bounds, value)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.counts[i]++
+	h.sum += value
+	h.count++
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.count
+}
+
+// Sum returns the sum of the observed values
+func (h *Histogram) Sum() float64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.sum
+}
+
+func (h *Histogram) write(w io.Writer, name string, labels Labels) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels.with("le", strconv.FormatFloat(bound, 'g', -1, 64)), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels.with("le", "+Inf"), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+type metric interface {
+	write(w io.Writer, name string, labels Labels)
+}
+
+type family struct {
+	help    string
+	kind    string
+	metrics map[string]metric // keyed by the rendered labels
+	labels  map[string]Labels
+}
+
+// Registry holds metrics by name and labels, and serves them over HTTP
+type Registry struct {
+	lock     sync.RWMutex
+	families map[string]*family
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// DefaultRegistry is the registry the peer serves its metrics from
+var DefaultRegistry = NewRegistry()
+
+// register adds m under name and labels, replacing any metric previously
+// registered under them
+func (r *Registry) register(name, help, kind string, labels Labels, m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{help: help, kind: kind, metrics: make(map[string]metric), labels: make(map[string]Labels)}
+		r.families[name] = f
+	} else if f.kind != kind {
+		panic(fmt.Errorf("Metric %s is already registered as a %s", name, f.kind))
+	}
+	key := labels.String()
+	f.metrics[key] = m
+	f.labels[key] = labels
+}
+
+// NewCounter creates a counter and registers it under name and labels
+func (r *Registry) NewCounter(name, help string, labels Labels) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", labels, c)
+	return c
+}
+
+// NewGauge creates a gauge and registers it under name and labels
+func (r *Registry) NewGauge(name, help string, labels Labels) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", labels, g)
+	return g
+}
+
+// NewHistogram creates a histogram with buckets bounded by bounds, which must
+// be ascending, and registers it under name and labels
+func (r *Registry) NewHistogram(name, help string, labels Labels, bounds []float64) *Histogram {
+	if !sort.Float64sAreSorted(bounds) {
+		panic(fmt.Errorf("Bucket bounds of histogram %s are not ascending: %v", name, bounds))
+	}
+	h := &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+	r.register(name, help, "histogram", labels, h)
+	return h
+}
+
+// Unregister removes all metrics registered with exactly the given labels
+func (r *Registry) Unregister(labels Labels) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := labels.String()
+	for name, f := range r.families {
+		delete(f.metrics, key)
+		delete(f.labels, key)
+		if len(f.metrics) == 0 {
+			delete(r.families, name)
+		}
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, f.help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, f.kind)
+		keys := make([]string, 0, len(f.metrics))
+		for key := range f.metrics {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			f.metrics[key].write(&buf, name, f.labels[key])
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP implements http.Handler
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
+
+// Handler returns the HTTP handler serving the metrics of DefaultRegistry
+func Handler() http.Handler {
+	return DefaultRegistry
+}
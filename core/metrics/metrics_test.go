@@ -0,0 +1,123 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_events_total", "Events seen", Labels{"replica": "1"})
+	g0 := r.NewGauge("test_view", "Current view", Labels{"replica": "0"})
+	g1 := r.NewGauge("test_view", "Current view", Labels{"replica": "1"})
+
+	c.Inc()
+	c.Add(2)
+	g0.Set(4)
+	g1.Set(0.5)
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	expected := `# HELP test_events_total Events seen
+# TYPE test_events_total counter
+test_events_total{replica="1"} 3
+# HELP test_view Current view
+# TYPE test_view gauge
+test_view{replica="0"} 4
+test_view{replica="1"} 0.5
+`
+	if buf.String() != expected {
+		t.Errorf("Expected exposition:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("test_latency_seconds", "Latency", Labels{"replica": "1"}, []float64{0.1, 1})
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		h.Observe(v)
+	}
+
+	if h.Count() != 4 || h.Sum() != 2.65 {
+		t.Errorf("Expected 4 observations summing to 2.65, got %d summing to %v", h.Count(), h.Sum())
+	}
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	expected := `# HELP test_latency_seconds Latency
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1",replica="1"} 2
+test_latency_seconds_bucket{le="1",replica="1"} 3
+test_latency_seconds_bucket{le="+Inf",replica="1"} 4
+test_latency_seconds_sum{replica="1"} 2.65
+test_latency_seconds_count{replica="1"} 4
+`
+	if buf.String() != expected {
+		t.Errorf("Expected exposition:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestRegistryUnregister(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("test_view", "Current view", Labels{"replica": "0"})
+	r.NewGauge("test_view", "Current view", Labels{"replica": "1"})
+	r.NewCounter("test_events_total", "Events seen", Labels{"replica": "1"})
+
+	r.Unregister(Labels{"replica": "1"})
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	expected := `# HELP test_view Current view
+# TYPE test_view gauge
+test_view{replica="0"} 0
+`
+	if buf.String() != expected {
+		t.Errorf("Expected exposition:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestRegistryKindMismatch(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("test_metric", "A gauge", nil)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Registering a counter under the name of a gauge should panic")
+		}
+	}()
+	r.NewCounter("test_metric", "A counter", nil)
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_events_total", "Events seen", nil).Inc()
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatalf("Could not create request: %s", err)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("Unexpected content type %s", ct)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("test_events_total 1\n")) {
+		t.Errorf("Expected the counter in the response, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,397 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+const (
+	defaultConsensusStreamBuffer    = 1000
+	defaultConsensusStreamKeepalive = 5 * time.Second
+	defaultConsensusStreamTimeout   = 15 * time.Second
+	defaultConsensusStreamReconnect = time.Second
+)
+
+// consensusStreamConfig configures the ConsensusStreams of a peer
+type consensusStreamConfig struct {
+	enabled   bool
+	buffer    int           // frames queued for sending before sends are rejected
+	keepalive time.Duration // idle time after which a keepalive frame is sent
+	timeout   time.Duration // time without any frame after which the stream is closed
+	reconnect time.Duration // delay before redialing a stream which ended
+}
+
+func loadConsensusStreamConfig() consensusStreamConfig {
+	config := consensusStreamConfig{
+		enabled:   viper.GetBool("peer.validator.consensus.stream.enabled"),
+		buffer:    viper.GetInt("peer.validator.consensus.stream.buffersize"),
+		keepalive: viper.GetDuration("peer.validator.consensus.stream.keepalive"),
+		timeout:   viper.GetDuration("peer.validator.consensus.stream.timeout"),
+		reconnect: viper.GetDuration("peer.validator.consensus.stream.reconnect"),
+	}
+	if config.buffer <= 0 {
+		config.buffer = defaultConsensusStreamBuffer
+	}
+	if config.keepalive <= 0 {
+		config.keepalive = defaultConsensusStreamKeepalive
+	}
+	if config.timeout <= config.keepalive {
+		config.timeout = 3 * config.keepalive
+	}
+	if config.reconnect <= 0 {
+		config.reconnect = defaultConsensusStreamReconnect
+	}
+	return config
+}
+
+// consensusFrameStream is either side of a ConsensusStream
+type consensusFrameStream interface {
+	Send(*pb.ConsensusFrame) error
+	Recv() (*pb.ConsensusFrame, error)
+}
+
+// consensusStream carries the consensus messages exchanged with a single
+// peer. Messages are queued, and sent in order by a single goroutine, so
+// that senders neither wait for the network nor for each other
+type consensusStream struct {
+	stream   consensusFrameStream
+	config   consensusStreamConfig
+	out      chan *pb.Message
+	done     chan struct{}
+	once     sync.Once
+	sent     uint64 // number of the last frame sent
+	received uint64 // number of the last frame received
+}
+
+func newConsensusStream(stream consensusFrameStream, config consensusStreamConfig) *consensusStream {
+	return &consensusStream{
+		stream: stream,
+		config: config,
+		out:    make(chan *pb.Message, config.buffer),
+		done:   make(chan struct{}),
+	}
+}
+
+// handshake exchanges the hellos of both peers as the first frames of the
+// stream, the peer which initiated the stream sends first. It returns the
+// endpoint of the remote peer, as verified by verify
+func (cs *consensusStream) handshake(hello *pb.Message, initiator bool, verify func(*pb.Message) (*pb.PeerEndpoint, error)) (*pb.PeerEndpoint, error) {
+	if initiator {
+		if err := cs.sendFrame(hello); err != nil {
+			return nil, err
+		}
+	}
+	frame, err := cs.stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("Error receiving hello: %s", err)
+	}
+	if frame.SeqNo != 1 {
+		return nil, fmt.Errorf("Expected hello as frame 1, got frame %d", frame.SeqNo)
+	}
+	cs.received = 1
+	endpoint, err := verify(frame.Message)
+	if err != nil {
+		return nil, err
+	}
+	if !initiator {
+		if err := cs.sendFrame(hello); err != nil {
+			return nil, err
+		}
+	}
+	return endpoint, nil
+}
+
+func (cs *consensusStream) sendFrame(msg *pb.Message) error {
+	cs.sent++
+	if err := cs.stream.Send(&pb.ConsensusFrame{SeqNo: cs.sent, Message: msg}); err != nil {
+		return fmt.Errorf("Error sending frame %d: %s", cs.sent, err)
+	}
+	return nil
+}
+
+// send queues msg for sending, it fails rather than block if the queue is full
+func (cs *consensusStream) send(msg *pb.Message) error {
+	select {
+	case <-cs.done:
+		return fmt.Errorf("Consensus stream closed")
+	default:
+	}
+	select {
+	case cs.out <- msg:
+		return nil
+	default:
+		return fmt.Errorf("Consensus stream queue full, rejecting")
+	}
+}
+
+func (cs *consensusStream) close() {
+	cs.once.Do(func() { close(cs.done) })
+}
+
+// run sends the queued messages, and keepalives while there are none, and
+// passes the received messages to deliver, until the stream fails, the
+// remote peer falls silent, or the stream is closed
+func (cs *consensusStream) run(deliver func(*pb.Message) error) error {
+	defer cs.close()
+
+	errs := make(chan error, 2)
+	frames := make(chan *pb.ConsensusFrame)
+	go cs.sendLoop(errs)
+	go func() {
+		for {
+			frame, err := cs.stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case frames <- frame:
+			case <-cs.done:
+				return
+			}
+		}
+	}()
+
+	timeout := time.NewTimer(cs.config.timeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case frame := <-frames:
+			timeout.Reset(cs.config.timeout)
+			if frame.SeqNo == 0 {
+				continue
+			}
+			if frame.SeqNo != cs.received+1 {
+				return fmt.Errorf("Expected frame %d, got frame %d", cs.received+1, frame.SeqNo)
+			}
+			cs.received = frame.SeqNo
+			if frame.Message == nil || frame.Message.Type != pb.Message_CONSENSUS {
+				return fmt.Errorf("Frame %d does not carry a consensus message", frame.SeqNo)
+			}
+			if err := deliver(frame.Message); err != nil {
+				peerLogger.Error(fmt.Sprintf("Error handling message: %s", err))
+			}
+		case err := <-errs:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-timeout.C:
+			return fmt.Errorf("No frame received for %v", cs.config.timeout)
+		case <-cs.done:
+			return nil
+		}
+	}
+}
+
+func (cs *consensusStream) sendLoop(errs chan<- error) {
+	keepalive := time.NewTimer(cs.config.keepalive)
+	defer keepalive.Stop()
+	for {
+		var err error
+		select {
+		case msg := <-cs.out:
+			err = cs.sendFrame(msg)
+		case <-keepalive.C:
+			err = cs.stream.Send(&pb.ConsensusFrame{})
+		case <-cs.done:
+			return
+		}
+		if err != nil {
+			errs <- err
+			return
+		}
+		keepalive.Reset(cs.config.keepalive)
+	}
+}
+
+// consensusStreams holds the established consensus streams by peer, its
+// zero value is ready for use
+type consensusStreams struct {
+	sync.RWMutex
+	m map[pb.PeerID]*consensusStream
+}
+
+// get returns the stream to send msg to the peer over, nil if msg is not a
+// consensus message or there is no stream to the peer
+func (css *consensusStreams) get(id pb.PeerID, msg *pb.Message) *consensusStream {
+	if msg.Type != pb.Message_CONSENSUS {
+		return nil
+	}
+	css.RLock()
+	defer css.RUnlock()
+	return css.m[id]
+}
+
+// add makes cs the stream to the peer, closing any previous one
+func (css *consensusStreams) add(id pb.PeerID, cs *consensusStream) {
+	css.Lock()
+	defer css.Unlock()
+	if css.m == nil {
+		css.m = make(map[pb.PeerID]*consensusStream)
+	}
+	if old, ok := css.m[id]; ok {
+		old.close()
+	}
+	css.m[id] = cs
+}
+
+// remove forgets cs, unless it was replaced already
+func (css *consensusStreams) remove(id pb.PeerID, cs *consensusStream) {
+	css.Lock()
+	defer css.Unlock()
+	if css.m[id] == cs {
+		delete(css.m, id)
+	}
+}
+
+// closePeer closes the stream to the peer, if any
+func (css *consensusStreams) closePeer(id pb.PeerID) {
+	css.Lock()
+	defer css.Unlock()
+	if cs, ok := css.m[id]; ok {
+		cs.close()
+		delete(css.m, id)
+	}
+}
+
+// ConsensusStream implementation of the ConsensusStream bidi streaming RPC function
+func (p *PeerImpl) ConsensusStream(stream pb.Peer_ConsensusStreamServer) error {
+	config := loadConsensusStreamConfig()
+	if !p.isValidator || !config.enabled {
+		return fmt.Errorf("Peer does not accept consensus streams")
+	}
+	hello, err := p.NewOpenchainDiscoveryHello()
+	if err != nil {
+		return err
+	}
+	cs := newConsensusStream(stream, config)
+	remote, err := cs.handshake(hello, false, p.verifyConsensusHello)
+	if err != nil {
+		return fmt.Errorf("Error establishing consensus stream: %s", err)
+	}
+	if ct, err := GetConsensusTLS(); err != nil {
+		return err
+	} else if ct != nil {
+		if err := ct.checkIdentity(stream.Context(), remote.ID.Name); err != nil {
+			cs.close()
+			return fmt.Errorf("Error establishing consensus stream: %s", err)
+		}
+	}
+	return p.serveConsensusStream(remote, cs)
+}
+
+// verifyConsensusHello returns the endpoint of the peer which sent the hello
+// opening a ConsensusStream
+func (p *PeerImpl) verifyConsensusHello(msg *pb.Message) (*pb.PeerEndpoint, error) {
+	if msg == nil || msg.Type != pb.Message_DISC_HELLO {
+		return nil, fmt.Errorf("Expected %s as first message", pb.Message_DISC_HELLO)
+	}
+	helloMessage := &pb.HelloMessage{}
+	if err := proto.Unmarshal(msg.Payload, helloMessage); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling HelloMessage: %s", err)
+	}
+	if helloMessage.PeerEndpoint == nil || helloMessage.PeerEndpoint.ID == nil {
+		return nil, fmt.Errorf("HelloMessage lacks the peer endpoint")
+	}
+	if SecurityEnabled() {
+		if err := p.GetSecHelper().Verify(helloMessage.PeerEndpoint.PkiID, msg.Signature, msg.Payload); err != nil {
+			return nil, fmt.Errorf("Error Verifying signature for received HelloMessage: %s", err)
+		}
+	}
+	return helloMessage.PeerEndpoint, nil
+}
+
+// serveConsensusStream sends the consensus messages for the remote peer over
+// cs, and passes the messages received to its message handler, until the
+// stream ends
+func (p *PeerImpl) serveConsensusStream(remote *pb.PeerEndpoint, cs *consensusStream) error {
+	p.consensusStreams.add(*remote.ID, cs)
+	defer p.consensusStreams.remove(*remote.ID, cs)
+	peerLogger.Debug("Established consensus stream with %s", remote.ID.Name)
+
+	return cs.run(func(msg *pb.Message) error {
+		handler, err := p.getMessageHandler(remote.ID)
+		if err != nil {
+			return err
+		}
+		return handler.HandleMessage(msg)
+	})
+}
+
+// maintainConsensusStream keeps a consensus stream to the validator open
+// while the peer chats with it. Of two validators, the one with the lower
+// name dials
+func (p *PeerImpl) maintainConsensusStream(remote *pb.PeerEndpoint) {
+	config := loadConsensusStreamConfig()
+	if !p.isValidator || !config.enabled || remote.Type != pb.PeerEndpoint_VALIDATOR {
+		return
+	}
+	self, err := p.GetPeerEndpoint()
+	if err != nil || self.ID.Name >= remote.ID.Name {
+		return
+	}
+	go func() {
+		for {
+			if _, err := p.getMessageHandler(remote.ID); err != nil {
+				return
+			}
+			if err := p.dialConsensusStream(remote, config); err != nil {
+				peerLogger.Warning("Consensus stream with %s ended: %s", remote.ID.Name, err)
+			}
+			time.Sleep(config.reconnect)
+		}
+	}()
+}
+
+func (p *PeerImpl) dialConsensusStream(remote *pb.PeerEndpoint, config consensusStreamConfig) error {
+	address := p.endpoints.resolve(remote.Address)
+	conn, err := newValidatorClientConnection(address, remote.ID.Name)
+	if err != nil {
+		return fmt.Errorf("Error connecting to %s: %s", address, err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := pb.NewPeerClient(conn).ConsensusStream(ctx)
+	if err != nil {
+		return fmt.Errorf("Error opening consensus stream to %s: %s", address, err)
+	}
+	hello, err := p.NewOpenchainDiscoveryHello()
+	if err != nil {
+		return err
+	}
+	cs := newConsensusStream(stream, config)
+	endpoint, err := cs.handshake(hello, true, p.verifyConsensusHello)
+	if err != nil {
+		return err
+	}
+	if endpoint.ID.Name != remote.ID.Name {
+		return fmt.Errorf("Peer at %s identifies as %s, expected %s", address, endpoint.ID.Name, remote.ID.Name)
+	}
+	return p.serveConsensusStream(endpoint, cs)
+}
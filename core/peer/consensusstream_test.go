@@ -0,0 +1,213 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// pipeFrameStream is one end of an in-memory ConsensusStream
+type pipeFrameStream struct {
+	in     chan *pb.ConsensusFrame
+	out    chan *pb.ConsensusFrame
+	closed chan struct{}
+	sent   []*pb.ConsensusFrame
+}
+
+func newFramePipe() (*pipeFrameStream, *pipeFrameStream) {
+	ab := make(chan *pb.ConsensusFrame, 100)
+	ba := make(chan *pb.ConsensusFrame, 100)
+	closed := make(chan struct{})
+	return &pipeFrameStream{in: ba, out: ab, closed: closed}, &pipeFrameStream{in: ab, out: ba, closed: closed}
+}
+
+func (ps *pipeFrameStream) Send(frame *pb.ConsensusFrame) error {
+	select {
+	case ps.out <- frame:
+		return nil
+	case <-ps.closed:
+		return io.EOF
+	}
+}
+
+func (ps *pipeFrameStream) Recv() (*pb.ConsensusFrame, error) {
+	select {
+	case frame := <-ps.in:
+		return frame, nil
+	case <-ps.closed:
+		return nil, io.EOF
+	}
+}
+
+func testStreamConfig() consensusStreamConfig {
+	return consensusStreamConfig{enabled: true, buffer: 10, keepalive: 10 * time.Millisecond, timeout: 100 * time.Millisecond}
+}
+
+func helloFrom(name string) *pb.Message {
+	return &pb.Message{Type: pb.Message_DISC_HELLO, Payload: []byte(name)}
+}
+
+func verifyTestHello(msg *pb.Message) (*pb.PeerEndpoint, error) {
+	if msg == nil || msg.Type != pb.Message_DISC_HELLO {
+		return nil, fmt.Errorf("expected hello, got %v", msg)
+	}
+	return &pb.PeerEndpoint{ID: &pb.PeerID{Name: string(msg.Payload)}}, nil
+}
+
+// connectStreams establishes a consensus stream between vp0 and vp1
+func connectStreams(t *testing.T) (*consensusStream, *consensusStream) {
+	a, b := newFramePipe()
+	csA := newConsensusStream(a, testStreamConfig())
+	csB := newConsensusStream(b, testStreamConfig())
+	remoteA := make(chan *pb.PeerEndpoint)
+	go func() {
+		ep, err := csB.handshake(helloFrom("vp1"), false, verifyTestHello)
+		if err != nil {
+			t.Errorf("Accepting side failed the handshake: %s", err)
+		}
+		remoteA <- ep
+	}()
+	remoteB, err := csA.handshake(helloFrom("vp0"), true, verifyTestHello)
+	if err != nil {
+		t.Fatalf("Dialing side failed the handshake: %s", err)
+	}
+	if ep := <-remoteA; ep == nil || ep.ID.Name != "vp0" || remoteB.ID.Name != "vp1" {
+		t.Fatalf("Expected the peers to learn each other's names, got %v and %v", ep, remoteB)
+	}
+	return csA, csB
+}
+
+func TestConsensusStreamOrder(t *testing.T) {
+	csA, csB := connectStreams(t)
+	delivered := make(chan *pb.Message, 100)
+	go csA.run(func(*pb.Message) error { return nil })
+	go csB.run(func(msg *pb.Message) error {
+		delivered <- msg
+		return nil
+	})
+	defer csA.close()
+	defer csB.close()
+
+	for i := 0; i < 50; i++ {
+		msg := &pb.Message{Type: pb.Message_CONSENSUS, Payload: []byte{byte(i)}}
+		for csA.send(msg) != nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		select {
+		case msg := <-delivered:
+			if msg.Payload[0] != byte(i) {
+				t.Fatalf("Expected message %d, got message %d", i, msg.Payload[0])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for message %d", i)
+		}
+	}
+}
+
+func TestConsensusStreamKeepalive(t *testing.T) {
+	csA, csB := connectStreams(t)
+	resultA := make(chan error, 1)
+	resultB := make(chan error, 1)
+	go func() { resultA <- csA.run(func(*pb.Message) error { return nil }) }()
+	go func() { resultB <- csB.run(func(*pb.Message) error { return nil }) }()
+
+	select {
+	case err := <-resultB:
+		t.Fatalf("Expected keepalives to hold the idle stream open, it ended: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// Silence vp0 without closing its stream, vp1 must give up on it
+	csA.close()
+	select {
+	case err := <-resultB:
+		if err == nil {
+			t.Errorf("Expected the stream to time out")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the silent stream to be closed")
+	}
+	<-resultA
+}
+
+func TestConsensusStreamRejectsGaps(t *testing.T) {
+	a, b := newFramePipe()
+	cs := newConsensusStream(b, testStreamConfig())
+	cs.received = 1
+	result := make(chan error, 1)
+	go func() { result <- cs.run(func(*pb.Message) error { return nil }) }()
+
+	a.Send(&pb.ConsensusFrame{SeqNo: 3, Message: &pb.Message{Type: pb.Message_CONSENSUS}})
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Errorf("Expected a missing frame to end the stream with an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a missing frame to end the stream")
+	}
+	if err := cs.send(&pb.Message{Type: pb.Message_CONSENSUS}); err == nil {
+		t.Errorf("Expected sends on a closed stream to fail")
+	}
+}
+
+func TestConsensusStreamQueueFull(t *testing.T) {
+	_, b := newFramePipe()
+	cs := newConsensusStream(b, testStreamConfig())
+	msg := &pb.Message{Type: pb.Message_CONSENSUS}
+	for i := 0; i < testStreamConfig().buffer; i++ {
+		if err := cs.send(msg); err != nil {
+			t.Fatalf("Expected message %d to be queued: %s", i, err)
+		}
+	}
+	if err := cs.send(msg); err == nil {
+		t.Errorf("Expected sends to be rejected once the queue is full")
+	}
+}
+
+func TestConsensusStreamsOnlyCarryConsensus(t *testing.T) {
+	var css consensusStreams
+	id := pb.PeerID{Name: "vp1"}
+	_, b := newFramePipe()
+	cs := newConsensusStream(b, testStreamConfig())
+	css.add(id, cs)
+	if css.get(id, &pb.Message{Type: pb.Message_DISC_GET_PEERS}) != nil {
+		t.Errorf("Expected discovery messages to stay on the chat stream")
+	}
+	if css.get(id, &pb.Message{Type: pb.Message_CONSENSUS}) != cs {
+		t.Errorf("Expected consensus messages to use the consensus stream")
+	}
+
+	replacement := newConsensusStream(b, testStreamConfig())
+	css.add(id, replacement)
+	css.remove(id, cs)
+	if css.get(id, &pb.Message{Type: pb.Message_CONSENSUS}) != replacement {
+		t.Errorf("Expected removing a replaced stream to keep its replacement")
+	}
+	select {
+	case <-cs.done:
+	default:
+		t.Errorf("Expected the replaced stream to be closed")
+	}
+}
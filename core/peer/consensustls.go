@@ -0,0 +1,277 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/hyperledger/fabric/core/comm"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// ConsensusTLS holds the certificates validators authenticate each other
+// with. Every validator presents a certificate issued by the validator CA,
+// naming the peer id it enrolled under as common name, both when it dials
+// and when it is dialed. The certificates are read again whenever their
+// files change, so that they are renewed without restarting the peer
+type ConsensusTLS struct {
+	certFile string
+	keyFile  string
+	rootFile string
+
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	roots    *x509.CertPool
+	modTimes map[string]time.Time // of the files last loaded
+}
+
+var consensusTLS struct {
+	once sync.Once
+	ct   *ConsensusTLS
+	err  error
+}
+
+// GetConsensusTLS returns the consensus TLS configuration of the peer, it
+// returns nil if peer.validator.consensus.tls is disabled, or if the peer is
+// not a validator
+func GetConsensusTLS() (*ConsensusTLS, error) {
+	consensusTLS.once.Do(func() {
+		if !ValidatorEnabled() || !viper.GetBool("peer.validator.consensus.tls.enabled") {
+			return
+		}
+		if !comm.TLSEnabled() {
+			consensusTLS.err = errors.New("peer.validator.consensus.tls requires peer.tls.enabled, as the peer serves all its clients over TLS")
+			return
+		}
+		ct, err := newConsensusTLS(
+			viper.GetString("peer.validator.consensus.tls.cert.file"),
+			viper.GetString("peer.validator.consensus.tls.key.file"),
+			viper.GetString("peer.validator.consensus.tls.rootcert.file"))
+		if err != nil {
+			consensusTLS.err = err
+			return
+		}
+		if reload := viper.GetDuration("peer.validator.consensus.tls.reload"); reload > 0 {
+			go ct.watch(reload)
+		}
+		consensusTLS.ct = ct
+	})
+	return consensusTLS.ct, consensusTLS.err
+}
+
+func newConsensusTLS(certFile, keyFile, rootFile string) (*ConsensusTLS, error) {
+	ct := &ConsensusTLS{
+		certFile: certFile,
+		keyFile:  keyFile,
+		rootFile: rootFile,
+	}
+	if _, err := ct.reload(); err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+// reload reads the certificates again if any of their files changed since
+// they were loaded, and returns whether they did. The certificates in use
+// are kept if the new ones cannot be read
+func (ct *ConsensusTLS) reload() (bool, error) {
+	modTimes := make(map[string]time.Time)
+	changed := false
+	for _, file := range []string{ct.certFile, ct.keyFile, ct.rootFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, fmt.Errorf("Error reading consensus TLS file: %s", err)
+		}
+		modTimes[file] = info.ModTime()
+		changed = changed || !info.ModTime().Equal(ct.modTimes[file])
+	}
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(ct.certFile, ct.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("Error loading consensus TLS certificate: %s", err)
+	}
+	rootPEM, err := ioutil.ReadFile(ct.rootFile)
+	if err != nil {
+		return false, fmt.Errorf("Error reading consensus TLS root certificate: %s", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootPEM) {
+		return false, fmt.Errorf("No certificate found in consensus TLS root certificate file %s", ct.rootFile)
+	}
+
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	ct.cert = &cert
+	ct.roots = roots
+	ct.modTimes = modTimes
+	return true, nil
+}
+
+// watch reloads the certificates every period
+func (ct *ConsensusTLS) watch(period time.Duration) {
+	for range time.Tick(period) {
+		if reloaded, err := ct.reload(); err != nil {
+			peerLogger.Error("Keeping the current consensus TLS certificates: %s", err)
+		} else if reloaded {
+			peerLogger.Info("Reloaded the consensus TLS certificates")
+		}
+	}
+}
+
+func (ct *ConsensusTLS) certificate() *tls.Certificate {
+	ct.lock.RLock()
+	defer ct.lock.RUnlock()
+	return ct.cert
+}
+
+// verify checks that the certificate chain was issued by the validator CA,
+// and, unless name is empty, that it names the peer id name
+func (ct *ConsensusTLS) verify(rawCerts [][]byte, name string) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no certificate presented")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("invalid certificate: %s", err)
+		}
+		certs[i] = cert
+	}
+	ct.lock.RLock()
+	roots := ct.roots
+	ct.lock.RUnlock()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("certificate not issued by the validator CA: %s", err)
+	}
+	if name != "" && certs[0].Subject.CommonName != name {
+		return fmt.Errorf("certificate of %s presented by %s", certs[0].Subject.CommonName, name)
+	}
+	return nil
+}
+
+// ServerCredentials returns the credentials the peer serves its clients
+// with. Clients may present a certificate of the validator CA, which they
+// must to act as validators, other clients are accepted without one
+func (ct *ConsensusTLS) ServerCredentials() credentials.TransportAuthenticator {
+	return credentials.NewTLS(&tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return ct.certificate(), nil
+		},
+		ClientAuth: tls.RequestClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return nil
+			}
+			return ct.verify(rawCerts, "")
+		},
+	})
+}
+
+// clientCredentials returns the credentials a validator dials another
+// validator with, the peer id of which is name, if known
+func (ct *ConsensusTLS) clientCredentials(name string) credentials.TransportAuthenticator {
+	return credentials.NewTLS(&tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return ct.certificate(), nil
+		},
+		// Validators are identified by the certificate, not by their address
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return ct.verify(rawCerts, name)
+		},
+	})
+}
+
+// checkIdentity verifies that the validator with the peer id name proved
+// its identity with a certificate of the validator CA naming it, when it
+// dialed this peer over ctx. The certificate itself was verified during the
+// TLS handshake
+func (ct *ConsensusTLS) checkIdentity(ctx context.Context, name string) error {
+	authInfo, ok := credentials.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("Validator %s connected without TLS", name)
+	}
+	info, ok := authInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return fmt.Errorf("Validator %s connected without a client certificate", name)
+	}
+	if cn := info.State.PeerCertificates[0].Subject.CommonName; cn != name {
+		return fmt.Errorf("Validator %s connected with the certificate of %s", name, cn)
+	}
+	return nil
+}
+
+// checkChatIdentity checks the identity of a peer which dialed this peer
+// against the hello it opened its chat stream with, if it claims to be a
+// validator
+func checkChatIdentity(ctx context.Context, msg *pb.Message) error {
+	ct, err := GetConsensusTLS()
+	if err != nil || ct == nil || msg.Type != pb.Message_DISC_HELLO {
+		return err
+	}
+	helloMessage := &pb.HelloMessage{}
+	if err := proto.Unmarshal(msg.Payload, helloMessage); err != nil {
+		return fmt.Errorf("Error unmarshalling HelloMessage: %s", err)
+	}
+	if helloMessage.PeerEndpoint == nil || helloMessage.PeerEndpoint.ID == nil {
+		return fmt.Errorf("HelloMessage lacks the peer endpoint")
+	}
+	if helloMessage.PeerEndpoint.Type != pb.PeerEndpoint_VALIDATOR {
+		return nil
+	}
+	return ct.checkIdentity(ctx, helloMessage.PeerEndpoint.ID.Name)
+}
+
+// newValidatorClientConnection dials another validator, presenting the
+// consensus TLS certificate of this peer if enabled. The peer id of the
+// validator is name, or empty if not known yet
+func newValidatorClientConnection(address string, name string) (*grpc.ClientConn, error) {
+	ct, err := GetConsensusTLS()
+	if err != nil {
+		return nil, err
+	}
+	if ct == nil {
+		return NewPeerClientConnectionWithAddress(address)
+	}
+	return comm.NewClientConnectionWithAddress(address, true, true, ct.clientCredentials(name))
+}
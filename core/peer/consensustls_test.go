@@ -0,0 +1,197 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "validator CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, der: der}
+}
+
+// issue returns the DER certificate and PEM encoded certificate and key of the validator name
+func (ca *testCA) issue(t *testing.T, name string, serial int64) ([]byte, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeTestFile(t *testing.T, file string, data []byte, modTime time.Time) {
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsensusTLSVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consensustls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	der, certPEM, keyPEM := ca.issue(t, "vp1", 2)
+	certFile, keyFile, rootFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "root.pem")
+	modTime := time.Now().Add(-time.Minute)
+	writeTestFile(t, certFile, certPEM, modTime)
+	writeTestFile(t, keyFile, keyPEM, modTime)
+	writeTestFile(t, rootFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.der}), modTime)
+
+	ct, err := newConsensusTLS(certFile, keyFile, rootFile)
+	if err != nil {
+		t.Fatalf("Could not load the consensus TLS certificates: %s", err)
+	}
+	if err := ct.verify([][]byte{der}, "vp1"); err != nil {
+		t.Errorf("Expected the certificate of vp1 to verify: %s", err)
+	}
+	if err := ct.verify([][]byte{der}, ""); err != nil {
+		t.Errorf("Expected the certificate of vp1 to verify for an unknown peer: %s", err)
+	}
+	if err := ct.verify([][]byte{der}, "vp2"); err == nil {
+		t.Errorf("Expected the certificate of vp1 to be rejected for vp2")
+	}
+	foreign, _, _ := newTestCA(t).issue(t, "vp1", 2)
+	if err := ct.verify([][]byte{foreign}, "vp1"); err == nil {
+		t.Errorf("Expected a certificate of another CA to be rejected")
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := credentials.NewContext(context.Background(), credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}})
+	if err := ct.checkIdentity(ctx, "vp1"); err != nil {
+		t.Errorf("Expected vp1 to be identified by its certificate: %s", err)
+	}
+	if err := ct.checkIdentity(ctx, "vp2"); err == nil {
+		t.Errorf("Expected a peer presenting the certificate of vp1 not to be identified as vp2")
+	}
+	if err := ct.checkIdentity(context.Background(), "vp1"); err == nil {
+		t.Errorf("Expected a validator connecting without TLS to be rejected")
+	}
+}
+
+func TestConsensusTLSReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consensustls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	_, certPEM, keyPEM := ca.issue(t, "vp1", 2)
+	certFile, keyFile, rootFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "root.pem")
+	modTime := time.Now().Add(-time.Minute)
+	writeTestFile(t, certFile, certPEM, modTime)
+	writeTestFile(t, keyFile, keyPEM, modTime)
+	writeTestFile(t, rootFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.der}), modTime)
+
+	ct, err := newConsensusTLS(certFile, keyFile, rootFile)
+	if err != nil {
+		t.Fatalf("Could not load the consensus TLS certificates: %s", err)
+	}
+	if reloaded, err := ct.reload(); reloaded || err != nil {
+		t.Errorf("Expected unchanged files not to be reloaded, got %v, %v", reloaded, err)
+	}
+
+	// A half written renewal keeps the current certificate
+	writeTestFile(t, certFile, []byte("garbage"), modTime.Add(time.Second))
+	if _, err := ct.reload(); err == nil {
+		t.Errorf("Expected an unreadable certificate to fail reloading")
+	}
+	if cert, _ := x509.ParseCertificate(ct.certificate().Certificate[0]); cert.SerialNumber.Int64() != 2 {
+		t.Errorf("Expected the current certificate to be kept")
+	}
+
+	renewed, certPEM, keyPEM := ca.issue(t, "vp1", 3)
+	writeTestFile(t, keyFile, keyPEM, modTime.Add(2*time.Second))
+	writeTestFile(t, certFile, certPEM, modTime.Add(2*time.Second))
+	if reloaded, err := ct.reload(); !reloaded || err != nil {
+		t.Fatalf("Expected the renewed certificate to be loaded, got %v, %v", reloaded, err)
+	}
+	if cert, _ := x509.ParseCertificate(ct.certificate().Certificate[0]); cert.SerialNumber.Int64() != 3 {
+		t.Errorf("Expected the renewed certificate to be presented")
+	}
+	if err := ct.verify([][]byte{renewed}, "vp1"); err != nil {
+		t.Errorf("Expected the renewed certificate to verify: %s", err)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/util"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// endpointRecord is the current endpoint of a peer, and the addresses it moved away from
+type endpointRecord struct {
+	endpoint *pb.PeerEndpoint
+	sequence uint64          // of the last endpoint update accepted, 0 if none
+	previous map[string]bool // addresses the peer is no longer reachable at
+}
+
+// endpointTable keeps the address every peer is reachable at, by PeerID, so
+// that a peer which moves to another address, after a DNS change or being
+// rescheduled, keeps its identity. Its PeerID, and the replica ID consensus
+// derives from it, never change
+type endpointTable struct {
+	sync.Mutex
+	m map[pb.PeerID]*endpointRecord
+}
+
+func newEndpointTable() *endpointTable {
+	return &endpointTable{m: make(map[pb.PeerID]*endpointRecord)}
+}
+
+// move records that the peer of record is reachable at address
+func (r *endpointRecord) move(address string) bool {
+	if r.endpoint.Address == address {
+		return false
+	}
+	r.previous[r.endpoint.Address] = true
+	delete(r.previous, address)
+	return true
+}
+
+// learn records the endpoint of a peer which sent a verified hello, it
+// returns whether the peer moved from the address known so far
+func (et *endpointTable) learn(endpoint *pb.PeerEndpoint) bool {
+	et.Lock()
+	defer et.Unlock()
+	r, ok := et.m[*endpoint.ID]
+	if !ok {
+		et.m[*endpoint.ID] = &endpointRecord{endpoint: endpoint, previous: make(map[string]bool)}
+		return false
+	}
+	moved := r.move(endpoint.Address)
+	r.endpoint = endpoint
+	return moved
+}
+
+// get returns the endpoint known for the peer, or nil
+func (et *endpointTable) get(id pb.PeerID) *pb.PeerEndpoint {
+	et.Lock()
+	defer et.Unlock()
+	if r, ok := et.m[id]; ok {
+		return r.endpoint
+	}
+	return nil
+}
+
+// update records the endpoint of an update with the given sequence, it
+// returns false if an update as recent was accepted already, and whether
+// the peer moved
+func (et *endpointTable) update(endpoint *pb.PeerEndpoint, sequence uint64) (accepted bool, moved bool) {
+	et.Lock()
+	defer et.Unlock()
+	r, ok := et.m[*endpoint.ID]
+	if !ok {
+		r = &endpointRecord{endpoint: endpoint, previous: make(map[string]bool)}
+		et.m[*endpoint.ID] = r
+	} else if sequence <= r.sequence {
+		return false, false
+	} else {
+		moved = r.move(endpoint.Address)
+		r.endpoint = endpoint
+	}
+	r.sequence = sequence
+	return true, moved
+}
+
+// resolve returns the address the peer formerly reachable at address moved
+// to, or address if no peer moved away from it
+func (et *endpointTable) resolve(address string) string {
+	et.Lock()
+	defer et.Unlock()
+	for _, r := range et.m {
+		if r.previous[address] {
+			return r.endpoint.Address
+		}
+	}
+	return address
+}
+
+// newEndpointUpdate returns the signed DISC_ENDPOINT_UPDATE message
+// announcing the endpoint of this peer
+func (p *PeerImpl) newEndpointUpdate() (*pb.Message, error) {
+	endpoint, err := p.GetPeerEndpoint()
+	if err != nil {
+		return nil, fmt.Errorf("Error creating endpoint update: %s", err)
+	}
+	data, err := proto.Marshal(&pb.EndpointUpdate{PeerEndpoint: endpoint, Sequence: p.endpointSequence})
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling EndpointUpdate: %s", err)
+	}
+	msg := &pb.Message{Type: pb.Message_DISC_ENDPOINT_UPDATE, Payload: data, Timestamp: util.CreateUtcTimestamp()}
+	if err := p.signMessageMutating(msg); err != nil {
+		return nil, fmt.Errorf("Error signing EndpointUpdate: %s", err)
+	}
+	return msg, nil
+}
+
+// announceEndpoint sends the endpoint of this peer to a peer it started
+// chatting with, which relays it to the peers it chats with, so that the
+// peers still dialing an address this peer moved away from learn the new one
+func (p *PeerImpl) announceEndpoint(messageHandler MessageHandler) {
+	msg, err := p.newEndpointUpdate()
+	if err != nil {
+		peerLogger.Error("Error announcing endpoint: %s", err)
+		return
+	}
+	if err := messageHandler.SendMessage(msg); err != nil {
+		peerLogger.Warning("Error announcing endpoint: %s", err)
+	}
+}
+
+// EndpointUpdated verifies a DISC_ENDPOINT_UPDATE message, records the
+// endpoint it announces and relays it to the other peers. The update must be
+// signed by the peer it announces the endpoint of, with the identity the peer
+// proved in its hello
+func (p *PeerImpl) EndpointUpdated(msg *pb.Message) error {
+	update := &pb.EndpointUpdate{}
+	if err := proto.Unmarshal(msg.Payload, update); err != nil {
+		return fmt.Errorf("Error unmarshalling EndpointUpdate: %s", err)
+	}
+	endpoint := update.PeerEndpoint
+	if endpoint == nil || endpoint.ID == nil || endpoint.Address == "" {
+		return fmt.Errorf("EndpointUpdate lacks the peer endpoint")
+	}
+	if self, err := p.GetPeerEndpoint(); err == nil && *self.ID == *endpoint.ID {
+		return nil
+	}
+	if SecurityEnabled() {
+		known := p.endpoints.get(*endpoint.ID)
+		if known == nil {
+			return fmt.Errorf("Cannot verify endpoint update of unknown peer %s", endpoint.ID.Name)
+		}
+		if !bytes.Equal(known.PkiID, endpoint.PkiID) {
+			return fmt.Errorf("Endpoint update of peer %s carries another identity", endpoint.ID.Name)
+		}
+		if err := p.GetSecHelper().Verify(known.PkiID, msg.Signature, msg.Payload); err != nil {
+			return fmt.Errorf("Error verifying signature of endpoint update of peer %s: %s", endpoint.ID.Name, err)
+		}
+	}
+
+	accepted, moved := p.endpoints.update(endpoint, update.Sequence)
+	if !accepted {
+		return nil
+	}
+	if moved {
+		peerLogger.Info("Peer %s moved to %s", endpoint.ID.Name, endpoint.Address)
+	}
+	for _, err := range p.Broadcast(msg, pb.PeerEndpoint_UNDEFINED) {
+		peerLogger.Warning("Error relaying endpoint update of peer %s: %s", endpoint.ID.Name, err)
+	}
+	if _, err := p.getMessageHandler(endpoint.ID); moved && err != nil {
+		p.chatWithSomePeers([]string{endpoint.Address})
+	}
+	return nil
+}
+
+// newEndpointSequence returns the sequence of the endpoint updates of this
+// peer, which grows with every restart, and so with every move
+func newEndpointSequence() uint64 {
+	return uint64(time.Now().UnixNano())
+}
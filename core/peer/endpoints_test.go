@@ -0,0 +1,77 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func endpointAt(name, address string) *pb.PeerEndpoint {
+	return &pb.PeerEndpoint{ID: &pb.PeerID{Name: name}, Address: address, Type: pb.PeerEndpoint_VALIDATOR}
+}
+
+func TestEndpointTableUpdates(t *testing.T) {
+	et := newEndpointTable()
+	if et.learn(endpointAt("vp1", "10.0.0.1:30303")) {
+		t.Fatalf("Expected the first endpoint of a peer not to be a move")
+	}
+
+	accepted, moved := et.update(endpointAt("vp1", "10.0.0.7:30303"), 5)
+	if !accepted || !moved {
+		t.Fatalf("Expected the update to move vp1, accepted=%v moved=%v", accepted, moved)
+	}
+	if address := et.resolve("10.0.0.1:30303"); address != "10.0.0.7:30303" {
+		t.Errorf("Expected the old address of vp1 to resolve to its new one, got %s", address)
+	}
+	if address := et.resolve("10.0.0.2:30303"); address != "10.0.0.2:30303" {
+		t.Errorf("Expected an address no peer moved away from to resolve to itself, got %s", address)
+	}
+
+	if accepted, _ := et.update(endpointAt("vp1", "10.0.0.1:30303"), 5); accepted {
+		t.Errorf("Expected a replayed update to be ignored")
+	}
+	if accepted, _ := et.update(endpointAt("vp1", "10.0.0.1:30303"), 4); accepted {
+		t.Errorf("Expected an older update to be ignored")
+	}
+	if ep := et.get(pb.PeerID{Name: "vp1"}); ep.Address != "10.0.0.7:30303" {
+		t.Errorf("Expected vp1 to stay at its new address, got %s", ep.Address)
+	}
+
+	// Moving back makes the address current again
+	if _, moved := et.update(endpointAt("vp1", "10.0.0.1:30303"), 6); !moved {
+		t.Fatalf("Expected vp1 to move back")
+	}
+	if address := et.resolve("10.0.0.1:30303"); address != "10.0.0.1:30303" {
+		t.Errorf("Expected the current address of vp1 to resolve to itself, got %s", address)
+	}
+	if address := et.resolve("10.0.0.7:30303"); address != "10.0.0.1:30303" {
+		t.Errorf("Expected the address vp1 left to resolve to its current one, got %s", address)
+	}
+}
+
+func TestEndpointTableLearnsMoves(t *testing.T) {
+	et := newEndpointTable()
+	et.learn(endpointAt("vp2", "vp2.old:30303"))
+	if !et.learn(endpointAt("vp2", "vp2.new:30303")) {
+		t.Fatalf("Expected a hello from another address to be a move")
+	}
+	if address := et.resolve("vp2.old:30303"); address != "vp2.new:30303" {
+		t.Errorf("Expected the old address of vp2 to resolve to its new one, got %s", address)
+	}
+}
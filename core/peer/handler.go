@@ -26,6 +26,8 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
+	"github.com/hyperledger/fabric/core/util"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -65,6 +67,7 @@ func NewPeerHandler(coord MessageHandlerCoordinator, stream ChatStream, initiate
 			{Name: pb.Message_DISC_HELLO.String(), Src: []string{"created"}, Dst: "established"},
 			{Name: pb.Message_DISC_GET_PEERS.String(), Src: []string{"established"}, Dst: "established"},
 			{Name: pb.Message_DISC_PEERS.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_DISC_ENDPOINT_UPDATE.String(), Src: []string{"established"}, Dst: "established"},
 			{Name: pb.Message_SYNC_BLOCK_ADDED.String(), Src: []string{"established"}, Dst: "established"},
 			{Name: pb.Message_SYNC_GET_BLOCKS.String(), Src: []string{"established"}, Dst: "established"},
 			{Name: pb.Message_SYNC_BLOCKS.String(), Src: []string{"established"}, Dst: "established"},
@@ -78,6 +81,7 @@ func NewPeerHandler(coord MessageHandlerCoordinator, stream ChatStream, initiate
 			"before_" + pb.Message_DISC_HELLO.String():              func(e *fsm.Event) { d.beforeHello(e) },
 			"before_" + pb.Message_DISC_GET_PEERS.String():          func(e *fsm.Event) { d.beforeGetPeers(e) },
 			"before_" + pb.Message_DISC_PEERS.String():              func(e *fsm.Event) { d.beforePeers(e) },
+			"before_" + pb.Message_DISC_ENDPOINT_UPDATE.String():    func(e *fsm.Event) { d.beforeEndpointUpdate(e) },
 			"before_" + pb.Message_SYNC_BLOCK_ADDED.String():        func(e *fsm.Event) { d.beforeBlockAdded(e) },
 			"before_" + pb.Message_SYNC_GET_BLOCKS.String():         func(e *fsm.Event) { d.beforeSyncGetBlocks(e) },
 			"before_" + pb.Message_SYNC_BLOCKS.String():             func(e *fsm.Event) { d.beforeSyncBlocks(e) },
@@ -232,6 +236,18 @@ func (d *Handler) beforePeers(e *fsm.Event) {
 
 }
 
+func (d *Handler) beforeEndpointUpdate(e *fsm.Event) {
+	peerLogger.Debug("Received %s, recording peer endpoint", e.Event)
+	msg, ok := e.Args[0].(*pb.Message)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	if err := d.Coordinator.EndpointUpdated(msg); err != nil {
+		e.Cancel(err)
+	}
+}
+
 func (d *Handler) beforeBlockAdded(e *fsm.Event) {
 	peerLogger.Debug("Received message: %s", e.Event)
 	msg, ok := e.Args[0].(*pb.Message)
@@ -476,6 +492,19 @@ func (d *Handler) sendBlocks(syncBlockRange *pb.SyncBlockRange) {
 // RequestStateSnapshot request the state snapshot deltas from the other PeerEndpoint, will provide them through the returned channel.
 // this will also stop writing any received syncStateSnapshot(s) to channels created from Prior calls to RequestStateSnapshot()
 func (d *Handler) RequestStateSnapshot() (<-chan *pb.SyncStateSnapshot, error) {
+	return d.requestStateSnapshot(0, 0)
+}
+
+// RequestStateSnapshotChunks requests the state snapshot from the other PeerEndpoint as Merkle-proved chunks of up to chunkSize deltas,
+// starting with the chunk at index startChunk, and provides them through the returned channel.
+func (d *Handler) RequestStateSnapshotChunks(chunkSize, startChunk uint64) (<-chan *pb.SyncStateSnapshot, error) {
+	if chunkSize == 0 {
+		return nil, fmt.Errorf("Must request a state snapshot with a chunk size greater than 0")
+	}
+	return d.requestStateSnapshot(chunkSize, startChunk)
+}
+
+func (d *Handler) requestStateSnapshot(chunkSize, startChunk uint64) (<-chan *pb.SyncStateSnapshot, error) {
 	d.snapshotRequestHandler.Lock()
 	defer d.snapshotRequestHandler.Unlock()
 	// Reset the handler
@@ -483,6 +512,8 @@ func (d *Handler) RequestStateSnapshot() (<-chan *pb.SyncStateSnapshot, error) {
 
 	// Create the syncStateSnapshotRequest
 	syncStateSnapshotRequest := d.snapshotRequestHandler.createRequest()
+	syncStateSnapshotRequest.ChunkSize = chunkSize
+	syncStateSnapshotRequest.StartChunk = startChunk
 	syncStateSnapshotRequestBytes, err := proto.Marshal(syncStateSnapshotRequest)
 	if err != nil {
 		return nil, fmt.Errorf("Error marshaling syncStateSnapshotRequest during GetStateSnapshot: %s", err)
@@ -567,6 +598,11 @@ func (d *Handler) sendStateSnapshot(syncStateSnapshotRequest *pb.SyncStateSnapsh
 	}
 	defer snapshot.Release()
 
+	if syncStateSnapshotRequest.ChunkSize > 0 {
+		d.sendStateSnapshotChunks(syncStateSnapshotRequest, snapshot)
+		return
+	}
+
 	// Iterate over the state deltas and send to requestor
 	currBlockNumber := snapshot.GetBlockNumber()
 	var sequence uint64
@@ -607,6 +643,74 @@ func (d *Handler) sendStateSnapshot(syncStateSnapshotRequest *pb.SyncStateSnapsh
 
 }
 
+// sendStateSnapshotChunks sends the state snapshot as chunks of up to ChunkSize deltas each, along with the proof of each chunk
+// against the root of the Merkle tree over all chunks, so that the receiver can verify every chunk as it arrives.
+// The chunks are assembled in memory before the first one is sent, as the root must be known to prove any of them
+func (d *Handler) sendStateSnapshotChunks(syncStateSnapshotRequest *pb.SyncStateSnapshotRequest, snapshot *state.StateSnapshot) {
+	currBlockNumber := snapshot.GetBlockNumber()
+
+	var chunks [][]byte
+	var deltas [][]byte
+	for snapshot.Next() {
+		delta := statemgmt.NewStateDelta()
+		k, v := snapshot.GetRawKeyValue()
+		cID, kID := statemgmt.DecodeCompositeKey(k)
+		delta.Set(cID, kID, v, nil)
+		deltas = append(deltas, delta.Marshal())
+
+		if uint64(len(deltas)) == syncStateSnapshotRequest.ChunkSize {
+			chunks = append(chunks, statemgmt.EncodeSnapshotChunk(deltas))
+			deltas = nil
+		}
+	}
+	if len(deltas) > 0 {
+		chunks = append(chunks, statemgmt.EncodeSnapshotChunk(deltas))
+	}
+
+	tree := util.NewMerkleTree(chunks)
+	chunkCount := uint64(len(chunks))
+	peerLogger.Debug("Sending state snapshot for BlockNum = %d as %d chunks from chunk %d, with Merkle root %x", currBlockNumber, chunkCount, syncStateSnapshotRequest.StartChunk, tree.Root())
+
+	for i := syncStateSnapshotRequest.StartChunk; i < chunkCount; i++ {
+		syncStateSnapshot := &pb.SyncStateSnapshot{
+			Delta:       chunks[i],
+			Sequence:    i,
+			BlockNumber: currBlockNumber,
+			Request:     syncStateSnapshotRequest,
+			ChunkCount:  chunkCount,
+			MerkleRoot:  tree.Root(),
+			MerkleProof: tree.Proof(i),
+		}
+		syncStateSnapshotBytes, err := proto.Marshal(syncStateSnapshot)
+		if err != nil {
+			peerLogger.Error(fmt.Sprintf("Error marshalling syncStateSnapsot chunk %d for BlockNum = %d: %s", i, currBlockNumber, err))
+			return
+		}
+		if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_SNAPSHOT, Payload: syncStateSnapshotBytes}); err != nil {
+			peerLogger.Error(fmt.Sprintf("Error sending syncStateSnapsot chunk %d for BlockNum = %d: %s", i, currBlockNumber, err))
+			return
+		}
+	}
+
+	// Now send the terminating message
+	syncStateSnapshot := &pb.SyncStateSnapshot{
+		Delta:       []byte{},
+		Sequence:    chunkCount,
+		BlockNumber: currBlockNumber,
+		Request:     syncStateSnapshotRequest,
+		ChunkCount:  chunkCount,
+		MerkleRoot:  tree.Root(),
+	}
+	syncStateSnapshotBytes, err := proto.Marshal(syncStateSnapshot)
+	if err != nil {
+		peerLogger.Error(fmt.Sprintf("Error marshalling terminating syncStateSnapsot message for correlationId = %d, BlockNum = %d: %s", syncStateSnapshotRequest.CorrelationId, currBlockNumber, err))
+		return
+	}
+	if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_SNAPSHOT, Payload: syncStateSnapshotBytes}); err != nil {
+		peerLogger.Error(fmt.Sprintf("Error sending terminating syncStateSnapsot for correlationId = %d, BlockNum = %d: %s", syncStateSnapshotRequest.CorrelationId, currBlockNumber, err))
+	}
+}
+
 // ----------------------------------------------------------------------------
 //
 //  State sync Deltas functionality
@@ -662,6 +766,11 @@ func (d *Handler) sendStateDeltas(syncStateDeltasRequest *pb.SyncStateDeltasRequ
 	peerLogger.Debug("Sending state deltas for block range %d-%d", syncStateDeltasRequest.Range.Start, syncStateDeltasRequest.Range.End)
 	var blockNums []uint64
 	syncBlockRange := syncStateDeltasRequest.Range
+	if syncBlockRange.Start < syncBlockRange.End {
+		// Rolling forwards, send only what changed across the whole range
+		d.sendStateDeltaRange(syncBlockRange)
+		return
+	}
 	if syncBlockRange.Start > syncBlockRange.End {
 		// Send in reverse order
 		for i := syncBlockRange.Start; i >= syncBlockRange.End; i-- {
@@ -700,6 +809,28 @@ func (d *Handler) sendStateDeltas(syncStateDeltasRequest *pb.SyncStateDeltasRequ
 	}
 }
 
+// sendStateDeltaRange sends a single state delta composed from the state deltas of the supplied SyncBlockRange over the stream.
+func (d *Handler) sendStateDeltaRange(syncBlockRange *pb.SyncBlockRange) {
+	stateDelta, err := d.Coordinator.GetStateDeltaRange(syncBlockRange.Start, syncBlockRange.End)
+	if err != nil {
+		peerLogger.Error(fmt.Sprintf("Error sending stateDelta for block range %d-%d: %s", syncBlockRange.Start, syncBlockRange.End, err))
+		return
+	}
+	if stateDelta == nil {
+		peerLogger.Warning(fmt.Sprintf("Requested to send a stateDelta for block range %d-%d which has been discarded", syncBlockRange.Start, syncBlockRange.End))
+		return
+	}
+	syncStateDeltas := &pb.SyncStateDeltas{Range: &pb.SyncBlockRange{Start: syncBlockRange.Start, End: syncBlockRange.End, CorrelationId: syncBlockRange.CorrelationId}, Deltas: [][]byte{stateDelta.Marshal()}}
+	syncStateDeltasBytes, err := proto.Marshal(syncStateDeltas)
+	if err != nil {
+		peerLogger.Error(fmt.Sprintf("Error marshalling syncStateDeltas for block range %d-%d: %s", syncBlockRange.Start, syncBlockRange.End, err))
+		return
+	}
+	if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_STATE_DELTAS, Payload: syncStateDeltasBytes}); err != nil {
+		peerLogger.Error(fmt.Sprintf("Error sending stateDeltas for block range %d-%d: %s", syncBlockRange.Start, syncBlockRange.End, err))
+	}
+}
+
 func (d *Handler) beforeSyncStateDeltas(e *fsm.Event) {
 	peerLogger.Debug("Received message: %s", e.Event)
 	msg, ok := e.Args[0].(*pb.Message)
@@ -58,6 +58,7 @@ type token struct{}
 // StateRetriever interface for retrieving state deltas, etc.
 type StateRetriever interface {
 	RequestStateSnapshot() (<-chan *pb.SyncStateSnapshot, error)
+	RequestStateSnapshotChunks(chunkSize, startChunk uint64) (<-chan *pb.SyncStateSnapshot, error)
 	RequestStateDeltas(syncBlockRange *pb.SyncBlockRange) (<-chan *pb.SyncStateDeltas, error)
 }
 
@@ -93,6 +94,7 @@ type BlockChainUtil interface {
 type StateAccessor interface {
 	GetStateSnapshot() (*state.StateSnapshot, error)
 	GetStateDelta(blockNumber uint64) (*statemgmt.StateDelta, error)
+	GetStateDeltaRange(fromBlockNumber, toBlockNumber uint64) (*statemgmt.StateDelta, error)
 }
 
 // MessageHandler standard interface for handling Openchain messages.
@@ -119,6 +121,7 @@ type MessageHandlerCoordinator interface {
 	GetPeers() (*pb.PeersMessage, error)
 	GetRemoteLedger(receiver *pb.PeerID) (RemoteLedger, error)
 	PeersDiscovered(*pb.PeersMessage) error
+	EndpointUpdated(*pb.Message) error
 	ExecuteTransaction(transaction *pb.Transaction) *pb.Response
 }
 
@@ -190,6 +193,11 @@ type PeerImpl struct {
 	engine         Engine
 	isValidator    bool
 	discoverySvc   discovery.Discovery
+
+	consensusStreams consensusStreams
+
+	endpoints        *endpointTable // current addresses of the peers
+	endpointSequence uint64         // sequence of the endpoint updates of this peer
 }
 
 // TransactionProccesor responsible for processing of Transactions
@@ -216,6 +224,8 @@ func NewPeerWithHandler(secHelperFunc func() crypto.Peer, handlerFact HandlerFac
 	}
 	peer.handlerFactory = handlerFact
 	peer.handlerMap = &handlerMap{m: make(map[pb.PeerID]MessageHandler)}
+	peer.endpoints = newEndpointTable()
+	peer.endpointSequence = newEndpointSequence()
 
 	peer.secHelper = secHelperFunc()
 
@@ -243,6 +253,8 @@ func NewPeerWithEngine(secHelperFunc func() crypto.Peer, engFactory EngineFactor
 	peer.discoverySvc = discInstance
 
 	peer.handlerMap = &handlerMap{m: make(map[pb.PeerID]MessageHandler)}
+	peer.endpoints = newEndpointTable()
+	peer.endpointSequence = newEndpointSequence()
 
 	peer.isValidator = ValidatorEnabled()
 	peer.secHelper = secHelperFunc()
@@ -372,6 +384,13 @@ func (p *PeerImpl) RegisterHandler(messageHandler MessageHandler) error {
 	}
 	p.handlerMap.m[*key] = messageHandler
 	peerLogger.Debug("registered handler with key: %s", key)
+	if remote, err := messageHandler.To(); err == nil {
+		if p.endpoints.learn(&remote) {
+			peerLogger.Info("Peer %s moved to %s", remote.ID.Name, remote.Address)
+		}
+		p.maintainConsensusStream(&remote)
+	}
+	go p.announceEndpoint(messageHandler)
 	return nil
 }
 
@@ -388,6 +407,7 @@ func (p *PeerImpl) DeregisterHandler(messageHandler MessageHandler) error {
 		return fmt.Errorf("Error deregistering handler, could not find handler with key: %s", key)
 	}
 	delete(p.handlerMap.m, *key)
+	p.consensusStreams.closePeer(*key)
 	peerLogger.Debug("Deregistered handler with key: %s", key)
 	return nil
 }
@@ -420,7 +440,13 @@ func (p *PeerImpl) Broadcast(msg *pb.Message, typ pb.PeerEndpoint_Type) []error
 
 	start := time.Now()
 
-	for _, msgHandler := range cloneMap {
+	for id, msgHandler := range cloneMap {
+		if cs := p.consensusStreams.get(id, msg); cs != nil {
+			if err := cs.send(msg); err != nil {
+				errorsFromHandlers <- fmt.Errorf("Error broadcasting msg (%s) to PeerEndpoint (%s): %s", msg.Type, id.Name, err)
+			}
+			continue
+		}
 		bcWG.Add(1)
 		go func(msgHandler MessageHandler) {
 			defer bcWG.Done()
@@ -461,6 +487,12 @@ func (p *PeerImpl) getMessageHandler(receiverHandle *pb.PeerID) (MessageHandler,
 
 // Unicast sends a message to a specific peer.
 func (p *PeerImpl) Unicast(msg *pb.Message, receiverHandle *pb.PeerID) error {
+	if cs := p.consensusStreams.get(*receiverHandle, msg); cs != nil {
+		if err := cs.send(msg); err != nil {
+			return fmt.Errorf("Error unicasting msg (%s) to PeerEndpoint (%s): %s", msg.Type, receiverHandle.Name, err)
+		}
+		return nil
+	}
 	msgHandler, err := p.getMessageHandler(receiverHandle)
 	if err != nil {
 		return err
@@ -543,8 +575,18 @@ func (p *PeerImpl) chatWithPeer(peerAddress string, chatTokens chan token) error
 		// acquire token
 		chatTokens <- token{}
 
+		if resolved := p.endpoints.resolve(peerAddress); resolved != peerAddress {
+			peerLogger.Info("Peer at address %s moved to %s", peerAddress, resolved)
+			peerAddress = resolved
+		}
 		peerLogger.Debug("Initiating Chat with peer address: %s", peerAddress)
-		conn, err := NewPeerClientConnectionWithAddress(peerAddress)
+		var conn *grpc.ClientConn
+		var err error
+		if p.isValidator {
+			conn, err = newValidatorClientConnection(peerAddress, "")
+		} else {
+			conn, err = NewPeerClientConnectionWithAddress(peerAddress)
+		}
 		if err != nil {
 			e := fmt.Errorf("Error creating connection to peer address=%s:  %s", peerAddress, err)
 			peerLogger.Error(e.Error())
@@ -595,6 +637,12 @@ func (p *PeerImpl) handleChat(ctx context.Context, stream ChatStream, initiatedS
 			peerLogger.Error(e.Error())
 			return e
 		}
+		if !initiatedStream {
+			if err := checkChatIdentity(ctx, in); err != nil {
+				peerLogger.Error("Refusing chat: %s", err)
+				return err
+			}
+		}
 		err = handler.HandleMessage(in)
 		if err != nil {
 			peerLogger.Error(fmt.Sprintf("Error handling message: %s", err))
@@ -720,6 +768,13 @@ func (p *PeerImpl) GetStateDelta(blockNumber uint64) (*statemgmt.StateDelta, err
 	return p.ledgerWrapper.ledger.GetStateDelta(blockNumber)
 }
 
+// GetStateDeltaRange return a single state delta composed from the state deltas of the requested block range
+func (p *PeerImpl) GetStateDeltaRange(fromBlockNumber, toBlockNumber uint64) (*statemgmt.StateDelta, error) {
+	p.ledgerWrapper.RLock()
+	defer p.ledgerWrapper.RUnlock()
+	return p.ledgerWrapper.ledger.GetStateDeltaRange(fromBlockNumber, toBlockNumber)
+}
+
 // PutBlock inserts a raw block into the blockchain at the specified index, nearly no error checking is performed
 func (p *PeerImpl) PutBlock(blockNumber uint64, block *pb.Block) error {
 	p.ledgerWrapper.Lock()
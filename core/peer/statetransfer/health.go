@@ -0,0 +1,97 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statetransfer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/protos"
+)
+
+// peerHealth records how responsive a peer has recently been as a source of blocks and state
+type peerHealth struct {
+	failures uint64        // Consecutive requests to the peer which failed or stalled
+	latency  time.Duration // Moving average of the duration of successful requests, 0 until a request succeeds
+}
+
+// recordResponse updates the health of peerID after a request to it which took elapsed and returned err
+func (sts *StateTransferState) recordResponse(peerID *protos.PeerID, elapsed time.Duration, err error) {
+	sts.healthLock.Lock()
+	defer sts.healthLock.Unlock()
+
+	health, ok := sts.peerHealth[peerID.Name]
+	if !ok {
+		health = &peerHealth{}
+		sts.peerHealth[peerID.Name] = health
+	}
+
+	if nil != err {
+		health.failures++
+		return
+	}
+
+	health.failures = 0
+	if 0 == health.latency {
+		health.latency = elapsed
+	} else {
+		// Weight the latest request by a quarter, so that a single slow request does not demote an otherwise fast peer
+		health.latency = (3*health.latency + elapsed) / 4
+	}
+}
+
+type rankedPeer struct {
+	peerID *protos.PeerID
+	health peerHealth
+}
+
+type rankedPeerSlice []rankedPeer
+
+func (a rankedPeerSlice) Len() int {
+	return len(a)
+}
+func (a rankedPeerSlice) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a rankedPeerSlice) Less(i, j int) bool {
+	if a[i].health.failures != a[j].health.failures {
+		return a[i].health.failures < a[j].health.failures
+	}
+	return a[i].health.latency < a[j].health.latency
+}
+
+// rankPeers orders peerIDs by their recent responsiveness, peers with the fewest consecutive failures first, then the fastest
+// Peers which have not been asked yet rank as fast, so that they are tried, and equally healthy peers keep the order they were passed in
+func (sts *StateTransferState) rankPeers(peerIDs []*protos.PeerID) []*protos.PeerID {
+	sts.healthLock.Lock()
+	ranked := make(rankedPeerSlice, len(peerIDs))
+	for i, peerID := range peerIDs {
+		ranked[i].peerID = peerID
+		if health, ok := sts.peerHealth[peerID.Name]; ok {
+			ranked[i].health = *health
+		}
+	}
+	sts.healthLock.Unlock()
+
+	sort.Stable(ranked)
+
+	result := make([]*protos.PeerID, len(ranked))
+	for i, r := range ranked {
+		result[i] = r.peerID
+	}
+	return result
+}
@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statetransfer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/protos"
+)
+
+func TestRankPeers(t *testing.T) {
+	mrls := createRemoteLedgers(1, 4)
+	sts := newTestThreadlessStateTransfer(NewMockLedger(mrls, nil, t), mrls)
+
+	peerIDs := make([]*protos.PeerID, 4)
+	for i := range peerIDs {
+		peerIDs[i] = &protos.PeerID{Name: fmt.Sprintf("vp%d", i+1)}
+	}
+
+	if ranked := sts.rankPeers(peerIDs); !sameOrder(ranked, peerIDs) {
+		t.Fatalf("Expected peers without any history to keep their order, got %v", ranked)
+	}
+
+	sts.recordResponse(peerIDs[0], 10*time.Millisecond, fmt.Errorf("stalled"))
+	sts.recordResponse(peerIDs[1], 30*time.Millisecond, nil)
+	sts.recordResponse(peerIDs[2], 20*time.Millisecond, nil)
+
+	// vp4 has not been asked yet, so it is tried ahead of the slower peers, vp1 failed most recently, so it is tried last
+	expected := []*protos.PeerID{peerIDs[3], peerIDs[2], peerIDs[1], peerIDs[0]}
+	if ranked := sts.rankPeers(peerIDs); !sameOrder(ranked, expected) {
+		t.Fatalf("Expected peers to be ranked %v, got %v", expected, ranked)
+	}
+
+	// A successful response clears the failures
+	sts.recordResponse(peerIDs[0], 10*time.Millisecond, nil)
+	expected = []*protos.PeerID{peerIDs[3], peerIDs[0], peerIDs[2], peerIDs[1]}
+	if ranked := sts.rankPeers(peerIDs); !sameOrder(ranked, expected) {
+		t.Fatalf("Expected peers to be ranked %v, got %v", expected, ranked)
+	}
+}
+
+func sameOrder(a, b []*protos.PeerID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStalledPeerDemoted(t *testing.T) {
+	mrls := createRemoteLedgers(1, 3)
+
+	mutex := &sync.Mutex{}
+	var stalled *protos.PeerID
+	var asked []string
+	ml := NewMockLedger(mrls, func(request mockRequest, peerID *protos.PeerID) mockResponse {
+		mutex.Lock()
+		defer mutex.Unlock()
+		asked = append(asked, peerID.Name)
+		if nil == stalled {
+			stalled = peerID
+		}
+		if *peerID == *stalled {
+			return Timeout
+		}
+		return Normal
+	}, t)
+	ml.PutBlock(0, SimpleGetBlock(0))
+
+	sts := newTestThreadlessStateTransfer(ml, mrls)
+	sts.BlockRequestTimeout = 10 * time.Millisecond
+	for peerID := range mrls.remoteLedgers {
+		mrls.GetMockRemoteLedgerByPeerID(&peerID).blockHeight = 11
+	}
+
+	peerIDs := []*protos.PeerID{{Name: "Peer 1"}, {Name: "Peer 2"}, {Name: "Peer 3"}}
+	if _, _, err := sts.syncBlocks(10, 6, SimpleGetBlockHash(10), peerIDs); nil != err {
+		t.Fatalf("Could not sync blocks after a peer stalled: %s", err)
+	}
+
+	mutex.Lock()
+	if 0 == len(asked) || asked[0] != stalled.Name {
+		t.Fatalf("Expected the first sync to be attempted from the first passed peer, asked %v", asked)
+	}
+	asked = nil
+	mutex.Unlock()
+
+	if _, _, err := sts.syncBlocks(5, 1, SimpleGetBlockHash(5), peerIDs); nil != err {
+		t.Fatalf("Could not sync blocks: %s", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, name := range asked {
+		if name == stalled.Name {
+			t.Fatalf("Expected the stalled peer %v not to be asked again while healthy peers respond, asked %v", stalled, asked)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statetransfer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+)
+
+const (
+	blockRangesKey = "statetransfer.blockRanges"
+	stateBlockKey  = "statetransfer.stateBlock"
+)
+
+// ProgressPersistor is implemented by stacks which can persist the progress of state transfer
+// If the stack passed to NewStateTransferState implements it, the validated block ranges and the block the state
+// was last played forward to are stored as they advance, so that a transfer interrupted by a restart resumes where it left off
+type ProgressPersistor interface {
+	StoreState(key string, value []byte) error
+	ReadState(key string) ([]byte, error)
+	DelState(key string)
+}
+
+// persistedRange is the stored form of a blockRange
+type persistedRange struct {
+	HighBlock   uint64
+	LowBlock    uint64
+	LowNextHash []byte
+}
+
+// persistBlockRanges stores the valid block ranges, along with inProgress, a range which is still being synced, if not nil
+func (sts *StateTransferState) persistBlockRanges(inProgress *blockRange) {
+	if nil == sts.persistor {
+		return
+	}
+
+	ranges := sts.validBlockRanges
+	if nil != inProgress {
+		ranges = append(ranges[:len(ranges):len(ranges)], inProgress)
+	}
+
+	stored := make([]persistedRange, len(ranges))
+	for i, r := range ranges {
+		stored[i] = persistedRange{HighBlock: r.highBlock, LowBlock: r.lowBlock, LowNextHash: r.lowNextHash}
+	}
+
+	raw, err := json.Marshal(stored)
+	if nil != err {
+		logger.Error("%v could not marshal its valid block ranges: %s", sts.id, err)
+		return
+	}
+	if err := sts.persistor.StoreState(blockRangesKey, raw); nil != err {
+		logger.Warning("%v could not persist its valid block ranges: %s", sts.id, err)
+	}
+}
+
+// restoreBlockRanges loads the valid block ranges persisted before a restart
+// Ranges which reach beyond the blockchain are discarded, and the head of the blockchain is added, as verifyAndRecoverBlockchain
+// would for an empty set of ranges, so that blocks above the highest persisted range are still validated
+func (sts *StateTransferState) restoreBlockRanges() {
+	if nil == sts.persistor {
+		return
+	}
+
+	raw, err := sts.persistor.ReadState(blockRangesKey)
+	if nil != err || 0 == len(raw) {
+		return
+	}
+
+	var stored []persistedRange
+	if err := json.Unmarshal(raw, &stored); nil != err {
+		logger.Warning("%v could not unmarshal its persisted block ranges, validating the blockchain from scratch: %s", sts.id, err)
+		return
+	}
+
+	size := sts.stack.GetBlockchainSize()
+	if 0 == size {
+		return
+	}
+
+	for _, r := range stored {
+		if r.HighBlock >= size || r.LowBlock > r.HighBlock {
+			logger.Debug("%v discarding persisted block range from %d to %d, the blockchain is %d blocks tall", sts.id, r.HighBlock, r.LowBlock, size)
+			continue
+		}
+		sts.validBlockRanges = append(sts.validBlockRanges, &blockRange{
+			highBlock:   r.HighBlock,
+			lowBlock:    r.LowBlock,
+			lowNextHash: r.LowNextHash,
+		})
+	}
+
+	if 0 == len(sts.validBlockRanges) {
+		return
+	}
+
+	logger.Info("%v restored %d valid block ranges from before its restart", sts.id, len(sts.validBlockRanges))
+
+	head, err := sts.stack.GetBlockByNumber(size - 1)
+	if nil != err {
+		logger.Warning("%v could not retrieve its head block %d: %s", sts.id, size-1, err)
+		return
+	}
+	sts.validBlockRanges = append(sts.validBlockRanges, &blockRange{
+		highBlock:   size - 1,
+		lowBlock:    size - 1,
+		lowNextHash: head.PreviousBlockHash,
+	})
+}
+
+// validThrough returns the lowest block the blockchain is known to be valid through, from blockNumber, which must
+// hash to blockHash, downwards, along with that block's PreviousBlockHash
+// The last return value is false if blockNumber is not present with the expected hash
+func (sts *StateTransferState) validThrough(blockNumber uint64, blockHash []byte) (uint64, []byte, bool) {
+	block, err := sts.stack.GetBlockByNumber(blockNumber)
+	if nil != err || nil == block {
+		return 0, nil, false
+	}
+	if testHash, err := sts.stack.HashBlock(block); nil != err || !bytes.Equal(testHash, blockHash) {
+		return 0, nil, false
+	}
+
+	lowBlock, lowNextHash := blockNumber, block.PreviousBlockHash
+
+	// Ranges are sorted by descending highBlock, so each range may only extend the chain validated by those before it
+	sort.Sort(blockRangeSlice(sts.validBlockRanges))
+	for _, r := range sts.validBlockRanges {
+		if 0 == lowBlock || r.lowBlock >= lowBlock || r.highBlock+1 < lowBlock {
+			continue
+		}
+
+		block, err := sts.stack.GetBlockByNumber(lowBlock - 1)
+		if nil != err || nil == block {
+			break
+		}
+		if testHash, err := sts.stack.HashBlock(block); nil != err || !bytes.Equal(testHash, lowNextHash) {
+			break
+		}
+
+		lowBlock, lowNextHash = r.lowBlock, r.lowNextHash
+	}
+
+	return lowBlock, lowNextHash, true
+}
+
+// persistStateBlock stores the number of the block the state was played forward to
+func (sts *StateTransferState) persistStateBlock(blockNumber uint64) {
+	if nil == sts.persistor {
+		return
+	}
+	raw := make([]byte, binary.MaxVarintLen64)
+	raw = raw[:binary.PutUvarint(raw, blockNumber)]
+	if err := sts.persistor.StoreState(stateBlockKey, raw); nil != err {
+		logger.Warning("%v could not persist the state transfer progress to block %d: %s", sts.id, blockNumber, err)
+	}
+}
+
+// persistedStateBlock returns the number of the block the state was played forward to by an interrupted state transfer, if any
+func (sts *StateTransferState) persistedStateBlock() (uint64, bool) {
+	if nil == sts.persistor {
+		return 0, false
+	}
+	raw, err := sts.persistor.ReadState(stateBlockKey)
+	if nil != err || 0 == len(raw) {
+		return 0, false
+	}
+	blockNumber, n := binary.Uvarint(raw)
+	if n <= 0 {
+		logger.Warning("%v could not decode the persisted state transfer progress", sts.id)
+		return 0, false
+	}
+	return blockNumber, true
+}
+
+// clearStateBlock discards the state transfer progress once the transfer completed
+func (sts *StateTransferState) clearStateBlock() {
+	if nil == sts.persistor {
+		return
+	}
+	sts.persistor.DelState(stateBlockKey)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statetransfer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/protos"
+)
+
+type mockPersistor struct {
+	mutex *sync.Mutex
+	state map[string][]byte
+}
+
+func newMockPersistor() *mockPersistor {
+	return &mockPersistor{
+		mutex: &sync.Mutex{},
+		state: make(map[string][]byte),
+	}
+}
+
+func (mp *mockPersistor) StoreState(key string, value []byte) error {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	mp.state[key] = value
+	return nil
+}
+
+func (mp *mockPersistor) ReadState(key string) ([]byte, error) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	return mp.state[key], nil
+}
+
+func (mp *mockPersistor) DelState(key string) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	delete(mp.state, key)
+}
+
+type persistentPartialStack struct {
+	PartialStack
+	*mockPersistor
+}
+
+func newPersistentTestThreadlessStateTransfer(ml *MockLedger, rld *MockRemoteHashLedgerDirectory, mp *mockPersistor) *StateTransferState {
+	sts := threadlessNewStateTransferState(&persistentPartialStack{newPartialStack(ml, rld), mp})
+	sts.BlockRequestTimeout = 10 * time.Millisecond
+	sts.DiscoveryThrottleTime = 10 * time.Millisecond
+	sts.maxBlockRange = 10
+	return sts
+}
+
+func TestResumeBlockSync(t *testing.T) {
+	mrls := createRemoteLedgers(0, 3)
+	for peerID := range mrls.remoteLedgers {
+		mrls.GetMockRemoteLedgerByPeerID(&peerID).blockHeight = 101
+	}
+
+	mutex := &sync.Mutex{}
+	interrupted := true
+	requests := 0
+	ml := NewMockLedger(mrls, func(request mockRequest, peerID *protos.PeerID) mockResponse {
+		if request != SyncBlocks {
+			return Normal
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests++
+		if interrupted && requests > 3 {
+			return Timeout
+		}
+		return Normal
+	}, t)
+	ml.PutBlock(0, SimpleGetBlock(0))
+
+	mp := newMockPersistor()
+	req := &blockSyncReq{
+		syncMark:       syncMark{blockNumber: 100},
+		reportOnBlock:  0,
+		replyChan:      make(chan error, 1),
+		firstBlockHash: SimpleGetBlockHash(100),
+	}
+
+	// Only the first three block ranges, 100 through 68, are retrieved before the peers stop responding
+	newPersistentTestThreadlessStateTransfer(ml, mrls, mp).syncBlockchainToCheckpoint(req)
+	if err := <-req.replyChan; nil == err {
+		t.Fatalf("Expected the interrupted block sync to fail")
+	}
+
+	mutex.Lock()
+	interrupted = false
+	requests = 0
+	mutex.Unlock()
+
+	sts := newPersistentTestThreadlessStateTransfer(ml, mrls, mp)
+	sts.syncBlockchainToCheckpoint(req)
+	if err := <-req.replyChan; nil != err {
+		t.Fatalf("Resumed block sync failed: %s", err)
+	}
+
+	if n, err := ml.VerifyBlockchain(100, 0); 0 != n || nil != err {
+		t.Fatalf("Blockchain claims to be up to date, but does not verify")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	// Blocks 67 through 0, 11 blocks per request, rather than all 101 blocks
+	if requests != 7 {
+		t.Errorf("Expected the resumed block sync to require 7 requests, but it made %d", requests)
+	}
+}
+
+func TestResumeStatePlay(t *testing.T) {
+	mrls := createRemoteLedgers(0, 3)
+	for peerID := range mrls.remoteLedgers {
+		mrls.GetMockRemoteLedgerByPeerID(&peerID).blockHeight = 11
+	}
+
+	snapshots := &filterResult{triggered: false, mutex: &sync.Mutex{}}
+	ml := NewMockLedger(mrls, func(request mockRequest, peerID *protos.PeerID) mockResponse {
+		if request == SyncSnapshot {
+			snapshots.mutex.Lock()
+			snapshots.triggered = true
+			snapshots.mutex.Unlock()
+		}
+		return Normal
+	}, t)
+	for i := uint64(0); i <= 10; i++ {
+		ml.PutBlock(i, SimpleGetBlock(i))
+	}
+
+	// The state is played forward to block 5 before the replica restarts
+	mp := newMockPersistor()
+	sts := newPersistentTestThreadlessStateTransfer(ml, mrls, mp)
+	sts.StateDeltaRequestTimeout = time.Second
+	if _, err := sts.playStateUpToBlockNumber(1, 5, nil); nil != err {
+		t.Fatalf("Could not play the state forward to block 5: %s", err)
+	}
+	if stateBlock, ok := sts.persistedStateBlock(); !ok || 5 != stateBlock {
+		t.Fatalf("Expected the state to be recorded as played forward to block 5, got %d", stateBlock)
+	}
+
+	sts = NewStateTransferState(&persistentPartialStack{newPartialStack(ml, mrls), mp})
+	defer sts.Stop()
+	if err := executeStateTransfer(sts, ml, 10, 10, mrls); nil != err {
+		t.Fatalf("Resumed state transfer failed: %s", err)
+	}
+
+	if snapshots.wasTriggered() {
+		t.Errorf("Expected the state transfer to resume from block 5, but it retrieved a state snapshot")
+	}
+	if _, ok := sts.persistedStateBlock(); ok {
+		t.Errorf("Expected the state transfer progress to be cleared once the transfer completed")
+	}
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/peer"
+	"github.com/hyperledger/fabric/core/util"
 	"github.com/hyperledger/fabric/protos"
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -70,6 +71,7 @@ type ProtoListener struct {
 	InitiatedImpl func(uint64, []byte, []*protos.PeerID, interface{})
 	ErroredImpl   func(uint64, []byte, []*protos.PeerID, interface{}, error)
 	CompletedImpl func(uint64, []byte, []*protos.PeerID, interface{})
+	ProgressImpl  func(Status)
 }
 
 // Initiated invokes the corresponding InitiatedImpl if it has been overwritten
@@ -93,6 +95,13 @@ func (pstl *ProtoListener) Completed(bn uint64, bh []byte, pids []*protos.PeerID
 	}
 }
 
+// Progress invokes the corresponding ProgressImpl if it has been overwritten
+func (pstl *ProtoListener) Progress(s Status) {
+	if nil != pstl.ProgressImpl {
+		pstl.ProgressImpl(s)
+	}
+}
+
 // StateTransferState is the structure used to manage the state of state transfer
 type StateTransferState struct {
 	stack PartialStack
@@ -105,10 +114,14 @@ type StateTransferState struct {
 
 	stateValid bool // Are we currently operating under the assumption that the state is valid?
 
+	resyncBlocks bool // Set by the state thread when the blocks for a transfer did not verify against the target, so that they are synced again rather than trusted
+
 	blockVerifyChunkSize uint64        // The max block length to attempt to sync at once, this prevents state transfer from being delayed while the blockchain is validated
 	validBlockRanges     []*blockRange // Used by the block thread to track which pieces of the blockchain have already been hashed
 	RecoverDamage        bool          // Whether state transfer should ever modify or delete existing blocks if they are determined to be corrupted
 
+	persistor ProgressPersistor // Used to persist the progress of state transfer across restarts, nil if the stack does not support it
+
 	initiateStateSync chan *blockHashReply // Used to ensure only one state transfer at a time occurs, write to only from the main consensus thread
 	blockHashReceiver chan *blockHashReply // Used to process incoming valid block hashes, write only from the state thread
 	blockSyncReq      chan *blockSyncReq   // Used to request a block sync, new requests cause the existing request to abort, write only from the state thread
@@ -126,11 +139,20 @@ type StateTransferState struct {
 	StateSnapshotRequestTimeout time.Duration // How long to wait for a peer to respond to a state snapshot request
 
 	maxStateDeltas     int    // The maximum number of state deltas to attempt to retrieve before giving up and performing a full state snapshot retrieval
+	parallelFetch      int    // The number of peers to retrieve block ranges from concurrently
 	maxBlockRange      uint64 // The maximum number blocks to attempt to retrieve at once, to prevent from overflowing the peer's buffer
 	maxStateDeltaRange uint64 // The maximum number of state deltas to attempt to retrieve at once, to prevent from overflowing the peer's buffer
+	snapshotChunkSize  uint64 // The number of state deltas per Merkle-proved chunk of a state snapshot, 0 to retrieve the snapshot without proofs
 
 	stateTransferListeners     []Listener  // A list of listeners to call when state transfer is initiated/errored/completed
 	stateTransferListenersLock *sync.Mutex // Used to lock the above list when adding a listener
+
+	peerHealth map[string]*peerHealth // The recent responsiveness of each peer asked for blocks or state, keyed by peer name
+	healthLock *sync.Mutex            // Used to lock the above map, requests are made from both threads and the parallel block fetches
+
+	status     Status         // The progress of the current or last state transfer, written by both threads
+	statusLock *sync.Mutex    // Used to lock the above status
+	metrics    *statusMetrics // Exposed through the metrics registry of the peer
 }
 
 // BlockingAddTarget Adds a target and blocks until that target's success or failure
@@ -317,11 +339,21 @@ func threadlessNewStateTransferState(stack PartialStack) *StateTransferState {
 
 	sts.id = ep.ID
 
+	sts.peerHealth = make(map[string]*peerHealth)
+	sts.healthLock = &sync.Mutex{}
+	sts.statusLock = &sync.Mutex{}
+	sts.metrics = newStatusMetrics(sts.id)
+
 	sts.RecoverDamage = viper.GetBool("statetransfer.recoverdamage")
 
 	sts.stateValid = true // Assume our starting state is correct unless told otherwise
 
+	if persistor, ok := stack.(ProgressPersistor); ok {
+		sts.persistor = persistor
+	}
+
 	sts.validBlockRanges = make([]*blockRange, 0)
+	sts.restoreBlockRanges()
 	sts.blockVerifyChunkSize = uint64(viper.GetInt("statetransfer.blocksperrequest"))
 	if sts.blockVerifyChunkSize == 0 {
 		panic(fmt.Errorf("Must set statetransfer.blocksperrequest to be nonzero"))
@@ -359,7 +391,18 @@ func threadlessNewStateTransferState(stack PartialStack) *StateTransferState {
 		panic(fmt.Errorf("sts.maxdeltas must be greater than 0"))
 	}
 
-	tmp := viper.GetInt("peer.sync.blocks.channelSize")
+	sts.parallelFetch = viper.GetInt("statetransfer.parallelfetch")
+	if sts.parallelFetch <= 0 {
+		panic(fmt.Errorf("statetransfer.parallelfetch must be greater than 0"))
+	}
+
+	tmp := viper.GetInt("statetransfer.snapshotchunksize")
+	if tmp < 0 {
+		panic(fmt.Errorf("statetransfer.snapshotchunksize must not be negative"))
+	}
+	sts.snapshotChunkSize = uint64(tmp)
+
+	tmp = viper.GetInt("peer.sync.blocks.channelSize")
 	if tmp <= 0 {
 		panic(fmt.Errorf("peer.sync.blocks.channelSize must be greater than 0"))
 	}
@@ -412,6 +455,7 @@ type blockSyncReq struct {
 	reportOnBlock  uint64
 	replyChan      chan error
 	firstBlockHash []byte
+	resync         bool // Sync all blocks from the target down, even those believed to be valid already
 }
 
 type blockRange struct {
@@ -442,16 +486,43 @@ func (a blockRangeSlice) Less(i, j int) bool {
 
 // Executes a func trying each peer included in peerIDs until successful
 // Attempts to execute over all peers if peerIDs is nil
+// Peers are tried from the most to the least responsive, a peer which fails or stalls is ranked lower on later attempts
 func (sts *StateTransferState) tryOverPeers(passedPeerIDs []*protos.PeerID, do func(peerID *protos.PeerID) error) (err error) {
 
+	peerIDs, err := sts.syncCandidates(passedPeerIDs)
+	if nil != err {
+		return err
+	}
+
+	logger.Debug("%v in tryOverPeers, using peerIDs: %v", sts.id, peerIDs)
+
+	for _, peerID := range sts.rankPeers(peerIDs) {
+		sts.statusSource(peerID)
+		start := time.Now()
+		err = do(peerID)
+		sts.recordResponse(peerID, time.Since(start), err)
+		if err == nil {
+			break
+		} else {
+			logger.Warning("%v in tryOverPeers loop trying %v : %s", sts.id, peerID, err)
+		}
+	}
+
+	return err
+
+}
+
+// Returns the peers to sync from, which are all validators other than ourselves if passedPeerIDs is nil
+func (sts *StateTransferState) syncCandidates(passedPeerIDs []*protos.PeerID) ([]*protos.PeerID, error) {
+
 	peerIDs := passedPeerIDs
 
 	if nil == passedPeerIDs {
-		logger.Debug("%v tryOverPeers, no peerIDs given, discovering", sts.id)
+		logger.Debug("%v no peerIDs given, discovering", sts.id)
 
 		peersMsg, err := sts.stack.GetPeers()
 		if err != nil {
-			return fmt.Errorf("Couldn't retrieve list of peers: %v", err)
+			return nil, fmt.Errorf("Couldn't retrieve list of peers: %v", err)
 		}
 		peers := peersMsg.GetPeers()
 		for _, endpoint := range peers {
@@ -464,38 +535,32 @@ func (sts *StateTransferState) tryOverPeers(passedPeerIDs []*protos.PeerID, do f
 		}
 
 		logger.Debug("%v discovered %d peerIDs", sts.id, len(peerIDs))
-	}
 
-	logger.Debug("%v in tryOverPeers, using peerIDs: %v", sts.id, peerIDs)
+		// Discovered peers come in no particular order, so spread the requests over the equally healthy ones
+		for i := range peerIDs {
+			j := rand.Intn(i + 1)
+			peerIDs[i], peerIDs[j] = peerIDs[j], peerIDs[i]
+		}
+	}
 
 	if 0 == len(peerIDs) {
-		logger.Error("%v invoked tryOverPeers with no peers specified, throttling thread", sts.id)
+		logger.Error("%v has no peers to sync from, throttling thread", sts.id)
 		// Unless we throttle here, this condition will likely cause a tight loop which will adversely affect the rest of the system
 		time.Sleep(sts.DiscoveryThrottleTime)
-		return fmt.Errorf("No peers available to try over")
-	}
-
-	numReplicas := len(peerIDs)
-	startIndex := rand.Int() % numReplicas
-
-	for i := 0; i < numReplicas; i++ {
-		index := (i + startIndex) % numReplicas
-		err = do(peerIDs[index])
-		if err == nil {
-			break
-		} else {
-			logger.Warning("%v in tryOverPeers loop trying %v : %s", sts.id, peerIDs[index], err)
-		}
+		return nil, fmt.Errorf("No peers available to try over")
 	}
 
-	return err
-
+	return peerIDs, nil
 }
 
 // Attempts to complete a blockSyncReq using the supplied peers
 // Will return the last block number attempted to sync, and the last block successfully synced (or nil) and error on failure
 // This means on failure, the returned block corresponds to 1 higher than the returned block number
 func (sts *StateTransferState) syncBlocks(highBlock, lowBlock uint64, highHash []byte, peerIDs []*protos.PeerID) (uint64, *protos.Block, error) {
+	if sts.parallelFetch > 1 && highBlock-lowBlock >= sts.maxBlockRange {
+		return sts.syncBlocksParallel(highBlock, lowBlock, highHash, peerIDs)
+	}
+
 	logger.Debug("%v syncing blocks from %d to %d with head hash of %x", sts.id, highBlock, lowBlock, highHash)
 	validBlockHash := highHash
 	blockCursor := highBlock
@@ -559,27 +624,7 @@ func (sts *StateTransferState) syncBlocks(highBlock, lowBlock uint64, highHash [
 								sts.id, blockCursor, peerID, testHash, validBlockHash)
 						}
 
-						logger.Debug("%v putting block %d to with PreviousBlockHash %x and StateHash %x", sts.id, blockCursor, block.PreviousBlockHash, block.StateHash)
-						if !sts.RecoverDamage {
-
-							// If we are not supposed to be destructive in our recovery, check to make sure this block doesn't already exist
-							if oldBlock, err := sts.stack.GetBlockByNumber(blockCursor); err == nil && oldBlock != nil {
-								oldBlockHash, err := sts.stack.HashBlock(oldBlock)
-								if nil == err {
-									if !bytes.Equal(oldBlockHash, validBlockHash) {
-										panic("The blockchain is corrupt and the configuration has specified that bad blocks should not be deleted/overridden")
-									}
-								} else {
-									logger.Error("%v could not compute the hash of block %d", sts.id, blockCursor)
-									panic("The blockchain is corrupt and the configuration has specified that bad blocks should not be deleted/overridden")
-								}
-								logger.Debug("%v not actually putting block %d to with PreviousBlockHash %x and StateHash %x, as it already exists", sts.id, blockCursor, block.PreviousBlockHash, block.StateHash)
-							} else {
-								sts.stack.PutBlock(blockCursor, block)
-							}
-						} else {
-							sts.stack.PutBlock(blockCursor, block)
-						}
+						sts.putVerifiedBlock(blockCursor, block, validBlockHash)
 
 						goodRange = &blockRange{
 							highBlock:   highBlock,
@@ -589,6 +634,11 @@ func (sts *StateTransferState) syncBlocks(highBlock, lowBlock uint64, highHash [
 
 						validBlockHash = block.PreviousBlockHash
 
+						if blockCursor == intermediateBlock {
+							// The requested range is complete, record the progress in case we are interrupted
+							sts.persistBlockRanges(goodRange)
+						}
+
 						if blockCursor == lowBlock {
 							logger.Debug("%v successfully synced from block %d to block %d", sts.id, highBlock, lowBlock)
 							return nil
@@ -612,12 +662,240 @@ func (sts *StateTransferState) syncBlocks(highBlock, lowBlock uint64, highHash [
 	if goodRange != nil {
 		goodRange.lowNextHash = block.PreviousBlockHash
 		sts.validBlockRanges = append(sts.validBlockRanges, goodRange)
+		sts.persistBlockRanges(nil)
 	}
 
 	return blockCursor, block, err
 
 }
 
+// Verifies that the blocks from highBlock down to lowBlock are present and form a hash chain ending in highHash
+func (sts *StateTransferState) verifyChainToTarget(highBlock, lowBlock uint64, highHash []byte) error {
+	validBlockHash := highHash
+	for blockNumber := highBlock; ; blockNumber-- {
+		block, err := sts.stack.GetBlockByNumber(blockNumber)
+		if nil != err || nil == block {
+			return fmt.Errorf("could not retrieve block %d: %v", blockNumber, err)
+		}
+
+		blockHash, err := sts.stack.HashBlock(block)
+		if nil != err {
+			return fmt.Errorf("could not hash block %d: %s", blockNumber, err)
+		}
+
+		if !bytes.Equal(blockHash, validBlockHash) {
+			return fmt.Errorf("block %d has hash %x, was expecting hash %x", blockNumber, blockHash, validBlockHash)
+		}
+
+		if blockNumber <= lowBlock {
+			return nil
+		}
+		validBlockHash = block.PreviousBlockHash
+	}
+}
+
+// Stores a block whose hash was verified to be blockHash, unless the block is already present and damage is not to be recovered
+func (sts *StateTransferState) putVerifiedBlock(blockNumber uint64, block *protos.Block, blockHash []byte) {
+	logger.Debug("%v putting block %d to with PreviousBlockHash %x and StateHash %x", sts.id, blockNumber, block.PreviousBlockHash, block.StateHash)
+	sts.statusBlockFetched()
+	if !sts.RecoverDamage {
+
+		// If we are not supposed to be destructive in our recovery, check to make sure this block doesn't already exist
+		if oldBlock, err := sts.stack.GetBlockByNumber(blockNumber); err == nil && oldBlock != nil {
+			oldBlockHash, err := sts.stack.HashBlock(oldBlock)
+			if nil == err {
+				if !bytes.Equal(oldBlockHash, blockHash) {
+					panic("The blockchain is corrupt and the configuration has specified that bad blocks should not be deleted/overridden")
+				}
+			} else {
+				logger.Error("%v could not compute the hash of block %d", sts.id, blockNumber)
+				panic("The blockchain is corrupt and the configuration has specified that bad blocks should not be deleted/overridden")
+			}
+			logger.Debug("%v not actually putting block %d to with PreviousBlockHash %x and StateHash %x, as it already exists", sts.id, blockNumber, block.PreviousBlockHash, block.StateHash)
+		} else {
+			sts.stack.PutBlock(blockNumber, block)
+		}
+	} else {
+		sts.stack.PutBlock(blockNumber, block)
+	}
+}
+
+// Retrieves the blocks from highBlock down to lowBlock from a single peer, and verifies that each block hashes to the PreviousBlockHash of the block above it
+func (sts *StateTransferState) fetchBlocks(peerID *protos.PeerID, highBlock, lowBlock uint64) ([]*protos.Block, error) {
+	logger.Debug("%v requesting block range from %d to %d from %v", sts.id, highBlock, lowBlock, peerID)
+	sts.statusSource(peerID)
+	blockChan, err := sts.GetRemoteBlocks(peerID, highBlock, lowBlock)
+	if nil != err {
+		return nil, err
+	}
+
+	blocks := make([]*protos.Block, 0, highBlock-lowBlock+1)
+	blockCursor := highBlock
+	for {
+		select {
+		case syncBlockMessage, ok := <-blockChan:
+			if !ok {
+				return nil, fmt.Errorf("Channel closed before we could finish reading")
+			}
+
+			if syncBlockMessage.Range.Start < syncBlockMessage.Range.End {
+				return nil, fmt.Errorf("%v received a block with wrong (increasing) order from %v, aborting", sts.id, peerID)
+			}
+
+			for i, block := range syncBlockMessage.Blocks {
+				if syncBlockMessage.Range.Start-uint64(i) != blockCursor {
+					return nil, fmt.Errorf("%v received a block out of order, indicating a buffer overflow or other corruption: start=%d, end=%d, wanted %d", sts.id, syncBlockMessage.Range.Start, syncBlockMessage.Range.End, blockCursor)
+				}
+
+				if 0 < len(blocks) {
+					testHash, err := sts.stack.HashBlock(block)
+					if nil != err {
+						return nil, fmt.Errorf("%v got a block %d which could not hash from %v: %s", sts.id, blockCursor, peerID, err)
+					}
+					if !bytes.Equal(testHash, blocks[len(blocks)-1].PreviousBlockHash) {
+						return nil, fmt.Errorf("%v got block %d from %v with hash %x, which does not chain to block %d", sts.id, blockCursor, peerID, testHash, blockCursor+1)
+					}
+				}
+
+				blocks = append(blocks, block)
+				if blockCursor == lowBlock {
+					return blocks, nil
+				}
+				blockCursor--
+			}
+		case <-time.After(sts.BlockRequestTimeout):
+			return nil, fmt.Errorf("%v had block sync request to %v time out", sts.id, peerID)
+		}
+	}
+}
+
+// Performs the same sync as syncBlocks, but retrieves ranges of maxBlockRange blocks from up to parallelFetch peers concurrently
+// The ranges are linked to highHash in order from the top, a range which does not link is retrieved again from the other peers
+func (sts *StateTransferState) syncBlocksParallel(highBlock, lowBlock uint64, highHash []byte, passedPeerIDs []*protos.PeerID) (uint64, *protos.Block, error) {
+	logger.Debug("%v syncing blocks from %d to %d with head hash of %x from %d peers concurrently", sts.id, highBlock, lowBlock, highHash, sts.parallelFetch)
+
+	peerIDs, err := sts.syncCandidates(passedPeerIDs)
+	if nil != err {
+		return highBlock, nil, err
+	}
+	peerIDs = sts.rankPeers(peerIDs)
+
+	type fetchedRange struct {
+		peerID  *protos.PeerID
+		blocks  []*protos.Block
+		elapsed time.Duration
+		err     error
+	}
+
+	type pendingRange struct {
+		highBlock uint64
+		lowBlock  uint64
+		result    chan fetchedRange
+	}
+
+	var ranges []*pendingRange
+	for high := highBlock; ; {
+		low := lowBlock
+		if high-lowBlock >= sts.maxBlockRange {
+			low = high - sts.maxBlockRange + 1
+		}
+		ranges = append(ranges, &pendingRange{highBlock: high, lowBlock: low, result: make(chan fetchedRange, 1)})
+		if low == lowBlock {
+			break
+		}
+		high = low - 1
+	}
+
+	// The ranges are spread over the peers from the most to the least responsive
+	fetch := func(i int) {
+		r := ranges[i]
+		peerID := peerIDs[i%len(peerIDs)]
+		go func() {
+			start := time.Now()
+			blocks, err := sts.fetchBlocks(peerID, r.highBlock, r.lowBlock)
+			r.result <- fetchedRange{peerID: peerID, blocks: blocks, elapsed: time.Since(start), err: err}
+		}()
+	}
+	for i := 0; i < len(ranges) && i < sts.parallelFetch; i++ {
+		fetch(i)
+	}
+
+	links := func(blocks []*protos.Block, blockHash []byte) error {
+		testHash, err := sts.stack.HashBlock(blocks[0])
+		if nil != err {
+			return err
+		}
+		if !bytes.Equal(testHash, blockHash) {
+			return fmt.Errorf("%v got block range with head hash %x, was expecting hash %x", sts.id, testHash, blockHash)
+		}
+		return nil
+	}
+
+	validBlockHash := highHash
+	var block *protos.Block
+	var syncErr error
+	lastSynced := highBlock + 1
+	for i, r := range ranges {
+		if next := i + sts.parallelFetch; next < len(ranges) {
+			fetch(next)
+		}
+
+		fetched := <-r.result
+		blocks, err := fetched.blocks, fetched.err
+		if nil == err {
+			err = links(blocks, validBlockHash)
+		}
+		sts.recordResponse(fetched.peerID, fetched.elapsed, err)
+		if nil != err {
+			logger.Warning("%v failed to get blocks from %d to %d from %v: %s", sts.id, r.highBlock, r.lowBlock, fetched.peerID, err)
+			err = sts.tryOverPeers(peerIDs, func(peerID *protos.PeerID) error {
+				if *peerID == *fetched.peerID {
+					return fmt.Errorf("%v already failed to provide blocks from %d to %d", peerID, r.highBlock, r.lowBlock)
+				}
+				blocks, err = sts.fetchBlocks(peerID, r.highBlock, r.lowBlock)
+				if nil == err {
+					err = links(blocks, validBlockHash)
+				}
+				return err
+			})
+		}
+		if nil != err {
+			syncErr = err
+			break
+		}
+
+		for j, b := range blocks {
+			sts.putVerifiedBlock(r.highBlock-uint64(j), b, validBlockHash)
+			validBlockHash = b.PreviousBlockHash
+			block = b
+		}
+		lastSynced = r.lowBlock
+
+		sts.persistBlockRanges(&blockRange{
+			highBlock:   highBlock,
+			lowBlock:    lastSynced,
+			lowNextHash: validBlockHash,
+		})
+	}
+
+	if nil != block {
+		logger.Debug("%v returned from parallel sync with block %d and state hash %x", sts.id, lastSynced, block.StateHash)
+		sts.validBlockRanges = append(sts.validBlockRanges, &blockRange{
+			highBlock:   highBlock,
+			lowBlock:    lastSynced,
+			lowNextHash: block.PreviousBlockHash,
+		})
+		sts.persistBlockRanges(nil)
+	} else {
+		logger.Debug("%v returned from parallel sync with no new blocks", sts.id)
+	}
+
+	if nil != syncErr {
+		return lastSynced - 1, block, syncErr
+	}
+	return lastSynced, block, nil
+}
+
 func (sts *StateTransferState) syncBlockchainToCheckpoint(blockSyncReq *blockSyncReq) {
 
 	logger.Debug("%v is processing a blockSyncReq to block %d", sts.id, blockSyncReq.blockNumber)
@@ -633,7 +911,26 @@ func (sts *StateTransferState) syncBlockchainToCheckpoint(blockSyncReq *blockSyn
 		}
 	} else {
 
-		_, _, err := sts.syncBlocks(blockSyncReq.blockNumber, blockSyncReq.reportOnBlock, blockSyncReq.firstBlockHash, blockSyncReq.peerIDs)
+		var err error
+		var lowBlock uint64
+		var lowNextHash []byte
+		ok := false
+		if blockSyncReq.resync {
+			logger.Info("%v syncing all blocks from %d to %d, as they previously did not verify", sts.id, blockSyncReq.blockNumber, blockSyncReq.reportOnBlock)
+		} else {
+			lowBlock, lowNextHash, ok = sts.validThrough(blockSyncReq.blockNumber, blockSyncReq.firstBlockHash)
+		}
+
+		if !ok {
+			sts.statusBlocksRemaining(blockSyncReq.blockNumber - blockSyncReq.reportOnBlock + 1)
+			_, _, err = sts.syncBlocks(blockSyncReq.blockNumber, blockSyncReq.reportOnBlock, blockSyncReq.firstBlockHash, blockSyncReq.peerIDs)
+		} else if lowBlock > blockSyncReq.reportOnBlock {
+			logger.Info("%v already has valid blocks from %d to %d, resuming block sync from block %d", sts.id, blockSyncReq.blockNumber, lowBlock, lowBlock-1)
+			sts.statusBlocksRemaining(lowBlock - blockSyncReq.reportOnBlock)
+			_, _, err = sts.syncBlocks(lowBlock-1, blockSyncReq.reportOnBlock, lowNextHash, blockSyncReq.peerIDs)
+		} else {
+			logger.Debug("%v already has valid blocks from %d to %d, no block sync required", sts.id, blockSyncReq.blockNumber, blockSyncReq.reportOnBlock)
+		}
 
 		if nil != blockSyncReq.replyChan {
 			logger.Debug("%v replying to blockSyncReq on reply channel with : %s", sts.id, err)
@@ -690,7 +987,7 @@ func (sts *StateTransferState) verifyAndRecoverBlockchain() bool {
 				if nil != err {
 					logger.Warning("%v could not retrieve block %d which it believed to be valid: %s", sts.id, lowBlock-1, err)
 				} else {
-					if blockHash, err := sts.stack.HashBlock(block); nil != err {
+					if blockHash, err := sts.stack.HashBlock(block); nil == err {
 						if bytes.Equal(blockHash, lowNextHash) {
 							// The chains connect, no need to validate all the way down
 							sts.validBlockRanges[0].lowBlock = sts.validBlockRanges[1].lowBlock
@@ -712,6 +1009,7 @@ func (sts *StateTransferState) verifyAndRecoverBlockchain() bool {
 			}
 			sts.validBlockRanges = sts.validBlockRanges[:len(sts.validBlockRanges)-1]
 			logger.Debug("Deleted from validBlockRanges, new length %d", len(sts.validBlockRanges))
+			sts.persistBlockRanges(nil)
 			return false
 		}
 
@@ -740,6 +1038,7 @@ func (sts *StateTransferState) verifyAndRecoverBlockchain() bool {
 		}
 
 		sts.validBlockRanges[0].lowNextHash = block.PreviousBlockHash
+		sts.persistBlockRanges(nil)
 		return false
 	}
 
@@ -811,6 +1110,11 @@ func (sts *StateTransferState) attemptStateTransfer(currentStateBlockNumber *uin
 		}
 
 		logger.Debug("%v completed state transfer to block %d", sts.id, *currentStateBlockNumber)
+		sts.persistStateBlock(*currentStateBlockNumber)
+	} else if stateBlock, ok := sts.persistedStateBlock(); ok && stateBlock < sts.stack.GetBlockchainSize() {
+		// A state transfer was interrupted, the state hash is checked against this block below before it is relied upon
+		logger.Info("%v resuming interrupted state transfer from the state for block %d", sts.id, stateBlock)
+		*currentStateBlockNumber = stateBlock
 	} else {
 		*currentStateBlockNumber = sts.stack.GetBlockchainSize() - 1 // The block height is one more than the latest block number
 	}
@@ -845,6 +1149,7 @@ func (sts *StateTransferState) attemptStateTransfer(currentStateBlockNumber *uin
 
 	if !*blocksValid {
 		(*mark) = *blockHReply // We now know of a more recent block hash
+		sts.statusTarget((*mark).blockNumber, (*mark).metadata)
 
 		blockReplyChannel := make(chan error)
 
@@ -853,6 +1158,7 @@ func (sts *StateTransferState) attemptStateTransfer(currentStateBlockNumber *uin
 			reportOnBlock:  *currentStateBlockNumber,
 			replyChan:      blockReplyChannel,
 			firstBlockHash: (*blockHReply).blockHash,
+			resync:         sts.resyncBlocks,
 		}
 
 		select {
@@ -875,7 +1181,16 @@ func (sts *StateTransferState) attemptStateTransfer(currentStateBlockNumber *uin
 		logger.Debug("%v already has valid blocks through %d necessary to validate the state for block %d", sts.id, (*blockHReply).blockNumber, *currentStateBlockNumber)
 	}
 
+	// The state hashes of these blocks are what the state and the state deltas are checked against, so do not apply anything unless they chain to the target
+	if err := sts.verifyChainToTarget((*blockHReply).blockNumber, *currentStateBlockNumber, (*blockHReply).blockHash); nil != err {
+		*blocksValid = false
+		sts.resyncBlocks = true
+		return fmt.Errorf("%v will not apply state for block %d, its blocks do not verify against the target, syncing them again: %s", sts.id, (*blockHReply).blockNumber, err)
+	}
+	sts.resyncBlocks = false
+
 	if *currentStateBlockNumber+uint64(sts.maxStateDeltas) < (*blockHReply).blockNumber {
+		sts.stateValid = false
 		return fmt.Errorf("%v has a state for block %d which is too far out of date to play forward to block %d, max deltas are %d, invalidating",
 			sts.id, *currentStateBlockNumber, (*blockHReply).blockNumber, sts.maxStateDeltas)
 	}
@@ -925,6 +1240,7 @@ func (sts *StateTransferState) stateThread() {
 		// Wait for state sync to become necessary
 		case mark := <-sts.initiateStateSync:
 			sts.informListeners(mark.blockNumber, mark.blockHash, mark.peerIDs, mark.metadata, nil, initiated)
+			sts.statusStarted(mark.metadata)
 			sts.stateThreadIdle = false
 
 			logger.Debug("%v is initiating state transfer", sts.id)
@@ -936,6 +1252,7 @@ func (sts *StateTransferState) stateThread() {
 			for {
 				if err := sts.attemptStateTransfer(&currentStateBlockNumber, &mark, &blockHReply, &blocksValid); err != nil {
 					logger.Error("%s", err)
+					sts.statusErrored()
 					sts.informListeners(0, nil, mark.peerIDs, nil, err, errored)
 					select {
 					case <-sts.threadExit:
@@ -953,8 +1270,10 @@ func (sts *StateTransferState) stateThread() {
 
 			logger.Debug("%v is completing state transfer", sts.id)
 
+			sts.clearStateBlock()
 			sts.asynchronousTransferInProgress = false
 
+			sts.statusCompleted()
 			sts.informListeners(blockHReply.blockNumber, blockHReply.blockHash, blockHReply.peerIDs, blockHReply.metadata, nil, completed)
 		case sts.stateThreadIdleChan <- struct{}{}:
 			logger.Debug("%v state thread reporting as idle to unblock someone", sts.id)
@@ -1017,6 +1336,7 @@ func (sts *StateTransferState) informListeners(blockNumber uint64, blockHash []b
 func (sts *StateTransferState) playStateUpToBlockNumber(fromBlockNumber, toBlockNumber uint64, peerIDs []*protos.PeerID) (uint64, error) {
 	logger.Debug("%v attempting to play state forward from %v to block %d", sts.id, peerIDs, toBlockNumber)
 	currentBlock := fromBlockNumber
+	sts.statusDeltasRemaining(toBlockNumber - fromBlockNumber + 1)
 	err := sts.tryOverPeers(peerIDs, func(peerID *protos.PeerID) error {
 
 		intermediateBlock := currentBlock - 1 // Underflow is okay here, as we immediately overflow, and assign
@@ -1089,6 +1409,9 @@ func (sts *StateTransferState) playStateUpToBlockNumber(fromBlockNumber, toBlock
 					return fmt.Errorf("%v played state forward according to %v, hashes matched, but failed to commit, invalidated state", sts.id, peerID)
 				}
 
+				sts.statusDeltasApplied(deltaMessage.Range.End - deltaMessage.Range.Start + 1)
+				currentBlock = deltaMessage.Range.End
+				sts.persistStateBlock(currentBlock)
 				if currentBlock == toBlockNumber {
 					return nil
 				}
@@ -1109,6 +1432,10 @@ func (sts *StateTransferState) playStateUpToBlockNumber(fromBlockNumber, toBlock
 // not to consider this state as valid
 func (sts *StateTransferState) syncStateSnapshot(minBlockNumber uint64, peerIDs []*protos.PeerID) (uint64, error) {
 
+	if sts.snapshotChunkSize > 0 {
+		return sts.syncStateSnapshotChunks(peerIDs)
+	}
+
 	logger.Debug("%v attempting to retrieve state snapshot from recovery from %v", sts.id, peerIDs)
 
 	currentStateBlock := uint64(0)
@@ -1155,6 +1482,7 @@ func (sts *StateTransferState) syncStateSnapshot(minBlockNumber uint64, peerIDs
 				if err := sts.stack.CommitStateDelta(piece); nil != err {
 					return fmt.Errorf("%v could not commit state delta from %v after %d deltas: %s", sts.id, counter, peerID, err)
 				}
+				sts.statusSnapshotDeltas(1)
 				counter++
 			case <-timer.C:
 				return fmt.Errorf("%v timed out during state recovery from %v", sts.id, peerID)
@@ -1166,6 +1494,102 @@ func (sts *StateTransferState) syncStateSnapshot(minBlockNumber uint64, peerIDs
 	return currentStateBlock, ok
 }
 
+// This function retrieves the current state from a peer as Merkle-proved chunks, every chunk is verified before it is applied
+// Should a peer fail, the remaining chunks are retrieved from the next peer, as long as it serves a snapshot with the same root
+// As with syncStateSnapshot, the state must still be verified against a block before it is considered valid
+func (sts *StateTransferState) syncStateSnapshotChunks(peerIDs []*protos.PeerID) (uint64, error) {
+
+	logger.Debug("%v attempting to retrieve state snapshot chunks from recovery from %v", sts.id, peerIDs)
+
+	var merkleRoot []byte // The root of the snapshot being retrieved, nil until its first chunk has been received
+	var chunkCount, nextChunk uint64
+	currentStateBlock := uint64(0)
+
+	err := sts.tryOverPeers(peerIDs, func(peerID *protos.PeerID) error {
+		if 0 == nextChunk {
+			merkleRoot = nil
+			if err := sts.stack.EmptyState(); nil != err {
+				logger.Error("Could not empty the current state: %s", err)
+			}
+		}
+
+		logger.Debug("%v is initiating state recovery from %v at chunk %d", sts.id, peerID, nextChunk)
+
+		stateChan, err := sts.GetRemoteStateSnapshotChunks(peerID, sts.snapshotChunkSize, nextChunk)
+		if err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(sts.StateSnapshotRequestTimeout)
+		defer timer.Stop()
+
+		for {
+			select {
+			case piece, ok := <-stateChan:
+				if !ok {
+					return fmt.Errorf("%v had state snapshot channel close prematurely after %d of %d chunks", sts.id, nextChunk, chunkCount)
+				}
+
+				if nil == merkleRoot {
+					merkleRoot, chunkCount, currentStateBlock = piece.MerkleRoot, piece.ChunkCount, piece.BlockNumber
+					logger.Debug("%v retrieving state snapshot for block %d from %v as %d chunks with Merkle root %x", sts.id, currentStateBlock, peerID, chunkCount, merkleRoot)
+				} else if !bytes.Equal(merkleRoot, piece.MerkleRoot) || chunkCount != piece.ChunkCount || currentStateBlock != piece.BlockNumber {
+					// The chunks already applied cannot be combined with those of another snapshot, so the next peer starts over
+					nextChunk = 0
+					return fmt.Errorf("%v received a chunk from %v of the snapshot for block %d with root %x, but was retrieving the snapshot for block %d with root %x",
+						sts.id, peerID, piece.BlockNumber, piece.MerkleRoot, currentStateBlock, merkleRoot)
+				}
+
+				if 0 == len(piece.Delta) {
+					if nextChunk != chunkCount {
+						return fmt.Errorf("%v received the end of the state snapshot from %v after %d of %d chunks", sts.id, peerID, nextChunk, chunkCount)
+					}
+					if 0 == chunkCount && !bytes.Equal(merkleRoot, util.NewMerkleTree(nil).Root()) {
+						return fmt.Errorf("%v received an empty state snapshot from %v with Merkle root %x", sts.id, peerID, merkleRoot)
+					}
+					logger.Debug("%v received final chunk of state snapshot from %v after %d chunks", sts.id, peerID, chunkCount)
+					return nil
+				}
+
+				if piece.Sequence != nextChunk {
+					return fmt.Errorf("%v received state snapshot chunk %d from %v, was expecting chunk %d", sts.id, piece.Sequence, peerID, nextChunk)
+				}
+
+				if !util.VerifyMerkleProof(piece.Delta, piece.Sequence, chunkCount, piece.MerkleProof, merkleRoot) {
+					return fmt.Errorf("%v received state snapshot chunk %d from %v which does not prove against Merkle root %x", sts.id, piece.Sequence, peerID, merkleRoot)
+				}
+
+				deltas, err := statemgmt.DecodeSnapshotChunk(piece.Delta)
+				if nil != err {
+					// The chunk is proven, so the snapshot itself is bad, the next peer must start over
+					nextChunk = 0
+					return fmt.Errorf("%v received a corrupt state snapshot chunk %d from %v : %s", sts.id, piece.Sequence, peerID, err)
+				}
+
+				for _, delta := range deltas {
+					umDelta := &statemgmt.StateDelta{}
+					if err := umDelta.Unmarshal(delta); nil != err {
+						nextChunk = 0
+						return fmt.Errorf("%v received a corrupt delta in state snapshot chunk %d from %v : %s", sts.id, piece.Sequence, peerID, err)
+					}
+					sts.stack.ApplyStateDelta(umDelta, umDelta)
+					if err := sts.stack.CommitStateDelta(umDelta); nil != err {
+						// The state now holds part of the chunk, the next peer must start over
+						nextChunk = 0
+						return fmt.Errorf("%v could not commit state delta in state snapshot chunk %d from %v: %s", sts.id, piece.Sequence, peerID, err)
+					}
+				}
+				sts.statusSnapshotDeltas(uint64(len(deltas)))
+				nextChunk++
+			case <-timer.C:
+				return fmt.Errorf("%v timed out during state recovery from %v after %d of %d chunks", sts.id, peerID, nextChunk, chunkCount)
+			}
+		}
+	})
+
+	return currentStateBlock, err
+}
+
 // The below were stolen from helper.go, they should eventually be removed there, and probably made private here
 
 // GetRemoteBlocks will return a channel to stream blocks from the desired replicaID
@@ -1189,6 +1613,15 @@ func (sts *StateTransferState) GetRemoteStateSnapshot(replicaID *protos.PeerID)
 	return remoteLedger.RequestStateSnapshot()
 }
 
+// GetRemoteStateSnapshotChunks will return a channel to stream a state snapshot as Merkle-proved chunks from the desired replicaID
+func (sts *StateTransferState) GetRemoteStateSnapshotChunks(replicaID *protos.PeerID, chunkSize, startChunk uint64) (<-chan *protos.SyncStateSnapshot, error) {
+	remoteLedger, err := sts.stack.GetRemoteLedger(replicaID)
+	if nil != err {
+		return nil, err
+	}
+	return remoteLedger.RequestStateSnapshotChunks(chunkSize, startChunk)
+}
+
 // GetRemoteStateDeltas will return a channel to stream a state snapshot deltas from the desired replicaID
 func (sts *StateTransferState) GetRemoteStateDeltas(replicaID *protos.PeerID, start, finish uint64) (<-chan *protos.SyncStateDeltas, error) {
 	remoteLedger, err := sts.stack.GetRemoteLedger(replicaID)
@@ -26,6 +26,7 @@ import (
 
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/peer"
+	"github.com/hyperledger/fabric/core/util"
 	"github.com/hyperledger/fabric/protos"
 )
 
@@ -201,6 +202,9 @@ func (rl *remoteLedger) RequestBlocks(rng *protos.SyncBlockRange) (<-chan *proto
 func (rl *remoteLedger) RequestStateSnapshot() (<-chan *protos.SyncStateSnapshot, error) {
 	return rl.mockLedger.GetRemoteStateSnapshot(rl.peerID)
 }
+func (rl *remoteLedger) RequestStateSnapshotChunks(chunkSize, startChunk uint64) (<-chan *protos.SyncStateSnapshot, error) {
+	return rl.mockLedger.GetRemoteStateSnapshotChunks(rl.peerID, chunkSize, startChunk)
+}
 func (rl *remoteLedger) RequestStateDeltas(rng *protos.SyncBlockRange) (<-chan *protos.SyncStateDeltas, error) {
 	return rl.mockLedger.GetRemoteStateDeltas(rl.peerID, rng.Start, rng.End)
 }
@@ -372,6 +376,82 @@ func (mock *MockLedger) GetRemoteStateSnapshot(peerID *protos.PeerID) (<-chan *p
 	return res, nil
 }
 
+func (mock *MockLedger) GetRemoteStateSnapshotChunks(peerID *protos.PeerID, chunkSize, startChunk uint64) (<-chan *protos.SyncStateSnapshot, error) {
+
+	rl, ok := mock.remoteLedgers.GetLedgerByPeerID(peerID)
+	if !ok {
+		return nil, fmt.Errorf("Bad peer ID %v", peerID)
+	}
+
+	remoteBlockHeight := rl.GetBlockchainSize()
+	ft := mock.filter(SyncSnapshot, peerID)
+
+	var deltas [][]byte
+	for i := uint64(0); i < remoteBlockHeight; i++ {
+		remoteBlock, err := rl.GetBlockByNumber(i)
+		if nil != err {
+			return nil, err
+		}
+		for _, transaction := range remoteBlock.Transactions {
+			deltas = append(deltas, SimpleBytesToStateDelta(transaction.Payload).Marshal())
+		}
+	}
+
+	var chunks [][]byte
+	for i := uint64(0); i < uint64(len(deltas)); i += chunkSize {
+		end := i + chunkSize
+		if end > uint64(len(deltas)) {
+			end = uint64(len(deltas))
+		}
+		chunks = append(chunks, statemgmt.EncodeSnapshotChunk(deltas[i:end]))
+	}
+	tree := util.NewMerkleTree(chunks)
+	chunkCount := uint64(len(chunks))
+
+	res := make(chan *protos.SyncStateSnapshot, len(chunks)+2) // Allows the thread to exit even if the consumer doesn't finish
+	if ft == Timeout {
+		return res, nil
+	}
+
+	go func() {
+		piece := func(i uint64) *protos.SyncStateSnapshot {
+			return &protos.SyncStateSnapshot{
+				Delta:       chunks[i],
+				Sequence:    i,
+				BlockNumber: remoteBlockHeight - 1,
+				ChunkCount:  chunkCount,
+				MerkleRoot:  tree.Root(),
+				MerkleProof: tree.Proof(i),
+			}
+		}
+
+		for i := startChunk; i < chunkCount; i++ {
+			switch {
+			case ft == Normal || i != chunkCount/2:
+				res <- piece(i)
+			case ft == Corrupt:
+				corrupt := piece(i)
+				corrupt.Delta = statemgmt.EncodeSnapshotChunk([][]byte{[]byte("GARBAGE_DELTA")})
+				res <- corrupt
+				return
+			case ft == OutOfOrder:
+				res <- piece((i + 1) % chunkCount)
+				return
+			default:
+				mock.t.Fatalf("Unsupported filter result %d", ft)
+			}
+		}
+		res <- &protos.SyncStateSnapshot{
+			Delta:       []byte{},
+			Sequence:    chunkCount,
+			BlockNumber: remoteBlockHeight - 1,
+			ChunkCount:  chunkCount,
+			MerkleRoot:  tree.Root(),
+		}
+	}()
+	return res, nil
+}
+
 func (mock *MockLedger) GetRemoteStateDeltas(peerID *protos.PeerID, start, finish uint64) (<-chan *protos.SyncStateDeltas, error) {
 	return mock.getRemoteStateDeltas(peerID, start, finish, SyncDeltas)
 }
@@ -395,6 +395,43 @@ func TestCatchupSyncSnapshotError(t *testing.T) {
 	}
 }
 
+func TestCatchupSnapshotChunks(t *testing.T) {
+	for _, chunkSize := range []uint64{1, 3, 100} {
+		mrls := createRemoteLedgers(1, 3)
+
+		// Test from blockheight of 5 (with missing blocks 0-3)
+		ml := NewMockLedger(mrls, nil, t)
+		ml.PutBlock(4, SimpleGetBlock(4))
+		sts := newTestStateTransfer(ml, mrls)
+		defer sts.Stop()
+		sts.snapshotChunkSize = chunkSize
+		if err := executeStateTransfer(sts, ml, 7, 10, mrls); nil != err {
+			t.Fatalf("Snapshot chunks of size %d: %s", chunkSize, err)
+		}
+	}
+}
+
+func TestCatchupSnapshotChunksError(t *testing.T) {
+	for _, failureType := range AllFailures {
+		mrls := createRemoteLedgers(1, 3)
+
+		// The failing peer stops after a few valid chunks, the rest are retrieved from another peer
+		filter, result := makeSimpleFilter(SyncSnapshot, failureType)
+		ml := NewMockLedger(mrls, filter, t)
+		ml.PutBlock(4, SimpleGetBlock(4))
+		sts := newTestStateTransfer(ml, mrls)
+		defer sts.Stop()
+		sts.snapshotChunkSize = 1
+		sts.StateSnapshotRequestTimeout = 10 * time.Millisecond
+		if err := executeStateTransfer(sts, ml, 7, 10, mrls); nil != err {
+			t.Fatalf("SnapshotChunksError %s case: %s", failureType, err)
+		}
+		if !result.wasTriggered() {
+			t.Fatalf("SnapshotChunksError case never simulated a %s", failureType)
+		}
+	}
+}
+
 func TestCatchupSyncDeltasError(t *testing.T) {
 	for _, failureType := range AllFailures {
 		mrls := createRemoteLedgers(1, 3)
@@ -613,6 +650,93 @@ func TestCatchupLaggingChainsErrors(t *testing.T) {
 	}
 }
 
+func executeParallelBlockRecovery(ml *MockLedger, startingBlock uint64, mrls *MockRemoteHashLedgerDirectory) error {
+	sts := newTestThreadlessStateTransfer(ml, mrls)
+	sts.BlockRequestTimeout = 100 * time.Millisecond
+	sts.RecoverDamage = true
+	sts.maxBlockRange = 10
+	sts.blockVerifyChunkSize = 10
+	sts.parallelFetch = 3
+
+	w := make(chan struct{})
+
+	go func() {
+		for !sts.verifyAndRecoverBlockchain() {
+		}
+		w <- struct{}{}
+	}()
+
+	select {
+	case <-time.After(time.Second * 2):
+		return fmt.Errorf("Timed out waiting for blocks to replicate for blockchain")
+	case <-w:
+		// Do nothing, continue the test
+	}
+
+	if n, err := ml.VerifyBlockchain(startingBlock, 0); 0 != n || nil != err {
+		return fmt.Errorf("Blockchain claims to be up to date, but does not verify")
+	}
+
+	return nil
+}
+
+func TestCatchupLaggingChainsParallel(t *testing.T) {
+	mrls := createRemoteLedgers(0, 3)
+
+	for peerID := range mrls.remoteLedgers {
+		mrls.GetMockRemoteLedgerByPeerID(&peerID).blockHeight = 201
+	}
+
+	mutex := &sync.Mutex{}
+	peers := make(map[protos.PeerID]int)
+	ml := NewMockLedger(mrls, func(request mockRequest, peerID *protos.PeerID) mockResponse {
+		if request == SyncBlocks {
+			mutex.Lock()
+			peers[*peerID]++
+			mutex.Unlock()
+		}
+		return Normal
+	}, t)
+	ml.PutBlock(200, SimpleGetBlock(200))
+
+	if err := executeParallelBlockRecovery(ml, 200, mrls); nil != err {
+		t.Fatalf("TestCatchupLaggingChainsParallel failure: %s", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	requests := 0
+	for _, count := range peers {
+		requests += count
+	}
+	if requests != 20 {
+		t.Errorf("Expected 20 requests for ranges of 10 blocks, but got %d", requests)
+	}
+	if len(peers) < 3 {
+		t.Errorf("Expected block ranges to be requested from 3 peers, but only %d were asked", len(peers))
+	}
+}
+
+func TestCatchupLaggingChainsParallelErrors(t *testing.T) {
+	for _, failureType := range AllFailures {
+		mrls := createRemoteLedgers(0, 3)
+
+		for peerID := range mrls.remoteLedgers {
+			mrls.GetMockRemoteLedgerByPeerID(&peerID).blockHeight = 201
+		}
+
+		filter, result := makeSimpleFilter(SyncBlocks, failureType)
+		ml := NewMockLedger(mrls, filter, t)
+		ml.PutBlock(200, SimpleGetBlock(200))
+		if err := executeParallelBlockRecovery(ml, 200, mrls); nil != err {
+			t.Fatalf("TestCatchupLaggingChainsParallelErrors %s failure: %s", failureType, err)
+		}
+		if !result.wasTriggered() {
+			t.Fatalf("TestCatchupLaggingChainsParallelErrors never simulated a %s", failureType)
+		}
+	}
+}
+
 func TestCatchupCorruptChains(t *testing.T) {
 	mrls := createRemoteLedgers(0, 3)
 
@@ -640,6 +764,49 @@ func TestCatchupCorruptChains(t *testing.T) {
 	}
 }
 
+func TestCatchupCorruptBlockBeforeDeltas(t *testing.T) {
+	mrls := createRemoteLedgers(0, 3)
+
+	snapshots := &filterResult{triggered: false, mutex: &sync.Mutex{}}
+	ml := NewMockLedger(mrls, func(request mockRequest, peerID *protos.PeerID) mockResponse {
+		if request == SyncSnapshot {
+			snapshots.mutex.Lock()
+			snapshots.triggered = true
+			snapshots.mutex.Unlock()
+		}
+		return Normal
+	}, t)
+	for i := uint64(0); i <= 10; i++ {
+		ml.PutBlock(i, SimpleGetBlock(i))
+	}
+	// Block 8 no longer hashes to the PreviousBlockHash of block 9, and carries a bad state hash
+	corrupt := SimpleGetBlock(8)
+	corrupt.StateHash = []byte("GARBAGE_STATE_HASH")
+	ml.PutBlock(8, corrupt)
+	ml.state = SimpleGetState(5)
+
+	// The whole blockchain is believed to be valid, and the state to be that of block 5, as after an interrupted transfer
+	mp := newMockPersistor()
+	sts := newPersistentTestThreadlessStateTransfer(ml, mrls, mp)
+	sts.validBlockRanges = []*blockRange{{highBlock: 10, lowBlock: 0, lowNextHash: SimpleGetBlockHash(^uint64(0))}}
+	sts.persistBlockRanges(nil)
+	sts.persistStateBlock(5)
+
+	sts = NewStateTransferState(&persistentPartialStack{newPartialStack(ml, mrls), mp})
+	defer sts.Stop()
+	if err := executeStateTransfer(sts, ml, 10, 10, mrls); nil != err {
+		t.Fatalf("State transfer failed: %s", err)
+	}
+
+	block, _ := ml.GetBlock(8)
+	if blockHash, _ := ml.HashBlock(block); !bytes.Equal(blockHash, SimpleGetBlockHash(8)) {
+		t.Errorf("Expected the corrupt block to be synced again before playing the state forward")
+	}
+	if snapshots.wasTriggered() {
+		t.Errorf("Expected the state deltas to be played against the synced blocks, but a state snapshot was retrieved")
+	}
+}
+
 type listenerHelper struct {
 	resultChannel chan struct{}
 	ProtoListener
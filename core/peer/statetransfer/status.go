@@ -0,0 +1,176 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statetransfer
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/metrics"
+	"github.com/hyperledger/fabric/protos"
+)
+
+// Status describes the progress of the current, or the last, state transfer
+type Status struct {
+	InProgress     bool           // Whether a state transfer is currently running
+	TargetBlock    uint64         // The block the state is being transferred to, 0 until a target block hash has been received
+	TargetMetadata interface{}    // The metadata passed to AddTarget with the target, for consensus this is the target seqNo
+	BlocksFetched  uint64         // Blocks retrieved from other peers and verified
+	BlocksToFetch  uint64         // Blocks fetched so far plus those still missing or invalid
+	DeltasApplied  uint64         // Blocks worth of state deltas played forward
+	DeltasToApply  uint64         // Blocks worth of state deltas played forward so far plus those still to play, known once the blocks have been synced
+	SnapshotDeltas uint64         // State deltas applied from a state snapshot, whose total is not known in advance
+	SourcePeer     *protos.PeerID // The peer most recently asked for blocks or state
+	Started        time.Time      // When the state transfer began
+	ETA            time.Duration  // Estimated time until the blocks and deltas have all been applied, 0 if unknown
+}
+
+// ProgressListener may additionally be implemented by a Listener to be informed whenever a state transfer makes progress
+// It is invoked from the state transfer threads, and must not block
+type ProgressListener interface {
+	Progress(Status)
+}
+
+// statusMetrics are the metrics state transfer exposes through the metrics registry of the peer
+type statusMetrics struct {
+	inProgress  *metrics.Gauge
+	targetBlock *metrics.Gauge
+
+	transfers      *metrics.Counter
+	errors         *metrics.Counter
+	blocksFetched  *metrics.Counter
+	deltasApplied  *metrics.Counter
+	snapshotDeltas *metrics.Counter
+}
+
+func newStatusMetrics(id *protos.PeerID) *statusMetrics {
+	r := metrics.DefaultRegistry
+	labels := metrics.Labels{"peer": id.Name}
+	return &statusMetrics{
+		inProgress:  r.NewGauge("statetransfer_in_progress", "1 while a state transfer is running, 0 otherwise", labels),
+		targetBlock: r.NewGauge("statetransfer_target_block", "Block the current or last state transfer targeted", labels),
+
+		transfers:      r.NewCounter("statetransfer_completed_total", "State transfers completed", labels),
+		errors:         r.NewCounter("statetransfer_errors_total", "State transfer attempts which failed and were retried", labels),
+		blocksFetched:  r.NewCounter("statetransfer_blocks_fetched_total", "Blocks retrieved from other peers and verified", labels),
+		deltasApplied:  r.NewCounter("statetransfer_deltas_applied_total", "Blocks worth of state deltas played forward", labels),
+		snapshotDeltas: r.NewCounter("statetransfer_snapshot_deltas_total", "State deltas applied from state snapshots", labels),
+	}
+}
+
+// Status returns the progress of the current state transfer, or of the last one if none is running
+func (sts *StateTransferState) Status() Status {
+	sts.statusLock.Lock()
+	defer sts.statusLock.Unlock()
+
+	status := sts.status
+	done := status.BlocksFetched + status.DeltasApplied
+	total := status.BlocksToFetch + status.DeltasToApply
+	if status.InProgress && done > 0 && total > done {
+		elapsed := time.Since(status.Started)
+		status.ETA = time.Duration(float64(elapsed) * float64(total-done) / float64(done))
+	}
+	return status
+}
+
+// updateStatus applies update to the status, and informs the listeners which implement ProgressListener
+func (sts *StateTransferState) updateStatus(update func(status *Status)) {
+	sts.statusLock.Lock()
+	update(&sts.status)
+	sts.statusLock.Unlock()
+
+	status := sts.Status()
+
+	sts.stateTransferListenersLock.Lock()
+	defer sts.stateTransferListenersLock.Unlock()
+	for _, listener := range sts.stateTransferListeners {
+		if pl, ok := listener.(ProgressListener); ok {
+			pl.Progress(status)
+		}
+	}
+}
+
+func (sts *StateTransferState) statusStarted(metadata interface{}) {
+	sts.metrics.inProgress.Set(1)
+	sts.updateStatus(func(status *Status) {
+		*status = Status{
+			InProgress:     true,
+			TargetMetadata: metadata,
+			Started:        time.Now(),
+		}
+	})
+}
+
+func (sts *StateTransferState) statusTarget(blockNumber uint64, metadata interface{}) {
+	sts.metrics.targetBlock.Set(float64(blockNumber))
+	sts.updateStatus(func(status *Status) {
+		status.TargetBlock = blockNumber
+		status.TargetMetadata = metadata
+	})
+}
+
+func (sts *StateTransferState) statusErrored() {
+	sts.metrics.errors.Inc()
+}
+
+func (sts *StateTransferState) statusCompleted() {
+	sts.metrics.inProgress.Set(0)
+	sts.metrics.transfers.Inc()
+	sts.updateStatus(func(status *Status) {
+		status.InProgress = false
+	})
+}
+
+func (sts *StateTransferState) statusSource(peerID *protos.PeerID) {
+	sts.updateStatus(func(status *Status) {
+		status.SourcePeer = peerID
+	})
+}
+
+// statusBlocksRemaining records that count more blocks must be fetched before the block sync completes
+func (sts *StateTransferState) statusBlocksRemaining(count uint64) {
+	sts.updateStatus(func(status *Status) {
+		status.BlocksToFetch = status.BlocksFetched + count
+	})
+}
+
+func (sts *StateTransferState) statusBlockFetched() {
+	sts.metrics.blocksFetched.Inc()
+	sts.updateStatus(func(status *Status) {
+		status.BlocksFetched++
+	})
+}
+
+// statusDeltasRemaining records that count more blocks worth of state deltas must be played forward
+func (sts *StateTransferState) statusDeltasRemaining(count uint64) {
+	sts.updateStatus(func(status *Status) {
+		status.DeltasToApply = status.DeltasApplied + count
+	})
+}
+
+func (sts *StateTransferState) statusDeltasApplied(count uint64) {
+	sts.metrics.deltasApplied.Add(count)
+	sts.updateStatus(func(status *Status) {
+		status.DeltasApplied += count
+	})
+}
+
+func (sts *StateTransferState) statusSnapshotDeltas(count uint64) {
+	sts.metrics.snapshotDeltas.Add(count)
+	sts.updateStatus(func(status *Status) {
+		status.SnapshotDeltas += count
+	})
+}
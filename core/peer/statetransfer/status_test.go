@@ -0,0 +1,84 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statetransfer
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatusProgress(t *testing.T) {
+	mrls := createRemoteLedgers(1, 3)
+
+	// Test from blockheight of 1, with valid genesis block
+	ml := NewMockLedger(mrls, nil, t)
+	ml.PutBlock(0, SimpleGetBlock(0))
+
+	sts := newTestStateTransfer(ml, mrls)
+	defer sts.Stop()
+
+	mutex := &sync.Mutex{}
+	var updates []Status
+	listener := struct{ ProtoListener }{}
+	listener.ProgressImpl = func(status Status) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		updates = append(updates, status)
+	}
+	sts.RegisterListener(&listener)
+	defer sts.UnregisterListener(&listener)
+
+	if err := executeStateTransfer(sts, ml, 7, 10, mrls); nil != err {
+		t.Fatalf("Progress case: %s", err)
+	}
+
+	status := sts.Status()
+	if status.InProgress {
+		t.Errorf("Expected the state transfer to be reported as complete")
+	}
+	if 7 != status.TargetBlock {
+		t.Errorf("Expected the target block to be 7, got %d", status.TargetBlock)
+	}
+	// Blocks 7 through 0 are fetched to validate the genesis state, then the deltas of blocks 1 through 7 are played onto it
+	if 8 != status.BlocksFetched || 8 != status.BlocksToFetch {
+		t.Errorf("Expected 8 of 8 blocks to be fetched, got %d of %d", status.BlocksFetched, status.BlocksToFetch)
+	}
+	if 7 != status.DeltasApplied || 7 != status.DeltasToApply {
+		t.Errorf("Expected 7 of 7 deltas to be applied, got %d of %d", status.DeltasApplied, status.DeltasToApply)
+	}
+	if nil == status.SourcePeer {
+		t.Errorf("Expected the source peer to be set")
+	}
+	if status.Started.IsZero() || status.Started.After(time.Now()) {
+		t.Errorf("Expected a valid start time, got %v", status.Started)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if 0 == len(updates) {
+		t.Fatalf("Expected progress to be reported to the listener")
+	}
+	for i := 1; i < len(updates); i++ {
+		if updates[i].BlocksFetched < updates[i-1].BlocksFetched || updates[i].DeltasApplied < updates[i-1].DeltasApplied {
+			t.Fatalf("Expected progress to only advance, update %d was %+v after %+v", i, updates[i], updates[i-1])
+		}
+	}
+	if last := updates[len(updates)-1]; last.InProgress {
+		t.Errorf("Expected the last progress update to report completion")
+	}
+}
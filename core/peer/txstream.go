@@ -0,0 +1,274 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+const (
+	defaultTxStreamWindow        = 100
+	defaultTxStreamCommitTimeout = 60 * time.Second
+)
+
+// ProcessTransactionStream implementation of the ProcessTransactionStream bidi streaming RPC function
+func (p *PeerImpl) ProcessTransactionStream(stream pb.Peer_ProcessTransactionStreamServer) error {
+	window := viper.GetInt("peer.txstream.window")
+	if window <= 0 {
+		window = defaultTxStreamWindow
+	}
+	commitTimeout := viper.GetDuration("peer.txstream.commitTimeout")
+	if commitTimeout <= 0 {
+		commitTimeout = defaultTxStreamCommitTimeout
+	}
+
+	process := func(tx *pb.Transaction) *pb.Response {
+		response, _ := p.ProcessTransaction(stream.Context(), tx)
+		return response
+	}
+	ts := newTxStream(stream, process, p.isValidator, window, commitTimeout)
+	if p.isValidator {
+		p.ledgerWrapper.RLock()
+		ledger := p.ledgerWrapper.ledger
+		p.ledgerWrapper.RUnlock()
+		ledger.RegisterBlockListener(ts)
+		defer ledger.UnregisterBlockListener(ts)
+	}
+	return ts.run()
+}
+
+// txReceipt is a receipt queued for sending, a final receipt releases the
+// window slot held by its transaction once sent
+type txReceipt struct {
+	receipt *pb.TransactionReceipt
+	final   bool
+}
+
+// pendingTx is a transaction waiting to be committed
+type pendingTx struct {
+	accepted bool                   // the ACCEPTED receipt has been queued
+	result   *pb.TransactionReceipt // set if the transaction was committed before being accepted
+	timer    *time.Timer
+}
+
+// txStream serves a single ProcessTransactionStream. Every transaction read
+// from the stream holds a slot of the window until its final receipt has
+// been sent, which bounds both the transactions in flight and the receipts
+// queued for sending
+type txStream struct {
+	stream        pb.Peer_ProcessTransactionStreamServer
+	process       func(*pb.Transaction) *pb.Response
+	trackCommits  bool
+	commitTimeout time.Duration
+
+	slots    chan struct{}
+	receipts chan txReceipt
+	inFlight sync.WaitGroup
+	done     chan struct{}
+	sendErr  error
+
+	lock    sync.Mutex
+	closed  bool
+	pending map[string]*pendingTx
+}
+
+func newTxStream(stream pb.Peer_ProcessTransactionStreamServer, process func(*pb.Transaction) *pb.Response, trackCommits bool, window int, commitTimeout time.Duration) *txStream {
+	return &txStream{
+		stream:        stream,
+		process:       process,
+		trackCommits:  trackCommits,
+		commitTimeout: commitTimeout,
+		slots:         make(chan struct{}, window),
+		receipts:      make(chan txReceipt, 2*window),
+		done:          make(chan struct{}),
+		pending:       make(map[string]*pendingTx),
+	}
+}
+
+// run reads transactions until the client closes its side of the stream,
+// then waits for the final receipts of all transactions in flight
+func (ts *txStream) run() error {
+	go ts.sendReceipts()
+	defer ts.close()
+
+	for {
+		select {
+		case ts.slots <- struct{}{}:
+		case <-ts.stream.Context().Done():
+			return ts.stream.Context().Err()
+		}
+
+		tx, err := ts.stream.Recv()
+		if err == io.EOF {
+			<-ts.slots
+			ts.inFlight.Wait()
+			return ts.sendErr
+		}
+		if err != nil {
+			<-ts.slots
+			return err
+		}
+		ts.inFlight.Add(1)
+		ts.processTransaction(tx)
+	}
+}
+
+func (ts *txStream) processTransaction(tx *pb.Transaction) {
+	peerLogger.Debug("ProcessTransactionStream processing transaction uuid = %s", tx.Uuid)
+	track := ts.trackCommits && tx.Type != pb.Transaction_CHAINCODE_QUERY
+	if track && !ts.addPending(tx.Uuid) {
+		ts.queue(&pb.TransactionReceipt{Uuid: tx.Uuid, Status: pb.TransactionReceipt_REJECTED, Msg: []byte(fmt.Sprintf("Transaction %s is already in flight", tx.Uuid))}, true)
+		return
+	}
+
+	response := ts.process(tx)
+	if response.Status != pb.Response_SUCCESS {
+		if track {
+			ts.removePending(tx.Uuid)
+		}
+		ts.queue(&pb.TransactionReceipt{Uuid: tx.Uuid, Status: pb.TransactionReceipt_REJECTED, Msg: response.Msg}, true)
+		return
+	}
+
+	accepted := &pb.TransactionReceipt{Uuid: tx.Uuid, Status: pb.TransactionReceipt_ACCEPTED, Msg: response.Msg}
+	if !track {
+		ts.queue(accepted, true)
+		return
+	}
+	ts.accept(accepted)
+}
+
+func (ts *txStream) addPending(uuid string) bool {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if _, ok := ts.pending[uuid]; ok {
+		return false
+	}
+	pending := &pendingTx{}
+	pending.timer = time.AfterFunc(ts.commitTimeout, func() {
+		ts.complete(&pb.TransactionReceipt{Uuid: uuid, Status: pb.TransactionReceipt_TIMEOUT})
+	})
+	ts.pending[uuid] = pending
+	return true
+}
+
+func (ts *txStream) removePending(uuid string) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if pending, ok := ts.pending[uuid]; ok {
+		pending.timer.Stop()
+		delete(ts.pending, uuid)
+	}
+}
+
+// accept queues the ACCEPTED receipt of a tracked transaction, followed by
+// its result if the transaction was committed in the meantime
+func (ts *txStream) accept(receipt *pb.TransactionReceipt) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	pending, ok := ts.pending[receipt.Uuid]
+	if !ok {
+		return
+	}
+	ts.queue(receipt, false)
+	pending.accepted = true
+	if pending.result != nil {
+		delete(ts.pending, receipt.Uuid)
+		ts.queue(pending.result, true)
+	}
+}
+
+// complete records the result of a tracked transaction, the first result
+// wins, later ones (such as a timeout racing a commit) are ignored
+func (ts *txStream) complete(result *pb.TransactionReceipt) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	pending, ok := ts.pending[result.Uuid]
+	if !ok || pending.result != nil || ts.closed {
+		return
+	}
+	pending.timer.Stop()
+	if !pending.accepted {
+		pending.result = result
+		return
+	}
+	delete(ts.pending, result.Uuid)
+	ts.queue(result, true)
+}
+
+// BlockAdded implements ledger.BlockListener
+func (ts *txStream) BlockAdded(blockNumber uint64, block *pb.Block) {
+	failed := make(map[string]*pb.TransactionResult)
+	if block.NonHashData != nil {
+		for _, result := range block.NonHashData.TransactionResults {
+			if result.ErrorCode != 0 {
+				failed[result.Uuid] = result
+			}
+		}
+	}
+	for _, tx := range block.Transactions {
+		receipt := &pb.TransactionReceipt{Uuid: tx.Uuid, Status: pb.TransactionReceipt_COMMITTED, BlockNumber: blockNumber}
+		if result, ok := failed[tx.Uuid]; ok {
+			receipt.Status = pb.TransactionReceipt_FAILED
+			receipt.Msg = []byte(result.Error)
+		}
+		ts.complete(receipt)
+	}
+}
+
+// queue never blocks, as every transaction in flight has at most two
+// receipts queued
+func (ts *txStream) queue(receipt *pb.TransactionReceipt, final bool) {
+	ts.receipts <- txReceipt{receipt, final}
+}
+
+func (ts *txStream) sendReceipts() {
+	for {
+		select {
+		case r := <-ts.receipts:
+			if ts.sendErr == nil {
+				if err := ts.stream.Send(r.receipt); err != nil {
+					peerLogger.Error("ProcessTransactionStream failed to send receipt for %s: %s", r.receipt.Uuid, err)
+					ts.sendErr = err
+				}
+			}
+			if r.final {
+				<-ts.slots
+				ts.inFlight.Done()
+			}
+		case <-ts.done:
+			return
+		}
+	}
+}
+
+func (ts *txStream) close() {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	ts.closed = true
+	for _, pending := range ts.pending {
+		pending.timer.Stop()
+	}
+	close(ts.done)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+type mockTxStream struct {
+	grpc.ServerStream
+	in  chan *pb.Transaction
+	out chan *pb.TransactionReceipt
+}
+
+func newMockTxStream() *mockTxStream {
+	return &mockTxStream{
+		in:  make(chan *pb.Transaction),
+		out: make(chan *pb.TransactionReceipt, 100),
+	}
+}
+
+func (m *mockTxStream) Context() context.Context {
+	return context.Background()
+}
+
+func (m *mockTxStream) Recv() (*pb.Transaction, error) {
+	tx, ok := <-m.in
+	if !ok {
+		return nil, io.EOF
+	}
+	return tx, nil
+}
+
+func (m *mockTxStream) Send(receipt *pb.TransactionReceipt) error {
+	m.out <- receipt
+	return nil
+}
+
+func (m *mockTxStream) expect(t *testing.T, uuid string, status pb.TransactionReceipt_Status) *pb.TransactionReceipt {
+	select {
+	case receipt := <-m.out:
+		if receipt.Uuid != uuid || receipt.Status != status {
+			t.Fatalf("Expected %s receipt for %s, got %s for %s", status, uuid, receipt.Status, receipt.Uuid)
+		}
+		return receipt
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s receipt for %s", status, uuid)
+	}
+	return nil
+}
+
+func acceptAll(tx *pb.Transaction) *pb.Response {
+	if tx.Uuid == "bad" {
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte("rejected")}
+	}
+	return &pb.Response{Status: pb.Response_SUCCESS}
+}
+
+func TestTxStreamReceipts(t *testing.T) {
+	stream := newMockTxStream()
+	ts := newTxStream(stream, acceptAll, true, 10, time.Minute)
+	result := make(chan error)
+	go func() { result <- ts.run() }()
+
+	stream.in <- &pb.Transaction{Uuid: "tx1", Type: pb.Transaction_CHAINCODE_INVOKE}
+	stream.expect(t, "tx1", pb.TransactionReceipt_ACCEPTED)
+	stream.in <- &pb.Transaction{Uuid: "bad", Type: pb.Transaction_CHAINCODE_INVOKE}
+	stream.expect(t, "bad", pb.TransactionReceipt_REJECTED)
+	stream.in <- &pb.Transaction{Uuid: "query", Type: pb.Transaction_CHAINCODE_QUERY}
+	stream.expect(t, "query", pb.TransactionReceipt_ACCEPTED)
+	stream.in <- &pb.Transaction{Uuid: "tx2", Type: pb.Transaction_CHAINCODE_INVOKE}
+	stream.expect(t, "tx2", pb.TransactionReceipt_ACCEPTED)
+	close(stream.in)
+
+	ts.BlockAdded(7, &pb.Block{
+		Transactions: []*pb.Transaction{{Uuid: "tx1"}, {Uuid: "tx2"}},
+		NonHashData:  &pb.NonHashData{TransactionResults: []*pb.TransactionResult{{Uuid: "tx2", ErrorCode: 1, Error: "failed"}}},
+	})
+	if receipt := stream.expect(t, "tx1", pb.TransactionReceipt_COMMITTED); receipt.BlockNumber != 7 {
+		t.Errorf("Expected tx1 to be committed in block 7, got %d", receipt.BlockNumber)
+	}
+	if receipt := stream.expect(t, "tx2", pb.TransactionReceipt_FAILED); string(receipt.Msg) != "failed" {
+		t.Errorf("Expected tx2 to fail with its transaction result error, got %s", receipt.Msg)
+	}
+
+	if err := <-result; err != nil {
+		t.Fatalf("Stream ended with error: %s", err)
+	}
+}
+
+func TestTxStreamCommitTimeout(t *testing.T) {
+	stream := newMockTxStream()
+	ts := newTxStream(stream, acceptAll, true, 10, 10*time.Millisecond)
+	result := make(chan error)
+	go func() { result <- ts.run() }()
+
+	stream.in <- &pb.Transaction{Uuid: "tx1", Type: pb.Transaction_CHAINCODE_INVOKE}
+	close(stream.in)
+	stream.expect(t, "tx1", pb.TransactionReceipt_ACCEPTED)
+	stream.expect(t, "tx1", pb.TransactionReceipt_TIMEOUT)
+	<-result
+}
+
+func TestTxStreamWindow(t *testing.T) {
+	stream := newMockTxStream()
+	ts := newTxStream(stream, acceptAll, true, 1, time.Minute)
+	go ts.run()
+	defer close(stream.in)
+
+	stream.in <- &pb.Transaction{Uuid: "tx1", Type: pb.Transaction_CHAINCODE_INVOKE}
+	stream.expect(t, "tx1", pb.TransactionReceipt_ACCEPTED)
+
+	select {
+	case stream.in <- &pb.Transaction{Uuid: "tx2", Type: pb.Transaction_CHAINCODE_INVOKE}:
+		t.Fatalf("Stream should not be read while the window is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ts.BlockAdded(0, &pb.Block{Transactions: []*pb.Transaction{{Uuid: "tx1"}}})
+	stream.expect(t, "tx1", pb.TransactionReceipt_COMMITTED)
+	stream.in <- &pb.Transaction{Uuid: "tx2", Type: pb.Transaction_CHAINCODE_INVOKE}
+	stream.expect(t, "tx2", pb.TransactionReceipt_ACCEPTED)
+	ts.BlockAdded(1, &pb.Block{Transactions: []*pb.Transaction{{Uuid: "tx2"}}})
+}
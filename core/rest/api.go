@@ -25,6 +25,7 @@ import (
 	google_protobuf1 "google/protobuf"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/core/ledger"
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -43,8 +44,10 @@ type PeerInfo interface {
 // ServerOpenchain defines the Openchain server object, which holds the
 // Ledger data structure and the pointer to the peerServer.
 type ServerOpenchain struct {
-	ledger   *ledger.Ledger
-	peerInfo PeerInfo
+	ledger        *ledger.Ledger
+	peerInfo      PeerInfo
+	inspector     consensus.Inspector
+	statusQuerier consensus.StatusQuerier
 }
 
 // NewOpenchainServer creates a new instance of the ServerOpenchain.
@@ -73,6 +76,48 @@ func NewOpenchainServerWithPeerInfo(peerServer PeerInfo) (*ServerOpenchain, erro
 	return s, nil
 }
 
+// SetConsensusInspector sets the consenter whose state the server reports,
+// it is left unset on peers which are not validators
+func (s *ServerOpenchain) SetConsensusInspector(inspector consensus.Inspector) {
+	s.inspector = inspector
+}
+
+// GetConsensusState returns a snapshot of the state of the consenter of the
+// peer
+func (s *ServerOpenchain) GetConsensusState() (*consensus.ReplicaState, error) {
+	if s.inspector == nil {
+		return nil, fmt.Errorf("Peer does not run a consenter which exposes its state")
+	}
+	return s.inspector.Inspect()
+}
+
+// SetConsensusStatusQuerier sets the consenter which reports the status of
+// the transactions submitted to the peer, it is left unset on peers which are
+// not validators
+func (s *ServerOpenchain) SetConsensusStatusQuerier(querier consensus.StatusQuerier) {
+	s.statusQuerier = querier
+}
+
+// GetRequestStatus returns how far the transaction with the uuid progressed
+// through the consenter of the peer. The consenter forgets transactions some
+// time after they executed, these are reported as executed if they are on the
+// ledger
+func (s *ServerOpenchain) GetRequestStatus(uuid string) (*consensus.RequestStatus, error) {
+	if s.statusQuerier == nil {
+		return nil, fmt.Errorf("Peer does not run a consenter which reports the status of requests")
+	}
+	status, err := s.statusQuerier.RequestStatus(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if status.Phase == consensus.RequestUnknown && s.ledger != nil {
+		if _, err := s.ledger.GetTransactionByUUID(uuid); err == nil {
+			status.Phase = consensus.RequestExecuted
+		}
+	}
+	return status, nil
+}
+
 // GetBlockchainInfo returns information about the blockchain ledger such as
 // height, current block hash, and previous block hash.
 func (s *ServerOpenchain) GetBlockchainInfo(ctx context.Context, e *google_protobuf1.Empty) (*pb.BlockchainInfo, error) {
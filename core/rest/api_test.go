@@ -24,6 +24,7 @@ import (
 
 	"google/protobuf"
 
+	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/util"
 	"github.com/hyperledger/fabric/protos"
@@ -441,3 +442,70 @@ func buildTestLedger2(ledger *ledger.Ledger, t *testing.T) {
 func generateUUID(t *testing.T) string {
 	return util.GenerateUUID()
 }
+
+type inspector struct {
+	state *consensus.ReplicaState
+}
+
+func (i *inspector) Inspect() (*consensus.ReplicaState, error) {
+	return i.state, nil
+}
+
+func TestServerOpenchain_API_GetConsensusState(t *testing.T) {
+	server := &ServerOpenchain{}
+	if _, err := server.GetConsensusState(); err == nil {
+		t.Error("Expected an error on a peer which does not run a consenter")
+	}
+
+	state := &consensus.ReplicaState{View: 2, Primary: 2, LastExec: 30}
+	server.SetConsensusInspector(&inspector{state})
+	got, err := server.GetConsensusState()
+	if err != nil {
+		t.Fatalf("Error retrieving consensus state: %s", err)
+	}
+	if got != state {
+		t.Errorf("Expected the state of the consenter, got %+v", got)
+	}
+}
+
+type statusQuerier struct {
+	status map[string]*consensus.RequestStatus
+}
+
+func (sq *statusQuerier) RequestStatus(uuid string) (*consensus.RequestStatus, error) {
+	if status, ok := sq.status[uuid]; ok {
+		return status, nil
+	}
+	return &consensus.RequestStatus{Phase: consensus.RequestUnknown}, nil
+}
+
+func TestServerOpenchain_API_GetRequestStatus(t *testing.T) {
+	ledger1 := ledger.InitTestLedger(t)
+	buildTestLedger1(ledger1, t)
+	server := &ServerOpenchain{ledger: ledger1}
+	if _, err := server.GetRequestStatus("pending"); err == nil {
+		t.Error("Expected an error on a peer which does not run a consenter")
+	}
+
+	server.SetConsensusStatusQuerier(&statusQuerier{map[string]*consensus.RequestStatus{
+		"pending": {Phase: consensus.RequestPrepared, SeqNo: 5},
+	}})
+	status, err := server.GetRequestStatus("pending")
+	if err != nil || status.Phase != consensus.RequestPrepared || status.SeqNo != 5 {
+		t.Errorf("Expected the transaction to be prepared at seqNo 5, got %v, %v", status, err)
+	}
+
+	// the consenter forgot the transaction, but it is on the ledger
+	block, err := ledger1.GetBlockByNumber(1)
+	if err != nil {
+		t.Fatalf("Error retrieving block: %s", err)
+	}
+	status, err = server.GetRequestStatus(block.Transactions[0].Uuid)
+	if err != nil || status.Phase != consensus.RequestExecuted {
+		t.Errorf("Expected a transaction on the ledger to be executed, got %v, %v", status, err)
+	}
+
+	if status, err = server.GetRequestStatus("lost"); err != nil || status.Phase != consensus.RequestUnknown {
+		t.Errorf("Expected the status of the transaction to be unknown, got %v, %v", status, err)
+	}
+}
@@ -1697,6 +1697,83 @@ func (s *ServerOpenchainREST) GetPeers(rw web.ResponseWriter, req *web.Request)
 	}
 }
 
+// consensusStatus is the state of the consenter of the peer, as returned by
+// /network/consensus
+type consensusStatus struct {
+	ReplicaID           uint64 `json:"replicaID"`
+	View                uint64 `json:"view"`
+	ActiveView          bool   `json:"activeView"`
+	Primary             uint64 `json:"primary"`
+	LastExecuted        uint64 `json:"lastExecuted"`
+	LowWatermark        uint64 `json:"lowWatermark"`
+	HighWatermark       uint64 `json:"highWatermark"`
+	CheckpointPeriod    uint64 `json:"checkpointPeriod"`
+	OutstandingRequests int    `json:"outstandingRequests"`
+	ViewChanges         int    `json:"viewChanges"`
+	Synced              bool   `json:"synced"`
+}
+
+// GetConsensusStatus returns the state of the consenter of the target peer,
+// such as its view, the primary of the view, the last executed sequence
+// number, its watermarks and whether it is in sync with the network
+func (s *ServerOpenchainREST) GetConsensusStatus(rw web.ResponseWriter, req *web.Request) {
+	state, err := s.server.GetConsensusState()
+	encoder := json.NewEncoder(rw)
+
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		restLogger.Error(fmt.Sprintf("{\"Error\": \"Querying consensus status -- %s\"}", err))
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	encoder.Encode(&consensusStatus{
+		ReplicaID:           state.ReplicaID,
+		View:                state.View,
+		ActiveView:          state.ActiveView,
+		Primary:             state.Primary,
+		LastExecuted:        state.LastExec,
+		LowWatermark:        state.LowWatermark,
+		HighWatermark:       state.LowWatermark + state.LogSize,
+		CheckpointPeriod:    state.CheckpointPeriod,
+		OutstandingRequests: state.OutstandingRequests,
+		ViewChanges:         len(state.ViewChanges),
+		Synced:              !state.StateTransfer,
+	})
+}
+
+// requestStatus is the progress of a transaction through the consenter of
+// the peer, as returned by /transactions/{uuid}/status
+type requestStatus struct {
+	UUID   string `json:"uuid"`
+	Status string `json:"status"`
+	SeqNo  uint64 `json:"seqNo,omitempty"`
+}
+
+// GetRequestStatus returns how far the transaction with the UUID progressed
+// through the consenter of the target peer: unknown, queued, pre-prepared,
+// prepared, committed or executed, with the sequence number it was assigned
+func (s *ServerOpenchainREST) GetRequestStatus(rw web.ResponseWriter, req *web.Request) {
+	txUUID := req.PathParams["uuid"]
+	status, err := s.server.GetRequestStatus(txUUID)
+	encoder := json.NewEncoder(rw)
+
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		restLogger.Error(fmt.Sprintf("{\"Error\": \"Querying status of transaction %s -- %s\"}", txUUID, err))
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	encoder.Encode(&requestStatus{
+		UUID:   txUUID,
+		Status: status.Phase.String(),
+		SeqNo:  status.SeqNo,
+	})
+}
+
 // NotFound returns a custom landing page when a given hyperledger end point
 // had not been defined.
 func (s *ServerOpenchainREST) NotFound(rw web.ResponseWriter, r *web.Request) {
@@ -1738,8 +1815,10 @@ func StartOpenchainRESTServer(server *ServerOpenchain, devops *core.Devops) {
 	router.Post("/chaincode", (*ServerOpenchainREST).ProcessChaincode)
 
 	router.Get("/transactions/:uuid", (*ServerOpenchainREST).GetTransactionByUUID)
+	router.Get("/transactions/:uuid/status", (*ServerOpenchainREST).GetRequestStatus)
 
 	router.Get("/network/peers", (*ServerOpenchainREST).GetPeers)
+	router.Get("/network/consensus", (*ServerOpenchainREST).GetConsensusStatus)
 
 	// Add not found page
 	router.NotFound((*ServerOpenchainREST).NotFound)
@@ -0,0 +1,113 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+)
+
+// Leaves and inner nodes are hashed with distinct prefixes, so that a leaf can never be passed off as an inner node
+var (
+	merkleLeafPrefix = []byte{0}
+	merkleNodePrefix = []byte{1}
+)
+
+// MerkleTree is a binary hash tree over a list of data items. A node without a sibling
+// is carried up to the next level unchanged
+type MerkleTree struct {
+	levels [][][]byte // levels[0] holds the leaf hashes, the last level holds the root
+}
+
+func merkleLeafHash(data []byte) []byte {
+	return ComputeCryptoHash(append(append([]byte{}, merkleLeafPrefix...), data...))
+}
+
+func merkleNodeHash(left, right []byte) []byte {
+	buf := make([]byte, 0, len(merkleNodePrefix)+len(left)+len(right))
+	buf = append(buf, merkleNodePrefix...)
+	buf = append(buf, left...)
+	return ComputeCryptoHash(append(buf, right...))
+}
+
+// NewMerkleTree computes the Merkle tree over data
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	level := make([][]byte, len(data))
+	for i, d := range data {
+		level[i] = merkleLeafHash(d)
+	}
+
+	tree := &MerkleTree{levels: [][][]byte{level}}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleNodeHash(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+	return tree
+}
+
+// Root returns the root hash of the tree, the root of an empty tree is the hash of no data
+func (tree *MerkleTree) Root() []byte {
+	top := tree.levels[len(tree.levels)-1]
+	if 0 == len(top) {
+		return ComputeCryptoHash(nil)
+	}
+	return top[0]
+}
+
+// Proof returns the sibling hashes on the path from the leaf at index to the root
+func (tree *MerkleTree) Proof(index uint64) [][]byte {
+	var proof [][]byte
+	for _, level := range tree.levels[:len(tree.levels)-1] {
+		if sibling := index ^ 1; sibling < uint64(len(level)) {
+			proof = append(proof, level[sibling])
+		}
+		index /= 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof checks that data is the item at index of a tree over count items with the given root
+func VerifyMerkleProof(data []byte, index, count uint64, proof [][]byte, root []byte) bool {
+	if index >= count {
+		return false
+	}
+
+	hash := merkleLeafHash(data)
+	for width := count; width > 1; width = (width + 1) / 2 {
+		if sibling := index ^ 1; sibling < width {
+			if 0 == len(proof) {
+				return false
+			}
+			if 0 == index%2 {
+				hash = merkleNodeHash(hash, proof[0])
+			} else {
+				hash = merkleNodeHash(proof[0], hash)
+			}
+			proof = proof[1:]
+		}
+		index /= 2
+	}
+
+	return 0 == len(proof) && bytes.Equal(hash, root)
+}
@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleProofs(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		data := make([][]byte, count)
+		for i := range data {
+			data[i] = []byte(fmt.Sprintf("item %d", i))
+		}
+		tree := NewMerkleTree(data)
+		root := tree.Root()
+
+		for i, d := range data {
+			proof := tree.Proof(uint64(i))
+			if !VerifyMerkleProof(d, uint64(i), uint64(count), proof, root) {
+				t.Fatalf("Proof for item %d of %d did not verify", i, count)
+			}
+			if VerifyMerkleProof([]byte("forged"), uint64(i), uint64(count), proof, root) {
+				t.Fatalf("Proof for item %d of %d verified forged data", i, count)
+			}
+			if count > 1 && VerifyMerkleProof(d, uint64((i+1)%count), uint64(count), proof, root) {
+				t.Fatalf("Proof for item %d of %d verified at another index", i, count)
+			}
+		}
+	}
+}
+
+func TestMerkleRootChanges(t *testing.T) {
+	a := NewMerkleTree([][]byte{[]byte("a"), []byte("b"), []byte("c")}).Root()
+	b := NewMerkleTree([][]byte{[]byte("a"), []byte("c"), []byte("b")}).Root()
+	if bytes.Equal(a, b) {
+		t.Fatalf("Expected the root to depend on the order of the items")
+	}
+	if !bytes.Equal(NewMerkleTree(nil).Root(), ComputeCryptoHash(nil)) {
+		t.Fatalf("Expected the root of an empty tree to be the hash of no data")
+	}
+}
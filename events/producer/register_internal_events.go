@@ -29,6 +29,7 @@ import (
 const (
 	RegisterType = "register"
 	BlockType    = "block"
+	GenericType  = "generic"
 )
 
 func getMessageType(e *pb.Event) string {
@@ -38,7 +39,7 @@ func getMessageType(e *pb.Event) string {
 	case *pb.Event_Block:
 		return "block"
 	case *pb.Event_Generic:
-		return "generic"
+		return GenericType
 	default:
 		return ""
 	}
@@ -48,4 +49,5 @@ func getMessageType(e *pb.Event) string {
 func addInternalEventTypes() {
 	AddEventType(BlockType)
 	AddEventType(RegisterType)
+	AddEventType(GenericType)
 }
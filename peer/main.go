@@ -24,6 +24,7 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -47,12 +48,15 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 
+	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/consensus/helper"
 	"github.com/hyperledger/fabric/core"
 	"github.com/hyperledger/fabric/core/chaincode"
 	"github.com/hyperledger/fabric/core/comm"
 	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/health"
 	"github.com/hyperledger/fabric/core/ledger/genesis"
+	"github.com/hyperledger/fabric/core/metrics"
 	"github.com/hyperledger/fabric/core/peer"
 	"github.com/hyperledger/fabric/core/rest"
 	"github.com/hyperledger/fabric/core/system_chaincode"
@@ -67,6 +71,7 @@ const fabric = "hyperledger"
 const nodeFuncName = "node"
 const networkFuncName = "network"
 const chainFuncName = "chaincode"
+const consensusFuncName = "consensus"
 const cmdRoot = "core"
 const undefinedParamValue = ""
 
@@ -165,6 +170,51 @@ var networkListCmd = &cobra.Command{
 	},
 }
 
+var consensusCmd = &cobra.Command{
+	Use:   consensusFuncName,
+	Short: fmt.Sprintf("%s specific commands.", consensusFuncName),
+	Long:  fmt.Sprintf("%s specific commands.", consensusFuncName),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		core.LoggingInit(consensusFuncName)
+	},
+}
+
+var consensusStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Returns status of the consenter.",
+	Long:  `Returns the view, the primary, the last executed sequence number and the watermarks of the consenter of the running validator.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return consensusStatus()
+	},
+}
+
+var consensusViewChangeCmd = &cobra.Command{
+	Use:   "viewchange",
+	Short: "Requests a view change.",
+	Long:  `Has the consenter of the running validator vote to abandon its current view, e.g. to take the current primary down for maintenance. The view changes once f+1 validators voted for it.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return consensusViewChange()
+	},
+}
+
+var consensusResetEpochCmd = &cobra.Command{
+	Use:   "resetepoch",
+	Short: "Ends the sequence number epoch.",
+	Long:  `Has the consenter of the running validator order a request ending its sequence number epoch at the next checkpoint. The validators restart numbering at 0 in the next epoch once that checkpoint is stable.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return consensusResetEpoch()
+	},
+}
+
+var consensusDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Dumps the state of the consenter.",
+	Long:  `Has the running validator write the soft state of its consenter, e.g. its message log, view changes and checkpoints, as JSON to a file below peer.validator.consensus.dumps, for the analysis of a wedged replica. Returns the path of the file on the validator. Sending SIGUSR1 to the validator writes the same dump.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return consensusDump()
+	},
+}
+
 // login related variables.
 var (
 	loginPW string
@@ -304,6 +354,13 @@ func main() {
 
 	mainCmd.AddCommand(chaincodeCmd)
 
+	consensusCmd.AddCommand(consensusStatusCmd)
+	consensusCmd.AddCommand(consensusViewChangeCmd)
+	consensusCmd.AddCommand(consensusResetEpochCmd)
+	consensusCmd.AddCommand(consensusDumpCmd)
+
+	mainCmd.AddCommand(consensusCmd)
+
 	runtime.GOMAXPROCS(viper.GetInt("peer.gomaxprocs"))
 
 	// Init the crypto layer
@@ -444,7 +501,14 @@ func serve(args []string) error {
 	}
 
 	var opts []grpc.ServerOption
-	if comm.TLSEnabled() {
+	consensusTLS, err := peer.GetConsensusTLS()
+	if err != nil {
+		grpclog.Fatalf("Failed to load consensus TLS certificates %v", err)
+	}
+	if consensusTLS != nil {
+		logger.Info("Validators authenticated with consensus TLS certificates")
+		opts = []grpc.ServerOption{grpc.Creds(consensusTLS.ServerCredentials())}
+	} else if comm.TLSEnabled() {
 		creds, err := credentials.NewServerTLSFromFile(viper.GetString("peer.tls.cert.file"), viper.GetString("peer.tls.key.file"))
 		if err != nil {
 			grpclog.Fatalf("Failed to generate credentials %v", err)
@@ -507,6 +571,30 @@ func serve(args []string) error {
 		return err
 	}
 
+	if peer.ValidatorEnabled() {
+		engine, _ := helper.GetEngine(peerServer)
+		if inspector, ok := engine.(consensus.Inspector); ok {
+			serverOpenchain.SetConsensusInspector(inspector)
+		}
+		if querier, ok := engine.(consensus.StatusQuerier); ok {
+			serverOpenchain.SetConsensusStatusQuerier(querier)
+		}
+
+		// Register the ConsensusAdmin server
+		pb.RegisterConsensusAdminServer(grpcServer, helper.NewConsensusAdminServer(engine))
+
+		// Dump the consensus state on SIGUSR1
+		dumpSignals := make(chan os.Signal, 1)
+		signal.Notify(dumpSignals, syscall.SIGUSR1)
+		go func() {
+			for range dumpSignals {
+				if _, err := helper.DumpConsensusState(engine); err != nil {
+					logger.Error("Error dumping the consensus state: %s", err)
+				}
+			}
+		}()
+	}
+
 	pb.RegisterOpenchainServer(grpcServer, serverOpenchain)
 
 	// Create and register the REST service if configured
@@ -552,6 +640,19 @@ func serve(args []string) error {
 		}()
 	}
 
+	if viper.GetBool("peer.metrics.enabled") {
+		go func() {
+			metricsListenAddress := viper.GetString("peer.metrics.listenAddress")
+			logger.Info(fmt.Sprintf("Starting metrics server with listenAddress = %s", metricsListenAddress))
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			mux.Handle("/health", health.Handler())
+			if metricsErr := http.ListenAndServe(metricsListenAddress, mux); metricsErr != nil {
+				logger.Error(fmt.Sprintf("Error starting metrics server: %s", metricsErr))
+			}
+		}()
+	}
+
 	// Block until grpc server exits
 	return <-serve
 }
@@ -1009,6 +1110,80 @@ func networkList() (err error) {
 	return nil
 }
 
+func consensusStatus() (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
+		return
+	}
+	adminClient := pb.NewConsensusAdminClient(clientConn)
+	status, err := adminClient.GetConsensusStatus(context.Background(), &google_protobuf.Empty{})
+	if err != nil {
+		err = fmt.Errorf("Error trying to get consensus status: %s", err)
+		return
+	}
+
+	// Print every field, as the zero values of proto3 are dropped by
+	// its text and JSON encodings
+	fmt.Printf("replica:              %d\n", status.ReplicaID)
+	fmt.Printf("view:                 %d (active: %t)\n", status.View, status.ActiveView)
+	fmt.Printf("epoch:                %d\n", status.Epoch)
+	fmt.Printf("primary:              %d\n", status.Primary)
+	fmt.Printf("last executed:        %d\n", status.LastExecuted)
+	fmt.Printf("watermarks:           %d - %d\n", status.LowWatermark, status.HighWatermark)
+	fmt.Printf("checkpoint period:    %d\n", status.CheckpointPeriod)
+	fmt.Printf("outstanding requests: %d\n", status.OutstandingRequests)
+	fmt.Printf("view change votes:    %d\n", status.ViewChanges)
+	fmt.Printf("synced:               %t\n", status.Synced)
+	return nil
+}
+
+func consensusViewChange() (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
+		return
+	}
+	adminClient := pb.NewConsensusAdminClient(clientConn)
+	if _, err = adminClient.RequestViewChange(context.Background(), &google_protobuf.Empty{}); err != nil {
+		err = fmt.Errorf("Error trying to request a view change: %s", err)
+		return
+	}
+	fmt.Println("Requested a view change, the view changes once f+1 validators requested it")
+	return nil
+}
+
+func consensusResetEpoch() (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
+		return
+	}
+	adminClient := pb.NewConsensusAdminClient(clientConn)
+	if _, err = adminClient.ResetEpoch(context.Background(), &google_protobuf.Empty{}); err != nil {
+		err = fmt.Errorf("Error trying to end the sequence number epoch: %s", err)
+		return
+	}
+	fmt.Println("Requested the end of the sequence number epoch, it ends at the checkpoint following the request once the request is ordered")
+	return nil
+}
+
+func consensusDump() (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
+		return
+	}
+	adminClient := pb.NewConsensusAdminClient(clientConn)
+	dump, err := adminClient.DumpState(context.Background(), &google_protobuf.Empty{})
+	if err != nil {
+		err = fmt.Errorf("Error trying to dump the consensus state: %s", err)
+		return
+	}
+	fmt.Println(dump.Path)
+	return nil
+}
+
 func writePid(fileName string, pid int) error {
 	err := os.MkdirAll(filepath.Dir(fileName), 0755)
 	if err != nil {
@@ -8,6 +8,7 @@ Package protos is a generated protocol buffer package.
 It is generated from these files:
 	api.proto
 	chaincode.proto
+	consensus_admin.proto
 	devops.proto
 	events.proto
 	fabric.proto
@@ -29,6 +30,8 @@ It has these top-level messages:
 	RangeQueryStateClose
 	RangeQueryStateKeyValue
 	RangeQueryStateResponse
+	ConsensusStatus
+	ConsensusDump
 	Secret
 	BuildResult
 	Interest
@@ -46,8 +49,10 @@ It has these top-level messages:
 	PeerEndpoint
 	PeersMessage
 	HelloMessage
+	EndpointUpdate
 	Message
 	Response
+	TransactionReceipt
 	BlockState
 	SyncBlockRange
 	SyncBlocks
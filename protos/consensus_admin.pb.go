@@ -0,0 +1,206 @@
+// Code generated by protoc-gen-go.
+// source: consensus_admin.proto
+// DO NOT EDIT!
+
+package protos
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import google_protobuf1 "google/protobuf"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type ConsensusStatus struct {
+	ReplicaID           uint64 `protobuf:"varint,1,opt,name=replicaID" json:"replicaID,omitempty"`
+	View                uint64 `protobuf:"varint,2,opt,name=view" json:"view,omitempty"`
+	ActiveView          bool   `protobuf:"varint,3,opt,name=activeView" json:"activeView,omitempty"`
+	Primary             uint64 `protobuf:"varint,4,opt,name=primary" json:"primary,omitempty"`
+	LastExecuted        uint64 `protobuf:"varint,5,opt,name=lastExecuted" json:"lastExecuted,omitempty"`
+	LowWatermark        uint64 `protobuf:"varint,6,opt,name=lowWatermark" json:"lowWatermark,omitempty"`
+	HighWatermark       uint64 `protobuf:"varint,7,opt,name=highWatermark" json:"highWatermark,omitempty"`
+	CheckpointPeriod    uint64 `protobuf:"varint,8,opt,name=checkpointPeriod" json:"checkpointPeriod,omitempty"`
+	OutstandingRequests uint64 `protobuf:"varint,9,opt,name=outstandingRequests" json:"outstandingRequests,omitempty"`
+	ViewChanges         uint64 `protobuf:"varint,10,opt,name=viewChanges" json:"viewChanges,omitempty"`
+	Synced              bool   `protobuf:"varint,11,opt,name=synced" json:"synced,omitempty"`
+	Epoch               uint64 `protobuf:"varint,12,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *ConsensusStatus) Reset()         { *m = ConsensusStatus{} }
+func (m *ConsensusStatus) String() string { return proto.CompactTextString(m) }
+func (*ConsensusStatus) ProtoMessage()    {}
+
+type ConsensusDump struct {
+	// Path of the dump on the validator.
+	Path string `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+}
+
+func (m *ConsensusDump) Reset()         { *m = ConsensusDump{} }
+func (m *ConsensusDump) String() string { return proto.CompactTextString(m) }
+func (*ConsensusDump) ProtoMessage()    {}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// Client API for ConsensusAdmin service
+
+type ConsensusAdminClient interface {
+	// Return the state of the consenter.
+	GetConsensusStatus(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ConsensusStatus, error)
+	// Vote to abandon the current view, e.g. to take its primary down for
+	// maintenance. The view changes once f+1 replicas voted for it.
+	RequestViewChange(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*google_protobuf1.Empty, error)
+	// Write the soft state of the consenter to a file on the validator, for
+	// the analysis of a wedged replica.
+	DumpState(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ConsensusDump, error)
+	// End the sequence number epoch at the next checkpoint, once a request
+	// asking for it is ordered. The numbering restarts at 0 once that
+	// checkpoint is stable.
+	ResetEpoch(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*google_protobuf1.Empty, error)
+}
+
+type consensusAdminClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewConsensusAdminClient(cc *grpc.ClientConn) ConsensusAdminClient {
+	return &consensusAdminClient{cc}
+}
+
+func (c *consensusAdminClient) GetConsensusStatus(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ConsensusStatus, error) {
+	out := new(ConsensusStatus)
+	err := grpc.Invoke(ctx, "/protos.ConsensusAdmin/GetConsensusStatus", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *consensusAdminClient) RequestViewChange(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*google_protobuf1.Empty, error) {
+	out := new(google_protobuf1.Empty)
+	err := grpc.Invoke(ctx, "/protos.ConsensusAdmin/RequestViewChange", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *consensusAdminClient) DumpState(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ConsensusDump, error) {
+	out := new(ConsensusDump)
+	err := grpc.Invoke(ctx, "/protos.ConsensusAdmin/DumpState", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *consensusAdminClient) ResetEpoch(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*google_protobuf1.Empty, error) {
+	out := new(google_protobuf1.Empty)
+	err := grpc.Invoke(ctx, "/protos.ConsensusAdmin/ResetEpoch", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for ConsensusAdmin service
+
+type ConsensusAdminServer interface {
+	// Return the state of the consenter.
+	GetConsensusStatus(context.Context, *google_protobuf1.Empty) (*ConsensusStatus, error)
+	// Vote to abandon the current view, e.g. to take its primary down for
+	// maintenance. The view changes once f+1 replicas voted for it.
+	RequestViewChange(context.Context, *google_protobuf1.Empty) (*google_protobuf1.Empty, error)
+	// Write the soft state of the consenter to a file on the validator, for
+	// the analysis of a wedged replica.
+	DumpState(context.Context, *google_protobuf1.Empty) (*ConsensusDump, error)
+	// End the sequence number epoch at the next checkpoint, once a request
+	// asking for it is ordered. The numbering restarts at 0 once that
+	// checkpoint is stable.
+	ResetEpoch(context.Context, *google_protobuf1.Empty) (*google_protobuf1.Empty, error)
+}
+
+func RegisterConsensusAdminServer(s *grpc.Server, srv ConsensusAdminServer) {
+	s.RegisterService(&_ConsensusAdmin_serviceDesc, srv)
+}
+
+func _ConsensusAdmin_GetConsensusStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ConsensusAdminServer).GetConsensusStatus(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ConsensusAdmin_RequestViewChange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ConsensusAdminServer).RequestViewChange(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ConsensusAdmin_DumpState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ConsensusAdminServer).DumpState(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _ConsensusAdmin_ResetEpoch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(ConsensusAdminServer).ResetEpoch(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+var _ConsensusAdmin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "protos.ConsensusAdmin",
+	HandlerType: (*ConsensusAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetConsensusStatus",
+			Handler:    _ConsensusAdmin_GetConsensusStatus_Handler,
+		},
+		{
+			MethodName: "RequestViewChange",
+			Handler:    _ConsensusAdmin_RequestViewChange_Handler,
+		},
+		{
+			MethodName: "DumpState",
+			Handler:    _ConsensusAdmin_DumpState_Handler,
+		},
+		{
+			MethodName: "ResetEpoch",
+			Handler:    _ConsensusAdmin_ResetEpoch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
@@ -31,6 +31,9 @@ const (
 	Transaction_CHAINCODE_QUERY Transaction_Type = 3
 	// terminate a chaincode; not implemented yet
 	Transaction_CHAINCODE_TERMINATE Transaction_Type = 4
+	// a transaction sealed to the validators in the payload, which is
+	// only opened when it executes
+	Transaction_CHAIN_SEALED Transaction_Type = 6
 )
 
 var Transaction_Type_name = map[int32]string{
@@ -39,6 +42,7 @@ var Transaction_Type_name = map[int32]string{
 	2: "CHAINCODE_INVOKE",
 	3: "CHAINCODE_QUERY",
 	4: "CHAINCODE_TERMINATE",
+	6: "CHAIN_SEALED",
 }
 var Transaction_Type_value = map[string]int32{
 	"UNDEFINED":           0,
@@ -46,6 +50,7 @@ var Transaction_Type_value = map[string]int32{
 	"CHAINCODE_INVOKE":    2,
 	"CHAINCODE_QUERY":     3,
 	"CHAINCODE_TERMINATE": 4,
+	"CHAIN_SEALED":        6,
 }
 
 func (x Transaction_Type) String() string {
@@ -84,6 +89,7 @@ const (
 	Message_DISC_GET_PEERS          Message_Type = 3
 	Message_DISC_PEERS              Message_Type = 4
 	Message_DISC_NEWMSG             Message_Type = 5
+	Message_DISC_ENDPOINT_UPDATE    Message_Type = 7
 	Message_CHAIN_TRANSACTION       Message_Type = 6
 	Message_SYNC_GET_BLOCKS         Message_Type = 11
 	Message_SYNC_BLOCKS             Message_Type = 12
@@ -103,6 +109,7 @@ var Message_Type_name = map[int32]string{
 	3:  "DISC_GET_PEERS",
 	4:  "DISC_PEERS",
 	5:  "DISC_NEWMSG",
+	7:  "DISC_ENDPOINT_UPDATE",
 	6:  "CHAIN_TRANSACTION",
 	11: "SYNC_GET_BLOCKS",
 	12: "SYNC_BLOCKS",
@@ -121,6 +128,7 @@ var Message_Type_value = map[string]int32{
 	"DISC_GET_PEERS":          3,
 	"DISC_PEERS":              4,
 	"DISC_NEWMSG":             5,
+	"DISC_ENDPOINT_UPDATE":    7,
 	"CHAIN_TRANSACTION":       6,
 	"SYNC_GET_BLOCKS":         11,
 	"SYNC_BLOCKS":             12,
@@ -160,6 +168,38 @@ func (x Response_StatusCode) String() string {
 	return proto.EnumName(Response_StatusCode_name, int32(x))
 }
 
+type TransactionReceipt_Status int32
+
+const (
+	TransactionReceipt_UNDEFINED TransactionReceipt_Status = 0
+	TransactionReceipt_ACCEPTED  TransactionReceipt_Status = 1
+	TransactionReceipt_REJECTED  TransactionReceipt_Status = 2
+	TransactionReceipt_COMMITTED TransactionReceipt_Status = 3
+	TransactionReceipt_FAILED    TransactionReceipt_Status = 4
+	TransactionReceipt_TIMEOUT   TransactionReceipt_Status = 5
+)
+
+var TransactionReceipt_Status_name = map[int32]string{
+	0: "UNDEFINED",
+	1: "ACCEPTED",
+	2: "REJECTED",
+	3: "COMMITTED",
+	4: "FAILED",
+	5: "TIMEOUT",
+}
+var TransactionReceipt_Status_value = map[string]int32{
+	"UNDEFINED": 0,
+	"ACCEPTED":  1,
+	"REJECTED":  2,
+	"COMMITTED": 3,
+	"FAILED":    4,
+	"TIMEOUT":   5,
+}
+
+func (x TransactionReceipt_Status) String() string {
+	return proto.EnumName(TransactionReceipt_Status_name, int32(x))
+}
+
 // Transaction defines a function call to a contract.
 // `args` is an array of type string so that the chaincode writer can choose
 // whatever format they wish for the arguments for their chaincode.
@@ -179,6 +219,12 @@ type Transaction struct {
 	ToValidators                   []byte                     `protobuf:"bytes,10,opt,name=toValidators,proto3" json:"toValidators,omitempty"`
 	Cert                           []byte                     `protobuf:"bytes,11,opt,name=cert,proto3" json:"cert,omitempty"`
 	Signature                      []byte                     `protobuf:"bytes,12,opt,name=signature,proto3" json:"signature,omitempty"`
+	// the validators drop the transaction if it was not ordered by then,
+	// unset if it never expires
+	Expiry *google_protobuf.Timestamp `protobuf:"bytes,14,opt,name=expiry" json:"expiry,omitempty"`
+	// chosen by the client to trace the business transaction this transaction
+	// belongs to, it is carried into the logs and events of the validators
+	CorrelationID string `protobuf:"bytes,15,opt,name=correlationID" json:"correlationID,omitempty"`
 }
 
 func (m *Transaction) Reset()         { *m = Transaction{} }
@@ -192,6 +238,13 @@ func (m *Transaction) GetTimestamp() *google_protobuf.Timestamp {
 	return nil
 }
 
+func (m *Transaction) GetExpiry() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Expiry
+	}
+	return nil
+}
+
 // TransactionBlock carries a batch of transactions.
 type TransactionBlock struct {
 	Transactions []*Transaction `protobuf:"bytes,1,rep,name=transactions" json:"transactions,omitempty"`
@@ -219,6 +272,8 @@ type TransactionResult struct {
 	Result    []byte `protobuf:"bytes,2,opt,name=result,proto3" json:"result,omitempty"`
 	ErrorCode uint32 `protobuf:"varint,3,opt,name=errorCode" json:"errorCode,omitempty"`
 	Error     string `protobuf:"bytes,4,opt,name=error" json:"error,omitempty"`
+	// correlation ID of the transaction
+	CorrelationID string `protobuf:"bytes,5,opt,name=correlationID" json:"correlationID,omitempty"`
 }
 
 func (m *TransactionResult) Reset()         { *m = TransactionResult{} }
@@ -385,11 +440,34 @@ func (m *HelloMessage) GetBlockchainInfo() *BlockchainInfo {
 	return nil
 }
 
+// EndpointUpdate is the payload of Message.DISC_ENDPOINT_UPDATE, which a
+// peer signs and sends to announce the address it is reachable at, after it
+// moved to another address under the same PeerID. Peers relay the updates
+// they accept to the peers they chat with.
+// peerEndpoint - The endpoint of the peer, with its new address.
+// sequence - Grows with every move of the peer, older updates are ignored.
+type EndpointUpdate struct {
+	PeerEndpoint *PeerEndpoint `protobuf:"bytes,1,opt,name=peerEndpoint" json:"peerEndpoint,omitempty"`
+	Sequence     uint64        `protobuf:"varint,2,opt,name=sequence" json:"sequence,omitempty"`
+}
+
+func (m *EndpointUpdate) Reset()         { *m = EndpointUpdate{} }
+func (m *EndpointUpdate) String() string { return proto.CompactTextString(m) }
+func (*EndpointUpdate) ProtoMessage()    {}
+
+func (m *EndpointUpdate) GetPeerEndpoint() *PeerEndpoint {
+	if m != nil {
+		return m.PeerEndpoint
+	}
+	return nil
+}
+
 type Message struct {
 	Type      Message_Type               `protobuf:"varint,1,opt,name=type,enum=protos.Message_Type" json:"type,omitempty"`
 	Timestamp *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=timestamp" json:"timestamp,omitempty"`
 	Payload   []byte                     `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
 	Signature []byte                     `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	Version   uint32                     `protobuf:"varint,5,opt,name=version" json:"version,omitempty"`
 }
 
 func (m *Message) Reset()         { *m = Message{} }
@@ -412,6 +490,47 @@ func (m *Response) Reset()         { *m = Response{} }
 func (m *Response) String() string { return proto.CompactTextString(m) }
 func (*Response) ProtoMessage()    {}
 
+// TransactionReceipt reports the progress of a transaction submitted
+// through Peer.ProcessTransactionStream. Every transaction first receives
+// an ACCEPTED or REJECTED receipt. Accepted transactions which modify the
+// ledger later receive a COMMITTED or FAILED receipt once they are part of
+// a block on the peer, or TIMEOUT if this does not happen in time.
+// uuid - The unique identifier of the transaction.
+// msg - The response of the peer, or the error of a rejected or failed transaction.
+// blockNumber - The block containing the transaction once it is committed or failed.
+type TransactionReceipt struct {
+	Uuid        string                    `protobuf:"bytes,1,opt,name=uuid" json:"uuid,omitempty"`
+	Status      TransactionReceipt_Status `protobuf:"varint,2,opt,name=status,enum=protos.TransactionReceipt_Status" json:"status,omitempty"`
+	Msg         []byte                    `protobuf:"bytes,3,opt,name=msg,proto3" json:"msg,omitempty"`
+	BlockNumber uint64                    `protobuf:"varint,4,opt,name=blockNumber" json:"blockNumber,omitempty"`
+}
+
+func (m *TransactionReceipt) Reset()         { *m = TransactionReceipt{} }
+func (m *TransactionReceipt) String() string { return proto.CompactTextString(m) }
+func (*TransactionReceipt) ProtoMessage()    {}
+
+// ConsensusFrame carries the CONSENSUS messages of two validating peers
+// over Peer.ConsensusStream. Each side numbers the frames it sends from 1 on,
+// the first frame carrying its signed DISC_HELLO message, and sends keepalive
+// frames, which are not numbered, while it has nothing else to send.
+// seqNo - The position of the frame on the stream, 0 for keepalives.
+// message - The message the frame carries, unset for keepalives.
+type ConsensusFrame struct {
+	SeqNo   uint64   `protobuf:"varint,1,opt,name=seqNo" json:"seqNo,omitempty"`
+	Message *Message `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *ConsensusFrame) Reset()         { *m = ConsensusFrame{} }
+func (m *ConsensusFrame) String() string { return proto.CompactTextString(m) }
+func (*ConsensusFrame) ProtoMessage()    {}
+
+func (m *ConsensusFrame) GetMessage() *Message {
+	if m != nil {
+		return m.Message
+	}
+	return nil
+}
+
 // BlockState is the payload of Message.SYNC_BLOCK_ADDED. When a VP
 // commits a new block to the ledger, it will notify its connected NVPs of the
 // block and the delta state. The NVP may call the ledger APIs to apply the
@@ -474,8 +593,12 @@ func (m *SyncBlocks) GetBlocks() []*Block {
 }
 
 // SyncSnapshotRequest Payload for the penchainMessage.SYNC_GET_SNAPSHOT message.
+// If chunkSize is set, the snapshot is sent as Merkle-proved chunks of up to
+// chunkSize deltas each, starting with the chunk at index startChunk.
 type SyncStateSnapshotRequest struct {
 	CorrelationId uint64 `protobuf:"varint,1,opt,name=correlationId" json:"correlationId,omitempty"`
+	ChunkSize     uint64 `protobuf:"varint,2,opt,name=chunkSize" json:"chunkSize,omitempty"`
+	StartChunk    uint64 `protobuf:"varint,3,opt,name=startChunk" json:"startChunk,omitempty"`
 }
 
 func (m *SyncStateSnapshotRequest) Reset()         { *m = SyncStateSnapshotRequest{} }
@@ -486,11 +609,17 @@ func (*SyncStateSnapshotRequest) ProtoMessage()    {}
 // to penchainMessage.SYNC_GET_SNAPSHOT. It contains the snapshot or a chunk of the
 // snapshot on stream, and in which case, the sequence indicate the order
 // starting at 0.  The terminating message will have len(delta) == 0.
+// For a chunked snapshot, delta holds a chunk of deltas, sequence is the index
+// of the chunk, and merkleProof proves it against merkleRoot, the root of the
+// Merkle tree over all chunkCount chunks of the snapshot.
 type SyncStateSnapshot struct {
 	Delta       []byte                    `protobuf:"bytes,1,opt,name=delta,proto3" json:"delta,omitempty"`
 	Sequence    uint64                    `protobuf:"varint,2,opt,name=sequence" json:"sequence,omitempty"`
 	BlockNumber uint64                    `protobuf:"varint,3,opt,name=blockNumber" json:"blockNumber,omitempty"`
 	Request     *SyncStateSnapshotRequest `protobuf:"bytes,4,opt,name=request" json:"request,omitempty"`
+	ChunkCount  uint64                    `protobuf:"varint,5,opt,name=chunkCount" json:"chunkCount,omitempty"`
+	MerkleRoot  []byte                    `protobuf:"bytes,6,opt,name=merkleRoot,proto3" json:"merkleRoot,omitempty"`
+	MerkleProof [][]byte                  `protobuf:"bytes,7,rep,name=merkleProof,proto3" json:"merkleProof,omitempty"`
 }
 
 func (m *SyncStateSnapshot) Reset()         { *m = SyncStateSnapshot{} }
@@ -546,6 +675,7 @@ func init() {
 	proto.RegisterEnum("protos.PeerEndpoint_Type", PeerEndpoint_Type_name, PeerEndpoint_Type_value)
 	proto.RegisterEnum("protos.Message_Type", Message_Type_name, Message_Type_value)
 	proto.RegisterEnum("protos.Response_StatusCode", Response_StatusCode_name, Response_StatusCode_value)
+	proto.RegisterEnum("protos.TransactionReceipt_Status", TransactionReceipt_Status_name, TransactionReceipt_Status_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -560,6 +690,12 @@ type PeerClient interface {
 	Chat(ctx context.Context, opts ...grpc.CallOption) (Peer_ChatClient, error)
 	// Process a transaction from a remote source.
 	ProcessTransaction(ctx context.Context, in *Transaction, opts ...grpc.CallOption) (*Response, error)
+	// Process a stream of transactions from a remote source, replying with
+	// TransactionReceipts as each transaction progresses.
+	ProcessTransactionStream(ctx context.Context, opts ...grpc.CallOption) (Peer_ProcessTransactionStreamClient, error)
+	// Exchange the consensus messages of two validating peers over a stream
+	// dedicated to them, which keeps their order.
+	ConsensusStream(ctx context.Context, opts ...grpc.CallOption) (Peer_ConsensusStreamClient, error)
 }
 
 type peerClient struct {
@@ -610,6 +746,68 @@ func (c *peerClient) ProcessTransaction(ctx context.Context, in *Transaction, op
 	return out, nil
 }
 
+func (c *peerClient) ProcessTransactionStream(ctx context.Context, opts ...grpc.CallOption) (Peer_ProcessTransactionStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Peer_serviceDesc.Streams[1], c.cc, "/protos.Peer/ProcessTransactionStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &peerProcessTransactionStreamClient{stream}
+	return x, nil
+}
+
+type Peer_ProcessTransactionStreamClient interface {
+	Send(*Transaction) error
+	Recv() (*TransactionReceipt, error)
+	grpc.ClientStream
+}
+
+type peerProcessTransactionStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *peerProcessTransactionStreamClient) Send(m *Transaction) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *peerProcessTransactionStreamClient) Recv() (*TransactionReceipt, error) {
+	m := new(TransactionReceipt)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *peerClient) ConsensusStream(ctx context.Context, opts ...grpc.CallOption) (Peer_ConsensusStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Peer_serviceDesc.Streams[2], c.cc, "/protos.Peer/ConsensusStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &peerConsensusStreamClient{stream}
+	return x, nil
+}
+
+type Peer_ConsensusStreamClient interface {
+	Send(*ConsensusFrame) error
+	Recv() (*ConsensusFrame, error)
+	grpc.ClientStream
+}
+
+type peerConsensusStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *peerConsensusStreamClient) Send(m *ConsensusFrame) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *peerConsensusStreamClient) Recv() (*ConsensusFrame, error) {
+	m := new(ConsensusFrame)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for Peer service
 
 type PeerServer interface {
@@ -618,6 +816,12 @@ type PeerServer interface {
 	Chat(Peer_ChatServer) error
 	// Process a transaction from a remote source.
 	ProcessTransaction(context.Context, *Transaction) (*Response, error)
+	// Process a stream of transactions from a remote source, replying with
+	// TransactionReceipts as each transaction progresses.
+	ProcessTransactionStream(Peer_ProcessTransactionStreamServer) error
+	// Exchange the consensus messages of two validating peers over a stream
+	// dedicated to them, which keeps their order.
+	ConsensusStream(Peer_ConsensusStreamServer) error
 }
 
 func RegisterPeerServer(s *grpc.Server, srv PeerServer) {
@@ -662,6 +866,58 @@ func _Peer_ProcessTransaction_Handler(srv interface{}, ctx context.Context, dec
 	return out, nil
 }
 
+func _Peer_ProcessTransactionStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PeerServer).ProcessTransactionStream(&peerProcessTransactionStreamServer{stream})
+}
+
+type Peer_ProcessTransactionStreamServer interface {
+	Send(*TransactionReceipt) error
+	Recv() (*Transaction, error)
+	grpc.ServerStream
+}
+
+type peerProcessTransactionStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *peerProcessTransactionStreamServer) Send(m *TransactionReceipt) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *peerProcessTransactionStreamServer) Recv() (*Transaction, error) {
+	m := new(Transaction)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Peer_ConsensusStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PeerServer).ConsensusStream(&peerConsensusStreamServer{stream})
+}
+
+type Peer_ConsensusStreamServer interface {
+	Send(*ConsensusFrame) error
+	Recv() (*ConsensusFrame, error)
+	grpc.ServerStream
+}
+
+type peerConsensusStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *peerConsensusStreamServer) Send(m *ConsensusFrame) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *peerConsensusStreamServer) Recv() (*ConsensusFrame, error) {
+	m := new(ConsensusFrame)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _Peer_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.Peer",
 	HandlerType: (*PeerServer)(nil),
@@ -678,5 +934,17 @@ var _Peer_serviceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "ProcessTransactionStream",
+			Handler:       _Peer_ProcessTransactionStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ConsensusStream",
+			Handler:       _Peer_ConsensusStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 }
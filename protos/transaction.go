@@ -95,6 +95,7 @@ func NewChaincodeExecute(chaincodeInvocationSpec *ChaincodeInvocationSpec, uuid
 	transaction.Type = typ
 	transaction.Uuid = uuid
 	transaction.Timestamp = util.CreateUtcTimestamp()
+	transaction.CorrelationID = chaincodeInvocationSpec.CorrelationID
 	cID := chaincodeInvocationSpec.ChaincodeSpec.GetChaincodeID()
 	if cID != nil {
 		data, err := proto.Marshal(cID)
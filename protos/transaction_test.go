@@ -46,3 +46,17 @@ func Test_Transaction_CreateNew(t *testing.T) {
 	}
 
 }
+
+func Test_Transaction_CorrelationID(t *testing.T) {
+	spec := &ChaincodeInvocationSpec{
+		ChaincodeSpec: &ChaincodeSpec{ChaincodeID: &ChaincodeID{Name: "mycc"}},
+		CorrelationID: "order-42",
+	}
+	tx, err := NewChaincodeExecute(spec, "uuid", Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatalf("Error creating transaction: %s", err)
+	}
+	if tx.CorrelationID != "order-42" {
+		t.Errorf("Expected the correlation ID of the invocation to be carried into the transaction, got %q", tx.CorrelationID)
+	}
+}
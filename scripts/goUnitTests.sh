@@ -17,3 +17,18 @@ echo "DONE!"
 
 echo "Running tests..."
 go test -cover -timeout=20m $PKGS
+
+echo "Running tests of optional drivers..."
+go test -cover -tags "bolt leveldb" github.com/hyperledger/fabric/consensus/helper/persist
+go test -cover -tags snappy -run "WireCompression|NetworkCompressed" github.com/hyperledger/fabric/consensus/obcpbft/...
+
+if which softhsm2-util > /dev/null 2>&1; then
+    echo "Running tests of the PKCS#11 signing device against SoftHSM..."
+    export SOFTHSM2_CONF=`mktemp`
+    TOKENS=`mktemp -d`
+    echo "directories.tokendir = $TOKENS" > $SOFTHSM2_CONF
+    softhsm2-util --init-token --free --label consensus --so-pin 1234 --pin 98765432 > /dev/null
+    PKCS11_LIB=`find /usr/lib /usr/local/lib -name libsofthsm2.so | head -n 1` PKCS11_LABEL=consensus PKCS11_PIN=98765432 \
+        go test -cover -tags pkcs11 github.com/hyperledger/fabric/consensus/helper
+    rm -rf $TOKENS $SOFTHSM2_CONF
+fi